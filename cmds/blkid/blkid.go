@@ -0,0 +1,188 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Blkid prints the file system types, labels and UUIDs of block devices.
+//
+// Synopsis:
+//     blkid [-o full|value|device|export] [-s TAG]... [-t TAG=VALUE] [DEVICE]...
+//     blkid -L LABEL
+//     blkid -U UUID
+//
+// Description:
+//     Without DEVICE, all block devices with something to say are printed.
+//     A DEVICE that is not a block device, such as an image file, is probed
+//     anyway. The tags are TYPE, LABEL, UUID, PARTLABEL, PARTUUID and
+//     PARTTYPE.
+//
+//     blkid exits with status 2 if it finds no device.
+//
+// Options:
+//     -L: print the device with file system label LABEL
+//     -U: print the device with file system UUID UUID
+//     -o: output format: full (the default), value, device or export
+//     -s: print only tag TAG
+//     -t: print only devices whose tag TAG is VALUE
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+	"github.com/u-root/u-root/pkg/block"
+)
+
+var (
+	label  = flag.StringP("label", "L", "", "Print the device with this file system label")
+	uuid   = flag.StringP("uuid", "U", "", "Print the device with this file system UUID")
+	output = flag.StringP("output", "o", "full", "Output format: full, value, device or export")
+	tags   = flag.StringSliceP("match-tag", "s", nil, "Print only this tag")
+	token  = flag.StringP("match-token", "t", "", "Print only devices with TAG=VALUE")
+)
+
+// errNotFound makes blkid exit with status 2.
+var errNotFound = fmt.Errorf("no device found")
+
+var tagNames = []string{"LABEL", "UUID", "TYPE", "PARTLABEL", "PARTUUID", "PARTTYPE"}
+
+// tagValues returns the tags of d that are not empty.
+func tagValues(d *block.BlockDev) map[string]string {
+	all := map[string]string{
+		"LABEL":     d.Label,
+		"UUID":      d.UUID,
+		"TYPE":      d.FSType,
+		"PARTLABEL": d.PartLabel,
+		"PARTUUID":  d.PartUUID,
+		"PARTTYPE":  d.PartType,
+	}
+	for k, v := range all {
+		if v == "" {
+			delete(all, k)
+		}
+	}
+	return all
+}
+
+// devices returns the devices called names, probing those that are not
+// block devices.
+func devices(all block.BlockDevs, names []string) block.BlockDevs {
+	if len(names) == 0 {
+		return all
+	}
+	var devs block.BlockDevs
+	for _, name := range names {
+		found := all.Filter(func(d *block.BlockDev) bool {
+			return d.DevPath == name || d.DevPath == filepath.Join("/dev", name)
+		})
+		if len(found) > 0 {
+			devs = append(devs, found...)
+			continue
+		}
+		d := &block.BlockDev{Name: filepath.Base(name), DevPath: name}
+		f, err := os.Open(name)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		if fs, err := block.Probe(f); err == nil {
+			d.FSType, d.Label, d.UUID = fs.Type, fs.Label, fs.UUID
+		}
+		f.Close()
+		devs = append(devs, d)
+	}
+	return devs
+}
+
+func blkid(w io.Writer, all block.BlockDevs, names []string) error {
+	switch {
+	case *label != "":
+		all = all.FilterLabel(*label)
+		*output = "device"
+	case *uuid != "":
+		all = all.FilterFSUUID(*uuid)
+		*output = "device"
+	}
+	devs := devices(all, names)
+	if *token != "" {
+		kv := strings.SplitN(*token, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("-t %q: want TAG=VALUE", *token)
+		}
+		devs = devs.Filter(func(d *block.BlockDev) bool {
+			return strings.EqualFold(tagValues(d)[strings.ToUpper(kv[0])], strings.Trim(kv[1], `"`))
+		})
+	}
+
+	found := false
+	for _, d := range devs {
+		tv := tagValues(d)
+		var names []string
+		for _, t := range tagNames {
+			if _, ok := tv[t]; !ok {
+				continue
+			}
+			if len(*tags) > 0 && !contains(*tags, t) {
+				continue
+			}
+			names = append(names, t)
+		}
+		if len(names) == 0 {
+			continue
+		}
+		found = true
+		switch *output {
+		case "full":
+			fmt.Fprintf(w, "%s:", d.DevPath)
+			for _, t := range names {
+				fmt.Fprintf(w, " %s=%q", t, tv[t])
+			}
+			fmt.Fprintln(w)
+		case "value":
+			for _, t := range names {
+				fmt.Fprintln(w, tv[t])
+			}
+		case "device":
+			fmt.Fprintln(w, d.DevPath)
+		case "export":
+			fmt.Fprintf(w, "DEVNAME=%s\n", d.DevPath)
+			for _, t := range names {
+				fmt.Fprintf(w, "%s=%s\n", t, tv[t])
+			}
+			fmt.Fprintln(w)
+		default:
+			return fmt.Errorf("unknown output format %q", *output)
+		}
+	}
+	if !found {
+		return errNotFound
+	}
+	return nil
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if strings.EqualFold(e, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+	flag.Parse()
+	// Named files can be probed without sysfs.
+	devs, err := block.BlockDevices()
+	if err != nil && flag.NArg() == 0 {
+		log.Fatal(err)
+	}
+	if err := blkid(os.Stdout, devs, flag.Args()); err == errNotFound {
+		os.Exit(2)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/block"
+	"github.com/u-root/u-root/pkg/testutil"
+)
+
+var devs = block.BlockDevs{
+	{Name: "sda", DevPath: "/dev/sda"},
+	{Name: "sda1", DevPath: "/dev/sda1", Parent: "sda", PartNumber: 1, PartUUID: "0d6f7f5a-1a4f-4d2c-9b1f-3b9a0b6d1e2f", PartLabel: "EFI System", FSType: "vfat", Label: "EFI", UUID: "1234-ABCD"},
+	{Name: "sdb1", DevPath: "/dev/sdb1", Parent: "sdb", PartNumber: 1, PartUUID: "deadbeef-01", FSType: "ext4", Label: "root", UUID: "5bd1c8f5-7b1c-4a8e-9f5e-0e3f6a8e4b2d"},
+}
+
+func TestBlkid(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		label  string
+		uuid   string
+		output string
+		tags   []string
+		token  string
+		args   []string
+		want   string
+		err    error
+	}{
+		{
+			name:   "all",
+			output: "full",
+			want: "/dev/sda1: LABEL=\"EFI\" UUID=\"1234-ABCD\" TYPE=\"vfat\" PARTLABEL=\"EFI System\" PARTUUID=\"0d6f7f5a-1a4f-4d2c-9b1f-3b9a0b6d1e2f\"\n" +
+				"/dev/sdb1: LABEL=\"root\" UUID=\"5bd1c8f5-7b1c-4a8e-9f5e-0e3f6a8e4b2d\" TYPE=\"ext4\" PARTUUID=\"deadbeef-01\"\n",
+		},
+		{
+			name:   "device with tags",
+			output: "full",
+			tags:   []string{"TYPE", "uuid"},
+			args:   []string{"sdb1"},
+			want:   "/dev/sdb1: UUID=\"5bd1c8f5-7b1c-4a8e-9f5e-0e3f6a8e4b2d\" TYPE=\"ext4\"\n",
+		},
+		{
+			name:   "value",
+			output: "value",
+			tags:   []string{"UUID"},
+			args:   []string{"/dev/sda1"},
+			want:   "1234-ABCD\n",
+		},
+		{
+			name:   "export",
+			output: "export",
+			tags:   []string{"LABEL", "TYPE"},
+			token:  "TYPE=ext4",
+			want:   "DEVNAME=/dev/sdb1\nLABEL=root\nTYPE=ext4\n\n",
+		},
+		{name: "label", label: "root", output: "full", want: "/dev/sdb1\n"},
+		{name: "uuid", uuid: "1234-abcd", output: "full", want: "/dev/sda1\n"},
+		{name: "no uuid", uuid: "1234", output: "full", err: errNotFound},
+		{name: "nothing to say", output: "full", args: []string{"sda"}, err: errNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			*label, *uuid, *output, *tags, *token = tt.label, tt.uuid, tt.output, tt.tags, tt.token
+			var b bytes.Buffer
+			if err := blkid(&b, devs, tt.args); err != tt.err {
+				t.Fatalf("blkid() = %v, want %v", err, tt.err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("blkid() printed\n%s, want\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+// TestImage probes a file that is no block device.
+func TestImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blkid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	img := make([]byte, 64<<10)
+	sb := img[1024:]
+	binary.LittleEndian.PutUint16(sb[56:], 0xef53)
+	copy(sb[104:], []byte{0x5b, 0xd1, 0xc8, 0xf5, 0x7b, 0x1c, 0x4a, 0x8e, 0x9f, 0x5e, 0x0e, 0x3f, 0x6a, 0x8e, 0x4b, 0x2d})
+	copy(sb[120:], "boot")
+	name := filepath.Join(dir, "ext2.img")
+	if err := ioutil.WriteFile(name, img, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := testutil.Command(t, "-o", "export", name).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "DEVNAME=" + name + "\nLABEL=boot\nUUID=5bd1c8f5-7b1c-4a8e-9f5e-0e3f6a8e4b2d\nTYPE=ext2\n\n"
+	if string(out) != want {
+		t.Errorf("blkid -o export %s printed\n%s, want\n%s", name, out, want)
+	}
+
+	c := testutil.Command(t, filepath.Join(dir, "nothere"))
+	if err := c.Run(); err == nil {
+		t.Errorf("blkid of a missing file: got nil, want exit status 2")
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutil.Run(m, main)
+}
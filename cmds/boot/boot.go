@@ -28,10 +28,8 @@
 package main
 
 import (
-	"encoding/binary"
 	"flag"
 	"fmt"
-	"github.com/u-root/u-root/pkg/kexec"
 	"io"
 	"io/ioutil"
 	"log"
@@ -40,11 +38,10 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
-)
 
-const (
-	bootableMBR     = 0xaa55
-	signatureOffset = 510
+	"github.com/u-root/u-root/pkg/kexec"
+	"github.com/u-root/u-root/pkg/mbr"
+	"github.com/u-root/u-root/pkg/service"
 )
 
 type bootEntry struct {
@@ -64,20 +61,31 @@ var (
 
 // checkForBootableMBR is looking for bootable MBR signature
 // Current support is limited to Hard disk devices and USB devices
-func checkForBootableMBR(path string) error {
-	var sig uint16
+// It returns the device and the devices of the partitions in its MBR.
+func checkForBootableMBR(path string) ([]string, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if err := binary.Read(io.NewSectionReader(f, signatureOffset, 2), binary.LittleEndian, &sig); err != nil {
-		return err
+	defer f.Close()
+	t, err := mbr.Read(f)
+	if t == nil {
+		return nil, fmt.Errorf("%v is not a bootable device: %v", path, err)
 	}
-	if sig != bootableMBR {
-		err := fmt.Errorf("%v is not a bootable device", path)
-		return err
+	if err != nil {
+		log.Printf("%v: %v", path, err)
+	}
+	if t.IsProtective() {
+		// The partitions are in the GPT. You can't just look for numbers
+		// to match. Consider names like mmcblk0, where has parts like
+		// mmcblk0p1. Just glob.
+		return filepath.Glob(path + "*")
+	}
+	parts := []string{path}
+	for _, p := range t.Partitions() {
+		parts = append(parts, mbr.PartitionPath(path, p.Number))
 	}
-	return nil
+	return parts, nil
 }
 
 // getSupportedFilesystem returns all block file system supported by the linuxboot kernel
@@ -418,21 +426,14 @@ func main() {
 	// we want. It works for now but ...
 	var allparts []string
 	for _, d := range blkList {
-		err := checkForBootableMBR(d)
+		parts, err := checkForBootableMBR(d)
 		if err != nil {
 			// Not sure it matters; there can be many bogus entries?
 			log.Printf("MBR for %s failed: %v", d, err)
 			continue
 		}
 		verbose("Bootable device %v found", d)
-		// You can't just look for numbers to match. Consider names like
-		// mmcblk0, where has parts like mmcblk0p1. Just glob.
-		g := d + "*"
-		all, err := filepath.Glob(g)
-		if err != nil {
-			log.Printf("Glob for all partitions of %s failed: %v", g, err)
-		}
-		allparts = append(allparts, all...)
+		allparts = append(allparts, parts...)
 	}
 	uroot, err = ioutil.TempDir("", "u-root-boot")
 	if err != nil {
@@ -465,6 +466,9 @@ func main() {
 		if *dryrun {
 			continue
 		}
+		if err := service.Shutdown(); err != nil {
+			log.Printf("Stopping services: %v", err)
+		}
 		if err := kexec.Reboot(); err != nil {
 			log.Printf("Kexec Reboot %v failed, %v. Sorry", u, err)
 		}
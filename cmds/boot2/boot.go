@@ -9,11 +9,13 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"strconv"
 
 	"github.com/u-root/u-root/pkg/diskboot"
 	"github.com/u-root/u-root/pkg/kexec"
 	"github.com/u-root/u-root/pkg/mount"
+	"github.com/u-root/u-root/pkg/service"
 )
 
 var (
@@ -101,7 +103,14 @@ func getEntry(config *diskboot.Config) (*diskboot.Entry, error) {
 
 func bootEntry(config *diskboot.Config, entry *diskboot.Entry) error {
 	verbose("Booting entry: %v", entry)
-	err := entry.KexecLoad(config.MountPath, *appendCmdline, *dryrun)
+	fsys, release, err := config.EntryFS(entry)
+	if err != nil {
+		return fmt.Errorf("error finding file system %v: %v", entry.FSUUID, err)
+	}
+	err = entry.KexecLoadFS(fsys, *appendCmdline, *dryrun)
+	if rerr := release(); rerr != nil {
+		log.Printf("Error releasing file system %v: %v", entry.FSUUID, rerr)
+	}
 	if err != nil {
 		return fmt.Errorf("wrror doing kexec load: %v", err)
 	}
@@ -110,6 +119,9 @@ func bootEntry(config *diskboot.Config, entry *diskboot.Entry) error {
 		return nil
 	}
 
+	if err := service.Shutdown(); err != nil {
+		log.Printf("Stopping services: %v", err)
+	}
 	err = kexec.Reboot()
 	if err != nil {
 		return fmt.Errorf("error doing kexec reboot: %v", err)
@@ -119,9 +131,15 @@ func bootEntry(config *diskboot.Config, entry *diskboot.Entry) error {
 
 func cleanDevices() {
 	for _, device := range devices {
+		// Devices read with pkg/fs are not mounted.
+		if device.MountPath == "" {
+			continue
+		}
 		if err := mount.Unmount(device.MountPath, true, false); err != nil {
 			log.Printf("Error unmounting device %v: %v", device.DevPath, err)
+			continue
 		}
+		os.Remove(device.MountPath)
 	}
 }
 
@@ -10,6 +10,10 @@
 //
 // Description:
 //	Read a bzImage in, change it, write it out, or print info.
+//	Kernels compressed with gzip, xz, lzma, bzip2, lzo, lz4 and zstd are
+//	supported.
+//	initramfs replaces the initramfs built into the kernel. If the new one
+//	is too big, it is compressed to fit, if the kernel can decompress it.
 package main
 
 import (
@@ -100,6 +104,7 @@ func main() {
 		fmt.Printf("%s", br.Header.Diff(&br2.Header))
 	case "dump":
 		fmt.Printf("%s\n", strings.Join(br.Header.Show(), "\n"))
+		fmt.Printf("Compression:%s\n", br.Compression())
 	case "extract":
 		bzimage.Debug = log.Printf
 		var i []byte
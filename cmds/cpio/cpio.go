@@ -3,120 +3,496 @@
 // license that can be found in the LICENSE file.
 
 // cpio operates on cpio files using a cpio package
-// It only implements basic cpio options.
+// It implements the commonly used options of GNU cpio.
 //
 //
 // Synopsis:
-//     cpio
+//     cpio -o [-v] [-H FORMAT] [-F ARCHIVE] [-R OWNER] < NAME-LIST
+//     cpio -i [-dfmuv] [-H FORMAT] [-F ARCHIVE] [-R OWNER] [PATTERN...]
+//     cpio -t [-fv] [-H FORMAT] [-F ARCHIVE] [PATTERN...]
+//     cpio -p [-dmuv] [-R OWNER] DIRECTORY < NAME-LIST
 //
 // Description:
+//     In copy-out mode (-o), cpio writes an archive of the files named on
+//     stdin, one per line, e.g. find . | cpio -o > archive.
+//
+//     In copy-in mode (-i), cpio extracts the files in an archive into the
+//     current directory. If patterns are given, only files whose names
+//     match at least one of them are extracted. Patterns are shell
+//     patterns as in filepath.Match, except that * and ? also match /.
+//
+//     In list mode (-t), cpio prints the names of the files in an archive,
+//     again optionally filtered by patterns.
+//
+//     In pass-through mode (-p), cpio copies the files named on stdin into
+//     DIRECTORY without an intermediate archive.
+//
+//     The archive is read from stdin and written to stdout unless -F is
+//     given. Archives may be read from a pipe, e.g.
+//         zcat initrd | cpio -it
+//
+//     For compatibility, a first argument of o, i or t selects a mode as
+//     well; i then implies -d and -u, and t implies -v.
 //
 // Options:
-//     o: output an archive to stdout given a pattern
-//     i: output files from a stdin stream
-//     t: print table of contents
-//     -v: debug prints
+//     -o: copy-out: write an archive of the files listed on stdin
+//     -i: copy-in: extract files from an archive
+//     -t: print table of contents
+//     -p: pass-through: copy the files listed on stdin into DIRECTORY
+//     -F: archive file to use instead of stdin or stdout
+//     -H: archive format: newc (default), crc, odc or bin
+//     -d: create leading directories where needed
+//     -u: replace existing files even if they are newer than the archived ones
+//     -m: keep the modification times of the archived files
+//     -f: only use the files that match none of the patterns
+//     -R: set the owner of all files to USER[:GROUP]; USER: uses the
+//         login group of USER and :GROUP only changes the group
+//     -v: list the files processed; with -t, list them like ls -l
 //
-// Bugs: in i mode, it can't use non-seekable stdin, i.e. a pipe. Yep, this sucks.
-// But if we implement seek on such things, we have to do it by reading, which
-// really sucks. It's doable, we'll do it if we have to, but for now I'd like
-// to avoid the complexity. cpio is a 40 year old concept. If you want something
-// better, see ../archive which has a VTOC and separates data from metadata (unlike cpio).
-// We could test for ESPIPE and fix it that way ... later.
+// cpio is a 40 year old concept. If you want something better, see
+// ../archive which has a VTOC and separates data from metadata (unlike cpio).
 package main
 
 import (
 	"bufio"
-	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	flag "github.com/spf13/pflag"
 	"github.com/u-root/u-root/pkg/cpio"
+	"golang.org/x/sys/unix"
 )
 
 var (
-	debug  = func(string, ...interface{}) {}
-	d      = flag.Bool("v", false, "Debug prints")
-	format = flag.String("H", "newc", "format")
+	create      = flag.BoolP("create", "o", false, "Copy-out: write an archive of the files listed on stdin")
+	extract     = flag.BoolP("extract", "i", false, "Copy-in: extract files from an archive")
+	list        = flag.BoolP("list", "t", false, "Print the table of contents of an archive")
+	pass        = flag.BoolP("pass-through", "p", false, "Copy the files listed on stdin into a directory")
+	file        = flag.StringP("file", "F", "", "Archive file to use instead of stdin or stdout")
+	format      = flag.StringP("format", "H", "newc", "Archive format: newc, crc, odc or bin")
+	makeDirs    = flag.BoolP("make-directories", "d", false, "Create leading directories where needed")
+	uncond      = flag.BoolP("unconditional", "u", false, "Replace existing files even if they are newer")
+	keepMTime   = flag.BoolP("preserve-modification-time", "m", false, "Keep the modification times of archived files")
+	nonmatching = flag.BoolP("nonmatching", "f", false, "Only use the files that match none of the patterns")
+	owner       = flag.StringP("owner", "R", "", "Set the owner of all files to USER[:GROUP]")
+	verbose     = flag.BoolP("verbose", "v", false, "List the files processed")
+
+	cmd = "cpio -o|-i|-t|-p [-dfmuv] [-F ARCHIVE] [-H FORMAT] [-R OWNER] [PATTERN...|DIRECTORY]"
 )
 
+func init() {
+	defUsage := flag.Usage
+	flag.Usage = func() {
+		os.Args[0] = cmd
+		defUsage()
+	}
+}
+
 func usage() {
-	log.Fatalf("Usage: cpio")
+	flag.Usage()
+	os.Exit(1)
 }
 
-func main() {
-	flag.Parse()
-	if *d {
-		debug = log.Printf
-	}
+// match reports whether name matches the shell pattern.
+//
+// Unlike in filepath.Match, * and ? also match /, as they do in GNU cpio.
+// filepath.Match treats / specially, so both are matched with / replaced.
+func match(pattern, name string) bool {
+	const sep = "\x00"
+	ok, _ := filepath.Match(strings.Replace(pattern, "/", sep, -1), strings.Replace(name, "/", sep, -1))
+	return ok
+}
 
-	a := flag.Args()
-	debug("Args %v", a)
-	if len(a) < 1 {
-		usage()
+// parseOwner parses an -R argument of the form USER, USER:GROUP, USER: or
+// :GROUP, where USER and GROUP are names or numeric IDs. USER.GROUP is
+// accepted as well.
+//
+// An ID of -1 means that it is to be left alone.
+func parseOwner(s string) (uid int, gid int, err error) {
+	uid, gid = -1, -1
+	name, group := s, ""
+	sep := strings.IndexByte(s, ':')
+	if sep < 0 {
+		sep = strings.IndexByte(s, '.')
 	}
-	op := a[0]
-
-	archiver, err := cpio.Format(*format)
-	if err != nil {
-		log.Fatalf("Format %q not supported: %v", *format, err)
+	if sep >= 0 {
+		name, group = s[:sep], s[sep+1:]
 	}
 
-	switch op {
-	case "i":
-		rr := archiver.Reader(os.Stdin)
-		for {
-			rec, err := rr.ReadRecord()
-			if err == io.EOF {
-				break
+	if len(name) > 0 {
+		u, err := lookupUser(name)
+		if err != nil {
+			return 0, 0, err
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, fmt.Errorf("user %q has invalid uid %q", name, u.Uid)
+		}
+		if sep >= 0 && len(group) == 0 {
+			if gid, err = strconv.Atoi(u.Gid); err != nil {
+				return 0, 0, fmt.Errorf("user %q has no login group", name)
 			}
+		}
+	}
+	if len(group) > 0 {
+		if gid, err = strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
 			if err != nil {
-				log.Fatalf("error reading records: %v", err)
+				return 0, 0, err
 			}
-			debug("Creating %s\n", rec)
-			if err := cpio.CreateFile(rec); err != nil {
-				log.Printf("Creating %q failed: %v", rec.Name, err)
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return 0, 0, fmt.Errorf("group %q has invalid gid %q", group, g.Gid)
 			}
 		}
+	}
+	if uid == -1 && gid == -1 {
+		return 0, 0, fmt.Errorf("invalid owner %q", s)
+	}
+	return uid, gid, nil
+}
 
-	case "o":
-		rw := archiver.Writer(os.Stdout)
-		scanner := bufio.NewScanner(os.Stdin)
+// lookupUser looks up a user by name or numeric ID.
+//
+// Numeric IDs do not have to exist in the user database.
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err != nil {
+		return user.Lookup(name)
+	}
+	if u, err := user.LookupId(name); err == nil {
+		return u, nil
+	}
+	return &user.User{Uid: name}, nil
+}
 
-		for scanner.Scan() {
-			name := scanner.Text()
-			rec, err := cpio.GetRecord(name)
-			if err != nil {
-				log.Fatalf("Getting record of %q failed: %v", name, err)
+// nameList is a RecordReader for the files named in s, one per line.
+type nameList struct {
+	s *bufio.Scanner
+}
+
+// ReadRecord implements cpio.RecordReader.
+func (n nameList) ReadRecord() (cpio.Record, error) {
+	for n.s.Scan() {
+		if name := n.s.Text(); len(name) > 0 {
+			return cpio.GetRecord(name)
+		}
+	}
+	if err := n.s.Err(); err != nil {
+		return cpio.Record{}, fmt.Errorf("reading file names: %v", err)
+	}
+	return cpio.Record{}, io.EOF
+}
+
+// filter is a RecordReader that skips records not matching patterns and
+// applies -R to the others.
+type filter struct {
+	rr       cpio.RecordReader
+	patterns []string
+	uid, gid int
+}
+
+func (f *filter) selected(name string) bool {
+	if len(f.patterns) == 0 {
+		return true
+	}
+	for _, p := range f.patterns {
+		if match(p, name) {
+			return !*nonmatching
+		}
+	}
+	return *nonmatching
+}
+
+// ReadRecord implements cpio.RecordReader.
+func (f *filter) ReadRecord() (cpio.Record, error) {
+	for {
+		rec, err := f.rr.ReadRecord()
+		if err != nil {
+			return rec, err
+		}
+		if !f.selected(rec.Name) {
+			continue
+		}
+		if f.uid != -1 {
+			rec.UID = uint64(f.uid)
+		}
+		if f.gid != -1 {
+			rec.GID = uint64(f.gid)
+		}
+		return rec, nil
+	}
+}
+
+// inode identifies hard linked files in an archive.
+type inode struct {
+	major, minor, ino uint64
+}
+
+// extractor is a RecordWriter that creates the files written to it in dir.
+//
+// Errors creating a file are logged and counted, so that one bad file does
+// not stop the rest from being created.
+type extractor struct {
+	dir    string
+	errors int
+
+	// links maps hard linked files to the first path they were
+	// created at.
+	links map[inode]string
+
+	// dirs are the directories whose modification times are restored
+	// when done, after their contents have been created.
+	dirs []cpio.Record
+
+	// symlinks are the symbolic links created so far, relative to dir.
+	// Files are not created through them, as they may point anywhere.
+	symlinks map[string]bool
+}
+
+func newExtractor(dir string) *extractor {
+	return &extractor{
+		dir:      dir,
+		links:    make(map[inode]string),
+		symlinks: make(map[string]bool),
+	}
+}
+
+// WriteRecord implements cpio.RecordWriter.
+func (e *extractor) WriteRecord(rec cpio.Record) error {
+	if *verbose {
+		fmt.Fprintln(os.Stderr, rec.Name)
+	}
+	if err := e.create(rec); err != nil {
+		log.Printf("%s: %v", rec.Name, err)
+		e.errors++
+	}
+	if c, ok := rec.ReaderAt.(io.Closer); ok {
+		c.Close()
+	}
+	return nil
+}
+
+func (e *extractor) create(rec cpio.Record) error {
+	name := filepath.Join(e.dir, rec.Name)
+	rel, err := filepath.Rel(e.dir, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("refusing to create a file outside of %s", e.dir)
+	}
+	for d := filepath.Dir(rel); d != "."; d = filepath.Dir(d) {
+		if e.symlinks[d] {
+			return fmt.Errorf("refusing to create a file under symbolic link %s", d)
+		}
+	}
+	if !*makeDirs {
+		if _, err := os.Lstat(filepath.Dir(name)); os.IsNotExist(err) {
+			return fmt.Errorf("cannot create: %s does not exist (use -d)", filepath.Dir(name))
+		}
+	}
+
+	typ := rec.Mode & unix.S_IFMT
+	fi, err := os.Lstat(name)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	case fi.IsDir() && typ == unix.S_IFDIR:
+		// Existing directories are kept and only get new modes.
+	default:
+		if !*uncond && !fi.ModTime().Before(time.Unix(int64(rec.MTime), 0)) {
+			log.Printf("%s: not created: newer or same age version exists", rec.Name)
+			return nil
+		}
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+	}
+
+	if typ == unix.S_IFREG && rec.NLink > 1 {
+		i := inode{major: rec.Major, minor: rec.Minor, ino: rec.Ino}
+		if first, ok := e.links[i]; ok {
+			if err := os.Link(first, name); err != nil {
+				return err
 			}
-			if err := rw.WriteRecord(rec); err != nil {
-				log.Fatalf("Writing record %q failed: %v", name, err)
+			// Archivers disagree on which link carries the content.
+			// If this one does, writing it through the new link
+			// writes it to all of them.
+			if rec.FileSize == 0 || rec.ReaderAt == nil {
+				return nil
 			}
+		} else {
+			e.links[i] = name
+		}
+	}
+
+	if err := cpio.CreateFileInRoot(rec, e.dir); err != nil {
+		return err
+	}
+	e.symlinks[rel] = typ == unix.S_IFLNK
+
+	if *keepMTime && typ != unix.S_IFLNK {
+		if typ == unix.S_IFDIR {
+			e.dirs = append(e.dirs, rec)
+			return nil
 		}
+		t := time.Unix(int64(rec.MTime), 0)
+		return os.Chtimes(name, t, t)
+	}
+	return nil
+}
 
-		if err := scanner.Err(); err != nil {
-			log.Fatalf("Error reading stdin: %v", err)
+// finish restores the modification times of directories.
+func (e *extractor) finish() error {
+	for i := len(e.dirs) - 1; i >= 0; i-- {
+		t := time.Unix(int64(e.dirs[i].MTime), 0)
+		if err := os.Chtimes(filepath.Join(e.dir, e.dirs[i].Name), t, t); err != nil {
+			log.Printf("%s: %v", e.dirs[i].Name, err)
+			e.errors++
 		}
-		if err := cpio.WriteTrailer(rw); err != nil {
-			log.Fatalf("Error writing trailer record: %v", err)
+	}
+	if e.errors > 0 {
+		return fmt.Errorf("%d files could not be created", e.errors)
+	}
+	return nil
+}
+
+// verboseWriter prints the names of the records written to stderr.
+type verboseWriter struct {
+	cpio.RecordWriter
+}
+
+// WriteRecord implements cpio.RecordWriter.
+func (v verboseWriter) WriteRecord(rec cpio.Record) error {
+	if rec.Name != cpio.Trailer {
+		fmt.Fprintln(os.Stderr, rec.Name)
+	}
+	return v.RecordWriter.WriteRecord(rec)
+}
+
+// archiveReader returns a RecordReader for the archive in -F or on stdin.
+func archiveReader(archiver cpio.RecordFormat) (cpio.RecordReader, error) {
+	in := os.Stdin
+	if len(*file) > 0 {
+		f, err := os.Open(*file)
+		if err != nil {
+			return nil, err
 		}
+		in = f
+	}
+	// Archives that cannot be read at random, like pipes, are read as a
+	// stream.
+	if _, err := in.Seek(0, io.SeekCurrent); err != nil {
+		return cpio.NewStreamReader(archiver, in), nil
+	}
+	return archiver.Reader(in), nil
+}
 
-	case "t":
-		rr := archiver.Reader(os.Stdin)
-		for {
-			rec, err := rr.ReadRecord()
-			if err == io.EOF {
-				break
+func main() {
+	flag.Parse()
+	a := flag.Args()
+
+	var modes int
+	for _, m := range []bool{*create, *extract, *list, *pass} {
+		if m {
+			modes++
+		}
+	}
+	if modes == 0 && len(a) > 0 {
+		switch a[0] {
+		case "o":
+			*create = true
+		case "i":
+			*extract, *makeDirs, *uncond = true, true, true
+		case "t":
+			*list, *verbose = true, true
+		default:
+			usage()
+		}
+		a = a[1:]
+	} else if modes != 1 {
+		usage()
+	}
+
+	archiver, err := cpio.Format(*format)
+	if err != nil {
+		log.Fatalf("Format %q not supported: %v", *format, err)
+	}
+
+	uid, gid := -1, -1
+	if len(*owner) > 0 {
+		if uid, gid, err = parseOwner(*owner); err != nil {
+			log.Fatalf("Invalid -R: %v", err)
+		}
+	}
+
+	switch {
+	case *extract:
+		rr, err := archiveReader(archiver)
+		if err != nil {
+			log.Fatal(err)
+		}
+		e := newExtractor(".")
+		if err := cpio.Concat(e, &filter{rr: rr, patterns: a, uid: uid, gid: gid}, nil); err != nil {
+			log.Fatalf("Error reading records: %v", err)
+		}
+		if err := e.finish(); err != nil {
+			log.Fatal(err)
+		}
+
+	case *create:
+		if len(a) > 0 {
+			usage()
+		}
+		out := os.Stdout
+		if len(*file) > 0 {
+			if out, err = os.Create(*file); err != nil {
+				log.Fatal(err)
 			}
-			if err != nil {
-				log.Fatalf("error reading records: %v", err)
+		}
+		rr := &filter{rr: nameList{bufio.NewScanner(os.Stdin)}, uid: uid, gid: gid}
+		w := archiver.Writer(out)
+		if *verbose {
+			w = verboseWriter{w}
+		}
+		if err := cpio.Passthrough(rr, w); err != nil {
+			log.Fatalf("Error writing archive: %v", err)
+		}
+		if err := out.Close(); err != nil {
+			log.Fatal(err)
+		}
+
+	case *list:
+		rr, err := archiveReader(archiver)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = cpio.ForEachRecord(&filter{rr: rr, patterns: a, uid: -1, gid: -1}, func(rec cpio.Record) error {
+			if *verbose {
+				fmt.Println(rec)
+			} else {
+				fmt.Println(rec.Name)
 			}
-			fmt.Println(rec)
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Error reading records: %v", err)
 		}
 
-	default:
-		usage()
+	case *pass:
+		if len(a) != 1 {
+			usage()
+		}
+		e := newExtractor(a[0])
+		rr := &filter{rr: nameList{bufio.NewScanner(os.Stdin)}, uid: uid, gid: gid}
+		if err := cpio.Concat(e, rr, nil); err != nil {
+			log.Fatal(err)
+		}
+		if err := e.finish(); err != nil {
+			log.Fatal(err)
+		}
 	}
 }
@@ -0,0 +1,292 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/testutil"
+)
+
+// run runs cpio in dir with stdin and returns its stdout.
+func run(t *testing.T, dir string, stdin string, args ...string) string {
+	c := testutil.Command(t, args...)
+	c.Dir = dir
+	c.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	c.Stdout, c.Stderr = &stdout, &stderr
+	if err := c.Run(); err != nil {
+		t.Fatalf("cpio %v: %v\nstderr: %s", args, err, stderr.String())
+	}
+	return stdout.String()
+}
+
+// tree creates a small file tree in dir and returns the file names as
+// cpio -o would read them.
+func tree(t *testing.T, dir string) string {
+	for _, d := range []string{"etc", "bin"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "etc/hostname"), []byte("u-root\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "bin/bb"), []byte("busybox"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(dir, "bin/bb"), filepath.Join(dir, "bin/ls")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("bb", filepath.Join(dir, "bin/sh")); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Unix(1000000000, 0)
+	if err := os.Chtimes(filepath.Join(dir, "etc/hostname"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	return "etc\netc/hostname\nbin\nbin/bb\nbin/ls\nbin/sh\n"
+}
+
+func readFile(t *testing.T, name string) string {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCopyOutIn(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cpio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	src := filepath.Join(tmpDir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	names := tree(t, src)
+	archive := filepath.Join(tmpDir, "archive.cpio")
+	run(t, src, names, "-o", "-H", "odc", "-F", archive, "-R", "1234:5678")
+
+	// -R changed the owner of all files.
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	recs, err := cpio.ReadAllRecords(cpio.ODC.Reader(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 6 {
+		t.Fatalf("archive has %d records, want 6", len(recs))
+	}
+	for _, rec := range recs {
+		if rec.UID != 1234 || rec.GID != 5678 {
+			t.Errorf("%s: owner is %d:%d, want 1234:5678", rec.Name, rec.UID, rec.GID)
+		}
+	}
+
+	// List with patterns.
+	if got, want := run(t, tmpDir, "", "-t", "-H", "odc", "-F", archive, "bin/*"), "bin/bb\nbin/ls\nbin/sh\n"; got != want {
+		t.Errorf("cpio -t bin/* = %q, want %q", got, want)
+	}
+	if got, want := run(t, tmpDir, "", "-tf", "-H", "odc", "-F", archive, "bin*", "*name"), "etc\n"; got != want {
+		t.Errorf("cpio -tf bin* *name = %q, want %q", got, want)
+	}
+
+	// Extract from a pipe. Without -d, files in missing directories are
+	// not created.
+	dst := filepath.Join(tmpDir, "dst")
+	if err := os.Mkdir(dst, 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := testutil.Command(t, "-i", "-H", "odc", "etc/hostname")
+	c.Dir = dst
+	c.Stdin = strings.NewReader(readFile(t, archive))
+	if err := c.Run(); err == nil {
+		t.Errorf("cpio -i etc/hostname without etc succeeded, want error")
+	}
+	if _, err := os.Stat(filepath.Join(dst, "etc/hostname")); !os.IsNotExist(err) {
+		t.Errorf("etc/hostname was created without -d: %v", err)
+	}
+
+	run(t, dst, readFile(t, archive), "-idm", "-H", "odc")
+	if got := readFile(t, filepath.Join(dst, "etc/hostname")); got != "u-root\n" {
+		t.Errorf("etc/hostname = %q, want %q", got, "u-root\n")
+	}
+	fi, err := os.Stat(filepath.Join(dst, "etc/hostname"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(time.Unix(1000000000, 0)) {
+		t.Errorf("etc/hostname modified at %v, want %v", fi.ModTime(), time.Unix(1000000000, 0))
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "bin/sh")); err != nil || target != "bb" {
+		t.Errorf("bin/sh links to %q (%v), want bb", target, err)
+	}
+	bb, err := os.Stat(filepath.Join(dst, "bin/bb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls, err := os.Stat(filepath.Join(dst, "bin/ls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(bb, ls) {
+		t.Errorf("bin/bb and bin/ls are not hard linked")
+	}
+	if got := readFile(t, filepath.Join(dst, "bin/ls")); got != "busybox" {
+		t.Errorf("bin/ls = %q, want busybox", got)
+	}
+	if st := bb.Sys().(*syscall.Stat_t); os.Getuid() == 0 && (st.Uid != 1234 || st.Gid != 5678) {
+		t.Errorf("bin/bb is owned by %d:%d, want 1234:5678", st.Uid, st.Gid)
+	}
+
+	// Newer files are only replaced with -u.
+	hostname := filepath.Join(dst, "etc/hostname")
+	if err := ioutil.WriteFile(hostname, []byte("local\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run(t, dst, "", "-i", "-H", "odc", "-F", archive, "etc/hostname")
+	if got := readFile(t, hostname); got != "local\n" {
+		t.Errorf("cpio -i replaced newer etc/hostname with %q", got)
+	}
+	run(t, dst, "", "-iu", "-H", "odc", "-F", archive, "etc/hostname")
+	if got := readFile(t, hostname); got != "u-root\n" {
+		t.Errorf("cpio -iu: etc/hostname = %q, want %q", got, "u-root\n")
+	}
+}
+
+// TestSymlinkEscape checks that files are not extracted through symbolic
+// links from the archive, which could point outside of the directory.
+func TestSymlinkEscape(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cpio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	outside := filepath.Join(tmpDir, "outside")
+	dst := filepath.Join(tmpDir, "dst")
+	for _, d := range []string{outside, dst} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var b bytes.Buffer
+	w := cpio.Newc.Writer(&b)
+	if err := cpio.WriteRecords(w, []cpio.Record{
+		cpio.Symlink("evil", outside),
+		cpio.StaticFile("evil/passwd", "root::0:0::/:/bin/sh\n", 0644),
+		cpio.StaticFile("good", "good\n", 0644),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cpio.WriteTrailer(w); err != nil {
+		t.Fatal(err)
+	}
+
+	c := testutil.Command(t, "-id")
+	c.Dir = dst
+	c.Stdin = &b
+	if out, err := c.CombinedOutput(); err == nil || !strings.Contains(string(out), "symbolic link evil") {
+		t.Errorf("cpio -id of evil/passwd under evil -> %s: %q, %v, want error", outside, out, err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "passwd")); !os.IsNotExist(err) {
+		t.Errorf("passwd was created through evil: %v", err)
+	}
+	if got := readFile(t, filepath.Join(dst, "good")); got != "good\n" {
+		t.Errorf("good = %q, want %q", got, "good\n")
+	}
+}
+
+func TestPassThrough(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cpio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	src := filepath.Join(tmpDir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	names := tree(t, src)
+	dst := filepath.Join(tmpDir, "dst")
+
+	run(t, src, names, "-pd", dst)
+	for _, name := range []string{"etc/hostname", "bin/bb", "bin/ls"} {
+		if got, want := readFile(t, filepath.Join(dst, name)), readFile(t, filepath.Join(src, name)); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "bin/sh")); err != nil || target != "bb" {
+		t.Errorf("bin/sh links to %q (%v), want bb", target, err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"etc/*", "etc/hostname", true},
+		{"etc/*", "etc/ssh/sshd_config", true},
+		{"*.conf", "etc/resolv.conf", true},
+		{"etc/?osts", "etc/hosts", true},
+		{"etc/[hp]*", "etc/passwd", true},
+		{"etc", "etc/hosts", false},
+		{"bin/*", "etc/hosts", false},
+	} {
+		if got := match(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseOwner(t *testing.T) {
+	for _, tt := range []struct {
+		owner    string
+		uid, gid int
+	}{
+		{"1000", 1000, -1},
+		{"1000:100", 1000, 100},
+		{"1000.100", 1000, 100},
+		{":100", -1, 100},
+		{"root:", 0, 0},
+		{"root:root", 0, 0},
+	} {
+		uid, gid, err := parseOwner(tt.owner)
+		if err != nil {
+			t.Errorf("parseOwner(%q) = %v", tt.owner, err)
+			continue
+		}
+		if uid != tt.uid || gid != tt.gid {
+			t.Errorf("parseOwner(%q) = %d, %d, want %d, %d", tt.owner, uid, gid, tt.uid, tt.gid)
+		}
+	}
+
+	for _, owner := range []string{"", ":", "no-such-user-hopefully"} {
+		if _, _, err := parseOwner(owner); err == nil {
+			t.Errorf("parseOwner(%q) = nil, want error", owner)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutil.Run(m, main)
+}
@@ -2,32 +2,79 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// gpt reads and writes GPT headers.
+// gpt reads, writes and edits GPT headers.
 //
 // Synopsis:
 //     gpt [-w] file
+//     gpt create file
+//     gpt free file
+//     gpt repair file
+//     gpt add file type [start [end [name]]]
+//     gpt delete file n
+//     gpt resize file n end
+//     gpt set-name file n name
+//     gpt set-attr file n attributes
+//     gpt set-type file n type
 //
 // Description:
 //     For -w, it reads a JSON formatted GPT from stdin, and writes 'file'
 //     which is usually a device. It writes both primary and secondary headers.
 //
 //     Otherwise it just writes the headers to stdout in JSON format.
+//
+//     create writes a new, empty GPT and a protective MBR covering the
+//     whole of 'file'. free lists the unpartitioned space, aligned to 1 MiB.
+//     repair rebuilds a damaged primary or secondary header from the other
+//     one, and writes a protective MBR if there is no valid MBR.
+//
+//     The other commands edit partition n, counting from 1, and write both
+//     primary and secondary headers. A type is a GUID or one of the names
+//     efi, bios-boot, linux, linux-swap, linux-home, linux-lvm, linux-raid,
+//     linux-root-x86, linux-root-x86-64, linux-root-arm64,
+//     microsoft-basic-data, microsoft-reserved, chromeos-kernel,
+//     chromeos-rootfs, chromeos-firmware or chromeos-reserved.
+//
+//     start and end are block numbers, or byte offsets with a K, M, G or T
+//     suffix. An end of +size is relative to the start. A start or end of 0
+//     picks the start or end of the first free space. For resize, an end of
+//     0 grows the partition as far as it can.
+//
+//     Attributes are a number or a comma separated list of required,
+//     no-block-io, legacy-boot, read-only, shadow-copy, hidden and
+//     no-automount.
 package main
 
 import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/u-root/u-root/pkg/gpt"
 )
 
-const cmd = "gpt [options] file"
+const cmd = "gpt [options] file | create file | free file | repair file | add file type [start [end [name]]] | delete file n | resize file n end | set-name file n name | set-attr file n attributes | set-type file n type"
 
 var (
 	write = flag.Bool("w", false, "Write GPT to file")
+
+	// commands maps commands to their minimum and maximum number of
+	// arguments, including the file.
+	commands = map[string][2]int{
+		"create":   {1, 1},
+		"free":     {1, 1},
+		"repair":   {1, 1},
+		"add":      {2, 5},
+		"delete":   {2, 2},
+		"resize":   {3, 3},
+		"set-name": {3, 3},
+		"set-attr": {3, 3},
+		"set-type": {3, 3},
+	}
 )
 
 func init() {
@@ -39,8 +86,174 @@ func init() {
 	}
 }
 
+// parseBlock parses a block number, or a byte offset with a K, M, G or T
+// suffix.
+func parseBlock(s string) (uint64, error) {
+	shift := uint(0)
+	if i := strings.IndexAny(s, "KMGTkmgt"); i != -1 && i == len(s)-1 {
+		shift = uint(10 * (strings.IndexByte("kmgt", s[i]|0x20) + 1))
+		s = s[:i]
+	}
+	n, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return 0, err
+	}
+	if shift == 0 {
+		return n, nil
+	}
+	return n << shift / gpt.BlockSize, nil
+}
+
+// parseEnd parses the last block of a partition starting at start.
+func parseEnd(s string, start uint64) (uint64, error) {
+	if !strings.HasPrefix(s, "+") {
+		return parseBlock(s)
+	}
+	n, err := parseBlock(s[1:])
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return start + n - 1, nil
+}
+
+// partNum parses a partition number, counting from 1, into an index.
+func partNum(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid partition number %q", s)
+	}
+	return n - 1, nil
+}
+
+// edit applies command c with arguments a to the partition table p.
+func edit(p *gpt.PartitionTable, c string, a []string) error {
+	if c == "add" {
+		typ, err := gpt.ParsePartType(a[0])
+		if err != nil {
+			return err
+		}
+		var start, end uint64
+		var name string
+		if len(a) > 1 {
+			if start, err = parseBlock(a[1]); err != nil {
+				return err
+			}
+		}
+		if start == 0 {
+			if free := p.FreeSpace(gpt.DefaultAlign); len(free) > 0 {
+				start = free[0].First
+			}
+		}
+		if len(a) > 2 {
+			if end, err = parseEnd(a[2], start); err != nil {
+				return err
+			}
+		}
+		if len(a) > 3 {
+			name = a[3]
+		}
+		_, err = p.Add(typ, start, end, name)
+		return err
+	}
+
+	i, err := partNum(a[0])
+	if err != nil {
+		return err
+	}
+	switch c {
+	case "delete":
+		return p.Delete(i)
+	case "resize":
+		var start uint64
+		if i < len(p.Primary.Parts) {
+			start = p.Primary.Parts[i].FirstLBA
+		}
+		end, err := parseEnd(a[1], start)
+		if err != nil {
+			return err
+		}
+		return p.Resize(i, end)
+	case "set-name":
+		return p.SetName(i, a[1])
+	case "set-attr":
+		attr, err := gpt.ParseAttr(a[1])
+		if err != nil {
+			return err
+		}
+		return p.SetAttr(i, attr)
+	case "set-type":
+		typ, err := gpt.ParsePartType(a[1])
+		if err != nil {
+			return err
+		}
+		return p.SetType(i, typ)
+	}
+	return fmt.Errorf("unknown command %q", c)
+}
+
+// command runs one of the commands on file n.
+func command(c, n string, a []string) {
+	m := os.O_RDWR
+	if c == "free" {
+		m = os.O_RDONLY
+	}
+	f, err := os.OpenFile(n, m, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	var p *gpt.PartitionTable
+	switch c {
+	case "create", "repair":
+		// Seeking works for devices, whose Stat size is 0.
+		size, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if c == "create" {
+			p, err = gpt.Create(size)
+		} else {
+			p, err = gpt.Repair(f, size)
+		}
+		if err != nil {
+			log.Fatalf("%s %v: %v", c, n, err)
+		}
+	default:
+		if p, err = gpt.New(f); err != nil {
+			log.Fatalf("Reading %v: %v", n, err)
+		}
+	}
+
+	switch c {
+	case "create", "repair":
+	case "free":
+		for _, e := range p.FreeSpace(gpt.DefaultAlign) {
+			fmt.Printf("%d %d %d\n", e.First, e.Last, e.Size())
+		}
+		return
+	default:
+		if err := edit(p, c, a); err != nil {
+			log.Fatalf("%s: %v", c, err)
+		}
+	}
+	if err := gpt.Write(f, p); err != nil {
+		log.Fatalf("Writing %v: %v", n, err)
+	}
+}
+
 func main() {
 	flag.Parse()
+	// A command name alone is a command missing its file, rather than a
+	// file to dump; use ./create for a file called create.
+	if r, ok := commands[flag.Arg(0)]; (ok || flag.NArg() > 1) && !*write {
+		c := flag.Arg(0)
+		if !ok || flag.NArg()-1 < r[0] || flag.NArg()-1 > r[1] {
+			flag.Usage()
+		}
+		command(c, flag.Arg(1), flag.Args()[2:])
+		return
+	}
 	if flag.NArg() != 1 {
 		flag.Usage()
 	}
@@ -0,0 +1,109 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/gpt"
+	"github.com/u-root/u-root/pkg/testutil"
+)
+
+func TestEdit(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gpt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	disk := filepath.Join(tmpDir, "disk")
+	if err := ioutil.WriteFile(disk, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(disk, 16<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		out  string
+		err  string
+	}{
+		{args: []string{"create"}, err: "Usage"},
+		{args: []string{"delete", disk}, err: "Usage"},
+		{args: []string{"create", disk}},
+		{args: []string{"free", disk}, out: "2048 32734 30687\n"},
+		{args: []string{"add", disk, "efi", "1M", "+4M", "EFI"}},
+		{args: []string{"add", disk, "linux", "0", "0", "root"}},
+		{args: []string{"free", disk}},
+		{args: []string{"add", disk, "bogus"}, err: "is neither a known partition type nor a GUID"},
+		{args: []string{"resize", disk, "2", "+8M"}},
+		{args: []string{"set-name", disk, "2", "ROOT-A"}},
+		{args: []string{"set-attr", disk, "1", "required,legacy-boot"}},
+		{args: []string{"set-type", disk, "2", "linux-root-x86-64"}},
+		{args: []string{"delete", disk, "3"}, err: "Partition 3 is not in use"},
+		{args: []string{"free", disk}, out: "26624 32734 6111\n"},
+	} {
+		out, err := testutil.Command(t, tt.args...).CombinedOutput()
+		if tt.err != "" {
+			if err == nil || !strings.Contains(string(out), tt.err) {
+				t.Errorf("gpt %v: got %q, %v, want error %q", tt.args, out, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("gpt %v: %v: %s", tt.args, err, out)
+		}
+		if tt.out != "" && string(out) != tt.out {
+			t.Errorf("gpt %v: got %q, want %q", tt.args, out, tt.out)
+		}
+	}
+
+	// Damage the primary header and repair it from the backup.
+	f, err := os.OpenFile(disk, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt([]byte("BROKEN"), gpt.HeaderOff); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gpt.New(f); err == nil {
+		t.Fatalf("Reading a damaged GPT: got nil, want error")
+	}
+	if out, err := testutil.Command(t, "repair", disk).CombinedOutput(); err != nil {
+		t.Fatalf("gpt repair: %v: %s", err, out)
+	}
+	p, err := gpt.New(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		typ         string
+		first, last uint64
+		name        string
+		attr        gpt.PartAttr
+	}{
+		{"efi", 2048, 10239, "EFI", gpt.AttrRequired | gpt.AttrLegacyBIOSBootable},
+		{"linux-root-x86-64", 10240, 26623, "ROOT-A", 0},
+	}
+	for i, w := range want {
+		for _, g := range []*gpt.GPT{p.Primary, p.Backup} {
+			part := g.Parts[i]
+			if gpt.PartTypeName(part.PartGUID) != w.typ || part.FirstLBA != w.first || part.LastLBA != w.last || gpt.DecodeName(part.Name) != w.name || part.Attribute != w.attr {
+				t.Errorf("Partition %d: got %s %d-%d %q %#x, want %s %d-%d %q %#x", i+1,
+					gpt.PartTypeName(part.PartGUID), part.FirstLBA, part.LastLBA, gpt.DecodeName(part.Name), part.Attribute,
+					w.typ, w.first, w.last, w.name, w.attr)
+			}
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutil.Run(m, main)
+}
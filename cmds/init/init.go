@@ -128,6 +128,8 @@ func main() {
 
 	osInitGo()
 
+	startServices()
+
 	for _, v := range cmdList {
 		if _, err := os.Stat(v); os.IsNotExist(err) {
 			continue
@@ -158,9 +160,15 @@ func main() {
 			cloneFlags = uintptr(syscall.CLONE_NEWPID)
 		}
 
+		var args []string
+		env := envs
+		if isUinit(v) {
+			args, env = uinitArgs(), uinitEnv(envs)
+		}
+
 		cmdCount++
-		cmd := exec.Command(v)
-		cmd.Env = envs
+		cmd := exec.Command(v, args...)
+		cmd.Env = env
 		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
 		if *test {
 			cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: cloneFlags}
@@ -180,6 +188,9 @@ func main() {
 				break
 			} else if p != -1 {
 				debug("Reaped PID %d, exit status %d", p, s.ExitStatus())
+				if supervisor != nil {
+					supervisor.Reaped(p, s)
+				}
 			} else {
 				debug("Error from Wait4 for orphaned child: %v", err)
 				break
@@ -193,6 +204,13 @@ func main() {
 		log.Printf("init: No suitable executable found in %+v", cmdList)
 	}
 
+	// Services go on after the shell has exited, until they are shut
+	// down.
+	if supervisor != nil {
+		log.Printf("init: Supervising services")
+		supervisor.Reap()
+	}
+
 	// We need to reap all children before exiting.
 	log.Printf("init: Waiting for orphaned children")
 	for {
@@ -0,0 +1,56 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/u-root/u-root/pkg/service"
+)
+
+// supervisor runs the services, if there are any.
+var supervisor *service.Supervisor
+
+// startServices starts the services defined in service.DefaultDir, and
+// serves the supervisor on service.DefaultSocket for svc, shutdown and
+// kexec.
+func startServices() {
+	svcs, err := service.ReadDir(service.DefaultDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("init: %v", err)
+		}
+		return
+	}
+	if len(svcs) == 0 {
+		return
+	}
+	s, err := service.New(svcs)
+	if err != nil {
+		log.Printf("init: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(service.DefaultSocket), 0755); err != nil {
+		log.Printf("init: %v", err)
+	}
+	os.Remove(service.DefaultSocket)
+	// The socket is made 0600, rather than changed after, so that no one
+	// else can connect in between.
+	umask := syscall.Umask(0177)
+	l, err := net.Listen("unix", service.DefaultSocket)
+	syscall.Umask(umask)
+	if err != nil {
+		log.Printf("init: %v", err)
+	} else {
+		go s.Serve(l)
+	}
+	s.Start()
+	supervisor = s
+	log.Printf("init: Started %d services from %s", len(svcs), service.DefaultDir)
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/cmdline"
+)
+
+// uinitArgsFile has the default arguments of uinit, one to a line. u-root
+// -uinitargs puts it in the initramfs.
+const uinitArgsFile = "/etc/uroot/uinitargs"
+
+// uinitEnvFile has the default environment of uinit, likewise from u-root
+// -uinitenv.
+const uinitEnvFile = "/etc/uroot/uinitenv"
+
+// isUinit returns whether v is one of the uinits in cmdList.
+func isUinit(v string) bool {
+	return filepath.Base(v) == "uinit"
+}
+
+// uinitArgs returns the arguments of uinit. They are the uroot.uinitargs
+// from the kernel command line, or the default ones if it has none, then
+// the uroot.uinitflags, which thus win over default flags, and then the
+// arguments the kernel passed to init itself.
+//
+// One initramfs can then do different things on different hosts, e.g.
+// netboot or boot from disk, depending on their command line.
+func uinitArgs() []string {
+	var args []string
+	if _, ok := cmdline.Flag("uroot.uinitargs"); !ok {
+		args = readLines(uinitArgsFile)
+	}
+	args = append(args, cmdline.GetUinitArgs()...)
+	return append(args, flag.Args()...)
+}
+
+// uinitEnv returns env with the variables of uinit added: the default
+// ones, then the uroot.uinitenv from the kernel command line. A variable
+// set twice has the last value, as exec keeps that one.
+func uinitEnv(env []string) []string {
+	env = append(append([]string{}, env...), readLines(uinitEnvFile)...)
+	return append(env, cmdline.GetUinitEnv()...)
+}
+
+// readLines returns the lines of file that are not empty. A file that does
+// not exist has none.
+func readLines(file string) []string {
+	b, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("init: %v", err)
+	}
+	var l []string
+	for _, s := range strings.Split(string(b), "\n") {
+		if s != "" {
+			l = append(l, s)
+		}
+	}
+	return l
+}
@@ -13,6 +13,9 @@
 // Options:
 //     --cmdline=STRING or -c=STRING: Set the kernel command line
 //     --reuse-commandline:           Use the kernel command line from running system
+//     --append=STRING:               Append parameters to the kernel command line
+//     --remove=NAME:                 Remove parameter NAME from the kernel command line;
+//                                    can be given more than once
 //     --i=FILE or --initrd=FILE:     Use file as the kernel's initial ramdisk
 //     -l or --load:                  Load the new kernel into the current kernel
 //     -e or --exec:		      Execute a currently loaded kernel
@@ -25,11 +28,14 @@ import (
 	flag "github.com/spf13/pflag"
 	"github.com/u-root/u-root/pkg/cmdline"
 	"github.com/u-root/u-root/pkg/kexec"
+	"github.com/u-root/u-root/pkg/service"
 )
 
 type options struct {
 	cmdline      string
 	reuseCmdline bool
+	append       string
+	remove       []string
 	initramfs    string
 	load         bool
 	exec         bool
@@ -39,6 +45,8 @@ func registerFlags() *options {
 	o := &options{}
 	flag.StringVarP(&o.cmdline, "cmdline", "c", "", "Set the kernel command line")
 	flag.BoolVar(&o.reuseCmdline, "reuse-cmdline", false, "Use the kernel command line from running system")
+	flag.StringVar(&o.append, "append", "", "Append parameters to the kernel command line")
+	flag.StringArrayVar(&o.remove, "remove", nil, "Remove a parameter, e.g. console, from the kernel command line")
 	flag.StringVarP(&o.initramfs, "initrd", "i", "", "Use file as the kernel's initial ramdisk")
 	flag.BoolVarP(&o.load, "load", "l", false, "Load the new kernel into the current kernel")
 	flag.BoolVarP(&o.exec, "exec", "e", false, "Execute a currently loaded kernel")
@@ -73,6 +81,12 @@ func main() {
 			newCmdLine = procCmdLine.Raw
 		}
 	}
+	if opts.append != "" || len(opts.remove) > 0 {
+		l := cmdline.Parse(newCmdLine)
+		l.Remove(opts.remove...)
+		l.Append(opts.append)
+		newCmdLine = l.String()
+	}
 
 	if opts.load {
 		kernelpath := flag.Args()[0]
@@ -99,6 +113,10 @@ func main() {
 	}
 
 	if opts.exec {
+		// Let init stop its services cleanly first.
+		if err := service.Shutdown(); err != nil {
+			log.Printf("Stopping services: %v", err)
+		}
 		if err := kexec.Reboot(); err != nil {
 			log.Fatalf("%v", err)
 		}
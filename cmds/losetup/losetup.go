@@ -5,16 +5,32 @@
 // Setup loop devices.
 //
 // Synopsis:
-//     losetup [-Ad] FILE
-//     losetup [-Ad] DEV FILE
+//     losetup [-Ar] [-o OFFSET] [--sizelimit SIZE] [-P] [-f] [--show] FILE
+//     losetup [-r] [-o OFFSET] [--sizelimit SIZE] [-P] DEV FILE
+//     losetup -f
+//     losetup -a
+//     losetup DEV
+//     losetup -d DEV...
+//
+// Description:
+//     With a FILE, bind it to DEV or, if there is no DEV, any free loop
+//     device. With only DEV, show what is bound to it.
 //
 // Options:
 //     -A: pick any device
-//     -d: detach the device
+//     -a: show the status of all loop devices
+//     -d: detach the devices
+//     -f: find the first free device, and print it if there is no FILE
+//     --show: print the name of the device FILE is bound to
+//     -o: start OFFSET bytes into FILE
+//     --sizelimit: make the device at most SIZE bytes
+//     -r: make the device read-only
+//     -P: scan the device for partitions, e.g. /dev/loop0p1
 package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 
@@ -22,10 +38,28 @@ import (
 )
 
 var (
-	anyLoop = flag.Bool("A", true, "Pick any device")
-	detach  = flag.Bool("d", false, "Detach the device")
+	anyLoop   = flag.Bool("A", true, "Pick any device")
+	all       = flag.Bool("a", false, "Show the status of all loop devices")
+	detach    = flag.Bool("d", false, "Detach the devices")
+	find      = flag.Bool("f", false, "Find the first free device")
+	show      = flag.Bool("show", false, "Print the device name")
+	offset    = flag.Uint64("o", 0, "Offset in the file")
+	sizeLimit = flag.Uint64("sizelimit", 0, "Size limit of the device")
+	readOnly  = flag.Bool("r", false, "Make the device read-only")
+	partScan  = flag.Bool("P", false, "Scan the device for partitions")
 )
 
+func status(s *loop.Status) string {
+	m := fmt.Sprintf("%s: [%04x]:%d (%s)", s.Dev(), s.Device, s.Inode, s.FileName)
+	if s.Offset != 0 {
+		m += fmt.Sprintf(", offset %d", s.Offset)
+	}
+	if s.SizeLimit != 0 {
+		m += fmt.Sprintf(", sizelimit %d", s.SizeLimit)
+	}
+	return m
+}
+
 func main() {
 	var (
 		filename, devicename string
@@ -35,36 +69,74 @@ func main() {
 	flag.Parse()
 	args := flag.Args()
 	if *detach {
-		if len(args) == 1 {
-			if err := loop.ClearFdFile(args[0]); err != nil {
+		if len(args) == 0 {
+			flag.Usage()
+			log.Fatal("Syntax Error")
+		}
+		for _, d := range args {
+			if err := loop.ClearFdFile(d); err != nil {
 				log.Fatal("Error clearing device: ", err)
 			}
-			log.Println("Detached", args[0])
-			os.Exit(0)
+			log.Println("Detached", d)
 		}
-		flag.Usage()
-		log.Fatal("Syntax Error")
+		os.Exit(0)
 	}
 
-	if len(args) == 1 {
+	if *all {
+		l, err := loop.List()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range l {
+			fmt.Println(status(s))
+		}
+		os.Exit(0)
+	}
+
+	switch {
+	case len(args) == 0 && *find:
+		devicename, err = loop.FindDevice()
+		if err != nil {
+			log.Fatalf("can't find a loop: %v", err)
+		}
+		fmt.Println(devicename)
+		os.Exit(0)
+	case len(args) == 1:
+		if fi, err := os.Stat(args[0]); err == nil && fi.Mode()&os.ModeDevice != 0 && !*find {
+			s, err := loop.GetStatusFile(args[0])
+			if err != nil {
+				log.Fatalf("%s: %v", args[0], err)
+			}
+			fmt.Println(status(s))
+			os.Exit(0)
+		}
 		devicename, err = loop.FindDevice()
 		if err != nil {
 			log.Fatalf("can't find a loop: %v", err)
-			os.Exit(1)
 		}
 		filename = args[0]
-	} else if len(args) == 2 {
+	case len(args) == 2:
 		devicename = args[0]
 		filename = args[1]
-	} else {
+	default:
 		flag.Usage()
 		log.Fatal("Syntax Error")
 	}
 
-	if err := loop.SetFdFiles(devicename, filename); err != nil {
+	s := &loop.Status{Offset: *offset, SizeLimit: *sizeLimit}
+	if *readOnly {
+		s.Flags |= loop.FlagReadOnly
+	}
+	if *partScan {
+		s.Flags |= loop.FlagPartScan
+	}
+	if err := loop.ConfigureFiles(devicename, filename, s); err != nil {
 		log.Fatal("Could not set loop device:", err)
 	}
 
+	if *show {
+		fmt.Println(devicename)
+		return
+	}
 	log.Printf("Attached %s to %s", devicename, filename)
-
 }
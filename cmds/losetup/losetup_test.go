@@ -0,0 +1,62 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/testutil"
+)
+
+func TestSyntax(t *testing.T) {
+	for _, args := range [][]string{{"-d"}, {"a", "b", "c"}} {
+		if out, err := testutil.Command(t, args...).CombinedOutput(); err == nil || !strings.Contains(string(out), "Syntax Error") {
+			t.Errorf("losetup %q = %q, %v, want Syntax Error", args, out, err)
+		}
+	}
+}
+
+func TestAttach(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Must be root for this test")
+	}
+	f, err := ioutil.TempFile("", "losetup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := f.Truncate(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := testutil.Command(t, "-f", "--show", "-r", "-o", "4096", "--sizelimit", "8192", f.Name()).Output()
+	if err != nil {
+		t.Fatalf("losetup -f --show: %v", err)
+	}
+	dev := strings.TrimSpace(string(out))
+	if !strings.HasPrefix(dev, "/dev/loop") {
+		t.Fatalf("losetup -f --show = %q, want a loop device", dev)
+	}
+	defer testutil.Command(t, "-d", dev).Run()
+
+	want := " (" + f.Name() + "), offset 4096, sizelimit 8192\n"
+	for _, args := range [][]string{{"-a"}, {dev}} {
+		out, err := testutil.Command(t, args...).Output()
+		if err != nil || !strings.Contains(string(out), dev+": ") || !strings.Contains(string(out), want) {
+			t.Errorf("losetup %q = %q, %v, want %s: ...%s", args, out, err, dev, want)
+		}
+	}
+	if out, err := testutil.Command(t, "-d", dev).CombinedOutput(); err != nil {
+		t.Errorf("losetup -d %s: %v, %s", dev, err, out)
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutil.Run(m, main)
+}
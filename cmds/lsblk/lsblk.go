@@ -0,0 +1,166 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Lsblk lists block devices, with their partitions below them.
+//
+// Synopsis:
+//     lsblk [-bflnp] [DEVICE]...
+//
+// Description:
+//     Without DEVICE, all block devices are listed. A DEVICE is listed
+//     with its partitions.
+//
+// Options:
+//     -b: print sizes in bytes
+//     -f: print file system types, labels and UUIDs instead of sizes
+//     -l: print a list instead of a tree
+//     -n: print no header
+//     -p: print device paths instead of names
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	flag "github.com/spf13/pflag"
+	"github.com/u-root/u-root/pkg/block"
+)
+
+var (
+	inBytes   = flag.BoolP("bytes", "b", false, "Print sizes in bytes")
+	fsInfo    = flag.BoolP("fs", "f", false, "Print file system information")
+	list      = flag.BoolP("list", "l", false, "Print a list instead of a tree")
+	noHeading = flag.BoolP("noheadings", "n", false, "Do not print a header")
+	paths     = flag.BoolP("paths", "p", false, "Print device paths")
+)
+
+// mountPoints returns the mount points of devices in /proc/mounts.
+func mountPoints() map[string]string {
+	m := map[string]string{}
+	f, err := os.Open("/proc/mounts")
+	if err != nil {
+		return m
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if fields := strings.Fields(s.Text()); len(fields) > 1 && strings.HasPrefix(fields[0], "/dev/") {
+			if _, ok := m[fields[0]]; !ok {
+				m[fields[0]] = fields[1]
+			}
+		}
+	}
+	return m
+}
+
+// humanSize formats n like 512B, 8M or 1.5G.
+func humanSize(n uint64) string {
+	const units = "BKMGTPE"
+	v, i := float64(n), 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return strings.TrimSuffix(strconv.FormatFloat(v, 'f', 1, 64), ".0") + units[i:i+1]
+}
+
+func lsblk(w io.Writer, devs block.BlockDevs, names []string, mounts map[string]string) error {
+	var disks block.BlockDevs
+	if len(names) == 0 {
+		disks = devs.Filter(func(d *block.BlockDev) bool { return d.Parent == "" })
+	}
+	for _, name := range names {
+		found := devs.Filter(func(d *block.BlockDev) bool {
+			return d.Name == name || d.DevPath == name || d.DevPath == filepath.Join("/dev", name)
+		})
+		if len(found) == 0 {
+			return fmt.Errorf("%s: not a block device", name)
+		}
+		disks = append(disks, found...)
+	}
+
+	var out strings.Builder
+	tw := tabwriter.NewWriter(&out, 0, 0, 1, ' ', 0)
+	if !*noHeading {
+		if *fsInfo {
+			fmt.Fprintln(tw, "NAME\tFSTYPE\tLABEL\tUUID\tMOUNTPOINT")
+		} else {
+			fmt.Fprintln(tw, "NAME\tMAJ:MIN\tRM\tSIZE\tRO\tTYPE\tMOUNTPOINT")
+		}
+	}
+	line := func(d *block.BlockDev, prefix string) {
+		name := d.Name
+		if *paths {
+			name = d.DevPath
+		}
+		if *fsInfo {
+			fmt.Fprintf(tw, "%s%s\t%s\t%s\t%s\t%s\n", prefix, name, d.FSType, d.Label, d.UUID, mounts[d.DevPath])
+			return
+		}
+		size := humanSize(d.Size)
+		if *inBytes {
+			size = strconv.FormatUint(d.Size, 10)
+		}
+		typ := "disk"
+		switch {
+		case d.Parent != "":
+			typ = "part"
+		case strings.HasPrefix(d.Name, "loop"):
+			typ = "loop"
+		}
+		fmt.Fprintf(tw, "%s%s\t%d:%d\t%d\t%s\t%d\t%s\t%s\n", prefix, name, d.Major, d.Minor, b2i(d.Removable), size, b2i(d.ReadOnly), typ, mounts[d.DevPath])
+	}
+	for _, d := range disks {
+		line(d, "")
+		parts := devs.Filter(func(p *block.BlockDev) bool { return p.Parent == d.Name })
+		for i, p := range parts {
+			prefix := ""
+			if !*list {
+				prefix = "├─"
+				if i == len(parts)-1 {
+					prefix = "└─"
+				}
+			}
+			line(p, prefix)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	// Empty last columns leave trailing blanks.
+	for _, l := range strings.SplitAfter(out.String(), "\n") {
+		if l != "" {
+			fmt.Fprintln(w, strings.TrimRight(l, " \n"))
+		}
+	}
+	return nil
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	flag.Parse()
+	devs, err := block.BlockDevices()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := lsblk(os.Stdout, devs, flag.Args(), mountPoints()); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/block"
+)
+
+var devs = block.BlockDevs{
+	{Name: "loop0", DevPath: "/dev/loop0", Major: 7},
+	{Name: "sda", DevPath: "/dev/sda", Major: 8, Size: 16 << 30, Removable: true},
+	{Name: "sda1", DevPath: "/dev/sda1", Major: 8, Minor: 1, Size: 512 << 20, Removable: true, Parent: "sda", PartNumber: 1, FSType: "vfat", Label: "EFI", UUID: "1234-ABCD"},
+	{Name: "sda2", DevPath: "/dev/sda2", Major: 8, Minor: 2, Size: 3 << 29, Removable: true, Parent: "sda", PartNumber: 2, FSType: "ext4", UUID: "5bd1c8f5-7b1c-4a8e-9f5e-0e3f6a8e4b2d"},
+	{Name: "sr0", DevPath: "/dev/sr0", Major: 11, Size: 1000, ReadOnly: true},
+}
+
+func TestHumanSize(t *testing.T) {
+	for n, want := range map[uint64]string{
+		0:           "0B",
+		1000:        "1000B",
+		1024:        "1K",
+		1536:        "1.5K",
+		8 << 20:     "8M",
+		3 << 29:     "1.5G",
+		1<<40 + 1:   "1T",
+		100<<30 + 1: "100G",
+	} {
+		if got := humanSize(n); got != want {
+			t.Errorf("humanSize(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestLsblk(t *testing.T) {
+	mounts := map[string]string{"/dev/sda2": "/mnt"}
+	for _, tt := range []struct {
+		name                                 string
+		bytes, fs, list, noHeading, showPath bool
+		args                                 []string
+		want                                 string
+	}{
+		{
+			name: "tree",
+			want: `NAME   MAJ:MIN RM SIZE  RO TYPE MOUNTPOINT
+loop0  7:0     0  0B    0  loop
+sda    8:0     1  16G   0  disk
+├─sda1 8:1     1  512M  0  part
+└─sda2 8:2     1  1.5G  0  part /mnt
+sr0    11:0    0  1000B 1  disk
+`,
+		},
+		{
+			name: "fs",
+			fs:   true,
+			args: []string{"sda"},
+			want: `NAME   FSTYPE LABEL UUID                                 MOUNTPOINT
+sda
+├─sda1 vfat   EFI   1234-ABCD
+└─sda2 ext4         5bd1c8f5-7b1c-4a8e-9f5e-0e3f6a8e4b2d /mnt
+`,
+		},
+		{
+			name:      "list",
+			bytes:     true,
+			list:      true,
+			noHeading: true,
+			showPath:  true,
+			args:      []string{"/dev/sda"},
+			want: `/dev/sda  8:0 1 17179869184 0 disk
+/dev/sda1 8:1 1 536870912   0 part
+/dev/sda2 8:2 1 1610612736  0 part /mnt
+`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			*inBytes, *fsInfo, *list, *noHeading, *paths = tt.bytes, tt.fs, tt.list, tt.noHeading, tt.showPath
+			var b bytes.Buffer
+			if err := lsblk(&b, devs, tt.args, mounts); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("lsblk() printed\n%s, want\n%s", b.String(), tt.want)
+			}
+		})
+	}
+
+	if err := lsblk(&bytes.Buffer{}, devs, []string{"sdz"}, nil); err == nil {
+		t.Errorf("lsblk(sdz): got nil, want error")
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Mkfs makes a file system on a block device or image file.
+//
+// Synopsis:
+//     mkfs [-t ext4|vfat] [-L LABEL] [-U UUID] [-s SIZE] [-b BLOCKSIZE] [-F 12|16|32] DEVICE
+//
+// Description:
+//     The file system fills DEVICE. With -s, an image file is created or
+//     resized to SIZE bytes first; a block device gets a file system of
+//     SIZE bytes at its start.
+//
+//     ext4 file systems get no journal.
+//
+// Options:
+//     -t: file system type, ext4 (the default) or vfat
+//     -L: volume label
+//     -U: file system UUID, or for vfat the serial number as XXXX-XXXX
+//     -s: size, with an optional K, M, G or T suffix
+//     -b: ext4 block size, or vfat sectors per cluster
+//     -F: FAT type: 12, 16 or 32
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rck/unit"
+	flag "github.com/spf13/pflag"
+	"github.com/u-root/u-root/pkg/fs/ext4"
+	"github.com/u-root/u-root/pkg/fs/fat"
+)
+
+var (
+	fsType    = flag.StringP("type", "t", "ext4", "File system type: ext4 or vfat")
+	label     = flag.StringP("label", "L", "", "Volume label")
+	uuid      = flag.StringP("uuid", "U", "", "File system UUID or vfat serial number")
+	size      = flag.StringP("size", "s", "", "File system size")
+	blockSize = flag.IntP("block-size", "b", 0, "ext4 block size, or vfat sectors per cluster")
+	fatType   = flag.IntP("fat-size", "F", 0, "FAT type: 12, 16 or 32")
+)
+
+// parseUUID parses the hex digits of s, with or without dashes, into b.
+func parseUUID(s string, b []byte) error {
+	d, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil || len(d) != len(b) {
+		return fmt.Errorf("invalid UUID %q: want %d hex digits", s, 2*len(b))
+	}
+	copy(b, d)
+	return nil
+}
+
+// open opens the device or file name and returns the file system size.
+func open(name string) (*os.File, int64, error) {
+	if *size == "" {
+		f, err := os.OpenFile(name, os.O_RDWR, 0)
+		if err != nil {
+			return nil, 0, err
+		}
+		// Seeking works for devices, whose Stat size is 0.
+		n, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		return f, n, nil
+	}
+
+	v, err := unit.MustNewUnit(unit.DefaultUnits).ValueFromString(*size)
+	if err != nil || v.Value <= 0 {
+		return nil, 0, fmt.Errorf("invalid size %q", *size)
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	if fi.Mode().IsRegular() {
+		err = f.Truncate(v.Value)
+	} else if n, serr := f.Seek(0, io.SeekEnd); serr != nil {
+		err = serr
+	} else if n < v.Value {
+		err = fmt.Errorf("%s has only %d bytes", name, n)
+	}
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, v.Value, nil
+}
+
+func mkfs(name string) error {
+	f, n, err := open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch *fsType {
+	case "ext4":
+		o := &ext4.FormatOptions{BlockSize: *blockSize, Label: *label}
+		if *uuid != "" {
+			if err := parseUUID(*uuid, o.UUID[:]); err != nil {
+				return err
+			}
+		}
+		err = ext4.Format(f, n, o)
+	case "vfat", "fat":
+		o := &fat.FormatOptions{Type: fat.Type(*fatType), SectorsPerCluster: *blockSize, Label: *label}
+		if *uuid != "" {
+			s, err := strconv.ParseUint(strings.Replace(*uuid, "-", "", 1), 16, 32)
+			if err != nil {
+				return fmt.Errorf("invalid serial number %q: want XXXX-XXXX", *uuid)
+			}
+			o.Serial = uint32(s)
+		}
+		err = fat.Format(f, n, o)
+	default:
+		return fmt.Errorf("unsupported file system type %q", *fsType)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err := mkfs(flag.Arg(0)); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/block"
+	"github.com/u-root/u-root/pkg/testutil"
+)
+
+func TestMkfs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mkfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range []struct {
+		name string
+		args []string
+		size int64
+		want block.FSInfo
+	}{
+		{
+			name: "ext4",
+			args: []string{"-s", "8M", "-L", "root", "-U", "5bd1c8f5-7b1c-4a8e-9f5e-0e3f6a8e4b2d"},
+			size: 8 << 20,
+			want: block.FSInfo{Type: "ext4", Label: "root", UUID: "5bd1c8f5-7b1c-4a8e-9f5e-0e3f6a8e4b2d"},
+		},
+		{
+			name: "vfat",
+			args: []string{"-t", "vfat", "-s", "40M", "-F", "32", "-L", "EFI", "-U", "1234-ABCD"},
+			size: 40 << 20,
+			want: block.FSInfo{Type: "vfat", Label: "EFI", UUID: "1234-ABCD"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, tt.name+".img")
+			if out, err := testutil.Command(t, append(tt.args, name)...).CombinedOutput(); err != nil {
+				t.Fatalf("mkfs: %v\n%s", err, out)
+			}
+			f, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if fi, err := f.Stat(); err != nil || fi.Size() != tt.size {
+				t.Errorf("%s has %v bytes, want %d (%v)", name, fi.Size(), tt.size, err)
+			}
+			got, err := block.Probe(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("Probe() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// Without -s, the existing file is formatted as is.
+	if out, err := testutil.Command(t, "-t", "vfat", filepath.Join(dir, "ext4.img")).CombinedOutput(); err != nil {
+		t.Fatalf("mkfs: %v\n%s", err, out)
+	}
+	for _, args := range [][]string{
+		{filepath.Join(dir, "nothere")},
+		{"-t", "btrfs", "-s", "8M", filepath.Join(dir, "btrfs.img")},
+		{"-U", "xyz", "-s", "8M", filepath.Join(dir, "bad.img")},
+		{"-s", "lots", filepath.Join(dir, "bad.img")},
+	} {
+		if err := testutil.Command(t, args...).Run(); err == nil {
+			t.Errorf("mkfs %v: got nil, want error", args)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutil.Run(m, main)
+}
@@ -6,21 +6,44 @@
 //
 // Synopsis:
 //     mount [-r] [-o options] [-t FSTYPE] DEV PATH
+//     mount [-r] [-o options] DEV|PATH
+//     mount -a [-t FSTYPE[,FSTYPE...]]
+//     mount --bind|--rbind [-r] DIR PATH
+//     mount --make-private|--make-rprivate|--make-shared|--make-slave PATH
+//
+// Description:
+//     DEV may be a device, a file, or one of UUID=, LABEL=, PARTUUID= and
+//     PARTLABEL= to find the device by its file system or partition.
+//     Without -t, the file system type is found in the superblock, or,
+//     failing that, every block file system in /proc/filesystems is tried.
+//
+//     With only one of DEV and PATH, the other one is looked up in
+//     /etc/fstab. -a mounts all /etc/fstab entries that are not noauto and
+//     not mounted yet.
 //
 // Options:
 //     -r: read only
+//     -a: mount all file systems in /etc/fstab
+//     -o: comma separated mount options; loop sets up a loop device for a
+//         file
+//     -t: file system type; with -a, only mount these types
+//     --bind: bind mount DIR on PATH
+//     --rbind: bind mount DIR and all mounts below it on PATH
+//     --make-private, --make-rprivate, --make-shared, --make-slave: change
+//         the propagation type of the mount at PATH
 package main
 
 import (
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"strings"
 
+	"github.com/u-root/u-root/pkg/block"
 	"github.com/u-root/u-root/pkg/loop"
 	"github.com/u-root/u-root/pkg/mount"
-	"golang.org/x/sys/unix"
 )
 
 type mountOptions []string
@@ -37,9 +60,23 @@ func (o *mountOptions) Set(value string) error {
 }
 
 var (
-	ro      = flag.Bool("r", false, "Read only mount")
-	fsType  = flag.String("t", "", "File system type")
-	options mountOptions
+	ro           = flag.Bool("r", false, "Read only mount")
+	fsType       = flag.String("t", "", "File system type")
+	all          = flag.Bool("a", false, "Mount all file systems in /etc/fstab")
+	bind         = flag.Bool("bind", false, "Bind mount a directory")
+	rbind        = flag.Bool("rbind", false, "Bind mount a directory and all mounts below it")
+	makePrivate  = flag.Bool("make-private", false, "Make a mount private")
+	makeRPrivate = flag.Bool("make-rprivate", false, "Make a mount and all mounts below it private")
+	makeShared   = flag.Bool("make-shared", false, "Make a mount shared")
+	makeSlave    = flag.Bool("make-slave", false, "Make a mount slave")
+	options      mountOptions
+)
+
+var (
+	fstab      = "/etc/fstab"
+	procMounts = "/proc/mounts"
+	// blockDevices is replaced in tests.
+	blockDevices = block.BlockDevices
 )
 
 func init() {
@@ -51,7 +88,7 @@ func loopSetup(filename string) (loopDevice string, err error) {
 	if err != nil {
 		return "", err
 	}
-	if err := loop.SetFdFiles(loopDevice, filename); err != nil {
+	if err := loop.ConfigureFiles(loopDevice, filename, &loop.Status{}); err != nil {
 		return "", err
 	}
 	return loopDevice, nil
@@ -81,6 +118,22 @@ func getSupportedFilesystem(originFS string) ([]string, bool, error) {
 
 }
 
+// blockFilesystems returns the file systems in /proc/filesystems that
+// need a device.
+func blockFilesystems() ([]string, error) {
+	fs, err := ioutil.ReadFile("/proc/filesystems")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range strings.Split(string(fs), "\n") {
+		if n := strings.Fields(f); len(n) == 1 {
+			names = append(names, n[0])
+		}
+	}
+	return names, nil
+}
+
 func informIfUnknownFS(originFS string) {
 	knownFS, known, err := getSupportedFilesystem(originFS)
 	if err != nil {
@@ -92,26 +145,13 @@ func informIfUnknownFS(originFS string) {
 	}
 }
 
-func main() {
-	flag.Parse()
-	a := flag.Args()
-	if len(a) < 2 {
-		flag.Usage()
-		os.Exit(1)
-	}
-
-	dev := a[0]
-	path := a[1]
-	var flags uintptr
-	var data []string
-	var err error
-	for _, option := range options {
+// parseOptions splits mount options into flags and file system data.
+func parseOptions(o []string) (flags uintptr, data []string, loop bool) {
+	for _, option := range o {
 		switch option {
+		case "":
 		case "loop":
-			dev, err = loopSetup(dev)
-			if err != nil {
-				log.Fatal("Error setting loop device:", err)
-			}
+			loop = true
 		default:
 			if f, ok := opts[option]; ok {
 				flags |= f
@@ -120,16 +160,241 @@ func main() {
 			}
 		}
 	}
+	return flags, data, loop
+}
+
+// resolveSource returns the device a UUID=, LABEL=, PARTUUID= or
+// PARTLABEL= tag stands for. Anything else is returned as is.
+func resolveSource(spec string) (string, error) {
+	kv := strings.SplitN(spec, "=", 2)
+	if len(kv) != 2 {
+		return spec, nil
+	}
+	var filter func(block.BlockDevs) block.BlockDevs
+	value := strings.Trim(kv[1], `"`)
+	switch kv[0] {
+	case "UUID":
+		filter = func(b block.BlockDevs) block.BlockDevs { return b.FilterFSUUID(value) }
+	case "LABEL":
+		filter = func(b block.BlockDevs) block.BlockDevs { return b.FilterLabel(value) }
+	case "PARTUUID":
+		filter = func(b block.BlockDevs) block.BlockDevs { return b.FilterPartUUID(value) }
+	case "PARTLABEL":
+		filter = func(b block.BlockDevs) block.BlockDevs {
+			return b.Filter(func(d *block.BlockDev) bool { return d.PartLabel == value })
+		}
+	default:
+		return spec, nil
+	}
+	devs, err := blockDevices()
+	if err != nil {
+		return "", err
+	}
+	found := filter(devs)
+	if len(found) == 0 {
+		return "", fmt.Errorf("%s: no such device", spec)
+	}
+	if len(found) > 1 {
+		log.Printf("%s: %d devices match, using %s", spec, len(found), found[0].DevPath)
+	}
+	return found[0].DevPath, nil
+}
+
+// detectFS returns the file system type in the superblock of dev.
+func detectFS(dev string) (string, error) {
+	f, err := os.Open(dev)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fs, err := block.Probe(f)
+	if err != nil {
+		return "", err
+	}
+	return fs.Type, nil
+}
+
+// mountOne mounts dev on path. dev is resolved and, for the loop option,
+// attached to a loop device first. Without fstype, the type is detected.
+func mountOne(dev, path, fstype string, o []string) error {
+	flags, data, useLoop := parseOptions(o)
+	if *ro {
+		flags |= opts["rdonly"]
+	}
+	dev, err := resolveSource(dev)
+	if err != nil {
+		return err
+	}
+	if !useLoop {
+		return mountDev(dev, path, fstype, flags, data)
+	}
+	if dev, err = loopSetup(dev); err != nil {
+		return fmt.Errorf("setting up loop device: %v", err)
+	}
+	if err := mountDev(dev, path, fstype, flags, data); err != nil {
+		loop.ClearFdFile(dev)
+		return err
+	}
+	// Free the loop device when the file system is unmounted. Setting
+	// it earlier would free it when detectFS closes it.
+	s, err := loop.GetStatusFile(dev)
+	if err != nil {
+		return err
+	}
+	s.Flags |= loop.FlagAutoclear
+	return loop.SetStatusFile(dev, s)
+}
+
+// mountDev mounts dev on path, detecting the file system type if there is
+// no fstype.
+func mountDev(dev, path, fstype string, flags uintptr, data []string) error {
+	if flags&(opts["bind"]|opts["move"]|opts["remount"]) != 0 {
+		return mount.Mount(dev, path, fstype, strings.Join(data, ","), flags)
+	}
+
+	fstypes := []string{fstype}
+	if fstype == "" || fstype == "auto" {
+		if t, err := detectFS(dev); err == nil {
+			fstypes = []string{t}
+		} else if fstypes, err = blockFilesystems(); err != nil || len(fstypes) == 0 {
+			return fmt.Errorf("%s: no file system type found", dev)
+		}
+	}
+	var err error
+	for _, t := range fstypes {
+		if err = mount.Mount(dev, path, t, strings.Join(data, ","), flags); err == nil {
+			return nil
+		}
+	}
+	if len(fstypes) == 1 {
+		informIfUnknownFS(fstypes[0])
+	}
+	return err
+}
+
+// bindMount bind mounts dir on path. A read-only bind mount needs a
+// remount.
+func bindMount(dir, path string, recursive bool) error {
+	flags := opts["bind"]
+	if recursive {
+		flags |= opts["rec"]
+	}
+	if err := mount.Mount(dir, path, "", "", flags); err != nil {
+		return err
+	}
 	if *ro {
-		flags |= unix.MS_RDONLY
+		return mount.Mount("", path, "", "", flags|opts["remount"]|opts["rdonly"])
+	}
+	return nil
+}
+
+// fstabEntry returns the fstab entry with device or mount point name.
+func fstabEntry(name string) (*mount.FstabEntry, error) {
+	entries, err := mount.ReadFstab(fstab)
+	if err != nil {
+		return nil, err
 	}
-	if *fsType == "" {
-		// mandatory parameter for the moment
-		log.Fatalf("No file system type provided!\nUsage: mount [-r] [-o mount options] -t fstype dev path")
+	for _, e := range entries {
+		if e.File == name || e.Spec == name {
+			return e, nil
+		}
 	}
-	if err := mount.Mount(dev, path, *fsType, strings.Join(data, ","), flags); err != nil {
-		log.Printf("%v", err)
-		informIfUnknownFS(*fsType)
-		os.Exit(1)
+	return nil, fmt.Errorf("%s: not found in %s", name, fstab)
+}
+
+// mountAll mounts the fstab entries that are not noauto and not mounted
+// yet. With types, only entries of these types are mounted.
+func mountAll(types []string) error {
+	entries, err := mount.ReadFstab(fstab)
+	if err != nil {
+		return err
+	}
+	mounted := map[string]bool{}
+	if m, err := mount.ReadFstab(procMounts); err == nil {
+		for _, e := range m {
+			mounted[e.File] = true
+		}
+	}
+	var failed int
+	for _, e := range entries {
+		if e.HasOption("noauto") || e.Type == "swap" || e.Type == "ignore" || mounted[e.File] {
+			continue
+		}
+		if len(types) > 0 && !contains(types, e.Type) {
+			continue
+		}
+		if err := mountOne(e.Spec, e.File, e.Type, append(e.Options, options...)); err != nil {
+			log.Printf("%s on %s: %v", e.Spec, e.File, err)
+			failed++
+			continue
+		}
+		mounted[e.File] = true
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of the file systems in %s failed to mount", failed, fstab)
+	}
+	return nil
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func run(a []string) error {
+	var propagation uintptr
+	for _, p := range []struct {
+		set   bool
+		flags uintptr
+	}{
+		{*makePrivate, opts["private"]},
+		{*makeRPrivate, opts["private"] | opts["rec"]},
+		{*makeShared, opts["shared"]},
+		{*makeSlave, opts["slave"]},
+	} {
+		if p.set {
+			propagation |= p.flags
+		}
+	}
+
+	switch {
+	case *all:
+		var types []string
+		if *fsType != "" {
+			types = strings.Split(*fsType, ",")
+		}
+		return mountAll(types)
+	case propagation != 0:
+		if len(a) != 1 {
+			return fmt.Errorf("usage: mount --make-private PATH")
+		}
+		return mount.Mount("", a[0], "", "", propagation)
+	case *bind || *rbind:
+		if len(a) != 2 {
+			return fmt.Errorf("usage: mount --bind DIR PATH")
+		}
+		return bindMount(a[0], a[1], *rbind)
+	case len(a) == 1:
+		e, err := fstabEntry(a[0])
+		if err != nil {
+			return err
+		}
+		return mountOne(e.Spec, e.File, e.Type, append(e.Options, options...))
+	case len(a) == 2:
+		return mountOne(a[0], a[1], *fsType, options)
+	}
+	flag.Usage()
+	os.Exit(1)
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	if err := run(flag.Args()); err != nil {
+		log.Fatal(err)
 	}
 }
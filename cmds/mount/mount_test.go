@@ -0,0 +1,84 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/block"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseOptions(t *testing.T) {
+	flags, data, loop := parseOptions([]string{"defaults", "ro", "noatime", "loop", "umask=0077", "", "noauto"})
+	if want := uintptr(unix.MS_RDONLY | unix.MS_NOATIME); flags != want {
+		t.Errorf("flags = %#x, want %#x", flags, want)
+	}
+	if want := []string{"umask=0077"}; !reflect.DeepEqual(data, want) {
+		t.Errorf("data = %q, want %q", data, want)
+	}
+	if !loop {
+		t.Errorf("loop = false, want true")
+	}
+}
+
+func TestResolveSource(t *testing.T) {
+	defer func(f func() (block.BlockDevs, error)) { blockDevices = f }(blockDevices)
+	blockDevices = func() (block.BlockDevs, error) {
+		return block.BlockDevs{
+			{Name: "sda1", DevPath: "/dev/sda1", PartUUID: "0d6f7f5a-1a4f-4d2c-9b1f-3b9a0b6d1e2f", PartLabel: "EFI System", FSType: "vfat", Label: "EFI", UUID: "1234-ABCD"},
+			{Name: "sdb1", DevPath: "/dev/sdb1", PartUUID: "deadbeef-01", FSType: "ext4", Label: "root", UUID: "5bd1c8f5-7b1c-4a8e-9f5e-0e3f6a8e4b2d"},
+		}, nil
+	}
+	for _, tt := range []struct {
+		spec, want string
+		err        bool
+	}{
+		{spec: "/dev/sdc", want: "/dev/sdc"},
+		{spec: "tmpfs", want: "tmpfs"},
+		{spec: "UUID=1234-abcd", want: "/dev/sda1"},
+		{spec: `LABEL="root"`, want: "/dev/sdb1"},
+		{spec: "PARTUUID=DEADBEEF-01", want: "/dev/sdb1"},
+		{spec: "PARTLABEL=EFI System", want: "/dev/sda1"},
+		{spec: "LABEL=nothere", err: true},
+	} {
+		got, err := resolveSource(tt.spec)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("resolveSource(%q) = %q, %v, want %q, error %v", tt.spec, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestFstabEntry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(f string) { fstab = f }(fstab)
+	fstab = filepath.Join(dir, "fstab")
+	if err := ioutil.WriteFile(fstab, []byte("LABEL=EFI /boot/efi vfat noauto\n/dev/sdb1 /data ext4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{"/boot/efi": "LABEL=EFI", "LABEL=EFI": "LABEL=EFI", "/dev/sdb1": "/dev/sdb1"} {
+		e, err := fstabEntry(name)
+		if err != nil || e.Spec != want {
+			t.Errorf("fstabEntry(%q) = %v, %v, want spec %q", name, e, err, want)
+		}
+	}
+	if _, err := fstabEntry("/mnt"); err == nil {
+		t.Errorf("fstabEntry(/mnt): got nil, want error")
+	}
+
+	// Nothing of type nfs is in fstab, so nothing is mounted.
+	if err := mountAll([]string{"nfs"}); err != nil {
+		t.Errorf("mountAll(nfs) = %v, want nil", err)
+	}
+}
@@ -36,6 +36,8 @@ var opts = map[string]uintptr{
 	"relatime":    unix.MS_RELATIME,
 	"remount":     unix.MS_REMOUNT,
 	"rmt_mask":    unix.MS_RMT_MASK,
+	"ro":          unix.MS_RDONLY,
+	"rw":          0,
 	"shared":      unix.MS_SHARED,
 	"silent":      unix.MS_SILENT,
 	"slave":       unix.MS_SLAVE,
@@ -45,4 +47,11 @@ var opts = map[string]uintptr{
 	"synchronous": unix.MS_SYNCHRONOUS,
 	"unbindable":  unix.MS_UNBINDABLE,
 	"verbose":     unix.MS_VERBOSE,
+
+	// fstab options that are no mount flags.
+	"auto":     0,
+	"defaults": 0,
+	"noauto":   0,
+	"nofail":   0,
+	"_netdev":  0,
 }
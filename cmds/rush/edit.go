@@ -0,0 +1,460 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/complete"
+	"github.com/u-root/u-root/pkg/shell/builtins"
+	"github.com/u-root/u-root/pkg/termios"
+)
+
+// maxHistory is how many lines are kept in the history.
+const maxHistory = 1000
+
+// ed is the line editor of an interactive shell, or nil.
+var ed *editor
+
+func init() {
+	addBuiltIn("history", history)
+}
+
+// An editor reads lines from a terminal with emacs-style editing,
+// history and completion. It implements io.Reader for the parser, reading
+// a line whenever it has none.
+//
+// Keys are
+//     ^A, ^E, ^B, ^F, Home, End and arrows: move
+//     ESC b, ESC f: move a word
+//     ^H, DEL, ^D, Delete: delete a character; ^D at the start of an
+//         empty line is the end of input
+//     ^K, ^U, ^W, ESC DEL, ESC d: kill to the end, the start, or a word
+//     ^Y: yank what was killed
+//     ^P, ^N, up and down: move in the history
+//     ^C: discard the line
+//     ^L: redraw the line
+//     TAB: complete builtins and commands in $PATH at the start of a
+//         command, and file names elsewhere
+type editor struct {
+	in  *bufio.Reader
+	out io.Writer
+	// fd is put in raw mode while a line is read, if it is not -1.
+	fd int
+	// prompt is printed before the line. It is "> " for lines that
+	// continue a command.
+	prompt string
+	// pending is what was read but not yet by the parser.
+	pending []byte
+
+	history []string
+	// histFile is where lines are added, if it is not "".
+	histFile string
+	// kill is what was killed last.
+	kill []rune
+
+	// line is the line being edited, and pos is the cursor.
+	line []rune
+	pos  int
+	// hist is where in the history line is, and saved is the new line
+	// while going through the history.
+	hist  int
+	saved []rune
+}
+
+func newEditor(in io.Reader, out io.Writer, fd int) *editor {
+	return &editor{in: bufio.NewReader(in), out: out, fd: fd, prompt: "% "}
+}
+
+// loadHistory reads the history from name, and adds lines to it from now on.
+func (e *editor) loadHistory(name string) {
+	e.histFile = name
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return
+	}
+	for _, l := range strings.Split(string(b), "\n") {
+		if l != "" {
+			e.history = append(e.history, l)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// addHistory adds l to the history, unless it is empty or the same as the
+// last line.
+func (e *editor) addHistory(l string) {
+	if strings.TrimSpace(l) == "" || len(e.history) > 0 && e.history[len(e.history)-1] == l {
+		return
+	}
+	e.history = append(e.history, l)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+	if e.histFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.histFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, l)
+}
+
+func (e *editor) Read(b []byte) (int, error) {
+	if len(e.pending) == 0 {
+		l, err := e.readLine()
+		if err != nil {
+			return 0, err
+		}
+		e.pending = []byte(l + "\n")
+		e.prompt = "> "
+	}
+	n := copy(b, e.pending)
+	e.pending = e.pending[n:]
+	return n, nil
+}
+
+func ctrl(c byte) rune {
+	return rune(c & 0x1f)
+}
+
+// readLine reads and edits a line.
+func (e *editor) readLine() (string, error) {
+	if e.fd != -1 {
+		if old, err := termios.GetTermios(uintptr(e.fd)); err == nil {
+			if err := termios.SetTermios(uintptr(e.fd), termios.MakeRaw(old)); err == nil {
+				defer termios.SetTermios(uintptr(e.fd), old)
+			}
+		}
+	}
+	e.line, e.pos, e.hist, e.saved = nil, 0, len(e.history), nil
+	e.redraw()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			if err == io.EOF && len(e.line) > 0 {
+				break
+			}
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			e.pos = len(e.line)
+			e.redraw()
+			io.WriteString(e.out, "\r\n")
+			l := string(e.line)
+			e.addHistory(l)
+			return l, nil
+		case ctrl('D'):
+			if len(e.line) == 0 {
+				io.WriteString(e.out, "\r\n")
+				return "", io.EOF
+			}
+			e.delete(e.pos, e.pos+1)
+		case ctrl('C'):
+			io.WriteString(e.out, "^C\r\n")
+			e.line, e.pos, e.hist = nil, 0, len(e.history)
+		case ctrl('A'):
+			e.pos = 0
+		case ctrl('E'):
+			e.pos = len(e.line)
+		case ctrl('B'):
+			e.move(-1)
+		case ctrl('F'):
+			e.move(1)
+		case ctrl('H'), 127:
+			e.delete(e.pos-1, e.pos)
+		case ctrl('K'):
+			e.killTo(len(e.line))
+		case ctrl('U'):
+			e.killTo(0)
+		case ctrl('W'):
+			e.killTo(e.wordStart())
+		case ctrl('Y'):
+			e.insert(e.kill)
+		case ctrl('P'):
+			e.browse(-1)
+		case ctrl('N'):
+			e.browse(1)
+		case ctrl('L'):
+			io.WriteString(e.out, "\x1b[H\x1b[2J")
+		case '\t':
+			e.complete()
+		case 27:
+			e.escape()
+		default:
+			if r >= ' ' {
+				e.insert([]rune{r})
+			}
+		}
+		e.redraw()
+	}
+	io.WriteString(e.out, "\r\n")
+	return string(e.line), nil
+}
+
+// escape handles ESC and what follows: arrows, Home, End and Delete, and
+// word commands.
+func (e *editor) escape() {
+	r, _, err := e.in.ReadRune()
+	if err != nil {
+		return
+	}
+	switch r {
+	case 'b':
+		e.pos = e.wordStart()
+		return
+	case 'f':
+		e.pos = e.wordEnd()
+		return
+	case 'd':
+		end := e.wordEnd()
+		e.kill = append([]rune{}, e.line[e.pos:end]...)
+		e.delete(e.pos, end)
+		return
+	case 127, ctrl('H'):
+		e.killTo(e.wordStart())
+		return
+	case '[', 'O':
+	default:
+		return
+	}
+	// A control sequence is parameters and a final byte.
+	var param string
+	for {
+		r, _, err = e.in.ReadRune()
+		if err != nil {
+			return
+		}
+		if r >= 0x40 && r <= 0x7e {
+			break
+		}
+		param += string(r)
+	}
+	switch {
+	case r == 'A':
+		e.browse(-1)
+	case r == 'B':
+		e.browse(1)
+	case r == 'C':
+		e.move(1)
+	case r == 'D':
+		e.move(-1)
+	case r == 'H', r == '~' && (param == "1" || param == "7"):
+		e.pos = 0
+	case r == 'F', r == '~' && (param == "4" || param == "8"):
+		e.pos = len(e.line)
+	case r == '~' && param == "3":
+		e.delete(e.pos, e.pos+1)
+	}
+}
+
+func (e *editor) redraw() {
+	var b bytes.Buffer
+	b.WriteString("\r" + e.prompt + string(e.line) + "\x1b[K")
+	if n := len(e.line) - e.pos; n > 0 {
+		fmt.Fprintf(&b, "\x1b[%dD", n)
+	}
+	e.out.Write(b.Bytes())
+}
+
+func (e *editor) move(n int) {
+	if p := e.pos + n; p >= 0 && p <= len(e.line) {
+		e.pos = p
+	}
+}
+
+func (e *editor) insert(r []rune) {
+	l := append([]rune{}, e.line[:e.pos]...)
+	l = append(l, r...)
+	e.line = append(l, e.line[e.pos:]...)
+	e.pos += len(r)
+}
+
+// delete deletes line[from:to] if it is in the line.
+func (e *editor) delete(from, to int) {
+	if from < 0 || to > len(e.line) || from >= to {
+		return
+	}
+	e.line = append(e.line[:from], e.line[to:]...)
+	if e.pos > from {
+		e.pos = from
+	}
+}
+
+// killTo kills from the cursor to p.
+func (e *editor) killTo(p int) {
+	from, to := e.pos, p
+	if from > to {
+		from, to = to, from
+	}
+	e.kill = append([]rune{}, e.line[from:to]...)
+	e.delete(from, to)
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// wordStart returns where the word before the cursor starts.
+func (e *editor) wordStart() int {
+	p := e.pos
+	for p > 0 && isSpace(e.line[p-1]) {
+		p--
+	}
+	for p > 0 && !isSpace(e.line[p-1]) {
+		p--
+	}
+	return p
+}
+
+// wordEnd returns where the word after the cursor ends.
+func (e *editor) wordEnd() int {
+	p := e.pos
+	for p < len(e.line) && isSpace(e.line[p]) {
+		p++
+	}
+	for p < len(e.line) && !isSpace(e.line[p]) {
+		p++
+	}
+	return p
+}
+
+// browse moves n lines in the history.
+func (e *editor) browse(n int) {
+	h := e.hist + n
+	if h < 0 || h > len(e.history) {
+		return
+	}
+	if e.hist == len(e.history) {
+		e.saved = e.line
+	}
+	e.hist = h
+	if h == len(e.history) {
+		e.line = e.saved
+	} else {
+		e.line = []rune(e.history[h])
+	}
+	e.pos = len(e.line)
+}
+
+// complete completes the word before the cursor as far as it can, and
+// shows the choices if there are several.
+func (e *editor) complete() {
+	start := e.pos
+	for start > 0 && strings.IndexRune(" \t;|&<>", e.line[start-1]) < 0 {
+		start--
+	}
+	// A word is a command if it is first, or follows ;, | or &.
+	cmd := true
+	for i := start - 1; i >= 0; i-- {
+		if !isSpace(e.line[i]) {
+			cmd = strings.IndexRune(";|&", e.line[i]) > -1
+			break
+		}
+	}
+	w := string(e.line[start:e.pos])
+	c := completions(w, cmd)
+	if len(c) == 0 {
+		io.WriteString(e.out, "\a")
+		return
+	}
+	p := c[0]
+	for _, s := range c[1:] {
+		for !strings.HasPrefix(s, p) {
+			p = p[:len(p)-1]
+		}
+	}
+	if len(c) == 1 && !strings.HasSuffix(p, "/") {
+		p += " "
+	}
+	if len(p) > len(w) {
+		e.insert([]rune(p[len(w):]))
+		return
+	}
+	var names []string
+	for _, s := range c {
+		if strings.HasSuffix(s, "/") {
+			names = append(names, filepath.Base(s)+"/")
+		} else {
+			names = append(names, filepath.Base(s))
+		}
+	}
+	io.WriteString(e.out, "\r\n"+strings.Join(names, "  ")+"\r\n")
+}
+
+// completions returns the completions of w: builtins and commands in
+// $PATH if it is a command without a /, and else files. Directories end
+// in /.
+func completions(w string, cmd bool) []string {
+	dir, base := filepath.Split(w)
+	var c complete.Completer
+	if cmd && dir == "" {
+		names := builtins.Names()
+		for n := range shellBuiltins {
+			names = append(names, n)
+		}
+		c = complete.NewStringCompleter(names)
+		if p, err := complete.NewPathCompleter(); err == nil {
+			c = complete.NewMultiCompleter(c, p)
+		}
+	} else {
+		root := dir
+		if root == "" {
+			root = "."
+		}
+		c = complete.NewFileCompleter(root)
+	}
+	// A FileCompleter takes an empty name to be its directory.
+	if base == "" {
+		base = "*"
+	}
+	m, err := c.Complete(base)
+	if err != nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var out []string
+	for _, s := range m {
+		if cmd && dir == "" {
+			s = filepath.Base(s)
+		} else {
+			// FileCompleters return the directory joined with the
+			// name, so use the directory as it was typed.
+			s = dir + filepath.Base(s)
+			if fi, err := os.Stat(s); err == nil && fi.IsDir() {
+				s += "/"
+			}
+		}
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func history(c *Command) error {
+	if ed == nil {
+		return nil
+	}
+	for i, l := range ed.history {
+		fmt.Printf("%5d  %s\n", i+1, l)
+	}
+	return nil
+}
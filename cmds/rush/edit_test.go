@@ -0,0 +1,155 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var editTests = []struct {
+	name string
+	in   string
+	want string
+}{
+	{"plain", "echo hi\r", "echo hi"},
+	{"backspace", "echo hx\x7fi\r", "echo hi"},
+	{"start", "cho hi\x01e\r", "echo hi"},
+	{"end", "cho hi\x01e\x05!\r", "echo hi!"},
+	{"back and forward", "ech hi\x02\x02\x02o\x06\x06x\r", "echo hxi"},
+	{"arrows", "ech hi\x1b[D\x1b[D\x1b[Do\x1b[C\x1b[Cx\r", "echo hxi"},
+	{"home and end", "cho h\x1b[He\x1b[Fi\r", "echo hi"},
+	{"delete", "echo hxi\x02\x02\x04\r", "echo hi"},
+	{"delete key", "echo hxi\x02\x02\x1b[3~\r", "echo hi"},
+	{"kill and yank", "a b c\x02\x02\x0b\x01\x19\r", " ca b"},
+	{"kill to start", "a b c\x02\x15\r", "c"},
+	{"kill word", "echo one two\x17three\r", "echo one three"},
+	{"word moves", "echo one two\x1bb\x1bbX\x1bfY\r", "echo XoneY two"},
+	{"kill word forward", "echo one two\x01\x1bd\r", " one two"},
+	{"interrupt", "junk\x03echo\r", "echo"},
+	{"eof ends the line", "echo", "echo"},
+}
+
+func TestEdit(t *testing.T) {
+	for _, tt := range editTests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEditor(strings.NewReader(tt.in), ioutil.Discard, -1)
+			l, err := e.readLine()
+			if err != nil {
+				t.Fatalf("readLine: got %v, want nil", err)
+			}
+			if l != tt.want {
+				t.Errorf("readLine: got %q, want %q", l, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditEOF(t *testing.T) {
+	e := newEditor(strings.NewReader("\x04"), ioutil.Discard, -1)
+	if _, err := e.readLine(); err != io.EOF {
+		t.Errorf("readLine: got %v, want %v", err, io.EOF)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestHistory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	h := filepath.Join(tmpDir, "history")
+	if err := ioutil.WriteFile(h, []byte("one\ntwo\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newEditor(strings.NewReader("three\r\r\x10\x10\x10\r\x1b[A\x1b[A\x1b[Bx\r\x10\x10\x0e\x0e\r"), ioutil.Discard, -1)
+	e.loadHistory(h)
+	for _, want := range []string{"three", "", "one", "onex", ""} {
+		l, err := e.readLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l != want {
+			t.Errorf("readLine: got %q, want %q", l, want)
+		}
+	}
+	want := []string{"one", "two", "three", "one", "onex"}
+	if !reflect.DeepEqual(e.history, want) {
+		t.Errorf("history: got %q, want %q", e.history, want)
+	}
+	b, err := ioutil.ReadFile(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(want, "\n") + "\n"; string(b) != got {
+		t.Errorf("%s: got %q, want %q", h, b, got)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestComplete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	for _, n := range []string{"file", "filet", "other"} {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, n), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(tmpDir, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "dir", "x"), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", filepath.Join(tmpDir, "dir"))
+
+	for _, tt := range []struct {
+		in   string
+		want string
+	}{
+		{"cat " + tmpDir + "/o\t\r", "cat " + tmpDir + "/other "},
+		{"cat " + tmpDir + "/f\t\r", "cat " + tmpDir + "/file"},
+		{"cat " + tmpDir + "/d\tx\r", "cat " + tmpDir + "/dir/x"},
+		{"cat " + tmpDir + "/z\t\r", "cat " + tmpDir + "/z"},
+		{"ex\t\r", "ex"},
+		{"expo\t\r", "export "},
+		{"hist\t\r", "history "},
+		{"true; \t\r", "true; x "},
+		{"echo x|\t\r", "echo x|x "},
+	} {
+		e := newEditor(strings.NewReader(tt.in), ioutil.Discard, -1)
+		l, err := e.readLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, l, tt.want)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	e := newEditor(strings.NewReader("if true\rthen echo\rfi\r"), ioutil.Discard, -1)
+	l, _, err := getCommand(bufio.NewReader(e))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 1 {
+		t.Errorf("getCommand: got %d nodes, want 1", len(l))
+	}
+	if e.prompt != "> " {
+		t.Errorf("prompt: got %q, want %q", e.prompt, "> ")
+	}
+}
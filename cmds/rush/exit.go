@@ -17,7 +17,7 @@ func init() {
 func exitBuiltin(c *Command) error {
 	var err error
 	if len(c.argv) == 0 {
-		os.Exit(0)
+		os.Exit(status)
 	} else if len(c.argv) > 1 {
 		err = errors.New("Too many arguments")
 	} else if ret, err2 := strconv.Atoi(c.argv[0]); err2 == nil {
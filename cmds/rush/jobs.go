@@ -0,0 +1,334 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/u-root/u-root/pkg/shell/builtins"
+)
+
+// A job is the processes of a pipeline. Jobs that run in the background
+// or are stopped are in the job table.
+type job struct {
+	id   int
+	pgid int
+	text string
+	// cmds are the commands of the pipeline, and done says which have
+	// finished. Builtins are done when the job starts.
+	cmds []*Command
+	done []bool
+	// status is the exit status of the last command.
+	status  int
+	stopped bool
+}
+
+// jobs is the job table. The last job is the current one, %+, and the
+// one before it %-.
+var jobs []*job
+
+func init() {
+	addBuiltIn("jobs", listJobs)
+	addBuiltIn("fg", fg)
+	addBuiltIn("bg", bg)
+}
+
+// jobText returns how a job shows in the job table.
+func jobText(cmds []*Command) string {
+	var s []string
+	for _, c := range cmds {
+		s = append(s, strings.Join(append([]string{c.cmd}, c.argv...), " "))
+	}
+	return strings.Join(s, " | ")
+}
+
+// finished reports whether all processes of j are done.
+func (j *job) finished() bool {
+	for _, d := range j.done {
+		if !d {
+			return false
+		}
+	}
+	return true
+}
+
+func (j *job) state() string {
+	switch {
+	case j.stopped:
+		return "Stopped"
+	case !j.finished():
+		return "Running"
+	case j.status == 0:
+		return "Done"
+	}
+	return fmt.Sprintf("Exit %d", j.status)
+}
+
+// wait waits for the processes of j. With block, it waits until they are
+// all done or, with job control, one stops. Without, it only collects
+// those that changed state.
+func (j *job) wait(block bool) {
+	flags := 0
+	if ttyf != nil {
+		flags |= syscall.WUNTRACED | syscall.WCONTINUED
+	}
+	if !block {
+		flags |= syscall.WNOHANG
+	}
+	for i, c := range j.cmds {
+		for !j.done[i] {
+			var ws syscall.WaitStatus
+			if block {
+				mu.Unlock()
+			}
+			pid, err := syscall.Wait4(c.Process.Pid, &ws, flags, nil)
+			if block {
+				mu.Lock()
+			}
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				j.done[i] = true
+				break
+			}
+			if pid == 0 {
+				break
+			}
+			switch {
+			case ws.Stopped():
+				j.stopped = true
+				if block {
+					return
+				}
+			case ws.Continued():
+				j.stopped = false
+			default:
+				j.done[i] = true
+				if i == len(j.cmds)-1 {
+					if ws.Signaled() {
+						j.status = 128 + int(ws.Signal())
+					} else {
+						j.status = ws.ExitStatus()
+					}
+				}
+				closeFiles(c)
+				c.Process.Release()
+			}
+			if !block {
+				break
+			}
+		}
+	}
+	if j.finished() {
+		j.stopped = false
+	}
+}
+
+// addJob puts j in the job table as the current job.
+func addJob(j *job) {
+	if j.id == 0 {
+		j.id = 1
+		for _, o := range jobs {
+			if o.id >= j.id {
+				j.id = o.id + 1
+			}
+		}
+	}
+	removeJob(j)
+	jobs = append(jobs, j)
+}
+
+func removeJob(j *job) {
+	for i, o := range jobs {
+		if o == j {
+			jobs = append(jobs[:i], jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+// current returns '+' for the current job, '-' for the previous one and
+// else ' '.
+func current(j *job) byte {
+	switch {
+	case len(jobs) > 0 && jobs[len(jobs)-1] == j:
+		return '+'
+	case len(jobs) > 1 && jobs[len(jobs)-2] == j:
+		return '-'
+	}
+	return ' '
+}
+
+func printJob(j *job) {
+	fmt.Printf("[%d]%c  %-24s%s\n", j.id, current(j), j.state(), j.text)
+}
+
+// notify reports jobs that stopped or finished in the background, and
+// removes finished ones from the job table.
+func notify() {
+	for _, j := range append([]*job{}, jobs...) {
+		stopped := j.stopped
+		j.wait(false)
+		switch {
+		case j.finished():
+			printJob(j)
+			removeJob(j)
+		case j.stopped && !stopped:
+			printJob(j)
+		}
+	}
+}
+
+// findJob returns the job for %N, %+, %%, %- or N, or the current job if
+// spec is "".
+func findJob(spec string) (*job, error) {
+	if len(jobs) == 0 {
+		return nil, errors.New("no jobs")
+	}
+	switch spec {
+	case "", "%", "%%", "%+":
+		return jobs[len(jobs)-1], nil
+	case "%-":
+		if len(jobs) < 2 {
+			return nil, errors.New("no previous job")
+		}
+		return jobs[len(jobs)-2], nil
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(spec, "%"))
+	if err != nil {
+		return nil, fmt.Errorf("bad job %q", spec)
+	}
+	for _, j := range jobs {
+		if j.id == n {
+			return j, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no such job", spec)
+}
+
+// waitForeground gives the terminal to j, waits until it is done or
+// stops, and takes the terminal back. Stopped jobs go in the job table.
+func waitForeground(j *job) int {
+	j.wait(true)
+	foreground()
+	if j.stopped {
+		addJob(j)
+		fmt.Println()
+		printJob(j)
+		return 128 + int(syscall.SIGTSTP)
+	}
+	removeJob(j)
+	return j.status
+}
+
+// continueJob sends SIGCONT to the process group of j.
+func continueJob(j *job) error {
+	j.stopped = false
+	if err := syscall.Kill(-j.pgid, syscall.SIGCONT); err != nil {
+		return fmt.Errorf("%s: %v", j.text, err)
+	}
+	return nil
+}
+
+func listJobs(c *Command) error {
+	notify()
+	l := append([]*job{}, jobs...)
+	sort.Slice(l, func(i, k int) bool { return l[i].id < l[k].id })
+	for _, j := range l {
+		printJob(j)
+	}
+	return nil
+}
+
+func fg(c *Command) error {
+	if len(c.argv) > 1 {
+		return errors.New("usage: fg [%job]")
+	}
+	j, err := findJob(strings.Join(c.argv, ""))
+	if err != nil {
+		return fmt.Errorf("fg: %v", err)
+	}
+	fmt.Println(j.text)
+	setForeground(j.pgid)
+	if err := continueJob(j); err != nil {
+		foreground()
+		return fmt.Errorf("fg: %v", err)
+	}
+	if st := waitForeground(j); st != 0 {
+		return builtins.ExitStatus(st)
+	}
+	return nil
+}
+
+func bg(c *Command) error {
+	specs := c.argv
+	if len(specs) == 0 {
+		specs = []string{""}
+	}
+	for _, s := range specs {
+		j, err := findJob(s)
+		if err != nil {
+			return fmt.Errorf("bg: %v", err)
+		}
+		if !j.stopped {
+			return fmt.Errorf("bg: job %d already in background", j.id)
+		}
+		if err := continueJob(j); err != nil {
+			return fmt.Errorf("bg: %v", err)
+		}
+		addJob(j)
+		fmt.Printf("[%d]%c %s &\n", j.id, current(j), j.text)
+	}
+	return nil
+}
+
+// waitJobs waits for the given jobs or process IDs, or all running jobs,
+// and returns the exit status of the last.
+func waitJobs(specs []string) (int, error) {
+	var l []*job
+	for _, s := range specs {
+		if !strings.HasPrefix(s, "%") {
+			pid, err := strconv.Atoi(s)
+			if err != nil {
+				return 0, fmt.Errorf("wait: bad process ID %q", s)
+			}
+			for _, j := range jobs {
+				for _, c := range j.cmds {
+					if c.Process != nil && c.Process.Pid == pid {
+						l = append(l, j)
+					}
+				}
+			}
+			continue
+		}
+		j, err := findJob(s)
+		if err != nil {
+			return 0, fmt.Errorf("wait: %v", err)
+		}
+		l = append(l, j)
+	}
+	if len(specs) == 0 {
+		for _, j := range jobs {
+			if !j.stopped {
+				l = append(l, j)
+			}
+		}
+	}
+	st := 0
+	for _, j := range l {
+		j.wait(true)
+		if j.finished() {
+			removeJob(j)
+		}
+		st = j.status
+	}
+	return st, nil
+}
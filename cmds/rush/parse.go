@@ -9,32 +9,49 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os/exec"
-	"path"
-	"path/filepath"
+	"strconv"
 	"strings"
 )
 
+// An arg is part of a word. The mod says what it is: ARG is text, QUOTE is
+// quoted text, which is not globbed, and ENV and QENV are a variable,
+// unquoted or quoted.
 type arg struct {
 	val string
 	mod string
 }
 
+// A word is an argument as typed. Its parts are expanded and joined when
+// the command is run.
+type word []arg
+
+// A redir is the file a file descriptor is redirected to.
+type redir struct {
+	name   word
+	append bool
+}
+
+// An assign is a NAME=value before a command.
+type assign struct {
+	name string
+	val  word
+}
+
 // The Command struct is initially filled in by the parser. The shell itself
 // adds to it as processing continues, and then uses it to creates os.Commands
 type Command struct {
 	*exec.Cmd
 	// These are filled in by the parser.
-	args  []arg
-	fdmap map[int]string
-	files map[int]io.Closer
-	link  string
-	bg    bool
+	args    []word
+	assigns []assign
+	fdmap   map[int]redir
+	files   map[int]io.Closer
+	link    string
+	bg      bool
 
 	// These are set up by the shell as it evaluates the Commands
 	// provided by the parser.
@@ -42,11 +59,55 @@ type Command struct {
 	// of argv in their builtins. We do that for them.
 	cmd  string
 	argv []string
+	// pipes are closed once the command has started.
+	pipes []io.Closer
+	// pgid is the process group the command joins, or 0 for a new one.
+	pgid int
+}
+
+// The parser makes a list of nodes for each line. A node is a *pipeline,
+// an *andOr, an *ifNode, a *loopNode or a *forNode.
+type node interface{}
+
+// A pipeline is commands with the output of each the input of the next.
+type pipeline struct {
+	cmds []*Command
+}
+
+// An andOr runs the first node, and each next one if the link before it
+// is && and the last node succeeded, or || and it failed.
+type andOr struct {
+	nodes []node
+	links []string
+	bg    bool
+}
+
+// An ifNode is if conds[0]; then bodies[0]; elif conds[1]; then
+// bodies[1]; else els; fi.
+type ifNode struct {
+	conds, bodies [][]node
+	els           []node
+}
+
+// A loopNode is while cond; do body; done, or until if until.
+type loopNode struct {
+	cond, body []node
+	until      bool
+}
+
+// A forNode is for name in words; do body; done. Without in, the words are
+// "$@".
+type forNode struct {
+	name  string
+	words []word
+	body  []node
 }
 
 var (
-	cmds  []Command
-	punct = "<>|&$ \t\n"
+	// punct ends words.
+	punct = "<>|&;$'\" \t\n"
+	// reserved words can not start a simple command.
+	reserved = map[string]bool{"then": true, "elif": true, "else": true, "fi": true, "do": true, "done": true}
 )
 
 func pushback(b *bufio.Reader) {
@@ -68,220 +129,551 @@ func one(b *bufio.Reader) byte {
 	return c
 }
 
-func next(b *bufio.Reader) byte {
-	c := one(b)
-	if c == '\\' {
-		return one(b)
-	}
-	return byte(c)
+func isName(c byte, first bool) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || !first && '0' <= c && c <= '9'
 }
 
-// Tokenize stuff coming in from the stream. For everything but an arg, the
-// type is just the thing itself, since we can switch on strings.
-func tok(b *bufio.Reader) (string, string) {
-	var tokType, arg string
-	c := next(b)
+// validName returns whether s can be the name of a variable.
+func validName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isName(s[i], i == 0) {
+			return false
+		}
+	}
+	return s != ""
+}
 
-	//fmt.Printf("TOK %v", c)
-	switch c {
-	case 0:
-		return "EOF", ""
-	case '>':
-		return "FD", "1"
-	case '<':
-		return "FD", "0"
-	// yes, I realize $ handling is still pretty hokey.
-	case '$':
-		arg = ""
-		c = next(b)
-		for {
+// variable reads what follows a $: a name, a name in braces, a digit or
+// one of ?$#@*. If there is none, the $ is just a $.
+func variable(b *bufio.Reader) (string, bool) {
+	c := one(b)
+	switch {
+	case c == '{':
+		var name string
+		for c = one(b); c != '}'; c = one(b) {
 			if c == 0 {
-				break
+				panic(errors.New("missing } in ${"))
 			}
-			if strings.Index(punct, string(c)) > -1 {
-				pushback(b)
-				break
-			}
-			arg = arg + string(c)
-			c = next(b)
+			name += string(c)
 		}
-		return "ENV", arg
-	case '\'':
-		for {
-			nc := next(b)
-			if nc == '\'' {
-				return "ARG", arg
-			}
-			arg = arg + string(nc)
+		if name == "" {
+			panic(errors.New("bad substitution ${}"))
+		}
+		return name, true
+	case strings.IndexByte("?$#@*", c) > -1 || '0' <= c && c <= '9':
+		return string(c), true
+	case isName(c, true):
+		name := string(c)
+		for c = one(b); isName(c, false); c = one(b) {
+			name += string(c)
 		}
-	case ' ', '\t':
-		return "white", string(c)
-	case '\n':
-		//fmt.Printf("NEWLINE\n")
-		return "EOL", ""
-	case '|', '&':
-		//fmt.Printf("LINK %v\n", c)
-		// peek ahead. We need the literal, so don't use next()
-		nc := one(b)
-		if nc == c {
-			//fmt.Printf("LINK %v\n", string(c)+string(c))
-			return "LINK", string(c) + string(c)
+		if c != 0 {
+			pushback(b)
 		}
+		return name, true
+	}
+	if c != 0 {
 		pushback(b)
-		if c == '&' {
-			//fmt.Printf("BG\n")
-			tokType = "BG"
-			if nc == 0 {
-				tokType = "EOL"
-			}
-			return "BG", tokType
+	}
+	return "", false
+}
+
+// getWord reads a word that starts with c: text, quotes and variables up
+// to a blank or punctuation.
+func getWord(b *bufio.Reader, c byte) word {
+	var w word
+	add := func(val, mod string) {
+		if n := len(w); n > 0 && w[n-1].mod == mod && (mod == "ARG" || mod == "QUOTE") {
+			w[n-1].val += val
+			return
 		}
-		//fmt.Printf("LINK %v\n", string(c))
-		return "LINK", string(c)
-	default:
-		for {
-			if c == 0 {
-				return "ARG", arg
+		w = append(w, arg{val, mod})
+	}
+	for ; ; c = one(b) {
+		switch c {
+		case 0:
+			return w
+		case '\\':
+			switch nc := one(b); nc {
+			case 0:
+				return w
+			case '\n':
+			default:
+				add(string(nc), "QUOTE")
+			}
+		case '\'':
+			add("", "QUOTE")
+			for {
+				nc := one(b)
+				if nc == 0 {
+					panic(errors.New("unterminated '"))
+				}
+				if nc == '\'' {
+					break
+				}
+				add(string(nc), "QUOTE")
+			}
+		case '"':
+			add("", "QUOTE")
+			for {
+				nc := one(b)
+				if nc == 0 {
+					panic(errors.New("unterminated \""))
+				}
+				if nc == '"' {
+					break
+				}
+				if nc == '\\' {
+					if nc = one(b); nc == 0 {
+						panic(errors.New("unterminated \""))
+					}
+					if strings.IndexByte("$`\"\\\n", nc) < 0 {
+						add("\\", "QUOTE")
+					}
+					if nc != '\n' {
+						add(string(nc), "QUOTE")
+					}
+					continue
+				}
+				if nc == '$' {
+					if name, ok := variable(b); ok {
+						w = append(w, arg{name, "QENV"})
+						continue
+					}
+				}
+				add(string(nc), "QUOTE")
+			}
+		case '$':
+			if name, ok := variable(b); ok {
+				w = append(w, arg{name, "ENV"})
+			} else {
+				add("$", "ARG")
 			}
-			if strings.Index(punct, string(c)) > -1 {
+		default:
+			if strings.IndexByte(punct, c) > -1 {
 				pushback(b)
-				return "ARG", arg
+				return w
 			}
-			arg = arg + string(c)
-			c = next(b)
+			add(string(c), "ARG")
 		}
+	}
+}
+
+// literal returns the text of w if it is plain text.
+func (w word) literal() (string, bool) {
+	if len(w) != 1 || w[0].mod != "ARG" {
+		return "", false
+	}
+	return w[0].val, true
+}
+
+// assignment splits w if it is NAME=value.
+func (w word) assignment() (string, word, bool) {
+	if len(w) == 0 || w[0].mod != "ARG" {
+		return "", nil, false
+	}
+	i := strings.IndexByte(w[0].val, '=')
+	if i < 0 || !validName(w[0].val[:i]) {
+		return "", nil, false
+	}
+	val := word{arg{w[0].val[i+1:], "QUOTE"}}
+	return w[0].val[:i], append(val, w[1:]...), true
+}
+
+// aliasWords returns the words of alias value s.
+func aliasWords(s string) []word {
+	b := bufio.NewReader(strings.NewReader(s))
+	var w []word
+	for t := tok(b); t.typ == "WORD"; t = tok(b) {
+		w = append(w, t.w)
+	}
+	return w
+}
+
+// A token is what tok reads. For everything but a word, the type is just
+// the thing itself, since we can switch on strings.
+type token struct {
+	typ string
+	val string
+	w   word
+}
 
+// keyword returns the word if t is one that may be a reserved word.
+func (t token) keyword() string {
+	if t.typ != "WORD" {
+		return ""
 	}
+	s, _ := t.w.literal()
+	return s
+}
 
+// redirect reads what follows a >: > to append, or nothing.
+func redirect(b *bufio.Reader, fd string) token {
+	switch one(b) {
+	case '>':
+		return token{typ: "APPEND", val: fd}
+	case 0:
+	default:
+		pushback(b)
+	}
+	return token{typ: "FD", val: fd}
 }
 
-// get an ARG. It has to work.
-func getArg(b *bufio.Reader, what string) string {
+// Tokenize stuff coming in from the stream.
+func tok(b *bufio.Reader) token {
 	for {
-		nt, s := tok(b)
-		if nt == "EOF" || nt == "EOL" {
-			panic(fmt.Errorf("%v requires an argument", what))
-		}
-		if nt == "white" {
+		c := one(b)
+		switch c {
+		case 0:
+			return token{typ: "EOF"}
+		case ' ', '\t':
 			continue
+		case '#':
+			for c != '\n' && c != 0 {
+				c = one(b)
+			}
+			if c == 0 {
+				return token{typ: "EOF"}
+			}
+			return token{typ: "EOL"}
+		case '\\':
+			if nb, _ := b.Peek(1); len(nb) == 1 && nb[0] == '\n' {
+				one(b)
+				continue
+			}
+		case '\n':
+			return token{typ: "EOL"}
+		case ';':
+			return token{typ: "SEMI", val: ";"}
+		case '>':
+			return redirect(b, "1")
+		case '<':
+			return token{typ: "FD", val: "0"}
+		case '|', '&':
+			// peek ahead.
+			nc := one(b)
+			if nc == c {
+				return token{typ: "LINK", val: string(c) + string(c)}
+			}
+			if nc != 0 {
+				pushback(b)
+			}
+			if c == '&' {
+				return token{typ: "BG", val: "&"}
+			}
+			return token{typ: "LINK", val: "|"}
 		}
-		if nt != "ARG" {
-			panic(fmt.Errorf("%v requires an argument, not %v", what, nt))
+		w := getWord(b, c)
+		// 2>file redirects file descriptor 2.
+		if s, ok := w.literal(); ok {
+			if _, err := strconv.Atoi(s); err == nil {
+				switch nc := one(b); nc {
+				case '>':
+					return redirect(b, s)
+				case '<':
+					return token{typ: "FD", val: s}
+				case 0:
+				default:
+					pushback(b)
+				}
+			}
 		}
-		return s
+		return token{typ: "WORD", w: w}
+	}
+}
+
+// A parser reads commands from b, one token ahead.
+type parser struct {
+	b      *bufio.Reader
+	peeked *token
+}
+
+func (p *parser) peek() token {
+	if p.peeked == nil {
+		t := tok(p.b)
+		p.peeked = &t
+	}
+	return *p.peeked
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.peeked = nil
+	return t
+}
+
+// newlines skips empty lines, which may follow && and the like.
+func (p *parser) newlines() {
+	for p.peek().typ == "EOL" {
+		p.next()
 	}
 }
-func parsestring(b *bufio.Reader, c *Command) (*Command, string) {
-	t, s := tok(b)
-	if s == "\n" || t == "EOF" || t == "EOL" {
-		return nil, t
+
+// get a word. It has to work.
+func (p *parser) getArg(what string) word {
+	t := p.next()
+	if t.typ == "EOF" || t.typ == "EOL" {
+		panic(fmt.Errorf("%v requires an argument", what))
 	}
+	if t.typ != "WORD" {
+		panic(fmt.Errorf("%v requires an argument, not %v", what, t.typ))
+	}
+	return t.w
+}
+
+// simple parses a simple command: assignments, words and redirections.
+func (p *parser) simple() *Command {
+	c := newCommand()
 	for {
-		switch t {
-		case "ENV":
-			if !path.IsAbs(s) {
-				s = filepath.Join(envDir, s)
-			}
-			b, err := ioutil.ReadFile(s)
-			if err != nil {
-				panic(fmt.Errorf("%s: %v", s, err))
+		t := p.peek()
+		switch t.typ {
+		case "WORD":
+			p.next()
+			if len(c.args) == 0 {
+				if name, val, ok := t.w.assignment(); ok {
+					c.assigns = append(c.assigns, assign{name, val})
+					continue
+				}
 			}
-			f := bufio.NewReader(bytes.NewReader(b))
-			// the whole string is consumed.
-			parsestring(f, c)
-		case "ARG":
-			c.args = append(c.args, arg{s, t})
-		case "white":
-		case "FD":
-			x := 0
-			_, err := fmt.Sscanf(s, "%v", &x)
+			c.args = append(c.args, t.w)
+		case "FD", "APPEND":
+			p.next()
+			x, err := strconv.Atoi(t.val)
 			if err != nil {
-				panic(fmt.Errorf("bad FD on redirect: %v, %v", s, err))
+				panic(fmt.Errorf("bad FD on redirect: %v, %v", t.val, err))
 			}
 			// whitespace is allowed
-			c.fdmap[x] = getArg(b, t)
-		// LINK and BG are similar save that LINK requires another command. If we don't get one, well.
-		case "LINK":
-			c.link = s
-			//fmt.Printf("LINK %v %v\n", c, s)
-			return c, t
-		case "BG":
-			c.bg = true
-			return c, t
-		case "EOF":
-			return c, t
-		case "EOL":
-			return c, t
+			c.fdmap[x] = redir{name: p.getArg("redirect"), append: t.typ == "APPEND"}
 		default:
-			panic(fmt.Errorf("unknown token type %v", t))
+			if len(c.args) == 0 && len(c.assigns) == 0 && len(c.fdmap) == 0 {
+				panic(errors.New("empty commands not allowed (yet)"))
+			}
+			return c
 		}
-		t, s = tok(b)
 	}
 }
-func parse(b *bufio.Reader) (*Command, string) {
-	//fmt.Printf("%v %v\n", t, s)
-	c := newCommand()
-	return parsestring(b, c)
+
+// pipeline parses commands joined by |, or a compound command.
+func (p *parser) pipeline() node {
+	switch k := p.peek().keyword(); {
+	case k == "if" || k == "while" || k == "until" || k == "for":
+		p.next()
+		n := p.compound(k)
+		if t := p.peek(); t.typ == "LINK" && t.val == "|" {
+			panic(fmt.Errorf("can't pipe from %s", k))
+		}
+		return n
+	case reserved[k]:
+		panic(fmt.Errorf("unexpected %s", k))
+	}
+	l := &pipeline{}
+	for {
+		c := p.simple()
+		l.cmds = append(l.cmds, c)
+		if t := p.peek(); t.typ != "LINK" || t.val != "|" {
+			return l
+		}
+		p.next()
+		c.link = "|"
+		p.newlines()
+		// the rules.
+		// Can't have a redir and a redirect for fd1.
+		if _, ok := c.fdmap[1]; ok {
+			panic(errors.New("Can't have a pipe and > on one command"))
+		}
+		if t := p.peek(); t.typ == "EOF" || t.typ == "EOL" {
+			panic(errors.New("Can't have a pipe to nowhere"))
+		}
+		if k := p.peek().keyword(); k == "if" || k == "while" || k == "until" || k == "for" {
+			panic(fmt.Errorf("can't pipe to %s", k))
+		}
+	}
 }
 
-func newCommand() *Command {
-	return &Command{fdmap: make(map[int]string), files: make(map[int]io.Closer)}
+// andOr parses pipelines joined by && and ||.
+func (p *parser) andOr() *andOr {
+	a := &andOr{}
+	for {
+		a.nodes = append(a.nodes, p.pipeline())
+		t := p.peek()
+		if t.typ != "LINK" || t.val == "|" {
+			return a
+		}
+		p.next()
+		a.links = append(a.links, t.val)
+		p.newlines()
+	}
 }
 
-// Just eat it up until you have all the commands you need.
-func parsecommands(b *bufio.Reader) ([]*Command, string) {
-	cmds := make([]*Command, 0)
+// separator reads what follows an andOr at the end of a list item.
+func (p *parser) separator(a *andOr) {
+	switch t := p.peek(); t.typ {
+	case "SEMI", "EOL":
+		p.next()
+	case "BG":
+		p.next()
+		a.bg = true
+	case "EOF":
+	default:
+		panic(fmt.Errorf("unexpected %s", t.typ))
+	}
+}
+
+// list parses commands, across lines, up to one of the words in ends,
+// and returns which it was.
+func (p *parser) list(ends ...string) ([]node, string) {
+	var l []node
 	for {
-		c, t := parse(b)
-		if c == nil {
-			return cmds, t
+		t := p.peek()
+		if t.typ == "EOF" {
+			panic(fmt.Errorf("unexpected end of input, want %s", strings.Join(ends, " or ")))
+		}
+		if t.typ == "EOL" || t.typ == "SEMI" && len(l) > 0 {
+			p.next()
+			continue
 		}
-		//fmt.Printf("cmd  %v\n", *c)
-		cmds = append(cmds, c)
-		if t == "EOF" || t == "EOL" {
-			return cmds, t
+		for _, e := range ends {
+			if t.keyword() == e {
+				p.next()
+				return l, e
+			}
 		}
+		a := p.andOr()
+		l = append(l, a)
+		p.separator(a)
 	}
 }
 
-func getCommand(b *bufio.Reader) (c []*Command, t string, err error) {
-	defer func() {
-		if e := recover(); e != nil {
-			err = e.(error)
+// compound parses the rest of a command that starts with the word k.
+func (p *parser) compound(k string) node {
+	switch k {
+	case "if":
+		n := &ifNode{}
+		for {
+			cond, _ := p.list("then")
+			body, end := p.list("elif", "else", "fi")
+			n.conds = append(n.conds, cond)
+			n.bodies = append(n.bodies, body)
+			switch end {
+			case "else":
+				n.els, _ = p.list("fi")
+			case "elif":
+				continue
+			}
+			return n
 		}
-	}()
+	case "while", "until":
+		n := &loopNode{until: k == "until"}
+		n.cond, _ = p.list("do")
+		n.body, _ = p.list("done")
+		return n
+	}
+	n := &forNode{}
+	name, ok := p.getArg("for").literal()
+	if !ok || !validName(name) {
+		panic(fmt.Errorf("for: bad variable name"))
+	}
+	n.name = name
+	if p.peek().typ == "SEMI" {
+		p.next()
+	}
+	p.newlines()
+	switch p.peek().keyword() {
+	case "in":
+		p.next()
+		for p.peek().typ == "WORD" {
+			n.words = append(n.words, p.next().w)
+		}
+		if t := p.next(); t.typ != "SEMI" && t.typ != "EOL" {
+			panic(fmt.Errorf("for: unexpected %s", t.typ))
+		}
+	case "do":
+		n.words = []word{{{"@", "QENV"}}}
+	default:
+		panic(errors.New("for: want in or do"))
+	}
+	p.newlines()
+	if p.next().keyword() != "do" {
+		panic(errors.New("for: want do"))
+	}
+	n.body, _ = p.list("done")
+	return n
+}
 
-	// TODO: put a recover here that just returns an error.
-	c, t = parsecommands(b)
-	// the rules.
-	// For now, no empty commands.
-	// Can't have a redir and a redirect for fd1.
-	for i, v := range c {
-		if len(v.args) == 0 {
-			return nil, "", errors.New("empty commands not allowed (yet)")
+// line parses the commands up to the end of the line, or, if a compound
+// command goes on, of the line that ends it.
+func (p *parser) line() ([]node, string) {
+	var l []node
+	for {
+		switch t := p.peek(); t.typ {
+		case "EOF", "EOL":
+			p.next()
+			return l, t.typ
 		}
-		if v.link == "|" && v.fdmap[1] != "" {
-			return nil, "", errors.New("Can't have a pipe and > on one command")
+		a := p.andOr()
+		l = append(l, a)
+		switch t := p.next(); t.typ {
+		case "EOF", "EOL":
+			return l, t.typ
+		case "SEMI":
+		case "BG":
+			a.bg = true
+		default:
+			panic(fmt.Errorf("unexpected %s", t.typ))
 		}
-		if v.link == "|" && i == len(c)-1 {
-			return nil, "", errors.New("Can't have a pipe to nowhere")
+	}
+}
+
+func newCommand() *Command {
+	return &Command{fdmap: make(map[int]redir), files: make(map[int]io.Closer)}
+}
+
+// copyNodes returns a copy of l that the shell can run while l runs too.
+// What the parser filled in is shared, as the shell only reads it.
+func copyNodes(l []node) []node {
+	var c []node
+	for _, n := range l {
+		c = append(c, copyNode(n))
+	}
+	return c
+}
+
+func copyNode(n node) node {
+	switch n := n.(type) {
+	case *pipeline:
+		p := &pipeline{}
+		for _, c := range n.cmds {
+			p.cmds = append(p.cmds, &Command{args: c.args, assigns: c.assigns, fdmap: c.fdmap, files: make(map[int]io.Closer), link: c.link, bg: c.bg})
 		}
-		if i < len(c)-1 && v.link == "|" && c[i+1].fdmap[0] != "" {
-			return nil, "", errors.New("Can't have a pipe to command with redirect on stdin")
+		return p
+	case *andOr:
+		a := *n
+		a.nodes = copyNodes(n.nodes)
+		return &a
+	case *ifNode:
+		i := &ifNode{els: copyNodes(n.els)}
+		for k := range n.conds {
+			i.conds = append(i.conds, copyNodes(n.conds[k]))
+			i.bodies = append(i.bodies, copyNodes(n.bodies[k]))
 		}
+		return i
+	case *loopNode:
+		return &loopNode{cond: copyNodes(n.cond), body: copyNodes(n.body), until: n.until}
+	case *forNode:
+		return &forNode{name: n.name, words: n.words, body: copyNodes(n.body)}
 	}
-	return c, t, err
+	panic(fmt.Sprintf("unknown node %T", n))
 }
 
-/*
-func main() {
-	b := bufio.NewReader(os.Stdin)
-	for {
-	    c, t, err := getCommand(b)
-		fmt.Printf("%v %v %v\n", c, t, err)
-	    if t == "EOF" {
-	       break
-	       }
-	       }
-}
-*/
+// getCommand reads the commands on the next line.
+func getCommand(b *bufio.Reader) (c []node, t string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = e.(error)
+		}
+	}()
+
+	p := &parser{b: b}
+	c, t = p.line()
+	return c, t, err
+}
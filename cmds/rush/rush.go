@@ -4,42 +4,102 @@
 
 // Rush is an interactive shell similar to sh.
 //
+// Synopsis:
+//     rush [-c COMMANDS [NAME [ARGS...]]]
+//     rush FILE [ARGS...]
+//
 // Description:
-//     Prompt is '% '.
+//     Without arguments, rush reads commands from stdin. Prompt is '% '.
+//     With -c, it runs COMMANDS, and with a FILE, the commands in it. NAME
+//     or FILE is $0, and ARGS are $1 on. The exit status of a script is that
+//     of the last command.
+//
+//     Commands are separated by newlines, ; or &, which runs them in the
+//     background, and joined by |, && and ||. There are
+//         if LIST; then LIST; [elif LIST; then LIST;]... [else LIST;] fi
+//         while LIST; do LIST; done
+//         until LIST; do LIST; done
+//         for NAME [in WORDS...]; do LIST; done
+//     NAME=value sets a variable, or, before a command, its environment.
+//     $NAME, ${NAME}, $?, $$, $#, $@ and $0 to $9 expand, also in "...".
+//     Variables that are not set are read from files in /env.
+//
+//     Builtins, like cd, echo, export, read and test, are those of package
+//     github.com/u-root/u-root/pkg/shell/builtins, and exit, shift, time,
+//     history, jobs, fg and bg. An alias, set with alias NAME=VALUE, stands
+//     for the words of VALUE as the first word of a command.
+//
+//     Other lists followed by & run in the background in the shell itself,
+//     and share its variables.
+//
+//     A command or pipeline followed by & is a job. On a terminal, each
+//     job is a process group, and ^Z stops the one in the foreground.
+//     jobs lists the jobs, fg and bg continue one, %N, %+ (the current
+//     one) or %-, in the foreground or background, and wait waits for them.
+//
+//     On a terminal, lines can be edited emacs-style, ^P and ^N go through
+//     the history, which is kept in $HOME/.rush_history, and TAB completes
+//     commands and file names.
+//
+// Options:
+//     -c: run COMMANDS
 package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/exec"
-	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 	"syscall"
+
+	"github.com/u-root/u-root/pkg/shell/builtins"
+	"github.com/u-root/u-root/pkg/termios"
 )
 
 type builtin func(c *Command) error
 
 // TODO: probably have one builtin map and use it for both types?
 var (
-	urpath   = "/go/bin:/ubin:/buildbin:/bbin:/bin:/usr/local/bin:"
-	builtins = make(map[string]builtin)
+	urpath = "/go/bin:/ubin:/buildbin:/bbin:/bin:/usr/local/bin:"
+	// shellBuiltins are the builtins that need the Command, like time.
+	// Others are in package builtins.
+	shellBuiltins = make(map[string]builtin)
 
 	// the environment dir is INTENDED to be per-user and bound in
 	// a private name space at /env.
 	envDir = "/env"
+
+	cmdString = flag.String("c", "", "Run the commands in the string")
+
+	// mu is held by whatever runs shell code: main, or a list in the
+	// background. It is let go while waiting for processes or input, so
+	// that lists run at the same time share variables, jobs and the rest
+	// of the shell one at a time.
+	mu sync.Mutex
 )
 
 func addBuiltIn(name string, f builtin) error {
-	if _, ok := builtins[name]; ok {
+	if _, ok := shellBuiltins[name]; ok {
 		return fmt.Errorf("%v already a builtin", name)
 	}
-	builtins[name] = f
+	shellBuiltins[name] = f
 	return nil
 }
 
+func isBuiltin(name string) bool {
+	if _, ok := shellBuiltins[name]; ok {
+		return true
+	}
+	_, ok := builtins.Lookup(name)
+	return ok
+}
+
 func wire(cmds []*Command) error {
 	for i, c := range cmds {
 		// IO defaults.
@@ -62,97 +122,150 @@ func wire(cmds []*Command) error {
 		if c.link != "|" {
 			continue
 		}
-		w, err := cmds[i+1].StdinPipe()
-		if err != nil {
-			return err
-		}
-		r, err := cmds[i].StdoutPipe()
+		r, w, err := os.Pipe()
 		if err != nil {
 			return err
 		}
-		// Oh, yuck.
-		// There seems to be no way to do the classic
-		// inherited pipes thing in Go. Hard to believe.
-		go func() {
-			io.Copy(w, r)
-			w.Close()
-		}()
+		c.Stdout = w
+		cmds[i+1].Stdin = r
+		c.pipes = append(c.pipes, w)
+		cmds[i+1].pipes = append(cmds[i+1].pipes, r)
 	}
 	return nil
 }
 
-func runit(c *Command) error {
+// exitStatus returns the exit status of a command that returned err.
+func exitStatus(err error) int {
+	switch err := err.(type) {
+	case nil:
+		return 0
+	case builtins.ExitStatus:
+		return int(err)
+	case *exec.ExitError:
+		if ws, ok := err.Sys().(syscall.WaitStatus); ok {
+			if ws.Signaled() {
+				return 128 + int(ws.Signal())
+			}
+			return ws.ExitStatus()
+		}
+	}
+	return 1
+}
+
+// start starts c, or runs it if it is a builtin.
+func start(c *Command) error {
 	defer func() {
-		for fd, f := range c.files {
-			f.Close()
-			delete(c.files, fd)
+		for _, p := range c.pipes {
+			p.Close()
 		}
 	}()
-	if b, ok := builtins[c.cmd]; ok {
-		if err := b(c); err != nil {
-			return err
-		}
-	} else {
+	if b, ok := shellBuiltins[c.cmd]; ok {
+		return b(c)
+	}
+	if b, ok := builtins.Lookup(c.cmd); ok {
+		return b.Run(&builtins.Context{
+			Args:   append([]string{c.cmd}, c.argv...),
+			Stdin:  c.Stdin,
+			Stdout: c.Stdout,
+			Stderr: c.Stderr,
+			Shell:  rushShell{},
+		})
+	}
+	if c.Cmd.SysProcAttr == nil {
 		c.Cmd.SysProcAttr = &syscall.SysProcAttr{}
-		if c.bg {
-			c.Cmd.SysProcAttr.Setpgid = true
-		} else {
-			c.Cmd.SysProcAttr.Foreground = true
-			c.Cmd.SysProcAttr.Ctty = int(ttyf.Fd())
-		}
-		if err := c.Start(); err != nil {
-			return fmt.Errorf("%v: Path %v", err, os.Getenv("PATH"))
-		}
-		if err := c.Wait(); err != nil {
-			return fmt.Errorf("wait: %v", err)
-		}
+	}
+	// With job control, each pipeline is a process group, and the
+	// terminal is given to the one in the foreground.
+	if c.bg || ttyf != nil {
+		c.Cmd.SysProcAttr.Setpgid = true
+		c.Cmd.SysProcAttr.Pgid = c.pgid
+	}
+	if !c.bg && ttyf != nil {
+		c.Cmd.SysProcAttr.Foreground = true
+		c.Cmd.SysProcAttr.Ctty = int(ttyf.Fd())
+	}
+	if err := c.Start(); err != nil {
+		return fmt.Errorf("%v: Path %v", err, os.Getenv("PATH"))
 	}
 	return nil
 }
 
+func closeFiles(c *Command) {
+	for fd, f := range c.files {
+		f.Close()
+		delete(c.files, fd)
+	}
+}
+
+// wait waits for c to finish.
+func wait(c *Command) error {
+	defer closeFiles(c)
+	mu.Unlock()
+	defer mu.Lock()
+	return c.Wait()
+}
+
+func runit(c *Command) error {
+	if isBuiltin(c.cmd) {
+		defer closeFiles(c)
+		return start(c)
+	}
+	if err := start(c); err != nil {
+		closeFiles(c)
+		return err
+	}
+	return wait(c)
+}
+
 func openRead(c *Command, r io.Reader, fd int) (io.Reader, error) {
-	if c.fdmap[fd] != "" {
-		f, err := os.Open(c.fdmap[fd])
+	if n, ok := c.fdmap[fd]; ok {
+		f, err := os.Open(expandString(n.name))
+		if err != nil {
+			return nil, err
+		}
 		c.files[fd] = f
-		return f, err
+		return f, nil
 	}
 	return r, nil
 }
 
 func openWrite(c *Command, w io.Writer, fd int) (io.Writer, error) {
-	if c.fdmap[fd] != "" {
-		f, err := os.Create(c.fdmap[fd])
+	if n, ok := c.fdmap[fd]; ok {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if n.append {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(expandString(n.name), flags, 0666)
+		if err != nil {
+			return nil, err
+		}
 		c.files[fd] = f
-		return f, err
+		return f, nil
 	}
 	return w, nil
 }
 
 func doArgs(cmds []*Command) error {
 	for _, c := range cmds {
-		globargv := []string{}
-		for _, v := range c.args {
-			if v.mod == "ENV" {
-				e := v.val
-				if !path.IsAbs(v.val) {
-					e = filepath.Join(envDir, e)
+		args := c.args
+		// An alias stands for the words of its value.
+		if len(args) > 0 {
+			if s, ok := args[0].literal(); ok {
+				if a, ok := aliases[s]; ok {
+					args = append(aliasWords(a), args[1:]...)
 				}
-				b, err := ioutil.ReadFile(e)
-				if err != nil {
-					return err
-				}
-				// It goes in as one argument. Not sure if this is what we want
-				// but it gets very weird to start splitting it on spaces. Or maybe not?
-				globargv = append(globargv, string(b))
-			} else if globs, err := filepath.Glob(v.val); err == nil && len(globs) > 0 {
-				globargv = append(globargv, globs...)
-			} else {
-				globargv = append(globargv, v.val)
 			}
 		}
-
-		c.cmd = globargv[0]
-		c.argv = globargv[1:]
+		argv := expand(args)
+		if len(argv) == 0 {
+			if len(cmds) > 1 {
+				return errors.New("empty commands not allowed in a pipe")
+			}
+			c.cmd, c.argv = "", nil
+			continue
+		}
+		c.cmd = argv[0]
+		c.argv = argv[1:]
 	}
 	return nil
 }
@@ -164,80 +277,238 @@ func doArgs(cmds []*Command) error {
 func commands(cmds []*Command) error {
 	for _, c := range cmds {
 		c.Cmd = exec.Command(c.cmd, c.argv[:]...)
+		if len(c.assigns) > 0 {
+			c.Cmd.Env = os.Environ()
+			for _, a := range c.assigns {
+				c.Cmd.Env = append(c.Cmd.Env, a.name+"="+expandString(a.val))
+			}
+		}
 		// this is a Very Special Case related to a Go issue.
 		// we're not able to unshare correctly in builtin.
 		// Not sure of the issue but this hack will have to do until
 		// we understand it. Barf.
 		if c.cmd == "builtin" {
-			c.Cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNS
+			c.Cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS}
 		}
 	}
 	return nil
 }
 
-func command(c *Command) error {
-	// for now, bg will just happen in background.
-	if c.bg {
-		go func() {
-			if err := runit(c); err != nil {
-				fmt.Fprintf(os.Stderr, "%v", err)
+// runPipeline runs the commands of p and returns the exit status of the
+// last one. In the background, the commands do not get the terminal and,
+// with async, they are left running as a job.
+func runPipeline(p *pipeline, bg, async bool) int {
+	cmds := p.cmds
+	for _, c := range cmds {
+		c.bg = bg
+	}
+	if err := doArgs(cmds); err != nil {
+		fmt.Fprintf(os.Stderr, "args problem: %v\n", err)
+		return 1
+	}
+	// A command of only assignments sets variables.
+	if c := cmds[0]; c.cmd == "" {
+		for _, a := range c.assigns {
+			setVar(a.name, expandString(a.val))
+		}
+		return 0
+	}
+	if err := commands(cmds); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if err := wire(cmds); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	j := &job{text: jobText(cmds), cmds: cmds, done: make([]bool, len(cmds))}
+	errs := make([]error, len(cmds))
+	for i, c := range cmds {
+		// Builtins, which may change c.cmd, are done once started.
+		j.done[i] = isBuiltin(c.cmd)
+		c.pgid = j.pgid
+		errs[i] = start(c)
+		if errs[i] != nil {
+			// Commands say why they failed themselves.
+			switch errs[i].(type) {
+			case builtins.ExitStatus, *exec.ExitError:
+			default:
+				fmt.Fprintf(os.Stderr, "%v\n", errs[i])
+			}
+			if !j.done[i] {
+				errs[i] = builtins.ExitStatus(127)
+				j.done[i] = true
 			}
+		}
+		if j.done[i] {
+			closeFiles(c)
+		} else if j.pgid == 0 {
+			j.pgid = c.Process.Pid
+		}
+	}
+	last := len(cmds) - 1
+	if j.done[last] {
+		j.status = exitStatus(errs[last])
+	}
+	switch {
+	case j.finished():
+	case async:
+		addJob(j)
+		if ttyf != nil {
+			fmt.Fprintf(os.Stderr, "[%d] %d\n", j.id, j.pgid)
+		}
+		return 0
+	case !bg && ttyf != nil:
+		return waitForeground(j)
+	default:
+		j.wait(true)
+	}
+	return j.status
+}
+
+// run runs a list of nodes, and returns the exit status of the last.
+func run(l []node) int {
+	for _, n := range l {
+		status = runNode(n)
+	}
+	return status
+}
+
+func runNode(n node) int {
+	switch n := n.(type) {
+	case *pipeline:
+		return runPipeline(n, false, false)
+	case *andOr:
+		if !n.bg {
+			return runAndOr(n)
+		}
+		// A pipeline in the background is a job. Other lists run in
+		// the background as they are. Either gets its own copy, as n
+		// may run again, as in a loop, before it is done.
+		n = copyNode(n).(*andOr)
+		if p, ok := n.nodes[0].(*pipeline); ok && len(n.nodes) == 1 {
+			return runPipeline(p, true, true)
+		}
+		go func() {
+			mu.Lock()
+			defer mu.Unlock()
+			runAndOr(n)
 		}()
-	} else {
-		err := runit(c)
-		return err
+		return 0
+	case *ifNode:
+		for i, c := range n.conds {
+			if run(c) == 0 {
+				return run(n.bodies[i])
+			}
+		}
+		if n.els != nil {
+			return run(n.els)
+		}
+		return 0
+	case *loopNode:
+		st := 0
+		for (run(n.cond) == 0) != n.until {
+			st = run(n.body)
+		}
+		return st
+	case *forNode:
+		st := 0
+		for _, v := range expand(n.words) {
+			setVar(n.name, v)
+			st = run(n.body)
+		}
+		return st
 	}
-	return nil
+	panic(fmt.Sprintf("unknown node %T", n))
 }
 
-func main() {
-	if len(os.Args) != 1 {
-		fmt.Println("no scripts/args yet")
-		os.Exit(1)
+func runAndOr(a *andOr) int {
+	run := func(n node) int {
+		if p, ok := n.(*pipeline); ok {
+			return runPipeline(p, a.bg, false)
+		}
+		return runNode(n)
+	}
+	st := run(a.nodes[0])
+	for i, l := range a.links {
+		status = st
+		if (l == "&&") == (st == 0) {
+			st = run(a.nodes[i+1])
+		}
 	}
+	return st
+}
 
-	b := bufio.NewReader(os.Stdin)
-	tty()
-	fmt.Printf("%% ")
+// script runs the commands read from b, and returns the exit status of
+// the last one. It stops at a syntax error.
+func script(b *bufio.Reader) int {
 	for {
-		foreground()
-		cmds, status, err := getCommand(b)
+		l, t, err := getCommand(b)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
 		}
-		if err := doArgs(cmds); err != nil {
-			fmt.Fprintf(os.Stderr, "args problem: %v\n", err)
-			continue
+		run(l)
+		if t == "EOF" {
+			return status
 		}
-		if err := commands(cmds); err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-			continue
+	}
+}
+
+func main() {
+	flag.Parse()
+	mu.Lock()
+	args := flag.Args()
+	switch {
+	case *cmdString != "":
+		if len(args) > 0 {
+			positional = args
 		}
-		if err := wire(cmds); err != nil {
+		os.Exit(script(bufio.NewReader(strings.NewReader(*cmdString))))
+	case len(args) > 0:
+		f, err := os.Open(args[0])
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
-			continue
+			os.Exit(127)
 		}
-		for _, c := range cmds {
-			if err := command(c); err != nil {
-				fmt.Fprintf(os.Stderr, "%v\n", err)
-				if c.link == "||" {
-					continue
-				}
-				// yes, not needed, but useful so you know
-				// what goes on here.
-				if c.link == "&&" {
-					break
-				}
-				break
-			} else {
-				if c.link == "||" {
-					break
-				}
-			}
+		positional = args
+		os.Exit(script(bufio.NewReader(f)))
+	}
+
+	var in io.Reader = os.Stdin
+	tty()
+	// Edit lines if the input is a terminal.
+	if _, err := termios.GetTermios(os.Stdin.Fd()); err == nil {
+		ed = newEditor(os.Stdin, os.Stdout, int(os.Stdin.Fd()))
+		if h, ok := os.LookupEnv("HOME"); ok {
+			ed.loadHistory(filepath.Join(h, ".rush_history"))
 		}
-		if status == "EOF" {
+		in = ed
+	}
+	b := bufio.NewReader(in)
+	prompt()
+	for {
+		foreground()
+		mu.Unlock()
+		l, t, err := getCommand(b)
+		mu.Lock()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		run(l)
+		if t == "EOF" {
 			break
 		}
-		fmt.Printf("%% ")
+		notify()
+		prompt()
 	}
 }
+
+// prompt prompts for a command. The editor prints the prompt itself.
+func prompt() {
+	if ed != nil {
+		ed.prompt = "% "
+		return
+	}
+	fmt.Printf("%% ")
+}
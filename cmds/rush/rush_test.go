@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
@@ -22,8 +23,6 @@ var tests = []struct {
 	stderr string // output (regular expression)
 	ret    int    // output
 }{
-	// TODO: Create a `-c` flag for rush so stdout does not contain
-	// prompts, or have the prompt be derived from $PS1.
 	{"exit\n", "% ", "", 0},
 	{"exit 77\n", "% ", "", 77},
 	{"exit 1 2 3\n", "% % ", "Too many arguments\n", 0},
@@ -72,6 +71,77 @@ func TestRush(t *testing.T) {
 	}
 }
 
+var scriptTests = []struct {
+	script string
+	args   []string
+	stdout string
+	stderr string
+	ret    int
+}{
+	{script: "echo a; echo b", stdout: "a\nb\n"},
+	{script: "x=1 y='2 3'\necho $x \"$y\" ${x}0", stdout: "1 2 3 10\n"},
+	{script: "for i in a 'b c' d; do echo $i; done", stdout: "a\nb c\nd\n"},
+	{script: "for i; do echo $i; done; echo $#", args: []string{"name", "x", "y z"}, stdout: "x\ny z\n2\n"},
+	{script: "n=\nwhile [ \"$n\" != xxx ]; do n=x$n; done; echo $n", stdout: "xxx\n"},
+	{script: "until test -n \"$n\"; do n=1; done; echo $n", stdout: "1\n"},
+	{script: "if [ a = b ]; then echo 1; elif [ 1 -lt 2 ]; then echo 2; else echo 3; fi", stdout: "2\n"},
+	{script: "if false\nthen\n  echo 1\nelse\n  echo 3\nfi", stdout: "3\n"},
+	{script: "false && echo a || echo b; true || echo c && echo d", stdout: "b\nd\n"},
+	{script: "false; echo $?; true; echo $?", stdout: "1\n0\n"},
+	{script: "X=1 printenv X; export Y=2; printenv Y", stdout: "1\n2\n"},
+	{script: "echo a'b'\"c\"\\ d # comment", stdout: "abc d\n"},
+	{script: "exit 3", ret: 3},
+	{script: "test -d /", ret: 0},
+	{script: "[ -f / ]", ret: 1},
+	{script: "if true; then", stderr: "unexpected end of input, want elif or else or fi\n", ret: 2},
+	{script: "done", stderr: "unexpected done\n", ret: 2},
+	{script: "sleep 0.1 & jobs; wait; echo $?", stdout: "[1]+  Running                 sleep 0.1\n0\n"},
+	{script: "false & true & wait %1; echo $?; wait; jobs", stdout: "1\n"},
+	{script: "echo a | false; echo $?; false | echo b", stdout: "1\nb\n"},
+	{script: "for i in 1 2 3; do true && sleep 0.1 && echo x & done; sleep 0.5", stdout: "x\nx\nx\n"},
+	{script: "fg", stderr: "fg: no jobs\n", ret: 1},
+	{script: "alias say='echo -n said'\nsay it; printf ' %s=%d\\n' x 1", stdout: "said it x=1\n"},
+	{script: "echo 'a  b' | read x y; echo $x,$y; pwd >/dev/null; unset x; echo \"$x\"", stdout: "a,b\n\n"},
+	{script: "f=/tmp/rush$$; echo 'echo $1 $2' >$f; . $f a b; rm $f", stdout: "a b\n"},
+}
+
+func TestScript(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestScript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for i, tt := range scriptTests {
+		t.Run(fmt.Sprintf("test%d", i), func(t *testing.T) {
+			// Run the script with -c and from a file.
+			file := filepath.Join(tmpDir, fmt.Sprintf("script%d", i))
+			if err := ioutil.WriteFile(file, []byte(tt.script), 0644); err != nil {
+				t.Fatal(err)
+			}
+			args := tt.args
+			if len(args) > 0 {
+				args = args[1:]
+			}
+			for _, c := range [][]string{
+				append([]string{"-c", tt.script}, tt.args...),
+				append([]string{file}, args...),
+			} {
+				cmd := testutil.Command(t, c...)
+				var stdout, stderr bytes.Buffer
+				cmd.Stdout, cmd.Stderr = &stdout, &stderr
+				err := cmd.Run()
+				if stdout.String() != tt.stdout || stderr.String() != tt.stderr {
+					t.Errorf("rush %q: got %q, %q, want %q, %q", c, stdout.String(), stderr.String(), tt.stdout, tt.stderr)
+				}
+				if err := testutil.IsExitCode(err, tt.ret); err != nil {
+					t.Errorf("rush %q: %v", c, err)
+				}
+			}
+		})
+	}
+}
+
 func TestMain(m *testing.M) {
 	testutil.Run(m, main)
 }
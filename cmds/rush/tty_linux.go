@@ -17,6 +17,7 @@ import (
 var (
 	ttypgrp int
 	ttyf    *os.File
+	jobSigs = make(chan os.Signal, 1)
 )
 
 // tty does whatever needs to be done to set up a tty for GOOS.
@@ -25,12 +26,18 @@ func tty() {
 
 	sigs := make(chan os.Signal, 512)
 	signal.Notify(sigs, os.Interrupt)
-	signal.Ignore(unix.SIGTTOU)
 	go func() {
 		for i := range sigs {
 			fmt.Println(i)
 		}
 	}()
+	// The job control signals must not stop the shell. They are caught
+	// rather than ignored, since commands inherit ignored signals.
+	signal.Notify(jobSigs, unix.SIGTSTP, unix.SIGTTIN, unix.SIGTTOU)
+	go func() {
+		for range jobSigs {
+		}
+	}()
 
 	// N.B. We can continue to use this file, in the foreground function,
 	// but the runtime closes it on exec for us.
@@ -50,13 +57,22 @@ func tty() {
 	}
 }
 
+// foreground takes the terminal back for the shell.
 func foreground() {
-	// Place process group in foreground.
-	if ttypgrp != 0 {
-		_, _, errno := unix.RawSyscall(unix.SYS_IOCTL, ttyf.Fd(), uintptr(unix.TIOCSPGRP), uintptr(unsafe.Pointer(&ttypgrp)))
-		if errno != 0 {
-			log.Printf("rush pid %v: Can't set foreground to %v: %v", os.Getpid(), ttypgrp, errno)
-		}
-	}
+	setForeground(ttypgrp)
+}
 
+// setForeground places process group pgrp in the foreground.
+func setForeground(pgrp int) {
+	if ttypgrp == 0 {
+		return
+	}
+	// A process group in the background that sets the foreground gets
+	// SIGTTOU unless it ignores it.
+	signal.Ignore(unix.SIGTTOU)
+	defer signal.Notify(jobSigs, unix.SIGTTOU)
+	_, _, errno := unix.RawSyscall(unix.SYS_IOCTL, ttyf.Fd(), uintptr(unix.TIOCSPGRP), uintptr(unsafe.Pointer(&pgrp)))
+	if errno != 0 {
+		log.Printf("rush pid %v: Can't set foreground to %v: %v", os.Getpid(), pgrp, errno)
+	}
 }
@@ -0,0 +1,245 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	// vars are the shell variables that are not exported. Exported ones
+	// are only in the environment.
+	vars = make(map[string]string)
+	// positional are the name of the script, $0, and its arguments.
+	positional = []string{"rush"}
+	// status is the exit status of the last command, $?.
+	status int
+	// aliases are set with the alias builtin.
+	aliases = make(map[string]string)
+)
+
+func init() {
+	addBuiltIn("shift", shift)
+}
+
+// lookup returns the value of variable name. Variables not set in the
+// shell or the environment are read from the environment directory.
+func lookup(name string) string {
+	switch name {
+	case "?":
+		return strconv.Itoa(status)
+	case "$":
+		return strconv.Itoa(os.Getpid())
+	case "#":
+		return strconv.Itoa(len(positional) - 1)
+	case "@", "*":
+		return strings.Join(positional[1:], " ")
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		if n < len(positional) {
+			return positional[n]
+		}
+		return ""
+	}
+	if v, ok := vars[name]; ok {
+		return v
+	}
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	e := name
+	if !path.IsAbs(e) {
+		e = filepath.Join(envDir, e)
+	}
+	if b, err := ioutil.ReadFile(e); err == nil {
+		return strings.TrimSuffix(string(b), "\n")
+	}
+	return ""
+}
+
+// setVar sets a variable, in the environment if it is exported.
+func setVar(name, val string) {
+	if _, ok := os.LookupEnv(name); ok {
+		os.Setenv(name, val)
+		return
+	}
+	vars[name] = val
+}
+
+// A field is an argument being expanded. Only fields with no quotes are
+// globbed.
+type field struct {
+	s      string
+	quoted bool
+}
+
+// expand expands the variables in words, splits unquoted ones at blanks
+// and globs.
+func expand(words []word) []string {
+	var out []string
+	for _, w := range words {
+		var f []field
+		// cur is the field being added to, if there is one.
+		cur := func() *field {
+			if len(f) == 0 {
+				f = append(f, field{})
+			}
+			return &f[len(f)-1]
+		}
+		// split tells the next text to start a new field.
+		split := false
+		add := func(s string, quoted bool) {
+			if split {
+				f = append(f, field{})
+				split = false
+			}
+			c := cur()
+			c.s += s
+			c.quoted = c.quoted || quoted
+		}
+		for _, a := range w {
+			switch a.mod {
+			case "ARG":
+				add(a.val, false)
+			case "QUOTE":
+				add(a.val, true)
+			case "QENV":
+				if a.val != "@" {
+					add(lookup(a.val), true)
+					break
+				}
+				// "$@" is each argument as is.
+				for i, p := range positional[1:] {
+					if i > 0 {
+						split = true
+					}
+					add(p, true)
+				}
+			case "ENV":
+				v := lookup(a.val)
+				if v == "" {
+					break
+				}
+				if strings.IndexAny(v[:1], " \t\n") > -1 {
+					split = len(f) > 0
+				}
+				for i, s := range strings.Fields(v) {
+					if i > 0 {
+						split = true
+					}
+					add(s, false)
+				}
+				if strings.IndexAny(v[len(v)-1:], " \t\n") > -1 {
+					split = true
+				}
+			}
+		}
+		for _, a := range f {
+			if !a.quoted {
+				if globs, err := filepath.Glob(a.s); err == nil && len(globs) > 0 {
+					out = append(out, globs...)
+					continue
+				}
+				if a.s == "" {
+					continue
+				}
+			}
+			out = append(out, a.s)
+		}
+	}
+	return out
+}
+
+// expandString expands the variables in w without splitting or globbing,
+// as for the value of an assignment.
+func expandString(w word) string {
+	var s string
+	for _, a := range w {
+		switch a.mod {
+		case "ARG", "QUOTE":
+			s += a.val
+		default:
+			s += lookup(a.val)
+		}
+	}
+	return s
+}
+
+// rushShell is the shell for the builtins of package builtins.
+type rushShell struct{}
+
+func (rushShell) Get(name string) (string, bool) {
+	if v, ok := vars[name]; ok {
+		return v, true
+	}
+	return os.LookupEnv(name)
+}
+
+func (rushShell) Set(name, value string) {
+	setVar(name, value)
+}
+
+func (rushShell) Export(name string) {
+	val, ok := vars[name]
+	if !ok {
+		val = os.Getenv(name)
+	}
+	delete(vars, name)
+	os.Setenv(name, val)
+}
+
+func (rushShell) Unset(name string) {
+	delete(vars, name)
+	os.Unsetenv(name)
+}
+
+func (rushShell) Environ() []string {
+	return os.Environ()
+}
+
+func (rushShell) Aliases() map[string]string {
+	return aliases
+}
+
+func (rushShell) Source(r io.Reader, args []string) int {
+	if len(args) > 0 {
+		defer func(p []string) {
+			positional = p
+		}(positional)
+		positional = append([]string{positional[0]}, args...)
+	}
+	return script(bufio.NewReader(r))
+}
+
+func (rushShell) Wait(jobs []string) (int, error) {
+	return waitJobs(jobs)
+}
+
+func shift(c *Command) error {
+	n := 1
+	if len(c.argv) > 1 {
+		return errors.New("usage: shift [n]")
+	}
+	if len(c.argv) == 1 {
+		var err error
+		if n, err = strconv.Atoi(c.argv[0]); err != nil || n < 0 {
+			return fmt.Errorf("shift: bad number %q", c.argv[0])
+		}
+	}
+	if n > len(positional)-1 {
+		return fmt.Errorf("shift: can't shift %d of %d arguments", n, len(positional)-1)
+	}
+	positional = append(positional[:1], positional[1+n:]...)
+	return nil
+}
@@ -20,6 +20,7 @@ import (
 	"log"
 	"os"
 
+	"github.com/u-root/u-root/pkg/service"
 	"golang.org/x/sys/unix"
 )
 
@@ -47,6 +48,13 @@ func main() {
 	if !ok || len(os.Args) > 2 {
 		usage()
 	}
+	// Let init stop its services cleanly first. Suspending leaves them
+	// running.
+	if op != unix.LINUX_REBOOT_CMD_SW_SUSPEND {
+		if err := service.Shutdown(); err != nil {
+			log.Printf("Stopping services: %v", err)
+		}
+	}
 	if err := reboot(int(op)); err != nil {
 		log.Fatalf(err.Error())
 	}
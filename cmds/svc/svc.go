@@ -0,0 +1,47 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Svc controls the services init runs.
+//
+// Synopsis:
+//     svc [-s SOCKET] [status]
+//     svc [-s SOCKET] log [NAME]
+//     svc [-s SOCKET] start|stop|restart NAME
+//     svc [-s SOCKET] shutdown
+//
+// Description:
+//     init starts the services defined in /etc/uinit.d. svc shows their
+//     status or their log, starts, stops and restarts them, or stops all
+//     of them. A service is defined by a file named for it, like
+//
+//         exec = /bbin/sshd -port 2222
+//         after = dhclient
+//         restart = always
+//
+// Options:
+//     -s: the socket of init
+package main
+
+import (
+	"fmt"
+	"log"
+
+	flag "github.com/spf13/pflag"
+	"github.com/u-root/u-root/pkg/service"
+)
+
+var socket = flag.StringP("socket", "s", service.DefaultSocket, "The socket of init")
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"status"}
+	}
+	out, err := service.Command(*socket, args...)
+	if err != nil {
+		log.Fatalf("svc: %v", err)
+	}
+	fmt.Print(out)
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/u-root/u-root/pkg/service"
+	"github.com/u-root/u-root/pkg/testutil"
+)
+
+func TestSvc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s, err := service.New([]*service.Service{{Name: "echo", Args: []string{"/bin/echo", "hello"}, Restart: service.Never}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	go s.Reap()
+	defer s.Stop()
+	s.Start()
+	for i := 0; i < 500 && !strings.Contains(string(s.Log.Bytes()), "exit status"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "echo             exited"},
+		{[]string{"log", "echo"}, "echo: hello\n"},
+	} {
+		out, err := testutil.Command(t, append([]string{"-s", sock}, tt.args...)...).CombinedOutput()
+		if err != nil || !strings.Contains(string(out), tt.want) {
+			t.Errorf("svc %q: got %q, %v, want %q", tt.args, out, err, tt.want)
+		}
+	}
+	out, err := testutil.Command(t, "-s", sock, "stop", "nope").CombinedOutput()
+	if err := testutil.IsExitCode(err, 1); err != nil || !strings.Contains(string(out), "no service nope") {
+		t.Errorf("svc stop nope: got %q, %v, want no service nope", out, err)
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutil.Run(m, main)
+}
@@ -15,30 +15,15 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
+
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/fs/squashfs"
 )
 
 const (
-	cmd = "tcz [options] package-names"
-	/*
-	 * IOCTL commands --- we will commandeer 0x4C ('L')
-	 */
-	LOOP_SET_CAPACITY = 0x4C07
-	LOOP_CHANGE_FD    = 0x4C06
-	LOOP_GET_STATUS64 = 0x4C05
-	LOOP_SET_STATUS64 = 0x4C04
-	LOOP_GET_STATUS   = 0x4C03
-	LOOP_SET_STATUS   = 0x4C02
-	LOOP_CLR_FD       = 0x4C01
-	LOOP_SET_FD       = 0x4C00
-	LO_NAME_SIZE      = 64
-	LO_KEY_SIZE       = 32
-	/* /dev/loop-control interface */
-	LOOP_CTL_ADD      = 0x4C80
-	LOOP_CTL_REMOVE   = 0x4C81
-	LOOP_CTL_GET_FREE = 0x4C82
-	SYS_ioctl         = 16
-	dirMode           = 0755
-	tinyCoreRoot      = "/TinyCorePackages/tcloop"
+	cmd          = "tcz [options] package-names"
+	dirMode      = 0755
+	tinyCoreRoot = "/TinyCorePackages/tcloop"
 )
 
 //http://distro.ibiblio.org/tinycorelinux/5.x/x86_64/tcz/
@@ -50,7 +35,7 @@ var (
 	version            = flag.String("v", "8.x", "tinycore version")
 	arch               = flag.String("a", "x86_64", "tinycore architecture")
 	port               = flag.String("p", "80", "Host port")
-	install            = flag.Bool("i", true, "Install the packages, i.e. mount and create symlinks")
+	install            = flag.Bool("i", true, "Install the packages, i.e. unpack and create symlinks")
 	tczRoot            = flag.String("r", "/tcz", "tcz root directory")
 	debugPrint         = flag.Bool("d", false, "Enable debug prints")
 	skip               = flag.String("skip", "", "Packages to skip")
@@ -60,22 +45,6 @@ var (
 	ignorePackage      = make(map[string]struct{})
 )
 
-// consider making this a goroutine which pushes the string down the channel.
-func findloop() (name string, err error) {
-	cfd, err := syscall.Open("/dev/loop-control", syscall.O_RDWR, 0)
-	if err != nil {
-		log.Fatalf("/dev/loop-control: %v", err)
-	}
-	defer syscall.Close(cfd)
-	a, b, errno := syscall.Syscall(SYS_ioctl, uintptr(cfd), LOOP_CTL_GET_FREE, 0)
-	if errno != 0 {
-		log.Fatalf("ioctl: %v\n", err)
-	}
-	debug("a %v b %v err %v\n", a, b, err)
-	name = fmt.Sprintf("/dev/loop%d", a)
-	return name, nil
-}
-
 func clonetree(tree string) error {
 	debug("Clone tree %v", tree)
 	lt := len(tree)
@@ -225,6 +194,56 @@ func installPackage(tczName string, deps map[string]bool) error {
 
 }
 
+// unpack extracts the squashfs file system in pkgpath into dir. The
+// convention is the package is in /tinyCoreRoot/packagename.
+func unpack(pkgpath, dir string) error {
+	f, err := os.Open(pkgpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fsys, err := squashfs.New(f)
+	if err != nil {
+		return err
+	}
+	recs, err := fsys.Records()
+	if err != nil {
+		return err
+	}
+	// Packages are untrusted, so no file may be made outside of dir,
+	// which a file under one of the package's symlinks could be.
+	links := map[string]bool{}
+	for _, r := range recs {
+		if r.Name != "." {
+			if err := checkName(r.Name, links); err != nil {
+				return fmt.Errorf("%s: %v", pkgpath, err)
+			}
+		}
+		if r.Mode&syscall.S_IFMT == syscall.S_IFLNK {
+			links[r.Name] = true
+		}
+		if err := cpio.CreateFileInRoot(r, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkName returns an error unless name is a relative path that stays in
+// its root and does not go through any of links.
+func checkName(name string, links map[string]bool) error {
+	elems := strings.Split(name, "/")
+	for i, e := range elems {
+		if e == "" || e == "." || e == ".." {
+			return fmt.Errorf("bad file name %q", name)
+		}
+		if p := strings.Join(elems[:i], "/"); links[p] {
+			return fmt.Errorf("%q is under symlink %q", name, p)
+		}
+	}
+	return nil
+}
+
 func setupPackages(tczName string, deps map[string]bool) error {
 	debug("setupPackages: @ %v deps %v\n", tczName, deps)
 	for v := range deps {
@@ -232,7 +251,7 @@ func setupPackages(tczName string, deps map[string]bool) error {
 		packagePath := filepath.Join(tinyCoreRoot, cmdName)
 
 		if _, err := os.Stat(packagePath); err == nil {
-			debug("PackagePath %s exists, skipping mount", packagePath)
+			debug("PackagePath %s exists, skipping unpack", packagePath)
 			continue
 		}
 
@@ -240,32 +259,11 @@ func setupPackages(tczName string, deps map[string]bool) error {
 			l.Fatalf("Package directory %s at %s, can not be created: %v", tczName, packagePath, err)
 		}
 
-		loopname, err := findloop()
-		if err != nil {
-			l.Fatal(err)
-		}
-		debug("findloop gets %v err %v\n", loopname, err)
 		pkgpath := filepath.Join(tczLocalPackageDir, v)
-		ffd, err := syscall.Open(pkgpath, syscall.O_RDONLY, 0)
-		if err != nil {
-			l.Fatalf("%v: %v\n", pkgpath, err)
-		}
-		lfd, err := syscall.Open(loopname, syscall.O_RDONLY, 0)
-		if err != nil {
-			l.Fatalf("%v: %v\n", loopname, err)
-		}
-		debug("ffd %v lfd %v\n", ffd, lfd)
-
-		a, b, errno := syscall.Syscall(SYS_ioctl, uintptr(lfd), LOOP_SET_FD, uintptr(ffd))
-		if errno != 0 {
-			l.Fatalf("loop set fd ioctl: pkgpath :%v:, loop :%v:, %v, %v, %v\n", pkgpath, loopname, a, b, errno)
-		}
-
-		/* now mount it. The convention is the mount is in /tinyCoreRoot/packagename */
-		if err := syscall.Mount(loopname, packagePath, "squashfs", syscall.MS_MGC_VAL|syscall.MS_RDONLY, ""); err != nil {
-			l.Fatalf("Mount :%s: on :%s: %v\n", loopname, packagePath, err)
+		if err := unpack(pkgpath, packagePath); err != nil {
+			l.Fatalf("Unpacking %s in %s: %v\n", pkgpath, packagePath, err)
 		}
-		err = clonetree(packagePath)
+		err := clonetree(packagePath)
 		if err != nil {
 			l.Fatalf("clonetree:  %v\n", err)
 		}
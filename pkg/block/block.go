@@ -0,0 +1,250 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package block finds block devices and partitions, and what is on them.
+//
+// BlockDevices reads /sys/class/block for the devices, the GPT or MBR of
+// each disk for the UUIDs, types and names of its partitions, and the
+// superblock of each device for the type, label and UUID of its file
+// system:
+//
+//	devs, err := block.BlockDevices()
+//	if err != nil {
+//		return err
+//	}
+//	for _, d := range devs.FilterFSUUID(uuid) {
+//		fmt.Println(d.DevPath)
+//	}
+package block
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/gpt"
+	"github.com/u-root/u-root/pkg/mbr"
+)
+
+// SectorSize is the unit of sizes in sysfs.
+const SectorSize = 512
+
+// BlockDev is a disk or partition.
+type BlockDev struct {
+	// Name is the kernel's name of the device, like sda1.
+	Name    string
+	DevPath string
+	Major   int
+	Minor   int
+	// Size is in bytes.
+	Size      uint64
+	Removable bool
+	ReadOnly  bool
+
+	// Parent is the name of the disk of a partition, and empty for
+	// disks.
+	Parent string
+	// PartNumber is the number of a partition, and 0 for disks.
+	PartNumber int
+	// PartUUID is the unique partition GUID of a GPT partition, or the
+	// disk signature and partition number of an MBR partition, as
+	// the kernel's root=PARTUUID= takes it.
+	PartUUID string
+	// PartType is the type GUID of a GPT partition, or the type of an
+	// MBR partition in hex, like 0x83.
+	PartType string
+	// PartLabel is the name of a GPT partition.
+	PartLabel string
+
+	// FSType, Label and UUID are from the file system superblock.
+	FSType string
+	Label  string
+	UUID   string
+}
+
+func (b *BlockDev) String() string {
+	return fmt.Sprintf("%s: TYPE=%q LABEL=%q UUID=%q PARTUUID=%q", b.DevPath, b.FSType, b.Label, b.UUID, b.PartUUID)
+}
+
+// BlockDevs is a list of block devices.
+type BlockDevs []*BlockDev
+
+var (
+	sysfsBlock = "/sys/class/block"
+	devDir     = "/dev"
+)
+
+// BlockDevices returns the block devices of the system, sorted by name,
+// which puts disks before their partitions. Devices that cannot be read,
+// for lack of permission or media, are returned with what sysfs says about
+// them.
+func BlockDevices() (BlockDevs, error) {
+	return blockDevices(sysfsBlock, devDir)
+}
+
+func readInt(name string) (int64, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+func blockDevices(sys, dev string) (BlockDevs, error) {
+	fis, err := ioutil.ReadDir(sys)
+	if err != nil {
+		return nil, err
+	}
+	var devs BlockDevs
+	byName := map[string]*BlockDev{}
+	for _, fi := range fis {
+		d, err := sysfsDevice(sys, fi.Name())
+		if err != nil {
+			return nil, err
+		}
+		d.DevPath = filepath.Join(dev, d.Name)
+		devs = append(devs, d)
+		byName[d.Name] = d
+	}
+
+	// Partitions are removable if their disk is, and their table is in
+	// their disk.
+	for _, d := range devs {
+		if p, ok := byName[d.Parent]; ok {
+			d.Removable = p.Removable
+		}
+	}
+	for _, d := range devs {
+		if d.Parent == "" {
+			partitions(d, devs)
+		}
+	}
+	for _, d := range devs {
+		d.probe()
+	}
+	return devs, nil
+}
+
+// sysfsDevice reads what sysfs knows about device name.
+func sysfsDevice(sys, name string) (*BlockDev, error) {
+	d := &BlockDev{Name: name}
+	dir := filepath.Join(sys, name)
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "dev")); err == nil {
+		if _, err := fmt.Sscanf(string(b), "%d:%d", &d.Major, &d.Minor); err != nil {
+			return nil, fmt.Errorf("%s: bad dev %q", name, b)
+		}
+	}
+	if n, err := readInt(filepath.Join(dir, "size")); err == nil {
+		d.Size = uint64(n) * SectorSize
+	}
+	if n, err := readInt(filepath.Join(dir, "ro")); err == nil {
+		d.ReadOnly = n != 0
+	}
+	if n, err := readInt(filepath.Join(dir, "removable")); err == nil {
+		d.Removable = n != 0
+	}
+	if n, err := readInt(filepath.Join(dir, "partition")); err == nil {
+		// A partition's directory is in its disk's.
+		d.PartNumber = int(n)
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return nil, err
+		}
+		d.Parent = filepath.Base(filepath.Dir(real))
+	}
+	return d, nil
+}
+
+// partitions sets the partition fields of the partitions of disk from its
+// GPT or MBR.
+func partitions(disk *BlockDev, devs BlockDevs) {
+	f, err := os.Open(disk.DevPath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	set := func(n int, uuid, typ, label string) {
+		for _, d := range devs {
+			if d.Parent == disk.Name && d.PartNumber == n {
+				d.PartUUID, d.PartType, d.PartLabel = uuid, typ, label
+			}
+		}
+	}
+	if t, _ := gpt.New(f); t != nil && t.Primary != nil {
+		for i, p := range t.Primary.Parts {
+			if p.IsUsed() {
+				set(i+1, p.UniqueGUID.String(), p.PartGUID.String(), gpt.DecodeName(p.Name))
+			}
+		}
+		return
+	}
+	t, _ := mbr.Read(f)
+	if t == nil || t.IsProtective() {
+		return
+	}
+	for _, p := range t.Partitions() {
+		set(p.Number, fmt.Sprintf("%08x-%02x", t.DiskSignature, p.Number), fmt.Sprintf("%#x", p.Type), "")
+	}
+}
+
+// probe sets the file system fields of d.
+func (b *BlockDev) probe() {
+	if b.Size == 0 {
+		return
+	}
+	f, err := os.Open(b.DevPath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if fs, err := Probe(f); err == nil {
+		b.FSType, b.Label, b.UUID = fs.Type, fs.Label, fs.UUID
+	}
+}
+
+// Filter returns the devices for which keep returns true.
+func (b BlockDevs) Filter(keep func(*BlockDev) bool) BlockDevs {
+	var devs BlockDevs
+	for _, d := range b {
+		if keep(d) {
+			devs = append(devs, d)
+		}
+	}
+	return devs
+}
+
+// FilterRemovable returns the devices that are removable, or not.
+func (b BlockDevs) FilterRemovable(removable bool) BlockDevs {
+	return b.Filter(func(d *BlockDev) bool { return d.Removable == removable })
+}
+
+// FilterPartUUID returns the partitions with partition UUID uuid.
+func (b BlockDevs) FilterPartUUID(uuid string) BlockDevs {
+	return b.Filter(func(d *BlockDev) bool { return d.PartUUID != "" && strings.EqualFold(d.PartUUID, uuid) })
+}
+
+// FilterPartType returns the partitions of type typ, a GPT type GUID or
+// an MBR type like 0x83.
+func (b BlockDevs) FilterPartType(typ string) BlockDevs {
+	return b.Filter(func(d *BlockDev) bool { return d.PartType != "" && strings.EqualFold(d.PartType, typ) })
+}
+
+// FilterFSUUID returns the devices with a file system with UUID uuid.
+func (b BlockDevs) FilterFSUUID(uuid string) BlockDevs {
+	return b.Filter(func(d *BlockDev) bool { return d.UUID != "" && strings.EqualFold(d.UUID, uuid) })
+}
+
+// FilterLabel returns the devices with a file system labeled label.
+func (b BlockDevs) FilterLabel(label string) BlockDevs {
+	return b.Filter(func(d *BlockDev) bool { return d.Label != "" && d.Label == label })
+}
+
+// FilterFSType returns the devices with a file system of type fstype.
+func (b BlockDevs) FilterFSType(fstype string) BlockDevs {
+	return b.Filter(func(d *BlockDev) bool { return d.FSType == fstype })
+}
@@ -0,0 +1,174 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package block
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/gpt"
+	"github.com/u-root/u-root/pkg/mbr"
+)
+
+type disk []byte
+
+func (d disk) WriteAt(b []byte, off int64) (int, error) {
+	copy(d[off:], b)
+	return len(b), nil
+}
+
+// fakeSys makes a sysfs and /dev with a removable GPT disk sda with a
+// FAT partition, and an MBR disk sdb with an ext4 partition.
+func fakeSys(t *testing.T) (string, string, string) {
+	dir, err := ioutil.TempDir("", "block")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys, dev := filepath.Join(dir, "sys"), filepath.Join(dir, "dev")
+	files := map[string]string{
+		"sda/dev":            "8:0\n",
+		"sda/size":           "8192\n",
+		"sda/removable":      "1\n",
+		"sda/ro":             "0\n",
+		"sda/sda1/dev":       "8:1\n",
+		"sda/sda1/size":      "2048\n",
+		"sda/sda1/partition": "1\n",
+		"sdb/dev":            "8:16\n",
+		"sdb/size":           "8192\n",
+		"sdb/removable":      "0\n",
+		"sdb/ro":             "1\n",
+		"sdb/sdb5/dev":       "8:21\n",
+		"sdb/sdb5/size":      "2048\n",
+		"sdb/sdb5/partition": "5\n",
+		"loop0/dev":          "7:0\n",
+		"loop0/size":         "0\n",
+		"loop0/removable":    "0\n",
+		"loop0/ro":           "0\n",
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, "devices", name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// /sys/class/block has links to the devices.
+	if err := os.MkdirAll(sys, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dev, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []string{"sda", "sda/sda1", "sdb", "sdb/sdb5", "loop0"} {
+		if err := os.Symlink(filepath.Join("../devices", l), filepath.Join(sys, filepath.Base(l))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sda := make(disk, 8192*512)
+	g, err := gpt.Create(int64(len(sda)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Add(gpt.PartTypes["efi"], 2048, 4095, "EFI System"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gpt.Write(sda, g); err != nil {
+		t.Fatal(err)
+	}
+	sdb := make(disk, 8192*512)
+	var tab mbr.Table
+	tab.DiskSignature = 0xdeadbeef
+	if tab.Parts[1], err = mbr.Entry(mbr.Extended, false, 0, 2048, 4096); err != nil {
+		t.Fatal(err)
+	}
+	tab.Logical = []mbr.Partition{{Number: 5, Type: mbr.Linux, FirstLBA: 2048 + 63, Blocks: 2048, EBR: 2048}}
+	if err := mbr.Write(sdb, &tab); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{
+		"sda":   sda,
+		"sda1":  image(map[int][]byte{0: vfatBootSector(true)}),
+		"sdb":   sdb,
+		"sdb5":  image(map[int][]byte{1024: extSuperblock(extCompatHasJournal, extIncompatExtents)}),
+		"loop0": nil,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dev, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, sys, dev
+}
+
+func TestBlockDevices(t *testing.T) {
+	dir, sys, dev := fakeSys(t)
+	defer os.RemoveAll(dir)
+
+	devs, err := blockDevices(sys, dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(dev, "sda"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	g, err := gpt.New(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partUUID := g.Primary.Parts[0].UniqueGUID.String()
+	efi := gpt.PartTypes["efi"]
+
+	want := BlockDevs{
+		{Name: "loop0", DevPath: filepath.Join(dev, "loop0"), Major: 7},
+		{Name: "sda", DevPath: filepath.Join(dev, "sda"), Major: 8, Size: 8192 * 512, Removable: true},
+		{
+			Name: "sda1", DevPath: filepath.Join(dev, "sda1"), Major: 8, Minor: 1, Size: 2048 * 512, Removable: true,
+			Parent: "sda", PartNumber: 1, PartUUID: partUUID, PartType: "c12a7328-f81f-11d2-ba4b-00a0c93ec93b", PartLabel: "EFI System",
+			FSType: "vfat", Label: "EFI", UUID: "1234-ABCD",
+		},
+		{Name: "sdb", DevPath: filepath.Join(dev, "sdb"), Major: 8, Minor: 16, Size: 8192 * 512, ReadOnly: true},
+		{
+			Name: "sdb5", DevPath: filepath.Join(dev, "sdb5"), Major: 8, Minor: 21, Size: 2048 * 512,
+			Parent: "sdb", PartNumber: 5, PartUUID: "deadbeef-05", PartType: "0x83",
+			FSType: "ext4", Label: "root", UUID: testUUIDString,
+		},
+	}
+	if !reflect.DeepEqual(devs, want) {
+		t.Errorf("blockDevices() =\n%v, want\n%v", devs, want)
+		for i := range devs {
+			t.Logf("%+v", *devs[i])
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		got  BlockDevs
+		want []string
+	}{
+		{"FilterRemovable(true)", devs.FilterRemovable(true), []string{"sda", "sda1"}},
+		{"FilterPartUUID", devs.FilterPartUUID("DEADBEEF-05"), []string{"sdb5"}},
+		{"FilterPartType", devs.FilterPartType(efi.String()), []string{"sda1"}},
+		{"FilterFSUUID", devs.FilterFSUUID(testUUIDString), []string{"sdb5"}},
+		{"FilterLabel", devs.FilterLabel("EFI"), []string{"sda1"}},
+		{"FilterFSType", devs.FilterFSType("ext4"), []string{"sdb5"}},
+		{"FilterFSUUID(none)", devs.FilterFSUUID(""), nil},
+	} {
+		var names []string
+		for _, d := range tt.got {
+			names = append(names, d.Name)
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.name, names, tt.want)
+		}
+	}
+}
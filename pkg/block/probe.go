@@ -0,0 +1,199 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FSInfo is what the superblock of a file system says about it.
+type FSInfo struct {
+	// Type is the file system type, as blkid and mount name it.
+	Type  string
+	Label string
+	UUID  string
+}
+
+// ErrNoFS is returned by Probe if it recognizes no file system.
+var ErrNoFS = errors.New("no known file system")
+
+var le = binary.LittleEndian
+
+// probes are tried in order. Each returns nil if its file system is not
+// found.
+var probes = []func(r io.ReaderAt) *FSInfo{
+	probeExt,
+	probeBtrfs,
+	probeXFS,
+	probeSquashfs,
+	probeSwap,
+	probeLUKS,
+	probeISO9660,
+	probeVFAT,
+}
+
+// Probe finds the file system in r by its superblock.
+func Probe(r io.ReaderAt) (*FSInfo, error) {
+	for _, p := range probes {
+		if fs := p(r); fs != nil {
+			return fs, nil
+		}
+	}
+	return nil, ErrNoFS
+}
+
+// read reads n bytes at off, or returns nil.
+func read(r io.ReaderAt, off int64, n int) []byte {
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil
+	}
+	return b
+}
+
+// uuid formats a binary UUID.
+func uuid(b []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// label returns a label without the trailing NULs or spaces it is padded
+// with.
+func label(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i != -1 {
+		b = b[:i]
+	}
+	return strings.TrimRight(string(b), " ")
+}
+
+const (
+	extMagic            = 0xef53
+	extCompatHasJournal = 0x4
+	extIncompatExtents  = 0x40
+	extIncompat64Bit    = 0x80
+	extIncompatFlexBG   = 0x200
+	extIncompatInline   = 0x8000
+)
+
+func probeExt(r io.ReaderAt) *FSInfo {
+	sb := read(r, 1024, 1024)
+	if sb == nil || le.Uint16(sb[56:]) != extMagic {
+		return nil
+	}
+	fs := &FSInfo{Type: "ext2", UUID: uuid(sb[104:120]), Label: label(sb[120:136])}
+	switch {
+	case le.Uint32(sb[96:])&(extIncompatExtents|extIncompat64Bit|extIncompatFlexBG|extIncompatInline) != 0:
+		fs.Type = "ext4"
+	case le.Uint32(sb[92:])&extCompatHasJournal != 0:
+		fs.Type = "ext3"
+	}
+	return fs
+}
+
+func probeBtrfs(r io.ReaderAt) *FSInfo {
+	sb := read(r, 0x10000, 0x1000)
+	if sb == nil || string(sb[0x40:0x48]) != "_BHRfS_M" {
+		return nil
+	}
+	return &FSInfo{Type: "btrfs", UUID: uuid(sb[0x20:0x30]), Label: label(sb[0x12b:0x22b])}
+}
+
+func probeXFS(r io.ReaderAt) *FSInfo {
+	sb := read(r, 0, 512)
+	if sb == nil || string(sb[0:4]) != "XFSB" {
+		return nil
+	}
+	return &FSInfo{Type: "xfs", UUID: uuid(sb[32:48]), Label: label(sb[108:120])}
+}
+
+func probeSquashfs(r io.ReaderAt) *FSInfo {
+	sb := read(r, 0, 4)
+	if sb == nil || string(sb) != "hsqs" {
+		return nil
+	}
+	return &FSInfo{Type: "squashfs"}
+}
+
+func probeSwap(r io.ReaderAt) *FSInfo {
+	// The signature is at the end of the first page, whatever the page
+	// size was.
+	for _, page := range []int64{4096, 8192, 16384, 65536} {
+		sig := read(r, page-10, 10)
+		if sig == nil || (string(sig) != "SWAPSPACE2" && string(sig) != "SWAP-SPACE") {
+			continue
+		}
+		fs := &FSInfo{Type: "swap"}
+		if h := read(r, 1024, 44); h != nil && string(sig) == "SWAPSPACE2" {
+			fs.UUID = uuid(h[12:28])
+			fs.Label = label(h[28:44])
+		}
+		return fs
+	}
+	return nil
+}
+
+func probeLUKS(r io.ReaderAt) *FSInfo {
+	h := read(r, 0, 208)
+	if h == nil || string(h[0:6]) != "LUKS\xba\xbe" {
+		return nil
+	}
+	fs := &FSInfo{Type: "crypto_LUKS", UUID: label(h[168:208])}
+	if binary.BigEndian.Uint16(h[6:]) == 2 {
+		fs.Label = label(h[24:72])
+	}
+	return fs
+}
+
+func probeISO9660(r io.ReaderAt) *FSInfo {
+	pvd := read(r, 16*2048, 2048)
+	if pvd == nil || pvd[0] != 1 || string(pvd[1:6]) != "CD001" {
+		return nil
+	}
+	fs := &FSInfo{Type: "iso9660", Label: label(pvd[40:72])}
+	// blkid makes the UUID from the creation or modification date.
+	for _, d := range [][]byte{pvd[830:847], pvd[813:830]} {
+		if d[0] != '0' && d[0] != 0 {
+			fs.UUID = fmt.Sprintf("%s-%s-%s-%s-%s-%s-%s", d[0:4], d[4:6], d[6:8], d[8:10], d[10:12], d[12:14], d[14:16])
+			break
+		}
+	}
+	return fs
+}
+
+func probeVFAT(r io.ReaderAt) *FSInfo {
+	bs := read(r, 0, 512)
+	if bs == nil || bs[510] != 0x55 || bs[511] != 0xaa || (bs[0] != 0xeb && bs[0] != 0xe9) {
+		return nil
+	}
+	if bps := le.Uint16(bs[11:]); bps < 512 || bps > 4096 || bps&(bps-1) != 0 || bs[13] == 0 {
+		return nil
+	}
+	// The extended boot record is after the FAT32 fields if there are
+	// any.
+	var ext []byte
+	switch {
+	case strings.HasPrefix(string(bs[0x52:0x5a]), "FAT32"):
+		ext = bs[0x40:]
+	case strings.HasPrefix(string(bs[0x36:0x3e]), "FAT"):
+		ext = bs[0x24:]
+	default:
+		return nil
+	}
+	fs := &FSInfo{Type: "vfat"}
+	if ext[2] == 0x29 || ext[2] == 0x28 {
+		serial := le.Uint32(ext[3:])
+		fs.UUID = fmt.Sprintf("%04X-%04X", serial>>16, serial&0xffff)
+	}
+	if ext[2] == 0x29 {
+		if l := label(ext[7:18]); l != "NO NAME" {
+			fs.Label = l
+		}
+	}
+	return fs
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package block
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var testUUID = []byte{0x5b, 0xd1, 0xc8, 0xf5, 0x7b, 0x1c, 0x4a, 0x8e, 0x9f, 0x5e, 0x0e, 0x3f, 0x6a, 0x8e, 0x4b, 0x2d}
+
+const testUUIDString = "5bd1c8f5-7b1c-4a8e-9f5e-0e3f6a8e4b2d"
+
+// image returns a 128K image with the given bytes at the given offsets.
+func image(parts map[int][]byte) []byte {
+	img := make([]byte, 128<<10)
+	for off, b := range parts {
+		copy(img[off:], b)
+	}
+	return img
+}
+
+func extSuperblock(compat, incompat uint32) []byte {
+	sb := make([]byte, 1024)
+	le.PutUint16(sb[56:], extMagic)
+	le.PutUint32(sb[92:], compat)
+	le.PutUint32(sb[96:], incompat)
+	copy(sb[104:], testUUID)
+	copy(sb[120:], "root")
+	return sb
+}
+
+func vfatBootSector(fat32 bool) []byte {
+	bs := make([]byte, 512)
+	bs[0] = 0xeb
+	le.PutUint16(bs[11:], 512)
+	bs[13] = 4
+	ext := bs[0x24:]
+	if fat32 {
+		ext = bs[0x40:]
+		copy(bs[0x52:], "FAT32   ")
+	} else {
+		copy(bs[0x36:], "FAT16   ")
+	}
+	ext[2] = 0x29
+	le.PutUint32(ext[3:], 0x1234abcd)
+	copy(ext[7:18], "EFI        ")
+	bs[510], bs[511] = 0x55, 0xaa
+	return bs
+}
+
+func TestProbe(t *testing.T) {
+	pvd := make([]byte, 2048)
+	pvd[0] = 1
+	copy(pvd[1:], "CD001")
+	copy(pvd[40:], "Fedora-WS-Live-27-1-6           ")
+	copy(pvd[813:], "2017110507011300\x00")
+	luks := make([]byte, 208)
+	copy(luks, "LUKS\xba\xbe\x00\x01")
+	copy(luks[168:], testUUIDString)
+	swapHeader := make([]byte, 44)
+	copy(swapHeader[12:], testUUID)
+	copy(swapHeader[28:], "swap")
+
+	for _, tt := range []struct {
+		name string
+		img  []byte
+		want *FSInfo
+	}{
+		{"ext2", image(map[int][]byte{1024: extSuperblock(0, 0x2)}), &FSInfo{"ext2", "root", testUUIDString}},
+		{"ext3", image(map[int][]byte{1024: extSuperblock(extCompatHasJournal, 0x2)}), &FSInfo{"ext3", "root", testUUIDString}},
+		{"ext4", image(map[int][]byte{1024: extSuperblock(extCompatHasJournal, extIncompatExtents)}), &FSInfo{"ext4", "root", testUUIDString}},
+		{"fat16", image(map[int][]byte{0: vfatBootSector(false)}), &FSInfo{"vfat", "EFI", "1234-ABCD"}},
+		{"fat32", image(map[int][]byte{0: vfatBootSector(true)}), &FSInfo{"vfat", "EFI", "1234-ABCD"}},
+		{"iso9660", image(map[int][]byte{16 * 2048: pvd}), &FSInfo{"iso9660", "Fedora-WS-Live-27-1-6", "2017-11-05-07-01-13-00"}},
+		{"xfs", image(map[int][]byte{0: []byte("XFSB"), 32: testUUID, 108: []byte("data")}), &FSInfo{"xfs", "data", testUUIDString}},
+		{"btrfs", image(map[int][]byte{0x10020: testUUID, 0x10040: []byte("_BHRfS_M"), 0x1012b: []byte("pool")}), &FSInfo{"btrfs", "pool", testUUIDString}},
+		{"squashfs", image(map[int][]byte{0: []byte("hsqs")}), &FSInfo{Type: "squashfs"}},
+		{"swap", image(map[int][]byte{1024: swapHeader, 4086: []byte("SWAPSPACE2")}), &FSInfo{"swap", "swap", testUUIDString}},
+		{"luks", image(map[int][]byte{0: luks}), &FSInfo{Type: "crypto_LUKS", UUID: testUUIDString}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Probe(bytes.NewReader(tt.img))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Probe() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	for _, img := range [][]byte{image(nil), make([]byte, 100)} {
+		if fs, err := Probe(bytes.NewReader(img)); err != ErrNoFS {
+			t.Errorf("Probe(%d zeros) = %v, %v, want %v", len(img), fs, err, ErrNoFS)
+		}
+	}
+}
@@ -7,15 +7,14 @@
 // be used to create a new bzImage.
 package bzimage
 
-// xz --check=crc32 $BCJ --lzma2=$LZMA2OPTS,dict=32MiB
 import (
 	"bytes"
+	"crypto/sha256"
 	"debug/elf"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"os/exec"
 	"reflect"
 	"strings"
 
@@ -23,13 +22,7 @@ import (
 )
 
 var (
-	xzmagic = [...]byte{0xFD, 0x37, 0x7A, 0x58, 0x5A, 0x00}
-	// String of unknown meaning.
-	// The build script has this value:
-	//initRAMFStag = [4]byte{0250, 0362, 0156, 0x01}
-	// The resultant bzd has this value:
-	initRAMFStag = [4]byte{0xf8, 0x85, 0x21, 0x01}
-	Debug        = func(string, ...interface{}) {}
+	Debug = func(string, ...interface{}) {}
 )
 
 // UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
@@ -40,10 +33,10 @@ var (
 // MBR and bootparams first 512 bytes
 //    the MBR includes 0xc0 bytes of boot code which is vestigial.
 // Then there is "preamble" code which is the kernel decompressor; then the
-// xz compressed kernel; then a library of sorts after the kernel which is called
+// compressed kernel; then a library of sorts after the kernel which is called
 // by the early uncompressed kernel code. This is all linked together and forms
 // an essentially indivisible whole -- which we wish to divisible.
-// Hence the groveling around for the xz header that you see here, and hence the checks
+// Hence the groveling around for the compressor magic that you see here, and hence the checks
 // to ensure that the kernel layout ends up largely the same before and after.
 // That said, if you keep layout unchanged, you can modify the uncompressed kernel.
 // For example, when you first build a kernel, you can:
@@ -79,11 +72,17 @@ func (b *BzImage) UnmarshalBinary(d []byte) error {
 	Debug("%d bytes of BootCode", len(b.BootCode))
 
 	Debug("Remaining length is %d bytes, PayloadSize %d", r.Len(), b.Header.PayloadSize)
-	x := bytes.Index(r.Bytes(), xzmagic[:])
-	if x == -1 {
-		return fmt.Errorf("Can't find xz header")
+	x := int(b.Header.PayloadOffset)
+	if b.Header.Protocolversion >= 0x208 && x+int(b.Header.PayloadSize) <= r.Len() {
+		b.compressor = findCompressor(r.Bytes()[x:])
 	}
-	Debug("xz is at %d", x)
+	if b.compressor == nil {
+		x, b.compressor = searchCompressor(r.Bytes())
+	}
+	if b.compressor == nil {
+		return fmt.Errorf("Can't find a compressed kernel")
+	}
+	Debug("%s is at %d", b.compressor.name, x)
 	b.HeadCode = make([]byte, x)
 	if _, err := r.Read(b.HeadCode); err != nil {
 		return fmt.Errorf("Can't read HeadCode: %v", err)
@@ -95,9 +94,11 @@ func (b *BzImage) UnmarshalBinary(d []byte) error {
 	}
 	var err error
 	Debug("Uncompress %d bytes", len(b.compressed))
-	if b.KernelCode, err = unpack(b.compressed); err != nil {
+	if b.KernelCode, err = b.compressor.decompress(b.compressed); err != nil {
 		return err
 	}
+	Debug("Uncompressed kernel is %d bytes", len(b.KernelCode))
+	b.kernelSum = sha256.Sum256(b.KernelCode)
 	b.TailCode = make([]byte, r.Len())
 	if _, err := r.Read(b.TailCode); err != nil {
 		return fmt.Errorf("Can't read TailCode: %v", err)
@@ -115,22 +116,17 @@ func (b *BzImage) UnmarshalBinary(d []byte) error {
 
 // MarshalBinary implements the encoding.BinaryMarshaler interface.
 func (b *BzImage) MarshalBinary() ([]byte, error) {
-	// First step, make sure we can compress the kernel.
-	dat, err := compress(b.KernelCode, "--lzma2=,dict=32MiB")
-	if err != nil {
-		return nil, err
-	}
-	dat = append(dat, initRAMFStag[:]...)
-	if len(dat) > len(b.compressed) {
-		return nil, fmt.Errorf("Marshal: compressed KernelCode too big: was %d, now %d", len(b.compressed), len(dat))
-	}
-	Debug("b.compressed len %#x dat len %#x pad it out", len(b.compressed), len(dat))
-	if len(dat) < len(b.compressed) {
-		l := len(dat)
-		n := make([]byte, len(b.compressed)-4)
-		copy(n, dat[:l-4])
-		n = append(n, initRAMFStag[:]...)
-		dat = n
+	if b.compressor == nil {
+		return nil, fmt.Errorf("Marshal: no compressed kernel to replace")
+	}
+	// First step, make sure we can compress the kernel. If it has not
+	// changed, the kernel we started with is as good as it gets.
+	dat := b.compressed
+	if sha256.Sum256(b.KernelCode) != b.kernelSum {
+		var err error
+		if dat, err = b.compressor.pack(b.KernelCode, b.compressed); err != nil {
+			return nil, err
+		}
 	}
 
 	var w bytes.Buffer
@@ -150,9 +146,8 @@ func (b *BzImage) MarshalBinary() ([]byte, error) {
 		return nil, err
 	}
 	Debug("Last bytes %#02x", dat[len(dat)-4:])
-	Debug("Last bytes %#o", dat[len(dat)-4:])
-	Debug("Last bytes %#d", dat[len(dat)-4:])
 	b.compressed = dat
+	b.kernelSum = sha256.Sum256(b.KernelCode)
 	Debug("Wrote %d bytes of Compressed kernel", w.Len())
 	if _, err := w.Write(b.TailCode); err != nil {
 		return nil, err
@@ -163,67 +158,13 @@ func (b *BzImage) MarshalBinary() ([]byte, error) {
 	return w.Bytes(), nil
 }
 
-// unpack extracts the header code and data from the kernel part
-// of the bzImage. It also uncompresses the kernel.
-// It searches the Kernel []byte for an xz header. Where it begins
-// is never certain. We only do relatively newer images, i.e. we only
-// look for the xz magic.
-func unpack(d []byte) ([]byte, error) {
-	Debug("Kernel is %d bytes", len(d))
-	Debug("Some kernel data: %#02x %#02x", d[:32], d[len(d)-8:])
-	c := exec.Command("xzcat")
-	stdout, err := c.StdoutPipe()
-	if err != nil {
-		return nil, err
-	}
-	stderr, err := c.StderrPipe()
-	if err != nil {
-		return nil, err
+// Compression returns the name of the compression of the kernel, e.g. gzip
+// or xz.
+func (b *BzImage) Compression() string {
+	if b.compressor == nil {
+		return ""
 	}
-	c.Stdin = bytes.NewBuffer(d)
-	if err := c.Start(); err != nil {
-		return nil, err
-	}
-
-	dat, err := ioutil.ReadAll(stdout)
-	if err != nil {
-		return nil, err
-	}
-	// fyi, the xz standard and code are shit. A shame.
-	// You can enable this if you have a nasty bug from xz.
-	// Just be aware that xz ALWAYS errors out even when nothing is wrong.
-	if false {
-		if e, err := ioutil.ReadAll(stderr); err != nil || len(e) > 0 {
-			Debug("xz stderr: '%s', %v", string(e), err)
-		}
-	}
-	Debug("Uncompressed kernel is %d bytes", len(dat))
-	return dat, nil
-}
-
-// compress compresses a []byte via xz using the dictOps, collecting it from stdout
-func compress(b []byte, dictOps string) ([]byte, error) {
-	Debug("b is %d bytes", len(b))
-	c := exec.Command("xz", "--check=crc32", "--x86", dictOps, "--stdout")
-	stdout, err := c.StdoutPipe()
-	if err != nil {
-		return nil, err
-	}
-	c.Stdin = bytes.NewBuffer(b)
-	if err := c.Start(); err != nil {
-		return nil, err
-	}
-
-	dat, err := ioutil.ReadAll(stdout)
-	if err != nil {
-		return nil, err
-	}
-	if err := c.Wait(); err != nil {
-		return nil, err
-	}
-	Debug("Compressed data is %d bytes, starts with %#02x", len(dat), dat[:32])
-	Debug("Last 16 bytes: %#02x", dat[len(dat)-16:])
-	return dat, nil
+	return b.compressor.name
 }
 
 // Extract extracts the KernelCode as an ELF.
@@ -267,26 +208,112 @@ func Equal(a, b []byte) error {
 	return nil
 }
 
+// initRAMFSCompressions is the order in which AddInitRAMFS tries
+// compressions, roughly from best to worst compression.
+var initRAMFSCompressions = []string{"xz", "lzma", "zstd", "bzip2", "gzip", "lz4", "lzo"}
+
+// InitRAMFSCompressions returns the compressions the kernel can unpack an
+// initramfs from.
+//
+// The kernel has a table with the magic, name and decompressor of each
+// compression, in lib/decompress.c. We look for entries of that table: the
+// first 2 bytes of the magic, padding, a pointer to the name and a pointer to
+// the decompressor, which is nil if the kernel was built without it.
+func (b *BzImage) InitRAMFSCompressions() ([]string, error) {
+	f, err := b.ELF()
+	if err != nil {
+		return nil, err
+	}
+	ptrSize := 4
+	if f.Class == elf.ELFCLASS64 {
+		ptrSize = 8
+	}
+	// str returns the NUL terminated string at virtual address va.
+	str := func(va uint64) string {
+		for _, p := range f.Progs {
+			if va < p.Vaddr || va >= p.Vaddr+p.Filesz {
+				continue
+			}
+			off := p.Off + va - p.Vaddr
+			if off >= uint64(len(b.KernelCode)) {
+				return ""
+			}
+			d := b.KernelCode[off:]
+			if i := bytes.IndexByte(d, 0); i >= 0 {
+				return string(d[:i])
+			}
+		}
+		return ""
+	}
+
+	var names []string
+	for _, name := range initRAMFSCompressions {
+		c := compressorByName(name)
+		entry := make([]byte, ptrSize)
+		copy(entry, c.magic[:2])
+		d := b.KernelCode
+		for {
+			x := bytes.Index(d, entry)
+			if x == -1 || x+3*ptrSize > len(d) {
+				break
+			}
+			ptr := func(i int) uint64 {
+				if ptrSize == 8 {
+					return f.ByteOrder.Uint64(d[x+i*ptrSize:])
+				}
+				return uint64(f.ByteOrder.Uint32(d[x+i*ptrSize:]))
+			}
+			if str(ptr(1)) == name && ptr(2) != 0 {
+				names = append(names, name)
+				break
+			}
+			d = d[x+1:]
+		}
+	}
+	return names, nil
+}
+
+// AddInitRAMFS replaces the initramfs built into the kernel with the
+// archive in the named file.
+//
+// The new archive has to fit where the old one was, including its padding:
+// growing it would mean relinking the kernel. If it is too big, it is compressed with the first
+// of the compressions the kernel supports that makes it fit. Archives that
+// are already compressed are used as they are.
 func (b *BzImage) AddInitRAMFS(name string) error {
 	u, err := ioutil.ReadFile(name)
 	if err != nil {
 		return err
 	}
-	// Should we ever want to compress the initramfs this is one
-	// way to do it.
-	d := u
-	if false {
-		d, err = compress(u, "--lzma2=,dict=1MiB")
-		if err != nil {
-			return err
-		}
-	}
 	s, e, err := b.InitRAMFS()
 	if err != nil {
 		return err
 	}
+	// The archive is usually followed by padding we can use too.
+	e = initRAMFSEnd(b.KernelCode, s, e)
 	l := e - s
 
+	d := u
+	if len(d) > l && findCompressor(d) == nil {
+		names, err := b.InitRAMFSCompressions()
+		if err != nil {
+			return err
+		}
+		Debug("Kernel can decompress initramfs compressed with %v", names)
+		for _, n := range names {
+			c, err := compressorByName(n).compressInitRAMFS(u)
+			if err != nil {
+				return err
+			}
+			Debug("%s compressed the initramfs to %d bytes", n, len(c))
+			if len(c) < len(d) {
+				d = c
+			}
+			if len(d) <= l {
+				break
+			}
+		}
+	}
 	if len(d) > l {
 		return fmt.Errorf("New initramfs is %d bytes, won't fit in %d byte old one", len(d), l)
 	}
@@ -378,6 +405,7 @@ func (h *LinuxHeader) String() string {
 // is find the programs what are RW and look for the cpio magic in them. If we find it,
 // we see if it can be read as a cpio and, if so, if there is a /dev or /init inside.
 // We repeat until we succeed or there's nothing left.
+// If there is no cpio, we look for an initramfs AddInitRAMFS compressed.
 func (b *BzImage) InitRAMFS() (int, int, error) {
 	f, err := b.ELF()
 	if err != nil {
@@ -400,42 +428,15 @@ func (b *BzImage) InitRAMFS() (int, int, error) {
 		return -1, -1, fmt.Errorf("Can't find an RWE prog in kernel")
 	}
 
-	archiver, err := cpio.Format("newc")
-	if err != nil {
-		return -1, -1, fmt.Errorf("Format newc not supported: %v", err)
-	}
 	var cur int
 	for cur < len(dat) {
-		x := bytes.Index(dat, []byte("070701"))
+		x := bytes.Index(dat[cur:], []byte("070701"))
 		if x == -1 {
-			return -1, -1, fmt.Errorf("no newc cpio magic found")
-		}
-		if err != nil {
-			return -1, -1, err
+			break
 		}
+		x += cur
 		cur = x
-		var r = bytes.NewReader(dat[cur:])
-		rr := archiver.Reader(r)
-		Debug("r.Len is %v", r.Len())
-		var found bool
-		var size int
-		for {
-			rec, err := rr.ReadRecord()
-			Debug("Check %v", rec)
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				Debug("error reading records: %v", err)
-				break
-			}
-			switch rec.Name {
-			case "init", "dev", "bin", "usr":
-				Debug("Found initramfs at %d, %d bytes", cur, len(dat)-r.Len())
-				found = true
-			}
-			size = int(rec.FilePos) + int(rec.FileSize)
-		}
+		size, found := isInitRAMFS(bytes.NewReader(dat[cur:]))
 		Debug("Size is %d", size)
 		// Add the trailer size.
 		y := x + size
@@ -457,5 +458,105 @@ func (b *BzImage) InitRAMFS() (int, int, error) {
 		}
 		cur += 6
 	}
+	x, y, err := compressedInitRAMFS(dat)
+	if err != nil {
+		return -1, -1, err
+	}
+	x += int(prog.Off)
+	y += int(prog.Off)
+	Debug("InitRAMFS: compressed, return %d, %d", x, y)
+	return x, y, nil
+}
+
+// isInitRAMFS reads a newc cpio archive from r, and returns its size and
+// whether it looks like an initramfs.
+func isInitRAMFS(r io.ReaderAt) (int, bool) {
+	rr := cpio.Newc.Reader(r)
+	var found bool
+	var size int
+	for {
+		rec, err := rr.ReadRecord()
+		Debug("Check %v", rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			Debug("error reading records: %v", err)
+			break
+		}
+		switch rec.Name {
+		case "init", "dev", "bin", "usr":
+			found = true
+		}
+		size = int(rec.FilePos) + int(rec.FileSize)
+	}
+	return size, found
+}
+
+// initRAMFSEnd returns where the space for the initramfs that starts at s
+// and ends at or after e ends.
+//
+// The kernel links the size of the initramfs, __initramfs_size, right after
+// the initramfs, 8 byte aligned. Archives are padded with zeros, so the size
+// is the first word after e that isn't zero.
+func initRAMFSEnd(d []byte, s, e int) int {
+	p := (e + 7) &^ 7
+	for p+8 <= len(d) && binary.LittleEndian.Uint64(d[p:]) == 0 {
+		p += 8
+	}
+	if p+8 > len(d) {
+		return e
+	}
+	if v := binary.LittleEndian.Uint64(d[p:]); v <= uint64(p-s) {
+		if end := s + int(v); end >= e && (end+7)&^7 == p {
+			return end
+		}
+	}
+	return e
+}
+
+// compressedInitRAMFS finds an initramfs compressed by AddInitRAMFS in d
+// and returns where its space starts and ends.
+//
+// AddInitRAMFS puts compressed archives at the end of the space, after
+// zeros. The space is followed by its size, so look for a size that covers
+// only zeros and then a compressed cpio.
+func compressedInitRAMFS(d []byte) (int, int, error) {
+	for p := 8; p+8 <= len(d); p += 8 {
+		v := int(binary.LittleEndian.Uint64(d[p:]))
+		if v <= 0 || v > p {
+			continue
+		}
+		// The space starts 4 byte aligned, and ends in the 8 bytes
+		// before its size.
+		for e := p - 7; e <= p; e++ {
+			s := e - v
+			if s < 0 || s&3 != 0 || len(bytes.Trim(d[e:p], "\x00")) != 0 {
+				continue
+			}
+			x := s
+			for x < e && d[x] == 0 {
+				x++
+			}
+			if c := findCompressor(d[x:e]); c != nil && isCompressedInitRAMFS(c, d[x:e]) {
+				return s, e, nil
+			}
+		}
+	}
 	return -1, -1, fmt.Errorf("no cpio found")
 }
+
+// isCompressedInitRAMFS returns whether d is an initramfs compressed with c.
+func isCompressedInitRAMFS(c *compressor, d []byte) bool {
+	r, err := c.reader(bytes.NewReader(d))
+	if err != nil {
+		return false
+	}
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+	// Trailing garbage is not our problem.
+	u, _ := ioutil.ReadAll(r)
+	_, found := isInitRAMFS(bytes.NewReader(u))
+	return found
+}
@@ -5,7 +5,12 @@
 package bzimage
 
 import (
+	"bytes"
+	"encoding/binary"
 	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/u-root/u-root/pkg/cpio"
@@ -220,3 +225,106 @@ func TestInitRAMFS(t *testing.T) {
 	}
 
 }
+
+func TestAddInitRAMFSCompressed(t *testing.T) {
+	Debug = t.Logf
+	image, err := ioutil.ReadFile("testdata/bzimage-64kurandominitramfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b BzImage
+	if err := b.UnmarshalBinary(image); err != nil {
+		t.Fatal(err)
+	}
+	s, _, err := b.InitRAMFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An initramfs that is too big, but compresses well.
+	var archive bytes.Buffer
+	w := cpio.Newc.Writer(&archive)
+	if err := cpio.WriteRecords(w, []cpio.Record{
+		cpio.Directory("dev", 0755),
+		cpio.StaticFile("init", strings.Repeat("#!/bin/sh\n", 20000), 0755),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cpio.WriteTrailer(w); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "bzimage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(archive.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// This kernel can't decompress an initramfs.
+	if names, err := b.InitRAMFSCompressions(); err != nil || len(names) != 0 {
+		t.Fatalf("InitRAMFSCompressions() = %v, %v, want none", names, err)
+	}
+	if err := b.AddInitRAMFS(f.Name()); err == nil {
+		t.Fatalf("AddInitRAMFS(%d bytes) = nil, want error", archive.Len())
+	}
+
+	// Pretend it was built with xz support, with a decompress table entry
+	// in front of the initramfs.
+	e, err := b.ELF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var va uint64
+	for _, p := range e.Progs {
+		if uint64(s) >= p.Off && uint64(s) < p.Off+p.Filesz {
+			va = p.Vaddr + uint64(s) - 4096 - p.Off
+		}
+	}
+	entry := b.KernelCode[s-4096:]
+	copy(entry, []byte{0xfd, 0x37, 0, 0, 0, 0, 0, 0})
+	binary.LittleEndian.PutUint64(entry[8:], va+32)
+	binary.LittleEndian.PutUint64(entry[16:], va)
+	copy(entry[32:], "xz\x00")
+	if names, err := b.InitRAMFSCompressions(); err != nil || !reflect.DeepEqual(names, []string{"xz"}) {
+		t.Fatalf("InitRAMFSCompressions() = %v, %v, want [xz]", names, err)
+	}
+	if err := b.AddInitRAMFS(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := b.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n BzImage
+	if err := n.UnmarshalBinary(d); err != nil {
+		t.Fatal(err)
+	}
+	ns, ne, err := n.InitRAMFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns != s || ne != s+66048 {
+		t.Errorf("InitRAMFS() = %d, %d, want %d, %d", ns, ne, s, s+66048)
+	}
+	u := bytes.TrimLeft(n.KernelCode[ns:ne], "\x00")
+	r, err := compressorByName("xz").reader(bytes.NewReader(u))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, archive.Bytes()) {
+		t.Errorf("initramfs decompressed to %d bytes, want the %d byte archive", len(got), archive.Len())
+	}
+
+	// And it can be replaced again.
+	if err := n.AddInitRAMFS("testdata/init.cpio"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,465 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bzimage
+
+import (
+	"bytes"
+	"container/heap"
+)
+
+// The standard library only has a bzip2 decompressor, so this is a
+// compressor to go with it. It follows the reference implementation in its
+// choice of block size, Huffman table count and selector assignment, but
+// uses a plain prefix doubling BWT.
+const (
+	// bzip2BlockSize is the largest block bzip2 -9 makes, after the
+	// initial run length encoding.
+	bzip2BlockSize = 900000 - 19
+
+	bzip2GroupSize  = 50
+	bzip2MaxCodeLen = 17
+	bzip2Iterations = 4
+)
+
+// bzip2CRC is the big endian CRC32 that bzip2 uses.
+var bzip2CRCTable = func() (t [256]uint32) {
+	for i := range t {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04c11db7
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}()
+
+func bzip2CRC(b []byte) uint32 {
+	c := ^uint32(0)
+	for _, v := range b {
+		c = c<<8 ^ bzip2CRCTable[byte(c>>24)^v]
+	}
+	return ^c
+}
+
+// bitWriter writes bits most significant first.
+type bitWriter struct {
+	b    bytes.Buffer
+	bits uint64
+	n    uint
+}
+
+func (w *bitWriter) write(n uint, v uint64) {
+	w.bits = w.bits<<n | v&(1<<n-1)
+	w.n += n
+	for w.n >= 8 {
+		w.n -= 8
+		w.b.WriteByte(byte(w.bits >> w.n))
+	}
+}
+
+func (w *bitWriter) flush() []byte {
+	if w.n > 0 {
+		w.write(8-w.n, 0)
+	}
+	return w.b.Bytes()
+}
+
+// bzip2Compress compresses b like bzip2 -9.
+func bzip2Compress(b []byte) ([]byte, error) {
+	w := &bitWriter{}
+	w.write(32, 'B'<<24|'Z'<<16|'h'<<8|'9')
+	var crc uint32
+	for len(b) > 0 {
+		block, n := bzip2RLE(b)
+		blockCRC := bzip2CRC(b[:n])
+		crc = (crc<<1 | crc>>31) ^ blockCRC
+		bzip2WriteBlock(w, block, blockCRC)
+		b = b[n:]
+	}
+	w.write(24, 0x177245)
+	w.write(24, 0x385090)
+	w.write(32, uint64(crc))
+	return w.flush(), nil
+}
+
+// bzip2RLE run length encodes a block worth of b: runs of 4 to 255 equal
+// bytes become 4 bytes and a count of the remaining ones. It returns the
+// encoded block and how many bytes of b it holds.
+func bzip2RLE(b []byte) ([]byte, int) {
+	var out []byte
+	i := 0
+	for i < len(b) && len(out) < bzip2BlockSize-5 {
+		c := b[i]
+		n := 1
+		for n < 255 && i+n < len(b) && b[i+n] == c {
+			n++
+		}
+		if n < 4 {
+			out = append(out, c)
+			i++
+			continue
+		}
+		out = append(out, c, c, c, c, byte(n-4))
+		i += n
+	}
+	return out, i
+}
+
+// bwt returns the last column of the sorted rotations of b, and the row of
+// b itself.
+func bwt(b []byte) ([]byte, int) {
+	n := len(b)
+	p := make([]int32, n)
+	c := make([]int32, n)
+	pn := make([]int32, n)
+	cn := make([]int32, n)
+	cnt := make([]int32, n+256)
+
+	for _, v := range b {
+		cnt[v]++
+	}
+	for i := 1; i < 256; i++ {
+		cnt[i] += cnt[i-1]
+	}
+	for i := n - 1; i >= 0; i-- {
+		cnt[b[i]]--
+		p[cnt[b[i]]] = int32(i)
+	}
+	classes := int32(1)
+	for i := 1; i < n; i++ {
+		if b[p[i]] != b[p[i-1]] {
+			classes++
+		}
+		c[p[i]] = classes - 1
+	}
+
+	// Each round sorts rotations by twice as many leading bytes, using
+	// the classes of the previous round.
+	for h := 1; h < n && int(classes) < n; h <<= 1 {
+		for i := range p {
+			v := int(p[i]) - h
+			if v < 0 {
+				v += n
+			}
+			pn[i] = int32(v)
+		}
+		for i := int32(0); i < classes; i++ {
+			cnt[i] = 0
+		}
+		for _, v := range pn {
+			cnt[c[v]]++
+		}
+		for i := int32(1); i < classes; i++ {
+			cnt[i] += cnt[i-1]
+		}
+		for i := n - 1; i >= 0; i-- {
+			v := pn[i]
+			cnt[c[v]]--
+			p[cnt[c[v]]] = v
+		}
+		classes = 1
+		cn[p[0]] = 0
+		for i := 1; i < n; i++ {
+			a, b := int(p[i]), int(p[i-1])
+			if c[a] != c[b] || c[(a+h)%n] != c[(b+h)%n] {
+				classes++
+			}
+			cn[p[i]] = classes - 1
+		}
+		c, cn = cn, c
+	}
+
+	last := make([]byte, n)
+	var orig int
+	for i, v := range p {
+		if v == 0 {
+			orig = i
+			v = int32(n)
+		}
+		last[i] = b[v-1]
+	}
+	return last, orig
+}
+
+func bzip2WriteBlock(w *bitWriter, block []byte, crc uint32) {
+	last, orig := bwt(block)
+
+	var inUse [256]bool
+	for _, v := range block {
+		inUse[v] = true
+	}
+	var unseqToSeq [256]byte
+	var mtf []byte
+	for i, used := range inUse {
+		if used {
+			unseqToSeq[i] = byte(len(mtf))
+			mtf = append(mtf, byte(len(mtf)))
+		}
+	}
+	alphaSize := len(mtf) + 2
+	eob := uint16(alphaSize - 1)
+
+	// Move to front, with runs of zeros written in bijective base 2 with
+	// the RUNA (1) and RUNB (2) symbols.
+	var syms []uint16
+	zeros := 0
+	runs := func() {
+		for zeros > 0 {
+			zeros--
+			syms = append(syms, uint16(zeros&1))
+			zeros >>= 1
+		}
+	}
+	for _, v := range last {
+		s := unseqToSeq[v]
+		j := bytes.IndexByte(mtf, s)
+		if j == 0 {
+			zeros++
+			continue
+		}
+		runs()
+		copy(mtf[1:j+1], mtf[:j])
+		mtf[0] = s
+		syms = append(syms, uint16(j+1))
+	}
+	runs()
+	syms = append(syms, eob)
+
+	nGroups := 6
+	switch n := len(syms); {
+	case n < 200:
+		nGroups = 2
+	case n < 600:
+		nGroups = 3
+	case n < 1200:
+		nGroups = 4
+	case n < 2400:
+		nGroups = 5
+	}
+	lens, selectors := bzip2Tables(syms, alphaSize, nGroups)
+
+	w.write(24, 0x314159)
+	w.write(24, 0x265359)
+	w.write(32, uint64(crc))
+	// Not randomized.
+	w.write(1, 0)
+	w.write(24, uint64(orig))
+
+	var ranges uint64
+	for i := 0; i < 16; i++ {
+		for _, used := range inUse[i*16 : i*16+16] {
+			if used {
+				ranges |= 1 << uint(15-i)
+				break
+			}
+		}
+	}
+	w.write(16, ranges)
+	for i := 0; i < 16; i++ {
+		if ranges&(1<<uint(15-i)) == 0 {
+			continue
+		}
+		var m uint64
+		for j, used := range inUse[i*16 : i*16+16] {
+			if used {
+				m |= 1 << uint(15-j)
+			}
+		}
+		w.write(16, m)
+	}
+
+	w.write(3, uint64(nGroups))
+	w.write(15, uint64(len(selectors)))
+	order := []byte{0, 1, 2, 3, 4, 5}[:nGroups]
+	for _, s := range selectors {
+		j := bytes.IndexByte(order, s)
+		for k := 0; k < j; k++ {
+			w.write(1, 1)
+		}
+		w.write(1, 0)
+		copy(order[1:j+1], order[:j])
+		order[0] = s
+	}
+
+	for _, l := range lens {
+		cur := l[0]
+		w.write(5, uint64(cur))
+		for _, v := range l {
+			for ; cur < v; cur++ {
+				w.write(2, 2)
+			}
+			for ; cur > v; cur-- {
+				w.write(2, 3)
+			}
+			w.write(1, 0)
+		}
+	}
+
+	codes := make([][]uint32, nGroups)
+	for t, l := range lens {
+		codes[t] = canonicalCodes(l)
+	}
+	for i, s := range selectors {
+		g := syms[i*bzip2GroupSize:]
+		if len(g) > bzip2GroupSize {
+			g = g[:bzip2GroupSize]
+		}
+		for _, v := range g {
+			w.write(uint(lens[s][v]), uint64(codes[s][v]))
+		}
+	}
+}
+
+// bzip2Tables makes nGroups Huffman tables for syms and picks one for each
+// group of 50 symbols.
+func bzip2Tables(syms []uint16, alphaSize, nGroups int) ([][]uint8, []byte) {
+	freq := make([]int, alphaSize)
+	for _, v := range syms {
+		freq[v]++
+	}
+
+	// Start with tables that each cover a range of symbols with
+	// roughly equal total frequency.
+	lens := make([][]uint8, nGroups)
+	remaining := len(syms)
+	lo := 0
+	for t := nGroups; t > 0; t-- {
+		target := remaining / t
+		hi, sum := lo-1, 0
+		for sum < target && hi < alphaSize-1 {
+			hi++
+			sum += freq[hi]
+		}
+		l := make([]uint8, alphaSize)
+		for v := range l {
+			if v < lo || v > hi {
+				l[v] = 15
+			}
+		}
+		lens[nGroups-t] = l
+		lo = hi + 1
+		remaining -= sum
+	}
+
+	selectors := make([]byte, (len(syms)+bzip2GroupSize-1)/bzip2GroupSize)
+	for iter := 0; iter < bzip2Iterations; iter++ {
+		freqs := make([][]int, nGroups)
+		for t := range freqs {
+			freqs[t] = make([]int, alphaSize)
+		}
+		for i := range selectors {
+			g := syms[i*bzip2GroupSize:]
+			if len(g) > bzip2GroupSize {
+				g = g[:bzip2GroupSize]
+			}
+			best, bestCost := 0, -1
+			for t, l := range lens {
+				cost := 0
+				for _, v := range g {
+					cost += int(l[v])
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = t, cost
+				}
+			}
+			selectors[i] = byte(best)
+			for _, v := range g {
+				freqs[best][v]++
+			}
+		}
+		for t := range lens {
+			lens[t] = huffmanLengths(freqs[t], bzip2MaxCodeLen)
+		}
+	}
+	return lens, selectors
+}
+
+type huffmanNode struct {
+	weight      int
+	left, right int
+}
+
+type huffmanHeap struct {
+	nodes []huffmanNode
+	idx   []int
+}
+
+func (h *huffmanHeap) Len() int           { return len(h.idx) }
+func (h *huffmanHeap) Less(i, j int) bool { return h.nodes[h.idx[i]].weight < h.nodes[h.idx[j]].weight }
+func (h *huffmanHeap) Swap(i, j int)      { h.idx[i], h.idx[j] = h.idx[j], h.idx[i] }
+func (h *huffmanHeap) Push(x interface{}) { h.idx = append(h.idx, x.(int)) }
+func (h *huffmanHeap) Pop() interface{} {
+	x := h.idx[len(h.idx)-1]
+	h.idx = h.idx[:len(h.idx)-1]
+	return x
+}
+
+// huffmanLengths returns Huffman code lengths of at most maxLen bits for
+// freq. Every symbol gets a code, even if it is unused.
+func huffmanLengths(freq []int, maxLen int) []uint8 {
+	f := make([]int, len(freq))
+	for i, v := range freq {
+		f[i] = v
+		if v == 0 {
+			f[i] = 1
+		}
+	}
+	lens := make([]uint8, len(f))
+	for {
+		h := &huffmanHeap{}
+		for i, v := range f {
+			h.nodes = append(h.nodes, huffmanNode{weight: v, left: -1, right: -1})
+			h.idx = append(h.idx, i)
+		}
+		heap.Init(h)
+		for h.Len() > 1 {
+			a := heap.Pop(h).(int)
+			b := heap.Pop(h).(int)
+			h.nodes = append(h.nodes, huffmanNode{weight: h.nodes[a].weight + h.nodes[b].weight, left: a, right: b})
+			heap.Push(h, len(h.nodes)-1)
+		}
+
+		tooLong := false
+		var walk func(n, depth int)
+		walk = func(n, depth int) {
+			if h.nodes[n].left < 0 {
+				lens[n] = uint8(depth)
+				if depth > maxLen {
+					tooLong = true
+				}
+				return
+			}
+			walk(h.nodes[n].left, depth+1)
+			walk(h.nodes[n].right, depth+1)
+		}
+		walk(len(h.nodes)-1, 0)
+		if !tooLong {
+			return lens
+		}
+		for i := range f {
+			f[i] = 1 + f[i]/2
+		}
+	}
+}
+
+// canonicalCodes assigns codes to lengths in order of length and symbol.
+func canonicalCodes(lens []uint8) []uint32 {
+	codes := make([]uint32, len(lens))
+	var code uint32
+	for l := uint8(1); l <= 32; l++ {
+		for v, vl := range lens {
+			if vl == l {
+				codes[v] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return codes
+}
@@ -0,0 +1,308 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bzimage
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// A compressor is one of the ways Linux can compress the kernel in a
+// bzImage.
+//
+// All but gzip, which has its own trailer with the size, have the size of the
+// uncompressed kernel appended as a 32 bit little endian value. That is
+// what the kernel decompressor uses, so we use it too: decompression reads
+// that many bytes and ignores what comes after it.
+type compressor struct {
+	name  string
+	magic []byte
+	// reader returns a decompressing reader.
+	reader func(io.Reader) (io.Reader, error)
+	// compress compresses in-process.
+	compress func([]byte) ([]byte, error)
+	// initramfs compresses an initramfs, if that is done differently
+	// from compressing a kernel.
+	initramfs func([]byte) ([]byte, error)
+	// patch compresses a kernel that differs from the one in a payload
+	// by reusing what it can of the payload, for when compress does not
+	// do well enough.
+	patch func(old, oldKernel, b []byte) ([]byte, error)
+}
+
+var compressors = []*compressor{
+	{
+		name:  "gzip",
+		magic: []byte{0x1f, 0x8b, 0x08},
+		reader: func(r io.Reader) (io.Reader, error) {
+			z, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			z.Multistream(false)
+			return z, nil
+		},
+		compress: func(b []byte) ([]byte, error) {
+			var w bytes.Buffer
+			z, err := gzip.NewWriterLevel(&w, gzip.BestCompression)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := z.Write(b); err != nil {
+				return nil, err
+			}
+			if err := z.Close(); err != nil {
+				return nil, err
+			}
+			return w.Bytes(), nil
+		},
+	},
+	{
+		name:     "xz",
+		magic:    xzHeaderMagic,
+		reader:   newXZReader,
+		compress: xzCompress,
+		patch:    xzPatch,
+		// The kernel documentation has initramfs archives compressed
+		// with xz --check=crc32 --lzma2=dict=1MiB.
+		initramfs: func(b []byte) ([]byte, error) {
+			return compressWriter(b, func(w io.Writer) (io.WriteCloser, error) {
+				return xz.WriterConfig{CheckSum: xz.CRC32, DictCap: 1 << 20}.NewWriter(w)
+			})
+		},
+	},
+	{
+		name:   "lzma",
+		magic:  []byte{0x5d, 0x00, 0x00},
+		reader: newLZMAReader,
+		compress: func(b []byte) ([]byte, error) {
+			return compressWriter(b, func(w io.Writer) (io.WriteCloser, error) {
+				return lzma.WriterConfig{Size: int64(len(b)), DictCap: 32 << 20}.NewWriter(w)
+			})
+		},
+	},
+	{
+		name:     "bzip2",
+		magic:    []byte{'B', 'Z', 'h'},
+		reader:   func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil },
+		compress: bzip2Compress,
+	},
+	{
+		name:     "lzo",
+		magic:    lzopMagic,
+		reader:   newLZOPReader,
+		compress: lzopCompress,
+	},
+	{
+		name:     "lz4",
+		magic:    []byte{0x02, 0x21, 0x4c, 0x18},
+		reader:   newLZ4Reader,
+		compress: lz4Compress,
+	},
+	{
+		name:  "zstd",
+		magic: []byte{0x28, 0xb5, 0x2f, 0xfd},
+		reader: func(r io.Reader) (io.Reader, error) {
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+		compress: func(b []byte) ([]byte, error) {
+			e, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression), zstd.WithEncoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			defer e.Close()
+			return e.EncodeAll(b, nil), nil
+		},
+	},
+}
+
+// lzmaMaxDictCap is the dictionary size of lzma -9, which the kernel build
+// uses.
+const lzmaMaxDictCap = 64 << 20
+
+// newLZMAReader returns a reader for the lzma file in r. The lzma package
+// allocates the dictionary size in the header, so bigger ones than the kernel
+// build makes are rejected.
+func newLZMAReader(r io.Reader) (io.Reader, error) {
+	h := make([]byte, lzma.HeaderLen)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return nil, err
+	}
+	if n := binary.LittleEndian.Uint32(h[1:]); n > lzmaMaxDictCap {
+		return nil, fmt.Errorf("lzma: %d byte dictionary is too big", n)
+	}
+	return lzma.NewReader(io.MultiReader(bytes.NewReader(h), r))
+}
+
+func compressWriter(b []byte, newWriter func(io.Writer) (io.WriteCloser, error)) ([]byte, error) {
+	var w bytes.Buffer
+	z, err := newWriter(&w)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := z.Write(b); err != nil {
+		return nil, err
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+// findCompressor returns the compressor whose magic d starts with.
+func findCompressor(d []byte) *compressor {
+	for _, c := range compressors {
+		if bytes.HasPrefix(d, c.magic) {
+			return c
+		}
+	}
+	return nil
+}
+
+// searchCompressor returns the first offset in d with a kernel payload, and
+// its compressor. It is for bzImages too old to record where their payload
+// is.
+//
+// A magic alone is not enough to go by, as the one of lzma is 3 bytes that
+// are common in setup code, so a payload must also start to decompress to
+// the ELF header of vmlinux.
+func searchCompressor(d []byte) (int, *compressor) {
+	type match struct {
+		off int
+		c   *compressor
+	}
+	var matches []match
+	for _, c := range compressors {
+		for i := 0; ; {
+			j := bytes.Index(d[i:], c.magic)
+			if j == -1 {
+				break
+			}
+			matches = append(matches, match{i + j, c})
+			i += j + 1
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].off < matches[j].off })
+	for _, m := range matches {
+		if m.c.isKernel(d[m.off:]) {
+			return m.off, m.c
+		}
+	}
+	return -1, nil
+}
+
+// isKernel returns whether d starts with a payload that decompresses to an
+// ELF file, as x86 kernels do.
+func (c *compressor) isKernel(d []byte) bool {
+	r, err := c.reader(bytes.NewReader(d))
+	if err != nil {
+		return false
+	}
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+	magic := make([]byte, len(elf.ELFMAG))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return false
+	}
+	return string(magic) == elf.ELFMAG
+}
+
+// compressorByName returns the compressor called name.
+func compressorByName(name string) *compressor {
+	for _, c := range compressors {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// compressInitRAMFS compresses an initramfs archive.
+func (c *compressor) compressInitRAMFS(b []byte) ([]byte, error) {
+	if c.initramfs != nil {
+		return c.initramfs(b)
+	}
+	return c.compress(b)
+}
+
+// decompress uncompresses a kernel payload. The uncompressed size is in the
+// last 4 bytes.
+//
+// The size is only a limit until the kernel is read, so a payload that lies
+// about it cannot make us allocate more than it decompresses to.
+func (c *compressor) decompress(d []byte) ([]byte, error) {
+	if len(d) < 4 {
+		return nil, fmt.Errorf("%s: %d byte payload is too short", c.name, len(d))
+	}
+	size := binary.LittleEndian.Uint32(d[len(d)-4:])
+	r, err := c.reader(bytes.NewReader(d))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", c.name, err)
+	}
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+	dat, err := ioutil.ReadAll(io.LimitReader(r, int64(size)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: reading %d byte kernel: %v", c.name, size, err)
+	}
+	if len(dat) != int(size) {
+		return nil, fmt.Errorf("%s: reading %d byte kernel: %v", c.name, size, io.ErrUnexpectedEOF)
+	}
+	return dat, nil
+}
+
+// pack compresses a kernel into a payload of exactly the size of old, the
+// payload it replaces, ending with the uncompressed size.
+func (c *compressor) pack(b, old []byte) ([]byte, error) {
+	size := len(old)
+	dat, err := c.compress(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", c.name, err)
+	}
+	Debug("%s compressed %d bytes to %d", c.name, len(b), len(dat))
+	if len(dat)+4 > size && c.patch != nil {
+		if p, err := c.repack(b, old); err != nil {
+			Debug("%s: %v", c.name, err)
+		} else if len(p) < len(dat) {
+			Debug("%s compressed %d bytes to %d reusing the old payload", c.name, len(b), len(p))
+			dat = p
+		}
+	}
+	if len(dat)+4 > size {
+		return nil, fmt.Errorf("Marshal: compressed KernelCode too big: was %d, now %d", size, len(dat)+4)
+	}
+	// The kernel decompressors stop at the end of the stream, so pad
+	// with zeros up to the size.
+	n := make([]byte, size)
+	copy(n, dat)
+	binary.LittleEndian.PutUint32(n[size-4:], uint32(len(b)))
+	return n, nil
+}
+
+// repack compresses b with c.patch, reusing what it can of the payload old.
+func (c *compressor) repack(b, old []byte) ([]byte, error) {
+	k, err := c.decompress(old)
+	if err != nil {
+		return nil, err
+	}
+	return c.patch(old, k, b)
+}
@@ -0,0 +1,259 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bzimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os/exec"
+	"testing"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// testKernel returns something that compresses a bit like a kernel: code,
+// runs of zeros and a bit of noise, spanning several compression blocks.
+func testKernel(t *testing.T) []byte {
+	image, err := ioutil.ReadFile("testdata/bzImage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bz BzImage
+	if err := bz.UnmarshalBinary(image); err != nil {
+		t.Fatal(err)
+	}
+	code := bz.KernelCode[:1<<20]
+	var b bytes.Buffer
+	rnd := rand.New(rand.NewSource(1))
+	for b.Len() < 1200000 {
+		b.Write(code[:rnd.Intn(len(code))])
+		b.Write(make([]byte, rnd.Intn(4096)))
+		noise := make([]byte, rnd.Intn(512))
+		rnd.Read(noise)
+		b.Write(noise)
+	}
+	return b.Bytes()
+}
+
+func TestCompressors(t *testing.T) {
+	kernel := testKernel(t)
+	for _, c := range compressors {
+		t.Run(c.name, func(t *testing.T) {
+			for _, data := range [][]byte{kernel, []byte("x"), bytes.Repeat([]byte{0}, 300)} {
+				dat, err := c.compress(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if findCompressor(dat) != c {
+					t.Fatalf("compressed data starts with %#x, want magic %#x", dat[:8], c.magic)
+				}
+				if len(data) == len(kernel) && len(dat) >= len(data)/2 {
+					t.Errorf("compressed %d bytes to %d", len(data), len(dat))
+				}
+				p, err := c.pack(data, make([]byte, len(dat)+100))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := c.decompress(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Errorf("decompress(pack(%d bytes)) returned %d different bytes", len(data), len(got))
+				}
+			}
+			if _, err := c.pack(kernel, make([]byte, 100)); err == nil {
+				t.Errorf("pack(%d bytes, 100) = nil, want error", len(kernel))
+			}
+		})
+	}
+}
+
+// TestDecompressBadSize checks that a payload claiming to hold a bigger
+// kernel than it does is an error.
+func TestDecompressBadSize(t *testing.T) {
+	for _, c := range compressors {
+		dat, err := c.compress([]byte("not much of a kernel"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := append(dat, 0xff, 0xff, 0xff, 0xff)
+		if _, err := c.decompress(p); err == nil {
+			t.Errorf("%s: decompress(%d byte payload of a 4 GiB kernel) = nil, want error", c.name, len(p))
+		}
+	}
+}
+
+// TestSearchCompressor checks that bytes that look like the start of a
+// payload, as lzma's magic does in setup code, are passed over.
+func TestSearchCompressor(t *testing.T) {
+	image, err := ioutil.ReadFile("testdata/bzImage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bz BzImage
+	if err := bz.UnmarshalBinary(image); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range compressors {
+		dat, err := c.compress(bz.KernelCode[:4096])
+		if err != nil {
+			t.Fatal(err)
+		}
+		head := []byte{0xb8, 0x5d, 0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x1f, 0x8b, 0x08, 0x00}
+		d := append(append(head, dat...), 0, 0, 0, 0)
+		if x, got := searchCompressor(d); x != len(head) || got != c {
+			t.Errorf("%s: searchCompressor = %d, %v, want %d, %s", c.name, x, got, len(head), c.name)
+		}
+	}
+	if x, got := searchCompressor([]byte{0x5d, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff}); got != nil {
+		t.Errorf("searchCompressor(lzma magic with a 4 GiB dictionary) = %d, %s, want -1, nil", x, got.name)
+	}
+}
+
+// TestXZBadBlockHeader checks that a block header with filter properties
+// bigger than itself is an error.
+func TestXZBadBlockHeader(t *testing.T) {
+	d := append([]byte{}, xzHeaderMagic...)
+	// Stream flags for CRC32, and the CRC32 of the stream header.
+	d = append(d, 0, 1, 0, 0, 0, 0)
+	// A 16 byte block header with one filter, 0x21, whose properties
+	// are a uvarint of 1<<48-1 bytes.
+	h := []byte{3, 0, 0x21, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0, 0, 0, 0, 0, 0}
+	d = append(d, h...)
+	if _, err := newXZReader(bytes.NewReader(d)); err == nil {
+		t.Errorf("newXZReader(block header with huge filter properties) = nil, want error")
+	}
+}
+
+// tools are the commands that compress kernels for the Linux build.
+var tools = map[string][]string{
+	"gzip":  {"gzip", "-n", "-9", "-c"},
+	"xz":    {"xz", "--check=crc32", "--x86", "--lzma2=,dict=32MiB", "--stdout"},
+	"lzma":  {"lzma", "-9", "-c"},
+	"bzip2": {"bzip2", "-9", "-c"},
+	"lzo":   {"lzop", "-9", "-c"},
+	"lz4":   {"lz4", "-l", "-9", "-c"},
+	"zstd":  {"zstd", "-22", "--ultra", "-c"},
+}
+
+// TestCompressorTools checks that the compressors read what the tools used to
+// build kernels write, and the other way round.
+func TestCompressorTools(t *testing.T) {
+	kernel := testKernel(t)
+	for _, c := range compressors {
+		t.Run(c.name, func(t *testing.T) {
+			tool := tools[c.name]
+			if _, err := exec.LookPath(tool[0]); err != nil {
+				t.Skipf("%s not installed", tool[0])
+			}
+			cmd := exec.Command(tool[0], tool[1:]...)
+			cmd.Stdin = bytes.NewReader(kernel)
+			dat, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("%v: %v", tool, err)
+			}
+			p := append(dat, 0, 0, 0, 0)
+			p[len(p)-4], p[len(p)-3], p[len(p)-2], p[len(p)-1] = byte(len(kernel)), byte(len(kernel)>>8), byte(len(kernel)>>16), byte(len(kernel)>>24)
+			got, err := c.decompress(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, kernel) {
+				t.Errorf("decompressing %s output returned different bytes", tool[0])
+			}
+
+			if dat, err = c.compress(kernel); err != nil {
+				t.Fatal(err)
+			}
+			cmd = exec.Command(tool[0], "-d", "-c")
+			cmd.Stdin = bytes.NewReader(dat)
+			if got, err = cmd.Output(); err != nil {
+				t.Fatalf("%s -d: %v", tool[0], err)
+			}
+			if !bytes.Equal(got, kernel) {
+				t.Errorf("%s -d returned different bytes", tool[0])
+			}
+		})
+	}
+}
+
+func TestXZPatch(t *testing.T) {
+	image, err := ioutil.ReadFile("testdata/bzImage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bz BzImage
+	if err := bz.UnmarshalBinary(image); err != nil {
+		t.Fatal(err)
+	}
+	if bz.Compression() != "xz" {
+		t.Fatalf("testdata/bzImage is compressed with %s, want xz", bz.Compression())
+	}
+	kernel := append([]byte{}, bz.KernelCode...)
+	copy(kernel[len(kernel)-70000:], bytes.Repeat([]byte("u-root"), 1000))
+
+	dat, err := xzPatch(bz.compressed, bz.KernelCode, kernel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := xzCompress(kernel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dat) >= len(full) {
+		t.Errorf("xzPatch compressed to %d bytes, xzCompress to %d, want less", len(dat), len(full))
+	}
+	size := make([]byte, 4)
+	binary.LittleEndian.PutUint32(size, uint32(len(kernel)))
+	got, err := compressorByName("xz").decompress(append(dat, size...))
+	if err == nil && !bytes.Equal(got, kernel) {
+		err = fmt.Errorf("got %d different bytes", len(got))
+	}
+	if err != nil {
+		t.Errorf("decompress(xzPatch()): %v", err)
+	}
+
+	if _, err := exec.LookPath("xz"); err != nil {
+		return
+	}
+	cmd := exec.Command("xz", "-d", "-c")
+	cmd.Stdin = bytes.NewReader(dat)
+	if got, err = cmd.Output(); err != nil {
+		t.Fatalf("xz -d: %v", err)
+	}
+	if !bytes.Equal(got, kernel) {
+		t.Errorf("xz -d returned different bytes")
+	}
+}
+
+// TestLZMA2 checks that LZMA2 chunks from lzmaEncoder decompress, whether
+// they start the data or continue from the bytes before them.
+func TestLZMA2(t *testing.T) {
+	kernel := testKernel(t)
+	for _, start := range []int{0, 1000, 60000} {
+		var data []byte
+		if start > 0 {
+			// The first bytes are in an uncompressed chunk.
+			data = append([]byte{lzma2RawDict, byte((start - 1) >> 8), byte(start - 1)}, kernel[:start]...)
+			data = append(data, newLZMAEncoder(kernel, xzDictCap).lzma2(start, lzma2ChunkProps, nil)...)
+		} else {
+			data = newLZMAEncoder(kernel, xzDictCap).lzma2(0, lzma2ChunkDict, nil)
+		}
+		z, err := lzma.Reader2Config{DictCap: xzDictCap}.NewReader2(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(z)
+		if err != nil {
+			t.Errorf("start %d: reading LZMA2 chunks: %v", start, err)
+		} else if !bytes.Equal(got, kernel) {
+			t.Errorf("start %d: LZMA2 chunks decompress to different bytes", start)
+		}
+	}
+}
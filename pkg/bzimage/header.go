@@ -6,6 +6,8 @@
 // Thanks to coreboot for documenting the basic layout.
 package bzimage
 
+import "crypto/sha256"
+
 const (
 	Ram      e820type = 1
 	Reserved          = 2
@@ -251,4 +253,7 @@ type BzImage struct {
 	KernelBase   uintptr
 	KernelOffset uintptr
 	compressed   []byte
+	compressor   *compressor
+	// kernelSum is the SHA256 of the KernelCode that compressed holds.
+	kernelSum [sha256.Size]byte
 }
@@ -0,0 +1,213 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bzimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Linux compresses kernels with `lz4 -l`, the legacy LZ4 frame format: a
+// magic number followed by blocks of independently compressed 8 MiB chunks,
+// each preceded by its compressed size.
+const (
+	lz4LegacyMagic     = 0x184c2102
+	lz4LegacyBlockSize = 8 << 20
+
+	// The last 5 bytes of a block are always literals, and the last
+	// match must start at least 12 bytes before the end.
+	lz4LastLiterals = 5
+	lz4MatchLimit   = 12
+)
+
+// lz4Reader decompresses the legacy LZ4 frame format.
+type lz4Reader struct {
+	r     io.Reader
+	block []byte
+	buf   []byte
+}
+
+func newLZ4Reader(r io.Reader) (io.Reader, error) {
+	var magic uint32
+	if err := binary.Read(r, binary.LittleEndian, &magic); err != nil {
+		return nil, err
+	}
+	if magic != lz4LegacyMagic {
+		return nil, fmt.Errorf("lz4: magic is %#x, want legacy format magic %#x", magic, lz4LegacyMagic)
+	}
+	return &lz4Reader{r: r}, nil
+}
+
+// Read implements io.Reader.
+func (l *lz4Reader) Read(p []byte) (int, error) {
+	for len(l.block) == 0 {
+		var size uint32
+		if err := binary.Read(l.r, binary.LittleEndian, &size); err != nil {
+			return 0, err
+		}
+		// Concatenated frames repeat the magic.
+		if size == lz4LegacyMagic {
+			continue
+		}
+		if size > lz4LegacyBlockSize+lz4LegacyBlockSize/255+16 {
+			return 0, fmt.Errorf("lz4: compressed block size %d is too big", size)
+		}
+		if cap(l.buf) < int(size) {
+			l.buf = make([]byte, size)
+		}
+		src := l.buf[:size]
+		if _, err := io.ReadFull(l.r, src); err != nil {
+			return 0, err
+		}
+		b, err := lz4DecompressBlock(make([]byte, 0, lz4LegacyBlockSize), src)
+		if err != nil {
+			return 0, err
+		}
+		l.block = b
+	}
+	n := copy(p, l.block)
+	l.block = l.block[n:]
+	return n, nil
+}
+
+// lz4DecompressBlock appends the decompressed LZ4 block src to dst.
+func lz4DecompressBlock(dst, src []byte) ([]byte, error) {
+	start := len(dst)
+	// length reads a length continued in bytes of 255.
+	length := func(i, l int) (int, int, error) {
+		for {
+			if i >= len(src) {
+				return 0, 0, io.ErrUnexpectedEOF
+			}
+			b := src[i]
+			i++
+			l += int(b)
+			if b != 255 {
+				return i, l, nil
+			}
+		}
+	}
+
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+
+		lit := int(token >> 4)
+		if lit == 15 {
+			var err error
+			if i, lit, err = length(i, lit); err != nil {
+				return nil, err
+			}
+		}
+		if lit > len(src)-i {
+			return nil, fmt.Errorf("lz4: literals run past the end of the block")
+		}
+		dst = append(dst, src[i:i+lit]...)
+		i += lit
+
+		// The last sequence has no match.
+		if i == len(src) {
+			break
+		}
+		if i+2 > len(src) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		off := int(binary.LittleEndian.Uint16(src[i:]))
+		i += 2
+		if off == 0 || off > len(dst)-start {
+			return nil, fmt.Errorf("lz4: invalid match offset %d", off)
+		}
+
+		ml := int(token & 15)
+		if ml == 15 {
+			var err error
+			if i, ml, err = length(i, ml); err != nil {
+				return nil, err
+			}
+		}
+		ml += 4
+		// Matches may overlap their own output.
+		m := len(dst) - off
+		for j := 0; j < ml; j++ {
+			dst = append(dst, dst[m+j])
+		}
+	}
+	return dst, nil
+}
+
+// lz4CompressBlock compresses src into an LZ4 block.
+func lz4CompressBlock(src []byte) []byte {
+	var dst bytes.Buffer
+	length := func(l int) {
+		for ; l >= 255; l -= 255 {
+			dst.WriteByte(255)
+		}
+		dst.WriteByte(byte(l))
+	}
+	sequence := func(lit []byte, dist, ml int) {
+		var token byte
+		if len(lit) >= 15 {
+			token = 15 << 4
+		} else {
+			token = byte(len(lit)) << 4
+		}
+		if dist > 0 {
+			if ml-4 >= 15 {
+				token |= 15
+			} else {
+				token |= byte(ml - 4)
+			}
+		}
+		dst.WriteByte(token)
+		if len(lit) >= 15 {
+			length(len(lit) - 15)
+		}
+		dst.Write(lit)
+		if dist > 0 {
+			dst.WriteByte(byte(dist))
+			dst.WriteByte(byte(dist >> 8))
+			if ml-4 >= 15 {
+				length(ml - 4 - 15)
+			}
+		}
+	}
+
+	m := newMatchFinder(src, 65535)
+	anchor := 0
+	for i := 0; i+lz4MatchLimit < len(src); {
+		dist, ml := m.find(i, len(src)-lz4LastLiterals-i)
+		if ml == 0 {
+			m.insert(i)
+			i++
+			continue
+		}
+		sequence(src[anchor:i], dist, ml)
+		for end := i + ml; i < end; i++ {
+			m.insert(i)
+		}
+		anchor = i
+	}
+	sequence(src[anchor:], 0, 0)
+	return dst.Bytes()
+}
+
+// lz4Compress compresses b in the legacy LZ4 frame format.
+func lz4Compress(b []byte) ([]byte, error) {
+	var w bytes.Buffer
+	binary.Write(&w, binary.LittleEndian, uint32(lz4LegacyMagic))
+	for len(b) > 0 {
+		n := len(b)
+		if n > lz4LegacyBlockSize {
+			n = lz4LegacyBlockSize
+		}
+		c := lz4CompressBlock(b[:n])
+		binary.Write(&w, binary.LittleEndian, uint32(len(c)))
+		w.Write(c)
+		b = b[n:]
+	}
+	return w.Bytes(), nil
+}
@@ -0,0 +1,652 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bzimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// The xz package can only start LZMA2 compression with an empty dictionary
+// and a fresh state, which costs a lot when only the end of a kernel is
+// compressed again. This is a small LZMA encoder that continues where the
+// chunks before it left off instead, and a decoder to find out where that
+// is. It parses like the fast mode of liblzma, lzma_encoder_optimum_fast.c,
+// and follows LzmaEnc.c in the LZMA SDK for the encoding.
+//
+// Only the properties xz uses by default are supported.
+const (
+	lzmaLC = 3
+	lzmaLP = 0
+	lzmaPB = 2
+	// lzmaProps is the properties byte for lzmaLC, lzmaLP and lzmaPB.
+	lzmaProps = (lzmaPB*5+lzmaLP)*9 + lzmaLC
+
+	lzmaStates     = 12
+	lzmaMinMatch   = 2
+	lzmaMaxMatch   = 273
+	lzmaNiceLen    = 64
+	lzmaEndPosSlot = 14
+
+	// An LZMA2 chunk holds at most 2 MiB of data compressed to at most
+	// 64 KiB. lzma2Margin is more than one symbol can need in the range
+	// coder plus its flush.
+	lzma2MaxUnpacked = 1 << 21
+	lzma2MaxPacked   = 1 << 16
+	lzma2Margin      = 128
+
+	// Chunk control bytes: uncompressed chunks with and without a
+	// dictionary reset, and LZMA chunks with no reset, a state reset, a
+	// state reset with new properties, and all that and a dictionary
+	// reset.
+	lzma2RawDict    = 0x01
+	lzma2Raw        = 0x02
+	lzma2Chunk      = 0x80
+	lzma2ChunkState = 0xa0
+	lzma2ChunkProps = 0xc0
+	lzma2ChunkDict  = 0xe0
+)
+
+// lzmaProb is the probability of a 0 bit, out of 1<<11.
+type lzmaProb uint16
+
+const lzmaProbInit = 1 << 10
+
+func initProbs(p []lzmaProb) {
+	for i := range p {
+		p[i] = lzmaProbInit
+	}
+}
+
+// A rangeCoder is a rangeEncoder or a rangeDecoder. Both code a bit and
+// return it, but a decoder ignores the bit it is given and returns the one it
+// decodes, so the same code encodes and decodes symbols.
+type rangeCoder interface {
+	// bit codes b with the probability p.
+	bit(p *lzmaProb, b uint32) uint32
+	// direct codes the low n bits of v with fixed probabilities.
+	direct(v uint32, n uint) uint32
+}
+
+// tree codes the low n bits of v, high bit first, with the bit tree p.
+func tree(rc rangeCoder, p []lzmaProb, n uint, v uint32) uint32 {
+	m := uint32(1)
+	for k := n; k > 0; {
+		k--
+		m = m<<1 | rc.bit(&p[m], v>>k&1)
+	}
+	return m - 1<<n
+}
+
+// reverseTree codes the low n bits of v, low bit first, with the bit tree p.
+func reverseTree(rc rangeCoder, p []lzmaProb, n uint, v uint32) uint32 {
+	m, r := uint32(1), uint32(0)
+	for k := uint(0); k < n; k++ {
+		b := rc.bit(&p[m], v>>k&1)
+		m = m<<1 | b
+		r |= b << k
+	}
+	return r
+}
+
+type rangeEncoder struct {
+	low       uint64
+	rng       uint32
+	cache     byte
+	cacheSize int
+	out       []byte
+}
+
+func newRangeEncoder() *rangeEncoder {
+	return &rangeEncoder{rng: 0xffffffff, cacheSize: 1}
+}
+
+func (rc *rangeEncoder) shiftLow() {
+	if uint32(rc.low) < 0xff000000 || rc.low>>32 != 0 {
+		c := rc.cache
+		for ; rc.cacheSize > 0; rc.cacheSize-- {
+			rc.out = append(rc.out, c+byte(rc.low>>32))
+			c = 0xff
+		}
+		rc.cache = byte(rc.low >> 24)
+	}
+	rc.cacheSize++
+	rc.low = uint64(uint32(rc.low) << 8)
+}
+
+func (rc *rangeEncoder) normalize() {
+	for rc.rng < 1<<24 {
+		rc.rng <<= 8
+		rc.shiftLow()
+	}
+}
+
+func (rc *rangeEncoder) bit(p *lzmaProb, b uint32) uint32 {
+	bound := (rc.rng >> 11) * uint32(*p)
+	if b == 0 {
+		rc.rng = bound
+		*p += (1<<11 - *p) >> 5
+	} else {
+		rc.low += uint64(bound)
+		rc.rng -= bound
+		*p -= *p >> 5
+	}
+	rc.normalize()
+	return b
+}
+
+func (rc *rangeEncoder) direct(v uint32, n uint) uint32 {
+	for k := n; k > 0; {
+		k--
+		rc.rng >>= 1
+		if v>>k&1 != 0 {
+			rc.low += uint64(rc.rng)
+		}
+		rc.normalize()
+	}
+	return v & (1<<n - 1)
+}
+
+// size is how many bytes the output will have after a flush, at most.
+func (rc *rangeEncoder) size() int {
+	return len(rc.out) + rc.cacheSize + 4
+}
+
+func (rc *rangeEncoder) flush() []byte {
+	for i := 0; i < 5; i++ {
+		rc.shiftLow()
+	}
+	return rc.out
+}
+
+type rangeDecoder struct {
+	b    []byte
+	rng  uint32
+	code uint32
+	// eof is whether the decoder ran past the end of b.
+	eof bool
+}
+
+func newRangeDecoder(b []byte) (*rangeDecoder, error) {
+	if len(b) < 5 || b[0] != 0 {
+		return nil, fmt.Errorf("bad LZMA range coder start")
+	}
+	return &rangeDecoder{b: b[5:], rng: 0xffffffff, code: binary.BigEndian.Uint32(b[1:])}, nil
+}
+
+func (rc *rangeDecoder) normalize() {
+	if rc.rng < 1<<24 {
+		rc.rng <<= 8
+		rc.code <<= 8
+		if len(rc.b) == 0 {
+			rc.eof = true
+			return
+		}
+		rc.code |= uint32(rc.b[0])
+		rc.b = rc.b[1:]
+	}
+}
+
+func (rc *rangeDecoder) bit(p *lzmaProb, _ uint32) uint32 {
+	bound := (rc.rng >> 11) * uint32(*p)
+	var b uint32
+	if rc.code < bound {
+		rc.rng = bound
+		*p += (1<<11 - *p) >> 5
+	} else {
+		rc.code -= bound
+		rc.rng -= bound
+		*p -= *p >> 5
+		b = 1
+	}
+	rc.normalize()
+	return b
+}
+
+func (rc *rangeDecoder) direct(_ uint32, n uint) uint32 {
+	var v uint32
+	for ; n > 0; n-- {
+		rc.rng >>= 1
+		var b uint32
+		if rc.code >= rc.rng {
+			rc.code -= rc.rng
+			b = 1
+		}
+		v = v<<1 | b
+		rc.normalize()
+	}
+	return v
+}
+
+type lzmaLenCoder struct {
+	choice, choice2 lzmaProb
+	low, mid        [1 << lzmaPB][8]lzmaProb
+	high            [256]lzmaProb
+}
+
+func (l *lzmaLenCoder) reset() {
+	l.choice, l.choice2 = lzmaProbInit, lzmaProbInit
+	for i := range l.low {
+		initProbs(l.low[i][:])
+		initProbs(l.mid[i][:])
+	}
+	initProbs(l.high[:])
+}
+
+func (l *lzmaLenCoder) code(rc rangeCoder, length int, posState uint32) int {
+	n := uint32(length - lzmaMinMatch)
+	if rc.bit(&l.choice, b2u(n >= 8)) == 0 {
+		return lzmaMinMatch + int(tree(rc, l.low[posState][:], 3, n))
+	}
+	if rc.bit(&l.choice2, b2u(n >= 16)) == 0 {
+		return lzmaMinMatch + 8 + int(tree(rc, l.mid[posState][:], 3, n-8))
+	}
+	return lzmaMinMatch + 16 + int(tree(rc, l.high[:], 8, n-16))
+}
+
+func b2u(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Kinds of LZMA symbols.
+const (
+	lzmaLiteral = iota
+	lzmaMatch
+	lzmaRep
+	lzmaShortRep
+)
+
+// An lzmaOp is an LZMA symbol.
+type lzmaOp struct {
+	kind int
+	// dist is the distance of a match, or the index of the distance a
+	// rep repeats.
+	dist int
+	len  int
+	// lit is the byte of a decoded literal, and back the distance of
+	// any other decoded symbol.
+	lit  byte
+	back int
+}
+
+// lzmaState is the state of an LZMA encoder or decoder, other than the
+// dictionary.
+type lzmaState struct {
+	state int
+	// reps are the last 4 match distances, minus 1.
+	reps [4]uint32
+
+	literal    [0x300 << (lzmaLC + lzmaLP)]lzmaProb
+	isMatch    [lzmaStates][1 << lzmaPB]lzmaProb
+	isRep      [lzmaStates]lzmaProb
+	isRepG0    [lzmaStates]lzmaProb
+	isRepG1    [lzmaStates]lzmaProb
+	isRepG2    [lzmaStates]lzmaProb
+	isRep0Long [lzmaStates][1 << lzmaPB]lzmaProb
+	posSlot    [4][64]lzmaProb
+	// posSpecial holds the bit trees of distance slots 4 to 13, which
+	// start at base-slot, counting from 1.
+	posSpecial [115]lzmaProb
+	align      [16]lzmaProb
+	matchLen   lzmaLenCoder
+	repLen     lzmaLenCoder
+}
+
+// reset resets the state and probabilities, as a chunk with a state reset
+// does.
+func (s *lzmaState) reset() {
+	s.state = 0
+	s.reps = [4]uint32{}
+	initProbs(s.literal[:])
+	for i := 0; i < lzmaStates; i++ {
+		initProbs(s.isMatch[i][:])
+		initProbs(s.isRep0Long[i][:])
+	}
+	initProbs(s.isRep[:])
+	initProbs(s.isRepG0[:])
+	initProbs(s.isRepG1[:])
+	initProbs(s.isRepG2[:])
+	for i := range s.posSlot {
+		initProbs(s.posSlot[i][:])
+	}
+	initProbs(s.posSpecial[:])
+	initProbs(s.align[:])
+	s.matchLen.reset()
+	s.repLen.reset()
+}
+
+// code codes the symbol op at position i of b, and returns it. A literal
+// codes b[i], so b must be longer than i even when decoding.
+func (s *lzmaState) code(rc rangeCoder, b []byte, i int, op lzmaOp) lzmaOp {
+	ps := uint32(i) & (1<<lzmaPB - 1)
+	st := s.state
+	if rc.bit(&s.isMatch[st][ps], b2u(op.kind != lzmaLiteral)) == 0 {
+		return lzmaOp{kind: lzmaLiteral, len: 1, lit: s.codeLiteral(rc, b, i)}
+	}
+
+	if rc.bit(&s.isRep[st], b2u(op.kind != lzmaMatch)) == 0 {
+		op.len = s.matchLen.code(rc, op.len, ps)
+		d := s.codeDist(rc, uint32(op.dist-1), op.len)
+		s.reps = [4]uint32{d, s.reps[0], s.reps[1], s.reps[2]}
+		s.state = 7
+		if st >= 7 {
+			s.state = 10
+		}
+		return lzmaOp{kind: lzmaMatch, dist: int(d) + 1, len: op.len}
+	}
+
+	index := 0
+	if rc.bit(&s.isRepG0[st], b2u(op.dist > 0)) == 0 {
+		if rc.bit(&s.isRep0Long[st][ps], b2u(op.kind != lzmaShortRep)) == 0 {
+			s.state = 9
+			if st >= 7 {
+				s.state = 11
+			}
+			return lzmaOp{kind: lzmaShortRep, len: 1}
+		}
+	} else if rc.bit(&s.isRepG1[st], b2u(op.dist > 1)) == 0 {
+		index = 1
+	} else {
+		index = 2 + int(rc.bit(&s.isRepG2[st], b2u(op.dist > 2)))
+	}
+	r := s.reps[index]
+	copy(s.reps[1:index+1], s.reps[:index])
+	s.reps[0] = r
+	op.len = s.repLen.code(rc, op.len, ps)
+	s.state = 8
+	if st >= 7 {
+		s.state = 11
+	}
+	return lzmaOp{kind: lzmaRep, dist: index, len: op.len}
+}
+
+func (s *lzmaState) codeLiteral(rc rangeCoder, b []byte, i int) byte {
+	var prev byte
+	if i > 0 {
+		prev = b[i-1]
+	}
+	ctx := (i&(1<<lzmaLP-1))<<lzmaLC + int(prev>>(8-lzmaLC))
+	p := s.literal[ctx*0x300 : (ctx+1)*0x300]
+	var sym uint32
+	if s.state < 7 {
+		sym = tree(rc, p, 8, uint32(b[i]))
+	} else {
+		// A literal after a match is coded along with the byte at
+		// the distance of the match, until they differ.
+		match, c := uint32(b[i-int(s.reps[0])-1]), uint32(b[i])
+		offs := uint32(0x100)
+		sym = 1
+		for sym < 0x100 {
+			match <<= 1
+			m := match & offs
+			bit := rc.bit(&p[offs+m+sym], c>>7&1)
+			c <<= 1
+			sym = sym<<1 | bit
+			if bit == 0 {
+				offs &^= m
+			} else {
+				offs &= m
+			}
+		}
+	}
+
+	switch {
+	case s.state < 4:
+		s.state = 0
+	case s.state < 10:
+		s.state -= 3
+	default:
+		s.state -= 6
+	}
+	return byte(sym)
+}
+
+// codeDist codes the distance d, minus 1, of a match of length n.
+func (s *lzmaState) codeDist(rc rangeCoder, d uint32, n int) uint32 {
+	lenState := n - lzmaMinMatch
+	if lenState > 3 {
+		lenState = 3
+	}
+	slot := d
+	if d >= 4 {
+		k := uint32(bits.Len32(d) - 1)
+		slot = 2*k + d>>(k-1)&1
+	}
+	slot = tree(rc, s.posSlot[lenState][:], 6, slot)
+	if slot < 4 {
+		return slot
+	}
+	footer := uint(slot>>1 - 1)
+	base := (2 | slot&1) << footer
+	if slot < lzmaEndPosSlot {
+		return base + reverseTree(rc, s.posSpecial[base-slot:], footer, d-base)
+	}
+	high := base + rc.direct((d-base)>>4, footer-4)<<4
+	return high + reverseTree(rc, s.align[:], 4, d-base)
+}
+
+// decode decodes the symbols in the LZMA chunk data, which decompresses to
+// b[start:end].
+//
+// The literals must match b.
+func (s *lzmaState) decode(data, b []byte, start, end int) ([]lzmaOp, error) {
+	rc, err := newRangeDecoder(data)
+	if err != nil {
+		return nil, err
+	}
+	var ops []lzmaOp
+	for i := start; i < end; {
+		op := s.code(rc, b, i, lzmaOp{})
+		if op.kind != lzmaLiteral {
+			op.back = int(s.reps[0]) + 1
+		}
+		switch {
+		case rc.eof:
+			return nil, fmt.Errorf("LZMA chunk ends early at %d", i)
+		case op.kind == lzmaLiteral && op.lit != b[i]:
+			return nil, fmt.Errorf("LZMA literal at %d is %#x, want %#x", i, op.lit, b[i])
+		case op.back > i:
+			return nil, fmt.Errorf("LZMA match at %d is %d bytes back", i, op.back)
+		case i+op.len > end:
+			return nil, fmt.Errorf("LZMA symbol at %d is past the end of its chunk", i)
+		}
+		ops = append(ops, op)
+		i += op.len
+	}
+	return ops, nil
+}
+
+type lzmaEncoder struct {
+	lzmaState
+
+	b  []byte
+	m  *matchFinder
+	rc *rangeEncoder
+	// inserted is how many positions of b are in m.
+	inserted int
+
+	// old are symbols for old data from position oldPos, where old data
+	// is different from b, to follow where they still work for b.
+	old    []lzmaOp
+	oldPos int
+	oldB   []byte
+}
+
+func newLZMAEncoder(b []byte, dictCap int) *lzmaEncoder {
+	e := &lzmaEncoder{b: b, m: newMatchFinder(b, dictCap)}
+	e.reset()
+	return e
+}
+
+// lzma2 compresses b[start:] into LZMA2 chunks and the end marker. The chunks
+// follow chunks that decompress to b[:start], which is the dictionary. The
+// first chunk has the control byte control and starts with the symbols ops.
+// After them, the symbols in e.old are used where they work.
+//
+// A control byte that does not reset the state continues from e's state.
+func (e *lzmaEncoder) lzma2(start int, control byte, ops []lzmaOp) []byte {
+	if control >= lzma2ChunkState {
+		e.reset()
+	}
+	if d := e.m.maxDist; start > d {
+		e.inserted = start - d
+	}
+
+	var out []byte
+	for i := start; i < len(e.b); {
+		e.rc = newRangeEncoder()
+		from := i
+		for ; len(ops) > 0; ops = ops[1:] {
+			e.code(e.rc, e.b, i, ops[0])
+			i += ops[0].len
+		}
+		for i < len(e.b) && i-from <= lzma2MaxUnpacked-lzmaMaxMatch && e.rc.size() <= lzma2MaxPacked-lzma2Margin {
+			i += e.code(e.rc, e.b, i, e.next(i)).len
+		}
+		data := e.rc.flush()
+		u := i - from - 1
+		out = append(out, control|byte(u>>16), byte(u>>8), byte(u), 0, 0)
+		binary.BigEndian.PutUint16(out[len(out)-2:], uint16(len(data)-1))
+		if control >= lzma2ChunkProps {
+			out = append(out, lzmaProps)
+		}
+		out = append(out, data...)
+		control = lzma2Chunk
+	}
+	return append(out, 0)
+}
+
+// insert adds the positions before i to the match finder.
+func (e *lzmaEncoder) insert(i int) {
+	for ; e.inserted < i && e.inserted+4 <= len(e.b); e.inserted++ {
+		e.m.insert(e.inserted)
+	}
+}
+
+// find returns the longest match for position i, if it is at least 4 bytes.
+func (e *lzmaEncoder) find(i, maxLen int) (dist, length int) {
+	if i+4 > len(e.b) {
+		return 0, 0
+	}
+	e.insert(i)
+	return e.m.find(i, maxLen)
+}
+
+// repMatch returns the length of the match at i at the distance rep+1.
+func (e *lzmaEncoder) repMatch(i int, rep uint32, maxLen int) int {
+	p := i - int(rep) - 1
+	if p < 0 {
+		return 0
+	}
+	n := 0
+	for n < maxLen && e.b[p+n] == e.b[i+n] {
+		n++
+	}
+	return n
+}
+
+// changePair is whether a match at the long distance big is worse than one a
+// byte shorter at the distance small.
+func changePair(small, big int) bool {
+	return big>>7 > small
+}
+
+// follow returns the old symbol at i, if there is one and it works for b.
+// A match or rep is made a rep if its distance is one of e.reps.
+func (e *lzmaEncoder) follow(i int) (lzmaOp, bool) {
+	for len(e.old) > 0 && e.oldPos < i {
+		e.oldPos += e.old[0].len
+		e.old = e.old[1:]
+	}
+	if len(e.old) == 0 || e.oldPos != i {
+		return lzmaOp{}, false
+	}
+	op := e.old[0]
+	if op.kind == lzmaLiteral {
+		return op, e.b[i] == e.oldB[i]
+	}
+	p := i - op.back
+	if p < 0 || i+op.len > len(e.b) || !bytes.Equal(e.b[p:p+op.len], e.b[i:i+op.len]) {
+		return lzmaOp{}, false
+	}
+	for k, r := range e.reps {
+		if int(r)+1 != op.back {
+			continue
+		}
+		switch {
+		case op.len > 1:
+			return lzmaOp{kind: lzmaRep, dist: k, len: op.len}, true
+		case k == 0:
+			return lzmaOp{kind: lzmaShortRep, len: 1}, true
+		}
+	}
+	if op.len == 1 {
+		return lzmaOp{kind: lzmaLiteral, len: 1}, true
+	}
+	return lzmaOp{kind: lzmaMatch, dist: op.back, len: op.len}, true
+}
+
+// next returns the symbol to encode at i.
+func (e *lzmaEncoder) next(i int) lzmaOp {
+	if op, ok := e.follow(i); ok {
+		return op
+	}
+	literal := lzmaOp{kind: lzmaLiteral, len: 1}
+	maxLen := len(e.b) - i
+	if maxLen > lzmaMaxMatch {
+		maxLen = lzmaMaxMatch
+	}
+	if maxLen < lzmaMinMatch {
+		return literal
+	}
+
+	rep := lzmaOp{kind: lzmaRep}
+	for k, r := range e.reps {
+		if n := e.repMatch(i, r, maxLen); n > rep.len {
+			rep.dist, rep.len = k, n
+		}
+	}
+	if rep.len >= lzmaNiceLen {
+		return rep
+	}
+
+	dist, length := e.find(i, maxLen)
+	match := lzmaOp{kind: lzmaMatch, dist: dist, len: length}
+	if length >= lzmaNiceLen {
+		return match
+	}
+	if rep.len >= lzmaMinMatch && (rep.len+1 >= length ||
+		rep.len+2 >= length && dist >= 1<<9 ||
+		rep.len+3 >= length && dist >= 1<<15) {
+		return rep
+	}
+	if length < lzmaMinMatch || maxLen <= 2 {
+		return literal
+	}
+
+	// Take a literal instead if the next position has a better match.
+	nextDist, nextLen := e.find(i+1, maxLen-1)
+	if nextLen >= length && nextDist < dist ||
+		nextLen == length+1 && !changePair(dist, nextDist) ||
+		nextLen > length+1 ||
+		nextLen+1 >= length && length >= 3 && changePair(nextDist, dist) {
+		return literal
+	}
+	limit := length - 1
+	if limit < lzmaMinMatch {
+		limit = lzmaMinMatch
+	}
+	for _, r := range e.reps {
+		if e.repMatch(i+1, r, limit) == limit {
+			return literal
+		}
+	}
+	return match
+}
@@ -0,0 +1,486 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bzimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// Linux compresses kernels with lzop, which wraps LZO1X compressed blocks of
+// at most 256 KiB in a header and block headers with checksums.
+var lzopMagic = []byte{0x89, 'L', 'Z', 'O', 0x00, 0x0d, 0x0a, 0x1a, 0x0a}
+
+const (
+	lzopBlockSize = 256 << 10
+
+	// lzop header flags.
+	lzopAdler32D   = 0x0001
+	lzopAdler32C   = 0x0002
+	lzopExtraField = 0x0040
+	lzopCRC32D     = 0x0100
+	lzopCRC32C     = 0x0200
+	lzopFilter     = 0x0800
+	lzopHeaderCRC  = 0x1000
+	lzopOSUnix     = 0x03000000
+
+	// lzop method for LZO1X-1.
+	lzopMethodLZO1X1 = 1
+)
+
+// lzopReader decompresses lzop files.
+type lzopReader struct {
+	r     io.Reader
+	flags uint32
+	block []byte
+	done  bool
+}
+
+func newLZOPReader(r io.Reader) (io.Reader, error) {
+	var h struct {
+		Magic      [9]byte
+		Version    uint16
+		LibVersion uint16
+	}
+	if err := binary.Read(r, binary.BigEndian, &h); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(h.Magic[:], lzopMagic) {
+		return nil, fmt.Errorf("lzo: bad lzop magic %#x", h.Magic)
+	}
+	var v struct {
+		Method uint8
+		Level  uint8
+		Flags  uint32
+	}
+	if h.Version >= 0x0940 {
+		var needed uint16
+		if err := binary.Read(r, binary.BigEndian, &needed); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &v); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := binary.Read(r, binary.BigEndian, &v.Method); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &v.Flags); err != nil {
+			return nil, err
+		}
+	}
+	// Filter, mode, mtime, mtime high, name length.
+	skip := 4 + 4
+	if v.Flags&lzopFilter != 0 {
+		skip += 4
+	}
+	if h.Version >= 0x0940 {
+		skip += 4
+	}
+	rest := make([]byte, skip+1)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, err
+	}
+	// Name and header checksum.
+	if _, err := io.CopyN(ioutil.Discard, r, int64(rest[skip])+4); err != nil {
+		return nil, err
+	}
+	if v.Flags&lzopExtraField != 0 {
+		var n uint32
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, int64(n)+4); err != nil {
+			return nil, err
+		}
+	}
+	return &lzopReader{r: r, flags: v.Flags}, nil
+}
+
+// Read implements io.Reader.
+func (l *lzopReader) Read(p []byte) (int, error) {
+	for len(l.block) == 0 {
+		if l.done {
+			return 0, io.EOF
+		}
+		if err := l.readBlock(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, l.block)
+	l.block = l.block[n:]
+	return n, nil
+}
+
+func (l *lzopReader) readBlock() error {
+	var dstLen, srcLen uint32
+	if err := binary.Read(l.r, binary.BigEndian, &dstLen); err != nil {
+		return err
+	}
+	if dstLen == 0 {
+		l.done = true
+		return nil
+	}
+	if err := binary.Read(l.r, binary.BigEndian, &srcLen); err != nil {
+		return err
+	}
+	if dstLen > 64<<20 || srcLen > dstLen {
+		return fmt.Errorf("lzo: invalid block sizes %d and %d", srcLen, dstLen)
+	}
+	var adler, crc uint32
+	if l.flags&lzopAdler32D != 0 {
+		if err := binary.Read(l.r, binary.BigEndian, &adler); err != nil {
+			return err
+		}
+	}
+	if l.flags&lzopCRC32D != 0 {
+		if err := binary.Read(l.r, binary.BigEndian, &crc); err != nil {
+			return err
+		}
+	}
+	if srcLen < dstLen {
+		var skip int64
+		if l.flags&lzopAdler32C != 0 {
+			skip += 4
+		}
+		if l.flags&lzopCRC32C != 0 {
+			skip += 4
+		}
+		if _, err := io.CopyN(ioutil.Discard, l.r, skip); err != nil {
+			return err
+		}
+	}
+
+	src := make([]byte, srcLen)
+	if _, err := io.ReadFull(l.r, src); err != nil {
+		return err
+	}
+	b := src
+	if srcLen < dstLen {
+		var err error
+		if b, err = lzo1xDecompress(make([]byte, 0, dstLen), src); err != nil {
+			return err
+		}
+		if len(b) != int(dstLen) {
+			return fmt.Errorf("lzo: block decompressed to %d bytes, want %d", len(b), dstLen)
+		}
+	}
+	if l.flags&lzopAdler32D != 0 && adler32.Checksum(b) != adler {
+		return fmt.Errorf("lzo: block adler32 checksum mismatch")
+	}
+	if l.flags&lzopCRC32D != 0 && crc32.ChecksumIEEE(b) != crc {
+		return fmt.Errorf("lzo: block crc32 checksum mismatch")
+	}
+	l.block = b
+	return nil
+}
+
+// lzo1xDecompress appends the decompressed LZO1X data in src to dst.
+//
+// This follows lzo1x_decompress_safe in Linux.
+func lzo1xDecompress(dst, src []byte) ([]byte, error) {
+	start := len(dst)
+	ip := 0
+	errTruncated := fmt.Errorf("lzo: input truncated")
+
+	next := func() (int, error) {
+		if ip >= len(src) {
+			return 0, errTruncated
+		}
+		b := src[ip]
+		ip++
+		return int(b), nil
+	}
+	// length reads a length continued in zero bytes, each adding 255.
+	length := func(base int) (int, error) {
+		var zeros int
+		for ip < len(src) && src[ip] == 0 {
+			ip++
+			zeros++
+		}
+		b, err := next()
+		if err != nil {
+			return 0, err
+		}
+		return base + 255*zeros + b, nil
+	}
+	literals := func(n int) error {
+		if n > len(src)-ip {
+			return errTruncated
+		}
+		dst = append(dst, src[ip:ip+n]...)
+		ip += n
+		return nil
+	}
+	match := func(dist, n int) error {
+		m := len(dst) - dist
+		if dist <= 0 || m < start {
+			return fmt.Errorf("lzo: match distance %d out of range", dist)
+		}
+		for j := 0; j < n; j++ {
+			dst = append(dst, dst[m+j])
+		}
+		return nil
+	}
+	le16 := func() (int, error) {
+		if ip+2 > len(src) {
+			return 0, errTruncated
+		}
+		v := int(binary.LittleEndian.Uint16(src[ip:]))
+		ip += 2
+		return v, nil
+	}
+
+	// state is the number of literals copied after the last match (up
+	// to 3), or 4 after a longer literal run. It determines the meaning
+	// of instructions below 16.
+	state := 0
+	if len(src) > 0 && src[0] > 17 {
+		t := int(src[0]) - 17
+		ip++
+		if err := literals(t); err != nil {
+			return nil, err
+		}
+		state = t
+		if t >= 4 {
+			state = 4
+		}
+	}
+
+	for {
+		t, err := next()
+		if err != nil {
+			return nil, err
+		}
+		var dist, n int
+		switch {
+		case t < 16 && state == 0:
+			// Literal run.
+			if t == 0 {
+				if t, err = length(15); err != nil {
+					return nil, err
+				}
+			}
+			if err := literals(t + 3); err != nil {
+				return nil, err
+			}
+			state = 4
+			continue
+
+		case t < 16 && state != 4:
+			// 2 byte match right after a short literal run.
+			b, err := next()
+			if err != nil {
+				return nil, err
+			}
+			dist = 1 + t>>2 + b<<2
+			n = 2
+			t &= 3
+
+		case t < 16:
+			// 3 byte match right after a long literal run.
+			b, err := next()
+			if err != nil {
+				return nil, err
+			}
+			dist = 1 + 0x800 + t>>2 + b<<2
+			n = 3
+			t &= 3
+
+		case t >= 64:
+			b, err := next()
+			if err != nil {
+				return nil, err
+			}
+			dist = 1 + (t>>2)&7 + b<<3
+			n = t>>5 + 1
+			t &= 3
+
+		case t >= 32:
+			n = t&31 + 2
+			if n == 2 {
+				if n, err = length(33); err != nil {
+					return nil, err
+				}
+			}
+			v, err := le16()
+			if err != nil {
+				return nil, err
+			}
+			dist = 1 + v>>2
+			t = v & 3
+
+		default:
+			// 16 <= t < 32.
+			n = t&7 + 2
+			if n == 2 {
+				if n, err = length(9); err != nil {
+					return nil, err
+				}
+			}
+			v, err := le16()
+			if err != nil {
+				return nil, err
+			}
+			dist = (t&8)<<11 + v>>2
+			if dist == 0 {
+				// End of stream marker.
+				return dst, nil
+			}
+			dist += 0x4000
+			t = v & 3
+		}
+
+		if err := match(dist, n); err != nil {
+			return nil, err
+		}
+		// The low 2 bits of each match are the number of literals
+		// that follow it.
+		if err := literals(t); err != nil {
+			return nil, err
+		}
+		state = t
+	}
+}
+
+// lzo1xCompress compresses src with LZO1X.
+//
+// The output format is that of lzo1x_1_compress in Linux, but matches are
+// looked for harder.
+func lzo1xCompress(src []byte) []byte {
+	var dst bytes.Buffer
+	length := func(l int) {
+		for ; l > 255; l -= 255 {
+			dst.WriteByte(0)
+		}
+		dst.WriteByte(byte(l))
+	}
+	literals := func(lit []byte) {
+		t := len(lit)
+		switch {
+		case t == 0:
+		case dst.Len() == 0 && t <= 238:
+			dst.WriteByte(byte(17 + t))
+		case t <= 3:
+			// Stored in the low bits of the last match.
+			b := dst.Bytes()
+			b[len(b)-2] |= byte(t)
+		case t <= 18:
+			dst.WriteByte(byte(t - 3))
+		default:
+			dst.WriteByte(0)
+			length(t - 18)
+		}
+		dst.Write(lit)
+	}
+	match := func(dist, n int) {
+		switch {
+		case n <= 8 && dist <= 0x800:
+			d := dist - 1
+			dst.WriteByte(byte((n-1)<<5 | (d&7)<<2))
+			dst.WriteByte(byte(d >> 3))
+			return
+		case dist <= 0x4000:
+			d := dist - 1
+			if n <= 33 {
+				dst.WriteByte(byte(32 | (n - 2)))
+			} else {
+				dst.WriteByte(32)
+				length(n - 33)
+			}
+			dst.WriteByte(byte(d << 2))
+			dst.WriteByte(byte(d >> 6))
+		default:
+			d := dist - 0x4000
+			if n <= 9 {
+				dst.WriteByte(byte(16 | (d>>11)&8 | (n - 2)))
+			} else {
+				dst.WriteByte(byte(16 | (d>>11)&8))
+				length(n - 9)
+			}
+			dst.WriteByte(byte(d << 2))
+			dst.WriteByte(byte(d >> 6))
+		}
+	}
+
+	m := newMatchFinder(src, 0xbfff)
+	anchor := 0
+	// The first literal run must not be shorter than 4 bytes, as shorter
+	// ones are stored in the preceding match.
+	for i := 4; i+4 <= len(src); {
+		dist, n := m.find(i, len(src)-i)
+		if n == 0 {
+			m.insert(i)
+			i++
+			continue
+		}
+		literals(src[anchor:i])
+		match(dist, n)
+		for end := i + n; i < end; i++ {
+			if i+4 <= len(src) {
+				m.insert(i)
+			}
+		}
+		anchor = i
+	}
+	literals(src[anchor:])
+	// End of stream marker.
+	dst.Write([]byte{16 | 1, 0, 0})
+	return dst.Bytes()
+}
+
+// lzopCompress compresses b in the lzop format the Linux decompressor
+// expects: LZO1X blocks with an adler32 checksum of the uncompressed data.
+func lzopCompress(b []byte) ([]byte, error) {
+	var w bytes.Buffer
+	w.Write(lzopMagic)
+
+	var h bytes.Buffer
+	binary.Write(&h, binary.BigEndian, struct {
+		Version       uint16
+		LibVersion    uint16
+		NeededVersion uint16
+		Method        uint8
+		Level         uint8
+		Flags         uint32
+		Mode          uint32
+		MTime         uint32
+		MTimeHigh     uint32
+		NameLen       uint8
+	}{
+		Version:       0x1030,
+		LibVersion:    0x2080,
+		NeededVersion: 0x0940,
+		Method:        lzopMethodLZO1X1,
+		Level:         5,
+		Flags:         lzopAdler32D | lzopOSUnix,
+		Mode:          0100644,
+	})
+	w.Write(h.Bytes())
+	binary.Write(&w, binary.BigEndian, adler32.Checksum(h.Bytes()))
+
+	for len(b) > 0 {
+		n := len(b)
+		if n > lzopBlockSize {
+			n = lzopBlockSize
+		}
+		block := b[:n]
+		c := lzo1xCompress(block)
+		if len(c) >= n {
+			// Incompressible blocks are stored.
+			c = block
+		}
+		binary.Write(&w, binary.BigEndian, []uint32{uint32(n), uint32(len(c)), adler32.Checksum(block)})
+		w.Write(c)
+		b = b[n:]
+	}
+	binary.Write(&w, binary.BigEndian, uint32(0))
+	return w.Bytes(), nil
+}
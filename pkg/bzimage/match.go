@@ -0,0 +1,87 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bzimage
+
+import (
+	"encoding/binary"
+)
+
+const (
+	// matchHashBits is the size of the hash table of a matchFinder.
+	matchHashBits = 16
+
+	// matchDepth is how many earlier positions with the same hash a
+	// matchFinder looks at.
+	matchDepth = 128
+)
+
+// A matchFinder finds earlier occurrences of the bytes at a position in a
+// buffer, for the LZ77 style compressors in this package.
+//
+// Positions are hashed by their first 4 bytes and chained together, so only
+// matches of at least 4 bytes are found.
+type matchFinder struct {
+	b       []byte
+	maxDist int
+
+	// head maps a hash to the last position inserted with that hash,
+	// plus 1.
+	head []int32
+	// prev maps a position to the previous position with the same hash,
+	// plus 1.
+	prev []int32
+}
+
+func newMatchFinder(b []byte, maxDist int) *matchFinder {
+	return &matchFinder{
+		b:       b,
+		maxDist: maxDist,
+		head:    make([]int32, 1<<matchHashBits),
+		prev:    make([]int32, len(b)),
+	}
+}
+
+func (m *matchFinder) hash(i int) uint32 {
+	return (binary.LittleEndian.Uint32(m.b[i:]) * 2654435761) >> (32 - matchHashBits)
+}
+
+// insert adds position i, which must be followed by at least 3 more bytes.
+func (m *matchFinder) insert(i int) {
+	h := m.hash(i)
+	m.prev[i] = m.head[h]
+	m.head[h] = int32(i + 1)
+}
+
+// find returns the distance and length of the longest match of at most
+// maxLen bytes for position i among the positions inserted so far. The
+// length is 0 if there is none.
+//
+// Position i must be followed by at least 3 more bytes.
+func (m *matchFinder) find(i, maxLen int) (dist int, length int) {
+	if maxLen < 4 {
+		return 0, 0
+	}
+	b := m.b
+	for p, n := int(m.head[m.hash(i)])-1, 0; p >= 0 && n < matchDepth; p, n = int(m.prev[p])-1, n+1 {
+		if i-p > m.maxDist {
+			break
+		}
+		// Quick reject: a longer match must also match at length.
+		if length > 0 && b[p+length] != b[i+length] {
+			continue
+		}
+		l := 0
+		for l < maxLen && b[p+l] == b[i+l] {
+			l++
+		}
+		if l >= 4 && l > length {
+			dist, length = i-p, l
+			if l == maxLen {
+				break
+			}
+		}
+	}
+	return dist, length
+}
@@ -0,0 +1,470 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bzimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// x86 kernels are compressed with `xz --x86`, which runs the code through the
+// x86 BCJ filter before LZMA2 compresses it. The filter makes the targets of
+// calls and jumps absolute, so calls to the same function compress better.
+// The xz package does not do filters, so this handles xz files with one block
+// filtered by BCJ x86 and LZMA2, and leaves everything else to the xz package.
+const (
+	xzCheckCRC32 = 0x01
+
+	xzFilterX86   = 0x04
+	xzFilterLZMA2 = 0x21
+
+	// xzDictCap is the dictionary size the kernel build uses.
+	xzDictCap = 32 << 20
+)
+
+var (
+	xzHeaderMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	xzFooterMagic = []byte{'Y', 'Z'}
+)
+
+// xzBlock is the first block of an xz file, if it is filtered by BCJ x86 and
+// LZMA2.
+type xzBlock struct {
+	// start is the start offset of the BCJ filter.
+	start uint32
+	// dictCap is the LZMA2 dictionary size.
+	dictCap int
+	// h is the block, starting with its header, up to the end of the
+	// file.
+	h []byte
+	// data is the compressed data in h, up to the end of the file.
+	data []byte
+}
+
+// parseXZBlock parses the header of the first block of the xz file in d. It
+// returns nil if the block is not filtered by BCJ x86 and LZMA2.
+func parseXZBlock(d []byte) (*xzBlock, error) {
+	if len(d) < 12 || !bytes.Equal(d[:6], xzHeaderMagic) {
+		return nil, fmt.Errorf("xz: bad magic")
+	}
+
+	// The block header is a size byte, flags, optional sizes and the
+	// filters, padded and followed by a CRC32.
+	h := d[12:]
+	if len(h) == 0 || h[0] == 0 {
+		return nil, nil
+	}
+	hsize := (int(h[0]) + 1) * 4
+	if len(h) < hsize {
+		return nil, io.ErrUnexpectedEOF
+	}
+	br := bytes.NewReader(h[2 : hsize-4])
+	flags := h[1]
+	if flags&0x40 != 0 {
+		if _, err := binary.ReadUvarint(br); err != nil {
+			return nil, err
+		}
+	}
+	if flags&0x80 != 0 {
+		if _, err := binary.ReadUvarint(br); err != nil {
+			return nil, err
+		}
+	}
+	var ids []uint64
+	var props [][]byte
+	for i := 0; i <= int(flags&3); i++ {
+		id, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(br.Len()) {
+			return nil, fmt.Errorf("xz: %d bytes of filter properties in a %d byte header", n, hsize)
+		}
+		p := make([]byte, n)
+		if _, err := io.ReadFull(br, p); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+		props = append(props, p)
+	}
+	if len(ids) != 2 || ids[0] != xzFilterX86 || ids[1] != xzFilterLZMA2 || len(props[1]) != 1 {
+		return nil, nil
+	}
+	b := &xzBlock{h: h, data: h[hsize:]}
+	if len(props[0]) == 4 {
+		b.start = binary.LittleEndian.Uint32(props[0])
+	}
+	dictCap, err := lzma.DecodeDictCap(props[1][0])
+	if err != nil {
+		return nil, err
+	}
+	b.dictCap = int(dictCap)
+	return b, nil
+}
+
+func newXZReader(r io.Reader) (io.Reader, error) {
+	d, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	blk, err := parseXZBlock(d)
+	if err != nil {
+		return nil, err
+	}
+	if blk == nil {
+		return xz.ReaderConfig{SingleStream: true}.NewReader(bytes.NewReader(d))
+	}
+	check := d[7] & 0x0f
+	h, start := blk.h, blk.start
+
+	data := bytes.NewReader(blk.data)
+	z, err := lzma.Reader2Config{DictCap: blk.dictCap}.NewReader2(data)
+	if err != nil {
+		return nil, err
+	}
+	dat, err := ioutil.ReadAll(z)
+	if err != nil {
+		return nil, fmt.Errorf("xz: %v", err)
+	}
+	x86BCJ(dat, start, false)
+	if check == xzCheckCRC32 {
+		// Skip the block padding to get to the check.
+		n := len(h) - data.Len()
+		n += -n & 3
+		if len(h) < n+4 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if crc32.ChecksumIEEE(dat) != binary.LittleEndian.Uint32(h[n:]) {
+			return nil, fmt.Errorf("xz: crc32 checksum mismatch")
+		}
+	}
+	return bytes.NewReader(dat), nil
+}
+
+// xzCompress compresses b like the kernel build does, with the BCJ x86
+// filter and LZMA2 with a 32 MiB dictionary.
+func xzCompress(b []byte) ([]byte, error) {
+	f := make([]byte, len(b))
+	copy(f, b)
+	x86BCJ(f, 0, true)
+	data, err := lzma2Compress(f)
+	if err != nil {
+		return nil, err
+	}
+	return xzFile(b, data), nil
+}
+
+// xzChunk is an LZMA2 chunk.
+type xzChunk struct {
+	control byte
+	// props is the properties byte in effect for the chunk.
+	props byte
+	// pos and end are the offsets of the chunk and of what follows it in
+	// the LZMA2 data, and start that of its uncompressed data.
+	pos, end, start int
+	size            int
+	data            []byte
+}
+
+// xzChunks returns the chunks of the LZMA2 data d.
+func xzChunks(d []byte) ([]xzChunk, error) {
+	var chunks []xzChunk
+	var props byte
+	pos, start := 0, 0
+	for {
+		if pos >= len(d) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		c := xzChunk{control: d[pos], pos: pos, start: start}
+		h := 3
+		switch {
+		case c.control == 0:
+			return chunks, nil
+		case c.control == lzma2RawDict || c.control == lzma2Raw:
+			if pos+h > len(d) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			c.size = int(binary.BigEndian.Uint16(d[pos+1:])) + 1
+			c.data = d[pos+h:]
+			if len(c.data) < c.size {
+				return nil, io.ErrUnexpectedEOF
+			}
+			c.data = c.data[:c.size]
+		case c.control >= lzma2Chunk:
+			h = 5
+			if c.control >= lzma2ChunkProps {
+				h++
+			}
+			if pos+h > len(d) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			if c.control >= lzma2ChunkProps {
+				props = d[pos+5]
+			}
+			c.size = int(c.control&0x1f)<<16 + int(binary.BigEndian.Uint16(d[pos+1:])) + 1
+			n := int(binary.BigEndian.Uint16(d[pos+3:])) + 1
+			c.data = d[pos+h:]
+			if len(c.data) < n {
+				return nil, io.ErrUnexpectedEOF
+			}
+			c.data = c.data[:n]
+		default:
+			return nil, fmt.Errorf("xz: bad LZMA2 control byte %#x", c.control)
+		}
+		c.props = props
+		c.end = pos + h + len(c.data)
+		chunks = append(chunks, c)
+		pos = c.end
+		start += c.size
+	}
+}
+
+// xzSymbols decodes the chunks of data b, starting with the state s, until
+// the first chunk that is not an LZMA chunk with the default properties.
+func xzSymbols(s *lzmaState, chunks []xzChunk, b []byte) ([]lzmaOp, error) {
+	var ops []lzmaOp
+	for _, c := range chunks {
+		if c.control < lzma2Chunk || c.props != lzmaProps {
+			break
+		}
+		if c.control >= lzma2ChunkState {
+			s.reset()
+		}
+		o, err := s.decode(c.data, b, c.start, c.start+c.size)
+		if err != nil {
+			return nil, fmt.Errorf("xz: %v", err)
+		}
+		ops = append(ops, o...)
+	}
+	return ops, nil
+}
+
+// xzPatch compresses b, which is the kernel in the xz file old with some
+// bytes changed, reusing the LZMA2 chunks of old before the first change.
+// From the chunk with the first change on, it is compressed again with the
+// symbols of old before the change, the symbols of old after it where they
+// still work, and new ones for the rest.
+//
+// The xz package does not compress as well as xz does, so this is what
+// lets a kernel compressed by xz fit in its payload after it changes near
+// its end, as it does when its initramfs is replaced.
+func xzPatch(old, oldKernel, b []byte) ([]byte, error) {
+	blk, err := parseXZBlock(old)
+	if err != nil {
+		return nil, err
+	}
+	if blk == nil || blk.start != 0 {
+		return nil, fmt.Errorf("xz: payload is not filtered by BCJ x86 and LZMA2")
+	}
+	chunks, err := xzChunks(blk.data)
+	if err != nil {
+		return nil, err
+	}
+	diff := 0
+	for diff < len(b) && diff < len(oldKernel) && b[diff] == oldKernel[diff] {
+		diff++
+	}
+	// BCJ changes the 4 bytes after a call or jump, so the filtered
+	// bytes are the same up to 5 bytes before the first change.
+	if diff -= 5; diff < 0 {
+		diff = 0
+	}
+
+	f := make([]byte, len(b))
+	copy(f, b)
+	x86BCJ(f, 0, true)
+	oldF := make([]byte, len(oldKernel))
+	copy(oldF, oldKernel)
+	x86BCJ(oldF, 0, true)
+	e := newLZMAEncoder(f, xzDictCap)
+
+	// k is the chunk with the first change.
+	k := 0
+	for k < len(chunks) && chunks[k].start+chunks[k].size <= diff {
+		k++
+	}
+	var keep, start int
+	if k > 0 {
+		keep, start = chunks[k-1].end, chunks[k-1].start+chunks[k-1].size
+	}
+	control := byte(lzma2ChunkProps)
+	var ops []lzmaOp
+	if k < len(chunks) && chunks[k].control >= lzma2Chunk && chunks[k].props == lzmaProps {
+		c := chunks[k]
+		// The low bits of the control byte are part of the size.
+		keep, start, control = c.pos, c.start, c.control&^0x1f
+
+		// The state of the chunk comes from the chunks since the last
+		// state reset.
+		r := k
+		for r > 0 && chunks[r].control < lzma2ChunkState {
+			r--
+		}
+		if _, err := xzSymbols(&e.lzmaState, chunks[r:k], f); err != nil {
+			return nil, err
+		}
+		if control >= lzma2ChunkState {
+			e.reset()
+		}
+
+		// The symbols of the chunk before the change are encoded
+		// again as they are, and the ones after it are followed where
+		// they still work.
+		s := e.lzmaState
+		old, err := xzSymbols(&s, chunks[k:], oldF)
+		if err != nil {
+			return nil, err
+		}
+		n, i := 0, start
+		for n < len(old) && i+old[n].len <= diff {
+			i += old[n].len
+			n++
+		}
+		ops = old[:n]
+		e.old, e.oldPos, e.oldB = old[n:], i, oldF
+	}
+	if start == 0 {
+		control = lzma2ChunkDict
+	}
+	Debug("xz: reusing %d compressed bytes and %d symbols for the first %d bytes", keep, len(ops), start)
+
+	data := append(append([]byte{}, blk.data[:keep]...), e.lzma2(start, control, ops)...)
+	return xzFile(b, data), nil
+}
+
+// lzma2Compress compresses b into LZMA2 chunks, the first with a dictionary
+// reset, and the end marker.
+func lzma2Compress(b []byte) ([]byte, error) {
+	var data bytes.Buffer
+	z, err := lzma.Writer2Config{DictCap: xzDictCap}.NewWriter2(&data)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := z.Write(b); err != nil {
+		return nil, err
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
+
+// xzFile returns an xz file with one block with the BCJ x86 and LZMA2
+// filters, holding b compressed to data.
+func xzFile(b, data []byte) []byte {
+	var w bytes.Buffer
+	le32 := func(v uint32) {
+		binary.Write(&w, binary.LittleEndian, v)
+	}
+	flags := []byte{0x00, xzCheckCRC32}
+	w.Write(xzHeaderMagic)
+	w.Write(flags)
+	le32(crc32.ChecksumIEEE(flags))
+
+	// A block header with two filters and no sizes.
+	h := []byte{0, 0x01, xzFilterX86, 0, xzFilterLZMA2, 1, lzma.EncodeDictCap(xzDictCap), 0}
+	h[0] = byte((len(h)+4)/4 - 1)
+	w.Write(h)
+	le32(crc32.ChecksumIEEE(h))
+	w.Write(data)
+	unpadded := len(h) + 4 + len(data)
+	w.Write(make([]byte, -unpadded&3))
+	le32(crc32.ChecksumIEEE(b))
+
+	// The index has one record, with the unpadded and uncompressed size
+	// of the block.
+	var index bytes.Buffer
+	index.WriteByte(0)
+	var v [binary.MaxVarintLen64]byte
+	for _, n := range []int{1, unpadded + 4, len(b)} {
+		index.Write(v[:binary.PutUvarint(v[:], uint64(n))])
+	}
+	index.Write(make([]byte, -index.Len()&3))
+	binary.Write(&index, binary.LittleEndian, crc32.ChecksumIEEE(index.Bytes()))
+	w.Write(index.Bytes())
+
+	footer := make([]byte, 6)
+	binary.LittleEndian.PutUint32(footer, uint32(index.Len()/4-1))
+	copy(footer[4:], flags)
+	le32(crc32.ChecksumIEEE(footer))
+	w.Write(footer)
+	w.Write(xzFooterMagic)
+	return w.Bytes()
+}
+
+// x86BCJ runs the x86 BCJ filter over b, which starts at offset start. It
+// converts the relative targets of call and jump instructions to absolute ones
+// when encoding, and back when decoding.
+//
+// This follows simple/x86.c in liblzma.
+func x86BCJ(b []byte, start uint32, encode bool) {
+	allowed := [8]bool{true, true, true, false, true, false, false, false}
+	bit := [8]uint32{0, 1, 2, 2, 3, 3, 3, 3}
+	isMSByte := func(b byte) bool { return b == 0 || b == 0xff }
+
+	var mask uint32
+	prev := start - 5
+	for i := 0; i+5 <= len(b); {
+		if b[i] != 0xe8 && b[i] != 0xe9 {
+			i++
+			continue
+		}
+		pos := start + uint32(i)
+		offset := pos - prev
+		prev = pos
+		if offset > 5 {
+			mask = 0
+		} else {
+			for j := uint32(0); j < offset; j++ {
+				mask &= 0x77
+				mask <<= 1
+			}
+		}
+		c := b[i+4]
+		if !isMSByte(c) || !allowed[(mask>>1)&7] || mask>>1 >= 0x10 {
+			i++
+			mask |= 1
+			if isMSByte(c) {
+				mask |= 0x10
+			}
+			continue
+		}
+		src := binary.LittleEndian.Uint32(b[i+1:])
+		var dest uint32
+		for {
+			if encode {
+				dest = src + pos + 5
+			} else {
+				dest = src - (pos + 5)
+			}
+			if mask == 0 {
+				break
+			}
+			n := bit[mask>>1]
+			if !isMSByte(byte(dest >> (24 - n*8))) {
+				break
+			}
+			src = dest ^ (1<<(32-n*8) - 1)
+		}
+		dest &= 0x01ffffff
+		if dest&0x01000000 != 0 {
+			dest |= 0xff000000
+		}
+		binary.LittleEndian.PutUint32(b[i+1:], dest)
+		i += 5
+		mask = 0
+	}
+}
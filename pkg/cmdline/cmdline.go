@@ -5,8 +5,11 @@
 // The cmdline package provides a parser and convenience functions for reading
 // configuration data from /proc/cmdline it's conformant with
 // https://www.kernel.org/doc/html/v4.14/admin-guide/kernel-parameters.html,
-// though making 'var_name' and 'var-name' equivalent may need to be done
-// separately.
+// and parses quotes as the kernel does. 'var_name' and 'var-name' are
+// equivalent.
+//
+// Parse returns a Line, which can be queried for module parameters, and
+// edited and turned back into a command line, e.g. for kexec.
 package cmdline
 
 import (
@@ -24,6 +27,7 @@ import (
 type CmdLine struct {
 	Raw   string
 	AsMap map[string]string
+	Line  *Line
 	Err   error
 }
 
@@ -38,7 +42,7 @@ func cmdLineOpener() {
 	if err != nil {
 		errorMsg := fmt.Sprintf("Can't open /proc/cmdline: %v", err)
 		log.Print(errorMsg)
-		procCmdLine = CmdLine{Err: fmt.Errorf(errorMsg)}
+		procCmdLine = CmdLine{Line: &Line{}, Err: fmt.Errorf(errorMsg)}
 		return
 	}
 
@@ -62,21 +66,39 @@ func FullCmdLine() string {
 // parse returns the current command line, trimmed
 func parse(cmdlineReader io.Reader) CmdLine {
 	raw, err := ioutil.ReadAll(cmdlineReader)
-	line := CmdLine{}
+	line := CmdLine{Line: &Line{}}
 	if err != nil {
 		log.Printf("Can't read command line: %v", err)
 		line.Err = err
 		line.Raw = ""
 	} else {
 		line.Raw = strings.TrimRight(string(raw), "\n")
-		line.AsMap = parseToMap(line.Raw)
+		line.Line = Parse(line.Raw)
+		line.AsMap = asMap(line.Line)
 	}
 	return line
 }
 
-// parseToMap turns a space-separated kernel commandline into a map
-func parseToMap(input string) map[string]string {
+// asMap turns a parsed kernel commandline into a map
+func asMap(l *Line) map[string]string {
+	flagMap := make(map[string]string)
+	for _, p := range l.Params {
+		// Set value="1" if there is none
+		value := p.Value
+		if !p.HasValue {
+			value = "1"
+		}
+		// We store the value twice, once with dash, once with underscores
+		// Just in case people check with the wrong method
+		flagMap[canonical(p.Name)] = value
+		flagMap[p.Name] = value
+	}
+	return flagMap
+}
 
+// fields splits a space-separated kernel commandline into its fields.
+// Spaces within quotes do not split fields, and the quotes are kept.
+func fields(input string) []string {
 	lastQuote := rune(0)
 	quotedFieldsCheck := func(c rune) bool {
 		switch {
@@ -92,9 +114,15 @@ func parseToMap(input string) map[string]string {
 			return unicode.IsSpace(c)
 		}
 	}
+	return strings.FieldsFunc(input, quotedFieldsCheck)
+}
 
+// parseToMap turns a space-separated list of flags, such as the value of
+// uroot.initflags, into a map. Unlike the kernel, it takes single quotes
+// as well as double ones.
+func parseToMap(input string) map[string]string {
 	flagMap := make(map[string]string)
-	for _, flag := range strings.FieldsFunc(string(input), quotedFieldsCheck) {
+	for _, flag := range fields(input) {
 		// kernel variables must allow '-' and '_' to be equivalent in variable
 		// names. We will replace dashes with underscores for processing.
 
@@ -138,6 +166,13 @@ func Flag(flag string) (string, bool) {
 	return value, present
 }
 
+// ModuleParams gets the parameters for module from the kernel cmdline, as
+// Line.ModuleParams does
+func ModuleParams(module string) string {
+	once.Do(cmdLineOpener)
+	return procCmdLine.Line.ModuleParams(module)
+}
+
 // getFlagMap gets specified flags as a map
 func getFlagMap(flagName string) map[string]string {
 	return parseToMap(flagName)
@@ -154,3 +189,51 @@ func GetInitFlagMap() map[string]string {
 	initflags, _ := Flag("uroot.initflags")
 	return getFlagMap(initflags)
 }
+
+// Argv splits s into arguments. Quotes group words into one argument, and
+// are removed, so that "-name 'a b'" is the two arguments -name and a b.
+func Argv(s string) []string {
+	var argv []string
+	for _, f := range fields(s) {
+		var arg []rune
+		quote := rune(0)
+		for _, c := range f {
+			switch {
+			case c == quote:
+				quote = rune(0)
+			case quote == rune(0) && unicode.In(c, unicode.Quotation_Mark):
+				quote = c
+			default:
+				arg = append(arg, c)
+			}
+		}
+		argv = append(argv, string(arg))
+	}
+	return argv
+}
+
+// GetUinitArgs gets the arguments for uinit: the words of uroot.uinitargs,
+// then the uroot.uinitflags as -name=value flags, in the order they are
+// given. Flags without a value are just -name.
+func GetUinitArgs() []string {
+	uinitargs, _ := Flag("uroot.uinitargs")
+	args := Argv(uinitargs)
+	uinitflags, _ := Flag("uroot.uinitflags")
+	for _, f := range Argv(uinitflags) {
+		args = append(args, "-"+f)
+	}
+	return args
+}
+
+// GetUinitEnv gets the environment variables for uinit: the NAME=value
+// words of uroot.uinitenv. Words that are not NAME=value are left out.
+func GetUinitEnv() []string {
+	uinitenv, _ := Flag("uroot.uinitenv")
+	var env []string
+	for _, e := range Argv(uinitenv) {
+		if strings.Index(e, "=") > 0 {
+			env = append(env, e)
+		}
+	}
+	return env
+}
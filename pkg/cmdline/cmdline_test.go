@@ -5,6 +5,7 @@
 package cmdline
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -81,3 +82,40 @@ func TestCmdline(t *testing.T) {
 	}
 
 }
+
+func TestUinitArgs(t *testing.T) {
+	for _, tt := range []struct {
+		cmdline string
+		want    []string
+	}{
+		{`ro console=ttyS0`, nil},
+		{`uroot.uinitargs="-mode netboot -v"`, []string{"-mode", "netboot", "-v"}},
+		{`uroot.uinitargs="-msg 'hello world' x"`, []string{"-msg", "hello world", "x"}},
+		{`uroot.uinitflags="a=3 skipfork test-flag"`, []string{"-a=3", "-skipfork", "-test-flag"}},
+		{`uroot.uinitargs="disk" ro uroot.uinitflags="v=1"`, []string{"disk", "-v=1"}},
+	} {
+		// Open /proc/cmdline first, so that it does not overwrite ours.
+		once.Do(cmdLineOpener)
+		procCmdLine = parse(strings.NewReader(tt.cmdline))
+		if got := GetUinitArgs(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetUinitArgs() with %q: got %q, want %q", tt.cmdline, got, tt.want)
+		}
+	}
+}
+
+func TestUinitEnv(t *testing.T) {
+	for _, tt := range []struct {
+		cmdline string
+		want    []string
+	}{
+		{`ro console=ttyS0`, nil},
+		{`uroot.uinitenv="MODE=netboot V="`, []string{"MODE=netboot", "V="}},
+		{`uroot.uinitenv="MSG='hello world' novalue =x"`, []string{"MSG=hello world"}},
+	} {
+		once.Do(cmdLineOpener)
+		procCmdLine = parse(strings.NewReader(tt.cmdline))
+		if got := GetUinitEnv(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetUinitEnv() with %q: got %q, want %q", tt.cmdline, got, tt.want)
+		}
+	}
+}
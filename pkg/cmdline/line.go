@@ -0,0 +1,225 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmdline
+
+import (
+	"strings"
+)
+
+// Param is a parameter of a kernel command line.
+type Param struct {
+	// Name is the name, e.g. console or ixgbe.allow_unsupported_sfp.
+	Name string
+	// Value is the value, without quotes around it.
+	Value string
+	// HasValue is whether there is an =. ro has none, and root= has an
+	// empty one.
+	HasValue bool
+}
+
+// Module returns the module the parameter is for, the part of its name
+// before a dot, or "" if it is not for a module.
+func (p Param) Module() string {
+	if i := strings.Index(p.Name, "."); i > 0 {
+		return p.Name[:i]
+	}
+	return ""
+}
+
+// String returns p as it is written on a command line, with quotes if
+// there are spaces in it.
+func (p Param) String() string {
+	if !p.HasValue {
+		return quote(p.Name)
+	}
+	return p.Name + "=" + quote(p.Value)
+}
+
+func quote(s string) string {
+	if strings.IndexAny(s, " \t\n") > -1 {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// Line is a parsed kernel command line. It can be changed and turned back
+// into a command line with String, e.g. to kexec a kernel with the command
+// line of the running one.
+type Line struct {
+	// Params are the parameters, in order. A name can be repeated, as
+	// console is.
+	Params []Param
+	// InitArgs are the arguments after --, which the kernel passes to
+	// init.
+	InitArgs []string
+}
+
+// Parse parses a kernel command line the way the kernel's next_arg does.
+// A parameter can be quoted as a whole, "name=a b", or just its value,
+// name="a b", and spaces in quotes do not end it.
+func Parse(s string) *Line {
+	l := &Line{}
+	dashes := false
+	for s = strings.TrimLeft(s, spaces); s != ""; s = strings.TrimLeft(s, spaces) {
+		var p Param
+		p, s = nextArg(s)
+		switch {
+		case dashes:
+			a := p.Name
+			if p.HasValue {
+				a += "=" + p.Value
+			}
+			l.InitArgs = append(l.InitArgs, a)
+		case p.Name == "--" && !p.HasValue:
+			dashes = true
+		default:
+			l.Params = append(l.Params, p)
+		}
+	}
+	return l
+}
+
+// spaces are what the kernel's isspace says are spaces.
+const spaces = " \t\n\v\f\r"
+
+// nextArg returns the parameter s starts with, and the rest of s.
+func nextArg(s string) (Param, string) {
+	var p Param
+	quoted := strings.HasPrefix(s, `"`)
+	if quoted {
+		s = s[1:]
+	}
+	inQuote := quoted
+	// As in the kernel, an = at the start does not count.
+	equals := 0
+	i := 0
+	for ; i < len(s); i++ {
+		if strings.IndexByte(spaces, s[i]) > -1 && !inQuote {
+			break
+		}
+		if equals == 0 && s[i] == '=' {
+			equals = i
+		}
+		if s[i] == '"' {
+			inQuote = !inQuote
+		}
+	}
+	arg, rest := s[:i], s[i:]
+	if quoted && strings.HasSuffix(arg, `"`) {
+		arg = arg[:len(arg)-1]
+	}
+	if equals == 0 {
+		p.Name = arg
+		return p, rest
+	}
+	p.Name, p.Value, p.HasValue = arg[:equals], arg[equals+1:], true
+	if strings.HasPrefix(p.Value, `"`) {
+		p.Value = p.Value[1:]
+		// A whole quoted parameter has lost its last quote already.
+		if !quoted && strings.HasSuffix(p.Value, `"`) {
+			p.Value = p.Value[:len(p.Value)-1]
+		}
+	}
+	return p, rest
+}
+
+// canonical returns name with underscores for dashes, since the kernel
+// treats them the same.
+func canonical(name string) string {
+	return strings.Replace(name, "-", "_", -1)
+}
+
+// Get returns the value of the last parameter called name, and whether
+// there is one. Dashes and underscores in names are the same.
+func (l *Line) Get(name string) (string, bool) {
+	vs := l.GetAll(name)
+	if len(vs) == 0 {
+		return "", false
+	}
+	return vs[len(vs)-1], true
+}
+
+// GetAll returns the values of all the parameters called name, in order.
+func (l *Line) GetAll(name string) []string {
+	var vs []string
+	name = canonical(name)
+	for _, p := range l.Params {
+		if canonical(p.Name) == name {
+			vs = append(vs, p.Value)
+		}
+	}
+	return vs
+}
+
+// Remove removes all the parameters with one of names.
+func (l *Line) Remove(names ...string) {
+	var ps []Param
+	for _, p := range l.Params {
+		if !containsName(names, p.Name) {
+			ps = append(ps, p)
+		}
+	}
+	l.Params = ps
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if canonical(n) == canonical(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set replaces the parameters called name with name=value, where the first
+// of them was, or adds it at the end if there are none.
+func (l *Line) Set(name, value string) {
+	set := Param{Name: name, Value: value, HasValue: true}
+	for i, p := range l.Params {
+		if canonical(p.Name) == canonical(name) {
+			l.Remove(name)
+			l.Params = append(l.Params[:i], append([]Param{set}, l.Params[i:]...)...)
+			return
+		}
+	}
+	l.Params = append(l.Params, set)
+}
+
+// Append adds the parameters and init arguments of a command line at the
+// end of l.
+func (l *Line) Append(s string) {
+	a := Parse(s)
+	l.Params = append(l.Params, a.Params...)
+	l.InitArgs = append(l.InitArgs, a.InitArgs...)
+}
+
+// ModuleParams returns the parameters for module, as module.name=value, in
+// the form that kmodule.Init and modprobe take them: name=value, separated
+// by spaces. Dashes and underscores in module names are the same.
+func (l *Line) ModuleParams(module string) string {
+	var ps []string
+	for _, p := range l.Params {
+		if m := p.Module(); m != "" && canonical(m) == canonical(module) {
+			p.Name = p.Name[len(m)+1:]
+			ps = append(ps, p.String())
+		}
+	}
+	return strings.Join(ps, " ")
+}
+
+// String returns l as a kernel command line.
+func (l *Line) String() string {
+	var s []string
+	for _, p := range l.Params {
+		s = append(s, p.String())
+	}
+	if len(l.InitArgs) > 0 {
+		s = append(s, "--")
+		for _, a := range l.InitArgs {
+			s = append(s, quote(a))
+		}
+	}
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmdline
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		cmdline string
+		want    *Line
+	}{
+		{"", &Line{}},
+		{
+			"  ro root=/dev/sda1\tconsole=tty0 console=ttyS0,115200n8 \n",
+			&Line{Params: []Param{
+				{Name: "ro"},
+				{Name: "root", Value: "/dev/sda1", HasValue: true},
+				{Name: "console", Value: "tty0", HasValue: true},
+				{Name: "console", Value: "ttyS0,115200n8", HasValue: true},
+			}},
+		},
+		{
+			`a="b c" "d=e f" g="h" i= =j k=l=m`,
+			&Line{Params: []Param{
+				{Name: "a", Value: "b c", HasValue: true},
+				{Name: "d", Value: "e f", HasValue: true},
+				{Name: "g", Value: "h", HasValue: true},
+				{Name: "i", HasValue: true},
+				{Name: "=j"},
+				{Name: "k", Value: "l=m", HasValue: true},
+			}},
+		},
+		{
+			// Only quotes around the value are removed.
+			`a=b"c d" "e f"`,
+			&Line{Params: []Param{
+				{Name: "a", Value: `b"c d"`, HasValue: true},
+				{Name: "e f"},
+			}},
+		},
+		{
+			`quiet -- single x="y z" --`,
+			&Line{
+				Params:   []Param{{Name: "quiet"}},
+				InitArgs: []string{"single", "x=y z", "--"},
+			},
+		},
+	} {
+		if got := Parse(tt.cmdline); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q): got %+v, want %+v", tt.cmdline, got, tt.want)
+		}
+	}
+}
+
+func TestLine(t *testing.T) {
+	l := Parse(`console=tty0 ip=dhcp ixgbe.allow-unsupported-sfp=1 root=/dev/sda1 console=ttyS0 ixgbe.x="a b" e1000.y -- init`)
+	if v, ok := l.Get("console"); !ok || v != "ttyS0" {
+		t.Errorf(`Get("console"): got %q, %v, want "ttyS0", true`, v, ok)
+	}
+	if v := l.GetAll("console"); !reflect.DeepEqual(v, []string{"tty0", "ttyS0"}) {
+		t.Errorf(`GetAll("console"): got %q`, v)
+	}
+	if _, ok := l.Get("nothere"); ok {
+		t.Errorf(`Get("nothere"): got true, want false`)
+	}
+	if v, ok := l.Get("ixgbe.allow_unsupported_sfp"); !ok || v != "1" {
+		t.Errorf("Get with underscores: got %q, %v, want 1, true", v, ok)
+	}
+	if p := l.ModuleParams("ixgbe"); p != `allow-unsupported-sfp=1 x="a b"` {
+		t.Errorf(`ModuleParams("ixgbe"): got %q`, p)
+	}
+	if p := l.ModuleParams("e1000"); p != "y" {
+		t.Errorf(`ModuleParams("e1000"): got %q`, p)
+	}
+	if p := l.ModuleParams("ip"); p != "" {
+		t.Errorf(`ModuleParams("ip"): got %q, want none`, p)
+	}
+
+	l.Remove("console")
+	l.Set("ip", "10.0.0.2::10.0.0.1:255.255.255.0::eth0:off")
+	l.Set("panic", "5")
+	l.Append(`console=ttyS1,115200 "x=a b"`)
+	want := `ip=10.0.0.2::10.0.0.1:255.255.255.0::eth0:off ixgbe.allow-unsupported-sfp=1 root=/dev/sda1 ixgbe.x="a b" e1000.y panic=5 console=ttyS1,115200 x="a b" -- init`
+	if s := l.String(); s != want {
+		t.Errorf("String: got %q, want %q", s, want)
+	}
+	if l2 := Parse(want); !reflect.DeepEqual(l2, l) {
+		t.Errorf("Parse(String()): got %+v, want %+v", l2, l)
+	}
+}
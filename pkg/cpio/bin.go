@@ -0,0 +1,147 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// binMagic is the magic number of the old binary format, 070707 in octal.
+const binMagic = 0x71c7
+
+// Bin is the old binary cpio record format.
+//
+// Archives in either byte order are read; archives are written in little
+// endian byte order. Fields are only 16 bits wide (32 bits for mtime and file
+// size), and writing a record whose fields do not fit is an error.
+var Bin RecordFormat = format{bin{}}
+
+// binHeader is the old binary header.
+//
+// The 32-bit fields mtime and filesize are stored as two 16-bit words, most
+// significant word first, regardless of byte order.
+type binHeader struct {
+	Magic    uint16
+	Dev      uint16
+	Ino      uint16
+	Mode     uint16
+	UID      uint16
+	GID      uint16
+	NLink    uint16
+	Rdev     uint16
+	MTime    [2]uint16
+	NameSize uint16
+	FileSize [2]uint16
+}
+
+// bin is the codec for the old binary format.
+type bin struct{}
+
+// headerLen implements codec.headerLen.
+func (bin) headerLen() int {
+	return binary.Size(binHeader{})
+}
+
+// align implements codec.align.
+func (bin) align() int64 {
+	return 2
+}
+
+// checksum implements codec.checksum.
+func (bin) checksum() bool {
+	return false
+}
+
+func split32(v uint64) [2]uint16 {
+	return [2]uint16{uint16(v >> 16), uint16(v)}
+}
+
+func join32(v [2]uint16) uint64 {
+	return uint64(v[0])<<16 | uint64(v[1])
+}
+
+// encode implements codec.encode.
+func (bin) encode(i Info, _ uint32) ([]byte, error) {
+	nameSize := uint64(len(i.Name)) + 1
+	for _, f := range []struct {
+		name  string
+		v     uint64
+		limit uint64
+	}{
+		{"major", i.Major, 0xff},
+		{"minor", i.Minor, 0xff},
+		{"inode", i.Ino, 0xffff},
+		{"mode", i.Mode, 0xffff},
+		{"uid", i.UID, 0xffff},
+		{"gid", i.GID, 0xffff},
+		{"nlink", i.NLink, 0xffff},
+		{"rmajor", i.Rmajor, 0xff},
+		{"rminor", i.Rminor, 0xff},
+		{"mtime", i.MTime, 0xffffffff},
+		{"name size", nameSize, 0xffff},
+		{"file size", i.FileSize, 0xffffffff},
+	} {
+		if f.v > f.limit {
+			return nil, fmt.Errorf("bin: %s %d does not fit in the header", f.name, f.v)
+		}
+	}
+
+	hdr := binHeader{
+		Magic:    binMagic,
+		Dev:      uint16(i.Major<<8 | i.Minor),
+		Ino:      uint16(i.Ino),
+		Mode:     uint16(i.Mode),
+		UID:      uint16(i.UID),
+		GID:      uint16(i.GID),
+		NLink:    uint16(i.NLink),
+		Rdev:     uint16(i.Rmajor<<8 | i.Rminor),
+		MTime:    split32(i.MTime),
+		NameSize: uint16(nameSize),
+		FileSize: split32(i.FileSize),
+	}
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, binary.LittleEndian, hdr); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decode implements codec.decode.
+func (bin) decode(buf []byte) (Info, uint64, uint32, error) {
+	var order binary.ByteOrder
+	switch {
+	case binary.LittleEndian.Uint16(buf) == binMagic:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint16(buf) == binMagic:
+		order = binary.BigEndian
+	default:
+		return Info{}, 0, 0, fmt.Errorf("reader: magic got %#x, want %#x in either byte order", buf[:2], binMagic)
+	}
+
+	var hdr binHeader
+	if err := binary.Read(bytes.NewReader(buf), order, &hdr); err != nil {
+		return Info{}, 0, 0, err
+	}
+	i := Info{
+		Major:    uint64(hdr.Dev >> 8),
+		Minor:    uint64(hdr.Dev & 0xff),
+		Ino:      uint64(hdr.Ino),
+		Mode:     uint64(hdr.Mode),
+		UID:      uint64(hdr.UID),
+		GID:      uint64(hdr.GID),
+		NLink:    uint64(hdr.NLink),
+		Rmajor:   uint64(hdr.Rdev >> 8),
+		Rminor:   uint64(hdr.Rdev & 0xff),
+		MTime:    join32(hdr.MTime),
+		FileSize: join32(hdr.FileSize),
+	}
+	return i, uint64(hdr.NameSize), 0, nil
+}
+
+func init() {
+	formatMap["bin"] = Bin
+}
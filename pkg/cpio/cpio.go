@@ -5,8 +5,10 @@
 package cpio
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -40,6 +42,51 @@ type RecordFormat interface {
 	Writer(w io.Writer) RecordWriter
 }
 
+// A StreamFormat is a RecordFormat that can also read archives that can
+// only be read sequentially, such as pipes.
+type StreamFormat interface {
+	RecordFormat
+
+	// StreamReader returns a RecordReader for archives that can only be
+	// read sequentially.
+	//
+	// The content of each record can only be read sequentially, and only
+	// until the next record is read.
+	StreamReader(r io.Reader) RecordReader
+}
+
+// NewStreamReader returns a RecordReader for the archive in r, which can
+// only be read sequentially.
+//
+// If f is a StreamFormat, its StreamReader is used. Otherwise the whole
+// archive is read into memory when the first record is read.
+func NewStreamReader(f RecordFormat, r io.Reader) RecordReader {
+	if sf, ok := f.(StreamFormat); ok {
+		return sf.StreamReader(r)
+	}
+	return &memReader{f: f, r: r}
+}
+
+// memReader reads a whole archive into memory to read it with a
+// RecordFormat's Reader.
+type memReader struct {
+	f  RecordFormat
+	r  io.Reader
+	rr RecordReader
+}
+
+// ReadRecord implements RecordReader.
+func (m *memReader) ReadRecord() (Record, error) {
+	if m.rr == nil {
+		b, err := ioutil.ReadAll(m.r)
+		if err != nil {
+			return Record{}, err
+		}
+		m.rr = m.f.Reader(bytes.NewReader(b))
+	}
+	return m.rr.ReadRecord()
+}
+
 // Format returns the RecordFormat with that name, if it exists.
 func Format(name string) (RecordFormat, error) {
 	op, ok := formatMap[name]
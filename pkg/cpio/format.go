@@ -0,0 +1,422 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpio
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/u-root/u-root/pkg/uio"
+	"golang.org/x/sys/unix"
+)
+
+// A codec encodes and decodes the record headers of one cpio format.
+//
+// Everything else -- names, contents, padding, checksums -- is the same for
+// all cpio formats and is taken care of by format.
+type codec interface {
+	// headerLen is the length of an encoded header.
+	headerLen() int
+
+	// decode decodes a header.
+	//
+	// The returned Info has no name. nameLen is the length of the name
+	// following the header, including the terminating NUL byte.
+	decode(hdr []byte) (info Info, nameLen uint64, sum uint32, err error)
+
+	// encode encodes a header for a record with info.
+	encode(info Info, sum uint32) ([]byte, error)
+
+	// align is the alignment of header+name and of content.
+	align() int64
+
+	// checksum is whether the format contains checksums of the contents
+	// of regular files.
+	checksum() bool
+}
+
+// format implements RecordFormat for any codec.
+type format struct {
+	codec
+}
+
+// alignTo returns the next multiple of a close to n.
+func alignTo(n, a int64) int64 {
+	return (n + a - 1) / a * a
+}
+
+// checksummed returns whether the content of a record with info has a
+// checksum in a checksumming format.
+func checksummed(info Info) bool {
+	return info.Mode&unix.S_IFMT == unix.S_IFREG
+}
+
+// sum is the cpio checksum, the 32-bit sum of all bytes.
+type sum uint32
+
+// Write implements io.Writer.
+func (s *sum) Write(p []byte) (int, error) {
+	for _, b := range p {
+		*s += sum(b)
+	}
+	return len(p), nil
+}
+
+type writer struct {
+	f   format
+	w   io.Writer
+	pos int64
+}
+
+// Writer implements RecordFormat.Writer.
+func (f format) Writer(w io.Writer) RecordWriter {
+	return NewDedupWriter(&writer{f: f, w: w})
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	if err != nil {
+		return 0, err
+	}
+	w.pos += int64(n)
+	return n, nil
+}
+
+func (w *writer) pad() error {
+	if o := alignTo(w.pos, w.f.align()); o != w.pos {
+		pad := make([]byte, o-w.pos)
+		if _, err := w.Write(pad); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteRecord writes cpio records. It pads the header+name write and the data
+// write to the format's alignment.
+func (w *writer) WriteRecord(f Record) error {
+	info := f.Info
+	if f.ReaderAt == nil {
+		info.FileSize = 0
+	}
+
+	var s sum
+	if w.f.checksum() && checksummed(info) && f.ReaderAt != nil {
+		c, stream := f.ReaderAt.(*streamContent)
+		switch {
+		case stream && c.verify && c.off == 0:
+			// The content of a crc stream can only be read once,
+			// but its checksum is known and is verified as the
+			// content is written.
+			s = sum(c.want)
+		case stream:
+			// Other stream content can only be read once too, so
+			// it is read into memory to sum it and then write it.
+			var err error
+			if f, err = InMemRecord(f); err != nil {
+				return err
+			}
+			fallthrough
+		default:
+			if _, err := io.Copy(&s, uio.Reader(f)); err != nil {
+				return err
+			}
+		}
+	}
+
+	hdr, err := w.f.encode(info, uint32(s))
+	if err != nil {
+		return fmt.Errorf("writing %q: %v", f.Name, err)
+	}
+	// Write header.
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+
+	// Append NULL char.
+	cstr := append([]byte(f.Info.Name), 0)
+	// Write name.
+	if _, err := w.Write(cstr); err != nil {
+		return err
+	}
+
+	// Pad to alignment.
+	if err := w.pad(); err != nil {
+		return err
+	}
+
+	// Some files do not have any content.
+	if f.ReaderAt == nil {
+		return nil
+	}
+
+	// Write file contents.
+	m, err := io.Copy(w, uio.Reader(f))
+	if err != nil {
+		return err
+	}
+	if c, ok := f.ReaderAt.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	if m > 0 {
+		return w.pad()
+	}
+	return nil
+}
+
+// readHeader decodes the header in buf and checks the name length.
+func (f format) readHeader(buf []byte) (Info, uint64, uint32, error) {
+	Debug("Header is %v\n", buf)
+	info, nameLen, s, err := f.decode(buf)
+	if err != nil {
+		return Info{}, 0, 0, err
+	}
+	Debug("Decoded header is %v\n", info)
+	if nameLen == 0 {
+		return Info{}, 0, 0, fmt.Errorf("reader: record has no name")
+	}
+	return info, nameLen, s, nil
+}
+
+type reader struct {
+	f   format
+	r   io.ReaderAt
+	pos int64
+}
+
+// Reader implements RecordFormat.Reader.
+func (f format) Reader(r io.ReaderAt) RecordReader {
+	return EOFReader{&reader{f: f, r: r}}
+}
+
+func (r *reader) read(p []byte) error {
+	n, err := r.r.ReadAt(p, r.pos)
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil || n != len(p) {
+		return fmt.Errorf("ReadAt(pos = %d): got %d, want %d bytes; error %v", r.pos, n, len(p), err)
+	}
+	r.pos += int64(n)
+	return nil
+}
+
+func (r *reader) readAligned(p []byte) error {
+	err := r.read(p)
+	r.pos = alignTo(r.pos, r.f.align())
+	return err
+}
+
+// ReadRecord implements RecordReader.
+func (r *reader) ReadRecord() (Record, error) {
+	recPos := r.pos
+
+	Debug("Next record: pos is %d\n", r.pos)
+
+	buf := make([]byte, r.f.headerLen())
+	if err := r.read(buf); err != nil {
+		return Record{}, err
+	}
+	info, nameLen, s, err := r.f.readHeader(buf)
+	if err != nil {
+		return Record{}, err
+	}
+
+	// Get the name.
+	nameBuf := make([]byte, nameLen)
+	if err := r.readAligned(nameBuf); err != nil {
+		return Record{}, err
+	}
+	info.Name = string(nameBuf[:nameLen-1])
+
+	recLen := uint64(r.pos - recPos)
+	filePos := r.pos
+	content := io.NewSectionReader(r.r, r.pos, int64(info.FileSize))
+	r.pos = alignTo(r.pos+int64(info.FileSize), r.f.align())
+
+	if r.f.checksum() && checksummed(info) {
+		var got sum
+		if _, err := io.Copy(&got, content); err != nil {
+			return Record{}, fmt.Errorf("reading %q for checksum: %v", info.Name, err)
+		}
+		if uint32(got) != s {
+			return Record{}, fmt.Errorf("%q: checksum is %#08x, header says %#08x", info.Name, uint32(got), s)
+		}
+	}
+	return Record{
+		Info:     info,
+		ReaderAt: content,
+		RecLen:   recLen,
+		RecPos:   recPos,
+		FilePos:  filePos,
+	}, nil
+}
+
+type streamReader struct {
+	f   format
+	r   io.Reader
+	pos int64
+
+	// content is the content of the last record read.
+	content *streamContent
+}
+
+// StreamReader implements StreamFormat.StreamReader.
+func (f format) StreamReader(r io.Reader) RecordReader {
+	return EOFReader{&streamReader{f: f, r: r}}
+}
+
+func (r *streamReader) read(p []byte) error {
+	n, err := io.ReadFull(r.r, p)
+	r.pos += int64(n)
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("Read(pos = %d): got %d, want %d bytes; error %v", r.pos, n, len(p), err)
+	}
+	return nil
+}
+
+// skip discards the next n bytes, adding them to s if s is not nil.
+func (r *streamReader) skip(n int64, s *sum) error {
+	var w io.Writer = ioutil.Discard
+	if s != nil {
+		w = s
+	}
+	m, err := io.CopyN(w, r.r, n)
+	r.pos += m
+	if err != nil {
+		return fmt.Errorf("skipping %d bytes at pos %d: %v", n, r.pos, err)
+	}
+	return nil
+}
+
+func (r *streamReader) align() error {
+	return r.skip(alignTo(r.pos, r.f.align())-r.pos, nil)
+}
+
+// ReadRecord implements RecordReader.
+//
+// Any content of the previous record that has not been read yet is skipped,
+// after which reading the previous record's content returns an error.
+func (r *streamReader) ReadRecord() (Record, error) {
+	if c := r.content; c != nil {
+		r.content = nil
+		c.r = nil
+		var s *sum
+		if c.verify {
+			s = &c.got
+		}
+		if err := r.skip(c.size-c.off, s); err != nil {
+			return Record{}, err
+		}
+		if err := c.check(); err != nil {
+			return Record{}, err
+		}
+		if err := r.align(); err != nil {
+			return Record{}, err
+		}
+	}
+
+	recPos := r.pos
+
+	Debug("Next record: pos is %d\n", r.pos)
+
+	buf := make([]byte, r.f.headerLen())
+	if err := r.read(buf); err != nil {
+		return Record{}, err
+	}
+	info, nameLen, s, err := r.f.readHeader(buf)
+	if err != nil {
+		return Record{}, err
+	}
+
+	// Get the name.
+	nameBuf := make([]byte, nameLen)
+	if err := r.read(nameBuf); err != nil {
+		return Record{}, err
+	}
+	if err := r.align(); err != nil {
+		return Record{}, err
+	}
+	info.Name = string(nameBuf[:nameLen-1])
+
+	r.content = &streamContent{
+		r:      r,
+		name:   info.Name,
+		size:   int64(info.FileSize),
+		verify: r.f.checksum() && checksummed(info),
+		want:   s,
+	}
+	return Record{
+		Info:     info,
+		ReaderAt: r.content,
+		RecLen:   uint64(r.pos - recPos),
+		RecPos:   recPos,
+		FilePos:  r.pos,
+	}, nil
+}
+
+// streamContent is an io.ReaderAt for the content of a record read from a
+// stream.
+//
+// It can only be read sequentially from offset 0 and only until the next
+// record is read from the stream.
+type streamContent struct {
+	r    *streamReader
+	name string
+	off  int64
+	size int64
+
+	// verify is whether the content is checksummed, in which case got
+	// is the sum of the content read so far and want the sum from the
+	// header.
+	verify bool
+	got    sum
+	want   uint32
+}
+
+func (c *streamContent) check() error {
+	if c.verify && uint32(c.got) != c.want {
+		return fmt.Errorf("%q: checksum is %#08x, header says %#08x", c.name, uint32(c.got), c.want)
+	}
+	return nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (c *streamContent) ReadAt(p []byte, off int64) (int, error) {
+	if c.r == nil {
+		return 0, fmt.Errorf("cpio: record content was skipped by reading the next record")
+	}
+	if off != c.off {
+		return 0, fmt.Errorf("cpio: stream record content must be read sequentially; read at %d, want %d", off, c.off)
+	}
+	if c.off >= c.size {
+		return 0, io.EOF
+	}
+	if rest := c.size - c.off; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := io.ReadFull(c.r.r, p)
+	c.off += int64(n)
+	c.r.pos += int64(n)
+	if c.verify {
+		c.got.Write(p[:n])
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err == nil && c.off == c.size {
+		if err := c.check(); err != nil {
+			return n, err
+		}
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,233 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+var formatTestRecords = []Record{
+	Directory("etc", 0755),
+	StaticFile("etc/hostname", "u-root\n", 0644),
+	Symlink("etc/localtime", "/usr/share/zoneinfo/UTC"),
+	CharDev("dev/null", 0666, 1, 3),
+	StaticFile("empty", "", 0600),
+	StaticRecord([]byte("odd"), Info{
+		Ino:    7,
+		Mode:   syscall.S_IFREG | 0755,
+		UID:    1000,
+		GID:    100,
+		NLink:  1,
+		MTime:  1234567890,
+		Major:  8,
+		Minor:  1,
+		Name:   "usr/bin/odd-length-name",
+		Rmajor: 0,
+	}),
+}
+
+func TestFormatsRoundTrip(t *testing.T) {
+	for _, name := range []string{"newc", "crc", "odc", "bin"} {
+		t.Run(name, func(t *testing.T) {
+			f, err := Format(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			buf := &bytes.Buffer{}
+			w := f.Writer(buf)
+			if err := WriteRecords(w, formatTestRecords); err != nil {
+				t.Fatalf("WriteRecords() = %v", err)
+			}
+			if err := WriteTrailer(w); err != nil {
+				t.Fatalf("WriteTrailer() = %v", err)
+			}
+
+			for _, r := range []RecordReader{
+				f.Reader(bytes.NewReader(buf.Bytes())),
+				NewStreamReader(f, bytes.NewReader(buf.Bytes())),
+			} {
+				for i, want := range formatTestRecords {
+					got, err := r.ReadRecord()
+					if err != nil {
+						t.Fatalf("index %d: ReadRecord() = %v", i, err)
+					}
+					if got.Info != want.Info {
+						t.Errorf("index %d: got %v, want %v", i, got.Info, want.Info)
+					}
+					if !ReaderAtEqual(got.ReaderAt, want.ReaderAt) {
+						t.Errorf("index %d (%q): content differs", i, got.Name)
+					}
+				}
+				if _, err := r.ReadRecord(); err != io.EOF {
+					t.Errorf("ReadRecord() at end = %v, want EOF", err)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamToCRC(t *testing.T) {
+	for _, name := range []string{"newc", "crc"} {
+		t.Run(name, func(t *testing.T) {
+			f, err := Format(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			in := &bytes.Buffer{}
+			w := f.Writer(in)
+			if err := WriteRecords(w, formatTestRecords); err != nil {
+				t.Fatalf("WriteRecords() = %v", err)
+			}
+			if err := WriteTrailer(w); err != nil {
+				t.Fatalf("WriteTrailer() = %v", err)
+			}
+
+			// Stream content can only be read once, but the crc
+			// writer needs its checksum before writing it.
+			out := &bytes.Buffer{}
+			if err := Passthrough(NewStreamReader(f, in), CRC.Writer(out)); err != nil {
+				t.Fatalf("Passthrough() = %v", err)
+			}
+
+			got, err := ReadAllRecords(CRC.Reader(bytes.NewReader(out.Bytes())))
+			if err != nil {
+				t.Fatalf("ReadAllRecords() = %v", err)
+			}
+			if len(got) != len(formatTestRecords) {
+				t.Fatalf("got %d records, want %d", len(got), len(formatTestRecords))
+			}
+			for i, want := range formatTestRecords {
+				if got[i].Info != want.Info || !ReaderAtEqual(got[i].ReaderAt, want.ReaderAt) {
+					t.Errorf("index %d: got %v, want %v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestODCHeader(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := ODC.Writer(buf)
+	rec := StaticRecord([]byte("world"), Info{
+		Ino:   1,
+		Mode:  syscall.S_IFREG | 0644,
+		NLink: 1,
+		Name:  "hello",
+	})
+	if err := w.WriteRecord(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "070707" + "000000" + "000001" + "100644" + "000000" + "000000" +
+		"000001" + "000000" + "00000000000" + "000006" + "00000000005" +
+		"hello\x00" + "world"
+	if got := buf.String(); got != want {
+		t.Errorf("odc record = %q, want %q", got, want)
+	}
+}
+
+func TestBinBigEndian(t *testing.T) {
+	buf := &bytes.Buffer{}
+	hdr := binHeader{
+		Magic:    binMagic,
+		Ino:      3,
+		Mode:     syscall.S_IFREG | 0644,
+		NLink:    1,
+		MTime:    split32(0x12345678),
+		NameSize: 4,
+		FileSize: split32(3),
+	}
+	if err := binary.Write(buf, binary.BigEndian, hdr); err != nil {
+		t.Fatal(err)
+	}
+	// Name and content are padded to even lengths.
+	buf.WriteString("foo\x00bar\x00")
+
+	r := Bin.Reader(bytes.NewReader(buf.Bytes()))
+	rec, err := r.ReadRecord()
+	if err != nil {
+		t.Fatalf("ReadRecord() = %v", err)
+	}
+	want := Info{
+		Ino:      3,
+		Mode:     syscall.S_IFREG | 0644,
+		NLink:    1,
+		MTime:    0x12345678,
+		FileSize: 3,
+		Name:     "foo",
+	}
+	if rec.Info != want {
+		t.Errorf("ReadRecord() = %v, want %v", rec.Info, want)
+	}
+	if !ReaderAtEqual(rec, strings.NewReader("bar")) {
+		t.Errorf("content of %q is not bar", rec.Name)
+	}
+}
+
+func TestBinOverflow(t *testing.T) {
+	w := Bin.Writer(&bytes.Buffer{})
+	rec := StaticRecord(nil, Info{Name: "big", Mode: syscall.S_IFREG, UID: 65536})
+	if err := w.WriteRecord(rec); err == nil {
+		t.Errorf("WriteRecord(uid 65536) = nil, want error")
+	}
+}
+
+func TestCRCChecksum(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := CRC.Writer(buf)
+	if err := w.WriteRecord(StaticFile("a", "abc", 0644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteTrailer(w); err != nil {
+		t.Fatal(err)
+	}
+
+	// The checksum is the last header field: 'a' + 'b' + 'c' = 0x126.
+	good := buf.Bytes()
+	if crc := string(good[102:110]); crc != "00000126" {
+		t.Errorf("checksum field = %q, want 00000126", crc)
+	}
+
+	bad := bytes.Replace(good, []byte("abc"), []byte("axc"), 1)
+
+	if _, err := CRC.Reader(bytes.NewReader(bad)).ReadRecord(); err == nil {
+		t.Errorf("Reader: ReadRecord() of corrupt record = nil, want checksum error")
+	}
+
+	// The stream reader verifies when reading the content...
+	r := NewStreamReader(CRC, bytes.NewReader(bad))
+	rec, err := r.ReadRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uio.ReadAll(rec); err == nil {
+		t.Errorf("StreamReader: reading corrupt content of %q = nil, want checksum error", rec.Name)
+	}
+
+	// ... or when skipping it.
+	r = NewStreamReader(CRC, bytes.NewReader(bad))
+	if _, err := r.ReadRecord(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadRecord(); err == nil {
+		t.Errorf("StreamReader: skipping corrupt content = nil, want checksum error")
+	}
+
+	// Good archives read fine.
+	if _, err := ReadAllRecords(CRC.Reader(bytes.NewReader(good))); err != nil {
+		t.Errorf("Reader: ReadAllRecords() = %v", err)
+	}
+	if _, err := ReadAllRecords(NewStreamReader(CRC, bytes.NewReader(good))); err != nil {
+		t.Errorf("StreamReader: ReadAllRecords() = %v", err)
+	}
+}
@@ -9,19 +9,22 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
-	"io"
-
-	"github.com/u-root/u-root/pkg/uio"
 )
 
 const (
 	newcMagic = "070701"
+	crcMagic  = "070702"
 	magicLen  = 6
 )
 
 var (
 	// Newc is the newc CPIO record format.
-	Newc RecordFormat = newc{magic: newcMagic}
+	Newc RecordFormat = format{newc{magic: newcMagic}}
+
+	// CRC is the newc CPIO record format with checksums of regular files.
+	//
+	// Checksums are verified on read and generated on write.
+	CRC RecordFormat = format{newc{magic: crcMagic, crc: true}}
 )
 
 type header struct {
@@ -73,186 +76,67 @@ func (h header) Info() Info {
 	return i
 }
 
-// newc implements RecordFormat for the newc format.
+// newc is the codec for the newc and crc formats.
+//
+// The crc format is the newc format with a different magic and a checksum of
+// the contents of regular files in the header.
 type newc struct {
 	magic string
+	crc   bool
 }
 
-// round4 returns the next multiple of 4 close to n.
-func round4(n int64) int64 {
-	return (n + 3) &^ 0x3
-}
-
-type writer struct {
-	n   newc
-	w   io.Writer
-	pos int64
-}
-
-// Writer implements RecordFormat.Writer.
-func (n newc) Writer(w io.Writer) RecordWriter {
-	return NewDedupWriter(&writer{n: n, w: w})
+// headerLen implements codec.headerLen.
+func (n newc) headerLen() int {
+	return hex.EncodedLen(binary.Size(header{})) + magicLen
 }
 
-func (w *writer) Write(b []byte) (int, error) {
-	n, err := w.w.Write(b)
-	if err != nil {
-		return 0, err
-	}
-	w.pos += int64(n)
-	return n, nil
+// align implements codec.align.
+func (n newc) align() int64 {
+	return 4
 }
 
-func (w *writer) pad() error {
-	if o := round4(w.pos); o != w.pos {
-		var pad [3]byte
-		if _, err := w.Write(pad[:o-w.pos]); err != nil {
-			return err
-		}
-	}
-	return nil
+// checksum implements codec.checksum.
+func (n newc) checksum() bool {
+	return n.crc
 }
 
-// WriteRecord writes newc cpio records. It pads the header+name write to 4
-// byte alignment and pads the data write as well.
-func (w *writer) WriteRecord(f Record) error {
-	// Write magic.
-	if _, err := w.Write([]byte(w.n.magic)); err != nil {
-		return err
-	}
-
+// encode implements codec.encode.
+func (n newc) encode(i Info, sum uint32) ([]byte, error) {
 	buf := &bytes.Buffer{}
-	hdr := headerFromInfo(f.Info)
-	if f.ReaderAt == nil {
-		hdr.FileSize = 0
-	}
-	hdr.CRC = 0
+	hdr := headerFromInfo(i)
+	hdr.CRC = sum
 	if err := binary.Write(buf, binary.BigEndian, hdr); err != nil {
-		return err
+		return nil, err
 	}
 
 	hexBuf := make([]byte, hex.EncodedLen(buf.Len()))
-	n := hex.Encode(hexBuf, buf.Bytes())
+	hex.Encode(hexBuf, buf.Bytes())
 	// It's much easier to debug if we match GNU output format.
 	hexBuf = bytes.ToUpper(hexBuf)
-
-	// Write header.
-	if _, err := w.Write(hexBuf[:n]); err != nil {
-		return err
-	}
-
-	// Append NULL char.
-	cstr := append([]byte(f.Info.Name), 0)
-	// Write name.
-	if _, err := w.Write(cstr); err != nil {
-		return err
-	}
-
-	// Pad to a multiple of 4.
-	if err := w.pad(); err != nil {
-		return err
-	}
-
-	// Some files do not have any content.
-	if f.ReaderAt == nil {
-		return nil
-	}
-
-	// Write file contents.
-	m, err := io.Copy(w, uio.Reader(f))
-	if err != nil {
-		return err
-	}
-	if c, ok := f.ReaderAt.(io.Closer); ok {
-		if err := c.Close(); err != nil {
-			return err
-		}
-	}
-	if m > 0 {
-		return w.pad()
-	}
-	return nil
-}
-
-type reader struct {
-	n   newc
-	r   io.ReaderAt
-	pos int64
-}
-
-// Reader implements RecordFormat.Reader.
-func (n newc) Reader(r io.ReaderAt) RecordReader {
-	return EOFReader{&reader{n: n, r: r}}
-}
-
-func (r *reader) read(p []byte) error {
-	n, err := r.r.ReadAt(p, r.pos)
-	if err == io.EOF {
-		return io.EOF
-	}
-	if err != nil || n != len(p) {
-		return fmt.Errorf("ReadAt(pos = %d): got %d, want %d bytes; error %v", r.pos, n, len(p), err)
-	}
-	r.pos += int64(n)
-	return nil
-}
-
-func (r *reader) readAligned(p []byte) error {
-	err := r.read(p)
-	r.pos = round4(r.pos)
-	return err
+	return append([]byte(n.magic), hexBuf...), nil
 }
 
-// ReadRecord implements RecordReader for the newc cpio format.
-func (r *reader) ReadRecord() (Record, error) {
-	hdr := header{}
-	recPos := r.pos
-
-	Debug("Next record: pos is %d\n", r.pos)
-
-	buf := make([]byte, hex.EncodedLen(binary.Size(hdr))+magicLen)
-	if err := r.read(buf); err != nil {
-		return Record{}, err
-	}
+// decode implements codec.decode.
+func (n newc) decode(buf []byte) (Info, uint64, uint32, error) {
+	var hdr header
 
 	// Check the magic.
-	if magic := string(buf[:magicLen]); magic != r.n.magic {
-		return Record{}, fmt.Errorf("reader: magic got %q, want %q", magic, r.n.magic)
+	if magic := string(buf[:magicLen]); magic != n.magic {
+		return Info{}, 0, 0, fmt.Errorf("reader: magic got %q, want %q", magic, n.magic)
 	}
-	Debug("Header is %v\n", buf)
 
 	// Decode hex header fields.
 	dst := make([]byte, binary.Size(hdr))
 	if _, err := hex.Decode(dst, buf[magicLen:]); err != nil {
-		return Record{}, fmt.Errorf("reader: error decoding hex: %v", err)
+		return Info{}, 0, 0, fmt.Errorf("reader: error decoding hex: %v", err)
 	}
 	if err := binary.Read(bytes.NewReader(dst), binary.BigEndian, &hdr); err != nil {
-		return Record{}, err
-	}
-	Debug("Decoded header is %v\n", hdr)
-
-	// Get the name.
-	nameBuf := make([]byte, hdr.NameLength)
-	if err := r.readAligned(nameBuf); err != nil {
-		return Record{}, err
+		return Info{}, 0, 0, err
 	}
-
-	info := hdr.Info()
-	info.Name = string(nameBuf[:hdr.NameLength-1])
-
-	recLen := uint64(r.pos - recPos)
-	filePos := r.pos
-	content := io.NewSectionReader(r.r, r.pos, int64(hdr.FileSize))
-	r.pos = round4(r.pos + int64(hdr.FileSize))
-	return Record{
-		Info:     info,
-		ReaderAt: content,
-		RecLen:   recLen,
-		RecPos:   recPos,
-		FilePos:  filePos,
-	}, nil
+	return hdr.Info(), uint64(hdr.NameLength), hdr.CRC, nil
 }
 
 func init() {
 	formatMap["newc"] = Newc
+	formatMap["crc"] = CRC
 }
@@ -11,6 +11,7 @@ import (
 	"reflect"
 	"syscall"
 	"testing"
+	"testing/iotest"
 
 	"github.com/u-root/u-root/pkg/uio"
 )
@@ -140,6 +141,114 @@ func TestReadWrite(t *testing.T) {
 	}
 }
 
+func TestStreamReader(t *testing.T) {
+	want, err := ReadAllRecords(Newc.Reader(bytes.NewReader(testCPIO)))
+	if err != nil {
+		t.Fatalf("Reading testCPIO reader: %v", err)
+	}
+
+	// Read all contents.
+	r := NewStreamReader(Newc, iotest.OneByteReader(bytes.NewReader(testCPIO)))
+	for i, w := range want {
+		rec, err := r.ReadRecord()
+		if err != nil {
+			t.Fatalf("index %d: ReadRecord() = %v", i, err)
+		}
+		if rec.Info != w.Info || rec.RecPos != w.RecPos || rec.RecLen != w.RecLen || rec.FilePos != w.FilePos {
+			t.Errorf("index %d: got %v, want %v", i, rec, w)
+		}
+		if !ReaderAtEqual(rec, w) {
+			t.Errorf("index %d (%q): content differs", i, rec.Name)
+		}
+	}
+	if _, err := r.ReadRecord(); err != io.EOF {
+		t.Errorf("ReadRecord() at end = %v, want EOF", err)
+	}
+
+	// Skip all contents.
+	r = NewStreamReader(Newc, bytes.NewReader(testCPIO))
+	var prev Record
+	for i, w := range want {
+		rec, err := r.ReadRecord()
+		if err != nil {
+			t.Fatalf("index %d: ReadRecord() = %v", i, err)
+		}
+		if rec.Info != w.Info {
+			t.Errorf("index %d: got %v, want %v", i, rec.Info, w.Info)
+		}
+		if prev.ReaderAt != nil && prev.FileSize > 0 {
+			if _, err := uio.ReadAll(prev); err == nil {
+				t.Errorf("index %d: reading skipped content of %q succeeded, want error", i, prev.Name)
+			}
+		}
+		prev = rec
+	}
+	if _, err := r.ReadRecord(); err != io.EOF {
+		t.Errorf("ReadRecord() at end = %v, want EOF", err)
+	}
+}
+
+// readerOnly is a RecordFormat that is not a StreamFormat.
+type readerOnly struct {
+	RecordFormat
+}
+
+func TestNewStreamReaderInMemory(t *testing.T) {
+	want, err := ReadAllRecords(Newc.Reader(bytes.NewReader(testCPIO)))
+	if err != nil {
+		t.Fatalf("Reading testCPIO reader: %v", err)
+	}
+	got, err := ReadAllRecords(NewStreamReader(readerOnly{Newc}, iotest.OneByteReader(bytes.NewReader(testCPIO))))
+	if err != nil {
+		t.Fatalf("ReadAllRecords() = %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d records, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i].Info != w.Info || !ReaderAtEqual(got[i], w) {
+			t.Errorf("index %d: got %v, want %v", i, got[i], w)
+		}
+	}
+}
+
+func TestStreamReaderTruncated(t *testing.T) {
+	r := NewStreamReader(Newc, bytes.NewReader(testCPIO[:200]))
+	rec, err := r.ReadRecord()
+	if err != nil {
+		t.Fatalf("ReadRecord() = %v", err)
+	}
+	if _, err := r.ReadRecord(); err == nil || err == io.EOF {
+		t.Errorf("ReadRecord() after %q of truncated archive = %v, want error", rec.Name, err)
+	}
+}
+
+func TestInMemRecord(t *testing.T) {
+	r := NewStreamReader(Newc, bytes.NewReader(testCPIO))
+	var recs []Record
+	for {
+		rec, err := r.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec, err = InMemRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+
+	want, err := ReadAllRecords(Newc.Reader(bytes.NewReader(testCPIO)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !AllEqual(recs, want) {
+		t.Errorf("InMemRecord records differ from Reader records")
+	}
+}
+
 func TestBad(t *testing.T) {
 	r := Newc.Reader(bytes.NewReader(badCPIO))
 	if _, err := r.ReadRecord(); err != io.EOF {
@@ -150,6 +259,16 @@ func TestBad(t *testing.T) {
 	if _, err := r.ReadRecord(); err == nil {
 		t.Errorf("Wanted bad magic err, got nil")
 	}
+
+	r = NewStreamReader(Newc, bytes.NewReader(badCPIO))
+	if _, err := r.ReadRecord(); err != io.EOF {
+		t.Errorf("StreamReader ReadRecord(badCPIO) got %v, want %v", err, io.EOF)
+	}
+
+	r = NewStreamReader(Newc, bytes.NewReader(badMagicCPIO))
+	if _, err := r.ReadRecord(); err == nil {
+		t.Errorf("Wanted bad magic err, got nil")
+	}
 }
 
 /*
@@ -0,0 +1,108 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpio
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const odcMagic = "070707"
+
+// ODC is the POSIX.1 portable ASCII cpio record format, also known as odc.
+var ODC RecordFormat = format{odc{}}
+
+// odcFields are the widths of the octal odc header fields following the
+// magic, in order: dev, ino, mode, uid, gid, nlink, rdev, mtime, namesize,
+// filesize.
+var odcFields = []int{6, 6, 6, 6, 6, 6, 6, 11, 6, 11}
+
+// odc is the codec for the odc format.
+type odc struct{}
+
+// headerLen implements codec.headerLen.
+func (odc) headerLen() int {
+	n := magicLen
+	for _, w := range odcFields {
+		n += w
+	}
+	return n
+}
+
+// align implements codec.align.
+func (odc) align() int64 {
+	return 1
+}
+
+// checksum implements codec.checksum.
+func (odc) checksum() bool {
+	return false
+}
+
+// encode implements codec.encode.
+func (o odc) encode(i Info, _ uint32) ([]byte, error) {
+	values := []uint64{
+		i.Major<<8 | i.Minor,
+		i.Ino,
+		i.Mode,
+		i.UID,
+		i.GID,
+		i.NLink,
+		i.Rmajor<<8 | i.Rminor,
+		i.MTime,
+		uint64(len(i.Name)) + 1,
+		i.FileSize,
+	}
+	buf := make([]byte, 0, o.headerLen())
+	buf = append(buf, odcMagic...)
+	for j, v := range values {
+		f := strconv.FormatUint(v, 8)
+		if len(f) > odcFields[j] {
+			return nil, fmt.Errorf("odc: value %#o does not fit in %d octal digits", v, odcFields[j])
+		}
+		for k := len(f); k < odcFields[j]; k++ {
+			buf = append(buf, '0')
+		}
+		buf = append(buf, f...)
+	}
+	return buf, nil
+}
+
+// decode implements codec.decode.
+func (odc) decode(buf []byte) (Info, uint64, uint32, error) {
+	if magic := string(buf[:magicLen]); magic != odcMagic {
+		return Info{}, 0, 0, fmt.Errorf("reader: magic got %q, want %q", magic, odcMagic)
+	}
+
+	values := make([]uint64, len(odcFields))
+	pos := magicLen
+	for j, w := range odcFields {
+		v, err := strconv.ParseUint(string(buf[pos:pos+w]), 8, 64)
+		if err != nil {
+			return Info{}, 0, 0, fmt.Errorf("reader: error decoding octal: %v", err)
+		}
+		values[j] = v
+		pos += w
+	}
+
+	i := Info{
+		Major:    values[0] >> 8,
+		Minor:    values[0] & 0xff,
+		Ino:      values[1],
+		Mode:     values[2],
+		UID:      values[3],
+		GID:      values[4],
+		NLink:    values[5],
+		Rmajor:   values[6] >> 8,
+		Rminor:   values[6] & 0xff,
+		MTime:    values[7],
+		FileSize: values[9],
+	}
+	return i, values[8], 0, nil
+}
+
+func init() {
+	formatMap["odc"] = ODC
+}
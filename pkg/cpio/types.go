@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"strings"
 	"time"
@@ -97,6 +98,22 @@ func CharDev(name string, perm uint64, rmajor, rminor uint64) Record {
 	}
 }
 
+// InMemRecord returns a copy of r with its content read into memory.
+//
+// This is useful to keep records read with a StreamFormat.StreamReader
+// around beyond reading the next record.
+func InMemRecord(r Record) (Record, error) {
+	if r.ReaderAt == nil {
+		return r, nil
+	}
+	content, err := ioutil.ReadAll(uio.Reader(r))
+	if err != nil {
+		return Record{}, fmt.Errorf("reading %q: %v", r.Name, err)
+	}
+	r.ReaderAt = bytes.NewReader(content)
+	return r, nil
+}
+
 func NewLazyFile(name string) io.ReaderAt {
 	return uio.NewLazyOpenerAt(func() (io.ReaderAt, error) {
 		return os.Open(name)
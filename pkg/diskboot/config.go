@@ -6,13 +6,17 @@ package diskboot
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"syscall"
 
+	"github.com/u-root/u-root/pkg/block"
+	"github.com/u-root/u-root/pkg/fs"
 	"github.com/u-root/u-root/pkg/kexec"
 )
 
@@ -23,6 +27,36 @@ type Config struct {
 	ConfigPath   string
 	Entries      []Entry
 	DefaultEntry int
+
+	fsys fs.FS
+}
+
+// FS returns the file system the config was found in, which is the one to
+// load the kernel from.
+func (c *Config) FS() fs.FS {
+	if c.fsys == nil {
+		return fs.Dir(c.MountPath)
+	}
+	return c.fsys
+}
+
+// EntryFS returns the file system to load the modules of e from: the
+// config's, or the one with UUID e.FSUUID. The returned func releases the
+// file system once the modules are loaded.
+func (c *Config) EntryFS(e *Entry) (fs.FS, func() error, error) {
+	if e.FSUUID == "" {
+		// The config's file system is the device's to release.
+		return c.FS(), func() error { return nil }, nil
+	}
+	devs, err := block.BlockDevices()
+	if err != nil {
+		return nil, nil, err
+	}
+	devs = devs.FilterFSUUID(e.FSUUID)
+	if len(devs) == 0 {
+		return nil, nil, fmt.Errorf("no file system with UUID %s", e.FSUUID)
+	}
+	return openFS(devs[0].DevPath, devs[0].FSType)
 }
 
 // EntryType dictates the method by which kexec should use to load
@@ -59,11 +93,19 @@ type Entry struct {
 	Name    string
 	Type    EntryType
 	Modules []Module
+	// FSUUID is the UUID of the file system the modules are on, if
+	// GRUB's search --fs-uuid puts them on another one than the config.
+	FSUUID string `json:",omitempty"`
 }
 
 // KexecLoad calls the appropriate kexec load routines based on the
 // type of Entry
 func (e *Entry) KexecLoad(mountPath, appendCmdline string, dryrun bool) error {
+	return e.KexecLoadFS(fs.Dir(mountPath), appendCmdline, dryrun)
+}
+
+// KexecLoadFS is like KexecLoad, but reads the kernel and initrd from fsys.
+func (e *Entry) KexecLoadFS(fsys fs.FS, appendCmdline string, dryrun bool) error {
 	switch e.Type {
 	case Multiboot:
 		// TODO: implement using kexec_load syscall
@@ -77,9 +119,9 @@ func (e *Entry) KexecLoad(mountPath, appendCmdline string, dryrun bool) error {
 			return fmt.Errorf("missing kernel")
 		}
 		var ramfs *os.File
-		kernelPath := filepath.Join(mountPath, e.Modules[0].Path)
+		kernelPath := e.Modules[0].Path
 		log.Print("Kernel Path:", kernelPath)
-		kernel, err := os.OpenFile(kernelPath, os.O_RDONLY, 0)
+		kernel, err := openFile(fsys, kernelPath)
 		cmdline := e.Modules[0].Params
 		if appendCmdline != "" {
 			cmdline += " " + appendCmdline
@@ -88,13 +130,15 @@ func (e *Entry) KexecLoad(mountPath, appendCmdline string, dryrun bool) error {
 		if err != nil {
 			return fmt.Errorf("failed to load kernel: %v", err)
 		}
+		defer kernel.Close()
 		if len(e.Modules) > 1 {
-			ramfsPath := filepath.Join(mountPath, e.Modules[1].Path)
+			ramfsPath := e.Modules[1].Path
 			log.Print("Ramfs Path:", ramfsPath)
-			ramfs, err = os.OpenFile(ramfsPath, os.O_RDONLY, 0)
+			ramfs, err = openFile(fsys, ramfsPath)
 			if err != nil {
 				return fmt.Errorf("failed to load ramfs: %v", err)
 			}
+			defer ramfs.Close()
 		}
 		if !dryrun {
 			return kexec.FileLoad(kernel, ramfs, cmdline)
@@ -103,6 +147,35 @@ func (e *Entry) KexecLoad(mountPath, appendCmdline string, dryrun bool) error {
 	return nil
 }
 
+// openFile opens name in fsys as an *os.File, which kexec needs. Files of
+// file system images are copied to a temporary file.
+func openFile(fsys fs.FS, name string) (*os.File, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if osf, ok := f.(*os.File); ok {
+		return osf, nil
+	}
+	defer f.Close()
+
+	tmp, err := ioutil.TempFile("", "diskboot")
+	if err != nil {
+		return nil, err
+	}
+	// The file stays open, so it can go now.
+	os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, f); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	return tmp, nil
+}
+
 type location struct {
 	Path string
 	Type parserState
@@ -129,11 +202,22 @@ var (
 // FindConfigs searching the path for valid boot configuration files
 // and returns a Config for each valid instance found.
 func FindConfigs(mountPath string) []*Config {
+	return findConfigs(fs.Dir(mountPath), mountPath)
+}
+
+// FindConfigsFS is like FindConfigs, but searches a file system, such as
+// one read by fs.Probe from an unmounted device. The paths of the Configs
+// are relative to "/".
+func FindConfigsFS(fsys fs.FS) []*Config {
+	return findConfigs(fsys, "/")
+}
+
+func findConfigs(fsys fs.FS, mountPath string) []*Config {
 	var configs []*Config
 
 	for _, location := range locations {
 		configPath := filepath.Join(mountPath, location.Path)
-		contents, err := ioutil.ReadFile(configPath)
+		contents, err := fs.ReadFile(fsys, location.Path)
 		if err != nil {
 			// TODO: log error
 			continue
@@ -141,18 +225,20 @@ func FindConfigs(mountPath string) []*Config {
 
 		var lines []string
 		if location.Type == syslinux {
-			lines = loadSyslinuxLines(configPath, contents)
+			lines = loadSyslinuxLines(fsys, location.Path, contents)
 		} else {
 			lines = strings.Split(string(contents), "\n")
 		}
 
-		configs = append(configs, ParseConfig(mountPath, configPath, lines))
+		config := ParseConfig(mountPath, configPath, lines)
+		config.fsys = fsys
+		configs = append(configs, config)
 	}
 
 	return configs
 }
 
-func loadSyslinuxLines(configPath string, contents []byte) []string {
+func loadSyslinuxLines(fsys fs.FS, configPath string, contents []byte) []string {
 	// TODO: just parse includes inline with syslinux specific parser
 	var newLines, includeLines []string
 	menuKernel := false
@@ -160,20 +246,20 @@ func loadSyslinuxLines(configPath string, contents []byte) []string {
 	lines := strings.Split(string(contents), "\n")
 	for _, line := range lines {
 		fields := strings.Fields(strings.TrimSpace(line))
-		includeDir := filepath.Dir(configPath)
+		includeDir := path.Dir(configPath)
 		if len(fields) == 2 && strings.ToUpper(fields[0]) == "INCLUDE" {
-			includeLines = loadSyslinuxInclude(includeDir, fields[1])
+			includeLines = loadSyslinuxInclude(fsys, includeDir, fields[1])
 		} else if len(fields) == 3 &&
 			strings.ToUpper(fields[0]) == "MENU" &&
 			strings.ToUpper(fields[1]) == "INCLUDE" {
-			includeLines = loadSyslinuxInclude(includeDir, fields[2])
+			includeLines = loadSyslinuxInclude(fsys, includeDir, fields[2])
 		} else if len(fields) > 1 &&
 			strings.ToUpper(fields[0]) == "APPEND" &&
 			menuKernel {
 			includeLines = []string{}
 			for _, includeFile := range fields[1:] {
 				includeLines = append(includeLines,
-					loadSyslinuxInclude(includeDir, includeFile)...)
+					loadSyslinuxInclude(fsys, includeDir, includeFile)...)
 			}
 		} else {
 			if len(fields) > 0 && strings.ToUpper(fields[0]) == "LABEL" {
@@ -191,12 +277,12 @@ func loadSyslinuxLines(configPath string, contents []byte) []string {
 	return newLines
 }
 
-func loadSyslinuxInclude(includePath, includeFile string) []string {
-	path := filepath.Join(includePath, includeFile)
-	includeContents, err := ioutil.ReadFile(path)
+func loadSyslinuxInclude(fsys fs.FS, includePath, includeFile string) []string {
+	name := path.Join(includePath, includeFile)
+	includeContents, err := fs.ReadFile(fsys, name)
 	if err != nil {
 		// TODO: log error
 		return nil
 	}
-	return loadSyslinuxLines(path, includeContents)
+	return loadSyslinuxLines(fsys, name, includeContents)
 }
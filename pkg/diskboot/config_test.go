@@ -5,13 +5,17 @@
 package diskboot
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/go-test/deep"
+	"github.com/u-root/u-root/pkg/fs"
 )
 
 func TestParseEmpty(t *testing.T) {
@@ -52,3 +56,89 @@ func TestConfigs(t *testing.T) {
 		}
 	}
 }
+
+func TestFindConfigsFS(t *testing.T) {
+	// An image made for pkg/fs/ext4, with a grub.cfg booting /vmlinuz.
+	f, err := os.Open("../fs/ext4/testdata/ext4.img.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, fstype, err := fs.Probe(bytes.NewReader(img))
+	if err != nil || fstype != "ext4" {
+		t.Fatalf("fs.Probe() = %q, %v, want ext4", fstype, err)
+	}
+
+	configs := FindConfigsFS(fsys)
+	if len(configs) != 1 {
+		t.Fatalf("FindConfigsFS() returned %d configs, want 1", len(configs))
+	}
+	c := configs[0]
+	if c.ConfigPath != "/boot/grub/grub.cfg" || len(c.Entries) != 1 {
+		t.Fatalf("FindConfigsFS() = %v, %d entries, want /boot/grub/grub.cfg with 1 entry", c.ConfigPath, len(c.Entries))
+	}
+	want := []Module{{Path: "/vmlinuz", Params: "root=/dev/sda1"}}
+	if diff := deep.Equal(c.Entries[0].Modules, want); diff != nil {
+		t.Error(diff)
+	}
+	if c.FS() != fsys {
+		t.Errorf("Config.FS() is not the file system it was found in")
+	}
+	if err := c.Entries[0].KexecLoadFS(c.FS(), "", true); err != nil {
+		t.Errorf("KexecLoadFS(dryrun): %v", err)
+	}
+}
+
+func TestSearchFSUUID(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		uuid string
+	}{
+		{"search --no-floppy --fs-uuid --set=root UUID1", "UUID1"},
+		{"search --no-floppy --fs-uuid --set=root --hint-bios=hd0,msdos1 UUID1", "UUID1"},
+		{"search -u -s root UUID1", "UUID1"},
+		{"search --fs-uuid --set UUID1", "UUID1"},
+		{"search.fs_uuid UUID1", "UUID1"},
+		{"search.fs_uuid UUID1 root", "UUID1"},
+		{"search.fs_uuid UUID1 other", ""},
+		{"search --fs-uuid --set=other UUID1", ""},
+		{"search --fs-uuid UUID1", ""},
+		{"search --no-floppy --set=root -l 'Fedora-WS-Live-27-1-6'", ""},
+	} {
+		if got := searchFSUUID(strings.Fields(tt.line)); got != tt.uuid {
+			t.Errorf("searchFSUUID(%q) = %q, want %q", tt.line, got, tt.uuid)
+		}
+	}
+
+	config := ParseConfig("/", "/boot/grub/grub.cfg", strings.Split(`search --no-floppy --fs-uuid --set=root UUID1
+menuentry 'Ubuntu' {
+	linux /vmlinuz root=UUID=UUID2
+}
+menuentry 'Other' {
+	search --no-floppy --fs-uuid --set=root UUID3
+	linux /vmlinuz
+}
+`, "\n"))
+	var got []string
+	for _, e := range config.Entries {
+		got = append(got, e.FSUUID)
+	}
+	if strings.Join(got, " ") != "UUID1 UUID3" {
+		t.Errorf("Entry FSUUIDs = %q, want [UUID1 UUID3]", got)
+	}
+	fsys, release, err := config.EntryFS(&Entry{})
+	if err != nil || fsys == nil {
+		t.Fatalf("EntryFS(entry without FSUUID) = %v, %v, want the config's", fsys, err)
+	}
+	if err := release(); err != nil {
+		t.Errorf("Releasing the config's file system: %v", err)
+	}
+}
@@ -7,19 +7,28 @@ package diskboot
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/u-root/u-root/pkg/block"
+	"github.com/u-root/u-root/pkg/fs"
+	_ "github.com/u-root/u-root/pkg/fs/ext4"
+	_ "github.com/u-root/u-root/pkg/fs/fat"
+	_ "github.com/u-root/u-root/pkg/fs/iso9660"
 	"github.com/u-root/u-root/pkg/mount"
 	"golang.org/x/sys/unix"
 )
 
-// Device contains the path to a block filesystem along with its type
+// Device contains the path to a block filesystem along with its type.
+// Devices with a file system that pkg/fs reads are not mounted; their
+// MountPath is empty and FS reads them.
 type Device struct {
 	DevPath   string
 	MountPath string
 	Fstype    string
 	Configs   []*Config
+	FS        fs.FS
 }
 
 // fstypes returns all block file system supported by the linuxboot kernel
@@ -38,10 +47,9 @@ func fstypes() (fstypes []string, err error) {
 
 // FindDevices searches for devices with bootable configs
 func FindDevices(devicesGlob string) (devices []*Device) {
-	fstypes, err := fstypes()
-	if err != nil {
-		return nil
-	}
+	// Without /proc/filesystems, only file systems that pkg/fs reads
+	// are found.
+	fstypes, _ := fstypes()
 
 	sysList, err := filepath.Glob(devicesGlob)
 	if err != nil {
@@ -49,10 +57,26 @@ func FindDevices(devicesGlob string) (devices []*Device) {
 	}
 	// The Linux /sys file system is a bit, er, awkward. You can't find
 	// the device special in there; just everything else.
+	// The glob may name disks only, as /sys/block does, so partitions
+	// of the disks it names are looked at too.
+	match := map[string]bool{}
 	for _, sys := range sysList {
-		blk := filepath.Join("/dev", filepath.Base(sys))
-
-		dev, _ := mountDevice(blk, fstypes)
+		match[filepath.Base(sys)] = true
+	}
+	devs, err := block.BlockDevices()
+	if err != nil {
+		return nil
+	}
+	for _, d := range devs {
+		if d.Size == 0 || !(match[d.Name] || match[d.Parent]) {
+			continue
+		}
+		// If the superblock is known, the kernel need not guess.
+		types := fstypes
+		if d.FSType != "" {
+			types = []string{d.FSType}
+		}
+		dev, _ := mountDevice(d.DevPath, types)
 		if dev != nil && len(dev.Configs) > 0 {
 			devices = append(devices, dev)
 		}
@@ -63,15 +87,67 @@ func FindDevices(devicesGlob string) (devices []*Device) {
 
 // FindDevice attempts to construct a boot device at the given path
 func FindDevice(devPath string) (*Device, error) {
-	fstypes, err := fstypes()
+	fstypes, _ := fstypes()
+	return mountDevice(devPath, fstypes)
+}
+
+// probeDevice reads the file system of devPath with pkg/fs.
+func probeDevice(devPath string) (*Device, error) {
+	f, err := os.Open(devPath)
+	if err != nil {
+		return nil, err
+	}
+	fsys, fstype, err := fs.Probe(f)
 	if err != nil {
-		return nil, nil
+		f.Close()
+		return nil, err
 	}
+	configs := FindConfigsFS(fsys)
+	if len(configs) == 0 {
+		f.Close()
+		return nil, fmt.Errorf("no configs on %s", devPath)
+	}
+	// f stays open for reading the kernel.
+	return &Device{DevPath: devPath, Fstype: fstype, Configs: configs, FS: fsys}, nil
+}
 
-	return mountDevice(devPath, fstypes)
+// openFS returns the file system on devPath, read with pkg/fs if it can,
+// and mounted read-only as fstype if not, and a func that closes the device
+// or unmounts it.
+func openFS(devPath, fstype string) (fs.FS, func() error, error) {
+	f, err := os.Open(devPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fsys, _, err := fs.Probe(f); err == nil {
+		return fsys, f.Close, nil
+	}
+	f.Close()
+
+	mountPath, err := ioutil.TempDir("/tmp", "boot-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to create tmp mount directory: %v", err)
+	}
+	if err := mount.Mount(devPath, mountPath, fstype, "", unix.MS_RDONLY); err != nil {
+		os.Remove(mountPath)
+		return nil, nil, err
+	}
+	return fs.Dir(mountPath), func() error { return unmount(mountPath) }, nil
+}
+
+// unmount unmounts mountPath and removes it.
+func unmount(mountPath string) error {
+	if err := mount.Unmount(mountPath, true, false); err != nil {
+		return err
+	}
+	return os.Remove(mountPath)
 }
 
 func mountDevice(devPath string, fstypes []string) (*Device, error) {
+	if dev, err := probeDevice(devPath); err == nil {
+		return dev, nil
+	}
+
 	mountPath, err := ioutil.TempDir("/tmp", "boot-")
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create tmp mount directory: %v", err)
@@ -83,10 +159,18 @@ func mountDevice(devPath string, fstypes []string) (*Device, error) {
 
 		configs := FindConfigs(mountPath)
 		if len(configs) == 0 {
+			mount.Unmount(mountPath, true, false)
 			continue
 		}
 
-		return &Device{devPath, mountPath, fstype, configs}, nil
+		return &Device{
+			DevPath:   devPath,
+			MountPath: mountPath,
+			Fstype:    fstype,
+			Configs:   configs,
+			FS:        fs.Dir(mountPath),
+		}, nil
 	}
+	os.Remove(mountPath)
 	return nil, fmt.Errorf("Failed to find a valid boot device with configs")
 }
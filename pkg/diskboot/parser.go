@@ -25,6 +25,48 @@ type parser struct {
 	entry        *Entry
 	defaultName  string
 	defaultIndex int
+	// rootUUID is the file system UUID of GRUB's root outside entries.
+	rootUUID string
+}
+
+// searchFSUUID returns the UUID of a GRUB search --fs-uuid command that
+// sets root, or "".
+func searchFSUUID(f []string) string {
+	var (
+		byUUID = f[0] == "search.fs_uuid"
+		set    = byUUID
+		setVar = "root"
+		args   []string
+	)
+	for _, a := range f[1:] {
+		switch {
+		case a == "--fs-uuid" || a == "-u":
+			byUUID = true
+		case a == "--set" || a == "-s":
+			set = true
+		case strings.HasPrefix(a, "--set="):
+			set, setVar = true, a[len("--set="):]
+		case strings.HasPrefix(a, "-"):
+		default:
+			args = append(args, a)
+		}
+	}
+	if !byUUID || !set || len(args) == 0 {
+		return ""
+	}
+	// The variable can also follow the UUID, or a bare --set.
+	if len(args) > 1 {
+		if f[0] == "search.fs_uuid" {
+			setVar = args[1]
+		} else {
+			setVar = args[0]
+			args = args[1:]
+		}
+	}
+	if setVar != "root" {
+		return ""
+	}
+	return args[0]
 }
 
 func (p *parser) parseSearch(line string) {
@@ -50,6 +92,10 @@ func (p *parser) parseSearch(line string) {
 		if len(f) > 1 {
 			p.parseSearchHandleSet(f[1])
 		}
+	case "SEARCH", "SEARCH.FS_UUID": // grub
+		if uuid := searchFSUUID(f); uuid != "" {
+			p.rootUUID = uuid
+		}
 	case "LABEL": // syslinux
 		p.state = syslinux
 		newEntry = true
@@ -68,8 +114,9 @@ func (p *parser) parseSearch(line string) {
 
 	if newEntry {
 		p.entry = &Entry{
-			Name: name,
-			Type: Elf,
+			Name:   name,
+			Type:   Elf,
+			FSUUID: p.rootUUID,
 		}
 	}
 }
@@ -119,6 +166,10 @@ func (p *parser) parseGrubEntry(line string) {
 		p.entry.Modules = append(p.entry.Modules, NewModule(f[1], f[2:]))
 	case "initrd":
 		p.entry.Modules = append(p.entry.Modules, NewModule(f[1], nil))
+	case "search", "search.fs_uuid":
+		if uuid := searchFSUUID(f); uuid != "" {
+			p.entry.FSUUID = uuid
+		}
 	}
 }
 
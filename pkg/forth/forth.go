@@ -25,6 +25,21 @@
 // At the end of the evaluation the stack should have one element
 // left; that element is popped and returned. It is an error (currently)
 // to return with a non-empty stack.
+// Beyond expressions, there are enough words for small programs, such
+// as for poking at hardware while bringing up firmware:
+//     : name ... ;                define a word
+//     c if ... else ... then      run one part if c is not 0
+//     limit start do ... loop     count i from start to limit; +loop
+//                                 adds TOS to i instead of 1, and j is
+//                                 the index of the enclosing loop
+//     begin ... c until           run until c is not 0
+//     begin c while ... repeat    run while c is not 0
+//     variable name, value constant name, @ and !
+//     ." text" prints text, s" text" pushes it
+//     \ comments to the end of the line, ( comments to )
+// and words for comparisons, logic, output, files, sysfs, the
+// environment, IO ports and physical memory. Comparisons leave -1 for
+// true and 0 for false. Run evaluates a program without returning TOS.
 // This package was used for real work at Sandia National Labs from 2010 to 2012 and possibly later.
 // Some of the use of error may seem a bit weird but the creation of this package predates the
 // creation of the error type (it was still an os thing back then).
@@ -82,7 +97,7 @@ func New() Forth {
 // Newop creates a new operation. We considered having
 // an opmap per stack but don't feel the package requires it
 func (f *forthstack) Newop(n string, op forthop) {
-	opmap[n] = op
+	setop(n, op)
 }
 
 func Ops() map[string]forthop {
@@ -142,24 +157,11 @@ func errRecover(errp *error) {
 
 /* iEval takes a Forth and strings and splits the string on space
  * characters, pushing each element on the stack or invoking the
- * operator if it is found in the opmap.
+ * operator if it is found in the opmap or the words.
  */
 func iEval(f Forth, s string) {
-	// TODO: create a separator list based on isspace and all the
-	// non alpha numberic characters in the opmap.
-	for _, val := range strings.Fields(s) {
-		//fmt.Printf("eval %s stack %v", val, f.Stack())
-		fun := opmap[val]
-		if fun != nil {
-			//fmt.Printf("Eval ...:")
-			fun(f)
-			//fmt.Printf("Stack now %v", f.Stack())
-		} else {
-			f.Push(val)
-			//fmt.Printf("push %s, stack %v", val, f.Stack())
-		}
-	}
-	return
+	in := &interp{f: f}
+	in.run(tokens(s))
 }
 
 /* Eval takes a Forth and strings and splits the string on space
@@ -175,11 +177,24 @@ func Eval(f Forth, s string) (ret string, err error) {
 
 }
 
-// toInt converts to int64.
+// Run evaluates s like Eval, but leaves the stack as it is.
+func Run(f Forth, s string) (err error) {
+	defer errRecover(&err)
+	iEval(f, s)
+	return
+}
+
+// toInt converts to int64. Numbers too large for an int64, such as
+// 64-bit addresses, wrap around.
 func toInt(f Forth) int64 {
-	i, err := strconv.ParseInt(f.Pop(), 0, 64)
+	s := f.Pop()
+	i, err := strconv.ParseInt(s, 0, 64)
 	if err != nil {
-		panic(err)
+		u, uerr := strconv.ParseUint(s, 0, 64)
+		if uerr != nil {
+			panic(err)
+		}
+		i = int64(u)
 	}
 	return i
 }
@@ -269,11 +284,9 @@ func hostbase(f Forth) {
 	f.Push(strings.TrimLeft(host, "abcdefghijklmnopqrstuvwxyz -"))
 }
 
+// NewWord defines name as command, as : name command ; would.
 func NewWord(f Forth, name, command string) {
-	newword := func(f Forth) {
-		iEval(f, command)
-		return
-	}
-	opmap[name] = newword
+	delete(opmap, name)
+	words[name] = tokens(command)
 	return
 }
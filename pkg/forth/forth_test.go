@@ -5,7 +5,11 @@
 package forth
 
 import (
+	"bytes"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -87,3 +91,112 @@ func TestForth(t *testing.T) {
 	}
 
 }
+
+func TestProgram(t *testing.T) {
+	var out bytes.Buffer
+	output = &out
+	defer func() { output = os.Stdout }()
+	for _, tt := range []struct {
+		prog  string
+		stack []string
+		out   string
+		err   string
+	}{
+		{"1 2 < 2 1 < 0x10 16 = a b = a a <>", []string{"-1", "0", "-1", "0", "0"}, "", ""},
+		{"1 2 over rot tuck nip 2dup 2drop drop", []string{"2", "1"}, "", ""},
+		{"0xf0 0x3c and 1 4 lshift 0 invert -5 abs 3 7 min", []string{"48", "16", "-1", "5", "3"}, "", ""},
+		{"0xffffffffffffffff 1 + 255 hex", []string{"0", "0xff"}, "", ""},
+		{"1 if yes else no then 0 if yes else no then 0 if yes then", []string{"yes", "no"}, "", ""},
+		{"1 if 0 if a else b then then", []string{"b"}, "", ""},
+		{"5 0 do i . loop", nil, "0 1 2 3 4 ", ""},
+		{"10 0 do i 2 +loop", []string{"0", "2", "4", "6", "8"}, "", ""},
+		{"2 0 do 2 0 do j i + loop loop", []string{"0", "1", "1", "2"}, "", ""},
+		{"0 begin 1 + dup 3 = until", []string{"3"}, "", ""},
+		{"0 begin dup 3 < while 1 + repeat", []string{"3"}, "", ""},
+		{": sq dup * ; : sq+ sq + ; 3 sq 2 4 sq+", []string{"9", "18"}, "", ""},
+		{": fact dup 1 > if dup 1 - fact * then ; 5 fact", []string{"120"}, "", ""},
+		{"variable v v @ 5 v ! v @ 1 + v ! v @", []string{"0", "6"}, "", ""},
+		{"42 constant answer answer", []string{"42"}, "", ""},
+		{`." hello,  world" cr s" a b" type 72 emit`, nil, "hello, world\na bH", ""},
+		{"1 ( a comment ) 2 \\ 3\n4 .s", []string{"1", "2", "4"}, "<3> 1 2 4\n", ""},
+		{"1 if 2", nil, "", "if without else or then"},
+		{": x 1", nil, "", ": without ;"},
+		{"then", nil, "", "unexpected then"},
+		{"i", nil, "", "i outside of a loop"},
+		{"x @", nil, "", "x is not a variable"},
+	} {
+		f := New()
+		out.Reset()
+		err := Run(f, tt.prog)
+		if err != nil && err.Error() != tt.err || err == nil && tt.err != "" {
+			t.Errorf("%q: got %v, want %v", tt.prog, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if s := f.Stack(); len(s) != 0 || len(tt.stack) != 0 {
+			if !reflect.DeepEqual(s, tt.stack) {
+				t.Errorf("%q: stack is %q, want %q", tt.prog, s, tt.stack)
+			}
+		}
+		if out.String() != tt.out {
+			t.Errorf("%q: printed %q, want %q", tt.prog, out.String(), tt.out)
+		}
+	}
+}
+
+func TestSys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(s, p, m string) { sysfsRoot, portDev, memDev = s, p, m }(sysfsRoot, portDev, memDev)
+	sysfsRoot = dir
+	portDev = filepath.Join(dir, "port")
+	memDev = filepath.Join(dir, "mem")
+	if err := os.MkdirAll(filepath.Join(dir, "class/net/eth0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "class/net/eth0/mtu"), []byte("1500\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mem := make([]byte, 32)
+	for i := range mem {
+		mem[i] = byte(i)
+	}
+	for _, n := range []string{portDev, memDev} {
+		if err := ioutil.WriteFile(n, mem, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Setenv("FORTHTEST", "")
+	defer os.Unsetenv("FORTHTEST")
+
+	f := New()
+	file := filepath.Join(dir, "file")
+	for _, tt := range []struct {
+		prog string
+		want string
+	}{
+		{"class/net/eth0/mtu sysfs 100 +", "1600"},
+		{"hello " + file + " writefile " + file + " readfile", "hello"},
+		{"abc FORTHTEST setenv FORTHTEST getenv", "abc"},
+		{"0x4 inb", "0x4"},
+		{"0x4 inw", "0x504"},
+		{"0x4 inl", "0x7060504"},
+		{"0x8 rq", "0xf0e0d0c0b0a0908"},
+		{"0x1234 0x10 outw 0x10 inl", "0x13121234"},
+		{"0xdeadbeef 0x18 wl 0x18 rl 0x18 rb", "0xef"},
+	} {
+		got, err := Eval(f, tt.prog)
+		if err != nil || got != tt.want {
+			t.Errorf("%q: got %q, %v, want %q", tt.prog, got, err, tt.want)
+		}
+		f.Reset()
+	}
+	if err := Run(f, "0x100 rb"); err == nil {
+		t.Errorf("0x100 rb: got nil, want an error")
+	}
+}
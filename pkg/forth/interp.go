@@ -0,0 +1,207 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package forth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// words are the words defined with : and NewWord. They are run by the
+// interpreter, not as ops, so that i and j work in them.
+var words = map[string][]string{}
+
+// openers and closers are the words that nest.
+var (
+	openers = map[string]bool{":": true, "if": true, "do": true, "begin": true}
+	closers = map[string]bool{";": true, "then": true, "loop": true, "+loop": true, "until": true, "repeat": true}
+)
+
+// tokens splits s into words. \ comments out the rest of a line and ( a
+// word up to ). ." and s" are followed by their string as one word.
+func tokens(s string) []string {
+	var t []string
+	for _, l := range strings.Split(s, "\n") {
+		f := strings.Fields(l)
+		for i := 0; i < len(f); i++ {
+			switch f[i] {
+			case "\\":
+				i = len(f)
+			case "(":
+				for i < len(f) && !strings.HasSuffix(f[i], ")") {
+					i++
+				}
+			case `."`, `s"`:
+				t = append(t, f[i])
+				var str []string
+				for i++; i < len(f); i++ {
+					if strings.HasSuffix(f[i], `"`) {
+						str = append(str, strings.TrimSuffix(f[i], `"`))
+						break
+					}
+					str = append(str, f[i])
+				}
+				t = append(t, strings.Join(str, " "))
+			default:
+				t = append(t, f[i])
+			}
+		}
+	}
+	return t
+}
+
+// An interp runs words on a Forth. loops are the indices of the do loops
+// it is in, the innermost last.
+type interp struct {
+	f     Forth
+	loops []int64
+}
+
+// match returns the index and the word of the first of ends after w[i],
+// skipping nested structures.
+func match(w []string, i int, ends ...string) (int, string) {
+	depth := 0
+	for k := i + 1; k < len(w); k++ {
+		if depth == 0 {
+			for _, e := range ends {
+				if w[k] == e {
+					return k, e
+				}
+			}
+		}
+		switch {
+		case openers[w[k]]:
+			depth++
+		case closers[w[k]]:
+			depth--
+		}
+		if depth < 0 {
+			break
+		}
+	}
+	panic(fmt.Errorf("%s without %s", w[i], strings.Join(ends, " or ")))
+}
+
+// name returns the name after w[i], as for : and variable.
+func name(w []string, i int) string {
+	if i+1 >= len(w) {
+		panic(fmt.Errorf("%s without a name", w[i]))
+	}
+	return w[i+1]
+}
+
+func (in *interp) run(w []string) {
+	f := in.f
+	for i := 0; i < len(w); i++ {
+		switch t := w[i]; t {
+		case ":":
+			n := name(w, i)
+			end, _ := match(w, i, ";")
+			if end < i+2 {
+				panic(errors.New(": without a name"))
+			}
+			delete(opmap, n)
+			words[n] = w[i+2 : end]
+			i = end
+		case "variable":
+			n := name(w, i)
+			variables[n] = "0"
+			setop(n, func(f Forth) { f.Push(n) })
+			i++
+		case "constant":
+			n, v := name(w, i), f.Pop()
+			setop(n, func(f Forth) { f.Push(v) })
+			i++
+		case `."`, `s"`:
+			if i+1 >= len(w) {
+				panic(fmt.Errorf("%s without a string", t))
+			}
+			if t == `."` {
+				fmt.Fprint(output, w[i+1])
+			} else {
+				f.Push(w[i+1])
+			}
+			i++
+		case "if":
+			els, k := match(w, i, "else", "then")
+			end := els
+			if k == "else" {
+				end, _ = match(w, els, "then")
+			}
+			if toInt(f) != 0 {
+				in.run(w[i+1 : els])
+			} else if els != end {
+				in.run(w[els+1 : end])
+			}
+			i = end
+		case "do":
+			end, k := match(w, i, "loop", "+loop")
+			start, limit := toInt(f), toInt(f)
+			in.loops = append(in.loops, start)
+			for {
+				in.run(w[i+1 : end])
+				n := int64(1)
+				if k == "+loop" {
+					n = toInt(f)
+				}
+				idx := &in.loops[len(in.loops)-1]
+				*idx += n
+				if n >= 0 && *idx >= limit || n < 0 && *idx < limit {
+					break
+				}
+			}
+			in.loops = in.loops[:len(in.loops)-1]
+			i = end
+		case "begin":
+			end, k := match(w, i, "until", "while")
+			if k == "until" {
+				for {
+					in.run(w[i+1 : end])
+					if toInt(f) != 0 {
+						break
+					}
+				}
+			} else {
+				rep, _ := match(w, end, "repeat")
+				for {
+					in.run(w[i+1 : end])
+					if toInt(f) == 0 {
+						break
+					}
+					in.run(w[end+1 : rep])
+				}
+				end = rep
+			}
+			i = end
+		case "i", "j":
+			n := 1
+			if t == "j" {
+				n = 2
+			}
+			if len(in.loops) < n {
+				panic(fmt.Errorf("%s outside of a loop", t))
+			}
+			f.Push(strconv.FormatInt(in.loops[len(in.loops)-n], 10))
+		case ";", "else", "then", "loop", "+loop", "until", "while", "repeat":
+			panic(fmt.Errorf("unexpected %s", t))
+		default:
+			if def, ok := words[t]; ok {
+				in.run(def)
+			} else if op, ok := opmap[t]; ok {
+				op(f)
+			} else {
+				f.Push(t)
+			}
+		}
+	}
+}
+
+// setop makes op the meaning of name, replacing a word of that name.
+func setop(name string, op forthop) {
+	delete(words, name)
+	opmap[name] = op
+}
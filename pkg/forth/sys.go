@@ -0,0 +1,127 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package forth
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// These are where the system words look. They are variables for testing.
+var (
+	sysfsRoot = "/sys"
+	portDev   = "/dev/port"
+	memDev    = "/dev/mem"
+)
+
+func init() {
+	for n, op := range map[string]forthop{
+		"readfile":  readFile,
+		"writefile": writeFile,
+		"sysfs":     sysfs,
+		"getenv":    getenv,
+		"setenv":    setenv,
+		"inb":       in(&portDev, 1),
+		"inw":       in(&portDev, 2),
+		"inl":       in(&portDev, 4),
+		"outb":      out(&portDev, 1),
+		"outw":      out(&portDev, 2),
+		"outl":      out(&portDev, 4),
+		"rb":        in(&memDev, 1),
+		"rw":        in(&memDev, 2),
+		"rl":        in(&memDev, 4),
+		"rq":        in(&memDev, 8),
+		"wb":        out(&memDev, 1),
+		"ww":        out(&memDev, 2),
+		"wl":        out(&memDev, 4),
+		"wq":        out(&memDev, 8),
+	} {
+		opmap[n] = op
+	}
+}
+
+// readFile replaces a file name with the contents of the file, without
+// the newline at the end.
+func readFile(f Forth) {
+	b, err := ioutil.ReadFile(f.Pop())
+	if err != nil {
+		panic(err)
+	}
+	f.Push(strings.TrimSuffix(string(b), "\n"))
+}
+
+// writeFile pops a file name and writes what is below it to the file.
+func writeFile(f Forth) {
+	n := f.Pop()
+	if err := ioutil.WriteFile(n, []byte(f.Pop()), 0644); err != nil {
+		panic(err)
+	}
+}
+
+// sysfs reads a file in sysfs, as in class/net/eth0/address sysfs.
+func sysfs(f Forth) {
+	f.Push(filepath.Join(sysfsRoot, f.Pop()))
+	readFile(f)
+}
+
+func getenv(f Forth) {
+	f.Push(os.Getenv(f.Pop()))
+}
+
+// setenv pops a name and sets it to what is below it.
+func setenv(f Forth) {
+	n := f.Pop()
+	if err := os.Setenv(n, f.Pop()); err != nil {
+		panic(err)
+	}
+}
+
+// in returns an op that replaces an address with the value of size bytes
+// at it in *dev, as the io command does.
+func in(dev *string, size int) forthop {
+	return func(f Forth) {
+		addr := toInt(f)
+		d, err := os.Open(*dev)
+		if err != nil {
+			panic(err)
+		}
+		defer d.Close()
+		if _, err := d.Seek(addr, 0); err != nil {
+			panic(fmt.Errorf("in: bad address %#x: %v", addr, err))
+		}
+		b := make([]byte, 8)
+		if _, err := io.ReadFull(d, b[:size]); err != nil {
+			panic(err)
+		}
+		f.Push(fmt.Sprintf("%#x", binary.LittleEndian.Uint64(b)))
+	}
+}
+
+// out returns an op that pops an address and writes the value below it,
+// size bytes of it, at the address in *dev.
+func out(dev *string, size int) forthop {
+	return func(f Forth) {
+		addr := toInt(f)
+		v := toInt(f)
+		d, err := os.OpenFile(*dev, os.O_WRONLY, 0)
+		if err != nil {
+			panic(err)
+		}
+		defer d.Close()
+		if _, err := d.Seek(addr, 0); err != nil {
+			panic(fmt.Errorf("out: bad address %#x: %v", addr, err))
+		}
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, uint64(v))
+		if _, err := d.Write(b[:size]); err != nil {
+			panic(err)
+		}
+	}
+}
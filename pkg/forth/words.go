@@ -0,0 +1,218 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package forth
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// output is where the words that print write.
+	output io.Writer = os.Stdout
+	// variables holds the values of the words defined with variable.
+	variables = map[string]string{}
+)
+
+func init() {
+	for n, op := range map[string]forthop{
+		"drop":   drop,
+		"over":   over,
+		"rot":    rot,
+		"nip":    nip,
+		"tuck":   tuck,
+		"2dup":   dup2,
+		"2drop":  drop2,
+		"=":      equal,
+		"<>":     notEqual,
+		"<":      compare(func(x, y int64) bool { return x < y }),
+		">":      compare(func(x, y int64) bool { return x > y }),
+		"<=":     compare(func(x, y int64) bool { return x <= y }),
+		">=":     compare(func(x, y int64) bool { return x >= y }),
+		"0=":     zero,
+		"and":    op2(func(x, y int64) int64 { return x & y }),
+		"or":     op2(func(x, y int64) int64 { return x | y }),
+		"xor":    op2(func(x, y int64) int64 { return x ^ y }),
+		"lshift": op2(func(x, y int64) int64 { return int64(uint64(x) << uint64(y)) }),
+		"rshift": op2(func(x, y int64) int64 { return int64(uint64(x) >> uint64(y)) }),
+		"min":    op2(func(x, y int64) int64 { return pick(x < y, x, y) }),
+		"max":    op2(func(x, y int64) int64 { return pick(x > y, x, y) }),
+		"invert": op1(func(x int64) int64 { return ^x }),
+		"negate": op1(func(x int64) int64 { return -x }),
+		"abs":    op1(func(x int64) int64 { return pick(x < 0, -x, x) }),
+		"hex":    hex,
+		"@":      fetch,
+		"!":      store,
+		".":      dot,
+		".s":     dotStack,
+		"cr":     cr,
+		"emit":   emit,
+		"type":   typeString,
+	} {
+		opmap[n] = op
+	}
+}
+
+func flag(b bool) string {
+	if b {
+		return "-1"
+	}
+	return "0"
+}
+
+// pick returns x if c, and else y.
+func pick(c bool, x, y int64) int64 {
+	if c {
+		return x
+	}
+	return y
+}
+
+func drop(f Forth) {
+	f.Pop()
+}
+
+func over(f Forth) {
+	x := f.Pop()
+	y := f.Pop()
+	f.Push(y)
+	f.Push(x)
+	f.Push(y)
+}
+
+func rot(f Forth) {
+	x := f.Pop()
+	y := f.Pop()
+	z := f.Pop()
+	f.Push(y)
+	f.Push(x)
+	f.Push(z)
+}
+
+func nip(f Forth) {
+	x := f.Pop()
+	f.Pop()
+	f.Push(x)
+}
+
+func tuck(f Forth) {
+	x := f.Pop()
+	y := f.Pop()
+	f.Push(x)
+	f.Push(y)
+	f.Push(x)
+}
+
+func dup2(f Forth) {
+	x := f.Pop()
+	y := f.Pop()
+	f.Push(y)
+	f.Push(x)
+	f.Push(y)
+	f.Push(x)
+}
+
+func drop2(f Forth) {
+	f.Pop()
+	f.Pop()
+}
+
+// equal compares numbers as numbers, so that 0x10 16 = is true, and
+// anything else as strings.
+func equal(f Forth) {
+	x := f.Pop()
+	y := f.Pop()
+	xi, xerr := strconv.ParseInt(x, 0, 64)
+	yi, yerr := strconv.ParseInt(y, 0, 64)
+	if xerr == nil && yerr == nil {
+		f.Push(flag(xi == yi))
+		return
+	}
+	f.Push(flag(x == y))
+}
+
+func notEqual(f Forth) {
+	equal(f)
+	f.Push(flag(toInt(f) == 0))
+}
+
+func zero(f Forth) {
+	f.Push(flag(toInt(f) == 0))
+}
+
+// compare returns an op that pops x, then y, and pushes whether c(y, x),
+// so that 1 2 < is true.
+func compare(c func(x, y int64) bool) forthop {
+	return func(f Forth) {
+		x := toInt(f)
+		y := toInt(f)
+		f.Push(flag(c(y, x)))
+	}
+}
+
+// op2 returns an op that pops x, then y, and pushes c(y, x).
+func op2(c func(x, y int64) int64) forthop {
+	return func(f Forth) {
+		x := toInt(f)
+		y := toInt(f)
+		f.Push(strconv.FormatInt(c(y, x), 10))
+	}
+}
+
+func op1(c func(x int64) int64) forthop {
+	return func(f Forth) {
+		f.Push(strconv.FormatInt(c(toInt(f)), 10))
+	}
+}
+
+// hex converts TOS to hexadecimal, as in 0x1f.
+func hex(f Forth) {
+	f.Push(fmt.Sprintf("%#x", uint64(toInt(f))))
+}
+
+// fetch replaces the name of a variable with its value.
+func fetch(f Forth) {
+	n := f.Pop()
+	v, ok := variables[n]
+	if !ok {
+		panic(fmt.Errorf("%s is not a variable", n))
+	}
+	f.Push(v)
+}
+
+// store pops the name of a variable and the value to store in it.
+func store(f Forth) {
+	n := f.Pop()
+	v := f.Pop()
+	if _, ok := variables[n]; !ok {
+		panic(fmt.Errorf("%s is not a variable", n))
+	}
+	variables[n] = v
+}
+
+func dot(f Forth) {
+	fmt.Fprintf(output, "%s ", f.Pop())
+}
+
+// dotStack prints the depth and the stack, TOS last, and leaves it as it is.
+func dotStack(f Forth) {
+	s := f.Stack()
+	fmt.Fprintf(output, "<%d> %s\n", len(s), strings.Join(s, " "))
+}
+
+func cr(f Forth) {
+	fmt.Fprintln(output)
+}
+
+func emit(f Forth) {
+	fmt.Fprintf(output, "%c", rune(toInt(f)))
+}
+
+func typeString(f Forth) {
+	fmt.Fprint(output, f.Pop())
+}
@@ -0,0 +1,613 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ext4 reads ext2, ext3 and ext4 file systems.
+//
+// The journal is not replayed, so a file system that was not unmounted
+// cleanly reads as it was at the last checkpoint. Encrypted files can not
+// be read.
+package ext4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/u-root/u-root/pkg/fs"
+)
+
+const (
+	superblockOffset = 1024
+	magic            = 0xef53
+	rootIno          = 2
+
+	compatHasJournal = 0x4
+
+	incompatCompression = 0x1
+	incompatFiletype    = 0x2
+	incompatRecover     = 0x4
+	incompatJournalDev  = 0x8
+	incompatMetaBG      = 0x10
+	incompatExtents     = 0x40
+	incompat64Bit       = 0x80
+	incompatMMP         = 0x100
+	incompatFlexBG      = 0x200
+	incompatEAInode     = 0x400
+	incompatDirData     = 0x1000
+	incompatCsumSeed    = 0x2000
+	incompatLargeDir    = 0x4000
+	incompatInlineData  = 0x8000
+	incompatEncrypt     = 0x10000
+	incompatCasefold    = 0x20000
+
+	// incompatSupported are the features that do not keep us from
+	// reading a file system.
+	incompatSupported = incompatFiletype | incompatRecover | incompatMetaBG |
+		incompatExtents | incompat64Bit | incompatMMP | incompatFlexBG |
+		incompatEAInode | incompatCsumSeed | incompatLargeDir |
+		incompatInlineData | incompatEncrypt | incompatCasefold
+
+	roCompatSparseSuper = 0x1
+
+	// Inode flags.
+	flagEncrypt    = 0x800
+	flagExtents    = 0x80000
+	flagInlineData = 0x10000000
+
+	extentMagic = 0xf30a
+	// maxDepth bounds the extent tree depth, which is at most 5.
+	maxDepth = 5
+)
+
+// FS is an ext2, ext3 or ext4 file system.
+type FS struct {
+	fs.FS
+
+	r              io.ReaderAt
+	blockSize      uint64
+	inodesPerGroup uint32
+	inodeSize      uint64
+	incompat       uint32
+	compat         uint32
+	// inodeTables are the first blocks of the inode tables of the groups.
+	inodeTables []uint64
+}
+
+func init() {
+	fs.Register("ext4", func(r io.ReaderAt) (fs.FS, error) { return New(r) })
+}
+
+var le = binary.LittleEndian
+
+// New reads the file system in r.
+func New(r io.ReaderAt) (*FS, error) {
+	sb := make([]byte, 1024)
+	if _, err := r.ReadAt(sb, superblockOffset); err != nil {
+		return nil, fmt.Errorf("ext4: reading superblock: %v", err)
+	}
+	if le.Uint16(sb[0x38:]) != magic {
+		return nil, fmt.Errorf("ext4: bad magic %#04x", le.Uint16(sb[0x38:]))
+	}
+	f := &FS{
+		r:              r,
+		inodesPerGroup: le.Uint32(sb[0x28:]),
+		inodeSize:      128,
+		compat:         le.Uint32(sb[0x5c:]),
+	}
+	logBlockSize := le.Uint32(sb[0x18:])
+	if logBlockSize > 6 {
+		return nil, fmt.Errorf("ext4: block size 1024<<%d is too big", logBlockSize)
+	}
+	f.blockSize = 1024 << logBlockSize
+	if le.Uint32(sb[0x4c:]) > 0 {
+		f.inodeSize = uint64(le.Uint16(sb[0x58:]))
+		f.incompat = le.Uint32(sb[0x60:])
+	}
+	if u := f.incompat &^ incompatSupported; u != 0 {
+		return nil, fmt.Errorf("ext4: unsupported incompatible features %#x", u)
+	}
+	if f.inodeSize < 128 || f.inodeSize > f.blockSize || f.inodesPerGroup == 0 {
+		return nil, fmt.Errorf("ext4: bad inode size %d or %d inodes per group", f.inodeSize, f.inodesPerGroup)
+	}
+
+	firstDataBlock := uint64(le.Uint32(sb[0x14:]))
+	blocksPerGroup := uint64(le.Uint32(sb[0x20:]))
+	blocks := uint64(le.Uint32(sb[0x04:]))
+	descSize := uint64(32)
+	if f.incompat&incompat64Bit != 0 {
+		blocks |= uint64(le.Uint32(sb[0x150:])) << 32
+		descSize = uint64(le.Uint16(sb[0xfe:]))
+		if descSize < 32 || descSize > f.blockSize {
+			return nil, fmt.Errorf("ext4: bad group descriptor size %d", descSize)
+		}
+	}
+	if blocksPerGroup == 0 || blocks <= firstDataBlock {
+		return nil, fmt.Errorf("ext4: bad geometry: %d blocks, %d per group", blocks, blocksPerGroup)
+	}
+	groups := (blocks - firstDataBlock + blocksPerGroup - 1) / blocksPerGroup
+	if n := (uint64(le.Uint32(sb[0x00:])) + uint64(f.inodesPerGroup) - 1) / uint64(f.inodesPerGroup); n < groups {
+		groups = n
+	}
+
+	sparse := le.Uint32(sb[0x64:])&roCompatSparseSuper != 0
+	firstMetaBG := uint64(le.Uint32(sb[0x104:]))
+	perBlock := f.blockSize / descSize
+	descBlock := func(i uint64) uint64 {
+		if f.incompat&incompatMetaBG == 0 || i < firstMetaBG {
+			return firstDataBlock + 1 + i
+		}
+		// With meta_bg, each group of perBlock groups has its
+		// descriptors in its first group.
+		g := i * perBlock
+		b := firstDataBlock + g*blocksPerGroup
+		if hasSuper(g, sparse) {
+			b++
+		}
+		return b
+	}
+
+	block := make([]byte, f.blockSize)
+	for g := uint64(0); g < groups; g++ {
+		if g%perBlock == 0 {
+			if err := f.readBlock(block, descBlock(g/perBlock)); err != nil {
+				return nil, err
+			}
+		}
+		d := block[g%perBlock*descSize:]
+		t := uint64(le.Uint32(d[0x08:]))
+		if descSize >= 64 {
+			t |= uint64(le.Uint32(d[0x28:])) << 32
+		}
+		f.inodeTables = append(f.inodeTables, t)
+	}
+
+	root, err := f.inode(rootIno, "/")
+	if err != nil {
+		return nil, err
+	}
+	if !root.Info().IsDir() {
+		return nil, fmt.Errorf("ext4: root inode is not a directory")
+	}
+	f.FS = fs.NewFS(root)
+	return f, nil
+}
+
+// hasSuper returns whether group g has a copy of the superblock and group
+// descriptors.
+func hasSuper(g uint64, sparse bool) bool {
+	if !sparse || g <= 1 {
+		return true
+	}
+	for _, p := range []uint64{3, 5, 7} {
+		n := p
+		for n < g {
+			n *= p
+		}
+		if n == g {
+			return true
+		}
+	}
+	return false
+}
+
+// Type returns ext2, ext3 or ext4, as the kernel would call the file
+// system.
+func (f *FS) Type() string {
+	switch {
+	case f.incompat&(incompatExtents|incompat64Bit|incompatFlexBG|incompatInlineData) != 0:
+		return "ext4"
+	case f.compat&compatHasJournal != 0:
+		return "ext3"
+	}
+	return "ext2"
+}
+
+func (f *FS) readBlock(b []byte, n uint64) error {
+	if _, err := f.r.ReadAt(b, int64(n*f.blockSize)); err != nil {
+		return fmt.Errorf("ext4: reading block %d: %v", n, err)
+	}
+	return nil
+}
+
+// inode is an inode, and the name it was found under.
+type inode struct {
+	fs     *FS
+	ino    uint32
+	name   string
+	raw    []byte
+	mode   uint16
+	size   uint64
+	mtime  uint32
+	blocks uint32
+	flags  uint32
+}
+
+func (f *FS) inode(ino uint32, name string) (*inode, error) {
+	g := uint64(ino-1) / uint64(f.inodesPerGroup)
+	if ino == 0 || g >= uint64(len(f.inodeTables)) {
+		return nil, fmt.Errorf("ext4: bad inode number %d", ino)
+	}
+	i := uint64(ino-1) % uint64(f.inodesPerGroup)
+	raw := make([]byte, f.inodeSize)
+	if _, err := f.r.ReadAt(raw, int64(f.inodeTables[g]*f.blockSize+i*f.inodeSize)); err != nil {
+		return nil, fmt.Errorf("ext4: reading inode %d: %v", ino, err)
+	}
+	n := &inode{
+		fs:     f,
+		ino:    ino,
+		name:   name,
+		raw:    raw,
+		mode:   le.Uint16(raw[0x00:]),
+		size:   uint64(le.Uint32(raw[0x04:])) | uint64(le.Uint32(raw[0x6c:]))<<32,
+		mtime:  le.Uint32(raw[0x10:]),
+		blocks: le.Uint32(raw[0x1c:]),
+		flags:  le.Uint32(raw[0x20:]),
+	}
+	return n, nil
+}
+
+func fileMode(m uint16) os.FileMode {
+	mode := os.FileMode(m & 0777)
+	if m&0x800 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&0x400 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&0x200 != 0 {
+		mode |= os.ModeSticky
+	}
+	switch m & 0xf000 {
+	case 0x4000:
+		mode |= os.ModeDir
+	case 0xa000:
+		mode |= os.ModeSymlink
+	case 0x2000:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case 0x6000:
+		mode |= os.ModeDevice
+	case 0x1000:
+		mode |= os.ModeNamedPipe
+	case 0xc000:
+		mode |= os.ModeSocket
+	}
+	return mode
+}
+
+// Info implements fs.Node.Info. Sys returns the inode number.
+func (n *inode) Info() os.FileInfo {
+	return fs.NewFileInfo(n.name, int64(n.size), fileMode(n.mode), time.Unix(int64(n.mtime), 0), n.ino)
+}
+
+// dirent is a directory entry.
+type dirent struct {
+	ino  uint32
+	name string
+}
+
+func (n *inode) entries() ([]dirent, error) {
+	d, err := n.data()
+	if err != nil {
+		return nil, err
+	}
+	var start int
+	if n.flags&flagInlineData != 0 {
+		// Inline directories start with the parent inode number.
+		start = 4
+	}
+	var ents []dirent
+	for pos := start; pos+8 <= len(d); {
+		ino := le.Uint32(d[pos:])
+		recLen := int(le.Uint16(d[pos+4:]))
+		nameLen := int(d[pos+6])
+		if n.fs.incompat&incompatFiletype == 0 {
+			nameLen = int(le.Uint16(d[pos+6:]))
+		}
+		if recLen < 8 || pos+recLen > len(d) || 8+nameLen > recLen {
+			return nil, fmt.Errorf("ext4: directory inode %d: bad entry at %d", n.ino, pos)
+		}
+		name := string(d[pos+8 : pos+8+nameLen])
+		if ino != 0 && name != "." && name != ".." {
+			ents = append(ents, dirent{ino, name})
+		}
+		pos += recLen
+	}
+	return ents, nil
+}
+
+// Lookup implements fs.Node.Lookup.
+func (n *inode) Lookup(name string) (fs.Node, error) {
+	ents, err := n.entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range ents {
+		if e.name == name {
+			return n.fs.inode(e.ino, e.name)
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// ReadDir implements fs.Node.ReadDir.
+func (n *inode) ReadDir() ([]os.FileInfo, error) {
+	ents, err := n.entries()
+	if err != nil {
+		return nil, err
+	}
+	var fis []os.FileInfo
+	for _, e := range ents {
+		c, err := n.fs.inode(e.ino, e.name)
+		if err != nil {
+			return nil, err
+		}
+		fis = append(fis, c.Info())
+	}
+	return fis, nil
+}
+
+// Readlink implements fs.Node.Readlink.
+func (n *inode) Readlink() (string, error) {
+	if n.mode&0xf000 != 0xa000 {
+		return "", syscall.EINVAL
+	}
+	// Short targets are in the block map of fast symbolic links.
+	if n.flags&(flagInlineData|flagExtents) == 0 && n.blocks == 0 && n.size < 60 {
+		return string(n.raw[0x28 : 0x28+n.size]), nil
+	}
+	d, err := n.data()
+	if err != nil {
+		return "", err
+	}
+	return string(d), nil
+}
+
+// Open implements fs.Node.Open.
+func (n *inode) Open() (fs.File, error) {
+	if n.flags&flagEncrypt != 0 {
+		return nil, fmt.Errorf("ext4: inode %d is encrypted", n.ino)
+	}
+	if n.flags&flagInlineData != 0 {
+		d, err := n.inlineData()
+		if err != nil {
+			return nil, err
+		}
+		return fs.NewFile(bytes.NewReader(d), n.Info()), nil
+	}
+	exts, err := n.extents()
+	if err != nil {
+		return nil, err
+	}
+	return fs.NewFile(&reader{fs: n.fs, exts: exts}, n.Info()), nil
+}
+
+// data returns all of the contents of the inode.
+func (n *inode) data() ([]byte, error) {
+	if n.flags&flagEncrypt != 0 {
+		return nil, fmt.Errorf("ext4: inode %d is encrypted", n.ino)
+	}
+	if n.flags&flagInlineData != 0 {
+		return n.inlineData()
+	}
+	if n.size > 1<<30 {
+		return nil, fmt.Errorf("ext4: inode %d is too big to read at once", n.ino)
+	}
+	exts, err := n.extents()
+	if err != nil {
+		return nil, err
+	}
+	d := make([]byte, n.size)
+	if _, err := (&reader{fs: n.fs, exts: exts}).ReadAt(d, 0); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// inlineData returns the contents of an inode with inline data: the block
+// map, followed by the system.data extended attribute.
+func (n *inode) inlineData() ([]byte, error) {
+	d := append([]byte(nil), n.raw[0x28:0x28+60]...)
+	if n.size > uint64(len(d)) {
+		d = append(d, n.xattr(7, "data")...)
+	}
+	if n.size > uint64(len(d)) {
+		return nil, fmt.Errorf("ext4: inode %d: %d bytes of inline data, want %d", n.ino, len(d), n.size)
+	}
+	return d[:n.size], nil
+}
+
+// xattr returns an extended attribute stored in the inode.
+func (n *inode) xattr(index byte, name string) []byte {
+	if len(n.raw) < 0x84 {
+		return nil
+	}
+	start := 128 + int(le.Uint16(n.raw[0x80:]))
+	if start+4 > len(n.raw) || le.Uint32(n.raw[start:]) != 0xea020000 {
+		return nil
+	}
+	first := start + 4
+	for pos := first; pos+16 <= len(n.raw); {
+		nameLen := int(n.raw[pos])
+		if nameLen == 0 && n.raw[pos+1] == 0 {
+			break
+		}
+		off, size := int(le.Uint16(n.raw[pos+2:])), int(le.Uint32(n.raw[pos+8:]))
+		if pos+16+nameLen > len(n.raw) {
+			break
+		}
+		if n.raw[pos+1] == index && string(n.raw[pos+16:pos+16+nameLen]) == name {
+			if first+off+size > len(n.raw) {
+				return nil
+			}
+			return n.raw[first+off : first+off+size]
+		}
+		pos += (16 + nameLen + 3) &^ 3
+	}
+	return nil
+}
+
+// extent maps length blocks of a file, starting at block logical, to the
+// disk, starting at block physical. Uninitialized extents read as zeros.
+type extent struct {
+	logical  uint64
+	physical uint64
+	length   uint64
+	uninit   bool
+}
+
+// extents returns the block map of the inode.
+func (n *inode) extents() ([]extent, error) {
+	var exts []extent
+	var err error
+	if n.flags&flagExtents != 0 {
+		err = n.fs.extentTree(&exts, n.raw[0x28:0x28+60], maxDepth)
+	} else {
+		err = n.fs.blockMap(&exts, n.raw[0x28:0x28+60], (n.size+n.fs.blockSize-1)/n.fs.blockSize)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ext4: inode %d: %v", n.ino, err)
+	}
+	sort.Slice(exts, func(i, j int) bool { return exts[i].logical < exts[j].logical })
+	return exts, nil
+}
+
+func (f *FS) extentTree(exts *[]extent, node []byte, depth int) error {
+	if len(node) < 12 || le.Uint16(node) != extentMagic {
+		return fmt.Errorf("bad extent header")
+	}
+	entries, d := int(le.Uint16(node[2:])), int(le.Uint16(node[6:]))
+	if d >= depth || 12+entries*12 > len(node) {
+		return fmt.Errorf("bad extent node with depth %d and %d entries", d, entries)
+	}
+	for i := 0; i < entries; i++ {
+		e := node[12+i*12:]
+		if d == 0 {
+			length := uint64(le.Uint16(e[4:]))
+			uninit := length > 32768
+			if uninit {
+				length -= 32768
+			}
+			*exts = append(*exts, extent{
+				logical:  uint64(le.Uint32(e[0:])),
+				physical: uint64(le.Uint16(e[6:]))<<32 | uint64(le.Uint32(e[8:])),
+				length:   length,
+				uninit:   uninit,
+			})
+			continue
+		}
+		leaf := uint64(le.Uint16(e[8:]))<<32 | uint64(le.Uint32(e[4:]))
+		b := make([]byte, f.blockSize)
+		if err := f.readBlock(b, leaf); err != nil {
+			return err
+		}
+		if err := f.extentTree(exts, b, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockMap reads the direct and indirect block map of ext2 and ext3.
+func (f *FS) blockMap(exts *[]extent, iblock []byte, nblocks uint64) error {
+	var logical uint64
+	add := func(phys uint64) {
+		if phys != 0 {
+			if l := len(*exts); l > 0 {
+				e := &(*exts)[l-1]
+				if e.logical+e.length == logical && e.physical+e.length == phys {
+					e.length++
+					logical++
+					return
+				}
+			}
+			*exts = append(*exts, extent{logical: logical, physical: phys, length: 1})
+		}
+		logical++
+	}
+	perBlock := f.blockSize / 4
+	var indirect func(b uint64, level int) error
+	indirect = func(b uint64, level int) error {
+		if b == 0 {
+			n := perBlock
+			for i := 1; i < level; i++ {
+				n *= perBlock
+			}
+			logical += n
+			return nil
+		}
+		d := make([]byte, f.blockSize)
+		if err := f.readBlock(d, b); err != nil {
+			return err
+		}
+		for i := uint64(0); i < perBlock && logical < nblocks; i++ {
+			p := uint64(le.Uint32(d[i*4:]))
+			if level == 1 {
+				add(p)
+			} else if err := indirect(p, level-1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := 0; i < 12 && logical < nblocks; i++ {
+		add(uint64(le.Uint32(iblock[i*4:])))
+	}
+	for level := 1; level <= 3 && logical < nblocks; level++ {
+		if err := indirect(uint64(le.Uint32(iblock[(11+level)*4:])), level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reader reads a file through its extents.
+type reader struct {
+	fs   *FS
+	exts []extent
+}
+
+// ReadAt implements io.ReaderAt. Holes read as zeros.
+func (r *reader) ReadAt(p []byte, off int64) (int, error) {
+	bs := r.fs.blockSize
+	n := 0
+	for n < len(p) {
+		pos := uint64(off) + uint64(n)
+		blk, in := pos/bs, pos%bs
+		i := sort.Search(len(r.exts), func(i int) bool { return r.exts[i].logical+r.exts[i].length > blk })
+		if i == len(r.exts) || r.exts[i].logical > blk || r.exts[i].uninit {
+			// A hole, up to the next extent.
+			end := uint64(len(p) - n)
+			if i < len(r.exts) && r.exts[i].logical > blk {
+				if e := r.exts[i].logical*bs - pos; e < end {
+					end = e
+				}
+			} else if i < len(r.exts) {
+				if e := (r.exts[i].logical+r.exts[i].length)*bs - pos; e < end {
+					end = e
+				}
+			}
+			for j := uint64(0); j < end; j++ {
+				p[n] = 0
+				n++
+			}
+			continue
+		}
+		e := r.exts[i]
+		end := (e.logical+e.length)*bs - pos
+		if end > uint64(len(p)-n) {
+			end = uint64(len(p) - n)
+		}
+		m, err := r.fs.r.ReadAt(p[n:n+int(end)], int64((e.physical+blk-e.logical)*bs+in))
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
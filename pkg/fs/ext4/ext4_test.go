@@ -0,0 +1,162 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ext4
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/fs"
+)
+
+// image returns a test image made by testdata/mkimages.sh.
+func image(t *testing.T, name string) *bytes.Reader {
+	f, err := os.Open("testdata/" + name + ".img.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(b)
+}
+
+func kernel() []byte {
+	b := make([]byte, 300000)
+	for i := range b {
+		b[i] = byte(i * 7 % 251)
+	}
+	return b
+}
+
+func TestImages(t *testing.T) {
+	grub := "menuentry \"Linux\" {\n\tlinux /vmlinuz root=/dev/sda1\n}\n"
+	sparse := make([]byte, 1<<20)
+	copy(sparse[len(sparse)-3:], "end")
+	files := map[string]string{
+		"/boot/grub/grub.cfg": grub,
+		"boot/vmlinuz-4.14":   string(kernel()),
+		"vmlinuz":             string(kernel()),
+		"dir/sub/long-link-to-grub-cfg-that-does-not-fit-into-the-inode": grub,
+		"dir/small":  "small",
+		"dir/medium": strings.Repeat("0", 99) + "7",
+		"empty":      "",
+		"sparse":     string(sparse),
+	}
+
+	for _, tt := range []struct {
+		name string
+		typ  string
+	}{
+		{"ext2", "ext2"},
+		{"ext3", "ext3"},
+		{"ext4", "ext4"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := New(image(t, tt.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ := f.Type(); typ != tt.typ {
+				t.Errorf("Type() = %q, want %q", typ, tt.typ)
+			}
+			for name, want := range files {
+				got, err := fs.ReadFile(f, name)
+				if err != nil {
+					t.Errorf("ReadFile(%q): %v", name, err)
+					continue
+				}
+				if string(got) != want {
+					t.Errorf("ReadFile(%q) returned %d different bytes, want %d", name, len(got), len(want))
+				}
+			}
+
+			fis, err := f.ReadDir("/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, fi := range fis {
+				names = append(names, fi.Name())
+			}
+			if n := strings.Join(names, " "); n != "boot dir empty loop1 loop2 lost+found sparse vmlinuz" {
+				t.Errorf("ReadDir(/) = %s", n)
+			}
+			if fis[len(fis)-1].Mode()&os.ModeSymlink == 0 {
+				t.Errorf("ReadDir(/) returned mode %v for vmlinuz, want a symbolic link", fis[len(fis)-1].Mode())
+			}
+
+			fi, err := f.Stat("boot/grub/grub.cfg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Size() != int64(len(grub)) || fi.Mode() != 0644 || fi.ModTime().Unix() != 1500000000 {
+				t.Errorf("Stat(boot/grub/grub.cfg) = %d bytes, mode %v, time %v", fi.Size(), fi.Mode(), fi.ModTime())
+			}
+			if fi, err := f.Stat("vmlinuz"); err != nil || !fi.Mode().IsRegular() || fi.Name() != "vmlinuz-4.14" {
+				t.Errorf("Stat(vmlinuz) = %v, %v, want vmlinuz-4.14", fi, err)
+			}
+
+			for _, name := range []string{"nothere", "boot/nothere", "empty/x"} {
+				if _, err := f.Open(name); err == nil {
+					t.Errorf("Open(%q): got nil, want error", name)
+				}
+			}
+			if _, err := f.Open("nothere"); !os.IsNotExist(err) {
+				t.Errorf("Open(nothere): got %v, want a does not exist error", err)
+			}
+			if _, err := f.Open("loop1"); err == nil || !strings.Contains(err.Error(), "too many levels") {
+				t.Errorf("Open(loop1): got %v, want too many levels of symbolic links", err)
+			}
+			if _, err := f.Open("boot"); err == nil {
+				t.Errorf("Open(boot): got nil, want error")
+			}
+
+			// A read across blocks and extents.
+			k, err := f.Open("boot/vmlinuz-4.14")
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := make([]byte, 10000)
+			if _, err := k.ReadAt(b, 250000); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, kernel()[250000:260000]) {
+				t.Errorf("ReadAt(10000 bytes at 250000) returned different bytes")
+			}
+		})
+	}
+}
+
+// TestMetaBG checks that the descriptors of the second meta group are
+// found.
+func TestMetaBG(t *testing.T) {
+	f, err := New(image(t, "ext3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.inodeTables) != 64 || f.inodeTables[32] != 8196 {
+		t.Errorf("Got %d groups, inode table of group 32 at %d, want 64 and 8196", len(f.inodeTables), f.inodeTables[32])
+	}
+}
+
+func TestNotExt(t *testing.T) {
+	if _, err := New(bytes.NewReader(make([]byte, 4096))); err == nil {
+		t.Errorf("New(zeros): got nil, want error")
+	}
+	if _, typ, err := fs.Probe(image(t, "ext4")); err != nil || typ != "ext4" {
+		t.Errorf("Probe(ext4 image) = %q, %v, want ext4", typ, err)
+	}
+}
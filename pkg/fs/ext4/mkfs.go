@@ -0,0 +1,377 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ext4
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Features of the file systems Format makes: extents and file types in
+// directories, but no journal, flex_bg or checksums.
+const (
+	compatExtAttr  = 0x8
+	compatDirIndex = 0x20
+
+	roCompatLargeFile  = 0x2
+	roCompatHugeFile   = 0x8
+	roCompatDirNlink   = 0x20
+	roCompatExtraIsize = 0x40
+
+	lostFoundIno = 11
+	inodeSize    = 256
+	descSize     = 32
+	// minGroupBlocks are the blocks a group needs besides its metadata
+	// to be worth having.
+	minGroupBlocks = 50
+)
+
+// FormatOptions are the options of Format. The zero value picks defaults
+// that suit the size of the file system.
+type FormatOptions struct {
+	// BlockSize is 1024, 2048 or 4096. The default is 1024 below 512 MiB
+	// and 4096 above.
+	BlockSize int
+	// BytesPerInode is the ratio of bytes to inodes. The default is
+	// 4096 below 512 MiB and 16384 above.
+	BytesPerInode int
+	// Label is the volume label, at most 16 bytes.
+	Label string
+	// UUID is the file system UUID. The default is a random one.
+	UUID [16]byte
+	// Time is the creation time. The default is now.
+	Time time.Time
+}
+
+// layout is the geometry of a file system.
+type layout struct {
+	blockSize      uint64
+	firstDataBlock uint64
+	blocks         uint64
+	blocksPerGroup uint64
+	groups         uint64
+	inodesPerGroup uint64
+	itableBlocks   uint64
+	gdtBlocks      uint64
+}
+
+// groupStart returns the first block of group g.
+func (l *layout) groupStart(g uint64) uint64 {
+	return l.firstDataBlock + g*l.blocksPerGroup
+}
+
+// groupBlocks returns the number of blocks in group g.
+func (l *layout) groupBlocks(g uint64) uint64 {
+	if g == l.groups-1 {
+		return l.blocks - l.groupStart(g)
+	}
+	return l.blocksPerGroup
+}
+
+// metaBlocks returns the number of blocks the superblock, group
+// descriptors, bitmaps and inode table take at the start of group g.
+func (l *layout) metaBlocks(g uint64) uint64 {
+	n := 2 + l.itableBlocks
+	if hasSuper(g, true) {
+		n += 1 + l.gdtBlocks
+	}
+	return n
+}
+
+func newLayout(size int64, o *FormatOptions) (*layout, error) {
+	bs, ratio := uint64(o.BlockSize), uint64(o.BytesPerInode)
+	if bs == 0 {
+		bs = 4096
+		if size < 512<<20 {
+			bs = 1024
+		}
+	}
+	if ratio == 0 {
+		ratio = 16384
+		if size < 512<<20 {
+			ratio = 4096
+		}
+	}
+	if bs != 1024 && bs != 2048 && bs != 4096 {
+		return nil, fmt.Errorf("ext4: block size %d is not 1024, 2048 or 4096", bs)
+	}
+	if ratio < bs {
+		return nil, fmt.Errorf("ext4: %d bytes per inode is less than the block size %d", ratio, bs)
+	}
+	l := &layout{
+		blockSize:      bs,
+		blocks:         uint64(size) / bs,
+		blocksPerGroup: 8 * bs,
+	}
+	if l.blocks > 1<<32-1 {
+		return nil, fmt.Errorf("ext4: %d blocks do not fit in 32 bits", l.blocks)
+	}
+	if bs == 1024 {
+		l.firstDataBlock = 1
+	}
+	if l.blocks <= l.firstDataBlock {
+		return nil, fmt.Errorf("ext4: %d bytes are too small for a file system", size)
+	}
+	l.groups = (l.blocks - l.firstDataBlock + l.blocksPerGroup - 1) / l.blocksPerGroup
+
+	perBlock := bs / inodeSize
+	ipg := (l.blocks*bs/ratio + l.groups - 1) / l.groups
+	if ipg < 16 {
+		ipg = 16
+	}
+	// Inode tables fill whole blocks, inode bitmaps whole bytes.
+	align := perBlock
+	if align < 8 {
+		align = 8
+	}
+	ipg = (ipg + align - 1) / align * align
+	if ipg > 8*bs {
+		ipg = 8 * bs
+	}
+	l.inodesPerGroup = ipg
+	l.itableBlocks = ipg / perBlock
+
+	for {
+		l.gdtBlocks = (l.groups*descSize + bs - 1) / bs
+		last := l.groups - 1
+		if l.groupBlocks(last) >= l.metaBlocks(last)+minGroupBlocks {
+			break
+		}
+		// Drop a last group too small for its metadata.
+		l.groups--
+		if l.groups == 0 {
+			return nil, fmt.Errorf("ext4: %d bytes are too small for a file system", size)
+		}
+		l.blocks = l.groupStart(l.groups)
+	}
+	return l, nil
+}
+
+// setBits sets bits [from, to) of bitmap b.
+func setBits(b []byte, from, to uint64) {
+	for i := from; i < to; i++ {
+		b[i/8] |= 1 << (i % 8)
+	}
+}
+
+// writeZeros writes n zero bytes at off.
+func writeZeros(w io.WriterAt, off, n int64) error {
+	zero := make([]byte, 64<<10)
+	for n > 0 {
+		c := int64(len(zero))
+		if c > n {
+			c = n
+		}
+		if _, err := w.WriteAt(zero[:c], off); err != nil {
+			return err
+		}
+		off += c
+		n -= c
+	}
+	return nil
+}
+
+// dirBlock returns a directory block with entries for ino, named by
+// names.
+func dirBlock(bs uint64, names []string, inos []uint32) []byte {
+	b := make([]byte, bs)
+	off := 0
+	for i, name := range names {
+		recLen := (8 + len(name) + 3) &^ 3
+		if i == len(names)-1 {
+			recLen = len(b) - off
+		}
+		le.PutUint32(b[off:], inos[i])
+		le.PutUint16(b[off+4:], uint16(recLen))
+		b[off+6] = byte(len(name))
+		// The file type is a directory.
+		b[off+7] = 2
+		copy(b[off+8:], name)
+		off += recLen
+	}
+	return b
+}
+
+// dirInode returns a directory inode with mode perm, links links and its
+// one data block at block.
+func dirInode(bs uint64, perm, links uint16, block uint64, t uint32) []byte {
+	b := make([]byte, inodeSize)
+	le.PutUint16(b[0x00:], 0x4000|perm)
+	le.PutUint32(b[0x04:], uint32(bs))
+	le.PutUint32(b[0x08:], t)
+	le.PutUint32(b[0x0c:], t)
+	le.PutUint32(b[0x10:], t)
+	le.PutUint16(b[0x1a:], links)
+	le.PutUint32(b[0x1c:], uint32(bs/512))
+	le.PutUint32(b[0x20:], flagExtents)
+	// An extent tree with one extent of one block.
+	e := b[0x28:]
+	le.PutUint16(e[0:], extentMagic)
+	le.PutUint16(e[2:], 1)
+	le.PutUint16(e[4:], 4)
+	le.PutUint32(e[12:], 0)
+	le.PutUint16(e[16:], 1)
+	le.PutUint16(e[18:], uint16(block>>32))
+	le.PutUint32(e[20:], uint32(block))
+	le.PutUint16(b[0x80:], 32)
+	return b
+}
+
+// Format writes an empty ext4 file system of size bytes to w. It has a
+// root directory and lost+found, and no journal.
+func Format(w io.WriterAt, size int64, o *FormatOptions) error {
+	if o == nil {
+		o = &FormatOptions{}
+	}
+	if len(o.Label) > 16 {
+		return fmt.Errorf("ext4: label %q is longer than 16 bytes", o.Label)
+	}
+	l, err := newLayout(size, o)
+	if err != nil {
+		return err
+	}
+	bs := l.blockSize
+	now := o.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	t := uint32(now.Unix())
+	id := o.UUID
+	if id == [16]byte{} {
+		if _, err := rand.Read(id[:]); err != nil {
+			return err
+		}
+		id[6] = id[6]&0x0f | 0x40
+		id[8] = id[8]&0x3f | 0x80
+	}
+	var seed [16]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return err
+	}
+
+	// Wipe the signatures of whatever was there before.
+	wipe := int64(64 << 10)
+	if wipe > size {
+		wipe = size
+	}
+	if err := writeZeros(w, 0, wipe); err != nil {
+		return err
+	}
+
+	var freeBlocks, freeInodes uint64
+	gdt := make([]byte, l.gdtBlocks*bs)
+	for g := uint64(0); g < l.groups; g++ {
+		start, n := l.groupStart(g), l.groupBlocks(g)
+		meta := start + l.metaBlocks(g)
+		bbitmap, ibitmap, itable := meta-l.itableBlocks-2, meta-l.itableBlocks-1, meta-l.itableBlocks
+		used := meta - start
+		usedInodes := uint64(0)
+		dirs := uint16(0)
+		if g == 0 {
+			// The root directory and lost+found.
+			root, lf := meta, meta+1
+			used += 2
+			usedInodes = lostFoundIno
+			dirs = 2
+			if _, err := w.WriteAt(dirBlock(bs, []string{".", "..", "lost+found"}, []uint32{rootIno, rootIno, lostFoundIno}), int64(root*bs)); err != nil {
+				return err
+			}
+			if _, err := w.WriteAt(dirBlock(bs, []string{".", ".."}, []uint32{lostFoundIno, rootIno}), int64(lf*bs)); err != nil {
+				return err
+			}
+		}
+
+		bb := make([]byte, bs)
+		setBits(bb, 0, used)
+		setBits(bb, n, 8*bs)
+		if _, err := w.WriteAt(bb, int64(bbitmap*bs)); err != nil {
+			return err
+		}
+		ib := make([]byte, bs)
+		setBits(ib, 0, usedInodes)
+		setBits(ib, l.inodesPerGroup, 8*bs)
+		if _, err := w.WriteAt(ib, int64(ibitmap*bs)); err != nil {
+			return err
+		}
+		if err := writeZeros(w, int64(itable*bs), int64(l.itableBlocks*bs)); err != nil {
+			return err
+		}
+		if g == 0 {
+			if _, err := w.WriteAt(dirInode(bs, 0755, 3, meta, t), int64(itable*bs+(rootIno-1)*inodeSize)); err != nil {
+				return err
+			}
+			if _, err := w.WriteAt(dirInode(bs, 0700, 2, meta+1, t), int64(itable*bs+(lostFoundIno-1)*inodeSize)); err != nil {
+				return err
+			}
+		}
+
+		d := gdt[g*descSize:]
+		le.PutUint32(d[0x00:], uint32(bbitmap))
+		le.PutUint32(d[0x04:], uint32(ibitmap))
+		le.PutUint32(d[0x08:], uint32(itable))
+		le.PutUint16(d[0x0c:], uint16(n-used))
+		le.PutUint16(d[0x0e:], uint16(l.inodesPerGroup-usedInodes))
+		le.PutUint16(d[0x10:], dirs)
+		freeBlocks += n - used
+		freeInodes += l.inodesPerGroup - usedInodes
+	}
+
+	sb := make([]byte, 1024)
+	le.PutUint32(sb[0x00:], uint32(l.groups*l.inodesPerGroup))
+	le.PutUint32(sb[0x04:], uint32(l.blocks))
+	le.PutUint32(sb[0x08:], uint32(l.blocks/20))
+	le.PutUint32(sb[0x0c:], uint32(freeBlocks))
+	le.PutUint32(sb[0x10:], uint32(freeInodes))
+	le.PutUint32(sb[0x14:], uint32(l.firstDataBlock))
+	// The block and cluster size are 1024<<n.
+	le.PutUint32(sb[0x18:], uint32(bs>>11))
+	le.PutUint32(sb[0x1c:], uint32(bs>>11))
+	le.PutUint32(sb[0x20:], uint32(l.blocksPerGroup))
+	le.PutUint32(sb[0x24:], uint32(l.blocksPerGroup))
+	le.PutUint32(sb[0x28:], uint32(l.inodesPerGroup))
+	le.PutUint32(sb[0x30:], t)
+	le.PutUint16(sb[0x36:], 0xffff)
+	le.PutUint16(sb[0x38:], magic)
+	// Clean, and continue on errors.
+	le.PutUint16(sb[0x3a:], 1)
+	le.PutUint16(sb[0x3c:], 1)
+	le.PutUint32(sb[0x40:], t)
+	le.PutUint32(sb[0x4c:], 1)
+	le.PutUint32(sb[0x54:], lostFoundIno)
+	le.PutUint16(sb[0x58:], inodeSize)
+	le.PutUint32(sb[0x5c:], compatExtAttr|compatDirIndex)
+	le.PutUint32(sb[0x60:], incompatFiletype|incompatExtents)
+	le.PutUint32(sb[0x64:], roCompatSparseSuper|roCompatLargeFile|roCompatHugeFile|roCompatDirNlink|roCompatExtraIsize)
+	copy(sb[0x68:], id[:])
+	copy(sb[0x78:], o.Label)
+	copy(sb[0xec:], seed[:])
+	// Half MD4 directory hashes of signed chars.
+	sb[0xfc] = 1
+	le.PutUint32(sb[0x108:], t)
+	le.PutUint16(sb[0x15c:], 32)
+	le.PutUint16(sb[0x15e:], 32)
+	le.PutUint32(sb[0x160:], 1)
+
+	for g := uint64(0); g < l.groups; g++ {
+		if !hasSuper(g, true) {
+			continue
+		}
+		start := l.groupStart(g)
+		off := int64(start * bs)
+		if g == 0 {
+			off = superblockOffset
+		}
+		le.PutUint16(sb[0x5a:], uint16(g))
+		if _, err := w.WriteAt(sb, off); err != nil {
+			return err
+		}
+		if _, err := w.WriteAt(gdt, int64((start+1)*bs)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ext4
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ext4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	uuid := [16]byte{0x5b, 0xd1, 0xc8, 0xf5, 0x7b, 0x1c, 0x4a, 0x8e, 0x9f, 0x5e, 0x0e, 0x3f, 0x6a, 0x8e, 0x4b, 0x2d}
+
+	for _, tt := range []struct {
+		name string
+		size int64
+		opts FormatOptions
+	}{
+		{name: "1M", size: 1 << 20},
+		// The last group of 300K is too small and dropped.
+		{name: "runt", size: 8<<20 + 300<<10},
+		{name: "2K", size: 64 << 20, opts: FormatOptions{BlockSize: 2048}},
+		{name: "4K", size: 100<<20 + 7, opts: FormatOptions{BlockSize: 4096, BytesPerInode: 8192}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Label, tt.opts.UUID = "root", uuid
+			name := filepath.Join(dir, tt.name+".img")
+			f, err := os.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if err := f.Truncate(tt.size); err != nil {
+				t.Fatal(err)
+			}
+			if err := Format(f, tt.size, &tt.opts); err != nil {
+				t.Fatal(err)
+			}
+
+			fsys, err := New(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ := fsys.Type(); typ != "ext4" {
+				t.Errorf("Type() = %q, want ext4", typ)
+			}
+			fi, err := fsys.ReadDir("/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fi) != 1 || fi[0].Name() != "lost+found" || !fi[0].IsDir() {
+				t.Errorf("ReadDir(/) = %v, want lost+found", fi)
+			}
+			sb := make([]byte, 1024)
+			if _, err := f.ReadAt(sb, superblockOffset); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(sb[0x68:0x78], uuid[:]) || string(sb[0x78:0x7c]) != "root" {
+				t.Errorf("UUID and label are %x %q, want %x root", sb[0x68:0x78], sb[0x78:0x88], uuid)
+			}
+
+			if _, err := exec.LookPath("e2fsck"); err != nil {
+				t.Skip("no e2fsck")
+			}
+			if out, err := exec.Command("e2fsck", "-fn", name).CombinedOutput(); err != nil {
+				t.Errorf("e2fsck: %v\n%s", err, out)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		size int64
+		opts FormatOptions
+	}{
+		{size: 32 << 10},
+		{size: 1 << 20, opts: FormatOptions{BlockSize: 8192}},
+		{size: 1 << 20, opts: FormatOptions{BytesPerInode: 512}},
+		{size: 1 << 20, opts: FormatOptions{Label: "a label that is too long"}},
+	} {
+		if err := Format(nil, tt.size, &tt.opts); err == nil {
+			t.Errorf("Format(%d bytes, %+v): got nil, want error", tt.size, tt.opts)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+#!/bin/sh
+# Builds the test images. The file contents are what TestImages expects.
+set -e
+root=$(mktemp -d)
+trap 'rm -rf "$root"' EXIT
+mkdir -p "$root/boot/grub" "$root/dir/sub"
+printf 'menuentry "Linux" {\n\tlinux /vmlinuz root=/dev/sda1\n}\n' > "$root/boot/grub/grub.cfg"
+python3 -c 'import sys; sys.stdout.buffer.write(bytes(i * 7 % 251 for i in range(300000)))' > "$root/boot/vmlinuz-4.14"
+ln -s boot/vmlinuz-4.14 "$root/vmlinuz"
+ln -s ../../../boot/./vmlinuz-4.14/../grub/../../boot/grub/grub.cfg "$root/dir/sub/long-link-to-grub-cfg-that-does-not-fit-into-the-inode"
+ln -s loop2 "$root/loop1"
+ln -s loop1 "$root/loop2"
+: > "$root/empty"
+printf small > "$root/dir/small"
+printf '%0100d' 7 > "$root/dir/medium"
+truncate -s 1M "$root/sparse"
+printf end | dd of="$root/sparse" bs=1 seek=1048573 conv=notrunc 2>/dev/null
+touch -d @1500000000 "$root/boot/grub/grub.cfg"
+
+mk() {
+	name=$1
+	shift
+	rm -f "$name.img" "$name.img.gz"
+	mke2fs -q -F -E root_owner=0:0 -U 5bd1c8f5-7b1c-4a8e-9f5e-0e3f6a8e4b2d -L "$name" -d "$root" "$@" "$name.img" 16M
+	gzip -9n "$name.img"
+}
+mk ext2 -t ext2 -b 1024
+mk ext3 -t ext3 -b 1024 -g 256 -O meta_bg,^resize_inode
+mk ext4 -t ext4 -b 4096 -O inline_data,64bit,metadata_csum
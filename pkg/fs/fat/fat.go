@@ -0,0 +1,490 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package fat reads FAT12, FAT16 and FAT32 file systems, with long file
+// names.
+//
+// Names are looked up case-insensitively, as FAT does.
+package fat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf16"
+
+	"github.com/u-root/u-root/pkg/fs"
+)
+
+// Type is the FAT variant, which depends on the number of clusters.
+type Type int
+
+const (
+	FAT12 Type = 12
+	FAT16 Type = 16
+	FAT32 Type = 32
+)
+
+const (
+	dirEntrySize = 32
+
+	attrReadOnly  = 0x01
+	attrHidden    = 0x02
+	attrSystem    = 0x04
+	attrVolumeID  = 0x08
+	attrDirectory = 0x10
+	attrLongName  = 0x0f
+
+	// Lower case flags of short names, used by Windows NT and Linux.
+	lowerBase = 0x08
+	lowerExt  = 0x10
+
+	lastLongEntry = 0x40
+	deleted       = 0xe5
+)
+
+// FS is a FAT file system.
+type FS struct {
+	fs.FS
+
+	r           io.ReaderAt
+	typ         Type
+	clusterSize int64
+	// fat is the offset of the first FAT.
+	fat int64
+	// rootDir and rootSize are the offset and size of the FAT12 and
+	// FAT16 root directory.
+	rootDir, rootSize int64
+	// data is the offset of cluster 2.
+	data     int64
+	clusters uint32
+	rootClus uint32
+	label    string
+	serial   uint32
+}
+
+func init() {
+	fs.Register("vfat", func(r io.ReaderAt) (fs.FS, error) { return New(r) })
+}
+
+var le = binary.LittleEndian
+
+// New reads the file system in r.
+func New(r io.ReaderAt) (*FS, error) {
+	b := make([]byte, 512)
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return nil, fmt.Errorf("fat: reading boot sector: %v", err)
+	}
+	if b[510] != 0x55 || b[511] != 0xaa || (b[0] != 0xeb && b[0] != 0xe9) {
+		return nil, errors.New("fat: no boot sector signature")
+	}
+	var (
+		bytesPerSec = int64(le.Uint16(b[0x0b:]))
+		secPerClus  = int64(b[0x0d])
+		reserved    = int64(le.Uint16(b[0x0e:]))
+		numFATs     = int64(b[0x10])
+		rootEntries = int64(le.Uint16(b[0x11:]))
+		totSec      = int64(le.Uint16(b[0x13:]))
+		fatSize     = int64(le.Uint16(b[0x16:]))
+	)
+	if bytesPerSec < 512 || bytesPerSec > 4096 || bytesPerSec&(bytesPerSec-1) != 0 ||
+		secPerClus == 0 || secPerClus&(secPerClus-1) != 0 || reserved == 0 || numFATs == 0 {
+		return nil, errors.New("fat: bad BIOS parameter block")
+	}
+	if totSec == 0 {
+		totSec = int64(le.Uint32(b[0x20:]))
+	}
+	if fatSize == 0 {
+		fatSize = int64(le.Uint32(b[0x24:]))
+	}
+	rootSecs := (rootEntries*dirEntrySize + bytesPerSec - 1) / bytesPerSec
+	dataSec := reserved + numFATs*fatSize + rootSecs
+	if totSec <= dataSec {
+		return nil, errors.New("fat: no data sectors")
+	}
+
+	f := &FS{
+		r:           r,
+		clusterSize: secPerClus * bytesPerSec,
+		fat:         reserved * bytesPerSec,
+		rootDir:     (reserved + numFATs*fatSize) * bytesPerSec,
+		rootSize:    rootSecs * bytesPerSec,
+		data:        dataSec * bytesPerSec,
+		clusters:    uint32((totSec - dataSec) / secPerClus),
+	}
+	// The number of clusters alone determines the type.
+	ext := b[0x24:]
+	switch {
+	case f.clusters < 4085:
+		f.typ = FAT12
+	case f.clusters < 65525:
+		f.typ = FAT16
+	default:
+		f.typ = FAT32
+		f.rootClus = le.Uint32(b[0x2c:])
+		ext = b[0x40:]
+	}
+	// The extended boot signature says whether there is a serial number
+	// and label.
+	if ext[2] == 0x29 {
+		f.serial = le.Uint32(ext[3:])
+		f.label = strings.TrimRight(string(ext[7:18]), " ")
+	}
+	if f.label == "NO NAME" {
+		f.label = ""
+	}
+
+	root := &node{fs: f, name: "/", attr: attrDirectory, cluster: f.rootClus}
+	// The volume label in the root directory takes precedence.
+	ents, err := root.entries(true)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range ents {
+		if e.attr&attrVolumeID != 0 {
+			f.label = e.name
+		}
+	}
+	f.FS = fs.NewFS(root)
+	return f, nil
+}
+
+// Type returns the FAT variant.
+func (f *FS) Type() Type {
+	return f.typ
+}
+
+// Label returns the volume label.
+func (f *FS) Label() string {
+	return f.label
+}
+
+// Serial returns the volume serial number.
+func (f *FS) Serial() uint32 {
+	return f.serial
+}
+
+// next returns the cluster after c in the FAT, and whether c was the last
+// one.
+func (f *FS) next(c uint32) (uint32, bool, error) {
+	var b [4]byte
+	var off int64
+	switch f.typ {
+	case FAT12:
+		off = int64(c + c/2)
+	case FAT16:
+		off = int64(c) * 2
+	default:
+		off = int64(c) * 4
+	}
+	if _, err := f.r.ReadAt(b[:], f.fat+off); err != nil && err != io.EOF {
+		return 0, false, fmt.Errorf("fat: reading FAT entry %d: %v", c, err)
+	}
+	var n, eoc uint32
+	switch f.typ {
+	case FAT12:
+		n = uint32(le.Uint16(b[:]))
+		if c&1 != 0 {
+			n >>= 4
+		}
+		n &= 0xfff
+		eoc = 0xff8
+	case FAT16:
+		n, eoc = uint32(le.Uint16(b[:])), 0xfff8
+	default:
+		n, eoc = le.Uint32(b[:])&0x0fffffff, 0x0ffffff8
+	}
+	if n >= eoc {
+		return 0, true, nil
+	}
+	if n < 2 || n >= f.clusters+2 {
+		return 0, false, fmt.Errorf("fat: bad cluster %#x after cluster %d", n, c)
+	}
+	return n, false, nil
+}
+
+// chain returns the clusters of a file starting at cluster c.
+func (f *FS) chain(c uint32) ([]uint32, error) {
+	var cs []uint32
+	for c != 0 {
+		if c < 2 || c >= f.clusters+2 {
+			return nil, fmt.Errorf("fat: bad cluster %#x", c)
+		}
+		if uint32(len(cs)) > f.clusters {
+			return nil, fmt.Errorf("fat: cluster chain loops")
+		}
+		cs = append(cs, c)
+		n, last, err := f.next(c)
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			break
+		}
+		c = n
+	}
+	return cs, nil
+}
+
+// node is a directory entry.
+type node struct {
+	fs   *FS
+	name string
+	// short is the 8.3 name, in upper case.
+	short   string
+	attr    byte
+	cluster uint32
+	size    uint32
+	mtime   time.Time
+}
+
+// Info implements fs.Node.Info.
+func (n *node) Info() os.FileInfo {
+	mode := os.FileMode(0755)
+	if n.attr&attrReadOnly != 0 {
+		mode = 0555
+	}
+	size := int64(n.size)
+	if n.attr&attrDirectory != 0 {
+		mode |= os.ModeDir
+		size = 0
+	} else {
+		mode &^= 0111
+	}
+	return fs.NewFileInfo(n.name, size, mode, n.mtime, nil)
+}
+
+// isRootFixed returns whether n is the root directory of FAT12 or FAT16.
+func (n *node) isRootFixed() bool {
+	return n.attr&attrDirectory != 0 && n.cluster == 0
+}
+
+// reader returns a reader of the contents of n.
+func (n *node) reader() (io.ReaderAt, int64, error) {
+	if n.isRootFixed() {
+		return io.NewSectionReader(n.fs.r, n.fs.rootDir, n.fs.rootSize), n.fs.rootSize, nil
+	}
+	cs, err := n.fs.chain(n.cluster)
+	if err != nil {
+		return nil, 0, err
+	}
+	size := int64(len(cs)) * n.fs.clusterSize
+	if n.attr&attrDirectory == 0 {
+		if int64(n.size) > size {
+			return nil, 0, fmt.Errorf("fat: %s: %d byte file has only %d clusters", n.name, n.size, len(cs))
+		}
+		size = int64(n.size)
+	}
+	return &reader{fs: n.fs, clusters: cs}, size, nil
+}
+
+// entries returns the entries of directory n. The volume label is only
+// returned if label is true.
+func (n *node) entries(label bool) ([]*node, error) {
+	r, size, err := n.reader()
+	if err != nil {
+		return nil, err
+	}
+	if size > 1<<24 {
+		return nil, fmt.Errorf("fat: %s: directory is too big", n.name)
+	}
+	d := make([]byte, size)
+	if _, err := r.ReadAt(d, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var ents []*node
+	var long []uint16
+	var sum byte
+	for pos := 0; pos+dirEntrySize <= len(d); pos += dirEntrySize {
+		e := d[pos : pos+dirEntrySize]
+		if e[0] == 0 {
+			break
+		}
+		if e[0] == deleted {
+			long = nil
+			continue
+		}
+		attr := e[11]
+		if attr&0x3f == attrLongName {
+			// Long name entries come last part first, each
+			// with 13 UTF-16 characters.
+			var part []uint16
+			for _, o := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+				part = append(part, le.Uint16(e[o:]))
+			}
+			if e[0]&lastLongEntry != 0 {
+				long = nil
+				sum = e[13]
+			}
+			if e[13] != sum {
+				long = nil
+				continue
+			}
+			long = append(part, long...)
+			continue
+		}
+		name := shortName(e)
+		short := strings.ToUpper(name)
+		if long != nil && checksum(e[0:11]) == sum {
+			name = longName(long)
+		}
+		long = nil
+		if attr&attrVolumeID != 0 {
+			if label && attr&attrDirectory == 0 {
+				ents = append(ents, &node{attr: attr, name: strings.TrimRight(string(e[0:11]), " ")})
+			}
+			continue
+		}
+		if name == "." || name == ".." {
+			continue
+		}
+		cluster := uint32(le.Uint16(e[26:]))
+		if n.fs.typ == FAT32 {
+			cluster |= uint32(le.Uint16(e[20:])) << 16
+		}
+		ents = append(ents, &node{
+			fs:      n.fs,
+			name:    name,
+			short:   short,
+			attr:    attr,
+			cluster: cluster,
+			size:    le.Uint32(e[28:]),
+			mtime:   timestamp(le.Uint16(e[24:]), le.Uint16(e[22:])),
+		})
+	}
+	return ents, nil
+}
+
+// checksum is the checksum of a short name, which long name entries have
+// to match.
+func checksum(short []byte) byte {
+	var sum byte
+	for _, c := range short {
+		sum = (sum>>1 | sum<<7) + c
+	}
+	return sum
+}
+
+// shortName returns the 8.3 name of entry e.
+func shortName(e []byte) string {
+	base := strings.TrimRight(string(e[0:8]), " ")
+	ext := strings.TrimRight(string(e[8:11]), " ")
+	if e[0] == 0x05 {
+		base = "\xe5" + base[1:]
+	}
+	if e[12]&lowerBase != 0 {
+		base = strings.ToLower(base)
+	}
+	if e[12]&lowerExt != 0 {
+		ext = strings.ToLower(ext)
+	}
+	if ext == "" {
+		return base
+	}
+	return base + "." + ext
+}
+
+// longName decodes a long name, which ends with a 0 if it does not fill the
+// entries, and is padded with 0xffff.
+func longName(u []uint16) string {
+	for i, c := range u {
+		if c == 0 {
+			u = u[:i]
+			break
+		}
+	}
+	return string(utf16.Decode(u))
+}
+
+// timestamp converts a FAT date and time.
+func timestamp(date, tm uint16) time.Time {
+	if date == 0 {
+		return time.Time{}
+	}
+	return time.Date(1980+int(date>>9), time.Month(date>>5&0xf), int(date&0x1f),
+		int(tm>>11), int(tm>>5&0x3f), int(tm&0x1f)*2, 0, time.UTC)
+}
+
+// Lookup implements fs.Node.Lookup.
+func (n *node) Lookup(name string) (fs.Node, error) {
+	ents, err := n.entries(false)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range ents {
+		if strings.EqualFold(e.name, name) {
+			return e, nil
+		}
+	}
+	// Files with long names can be found by their short name, too.
+	for _, e := range ents {
+		if strings.EqualFold(e.short, name) {
+			return e, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// ReadDir implements fs.Node.ReadDir.
+func (n *node) ReadDir() ([]os.FileInfo, error) {
+	ents, err := n.entries(false)
+	if err != nil {
+		return nil, err
+	}
+	var fis []os.FileInfo
+	for _, e := range ents {
+		fis = append(fis, e.Info())
+	}
+	return fis, nil
+}
+
+// Readlink implements fs.Node.Readlink. FAT has no symbolic links.
+func (n *node) Readlink() (string, error) {
+	return "", syscall.EINVAL
+}
+
+// Open implements fs.Node.Open.
+func (n *node) Open() (fs.File, error) {
+	r, _, err := n.reader()
+	if err != nil {
+		return nil, err
+	}
+	return fs.NewFile(r, n.Info()), nil
+}
+
+// reader reads the clusters of a file.
+type reader struct {
+	fs       *FS
+	clusters []uint32
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *reader) ReadAt(p []byte, off int64) (int, error) {
+	cs := r.fs.clusterSize
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		i := pos / cs
+		if i >= int64(len(r.clusters)) {
+			return n, io.EOF
+		}
+		in := pos % cs
+		end := int64(len(p) - n)
+		if end > cs-in {
+			end = cs - in
+		}
+		m, err := r.fs.r.ReadAt(p[n:n+int(end)], r.fs.data+int64(r.clusters[i]-2)*cs+in)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
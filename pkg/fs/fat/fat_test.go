@@ -0,0 +1,349 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/u-root/u-root/pkg/fs"
+)
+
+// builder makes FAT images for the tests. Files get every other cluster, so
+// reading them has to follow the FAT.
+type builder struct {
+	img      []byte
+	typ      Type
+	spc      int
+	fatOff   int
+	fatSize  int
+	rootOff  int
+	dataOff  int
+	next     uint32
+	clusters uint32
+}
+
+var mtime = time.Date(2018, 8, 10, 12, 34, 56, 0, time.UTC)
+
+func newBuilder(typ Type, sectors, spc int) *builder {
+	b := &builder{img: make([]byte, sectors*sectorSize), typ: typ, spc: spc, next: 2}
+	reserved, rootEntries := 1, 512
+	if typ == FAT32 {
+		reserved, rootEntries = 32, 0
+	}
+	clusters := sectors / spc
+	b.fatSize = (clusters*int(typ)/8 + sectorSize) / sectorSize
+	b.fatOff = reserved * sectorSize
+	b.rootOff = b.fatOff + 2*b.fatSize*sectorSize
+	b.dataOff = b.rootOff + rootEntries*dirEntrySize
+	b.clusters = uint32((sectors - b.dataOff/sectorSize) / spc)
+
+	bs := b.img
+	copy(bs, []byte{0xeb, 0x3c, 0x90, 'm', 'k', 'f', 's', '.', 'f', 'a', 't'})
+	binary.LittleEndian.PutUint16(bs[0x0b:], sectorSize)
+	bs[0x0d] = byte(spc)
+	binary.LittleEndian.PutUint16(bs[0x0e:], uint16(reserved))
+	bs[0x10] = 2
+	binary.LittleEndian.PutUint16(bs[0x11:], uint16(rootEntries))
+	bs[0x15] = 0xf8
+	binary.LittleEndian.PutUint32(bs[0x20:], uint32(sectors))
+	ext := bs[0x24:]
+	if typ == FAT32 {
+		binary.LittleEndian.PutUint32(bs[0x24:], uint32(b.fatSize))
+		ext = bs[0x40:]
+	} else {
+		binary.LittleEndian.PutUint16(bs[0x16:], uint16(b.fatSize))
+	}
+	ext[2] = 0x29
+	binary.LittleEndian.PutUint32(ext[3:], 0x1234abcd)
+	copy(ext[7:18], "NO NAME    ")
+	bs[510], bs[511] = 0x55, 0xaa
+	b.setFAT(0, 0x0ffffff8)
+	b.setFAT(1, 0x0fffffff)
+	return b
+}
+
+func (b *builder) setFAT(c, v uint32) {
+	for f := 0; f < 2; f++ {
+		fat := b.img[b.fatOff+f*b.fatSize*sectorSize:]
+		switch b.typ {
+		case FAT12:
+			v &= 0xfff
+			o := c + c/2
+			old := binary.LittleEndian.Uint16(fat[o:])
+			if c&1 != 0 {
+				binary.LittleEndian.PutUint16(fat[o:], old&0x000f|uint16(v)<<4)
+			} else {
+				binary.LittleEndian.PutUint16(fat[o:], old&0xf000|uint16(v))
+			}
+		case FAT16:
+			binary.LittleEndian.PutUint16(fat[c*2:], uint16(v))
+		default:
+			binary.LittleEndian.PutUint32(fat[c*4:], v&0x0fffffff)
+		}
+	}
+}
+
+// write writes d to newly allocated clusters and returns the first one.
+func (b *builder) write(d []byte) uint32 {
+	cs := int(b.spc * sectorSize)
+	var first, prev uint32
+	for len(d) > 0 || first == 0 {
+		c := b.next
+		b.next += 2
+		if c >= b.clusters+2 {
+			panic("image full")
+		}
+		if first == 0 {
+			first = c
+		} else {
+			b.setFAT(prev, c)
+		}
+		b.setFAT(c, 0x0fffffff)
+		d = d[copy(b.img[b.dataOff+int(c-2)*cs:b.dataOff+int(c-1)*cs], d):]
+		prev = c
+	}
+	return first
+}
+
+// entry returns the directory entries for a file, with long name entries
+// if the name is no 8.3 name.
+func entry(name string, n int, attr byte, cluster, size uint32) []byte {
+	short := []byte("           ")
+	var flags byte
+	base, ext := name, ""
+	if i := strings.LastIndex(name, "."); i > 0 {
+		base, ext = name[:i], name[i+1:]
+	}
+	var long []uint16
+	switch {
+	case name == "." || name == "..":
+		copy(short, name)
+	case len(base) <= 8 && len(ext) <= 3 && !strings.ContainsAny(name, " +~") && (name == strings.ToUpper(name) || name == strings.ToLower(name)):
+		copy(short, strings.ToUpper(base))
+		copy(short[8:], strings.ToUpper(ext))
+		if name != strings.ToUpper(name) {
+			flags = lowerBase | lowerExt
+		}
+	default:
+		b := strings.ToUpper(strings.Replace(base, " ", "", -1))
+		if len(b) > 6 {
+			b = b[:6]
+		}
+		copy(short, fmt.Sprintf("%s~%d", b, n))
+		if len(ext) > 3 {
+			ext = ext[:3]
+		}
+		copy(short[8:], strings.ToUpper(ext))
+		long = append(utf16.Encode([]rune(name)), 0)
+		for len(long)%13 != 0 {
+			long = append(long, 0xffff)
+		}
+	}
+
+	var d []byte
+	sum := checksum(short)
+	for i := len(long)/13 - 1; i >= 0; i-- {
+		e := make([]byte, dirEntrySize)
+		e[0] = byte(i + 1)
+		if i == len(long)/13-1 {
+			e[0] |= lastLongEntry
+		}
+		e[11], e[13] = attrLongName, sum
+		for j, o := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+			binary.LittleEndian.PutUint16(e[o:], long[i*13+j])
+		}
+		d = append(d, e...)
+	}
+	e := make([]byte, dirEntrySize)
+	copy(e, short)
+	e[11], e[12] = attr, flags
+	binary.LittleEndian.PutUint16(e[20:], uint16(cluster>>16))
+	binary.LittleEndian.PutUint16(e[22:], uint16(mtime.Hour()<<11|mtime.Minute()<<5|mtime.Second()/2))
+	binary.LittleEndian.PutUint16(e[24:], uint16((mtime.Year()-1980)<<9|int(mtime.Month())<<5|mtime.Day()))
+	binary.LittleEndian.PutUint16(e[26:], uint16(cluster))
+	binary.LittleEndian.PutUint32(e[28:], size)
+	return append(d, e...)
+}
+
+// dir writes a directory with files, whose names are paths relative to it,
+// and returns its first cluster. The root directory of FAT12 and FAT16 is
+// written to its fixed place.
+func (b *builder) dir(files map[string]string, self, parent uint32, root bool) uint32 {
+	sub := map[string]map[string]string{}
+	var names []string
+	for name, data := range files {
+		i := strings.Index(name, "/")
+		if i == -1 {
+			names = append(names, name)
+			continue
+		}
+		if sub[name[:i]] == nil {
+			sub[name[:i]] = map[string]string{}
+			names = append(names, name[:i])
+		}
+		sub[name[:i]][name[i+1:]] = data
+	}
+	sort.Strings(names)
+
+	if !root || b.typ == FAT32 {
+		self = b.write(make([]byte, 16*dirEntrySize*len(names)+2*dirEntrySize))
+	}
+	var d []byte
+	if root {
+		e := entry("TESTVOL", 0, attrVolumeID, 0, 0)
+		copy(e, "TESTVOL    ")
+		d = append(d, e...)
+	} else {
+		d = append(d, entry(".", 0, attrDirectory, self, 0)...)
+		d = append(d, entry("..", 0, attrDirectory, parent, 0)...)
+	}
+	for i, name := range names {
+		if s, ok := sub[name]; ok {
+			c := b.dir(s, 0, self, false)
+			d = append(d, entry(name, i+1, attrDirectory, c, 0)...)
+			continue
+		}
+		var c uint32
+		if len(files[name]) > 0 {
+			c = b.write([]byte(files[name]))
+		}
+		d = append(d, entry(name, i+1, 0, c, uint32(len(files[name])))...)
+	}
+
+	if root && b.typ != FAT32 {
+		copy(b.img[b.rootOff:b.dataOff], d)
+		return 0
+	}
+	// Rewrite the directory's clusters, following its chain.
+	f := &FS{r: bytes.NewReader(b.img), typ: b.typ, fat: int64(b.fatOff), clusters: b.clusters}
+	cs, err := f.chain(self)
+	if err != nil {
+		panic(err)
+	}
+	size := b.spc * sectorSize
+	for i, c := range cs {
+		if i*size < len(d) {
+			copy(b.img[b.dataOff+int(c-2)*size:b.dataOff+int(c-1)*size], d[i*size:])
+		}
+	}
+	return self
+}
+
+func build(typ Type, sectors, spc int, files map[string]string) []byte {
+	b := newBuilder(typ, sectors, spc)
+	root := b.dir(files, 0, 0, true)
+	if typ == FAT32 {
+		binary.LittleEndian.PutUint32(b.img[0x2c:], root)
+	}
+	return b.img
+}
+
+func TestFAT(t *testing.T) {
+	big := strings.Repeat("0123456789abcdef", 10000)
+	grub := "menuentry \"Linux\" {\n\tlinux /vmlinuz\n}\n"
+	files := map[string]string{
+		"EFI/BOOT/BOOTX64.EFI":             big[:5000],
+		"boot/grub/grub.cfg":               grub,
+		"A long file name with spaces.txt": "long",
+		"big":                              big,
+		"empty":                            "",
+		"dir/with/Mixed Case and ünïcödé.cfg": "unicode",
+	}
+	for _, tt := range []struct {
+		typ     Type
+		sectors int
+		spc     int
+	}{
+		{FAT12, 2048, 1},
+		{FAT16, 32768, 4},
+		{FAT32, 81920, 1},
+	} {
+		t.Run(fmt.Sprintf("FAT%d", tt.typ), func(t *testing.T) {
+			img := build(tt.typ, tt.sectors, tt.spc, files)
+			f, err := New(bytes.NewReader(img))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Type() != tt.typ || f.Label() != "TESTVOL" || f.Serial() != 0x1234abcd {
+				t.Errorf("Got FAT%d, label %q, serial %#x, want FAT%d, TESTVOL, 0x1234abcd", f.Type(), f.Label(), f.Serial(), tt.typ)
+			}
+			for name, want := range files {
+				got, err := fs.ReadFile(f, name)
+				if err != nil {
+					t.Errorf("ReadFile(%q): %v", name, err)
+					continue
+				}
+				if string(got) != want {
+					t.Errorf("ReadFile(%q) returned %d different bytes, want %d", name, len(got), len(want))
+				}
+			}
+			for name, want := range map[string]string{
+				"efi/boot/bootx64.efi": big[:5000],
+				"/BOOT/GRUB/GRUB.CFG":  grub,
+				"ALONGF~1.TXT":         "long",
+				"dir/../big":           big,
+			} {
+				if got, err := fs.ReadFile(f, name); err != nil || string(got) != want {
+					t.Errorf("ReadFile(%q) = %d bytes, %v, want %d bytes", name, len(got), err, len(want))
+				}
+			}
+
+			fis, err := f.ReadDir("/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, fi := range fis {
+				names = append(names, fi.Name())
+			}
+			if n := strings.Join(names, "|"); n != "A long file name with spaces.txt|EFI|big|boot|dir|empty" {
+				t.Errorf("ReadDir(/) = %s", n)
+			}
+			fi, err := f.Stat("boot/grub/grub.cfg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Name() != "grub.cfg" || fi.Size() != int64(len(grub)) || fi.Mode() != 0644 || !fi.ModTime().Equal(mtime) {
+				t.Errorf("Stat(boot/grub/grub.cfg) = %q, %d bytes, mode %v, time %v", fi.Name(), fi.Size(), fi.Mode(), fi.ModTime())
+			}
+			if fi, err := f.Stat("dir/with"); err != nil || !fi.IsDir() {
+				t.Errorf("Stat(dir/with) = %v, %v, want a directory", fi, err)
+			}
+			if _, err := f.Open("nothere"); !os.IsNotExist(err) {
+				t.Errorf("Open(nothere): got %v, want a does not exist error", err)
+			}
+			if _, typ, err := fs.Probe(bytes.NewReader(img)); err != nil || typ != "vfat" {
+				t.Errorf("Probe() = %q, %v, want vfat", typ, err)
+			}
+		})
+	}
+}
+
+func TestNotFAT(t *testing.T) {
+	if _, err := New(bytes.NewReader(make([]byte, 4096))); err == nil {
+		t.Errorf("New(zeros): got nil, want error")
+	}
+	// x starts at cluster 2 and continues at cluster 4, which is made
+	// to point back to cluster 2.
+	img := build(FAT16, 32768, 4, map[string]string{"x": strings.Repeat("x", 10000)})
+	f, err := New(bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newBuilder(FAT16, 32768, 4)
+	b.img = img
+	b.setFAT(4, 2)
+	if _, err := fs.ReadFile(f, "x"); err == nil {
+		t.Errorf("ReadFile of a file with a cluster loop: got nil, want error")
+	}
+}
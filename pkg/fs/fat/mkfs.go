@@ -0,0 +1,284 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fat
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	sectorSize = 512
+	numFATs    = 2
+	// media is the media descriptor of fixed disks.
+	media = 0xf8
+
+	maxFAT12Clusters = 4084
+	maxFAT16Clusters = 65524
+	maxFAT32Clusters = 0x0ffffff4
+)
+
+// FormatOptions are the options of Format. The zero value picks defaults
+// that suit the size of the file system.
+type FormatOptions struct {
+	// Type is FAT12, FAT16 or FAT32. The default is FAT32 from 512 MiB
+	// on, and FAT16 or, for small file systems, FAT12 below.
+	Type Type
+	// SectorsPerCluster is a power of 2 up to 128.
+	SectorsPerCluster int
+	// Label is the volume label, at most 11 characters.
+	Label string
+	// Serial is the volume serial number. The default is derived from
+	// the time.
+	Serial uint32
+}
+
+// geometry is the layout of a FAT file system.
+type geometry struct {
+	typ         Type
+	totSec      uint32
+	secPerClus  uint32
+	reserved    uint32
+	rootEntries uint32
+	fatSize     uint32
+	clusters    uint32
+}
+
+func (g *geometry) rootSecs() uint32 {
+	return g.rootEntries * dirEntrySize / sectorSize
+}
+
+// clusterSize returns the cluster size in sectors that Microsoft
+// recommends for typ and totSec sectors.
+func clusterSize(typ Type, totSec uint32) uint32 {
+	var table []struct{ upTo, spc uint32 }
+	switch typ {
+	case FAT12:
+		// Clusters grow until they number fewer than 4085.
+		spc := uint32(1)
+		for totSec/spc > maxFAT12Clusters && spc < 128 {
+			spc *= 2
+		}
+		return spc
+	case FAT16:
+		table = []struct{ upTo, spc uint32 }{
+			{32680, 2}, {262144, 4}, {524288, 8}, {1048576, 16}, {2097152, 32}, {4194304, 64},
+		}
+	default:
+		table = []struct{ upTo, spc uint32 }{
+			{532480, 1}, {16777216, 8}, {33554432, 16}, {67108864, 32},
+		}
+	}
+	for _, e := range table {
+		if totSec <= e.upTo {
+			return e.spc
+		}
+	}
+	if typ == FAT16 {
+		return 128
+	}
+	return 64
+}
+
+// newGeometry lays out a FAT of type typ on totSec sectors, with spc
+// sectors per cluster, or the recommended number if 0.
+func newGeometry(typ Type, totSec, spc uint32) (*geometry, error) {
+	g := &geometry{typ: typ, totSec: totSec, secPerClus: spc, reserved: 1, rootEntries: 512}
+	if g.secPerClus == 0 {
+		g.secPerClus = clusterSize(typ, totSec)
+	}
+	if typ == FAT32 {
+		g.reserved, g.rootEntries = 32, 0
+	}
+	if g.secPerClus > 128 || g.secPerClus&(g.secPerClus-1) != 0 {
+		return nil, fmt.Errorf("fat: %d sectors per cluster is not a power of 2 up to 128", g.secPerClus)
+	}
+	// The FAT size depends on the number of clusters, which depends on
+	// the FAT size.
+	g.fatSize = 1
+	for {
+		meta := g.reserved + numFATs*g.fatSize + g.rootSecs()
+		if meta >= totSec {
+			return nil, fmt.Errorf("fat: %d sectors are too small for %v", totSec, typ)
+		}
+		g.clusters = (totSec - meta) / g.secPerClus
+		need := ((uint64(g.clusters)+2)*uint64(typ)/8 + sectorSize - 1) / sectorSize
+		if uint64(g.fatSize) >= need {
+			break
+		}
+		g.fatSize = uint32(need)
+	}
+	min, max := uint32(1), uint32(maxFAT12Clusters)
+	switch typ {
+	case FAT16:
+		min, max = maxFAT12Clusters+1, maxFAT16Clusters
+	case FAT32:
+		min, max = maxFAT16Clusters+1, maxFAT32Clusters
+	}
+	if g.clusters < min || g.clusters > max {
+		return nil, fmt.Errorf("fat: %d clusters of %d sectors do not make a %v file system", g.clusters, g.secPerClus, typ)
+	}
+	return g, nil
+}
+
+func (t Type) String() string {
+	return fmt.Sprintf("FAT%d", int(t))
+}
+
+// Format writes an empty FAT file system of size bytes to w.
+func Format(w io.WriterAt, size int64, o *FormatOptions) error {
+	if o == nil {
+		o = &FormatOptions{}
+	}
+	label := strings.ToUpper(o.Label)
+	if len(label) > 11 {
+		return fmt.Errorf("fat: label %q is longer than 11 characters", o.Label)
+	}
+	if size/sectorSize > 1<<32-1 {
+		return fmt.Errorf("fat: %d bytes are too big", size)
+	}
+	totSec := uint32(size / sectorSize)
+	spc := uint32(o.SectorsPerCluster)
+
+	var g *geometry
+	var err error
+	switch o.Type {
+	case FAT12, FAT16, FAT32:
+		g, err = newGeometry(o.Type, totSec, spc)
+	case 0:
+		if size >= 512<<20 {
+			g, err = newGeometry(FAT32, totSec, spc)
+			break
+		}
+		if g, err = newGeometry(FAT16, totSec, spc); err != nil {
+			g, err = newGeometry(FAT12, totSec, spc)
+		}
+	default:
+		return fmt.Errorf("fat: unknown type %v", o.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	serial := o.Serial
+	if serial == 0 {
+		serial = uint32(time.Now().UnixNano())
+	}
+	fsLabel := label
+	if fsLabel == "" {
+		fsLabel = "NO NAME"
+	}
+
+	b := make([]byte, sectorSize)
+	b[0], b[1], b[2] = 0xeb, 0x3c, 0x90
+	copy(b[3:], "MSWIN4.1")
+	le.PutUint16(b[0x0b:], sectorSize)
+	b[0x0d] = byte(g.secPerClus)
+	le.PutUint16(b[0x0e:], uint16(g.reserved))
+	b[0x10] = numFATs
+	le.PutUint16(b[0x11:], uint16(g.rootEntries))
+	if totSec < 0x10000 {
+		le.PutUint16(b[0x13:], uint16(totSec))
+	} else {
+		le.PutUint32(b[0x20:], totSec)
+	}
+	b[0x15] = media
+	// The usual fake geometry.
+	le.PutUint16(b[0x18:], 32)
+	le.PutUint16(b[0x1a:], 64)
+	ext := b[0x24:]
+	if g.typ == FAT32 {
+		b[1] = 0x58
+		le.PutUint32(b[0x24:], g.fatSize)
+		le.PutUint32(b[0x2c:], 2)
+		// The FS information sector and the backup boot sector.
+		le.PutUint16(b[0x30:], 1)
+		le.PutUint16(b[0x32:], 6)
+		ext = b[0x40:]
+	} else {
+		le.PutUint16(b[0x16:], uint16(g.fatSize))
+	}
+	ext[0] = 0x80
+	ext[2] = 0x29
+	le.PutUint32(ext[3:], serial)
+	copy(ext[7:18], fmt.Sprintf("%-11s", fsLabel))
+	copy(ext[18:26], fmt.Sprintf("%-8v", g.typ))
+	b[510], b[511] = 0x55, 0xaa
+
+	// Zero the reserved sectors, FATs and root directory.
+	meta := int64(g.reserved+numFATs*g.fatSize+g.rootSecs()) * sectorSize
+	if g.typ == FAT32 {
+		meta += int64(g.secPerClus) * sectorSize
+	}
+	if err := writeZeros(w, 0, meta); err != nil {
+		return err
+	}
+	if _, err := w.WriteAt(b, 0); err != nil {
+		return err
+	}
+	if g.typ == FAT32 {
+		info := make([]byte, sectorSize)
+		le.PutUint32(info[0:], 0x41615252)
+		le.PutUint32(info[484:], 0x61417272)
+		// One cluster is taken by the root directory.
+		le.PutUint32(info[488:], g.clusters-1)
+		le.PutUint32(info[492:], 3)
+		le.PutUint32(info[508:], 0xaa550000)
+		for _, s := range []int64{0, 6} {
+			if _, err := w.WriteAt(b, s*sectorSize); err != nil {
+				return err
+			}
+			if _, err := w.WriteAt(info, (s+1)*sectorSize); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The first two FAT entries hold the media descriptor and an end
+	// of chain mark. On FAT32, the root directory is cluster 2.
+	var fat []byte
+	switch g.typ {
+	case FAT12:
+		fat = []byte{media, 0xff, 0xff}
+	case FAT16:
+		fat = []byte{media, 0xff, 0xff, 0xff}
+	default:
+		fat = []byte{media, 0xff, 0xff, 0x0f, 0xff, 0xff, 0xff, 0x0f, 0xff, 0xff, 0xff, 0x0f}
+	}
+	for i := uint32(0); i < numFATs; i++ {
+		if _, err := w.WriteAt(fat, int64(g.reserved+i*g.fatSize)*sectorSize); err != nil {
+			return err
+		}
+	}
+
+	if label != "" {
+		e := make([]byte, dirEntrySize)
+		copy(e, fmt.Sprintf("%-11s", label))
+		e[11] = attrVolumeID
+		if _, err := w.WriteAt(e, int64(g.reserved+numFATs*g.fatSize)*sectorSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeZeros writes n zero bytes at off.
+func writeZeros(w io.WriterAt, off, n int64) error {
+	zero := make([]byte, 64<<10)
+	for n > 0 {
+		c := int64(len(zero))
+		if c > n {
+			c = n
+		}
+		if _, err := w.WriteAt(zero[:c], off); err != nil {
+			return err
+		}
+		off += c
+		n -= c
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fat
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/fs"
+)
+
+func TestFormat(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		size     int64
+		opts     FormatOptions
+		typ      Type
+		clusters uint32
+	}{
+		{name: "auto FAT12", size: 1 << 20, typ: FAT12, clusters: 2003},
+		{name: "auto FAT16", size: 100 << 20, typ: FAT16, clusters: 51091},
+		{name: "auto FAT32", size: 600 << 20, typ: FAT32, clusters: 153296},
+		{name: "FAT32", size: 40 << 20, opts: FormatOptions{Type: FAT32}, typ: FAT32, clusters: 80608},
+		{name: "FAT16 clusters", size: 16 << 20, opts: FormatOptions{Type: FAT16, SectorsPerCluster: 1}, typ: FAT16, clusters: 32479},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "fat")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+			if err := f.Truncate(tt.size); err != nil {
+				t.Fatal(err)
+			}
+			tt.opts.Label, tt.opts.Serial = "efi", 0x1234abcd
+			if err := Format(f, tt.size, &tt.opts); err != nil {
+				t.Fatal(err)
+			}
+
+			fsys, err := New(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fsys.Type() != tt.typ || fsys.clusters != tt.clusters {
+				t.Errorf("got %v with %d clusters, want %v with %d", fsys.Type(), fsys.clusters, tt.typ, tt.clusters)
+			}
+			if fsys.Label() != "EFI" || fsys.Serial() != 0x1234abcd {
+				t.Errorf("Label() = %q, Serial() = %#x, want EFI, 0x1234abcd", fsys.Label(), fsys.Serial())
+			}
+			if fi, err := fsys.ReadDir("/"); err != nil || len(fi) != 0 {
+				t.Errorf("ReadDir(/) = %v, %v, want nothing", fi, err)
+			}
+			if _, err := fs.ReadFile(fsys, "nothere"); !os.IsNotExist(err) {
+				t.Errorf("ReadFile(nothere) = %v, want not exist", err)
+			}
+			// Only the reserved entries, and on FAT32 the root
+			// directory, are used.
+			used := map[Type]int{FAT12: 3, FAT16: 4, FAT32: 12}[tt.typ]
+			b := make([]byte, 64)
+			if _, err := f.ReadAt(b, fsys.fat); err != nil {
+				t.Fatal(err)
+			}
+			for i, c := range b[used:] {
+				if c != 0 {
+					t.Errorf("FAT byte %d is %#x, want 0", used+i, c)
+				}
+			}
+			if n, last, err := fsys.next(1); !last || err != nil {
+				t.Errorf("FAT entry 1 is %#x, want end of chain (%v)", n, err)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		size int64
+		opts FormatOptions
+	}{
+		{size: 1 << 20, opts: FormatOptions{Type: FAT32}},
+		{size: 600 << 20, opts: FormatOptions{Type: FAT12}},
+		{size: 100 << 20, opts: FormatOptions{Type: FAT16, SectorsPerCluster: 3}},
+		{size: 1 << 20, opts: FormatOptions{Type: 24}},
+		{size: 1 << 20, opts: FormatOptions{Label: "label too long"}},
+		{size: 4 << 10},
+	} {
+		if err := Format(nil, tt.size, &tt.opts); err == nil {
+			t.Errorf("Format(%d bytes, %+v): got nil, want error", tt.size, tt.opts)
+		}
+	}
+}
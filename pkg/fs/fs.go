@@ -0,0 +1,173 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package fs defines read-only file systems.
+//
+// Dir serves a directory of the running system. The subpackages read file
+// system images from an io.ReaderAt, such as a block device, without kernel
+// file system drivers. They register themselves, so Probe can find the one
+// for an image:
+//
+//	import (
+//		"github.com/u-root/u-root/pkg/fs"
+//		_ "github.com/u-root/u-root/pkg/fs/ext4"
+//	)
+//
+//	fsys, fstype, err := fs.Probe(device)
+//
+// Names are slash separated paths relative to the root of the file system.
+// A leading slash is allowed and ignored.
+package fs
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FS is a read-only file system.
+type FS interface {
+	// Open opens the file called name, following symbolic links.
+	Open(name string) (File, error)
+	// Stat returns a FileInfo for name, following symbolic links.
+	Stat(name string) (os.FileInfo, error)
+	// ReadDir returns the entries of directory name, sorted by name.
+	// Symbolic links in it are not followed.
+	ReadDir(name string) ([]os.FileInfo, error)
+}
+
+// File is an open file of an FS.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+	Stat() (os.FileInfo, error)
+}
+
+// ErrUnknown is returned by Probe if no file system recognizes the image.
+var ErrUnknown = errors.New("unknown file system")
+
+// MaxSymlinks is how many symbolic links a lookup follows before it gives
+// up.
+const MaxSymlinks = 40
+
+// Clean returns name as a cleaned path relative to the root, or "" for the
+// root itself.
+func Clean(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// Split returns the components of name.
+func Split(name string) []string {
+	n := Clean(name)
+	if n == "" {
+		return nil
+	}
+	return strings.Split(n, "/")
+}
+
+// ReadFile returns the contents of file name.
+func ReadFile(fsys FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+type dir string
+
+// Dir returns an FS for the directory d of the running system.
+func Dir(d string) FS {
+	return dir(d)
+}
+
+func (d dir) path(name string) string {
+	return filepath.Join(string(d), filepath.FromSlash(Clean(name)))
+}
+
+// Open implements FS.Open.
+func (d dir) Open(name string) (File, error) {
+	return os.Open(d.path(name))
+}
+
+// Stat implements FS.Stat.
+func (d dir) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(d.path(name))
+}
+
+// ReadDir implements FS.ReadDir.
+func (d dir) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(d.path(name))
+}
+
+type fileInfo struct {
+	name  string
+	size  int64
+	mode  os.FileMode
+	mtime time.Time
+	sys   interface{}
+}
+
+// NewFileInfo returns an os.FileInfo. sys is returned by its Sys method.
+func NewFileInfo(name string, size int64, mode os.FileMode, mtime time.Time, sys interface{}) os.FileInfo {
+	return &fileInfo{name: name, size: size, mode: mode, mtime: mtime, sys: sys}
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.mtime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return fi.sys }
+
+type file struct {
+	*io.SectionReader
+	fi os.FileInfo
+}
+
+// NewFile returns a File reading fi.Size() bytes of contents from r.
+func NewFile(r io.ReaderAt, fi os.FileInfo) File {
+	return &file{SectionReader: io.NewSectionReader(r, 0, fi.Size()), fi: fi}
+}
+
+func (f *file) Stat() (os.FileInfo, error) { return f.fi, nil }
+func (f *file) Close() error               { return nil }
+
+// PathError returns an *os.PathError, so os.IsNotExist and friends work
+// on errors of file systems.
+func PathError(op, name string, err error) error {
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+type format struct {
+	name string
+	open func(io.ReaderAt) (FS, error)
+}
+
+var formats []format
+
+// Register makes a file system known to Probe. open has to fail quickly for
+// images of other file systems, typically by checking a magic number first.
+func Register(name string, open func(io.ReaderAt) (FS, error)) {
+	formats = append(formats, format{name, open})
+}
+
+// Probe opens the file system in r with the first registered file system
+// that recognizes it, and returns it and its name.
+func Probe(r io.ReaderAt) (FS, string, error) {
+	for _, f := range formats {
+		if fsys, err := f.open(r); err == nil {
+			return fsys, f.name, nil
+		}
+	}
+	return nil, "", ErrUnknown
+}
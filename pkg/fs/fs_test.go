@@ -0,0 +1,202 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClean(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		clean string
+		split []string
+	}{
+		{"", "", nil},
+		{"/", "", nil},
+		{"/boot/../boot//grub/", "boot/grub", []string{"boot", "grub"}},
+		{"../../x", "x", []string{"x"}},
+		{"a/./b", "a/b", []string{"a", "b"}},
+	} {
+		if got := Clean(tt.name); got != tt.clean {
+			t.Errorf("Clean(%q) = %q, want %q", tt.name, got, tt.clean)
+		}
+		if got := Split(tt.name); strings.Join(got, "|") != strings.Join(tt.split, "|") || len(got) != len(tt.split) {
+			t.Errorf("Split(%q) = %q, want %q", tt.name, got, tt.split)
+		}
+	}
+}
+
+func TestDir(t *testing.T) {
+	d, err := ioutil.TempDir("", "fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	if err := os.MkdirAll(filepath.Join(d, "boot/grub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(d, "boot/grub/grub.cfg"), []byte("cfg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := Dir(d)
+	for _, name := range []string{"boot/grub/grub.cfg", "/boot/grub/grub.cfg", "../../boot/grub/grub.cfg"} {
+		if b, err := ReadFile(fsys, name); err != nil || string(b) != "cfg" {
+			t.Errorf("ReadFile(%q) = %q, %v, want cfg", name, b, err)
+		}
+	}
+	fis, err := fsys.ReadDir("/boot")
+	if err != nil || len(fis) != 1 || fis[0].Name() != "grub" {
+		t.Errorf("ReadDir(/boot) = %v, %v, want grub", fis, err)
+	}
+	if _, err := fsys.Stat("nothere"); !os.IsNotExist(err) {
+		t.Errorf("Stat(nothere): got %v, want a does not exist error", err)
+	}
+}
+
+// memNode is an in-memory Node.
+type memNode struct {
+	name     string
+	data     string
+	link     string
+	children map[string]*memNode
+}
+
+func (n *memNode) Info() os.FileInfo {
+	mode := os.FileMode(0644)
+	switch {
+	case n.children != nil:
+		mode = os.ModeDir | 0755
+	case n.link != "":
+		mode = os.ModeSymlink | 0777
+	}
+	return NewFileInfo(n.name, int64(len(n.data)), mode, time.Time{}, nil)
+}
+
+func (n *memNode) Lookup(name string) (Node, error) {
+	if c, ok := n.children[name]; ok {
+		return c, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+func (n *memNode) ReadDir() ([]os.FileInfo, error) {
+	var fis []os.FileInfo
+	for _, c := range n.children {
+		fis = append(fis, c.Info())
+	}
+	return fis, nil
+}
+
+func (n *memNode) Readlink() (string, error) {
+	if n.link == "" {
+		return "", syscall.EINVAL
+	}
+	return n.link, nil
+}
+
+func (n *memNode) Open() (File, error) {
+	return NewFile(strings.NewReader(n.data), n.Info()), nil
+}
+
+func memTree() *memNode {
+	dir := func(cs ...*memNode) *memNode {
+		n := &memNode{children: map[string]*memNode{}}
+		for _, c := range cs {
+			n.children[c.name] = c
+		}
+		return n
+	}
+	grub := dir(&memNode{name: "grub.cfg", data: "cfg"}, &memNode{name: "up", link: "../.."})
+	grub.name = "grub"
+	boot := dir(grub, &memNode{name: "abs", link: "/boot/grub/grub.cfg"})
+	boot.name = "boot"
+	return dir(boot,
+		&memNode{name: "rel", link: "boot/grub"},
+		&memNode{name: "loop", link: "loop"},
+		&memNode{name: "file", data: "file"},
+	)
+}
+
+func TestNodeFS(t *testing.T) {
+	fsys := NewFS(memTree())
+	for _, name := range []string{
+		"boot/grub/grub.cfg",
+		"/boot/abs",
+		"rel/grub.cfg",
+		"rel/../grub/grub.cfg",
+		"rel/up/boot/abs",
+		"../boot/./grub/grub.cfg",
+	} {
+		if b, err := ReadFile(fsys, name); err != nil || string(b) != "cfg" {
+			t.Errorf("ReadFile(%q) = %q, %v, want cfg", name, b, err)
+		}
+	}
+	for _, tt := range []struct {
+		name string
+		err  error
+	}{
+		{"nothere", os.ErrNotExist},
+		{"file/x", syscall.ENOTDIR},
+		{"loop", syscall.ELOOP},
+		{"boot", syscall.EISDIR},
+	} {
+		_, err := fsys.Open(tt.name)
+		if pe, ok := err.(*os.PathError); !ok || pe.Err != tt.err {
+			t.Errorf("Open(%q): got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	fis, err := fsys.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	if n := strings.Join(names, " "); n != "boot file loop rel" {
+		t.Errorf("ReadDir(/) = %s, want sorted names", n)
+	}
+	if _, err := fsys.ReadDir("file"); err == nil {
+		t.Errorf("ReadDir(file): got nil, want error")
+	}
+
+	// Resolve does not follow a symbolic link at the end unless asked to.
+	n, err := Resolve(memTree(), "rel", false)
+	if err != nil || n.Info().Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Resolve(rel, false) = %v, %v, want the symbolic link", n, err)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	saved := formats
+	defer func() { formats = saved }()
+	formats = nil
+
+	Register("magic", func(r io.ReaderAt) (FS, error) {
+		b := make([]byte, 5)
+		if _, err := r.ReadAt(b, 0); err != nil || string(b) != "magic" {
+			return nil, errors.New("no magic")
+		}
+		return NewFS(memTree()), nil
+	})
+	if _, typ, err := Probe(bytes.NewReader([]byte("magic number"))); err != nil || typ != "magic" {
+		t.Errorf("Probe(magic) = %q, %v, want magic", typ, err)
+	}
+	if _, _, err := Probe(bytes.NewReader([]byte("nothing"))); err != ErrUnknown {
+		t.Errorf("Probe(nothing): got %v, want %v", err, ErrUnknown)
+	}
+}
@@ -0,0 +1,528 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package iso9660 reads ISO 9660 file systems, with Rock Ridge and Joliet
+// extensions.
+//
+// Rock Ridge names, modes and symbolic links are used if the image has
+// them. Otherwise Joliet names are used if there is a Joliet volume
+// descriptor, and plain ISO 9660 names, without the ";1" version, if not.
+// Without Rock Ridge, names are looked up case-insensitively.
+package iso9660
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf16"
+
+	"github.com/u-root/u-root/pkg/fs"
+)
+
+const (
+	sectorSize = 2048
+
+	// First sector of the volume descriptors.
+	firstDescriptor = 16
+
+	descPrimary       = 1
+	descSupplementary = 2
+	descTerminator    = 255
+
+	flagDirectory   = 0x02
+	flagMultiExtent = 0x80
+
+	// Rock Ridge NM and SL flags.
+	rrContinue = 0x01
+	rrCurrent  = 0x02
+	rrParent   = 0x04
+	rrRoot     = 0x08
+)
+
+var le = binary.LittleEndian
+
+// FS is an ISO 9660 file system.
+type FS struct {
+	fs.FS
+
+	r         io.ReaderAt
+	label     string
+	joliet    bool
+	rockRidge bool
+	// skip is the number of bytes to skip at the start of each system
+	// use area, from the SUSP SP entry.
+	skip int
+}
+
+func init() {
+	fs.Register("iso9660", func(r io.ReaderAt) (fs.FS, error) { return New(r) })
+}
+
+// New reads the file system in r.
+func New(r io.ReaderAt) (*FS, error) {
+	var pvd, svd []byte
+	for s := int64(firstDescriptor); pvd == nil || s < firstDescriptor+32; s++ {
+		b := make([]byte, sectorSize)
+		if _, err := r.ReadAt(b, s*sectorSize); err != nil {
+			return nil, fmt.Errorf("iso9660: reading volume descriptor: %v", err)
+		}
+		if string(b[1:6]) != "CD001" {
+			return nil, errors.New("iso9660: no volume descriptor")
+		}
+		if b[0] == descTerminator {
+			break
+		}
+		switch {
+		case b[0] == descPrimary && pvd == nil:
+			pvd = b
+		case b[0] == descSupplementary && svd == nil && isJoliet(b):
+			svd = b
+		}
+	}
+	if pvd == nil {
+		return nil, errors.New("iso9660: no primary volume descriptor")
+	}
+	if le.Uint16(pvd[128:]) != sectorSize {
+		return nil, fmt.Errorf("iso9660: block size %d is not supported", le.Uint16(pvd[128:]))
+	}
+
+	f := &FS{r: r, label: strings.TrimRight(string(pvd[40:72]), " ")}
+	root, err := f.root(pvd)
+	if err != nil {
+		return nil, err
+	}
+	// Rock Ridge images have an SP entry in the first entry of the root
+	// directory.
+	first := make([]byte, 255)
+	if _, err := r.ReadAt(first, root.extents[0].off); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("iso9660: reading root directory: %v", err)
+	}
+	if su := systemUse(first); len(su) >= 7 && string(su[0:2]) == "SP" && su[4] == 0xbe && su[5] == 0xef {
+		f.rockRidge = true
+		f.skip = int(su[6])
+	} else if svd != nil {
+		f.joliet = true
+		if root, err = f.root(svd); err != nil {
+			return nil, err
+		}
+	}
+	f.FS = fs.NewFS(root)
+	return f, nil
+}
+
+// isJoliet returns whether the supplementary volume descriptor d has one of
+// the Joliet UCS-2 escape sequences.
+func isJoliet(d []byte) bool {
+	esc := string(d[88:91])
+	return esc == "%/@" || esc == "%/C" || esc == "%/E"
+}
+
+// root returns the root directory of a volume descriptor.
+func (f *FS) root(d []byte) (*node, error) {
+	n, _, err := f.record(d[156:190], "/")
+	return n, err
+}
+
+// Label returns the volume identifier of the primary volume descriptor.
+func (f *FS) Label() string {
+	return f.label
+}
+
+// RockRidge returns whether the file system has Rock Ridge extensions.
+func (f *FS) RockRidge() bool {
+	return f.rockRidge
+}
+
+// Joliet returns whether Joliet names are used.
+func (f *FS) Joliet() bool {
+	return f.joliet
+}
+
+type extent struct {
+	off, size int64
+}
+
+// node is a directory record.
+type node struct {
+	fs      *FS
+	name    string
+	mode    os.FileMode
+	mtime   time.Time
+	extents []extent
+	link    string
+	// more is set if the next record continues this file.
+	more bool
+}
+
+// systemUse returns the system use area of directory record rec.
+func systemUse(rec []byte) []byte {
+	if len(rec) < 34 || int(rec[0]) > len(rec) {
+		return nil
+	}
+	end := 33 + int(rec[32])
+	if rec[32]%2 == 0 {
+		end++
+	}
+	if end > int(rec[0]) {
+		return nil
+	}
+	return rec[end:rec[0]]
+}
+
+// record parses directory record rec. If name is not empty, it is used
+// instead of the name in the record. The returned bool says whether the
+// record is to be left out, as . and .. and relocated directories are.
+func (f *FS) record(rec []byte, name string) (*node, bool, error) {
+	if len(rec) < 34 || int(rec[0]) > len(rec) || 33+int(rec[32]) > int(rec[0]) {
+		return nil, false, errors.New("iso9660: bad directory record")
+	}
+	flags := rec[25]
+	n := &node{
+		fs:      f,
+		name:    name,
+		mode:    0444,
+		mtime:   timestamp(rec[18:25]),
+		extents: []extent{{off: int64(le.Uint32(rec[2:])) * sectorSize, size: int64(le.Uint32(rec[10:]))}},
+		more:    flags&flagMultiExtent != 0,
+	}
+	if flags&flagDirectory != 0 {
+		n.mode = os.ModeDir | 0555
+	}
+	raw := rec[33 : 33+rec[32]]
+	skip := name == "" && len(raw) == 1 && raw[0] <= 1
+	if name == "" {
+		n.name = f.decodeName(raw, flags&flagDirectory != 0)
+	}
+	if !f.rockRidge {
+		return n, skip, nil
+	}
+
+	var (
+		rrName   string
+		hasName  bool
+		comps    []string
+		contComp bool
+	)
+	err := f.susp(systemUse(rec), func(sig string, e []byte) error {
+		switch sig {
+		case "NM":
+			if len(e) < 5 || e[4]&(rrCurrent|rrParent) != 0 {
+				return nil
+			}
+			rrName += string(e[5:])
+			hasName = true
+		case "PX":
+			if len(e) < 12 {
+				return errors.New("iso9660: bad Rock Ridge PX entry")
+			}
+			n.mode = posixMode(le.Uint32(e[4:]))
+		case "SL":
+			if len(e) < 5 {
+				return errors.New("iso9660: bad Rock Ridge SL entry")
+			}
+			for c := e[5:]; len(c) >= 2; {
+				cf, cl := c[0], int(c[1])
+				if 2+cl > len(c) {
+					return errors.New("iso9660: bad Rock Ridge SL component")
+				}
+				s := string(c[2 : 2+cl])
+				switch {
+				case cf&rrCurrent != 0:
+					s = "."
+				case cf&rrParent != 0:
+					s = ".."
+				case cf&rrRoot != 0:
+					s = ""
+				}
+				if contComp && len(comps) > 0 {
+					comps[len(comps)-1] += s
+				} else {
+					comps = append(comps, s)
+				}
+				contComp = cf&rrContinue != 0
+				c = c[2+cl:]
+			}
+		case "RE":
+			// A relocated directory, which is found through its
+			// CL entry.
+			skip = true
+		case "CL":
+			if len(e) < 12 {
+				return errors.New("iso9660: bad Rock Ridge CL entry")
+			}
+			off := int64(le.Uint32(e[4:])) * sectorSize
+			self := make([]byte, 34)
+			if _, err := f.r.ReadAt(self, off); err != nil {
+				return fmt.Errorf("iso9660: reading relocated directory: %v", err)
+			}
+			n.extents = []extent{{off: off, size: int64(le.Uint32(self[10:]))}}
+			n.mode = os.ModeDir | n.mode.Perm()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if hasName && name == "" {
+		n.name = rrName
+	}
+	if comps != nil {
+		n.link = strings.Join(comps, "/")
+		if n.link == "" {
+			n.link = "/"
+		}
+	}
+	return n, skip, nil
+}
+
+// susp calls fn for each System Use Sharing Protocol entry in su, following
+// continuation areas.
+func (f *FS) susp(su []byte, fn func(sig string, e []byte) error) error {
+	if len(su) < f.skip {
+		return nil
+	}
+	su = su[f.skip:]
+	for areas := 0; ; areas++ {
+		var ce []byte
+		for len(su) >= 4 {
+			l := int(su[2])
+			if l < 4 || l > len(su) {
+				break
+			}
+			e := su[:l]
+			su = su[l:]
+			switch sig := string(e[0:2]); sig {
+			case "ST":
+				su = nil
+			case "CE":
+				ce = e
+			default:
+				if err := fn(sig, e); err != nil {
+					return err
+				}
+			}
+		}
+		if ce == nil {
+			return nil
+		}
+		if len(ce) < 28 || areas > 32 {
+			return errors.New("iso9660: bad SUSP continuation area")
+		}
+		size := le.Uint32(ce[20:])
+		if size > sectorSize {
+			return errors.New("iso9660: SUSP continuation area is too big")
+		}
+		su = make([]byte, size)
+		if _, err := f.r.ReadAt(su, int64(le.Uint32(ce[4:]))*sectorSize+int64(le.Uint32(ce[12:]))); err != nil {
+			return fmt.Errorf("iso9660: reading SUSP continuation area: %v", err)
+		}
+	}
+}
+
+// decodeName decodes a Joliet or ISO 9660 file name and removes the version
+// and the dot of names without extension.
+func (f *FS) decodeName(raw []byte, dir bool) string {
+	var name string
+	if f.joliet {
+		u := make([]uint16, len(raw)/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(raw[2*i:])
+		}
+		name = string(utf16.Decode(u))
+	} else {
+		name = string(raw)
+	}
+	if dir {
+		return name
+	}
+	if i := strings.LastIndex(name, ";"); i != -1 {
+		name = name[:i]
+	}
+	return strings.TrimSuffix(name, ".")
+}
+
+// posixMode converts a Rock Ridge PX mode.
+func posixMode(m uint32) os.FileMode {
+	mode := os.FileMode(m & 0777)
+	if m&syscall.S_ISUID != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&syscall.S_ISGID != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&syscall.S_ISVTX != 0 {
+		mode |= os.ModeSticky
+	}
+	switch m & syscall.S_IFMT {
+	case syscall.S_IFDIR:
+		mode |= os.ModeDir
+	case syscall.S_IFLNK:
+		mode |= os.ModeSymlink
+	case syscall.S_IFCHR:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case syscall.S_IFBLK:
+		mode |= os.ModeDevice
+	case syscall.S_IFIFO:
+		mode |= os.ModeNamedPipe
+	case syscall.S_IFSOCK:
+		mode |= os.ModeSocket
+	}
+	return mode
+}
+
+// timestamp converts the recording date of a directory record.
+func timestamp(d []byte) time.Time {
+	if d[0] == 0 && d[1] == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(d[6]))*15*60)
+	return time.Date(1900+int(d[0]), time.Month(d[1]), int(d[2]), int(d[3]), int(d[4]), int(d[5]), 0, zone)
+}
+
+func (n *node) size() int64 {
+	var size int64
+	for _, e := range n.extents {
+		size += e.size
+	}
+	return size
+}
+
+// Info implements fs.Node.Info.
+func (n *node) Info() os.FileInfo {
+	size := n.size()
+	if n.mode.IsDir() {
+		size = 0
+	}
+	return fs.NewFileInfo(n.name, size, n.mode, n.mtime, nil)
+}
+
+// entries returns the entries of directory n.
+func (n *node) entries() ([]*node, error) {
+	size := n.size()
+	if size > 1<<24 {
+		return nil, fmt.Errorf("iso9660: %s: directory is too big", n.name)
+	}
+	d := make([]byte, size)
+	if _, err := n.reader().ReadAt(d, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var ents []*node
+	var prev *node
+	for pos := 0; pos < len(d); {
+		l := int(d[pos])
+		if l == 0 {
+			// Records do not cross sectors; the rest of this
+			// one is padding.
+			pos = (pos/sectorSize + 1) * sectorSize
+			continue
+		}
+		if pos+l > len(d) {
+			return nil, fmt.Errorf("iso9660: %s: directory record exceeds directory", n.name)
+		}
+		e, skip, err := n.fs.record(d[pos:pos+l], "")
+		if err != nil {
+			return nil, err
+		}
+		pos += l
+		if prev != nil && prev.more {
+			// A further extent of a multi-extent file.
+			prev.extents = append(prev.extents, e.extents...)
+			prev.more = e.more
+			continue
+		}
+		if skip {
+			continue
+		}
+		ents = append(ents, e)
+		prev = e
+	}
+	return ents, nil
+}
+
+// Lookup implements fs.Node.Lookup.
+func (n *node) Lookup(name string) (fs.Node, error) {
+	ents, err := n.entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range ents {
+		if e.name == name || (!n.fs.rockRidge && strings.EqualFold(e.name, name)) {
+			return e, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// ReadDir implements fs.Node.ReadDir.
+func (n *node) ReadDir() ([]os.FileInfo, error) {
+	ents, err := n.entries()
+	if err != nil {
+		return nil, err
+	}
+	var fis []os.FileInfo
+	for _, e := range ents {
+		fis = append(fis, e.Info())
+	}
+	return fis, nil
+}
+
+// Readlink implements fs.Node.Readlink.
+func (n *node) Readlink() (string, error) {
+	if n.mode&os.ModeSymlink == 0 {
+		return "", syscall.EINVAL
+	}
+	return n.link, nil
+}
+
+// Open implements fs.Node.Open.
+func (n *node) Open() (fs.File, error) {
+	return fs.NewFile(n.reader(), n.Info()), nil
+}
+
+func (n *node) reader() io.ReaderAt {
+	if len(n.extents) == 1 {
+		return io.NewSectionReader(n.fs.r, n.extents[0].off, n.extents[0].size)
+	}
+	return &reader{r: n.fs.r, extents: n.extents}
+}
+
+// reader reads the extents of a multi-extent file.
+type reader struct {
+	r       io.ReaderAt
+	extents []extent
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *reader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for _, e := range r.extents {
+		if len(p) == n {
+			break
+		}
+		if off >= e.size {
+			off -= e.size
+			continue
+		}
+		end := e.size - off
+		if end > int64(len(p)-n) {
+			end = int64(len(p) - n)
+		}
+		m, err := r.r.ReadAt(p[n:n+int(end)], e.off+off)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		off = 0
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,433 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/u-root/u-root/pkg/fs"
+)
+
+// tnode is a file of a test image.
+type tnode struct {
+	name     string
+	data     string
+	link     string
+	dir      bool
+	children []*tnode
+}
+
+func tree(files, links map[string]string) *tnode {
+	root := &tnode{dir: true}
+	add := func(p string, n *tnode) {
+		cur := root
+		comps := strings.Split(p, "/")
+		for _, c := range comps[:len(comps)-1] {
+			var next *tnode
+			for _, ch := range cur.children {
+				if ch.name == c {
+					next = ch
+				}
+			}
+			if next == nil {
+				next = &tnode{name: c, dir: true}
+				cur.children = append(cur.children, next)
+			}
+			cur = next
+		}
+		n.name = comps[len(comps)-1]
+		cur.children = append(cur.children, n)
+	}
+	for p, d := range files {
+		add(p, &tnode{data: d})
+	}
+	for p, l := range links {
+		add(p, &tnode{link: l})
+	}
+	var sortTree func(n *tnode)
+	sortTree = func(n *tnode) {
+		sort.Slice(n.children, func(i, j int) bool { return n.children[i].name < n.children[j].name })
+		for _, c := range n.children {
+			sortTree(c)
+		}
+	}
+	sortTree(root)
+	return root
+}
+
+type extent32 struct {
+	lba, size uint32
+}
+
+// builder makes ISO 9660 images for the tests, as mkisofs would.
+type builder struct {
+	img  []byte
+	data map[*tnode]extent32
+	dirs map[*tnode]extent32
+	// final is set when the directories are written, rather than
+	// only measured.
+	final bool
+}
+
+var date = []byte{118, 8, 10, 12, 34, 56, 0}
+
+func both16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+func both32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+func (b *builder) alloc(d []byte) uint32 {
+	lba := uint32(len(b.img) / sectorSize)
+	b.img = append(b.img, d...)
+	for len(b.img)%sectorSize != 0 {
+		b.img = append(b.img, 0)
+	}
+	return lba
+}
+
+func record(name []byte, e extent32, flags byte, su []byte) []byte {
+	l := 33 + len(name)
+	if len(name)%2 == 0 {
+		l++
+	}
+	r := append(make([]byte, l), su...)
+	if len(r)%2 == 1 {
+		r = append(r, 0)
+	}
+	r[0] = byte(len(r))
+	both32(r[2:], e.lba)
+	both32(r[10:], e.size)
+	copy(r[18:], date)
+	r[25] = flags
+	both16(r[28:], 1)
+	r[32] = byte(len(name))
+	copy(r[33:], name)
+	return r
+}
+
+func px(mode uint32) []byte {
+	e := []byte{'P', 'X', 36, 1}
+	e = append(e, make([]byte, 32)...)
+	both32(e[4:], mode)
+	both32(e[12:], 1)
+	return e
+}
+
+// sl returns an SL entry for target.
+func sl(target string) []byte {
+	e := []byte{'S', 'L', 0, 1, 0}
+	comps := strings.Split(target, "/")
+	if strings.HasPrefix(target, "/") {
+		e = append(e, rrRoot, 0)
+		comps = comps[1:]
+	}
+	for _, c := range comps {
+		switch c {
+		case ".":
+			e = append(e, rrCurrent, 0)
+		case "..":
+			e = append(e, rrParent, 0)
+		default:
+			e = append(append(e, 0, byte(len(c))), c...)
+		}
+	}
+	e[2] = byte(len(e))
+	return e
+}
+
+func (b *builder) name(n *tnode, joliet bool) []byte {
+	name := n.name
+	if !n.dir {
+		if !joliet && !strings.Contains(name, ".") {
+			name += "."
+		}
+		name += ";1"
+	}
+	if !joliet {
+		return []byte(strings.ToUpper(name))
+	}
+	var d []byte
+	for _, u := range utf16.Encode([]rune(name)) {
+		d = append(d, byte(u>>8), byte(u))
+	}
+	return d
+}
+
+// susp returns the system use area of a Rock Ridge record, moving it to a
+// continuation area if it is long.
+func (b *builder) susp(su []byte) []byte {
+	if len(su) < 200 {
+		return su
+	}
+	ce := []byte{'C', 'E', 28, 1}
+	ce = append(ce, make([]byte, 24)...)
+	if b.final {
+		both32(ce[4:], b.alloc(su))
+	}
+	both32(ce[20:], uint32(len(su)))
+	return ce
+}
+
+func (b *builder) dir(n, parent *tnode, rr, joliet bool) []byte {
+	var recs [][]byte
+	var su []byte
+	if rr {
+		su = px(040555)
+		if n == parent {
+			su = append([]byte{'S', 'P', 7, 1, 0xbe, 0xef, 0}, su...)
+		}
+	}
+	recs = append(recs, record([]byte{0}, b.dirs[n], flagDirectory, su))
+	recs = append(recs, record([]byte{1}, b.dirs[parent], flagDirectory, nil))
+	for _, c := range n.children {
+		var su []byte
+		var e extent32
+		var flags byte
+		switch {
+		case c.dir:
+			e, flags = b.dirs[c], flagDirectory
+			su = px(040555)
+		case c.link != "":
+			su = append(px(0120777), sl(c.link)...)
+		default:
+			e = b.data[c]
+			su = px(0100444)
+		}
+		if rr {
+			su = append(su, 'N', 'M', byte(5+len(c.name)), 1, 0)
+			su = b.susp(append(su, c.name...))
+		} else {
+			su = nil
+		}
+		if c.name == "multi" {
+			// Two extents of 2048 bytes and the rest.
+			recs = append(recs, record(b.name(c, joliet), extent32{e.lba, sectorSize}, flagMultiExtent, su))
+			e = extent32{e.lba + 1, e.size - sectorSize}
+		}
+		recs = append(recs, record(b.name(c, joliet), e, flags, su))
+	}
+
+	var d []byte
+	for _, r := range recs {
+		if len(d)%sectorSize+len(r) > sectorSize {
+			d = append(d, make([]byte, sectorSize-len(d)%sectorSize)...)
+		}
+		d = append(d, r...)
+	}
+	return d
+}
+
+// tree writes the directories of root and returns the extent of root.
+func (b *builder) tree(root *tnode, rr, joliet bool) extent32 {
+	var walk func(n, parent *tnode, f func(n, parent *tnode))
+	walk = func(n, parent *tnode, f func(n, parent *tnode)) {
+		f(n, parent)
+		for _, c := range n.children {
+			if c.dir {
+				walk(c, n, f)
+			}
+		}
+	}
+	b.final = false
+	walk(root, root, func(n, parent *tnode) {
+		d := b.dir(n, parent, rr, joliet)
+		size := uint32((len(d) + sectorSize - 1) / sectorSize * sectorSize)
+		b.dirs[n] = extent32{b.alloc(make([]byte, size)), size}
+	})
+	b.final = true
+	walk(root, root, func(n, parent *tnode) {
+		// Writing the directory can allocate continuation areas.
+		d := b.dir(n, parent, rr, joliet)
+		copy(b.img[b.dirs[n].lba*sectorSize:], d)
+	})
+	return b.dirs[root]
+}
+
+func (b *builder) descriptor(lba uint32, typ byte, label string, root extent32) {
+	d := b.img[lba*sectorSize:]
+	d[0] = typ
+	copy(d[1:], "CD001\x01")
+	copy(d[40:72], label+strings.Repeat(" ", 32-len(label)))
+	if typ == descSupplementary {
+		copy(d[88:], "%/E")
+	}
+	both16(d[120:], 1)
+	both16(d[124:], 1)
+	both16(d[128:], sectorSize)
+	copy(d[156:], record([]byte{0}, root, flagDirectory, nil))
+}
+
+func build(files, links map[string]string, rr, joliet bool) []byte {
+	b := &builder{
+		img:  make([]byte, 19*sectorSize),
+		data: map[*tnode]extent32{},
+		dirs: map[*tnode]extent32{},
+	}
+	root := tree(files, links)
+	var walk func(n *tnode)
+	walk = func(n *tnode) {
+		for _, c := range n.children {
+			if c.dir {
+				walk(c)
+			} else if c.link == "" {
+				b.data[c] = extent32{b.alloc([]byte(c.data)), uint32(len(c.data))}
+			}
+		}
+	}
+	walk(root)
+
+	b.descriptor(16, descPrimary, "TESTISO", b.tree(root, rr, false))
+	term := uint32(17)
+	if joliet {
+		b.dirs = map[*tnode]extent32{}
+		b.descriptor(17, descSupplementary, "", b.tree(root, false, true))
+		term++
+	}
+	b.img[term*sectorSize] = descTerminator
+	copy(b.img[term*sectorSize+1:], "CD001\x01")
+	return b.img
+}
+
+func kernel() string {
+	b := make([]byte, 10000)
+	for i := range b {
+		b[i] = byte(i * 7 % 251)
+	}
+	return string(b)
+}
+
+func TestISO9660(t *testing.T) {
+	grub := "menuentry \"Linux\" {\n\tlinux /boot/vmlinuz\n}\n"
+	files := map[string]string{
+		"boot/grub/grub.cfg":    grub,
+		"boot/vmlinuz":          kernel(),
+		"isolinux/isolinux.cfg": "default linux\n",
+		"Mixed.Case":            "mixed",
+		"empty":                 "",
+		"multi":                 kernel(),
+	}
+	mtime := time.Date(2018, 8, 10, 12, 34, 56, 0, time.UTC)
+
+	for _, tt := range []struct {
+		name       string
+		rr, joliet bool
+		links      map[string]string
+		root       string
+		cfg        string
+		notFound   string
+	}{
+		{
+			name:     "plain",
+			root:     "BOOT|EMPTY|ISOLINUX|MIXED.CASE|MULTI",
+			cfg:      "GRUB.CFG",
+			notFound: "boot/grub/grub.cfg;2",
+		},
+		{
+			name: "rockridge",
+			rr:   true,
+			links: map[string]string{
+				"vmlinuz": "boot/vmlinuz",
+				// Long enough for a continuation area.
+				"grub": "/boot/grub/" + strings.Repeat("./", 80) + "grub.cfg",
+				"loop": "../loop",
+			},
+			root:     "Mixed.Case|boot|empty|grub|isolinux|loop|multi|vmlinuz",
+			cfg:      "grub.cfg",
+			notFound: "BOOT/GRUB/GRUB.CFG",
+		},
+		{
+			name:     "joliet",
+			joliet:   true,
+			root:     "Mixed.Case|boot|empty|isolinux|multi",
+			cfg:      "grub.cfg",
+			notFound: "boot/grub/grub.cfg;1",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			img := build(files, tt.links, tt.rr, tt.joliet)
+			f, err := New(bytes.NewReader(img))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Label() != "TESTISO" || f.RockRidge() != tt.rr || f.Joliet() != tt.joliet {
+				t.Errorf("Got label %q, Rock Ridge %v, Joliet %v, want TESTISO, %v, %v", f.Label(), f.RockRidge(), f.Joliet(), tt.rr, tt.joliet)
+			}
+			for name, want := range files {
+				got, err := fs.ReadFile(f, name)
+				if err != nil {
+					t.Errorf("ReadFile(%q): %v", name, err)
+					continue
+				}
+				if string(got) != want {
+					t.Errorf("ReadFile(%q) returned %d different bytes, want %d", name, len(got), len(want))
+				}
+			}
+			if !tt.rr {
+				if got, err := fs.ReadFile(f, "BOOT/Grub/GRUB.CFG"); err != nil || string(got) != grub {
+					t.Errorf("ReadFile(BOOT/Grub/GRUB.CFG) = %q, %v, want %q", got, err, grub)
+				}
+			}
+			if _, err := f.Open(tt.notFound); !os.IsNotExist(err) {
+				t.Errorf("Open(%q): got %v, want a does not exist error", tt.notFound, err)
+			}
+
+			fis, err := f.ReadDir("/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, fi := range fis {
+				names = append(names, fi.Name())
+			}
+			if n := strings.Join(names, "|"); n != tt.root {
+				t.Errorf("ReadDir(/) = %s, want %s", n, tt.root)
+			}
+
+			fi, err := f.Stat("boot/grub/grub.cfg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Name() != tt.cfg || fi.Size() != int64(len(grub)) || fi.Mode() != 0444 || !fi.ModTime().Equal(mtime) {
+				t.Errorf("Stat(boot/grub/grub.cfg) = %q, %d bytes, mode %v, time %v", fi.Name(), fi.Size(), fi.Mode(), fi.ModTime())
+			}
+			if fi, err := f.Stat("boot"); err != nil || !fi.IsDir() {
+				t.Errorf("Stat(boot) = %v, %v, want a directory", fi, err)
+			}
+
+			if tt.rr {
+				if got, err := fs.ReadFile(f, "grub"); err != nil || string(got) != grub {
+					t.Errorf("ReadFile(grub) = %q, %v, want %q", got, err, grub)
+				}
+				if fi, err := f.Stat("vmlinuz"); err != nil || fi.Name() != "vmlinuz" || fi.Size() != int64(len(kernel())) {
+					t.Errorf("Stat(vmlinuz) = %v, %v, want the 10000 byte boot/vmlinuz", fi, err)
+				}
+				if _, err := f.Open("loop"); err == nil || !strings.Contains(err.Error(), "too many levels") {
+					t.Errorf("Open(loop): got %v, want too many levels of symbolic links", err)
+				}
+			}
+			if _, typ, err := fs.Probe(bytes.NewReader(img)); err != nil || typ != "iso9660" {
+				t.Errorf("Probe() = %q, %v, want iso9660", typ, err)
+			}
+		})
+	}
+}
+
+func TestNotISO9660(t *testing.T) {
+	if _, err := New(bytes.NewReader(make([]byte, 64<<10))); err == nil {
+		t.Errorf("New(zeros): got nil, want error")
+	}
+}
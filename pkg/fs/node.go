@@ -0,0 +1,137 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Node is a file of a file system image. File systems implement Node and
+// use NewFS to get an FS, which takes care of walking paths and following
+// symbolic links.
+type Node interface {
+	// Info returns information about the node, not following symbolic
+	// links.
+	Info() os.FileInfo
+	// Lookup returns the entry called name of a directory, or an error
+	// satisfying os.IsNotExist if there is none.
+	Lookup(name string) (Node, error)
+	// ReadDir returns the entries of a directory, other than . and ..
+	ReadDir() ([]os.FileInfo, error)
+	// Readlink returns the target of a symbolic link.
+	Readlink() (string, error)
+	// Open opens a regular file.
+	Open() (File, error)
+}
+
+type nodeFS struct {
+	root Node
+}
+
+// NewFS returns an FS for the tree of Nodes starting at root.
+func NewFS(root Node) FS {
+	return &nodeFS{root: root}
+}
+
+// Resolve returns the node called name in the tree starting at root. If
+// follow is true, a symbolic link at the end of name is followed, too.
+func Resolve(root Node, name string, follow bool) (Node, error) {
+	var (
+		cur   = root
+		dirs  []Node
+		links int
+		comps = strings.Split(name, "/")
+	)
+	for len(comps) > 0 {
+		c := comps[0]
+		comps = comps[1:]
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			if len(dirs) > 0 {
+				cur, dirs = dirs[len(dirs)-1], dirs[:len(dirs)-1]
+			}
+			continue
+		}
+		if !cur.Info().IsDir() {
+			return nil, syscall.ENOTDIR
+		}
+		n, err := cur.Lookup(c)
+		if err != nil {
+			return nil, err
+		}
+		if n.Info().Mode()&os.ModeSymlink != 0 && (follow || len(comps) > 0) {
+			if links++; links > MaxSymlinks {
+				return nil, syscall.ELOOP
+			}
+			target, err := n.Readlink()
+			if err != nil {
+				return nil, err
+			}
+			if strings.HasPrefix(target, "/") {
+				cur, dirs = root, nil
+			}
+			comps = append(strings.Split(target, "/"), comps...)
+			continue
+		}
+		dirs = append(dirs, cur)
+		cur = n
+	}
+	return cur, nil
+}
+
+func (fsys *nodeFS) resolve(op, name string) (Node, error) {
+	n, err := Resolve(fsys.root, name, true)
+	if err != nil {
+		return nil, PathError(op, name, err)
+	}
+	return n, nil
+}
+
+// Open implements FS.Open.
+func (fsys *nodeFS) Open(name string) (File, error) {
+	n, err := fsys.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.Info().IsDir() {
+		return nil, PathError("open", name, syscall.EISDIR)
+	}
+	f, err := n.Open()
+	if err != nil {
+		return nil, PathError("open", name, err)
+	}
+	return f, nil
+}
+
+// Stat implements FS.Stat.
+func (fsys *nodeFS) Stat(name string) (os.FileInfo, error) {
+	n, err := fsys.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return n.Info(), nil
+}
+
+// ReadDir implements FS.ReadDir.
+func (fsys *nodeFS) ReadDir(name string) ([]os.FileInfo, error) {
+	n, err := fsys.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !n.Info().IsDir() {
+		return nil, PathError("readdir", name, syscall.ENOTDIR)
+	}
+	fis, err := n.ReadDir()
+	if err != nil {
+		return nil, PathError("readdir", name, err)
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	return fis, nil
+}
@@ -0,0 +1,871 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package squashfs reads and writes squashfs 4.0 file systems, such as
+// Tiny Core tcz extensions.
+//
+// Data and metadata compressed with gzip, lzma, xz and zstd can be read.
+// Extended attributes are ignored.
+package squashfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/fs"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Compression is the compressor of a file system.
+type Compression uint16
+
+const (
+	Gzip Compression = 1
+	LZMA Compression = 2
+	LZO  Compression = 3
+	XZ   Compression = 4
+	LZ4  Compression = 5
+	Zstd Compression = 6
+)
+
+func (c Compression) String() string {
+	switch c {
+	case Gzip:
+		return "gzip"
+	case LZMA:
+		return "lzma"
+	case LZO:
+		return "lzo"
+	case XZ:
+		return "xz"
+	case LZ4:
+		return "lz4"
+	case Zstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("compression %d", uint16(c))
+}
+
+const (
+	magic          = 0x73717368
+	superblockSize = 96
+	// metaSize is the uncompressed size of metadata blocks.
+	metaSize = 8192
+	// metaUncompressed flags metadata blocks stored as is.
+	metaUncompressed = 0x8000
+	// dataUncompressed flags data blocks and fragments stored as is.
+	dataUncompressed = 1 << 24
+	noFragment       = 0xffffffff
+	noTable          = 0xffffffffffffffff
+	noXattr          = 0xffffffff
+
+	flagNoFragments = 0x10
+	flagNoXattrs    = 0x200
+
+	// Inode types. The extended ones are the basic ones plus 7.
+	typeDir     = 1
+	typeFile    = 2
+	typeSymlink = 3
+	typeBlock   = 4
+	typeChar    = 5
+	typeFIFO    = 6
+	typeSocket  = 7
+	typeExtDir  = 8
+	typeExtFile = 9
+
+	fragmentEntrySize = 16
+	// maxCacheBlocks bounds the metadata cache.
+	maxCacheBlocks = 64
+)
+
+var le = binary.LittleEndian
+
+// fragment is an entry of the fragment table.
+type fragment struct {
+	start int64
+	size  uint32
+}
+
+// FS is a squashfs file system.
+type FS struct {
+	fs.FS
+
+	r           io.ReaderAt
+	comp        Compression
+	blockSize   uint32
+	inodeCount  uint32
+	mtime       uint32
+	inodeTable  int64
+	dirTable    int64
+	bytesUsed   int64
+	ids         []uint32
+	fragments   []fragment
+	root        *inode
+	zstdDecoder *zstd.Decoder
+
+	mu    sync.Mutex
+	cache map[int64]metaBlock
+}
+
+// metaBlock is a decompressed metadata block, and where the next one
+// starts.
+type metaBlock struct {
+	data []byte
+	next int64
+}
+
+func init() {
+	fs.Register("squashfs", func(r io.ReaderAt) (fs.FS, error) { return New(r) })
+}
+
+// New reads the file system in r.
+func New(r io.ReaderAt) (*FS, error) {
+	sb := make([]byte, superblockSize)
+	if _, err := r.ReadAt(sb, 0); err != nil {
+		return nil, fmt.Errorf("squashfs: reading superblock: %v", err)
+	}
+	if le.Uint32(sb[0:]) != magic {
+		return nil, fmt.Errorf("squashfs: bad magic %#08x", le.Uint32(sb[0:]))
+	}
+	if major, minor := le.Uint16(sb[28:]), le.Uint16(sb[30:]); major != 4 || minor != 0 {
+		return nil, fmt.Errorf("squashfs: version %d.%d is not 4.0", major, minor)
+	}
+	f := &FS{
+		r:          r,
+		inodeCount: le.Uint32(sb[4:]),
+		mtime:      le.Uint32(sb[8:]),
+		blockSize:  le.Uint32(sb[12:]),
+		comp:       Compression(le.Uint16(sb[20:])),
+		bytesUsed:  int64(le.Uint64(sb[40:])),
+		inodeTable: int64(le.Uint64(sb[64:])),
+		dirTable:   int64(le.Uint64(sb[72:])),
+		cache:      map[int64]metaBlock{},
+	}
+	if f.blockSize < 4096 || f.blockSize > 1<<20 || f.blockSize&(f.blockSize-1) != 0 || 1<<le.Uint16(sb[22:]) != f.blockSize {
+		return nil, fmt.Errorf("squashfs: bad block size %d", f.blockSize)
+	}
+	switch f.comp {
+	case Gzip, LZMA, XZ:
+	case Zstd:
+		d, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		f.zstdDecoder = d
+	default:
+		return nil, fmt.Errorf("squashfs: %v is not supported", f.comp)
+	}
+	if f.inodeTable >= f.dirTable || f.dirTable >= f.bytesUsed {
+		return nil, errors.New("squashfs: bad table offsets")
+	}
+
+	idTable := int64(le.Uint64(sb[48:]))
+	ids, err := f.table(idTable, int(le.Uint16(sb[26:])), 4)
+	if err != nil {
+		return nil, fmt.Errorf("squashfs: reading id table: %v", err)
+	}
+	for i := 0; i < len(ids); i += 4 {
+		f.ids = append(f.ids, le.Uint32(ids[i:]))
+	}
+
+	flags := le.Uint16(sb[24:])
+	if frags := le.Uint32(sb[16:]); flags&flagNoFragments == 0 && frags > 0 {
+		t, err := f.table(int64(le.Uint64(sb[80:])), int(frags), fragmentEntrySize)
+		if err != nil {
+			return nil, fmt.Errorf("squashfs: reading fragment table: %v", err)
+		}
+		for i := 0; i < len(t); i += fragmentEntrySize {
+			f.fragments = append(f.fragments, fragment{start: int64(le.Uint64(t[i:])), size: le.Uint32(t[i+8:])})
+		}
+	}
+
+	root, err := f.inode(le.Uint64(sb[32:]), "/")
+	if err != nil {
+		return nil, err
+	}
+	if !root.Info().IsDir() {
+		return nil, errors.New("squashfs: root inode is not a directory")
+	}
+	f.root = root
+	f.FS = fs.NewFS(root)
+	return f, nil
+}
+
+// Compression returns the compressor of the file system.
+func (f *FS) Compression() Compression {
+	return f.comp
+}
+
+// BlockSize returns the data block size.
+func (f *FS) BlockSize() int {
+	return int(f.blockSize)
+}
+
+// ModTime returns the time the file system was made.
+func (f *FS) ModTime() time.Time {
+	return time.Unix(int64(f.mtime), 0)
+}
+
+// decompress decompresses b, which is at most max bytes uncompressed.
+func (f *FS) decompress(b []byte, max int) ([]byte, error) {
+	var r io.Reader
+	var err error
+	switch f.comp {
+	case Gzip:
+		r, err = zlib.NewReader(bytes.NewReader(b))
+	case LZMA:
+		r, err = lzma.NewReader(bytes.NewReader(b))
+	case XZ:
+		r, err = xz.NewReader(bytes.NewReader(b))
+	case Zstd:
+		d, err := f.zstdDecoder.DecodeAll(b, make([]byte, 0, max))
+		if err == nil && len(d) > max {
+			err = fmt.Errorf("%d bytes are more than %d", len(d), max)
+		}
+		return d, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	d, err := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err == nil && len(d) > max {
+		err = fmt.Errorf("%d bytes are more than %d", len(d), max)
+	}
+	return d, err
+}
+
+// metaBlock reads the metadata block at pos.
+func (f *FS) metaBlock(pos int64) (metaBlock, error) {
+	f.mu.Lock()
+	m, ok := f.cache[pos]
+	f.mu.Unlock()
+	if ok {
+		return m, nil
+	}
+	var h [2]byte
+	if _, err := f.r.ReadAt(h[:], pos); err != nil {
+		return m, fmt.Errorf("squashfs: reading metadata block at %d: %v", pos, err)
+	}
+	size := int64(le.Uint16(h[:]) &^ metaUncompressed)
+	if size == 0 || size > metaSize {
+		return m, fmt.Errorf("squashfs: metadata block at %d has bad size %d", pos, size)
+	}
+	b := make([]byte, size)
+	if _, err := f.r.ReadAt(b, pos+2); err != nil {
+		return m, fmt.Errorf("squashfs: reading metadata block at %d: %v", pos, err)
+	}
+	if le.Uint16(h[:])&metaUncompressed == 0 {
+		var err error
+		if b, err = f.decompress(b, metaSize); err != nil {
+			return m, fmt.Errorf("squashfs: metadata block at %d: %v", pos, err)
+		}
+	}
+	m = metaBlock{data: b, next: pos + 2 + size}
+	f.mu.Lock()
+	if len(f.cache) >= maxCacheBlocks {
+		for k := range f.cache {
+			delete(f.cache, k)
+			break
+		}
+	}
+	f.cache[pos] = m
+	f.mu.Unlock()
+	return m, nil
+}
+
+// metaReader reads metadata that continues across blocks.
+type metaReader struct {
+	fs   *FS
+	buf  []byte
+	next int64
+}
+
+// metaReader returns a reader at offset off of the metadata block at pos.
+func (f *FS) metaReader(pos int64, off int) (*metaReader, error) {
+	m, err := f.metaBlock(pos)
+	if err != nil {
+		return nil, err
+	}
+	if off > len(m.data) {
+		return nil, fmt.Errorf("squashfs: offset %d is beyond metadata block at %d", off, pos)
+	}
+	return &metaReader{fs: f, buf: m.data[off:], next: m.next}, nil
+}
+
+// Read implements io.Reader.
+func (r *metaReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.next >= r.fs.bytesUsed {
+			return 0, io.ErrUnexpectedEOF
+		}
+		m, err := r.fs.metaBlock(r.next)
+		if err != nil {
+			return 0, err
+		}
+		r.buf, r.next = m.data, m.next
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// read returns the next n bytes.
+func (r *metaReader) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// table reads n entries of size bytes from a table whose metadata blocks
+// are listed at pos.
+func (f *FS) table(pos int64, n, size int) ([]byte, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	blocks := (n*size + metaSize - 1) / metaSize
+	index := make([]byte, 8*blocks)
+	if _, err := f.r.ReadAt(index, pos); err != nil {
+		return nil, err
+	}
+	var t []byte
+	for i := 0; i < blocks; i++ {
+		m, err := f.metaBlock(int64(le.Uint64(index[8*i:])))
+		if err != nil {
+			return nil, err
+		}
+		t = append(t, m.data...)
+	}
+	if len(t) < n*size {
+		return nil, fmt.Errorf("%d bytes for %d entries of %d bytes", len(t), n, size)
+	}
+	return t[:n*size], nil
+}
+
+// inode is an inode, and the name it was found under.
+type inode struct {
+	fs    *FS
+	ref   uint64
+	name  string
+	typ   uint16
+	perm  uint16
+	uid   uint32
+	gid   uint32
+	mtime uint32
+	ino   uint32
+	nlink uint32
+	size  uint64
+
+	// Directories.
+	dirBlock  uint32
+	dirOffset uint16
+
+	// Regular files.
+	blocksStart int64
+	blockSizes  []uint32
+	frag        uint32
+	fragOffset  uint32
+
+	// Symbolic links and devices.
+	target string
+	rdev   uint32
+}
+
+func (f *FS) id(i uint16) (uint32, error) {
+	if int(i) >= len(f.ids) {
+		return 0, fmt.Errorf("squashfs: bad id index %d", i)
+	}
+	return f.ids[i], nil
+}
+
+// inode reads the inode that ref refers to.
+func (f *FS) inode(ref uint64, name string) (*inode, error) {
+	r, err := f.metaReader(f.inodeTable+int64(ref>>16), int(ref&0xffff))
+	if err != nil {
+		return nil, err
+	}
+	h, err := r.read(16)
+	if err != nil {
+		return nil, fmt.Errorf("squashfs: reading inode %#x: %v", ref, err)
+	}
+	n := &inode{
+		fs:    f,
+		ref:   ref,
+		name:  name,
+		typ:   le.Uint16(h[0:]),
+		perm:  le.Uint16(h[2:]) & 07777,
+		mtime: le.Uint32(h[8:]),
+		ino:   le.Uint32(h[12:]),
+		nlink: 1,
+	}
+	if n.uid, err = f.id(le.Uint16(h[4:])); err != nil {
+		return nil, err
+	}
+	if n.gid, err = f.id(le.Uint16(h[6:])); err != nil {
+		return nil, err
+	}
+	if err := n.read(r); err != nil {
+		return nil, fmt.Errorf("squashfs: reading inode %d: %v", n.ino, err)
+	}
+	return n, nil
+}
+
+// read reads the type specific part of n.
+func (n *inode) read(r *metaReader) error {
+	var sizes uint64
+	switch n.typ {
+	case typeDir:
+		b, err := r.read(16)
+		if err != nil {
+			return err
+		}
+		n.dirBlock = le.Uint32(b[0:])
+		n.nlink = le.Uint32(b[4:])
+		n.size = uint64(le.Uint16(b[8:]))
+		n.dirOffset = le.Uint16(b[10:])
+	case typeExtDir:
+		b, err := r.read(24)
+		if err != nil {
+			return err
+		}
+		n.nlink = le.Uint32(b[0:])
+		n.size = uint64(le.Uint32(b[4:]))
+		n.dirBlock = le.Uint32(b[8:])
+		n.dirOffset = le.Uint16(b[18:])
+		// The directory index that follows is only a shortcut.
+	case typeFile:
+		b, err := r.read(16)
+		if err != nil {
+			return err
+		}
+		n.blocksStart = int64(le.Uint32(b[0:]))
+		n.frag = le.Uint32(b[4:])
+		n.fragOffset = le.Uint32(b[8:])
+		n.size = uint64(le.Uint32(b[12:]))
+		sizes = n.numBlocks()
+	case typeExtFile:
+		b, err := r.read(40)
+		if err != nil {
+			return err
+		}
+		n.blocksStart = int64(le.Uint64(b[0:]))
+		n.size = le.Uint64(b[8:])
+		n.nlink = le.Uint32(b[24:])
+		n.frag = le.Uint32(b[28:])
+		n.fragOffset = le.Uint32(b[32:])
+		sizes = n.numBlocks()
+	case typeSymlink, typeSymlink + 7:
+		b, err := r.read(8)
+		if err != nil {
+			return err
+		}
+		n.nlink = le.Uint32(b[0:])
+		size := le.Uint32(b[4:])
+		if size > 4096 {
+			return fmt.Errorf("symbolic link target of %d bytes", size)
+		}
+		t, err := r.read(int(size))
+		if err != nil {
+			return err
+		}
+		n.target, n.size = string(t), uint64(size)
+	case typeBlock, typeChar, typeBlock + 7, typeChar + 7:
+		b, err := r.read(8)
+		if err != nil {
+			return err
+		}
+		n.nlink = le.Uint32(b[0:])
+		n.rdev = le.Uint32(b[4:])
+	case typeFIFO, typeSocket, typeFIFO + 7, typeSocket + 7:
+		b, err := r.read(4)
+		if err != nil {
+			return err
+		}
+		n.nlink = le.Uint32(b[0:])
+	default:
+		return fmt.Errorf("bad type %d", n.typ)
+	}
+	if sizes > 0 {
+		// The sizes are 4 bytes each, and in the image.
+		if sizes > uint64(n.fs.bytesUsed)/4 {
+			return fmt.Errorf("%d blocks for %d bytes do not fit in the file system", sizes, n.size)
+		}
+		if sizes*uint64(n.fs.blockSize) > n.size+uint64(n.fs.blockSize) {
+			return fmt.Errorf("%d blocks for %d bytes", sizes, n.size)
+		}
+		// Read them a metadata block's worth at a time, so that a
+		// bad inode runs out of metadata before it gets memory.
+		for left := sizes; left > 0; {
+			c := left
+			if c > metaSize/4 {
+				c = metaSize / 4
+			}
+			b, err := r.read(4 * int(c))
+			if err != nil {
+				return err
+			}
+			for i := 0; i < len(b); i += 4 {
+				n.blockSizes = append(n.blockSizes, le.Uint32(b[i:]))
+			}
+			left -= c
+		}
+	}
+	return nil
+}
+
+// numBlocks returns the number of full data blocks of a file. The tail is
+// in a fragment, if it has one.
+func (n *inode) numBlocks() uint64 {
+	bs := uint64(n.fs.blockSize)
+	b := n.size / bs
+	if n.frag == noFragment && n.size%bs != 0 {
+		b++
+	}
+	return b
+}
+
+// basicType returns the type of n without the extended flavour.
+func (n *inode) basicType() uint16 {
+	if n.typ > typeSocket {
+		return n.typ - 7
+	}
+	return n.typ
+}
+
+// Mode returns the Linux mode of n.
+func (n *inode) Mode() uint64 {
+	t := map[uint16]uint64{
+		typeDir:     syscall.S_IFDIR,
+		typeFile:    syscall.S_IFREG,
+		typeSymlink: syscall.S_IFLNK,
+		typeBlock:   syscall.S_IFBLK,
+		typeChar:    syscall.S_IFCHR,
+		typeFIFO:    syscall.S_IFIFO,
+		typeSocket:  syscall.S_IFSOCK,
+	}[n.basicType()]
+	return t | uint64(n.perm)
+}
+
+func fileMode(n *inode) os.FileMode {
+	mode := os.FileMode(n.perm & 0777)
+	if n.perm&syscall.S_ISUID != 0 {
+		mode |= os.ModeSetuid
+	}
+	if n.perm&syscall.S_ISGID != 0 {
+		mode |= os.ModeSetgid
+	}
+	if n.perm&syscall.S_ISVTX != 0 {
+		mode |= os.ModeSticky
+	}
+	switch n.basicType() {
+	case typeDir:
+		mode |= os.ModeDir
+	case typeSymlink:
+		mode |= os.ModeSymlink
+	case typeChar:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case typeBlock:
+		mode |= os.ModeDevice
+	case typeFIFO:
+		mode |= os.ModeNamedPipe
+	case typeSocket:
+		mode |= os.ModeSocket
+	}
+	return mode
+}
+
+// Info implements fs.Node.Info. Sys returns the inode number.
+func (n *inode) Info() os.FileInfo {
+	size := int64(n.size)
+	if n.basicType() == typeDir {
+		// The size of directories includes 3 bytes for . and ..
+		size = 0
+	}
+	return fs.NewFileInfo(n.name, size, fileMode(n), time.Unix(int64(n.mtime), 0), n.ino)
+}
+
+// dirent is a directory entry.
+type dirent struct {
+	name string
+	ref  uint64
+}
+
+func (n *inode) entries() ([]dirent, error) {
+	if n.basicType() != typeDir {
+		return nil, syscall.ENOTDIR
+	}
+	if n.size <= 3 {
+		return nil, nil
+	}
+	r, err := n.fs.metaReader(n.fs.dirTable+int64(n.dirBlock), int(n.dirOffset))
+	if err != nil {
+		return nil, err
+	}
+	var ents []dirent
+	for left := int64(n.size) - 3; left > 0; {
+		h, err := r.read(12)
+		if err != nil {
+			return nil, fmt.Errorf("squashfs: directory %d: %v", n.ino, err)
+		}
+		count, start := le.Uint32(h[0:])+1, le.Uint32(h[4:])
+		if count > 256 {
+			return nil, fmt.Errorf("squashfs: directory %d: header with %d entries", n.ino, count)
+		}
+		left -= 12
+		for i := uint32(0); i < count; i++ {
+			e, err := r.read(8)
+			if err != nil {
+				return nil, fmt.Errorf("squashfs: directory %d: %v", n.ino, err)
+			}
+			name, err := r.read(int(le.Uint16(e[6:])) + 1)
+			if err != nil {
+				return nil, fmt.Errorf("squashfs: directory %d: %v", n.ino, err)
+			}
+			if !validName(string(name)) {
+				return nil, fmt.Errorf("squashfs: directory %d: bad name %q", n.ino, name)
+			}
+			ents = append(ents, dirent{name: string(name), ref: uint64(start)<<16 | uint64(le.Uint16(e[0:]))})
+			left -= 8 + int64(len(name))
+		}
+	}
+	return ents, nil
+}
+
+// validName returns whether name can name a directory entry.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\x00")
+}
+
+// Lookup implements fs.Node.Lookup.
+func (n *inode) Lookup(name string) (fs.Node, error) {
+	ents, err := n.entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range ents {
+		if e.name == name {
+			return n.fs.inode(e.ref, e.name)
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func (n *inode) children() ([]*inode, error) {
+	ents, err := n.entries()
+	if err != nil {
+		return nil, err
+	}
+	var c []*inode
+	for _, e := range ents {
+		i, err := n.fs.inode(e.ref, e.name)
+		if err != nil {
+			return nil, err
+		}
+		c = append(c, i)
+	}
+	return c, nil
+}
+
+// ReadDir implements fs.Node.ReadDir.
+func (n *inode) ReadDir() ([]os.FileInfo, error) {
+	c, err := n.children()
+	if err != nil {
+		return nil, err
+	}
+	var fis []os.FileInfo
+	for _, i := range c {
+		fis = append(fis, i.Info())
+	}
+	return fis, nil
+}
+
+// Readlink implements fs.Node.Readlink.
+func (n *inode) Readlink() (string, error) {
+	if n.basicType() != typeSymlink {
+		return "", syscall.EINVAL
+	}
+	return n.target, nil
+}
+
+// Open implements fs.Node.Open.
+func (n *inode) Open() (fs.File, error) {
+	if n.basicType() != typeFile {
+		return nil, syscall.EINVAL
+	}
+	return fs.NewFile(n.reader(), n.Info()), nil
+}
+
+func (n *inode) reader() *reader {
+	r := &reader{n: n, cached: -1}
+	pos := n.blocksStart
+	for _, s := range n.blockSizes {
+		r.offsets = append(r.offsets, pos)
+		pos += int64(s &^ dataUncompressed)
+	}
+	return r
+}
+
+// reader reads the data blocks and fragment of a file.
+type reader struct {
+	n       *inode
+	offsets []int64
+
+	mu     sync.Mutex
+	cached int
+	block  []byte
+}
+
+// readBlock returns data block i, where the block after the last one is
+// the tail in the fragment.
+func (r *reader) readBlock(i int) ([]byte, error) {
+	f, bs := r.n.fs, int(r.n.fs.blockSize)
+	if i < len(r.n.blockSizes) {
+		s := r.n.blockSizes[i]
+		size := int(s &^ dataUncompressed)
+		want := bs
+		if rest := int(r.n.size) - i*bs; rest < want {
+			want = rest
+		}
+		if size == 0 {
+			// A sparse block.
+			return make([]byte, want), nil
+		}
+		d, err := f.data(r.offsets[i], size, s&dataUncompressed == 0)
+		if err != nil {
+			return nil, fmt.Errorf("squashfs: inode %d block %d: %v", r.n.ino, i, err)
+		}
+		if len(d) < want {
+			return nil, fmt.Errorf("squashfs: inode %d block %d has %d bytes, want %d", r.n.ino, i, len(d), want)
+		}
+		return d[:want], nil
+	}
+
+	if int(r.n.frag) >= len(f.fragments) {
+		return nil, fmt.Errorf("squashfs: inode %d: bad fragment %d", r.n.ino, r.n.frag)
+	}
+	fr := f.fragments[r.n.frag]
+	d, err := f.data(fr.start, int(fr.size&^dataUncompressed), fr.size&dataUncompressed == 0)
+	if err != nil {
+		return nil, fmt.Errorf("squashfs: fragment %d: %v", r.n.frag, err)
+	}
+	tail := int(r.n.size) - i*bs
+	if off := int(r.n.fragOffset); off+tail <= len(d) {
+		return d[off : off+tail], nil
+	}
+	return nil, fmt.Errorf("squashfs: inode %d: tail of %d bytes beyond fragment %d", r.n.ino, tail, r.n.frag)
+}
+
+// data reads size bytes at off and decompresses them into a block.
+func (f *FS) data(off int64, size int, compressed bool) ([]byte, error) {
+	if size > int(f.blockSize) {
+		return nil, fmt.Errorf("%d bytes are more than the block size", size)
+	}
+	b := make([]byte, size)
+	if _, err := f.r.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	if !compressed {
+		return b, nil
+	}
+	return f.decompress(b, int(f.blockSize))
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *reader) ReadAt(p []byte, off int64) (int, error) {
+	bs := int64(r.n.fs.blockSize)
+	size := int64(r.n.size)
+	n := 0
+	for n < len(p) && off+int64(n) < size {
+		pos := off + int64(n)
+		i := int(pos / bs)
+		r.mu.Lock()
+		if r.cached != i {
+			b, err := r.readBlock(i)
+			if err != nil {
+				r.mu.Unlock()
+				return n, err
+			}
+			r.block, r.cached = b, i
+		}
+		n += copy(p[n:], r.block[pos-int64(i)*bs:])
+		r.mu.Unlock()
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Records returns cpio records of all files, with names relative to the
+// root, parents before their children. The contents are read when the
+// records are read.
+func (f *FS) Records() ([]cpio.Record, error) {
+	var recs []cpio.Record
+	// Directories have one parent, so one reached twice is corrupt, and
+	// may be its own ancestor.
+	dirs := map[uint64]bool{}
+	var walk func(n *inode, name string) error
+	walk = func(n *inode, name string) error {
+		info := cpio.Info{
+			Ino:    uint64(n.ino),
+			Mode:   n.Mode(),
+			UID:    uint64(n.uid),
+			GID:    uint64(n.gid),
+			NLink:  uint64(n.nlink),
+			MTime:  uint64(n.mtime),
+			Name:   name,
+			Rmajor: uint64(n.rdev>>8) & 0xfff,
+			Rminor: uint64(n.rdev&0xff | n.rdev>>12&0xfff00),
+		}
+		rec := cpio.Record{Info: info}
+		switch n.basicType() {
+		case typeFile:
+			rec.ReaderAt, rec.FileSize = n.reader(), n.size
+		case typeSymlink:
+			rec.ReaderAt, rec.FileSize = bytes.NewReader([]byte(n.target)), n.size
+		}
+		recs = append(recs, rec)
+		if n.basicType() != typeDir {
+			return nil
+		}
+		if dirs[n.ref] {
+			return fmt.Errorf("squashfs: directory %d is in the tree twice", n.ino)
+		}
+		dirs[n.ref] = true
+		c, err := n.children()
+		if err != nil {
+			return err
+		}
+		sort.Slice(c, func(i, j int) bool { return c[i].name < c[j].name })
+		for _, i := range c {
+			child := i.name
+			if name != "." {
+				child = name + "/" + i.name
+			}
+			if err := walk(i, child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(f.root, "."); err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
@@ -0,0 +1,282 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package squashfs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/fs"
+)
+
+func testRecords() []cpio.Record {
+	big := make([]byte, 3*4096+100)
+	for i := range big {
+		big[i] = byte(i * 7 % 251)
+	}
+	sparse := make([]byte, 2*4096+10)
+	copy(sparse[2*4096:], "not sparse")
+
+	recs := []cpio.Record{
+		cpio.Directory(".", 0755),
+		cpio.Directory("etc", 0700),
+		cpio.StaticFile("etc/hosts", "127.0.0.1 localhost\n", 0644),
+		// A hard link to etc/hosts.
+		{Info: cpio.Info{Name: "etc/hosts.link", Mode: syscall.S_IFREG | 0644, FileSize: 20}},
+		cpio.StaticFile("etc/empty", "", 0600),
+		cpio.StaticFile("big", string(big), 0755),
+		cpio.StaticFile("sparse", string(sparse), 0644),
+		cpio.Symlink("lib", "usr/lib"),
+		cpio.CharDev("dev/null", 0666, 1, 3),
+		cpio.CharDev("dev/weird", 0600, 300, 70000),
+		// a and a/b are made by the writer.
+		cpio.StaticFile("a/b/c", "c", 0644),
+	}
+	// Enough entries for several directory headers and metadata blocks.
+	for i := 0; i < 600; i++ {
+		recs = append(recs, cpio.StaticFile(fmt.Sprintf("many/file-with-a-long-name-%03d", i), fmt.Sprint(i), 0644))
+	}
+	return append(recs, cpio.StaticRecord(nil, cpio.Info{Name: cpio.Trailer}))
+}
+
+func TestWriteRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "squashfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mtime := time.Unix(1500000000, 0)
+
+	for _, c := range []Compression{Gzip, XZ, Zstd} {
+		t.Run(c.String(), func(t *testing.T) {
+			f, err := os.Create(filepath.Join(dir, c.String()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			w, err := NewWriter(f, &WriterOptions{Compression: c, BlockSize: 4096, ModTime: mtime})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := testRecords()
+			if err := cpio.WriteRecords(w, want); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			fsys, err := New(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fsys.Compression() != c || fsys.BlockSize() != 4096 || !fsys.ModTime().Equal(mtime) {
+				t.Errorf("got %v, %d, %v, want %v, 4096, %v", fsys.Compression(), fsys.BlockSize(), fsys.ModTime(), c, mtime)
+			}
+			if probed, typ, err := fs.Probe(f); err != nil || typ != "squashfs" || probed == nil {
+				t.Errorf("Probe = %v, %q, %v, want squashfs", probed, typ, err)
+			}
+
+			b, err := fs.ReadFile(fsys, "/etc/hosts")
+			if err != nil || string(b) != "127.0.0.1 localhost\n" {
+				t.Errorf("ReadFile(/etc/hosts) = %q, %v", b, err)
+			}
+			fi, err := fsys.ReadDir("/many")
+			if err != nil || len(fi) != 600 {
+				t.Fatalf("ReadDir(/many) has %d entries, %v, want 600", len(fi), err)
+			}
+			if fi, err := fsys.Stat("/a/b"); err != nil || fi.Mode() != os.ModeDir|0755 {
+				t.Errorf("Stat(/a/b) = %v, %v, want a directory", fi, err)
+			}
+
+			got, err := fsys.Records()
+			if err != nil {
+				t.Fatal(err)
+			}
+			byName := map[string]cpio.Record{}
+			for _, r := range got {
+				byName[r.Name] = r
+			}
+			for _, r := range want {
+				if r.Name == cpio.Trailer {
+					continue
+				}
+				g, ok := byName[r.Name]
+				if !ok {
+					t.Errorf("%q is missing", r.Name)
+					continue
+				}
+				if g.Mode != r.Mode || g.Rmajor != r.Rmajor || g.Rminor != r.Rminor {
+					t.Errorf("%q: mode %o, dev %d:%d, want %o, %d:%d", r.Name, g.Mode, g.Rmajor, g.Rminor, r.Mode, r.Rmajor, r.Rminor)
+				}
+				if r.Mode&syscall.S_IFMT == syscall.S_IFDIR {
+					continue
+				}
+				if r.Name == "etc/hosts.link" {
+					r = want[2]
+				}
+				if g.FileSize != r.FileSize || (r.FileSize > 0 && !cpio.ReaderAtEqual(g.ReaderAt, r.ReaderAt)) {
+					t.Errorf("%q: contents differ", g.Name)
+				}
+			}
+			// a, a/b, dev and many are added; the trailer is not.
+			if len(got) != len(want)+3 {
+				t.Errorf("got %d records, want %d", len(got), len(want)+3)
+			}
+
+			// Reads in the middle of blocks and across them.
+			r := byName["big"]
+			p := make([]byte, 5000)
+			q := make([]byte, 5000)
+			if _, err := r.ReadAt(p, 4000); err != nil {
+				t.Fatal(err)
+			}
+			want[5].ReadAt(q, 4000)
+			if !bytes.Equal(p, q) {
+				t.Errorf("ReadAt(big, 4000) differs")
+			}
+		})
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	if _, err := NewWriter(nil, &WriterOptions{BlockSize: 3000}); err == nil {
+		t.Errorf("NewWriter with block size 3000: got nil, want error")
+	}
+	if _, err := NewWriter(nil, &WriterOptions{Compression: LZO}); err == nil {
+		t.Errorf("NewWriter with lzo: got nil, want error")
+	}
+	f, err := ioutil.TempFile("", "squashfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	w, err := NewWriter(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRecord(cpio.StaticFile("file", "x", 0644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRecord(cpio.StaticFile("file/x", "x", 0644)); err == nil {
+		t.Errorf("writing under a file: got nil, want error")
+	}
+}
+
+func TestNotSquashfs(t *testing.T) {
+	if _, err := New(bytes.NewReader(make([]byte, 4096))); err == nil {
+		t.Errorf("New(zeros): got nil, want error")
+	}
+}
+
+// TestBadDirectories checks that directory entries that would name a file
+// outside of the tree, or put a directory in its own subtree, are errors.
+func TestBadDirectories(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		patch func(f *FS, dir []byte)
+	}{
+		{"dot dot", func(f *FS, dir []byte) {
+			copy(dir[bytes.Index(dir, []byte("zz")):], "..")
+		}},
+		{"slash", func(f *FS, dir []byte) {
+			copy(dir[bytes.Index(dir, []byte("zz")):], "z/")
+		}},
+		{"loop", func(f *FS, dir []byte) {
+			// zz/yy is the only entry of zz, after its header.
+			i := bytes.Index(dir, []byte("yy")) - 8
+			le.PutUint32(dir[i-12+4:], uint32(f.root.ref>>16))
+			le.PutUint16(dir[i:], uint16(f.root.ref))
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := ioutil.TempFile("", "squashfs")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(img.Name())
+			defer img.Close()
+			w, err := NewWriter(img, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cpio.WriteRecords(w, []cpio.Record{cpio.StaticFile("zz/yy/x", "x", 0644)}); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			f, err := New(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Records(); err != nil {
+				t.Fatal(err)
+			}
+			// The directory table is read from the cache from now on.
+			m, err := f.metaBlock(f.dirTable)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.patch(f, m.data)
+			if recs, err := f.Records(); err == nil {
+				t.Errorf("Records() = %d records, nil, want error", len(recs))
+			}
+		})
+	}
+}
+
+// TestHugeFile checks that a file inode with more blocks than fit in the
+// image is an error.
+func TestHugeFile(t *testing.T) {
+	img, err := ioutil.TempFile("", "squashfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(img.Name())
+	defer img.Close()
+	w, err := NewWriter(img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cpio.WriteRecords(w, []cpio.Record{cpio.StaticFile("file", "small", 0644)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := New(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents, err := f.root.entries()
+	if err != nil || len(ents) != 1 {
+		t.Fatalf("Root entries = %v, %v, want file", ents, err)
+	}
+	// Make file an extended one of 1<<62 bytes without a fragment, in
+	// the cached inode table.
+	m, err := f.metaBlock(f.inodeTable + int64(ents[0].ref>>16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := m.data[ents[0].ref&0xffff:]
+	if len(n) < 56 {
+		t.Fatalf("Inode of file has %d bytes left in its block, want 56", len(n))
+	}
+	le.PutUint16(n, typeExtFile)
+	le.PutUint64(n[24:], 1<<62)
+	le.PutUint32(n[44:], noFragment)
+	if _, err := f.root.Lookup("file"); err == nil {
+		t.Errorf("Lookup(file of 1<<62 bytes) = nil, want error")
+	}
+}
@@ -0,0 +1,661 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package squashfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/ulikunitz/xz"
+)
+
+// WriterOptions are the options of NewWriter. The zero value makes a gzip
+// compressed file system with 128 KiB blocks, like mksquashfs.
+type WriterOptions struct {
+	// Compression is Gzip, XZ or Zstd.
+	Compression Compression
+	// BlockSize is a power of 2 from 4 KiB to 1 MiB.
+	BlockSize int
+	// ModTime is the modification time of the file system. The default
+	// is now.
+	ModTime time.Time
+}
+
+// Writer writes the cpio records written to it as a squashfs file system.
+// Directories that records are in are made when missing; a later record
+// for the same name replaces an earlier one. Hard links become files that
+// share their contents: a record with no contents and the inode number of
+// an earlier file, as cpio.GetRecord makes them.
+//
+// File contents are written right away; the rest when the Writer is
+// closed.
+type Writer struct {
+	w         io.WriterAt
+	comp      Compression
+	blockSize int
+	mtime     uint32
+	zstd      *zstd.Encoder
+
+	// off is where the next data block goes.
+	off       int64
+	root      *wnode
+	fragment  []byte
+	fragments []fragment
+	ids       []uint32
+	idIndex   map[uint32]uint16
+	files     map[uint64]*wnode
+	closed    bool
+}
+
+// wnode is an inode to be written.
+type wnode struct {
+	name     string
+	info     cpio.Info
+	children map[string]*wnode
+	target   string
+
+	blocksStart int64
+	blockSizes  []uint32
+	sparse      uint64
+	frag        uint32
+	fragOffset  uint32
+
+	ino uint32
+	ref uint64
+}
+
+func (n *wnode) typ() uint16 {
+	switch n.info.Mode & syscall.S_IFMT {
+	case syscall.S_IFDIR:
+		return typeDir
+	case syscall.S_IFLNK:
+		return typeSymlink
+	case syscall.S_IFBLK:
+		return typeBlock
+	case syscall.S_IFCHR:
+		return typeChar
+	case syscall.S_IFIFO:
+		return typeFIFO
+	case syscall.S_IFSOCK:
+		return typeSocket
+	}
+	return typeFile
+}
+
+// NewWriter returns a Writer that writes a file system to w.
+func NewWriter(w io.WriterAt, o *WriterOptions) (*Writer, error) {
+	if o == nil {
+		o = &WriterOptions{}
+	}
+	sw := &Writer{
+		w:         w,
+		comp:      o.Compression,
+		blockSize: o.BlockSize,
+		off:       superblockSize,
+		root:      &wnode{info: cpio.Info{Mode: syscall.S_IFDIR | 0755}, children: map[string]*wnode{}},
+		idIndex:   map[uint32]uint16{},
+		files:     map[uint64]*wnode{},
+	}
+	if sw.comp == 0 {
+		sw.comp = Gzip
+	}
+	if sw.blockSize == 0 {
+		sw.blockSize = 128 << 10
+	}
+	if sw.blockSize < 4096 || sw.blockSize > 1<<20 || sw.blockSize&(sw.blockSize-1) != 0 {
+		return nil, fmt.Errorf("squashfs: block size %d is not a power of 2 from 4K to 1M", sw.blockSize)
+	}
+	switch sw.comp {
+	case Gzip, XZ:
+	case Zstd:
+		e, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(sw.blockSize))
+		if err != nil {
+			return nil, err
+		}
+		sw.zstd = e
+	default:
+		return nil, fmt.Errorf("squashfs: can not write %v", sw.comp)
+	}
+	mtime := o.ModTime
+	if mtime.IsZero() {
+		mtime = time.Now()
+	}
+	sw.mtime = uint32(mtime.Unix())
+	return sw, nil
+}
+
+// compress returns b compressed, or b if that does not make it smaller.
+func (w *Writer) compress(b []byte) ([]byte, bool, error) {
+	var c bytes.Buffer
+	switch w.comp {
+	case Gzip:
+		z, err := zlib.NewWriterLevel(&c, zlib.BestCompression)
+		if err != nil {
+			return nil, false, err
+		}
+		if _, err := z.Write(b); err != nil {
+			return nil, false, err
+		}
+		if err := z.Close(); err != nil {
+			return nil, false, err
+		}
+	case XZ:
+		// Linux reads xz with CRC32 checks and a dictionary of
+		// at most the block size.
+		dict := w.blockSize
+		if dict < metaSize {
+			dict = metaSize
+		}
+		z, err := xz.WriterConfig{DictCap: dict, CheckSum: xz.CRC32}.NewWriter(&c)
+		if err != nil {
+			return nil, false, err
+		}
+		if _, err := z.Write(b); err != nil {
+			return nil, false, err
+		}
+		if err := z.Close(); err != nil {
+			return nil, false, err
+		}
+	case Zstd:
+		c.Write(w.zstd.EncodeAll(b, nil))
+	}
+	if c.Len() >= len(b) {
+		return b, false, nil
+	}
+	return c.Bytes(), true, nil
+}
+
+// writeData writes a data block and returns its size entry.
+func (w *Writer) writeData(b []byte) (uint32, error) {
+	c, compressed, err := w.compress(b)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.w.WriteAt(c, w.off); err != nil {
+		return 0, err
+	}
+	w.off += int64(len(c))
+	size := uint32(len(c))
+	if !compressed {
+		size |= dataUncompressed
+	}
+	return size, nil
+}
+
+// flushFragment writes the pending fragment block.
+func (w *Writer) flushFragment() error {
+	if len(w.fragment) == 0 {
+		return nil
+	}
+	start := w.off
+	size, err := w.writeData(w.fragment)
+	if err != nil {
+		return err
+	}
+	w.fragments = append(w.fragments, fragment{start: start, size: size})
+	w.fragment = w.fragment[:0]
+	return nil
+}
+
+// writeFile writes the contents of a file.
+func (w *Writer) writeFile(n *wnode, r io.ReaderAt) error {
+	n.frag = noFragment
+	n.blocksStart = w.off
+	if n.info.FileSize == 0 {
+		return nil
+	}
+	if r == nil {
+		if f, ok := w.files[n.info.Ino]; ok && f.info.FileSize == n.info.FileSize {
+			n.blocksStart, n.blockSizes, n.sparse = f.blocksStart, f.blockSizes, f.sparse
+			n.frag, n.fragOffset = f.frag, f.fragOffset
+			return nil
+		}
+		return fmt.Errorf("squashfs: %q has %d bytes but no contents", n.name, n.info.FileSize)
+	}
+	b := make([]byte, w.blockSize)
+	for off := int64(0); off < int64(n.info.FileSize); {
+		m := int64(n.info.FileSize) - off
+		if m > int64(len(b)) {
+			m = int64(len(b))
+		}
+		if k, err := r.ReadAt(b[:m], off); int64(k) < m {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("squashfs: reading %q: %v", n.name, err)
+		}
+		off += m
+		if m < int64(len(b)) {
+			// The tail goes into a fragment.
+			if len(w.fragment)+int(m) > w.blockSize {
+				if err := w.flushFragment(); err != nil {
+					return err
+				}
+			}
+			n.frag, n.fragOffset = uint32(len(w.fragments)), uint32(len(w.fragment))
+			w.fragment = append(w.fragment, b[:m]...)
+			break
+		}
+		if bytes.Count(b, []byte{0}) == len(b) {
+			// Sparse blocks take no space.
+			n.blockSizes = append(n.blockSizes, 0)
+			n.sparse += uint64(len(b))
+			continue
+		}
+		size, err := w.writeData(b)
+		if err != nil {
+			return err
+		}
+		n.blockSizes = append(n.blockSizes, size)
+	}
+	return nil
+}
+
+// dir returns the directory called name, making it and its parents if
+// they are missing.
+func (w *Writer) dir(name string) (*wnode, error) {
+	d := w.root
+	if name == "." {
+		return d, nil
+	}
+	for _, c := range strings.Split(name, "/") {
+		n, ok := d.children[c]
+		if !ok {
+			n = &wnode{name: c, info: cpio.Info{Mode: syscall.S_IFDIR | 0755}, children: map[string]*wnode{}}
+			d.children[c] = n
+		}
+		if n.children == nil {
+			return nil, fmt.Errorf("squashfs: %q in %q is not a directory", c, name)
+		}
+		d = n
+	}
+	return d, nil
+}
+
+// WriteRecord implements cpio.RecordWriter.
+func (w *Writer) WriteRecord(rec cpio.Record) error {
+	if w.closed {
+		return errors.New("squashfs: write to closed Writer")
+	}
+	if rec.Name == cpio.Trailer {
+		return nil
+	}
+	name := path.Clean("/" + rec.Name)[1:]
+	if name == "" {
+		w.root.info = rec.Info
+		return nil
+	}
+	base := path.Base(name)
+	if len(base) > 256 {
+		return fmt.Errorf("squashfs: name %q is longer than 256 bytes", base)
+	}
+	parent, err := w.dir(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	n := &wnode{name: base, info: rec.Info}
+	switch n.typ() {
+	case typeDir:
+		n.children = map[string]*wnode{}
+		if old, ok := parent.children[base]; ok && old.children != nil {
+			n.children = old.children
+		}
+	case typeFile:
+		if err := w.writeFile(n, rec.ReaderAt); err != nil {
+			return err
+		}
+		w.files[n.info.Ino] = n
+	case typeSymlink:
+		t := make([]byte, rec.FileSize)
+		if rec.ReaderAt != nil {
+			if k, err := rec.ReaderAt.ReadAt(t, 0); k < len(t) {
+				return fmt.Errorf("squashfs: reading symbolic link %q: %v", rec.Name, err)
+			}
+		}
+		n.target = string(t)
+	}
+	parent.children[base] = n
+	return nil
+}
+
+// id returns the index of id in the id table.
+func (w *Writer) id(id uint64) (uint16, error) {
+	if i, ok := w.idIndex[uint32(id)]; ok {
+		return i, nil
+	}
+	if len(w.ids) == 1<<16 {
+		return 0, errors.New("squashfs: too many uids and gids")
+	}
+	i := uint16(len(w.ids))
+	w.ids = append(w.ids, uint32(id))
+	w.idIndex[uint32(id)] = i
+	return i, nil
+}
+
+// metaWriter collects metadata into compressed blocks.
+type metaWriter struct {
+	w   *Writer
+	out []byte
+	buf []byte
+}
+
+// ref returns the position of the next byte written: the offset of its
+// block and the offset in the block.
+func (m *metaWriter) ref() (uint32, uint16) {
+	return uint32(len(m.out)), uint16(len(m.buf))
+}
+
+func (m *metaWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		k := metaSize - len(m.buf)
+		if k > len(p) {
+			k = len(p)
+		}
+		m.buf = append(m.buf, p[:k]...)
+		p = p[k:]
+		if len(m.buf) == metaSize {
+			if err := m.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (m *metaWriter) flush() error {
+	if len(m.buf) == 0 {
+		return nil
+	}
+	c, compressed, err := m.w.compress(m.buf)
+	if err != nil {
+		return err
+	}
+	h := uint16(len(c))
+	if !compressed {
+		h |= metaUncompressed
+	}
+	m.out = append(m.out, byte(h), byte(h>>8))
+	m.out = append(m.out, c...)
+	m.buf = m.buf[:0]
+	return nil
+}
+
+// number numbers the inodes in the order they are written: children
+// before their directory.
+func number(n *wnode, next *uint32) {
+	for _, c := range sorted(n) {
+		number(c, next)
+	}
+	*next++
+	n.ino = *next
+}
+
+func sorted(n *wnode) []*wnode {
+	var c []*wnode
+	for _, e := range n.children {
+		c = append(c, e)
+	}
+	sort.Slice(c, func(i, j int) bool { return c[i].name < c[j].name })
+	return c
+}
+
+// writeInodes writes the inodes of the tree at n, and the directories.
+func (w *Writer) writeInodes(n *wnode, parent uint32, inodes, dirs *metaWriter) error {
+	var nlink uint32 = 1
+	var listing bytes.Buffer
+	children := sorted(n)
+	if n.children != nil {
+		nlink = 2
+		for _, c := range children {
+			if err := w.writeInodes(c, n.ino, inodes, dirs); err != nil {
+				return err
+			}
+			if c.children != nil {
+				nlink++
+			}
+		}
+		dirListing(&listing, children)
+	}
+
+	uid, err := w.id(n.info.UID)
+	if err != nil {
+		return err
+	}
+	gid, err := w.id(n.info.GID)
+	if err != nil {
+		return err
+	}
+	block, off := inodes.ref()
+	n.ref = uint64(block)<<16 | uint64(off)
+
+	typ := n.typ()
+	b := make([]byte, 16, 64)
+	le.PutUint16(b[2:], uint16(n.info.Mode&07777))
+	le.PutUint16(b[4:], uid)
+	le.PutUint16(b[6:], gid)
+	le.PutUint32(b[8:], uint32(n.info.MTime))
+	le.PutUint32(b[12:], n.ino)
+	put32 := func(v uint32) { b = append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24)) }
+	put64 := func(v uint64) { put32(uint32(v)); put32(uint32(v >> 32)) }
+	put16 := func(v uint16) { b = append(b, byte(v), byte(v>>8)) }
+
+	switch typ {
+	case typeDir:
+		dirBlock, dirOff := dirs.ref()
+		if _, err := dirs.Write(listing.Bytes()); err != nil {
+			return err
+		}
+		size := uint64(listing.Len()) + 3
+		if size <= 0xffff {
+			put32(dirBlock)
+			put32(nlink)
+			put16(uint16(size))
+			put16(dirOff)
+			put32(parent)
+			break
+		}
+		typ = typeExtDir
+		put32(nlink)
+		put32(uint32(size))
+		put32(dirBlock)
+		put32(parent)
+		put16(0)
+		put16(dirOff)
+		put32(noXattr)
+	case typeFile:
+		if n.blocksStart < 1<<32 && n.info.FileSize < 1<<32 && n.sparse == 0 {
+			put32(uint32(n.blocksStart))
+			put32(n.frag)
+			put32(n.fragOffset)
+			put32(uint32(n.info.FileSize))
+		} else {
+			typ = typeExtFile
+			put64(uint64(n.blocksStart))
+			put64(n.info.FileSize)
+			put64(n.sparse)
+			put32(1)
+			put32(n.frag)
+			put32(n.fragOffset)
+			put32(noXattr)
+		}
+		for _, s := range n.blockSizes {
+			put32(s)
+		}
+	case typeSymlink:
+		put32(1)
+		put32(uint32(len(n.target)))
+		b = append(b, n.target...)
+	case typeBlock, typeChar:
+		put32(1)
+		major, minor := uint32(n.info.Rmajor), uint32(n.info.Rminor)
+		put32(minor&0xff | major<<8 | (minor&^0xff)<<12)
+	default:
+		put32(1)
+	}
+	le.PutUint16(b[0:], typ)
+	_, err = inodes.Write(b)
+	return err
+}
+
+// dirListing writes the directory entries of children, which are sorted
+// and have been written.
+func dirListing(b *bytes.Buffer, children []*wnode) {
+	for i := 0; i < len(children); {
+		// A header covers up to 256 entries with inodes in the same
+		// metadata block and inode numbers close to the first one.
+		first := children[i]
+		j := i + 1
+		for j < len(children) && j-i < 256 && children[j].ref>>16 == first.ref>>16 &&
+			int64(children[j].ino)-int64(first.ino) >= -32768 && int64(children[j].ino)-int64(first.ino) <= 32767 {
+			j++
+		}
+		h := make([]byte, 12)
+		le.PutUint32(h[0:], uint32(j-i-1))
+		le.PutUint32(h[4:], uint32(first.ref>>16))
+		le.PutUint32(h[8:], first.ino)
+		b.Write(h)
+		for _, c := range children[i:j] {
+			e := make([]byte, 8)
+			le.PutUint16(e[0:], uint16(c.ref))
+			le.PutUint16(e[2:], uint16(int16(int64(c.ino)-int64(first.ino))))
+			le.PutUint16(e[4:], c.typ())
+			le.PutUint16(e[6:], uint16(len(c.name)-1))
+			b.Write(e)
+			b.WriteString(c.name)
+		}
+		i = j
+	}
+}
+
+// writeTable writes the metadata blocks of table t, followed by the list
+// of where they are, and returns where that list is.
+func (w *Writer) writeTable(t []byte) (int64, error) {
+	m := &metaWriter{w: w}
+	var index []byte
+	for len(t) > 0 {
+		k := metaSize
+		if k > len(t) {
+			k = len(t)
+		}
+		pos := w.off + int64(len(m.out))
+		index = append(index, make([]byte, 8)...)
+		le.PutUint64(index[len(index)-8:], uint64(pos))
+		if _, err := m.Write(t[:k]); err != nil {
+			return 0, err
+		}
+		if err := m.flush(); err != nil {
+			return 0, err
+		}
+		t = t[k:]
+	}
+	if _, err := w.w.WriteAt(m.out, w.off); err != nil {
+		return 0, err
+	}
+	w.off += int64(len(m.out))
+	start := w.off
+	if _, err := w.w.WriteAt(index, w.off); err != nil {
+		return 0, err
+	}
+	w.off += int64(len(index))
+	return start, nil
+}
+
+// Close writes the inodes, directories and tables, and the superblock.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if err := w.flushFragment(); err != nil {
+		return err
+	}
+
+	var count uint32
+	number(w.root, &count)
+	inodes, dirs := &metaWriter{w: w}, &metaWriter{w: w}
+	if err := w.writeInodes(w.root, count+1, inodes, dirs); err != nil {
+		return err
+	}
+	for _, m := range []*metaWriter{inodes, dirs} {
+		if err := m.flush(); err != nil {
+			return err
+		}
+	}
+	inodeTable := w.off
+	if _, err := w.w.WriteAt(inodes.out, w.off); err != nil {
+		return err
+	}
+	w.off += int64(len(inodes.out))
+	dirTable := w.off
+	if _, err := w.w.WriteAt(dirs.out, w.off); err != nil {
+		return err
+	}
+	w.off += int64(len(dirs.out))
+
+	fragTable := uint64(noTable)
+	if len(w.fragments) > 0 {
+		t := make([]byte, fragmentEntrySize*len(w.fragments))
+		for i, f := range w.fragments {
+			le.PutUint64(t[i*fragmentEntrySize:], uint64(f.start))
+			le.PutUint32(t[i*fragmentEntrySize+8:], f.size)
+		}
+		start, err := w.writeTable(t)
+		if err != nil {
+			return err
+		}
+		fragTable = uint64(start)
+	}
+	ids := make([]byte, 4*len(w.ids))
+	for i, id := range w.ids {
+		le.PutUint32(ids[4*i:], id)
+	}
+	idTable, err := w.writeTable(ids)
+	if err != nil {
+		return err
+	}
+
+	sb := make([]byte, superblockSize)
+	le.PutUint32(sb[0:], magic)
+	le.PutUint32(sb[4:], count)
+	le.PutUint32(sb[8:], w.mtime)
+	le.PutUint32(sb[12:], uint32(w.blockSize))
+	le.PutUint32(sb[16:], uint32(len(w.fragments)))
+	le.PutUint16(sb[20:], uint16(w.comp))
+	for bs := w.blockSize; bs > 1; bs >>= 1 {
+		sb[22]++
+	}
+	le.PutUint16(sb[24:], flagNoXattrs)
+	le.PutUint16(sb[26:], uint16(len(w.ids)))
+	le.PutUint16(sb[28:], 4)
+	le.PutUint64(sb[32:], w.root.ref)
+	le.PutUint64(sb[40:], uint64(w.off))
+	le.PutUint64(sb[48:], uint64(idTable))
+	le.PutUint64(sb[56:], noTable)
+	le.PutUint64(sb[64:], uint64(inodeTable))
+	le.PutUint64(sb[72:], uint64(dirTable))
+	le.PutUint64(sb[80:], fragTable)
+	le.PutUint64(sb[88:], noTable)
+	if _, err := w.w.WriteAt(sb, 0); err != nil {
+		return err
+	}
+	// Pad to 4K, as block devices read whole blocks.
+	if pad := -w.off & 4095; pad > 0 {
+		if _, err := w.w.WriteAt(make([]byte, pad), w.off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
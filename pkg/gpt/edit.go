@@ -0,0 +1,469 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gpt
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// DefaultAlign is the default partition alignment in blocks, 1 MiB.
+	DefaultAlign = 2048
+
+	// Partition attributes defined by UEFI.
+	AttrRequired           PartAttr = 1 << 0
+	AttrNoBlockIO          PartAttr = 1 << 1
+	AttrLegacyBIOSBootable PartAttr = 1 << 2
+
+	// Partition attributes used by Microsoft basic data partitions.
+	AttrReadOnly    PartAttr = 1 << 60
+	AttrShadowCopy  PartAttr = 1 << 61
+	AttrHidden      PartAttr = 1 << 62
+	AttrNoAutomount PartAttr = 1 << 63
+)
+
+// AttrNames maps partition attribute names to attributes.
+var AttrNames = map[string]PartAttr{
+	"required":     AttrRequired,
+	"no-block-io":  AttrNoBlockIO,
+	"legacy-boot":  AttrLegacyBIOSBootable,
+	"read-only":    AttrReadOnly,
+	"shadow-copy":  AttrShadowCopy,
+	"hidden":       AttrHidden,
+	"no-automount": AttrNoAutomount,
+}
+
+// PartTypes maps well-known names of partition types to their GUIDs.
+var PartTypes = map[string]GUID{
+	"unused":               {},
+	"efi":                  MustParseGUID("C12A7328-F81F-11D2-BA4B-00A0C93EC93B"),
+	"mbr":                  MustParseGUID("024DEE41-33E7-11D3-9D69-0008C781F39F"),
+	"bios-boot":            MustParseGUID("21686148-6449-6E6F-744E-656564454649"),
+	"linux":                MustParseGUID("0FC63DAF-8483-4772-8E79-3D69D8477DE4"),
+	"linux-swap":           MustParseGUID("0657FD6D-A4AB-43C4-84E5-0933C84B4F4F"),
+	"linux-home":           MustParseGUID("933AC7E1-2EB4-4F13-B844-0E14E2AEF915"),
+	"linux-lvm":            MustParseGUID("E6D6D379-F507-44C2-A23C-238F2A3DF928"),
+	"linux-raid":           MustParseGUID("A19D880F-05FC-4D3B-A006-743F0F84911E"),
+	"linux-root-x86":       MustParseGUID("44479540-F297-41B2-9AF7-D131D5F0458A"),
+	"linux-root-x86-64":    MustParseGUID("4F68BCE3-E8CD-4DB1-96E7-FBCAF984B709"),
+	"linux-root-arm64":     MustParseGUID("B921B045-1DF0-41C3-AF44-4C6F280D3FAE"),
+	"microsoft-basic-data": MustParseGUID("EBD0A0A2-B9E5-4433-87C0-68B6B72699C7"),
+	"microsoft-reserved":   MustParseGUID("E3C9E316-0B5C-4DB8-817D-F92DF00215AE"),
+	"chromeos-kernel":      MustParseGUID("FE3A2A5D-4F32-41A7-B725-ACCC3285A309"),
+	"chromeos-rootfs":      MustParseGUID("3CB8E202-3B7E-47DD-8A3C-7FF2A13CFCEC"),
+	"chromeos-firmware":    MustParseGUID("CAB6E88E-ABF3-4102-A07A-D4BB9BE3C1D3"),
+	"chromeos-reserved":    MustParseGUID("2E0A753D-9E48-43B0-8337-B15192CB1B5E"),
+}
+
+// ParseGUID parses a GUID in its usual string form, e.g.
+// C12A7328-F81F-11D2-BA4B-00A0C93EC93B.
+func ParseGUID(s string) (GUID, error) {
+	var g GUID
+	f := strings.Split(s, "-")
+	if len(f) != 5 || len(f[0]) != 8 || len(f[1]) != 4 || len(f[2]) != 4 || len(f[3]) != 4 || len(f[4]) != 12 {
+		return g, fmt.Errorf("GUID %q is not of the form XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX", s)
+	}
+	l, err := strconv.ParseUint(f[0], 16, 32)
+	if err != nil {
+		return g, fmt.Errorf("GUID %q: %v", s, err)
+	}
+	w1, err := strconv.ParseUint(f[1], 16, 16)
+	if err != nil {
+		return g, fmt.Errorf("GUID %q: %v", s, err)
+	}
+	w2, err := strconv.ParseUint(f[2], 16, 16)
+	if err != nil {
+		return g, fmt.Errorf("GUID %q: %v", s, err)
+	}
+	b, err := strconv.ParseUint(f[3]+f[4], 16, 64)
+	if err != nil {
+		return g, fmt.Errorf("GUID %q: %v", s, err)
+	}
+	g.L, g.W1, g.W2 = uint32(l), uint16(w1), uint16(w2)
+	binary.BigEndian.PutUint64(g.B[:], b)
+	return g, nil
+}
+
+// MustParseGUID is ParseGUID for GUIDs known to be valid. It panics on
+// errors.
+func MustParseGUID(s string) GUID {
+	g, err := ParseGUID(s)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// NewGUID returns a random (version 4) GUID.
+func NewGUID() (GUID, error) {
+	var b [16]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		return GUID{}, err
+	}
+	g := GUID{
+		L:  binary.LittleEndian.Uint32(b[0:]),
+		W1: binary.LittleEndian.Uint16(b[4:]),
+		W2: binary.LittleEndian.Uint16(b[6:])&0x0fff | 0x4000,
+	}
+	copy(g.B[:], b[8:])
+	g.B[0] = g.B[0]&0x3f | 0x80
+	return g, nil
+}
+
+// ParsePartType returns the partition type GUID for a well-known name
+// from PartTypes, or a GUID.
+func ParsePartType(s string) (GUID, error) {
+	if g, ok := PartTypes[strings.ToLower(s)]; ok {
+		return g, nil
+	}
+	g, err := ParseGUID(s)
+	if err != nil {
+		return g, fmt.Errorf("%q is neither a known partition type nor a GUID", s)
+	}
+	return g, nil
+}
+
+// PartTypeName returns the well-known name of a partition type, or its
+// GUID if it has none.
+func PartTypeName(g GUID) string {
+	for n, t := range PartTypes {
+		if t == g {
+			return n
+		}
+	}
+	return g.String()
+}
+
+// ParseAttr parses partition attributes, either as a number or as a comma
+// separated list of names from AttrNames.
+func ParseAttr(s string) (PartAttr, error) {
+	if n, err := strconv.ParseUint(s, 0, 64); err == nil {
+		return PartAttr(n), nil
+	}
+	var a PartAttr
+	for _, n := range strings.Split(s, ",") {
+		v, ok := AttrNames[strings.ToLower(n)]
+		if !ok {
+			return 0, fmt.Errorf("unknown partition attribute %q", n)
+		}
+		a |= v
+	}
+	return a, nil
+}
+
+// EncodeName encodes a partition name as UTF-16LE.
+func EncodeName(s string) (PartName, error) {
+	var n PartName
+	u := utf16.Encode([]rune(s))
+	if len(u) > len(n)/2 {
+		return n, fmt.Errorf("Partition name %q is longer than %d UTF-16 code units", s, len(n)/2)
+	}
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(n[i*2:], c)
+	}
+	return n, nil
+}
+
+// DecodeName decodes a UTF-16LE partition name.
+func DecodeName(n PartName) string {
+	var u []uint16
+	for i := 0; i < len(n); i += 2 {
+		c := binary.LittleEndian.Uint16(n[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+// IsUsed returns whether a partition entry is in use.
+func (p *Part) IsUsed() bool {
+	return p.PartGUID != GUID{}
+}
+
+// ProtectiveMBR returns an MBR with one partition of type 0xee covering a
+// disk of the given number of blocks, as far as an MBR can.
+func ProtectiveMBR(blocks uint64) *MBR {
+	var m MBR
+	e := m[0x1be:]
+	// Starting CHS 0/0/2, type, ending CHS as large as it goes.
+	copy(e, []byte{0x00, 0x00, 0x02, 0x00, 0xee, 0xff, 0xff, 0xff})
+	binary.LittleEndian.PutUint32(e[8:], 1)
+	size := blocks - 1
+	if size > 0xffffffff {
+		size = 0xffffffff
+	}
+	binary.LittleEndian.PutUint32(e[12:], uint32(size))
+	m[510], m[511] = 0x55, 0xaa
+	return &m
+}
+
+// Create returns a new, empty partition table with room for MaxNPart
+// partitions, for a disk of size bytes.
+func Create(size int64) (*PartitionTable, error) {
+	blocks := uint64(size / BlockSize)
+	partBlocks := uint64(MaxNPart * 0x80 / BlockSize)
+	if blocks < 2*partBlocks+3 {
+		return nil, fmt.Errorf("A %d byte disk is too small for a GPT", size)
+	}
+	guid, err := NewGUID()
+	if err != nil {
+		return nil, err
+	}
+	p := &PartitionTable{
+		MasterBootRecord: ProtectiveMBR(blocks),
+		Primary: &GPT{
+			Header: Header{
+				Signature:  Signature,
+				Revision:   Revision,
+				HeaderSize: HeaderSize,
+				CurrentLBA: 1,
+				BackupLBA:  blocks - 1,
+				FirstLBA:   2 + partBlocks,
+				LastLBA:    blocks - 2 - partBlocks,
+				DiskGUID:   guid,
+				PartStart:  2,
+				NPart:      MaxNPart,
+				PartSize:   0x80,
+			},
+			Parts: make([]Part, MaxNPart),
+		},
+	}
+	p.syncBackup()
+	return p, nil
+}
+
+// syncBackup makes the backup GPT a copy of the primary one.
+func (p *PartitionTable) syncBackup() {
+	b := &GPT{
+		Header: p.Primary.Header,
+		Parts:  append([]Part(nil), p.Primary.Parts...),
+	}
+	b.CurrentLBA, b.BackupLBA = p.Primary.BackupLBA, p.Primary.CurrentLBA
+	// The backup partition entries are right after the usable space.
+	b.PartStart = p.Primary.LastLBA + 1
+	p.Backup = b
+}
+
+// Extent is a range of blocks, including Last.
+type Extent struct {
+	First uint64
+	Last  uint64
+}
+
+// Size returns the number of blocks in e.
+func (e Extent) Size() uint64 {
+	return e.Last - e.First + 1
+}
+
+// FreeSpace returns the unpartitioned ranges of the disk, with starts
+// aligned to align blocks.
+func (p *PartitionTable) FreeSpace(align uint64) []Extent {
+	if align == 0 {
+		align = 1
+	}
+	var used []Extent
+	for _, part := range p.Primary.Parts {
+		if part.IsUsed() {
+			used = append(used, Extent{part.FirstLBA, part.LastLBA})
+		}
+	}
+	sort.Slice(used, func(i, j int) bool { return used[i].First < used[j].First })
+
+	var free []Extent
+	add := func(first, last uint64) {
+		first = (first + align - 1) / align * align
+		if first <= last {
+			free = append(free, Extent{first, last})
+		}
+	}
+	next := p.Primary.FirstLBA
+	for _, u := range used {
+		if u.First > next {
+			add(next, u.First-1)
+		}
+		if u.Last+1 > next {
+			next = u.Last + 1
+		}
+	}
+	if next <= p.Primary.LastLBA {
+		add(next, p.Primary.LastLBA)
+	}
+	return free
+}
+
+// part returns partition entry i.
+func (p *PartitionTable) part(i int) (*Part, error) {
+	if p.Primary == nil {
+		return nil, fmt.Errorf("No primary GPT")
+	}
+	if i < 0 || i >= len(p.Primary.Parts) {
+		return nil, fmt.Errorf("Partition %d does not exist; there are %d entries", i+1, len(p.Primary.Parts))
+	}
+	return &p.Primary.Parts[i], nil
+}
+
+// usedPart returns partition entry i, which must be in use.
+func (p *PartitionTable) usedPart(i int) (*Part, error) {
+	part, err := p.part(i)
+	if err != nil {
+		return nil, err
+	}
+	if !part.IsUsed() {
+		return nil, fmt.Errorf("Partition %d is not in use", i+1)
+	}
+	return part, nil
+}
+
+// check checks that partition i can span first to last.
+func (p *PartitionTable) check(i int, first, last uint64) error {
+	g := p.Primary
+	if first > last {
+		return fmt.Errorf("Partition %d: first block %d is after last block %d", i+1, first, last)
+	}
+	if first < g.FirstLBA || last > g.LastLBA {
+		return fmt.Errorf("Partition %d: blocks %d-%d are outside the usable blocks %d-%d", i+1, first, last, g.FirstLBA, g.LastLBA)
+	}
+	for j, o := range g.Parts {
+		if j != i && o.IsUsed() && first <= o.LastLBA && o.FirstLBA <= last {
+			return fmt.Errorf("Partition %d: blocks %d-%d overlap partition %d at %d-%d", i+1, first, last, j+1, o.FirstLBA, o.LastLBA)
+		}
+	}
+	return nil
+}
+
+// Add adds a partition of type typ from block first to block last, and
+// returns its index. If first is 0, the partition starts at the first free
+// space aligned to DefaultAlign. If last is 0, it extends to the end of the
+// free space it starts in.
+func (p *PartitionTable) Add(typ GUID, first, last uint64, name string) (int, error) {
+	if typ == (GUID{}) {
+		return -1, fmt.Errorf("Partition type can not be unused")
+	}
+	i := -1
+	for j, part := range p.Primary.Parts {
+		if !part.IsUsed() {
+			i = j
+			break
+		}
+	}
+	if i == -1 {
+		return -1, fmt.Errorf("All %d partition entries are in use", len(p.Primary.Parts))
+	}
+	free := p.FreeSpace(DefaultAlign)
+	if first == 0 {
+		if len(free) == 0 {
+			return -1, fmt.Errorf("No free space left")
+		}
+		first = free[0].First
+	}
+	if last == 0 {
+		for _, f := range p.FreeSpace(1) {
+			if first >= f.First && first <= f.Last {
+				last = f.Last
+			}
+		}
+	}
+	if err := p.check(i, first, last); err != nil {
+		return -1, err
+	}
+	n, err := EncodeName(name)
+	if err != nil {
+		return -1, err
+	}
+	guid, err := NewGUID()
+	if err != nil {
+		return -1, err
+	}
+	p.Primary.Parts[i] = Part{
+		PartGUID:   typ,
+		UniqueGUID: guid,
+		FirstLBA:   first,
+		LastLBA:    last,
+		Name:       n,
+	}
+	p.syncBackup()
+	return i, nil
+}
+
+// Delete deletes partition i.
+func (p *PartitionTable) Delete(i int) error {
+	part, err := p.usedPart(i)
+	if err != nil {
+		return err
+	}
+	*part = Part{}
+	p.syncBackup()
+	return nil
+}
+
+// Resize moves the end of partition i to block last. If last is 0, the
+// partition is grown as far as it can.
+func (p *PartitionTable) Resize(i int, last uint64) error {
+	part, err := p.usedPart(i)
+	if err != nil {
+		return err
+	}
+	if last == 0 {
+		last = p.Primary.LastLBA
+		for _, o := range p.Primary.Parts {
+			if o.IsUsed() && o.FirstLBA > part.LastLBA && o.FirstLBA-1 < last {
+				last = o.FirstLBA - 1
+			}
+		}
+	}
+	if err := p.check(i, part.FirstLBA, last); err != nil {
+		return err
+	}
+	part.LastLBA = last
+	p.syncBackup()
+	return nil
+}
+
+// SetName sets the name of partition i.
+func (p *PartitionTable) SetName(i int, name string) error {
+	part, err := p.usedPart(i)
+	if err != nil {
+		return err
+	}
+	if part.Name, err = EncodeName(name); err != nil {
+		return err
+	}
+	p.syncBackup()
+	return nil
+}
+
+// SetAttr sets the attributes of partition i.
+func (p *PartitionTable) SetAttr(i int, a PartAttr) error {
+	part, err := p.usedPart(i)
+	if err != nil {
+		return err
+	}
+	part.Attribute = a
+	p.syncBackup()
+	return nil
+}
+
+// SetType sets the type of partition i.
+func (p *PartitionTable) SetType(i int, typ GUID) error {
+	part, err := p.usedPart(i)
+	if err != nil {
+		return err
+	}
+	if typ == (GUID{}) {
+		return fmt.Errorf("Partition type can not be unused; delete the partition instead")
+	}
+	part.PartGUID = typ
+	p.syncBackup()
+	return nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !race
+
+package gpt
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+const diskSize = 64 << 20
+
+func TestParseGUID(t *testing.T) {
+	for _, s := range []string{"c12a7328-f81f-11d2-ba4b-00a0c93ec93b", "FE3A2A5D-4F32-41A7-B725-ACCC3285A309"} {
+		g, err := ParseGUID(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.EqualFold([]byte(g.String()), []byte(s)) {
+			t.Errorf("ParseGUID(%q).String() = %q", s, g.String())
+		}
+	}
+	for _, s := range []string{"", "c12a7328f81f11d2ba4b00a0c93ec93b", "x12a7328-f81f-11d2-ba4b-00a0c93ec93b"} {
+		if _, err := ParseGUID(s); err == nil {
+			t.Errorf("ParseGUID(%q): got nil, want error", s)
+		}
+	}
+	g, err := NewGUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.W2>>12 != 4 || g.B[0]>>6 != 2 {
+		t.Errorf("NewGUID() = %v, not a version 4 GUID", g.String())
+	}
+}
+
+func TestNames(t *testing.T) {
+	n, err := EncodeName("STATE ☺")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := DecodeName(n); s != "STATE ☺" {
+		t.Errorf("DecodeName(EncodeName(%q)) = %q", "STATE ☺", s)
+	}
+	if _, err := EncodeName(string(make([]byte, 37))); err == nil {
+		t.Errorf("EncodeName(37 characters): got nil, want error")
+	}
+	if a, err := ParseAttr("required,hidden"); err != nil || a != AttrRequired|AttrHidden {
+		t.Errorf("ParseAttr(required,hidden) = %#x, %v", a, err)
+	}
+	if a, err := ParseAttr("0x4"); err != nil || a != AttrLegacyBIOSBootable {
+		t.Errorf("ParseAttr(0x4) = %#x, %v", a, err)
+	}
+	if _, err := ParseAttr("bogus"); err == nil {
+		t.Errorf("ParseAttr(bogus): got nil, want error")
+	}
+	if g, err := ParsePartType("EFI"); err != nil || PartTypeName(g) != "efi" {
+		t.Errorf("ParsePartType(EFI) = %v, %v", g.String(), err)
+	}
+}
+
+// TestCreate creates a table, writes it and reads it back.
+func TestCreate(t *testing.T) {
+	d := make(iodisk, diskSize)
+	p, err := Create(diskSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(&d, p); err != nil {
+		t.Fatal(err)
+	}
+	if d[0x1be+4] != 0xee || d[510] != 0x55 || d[511] != 0xaa {
+		t.Errorf("No protective MBR")
+	}
+	g, err := New(bytes.NewReader(d))
+	if err != nil {
+		t.Fatalf("Reading created GPT: %v", err)
+	}
+	if g.Primary.FirstLBA != 34 || g.Primary.LastLBA != diskSize/BlockSize-34 || g.Backup.CurrentLBA != diskSize/BlockSize-1 {
+		t.Errorf("Created GPT has header %v", g.Primary.Header)
+	}
+	if free := g.FreeSpace(DefaultAlign); !reflect.DeepEqual(free, []Extent{{2048, diskSize/BlockSize - 34}}) {
+		t.Errorf("FreeSpace() = %v", free)
+	}
+
+	if _, err := Create(8192); err == nil {
+		t.Errorf("Create(8192): got nil, want error")
+	}
+}
+
+func TestEdit(t *testing.T) {
+	p, err := Create(diskSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := uint64(diskSize/BlockSize - 34)
+	efi := PartTypes["efi"]
+	linux := PartTypes["linux"]
+
+	if i, err := p.Add(efi, 0, 4095, "EFI"); err != nil || i != 0 {
+		t.Fatalf("Add(efi) = %d, %v", i, err)
+	}
+	if i, err := p.Add(linux, 0, 0, "root"); err != nil || i != 1 {
+		t.Fatalf("Add(linux) = %d, %v", i, err)
+	}
+	if got := p.Primary.Parts[1]; got.FirstLBA != 4096 || got.LastLBA != last {
+		t.Errorf("Second partition spans %d-%d, want 4096-%d", got.FirstLBA, got.LastLBA, last)
+	}
+	if _, err := p.Add(linux, 0, 0, ""); err == nil {
+		t.Errorf("Add to a full disk: got nil, want error")
+	}
+	if err := p.Resize(1, 8191); err != nil {
+		t.Fatal(err)
+	}
+	if free := p.FreeSpace(DefaultAlign); !reflect.DeepEqual(free, []Extent{{8192, last}}) {
+		t.Errorf("FreeSpace() = %v", free)
+	}
+	if _, err := p.Add(linux, 8000, 9000, ""); err == nil {
+		t.Errorf("Add overlapping partition: got nil, want error")
+	}
+	if _, err := p.Add(linux, 8192, last+1, ""); err == nil {
+		t.Errorf("Add partition past the end: got nil, want error")
+	}
+	if err := p.SetName(1, "ROOT-A"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetAttr(0, AttrRequired); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetType(1, PartTypes["linux-root-x86-64"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Delete(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Delete(0); err == nil {
+		t.Errorf("Delete unused partition: got nil, want error")
+	}
+	if err := p.Resize(5, 0); err == nil {
+		t.Errorf("Resize unused partition: got nil, want error")
+	}
+	if err := p.Resize(1, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	d := make(iodisk, diskSize)
+	if err := Write(&d, p); err != nil {
+		t.Fatal(err)
+	}
+	g, err := New(bytes.NewReader(d))
+	if err != nil {
+		t.Fatalf("Reading edited GPT: %v", err)
+	}
+	if g.Primary.Parts[0].IsUsed() {
+		t.Errorf("Deleted partition 1 is still there")
+	}
+	part := g.Backup.Parts[1]
+	if part.PartGUID != PartTypes["linux-root-x86-64"] || DecodeName(part.Name) != "ROOT-A" || part.FirstLBA != 4096 || part.LastLBA != last {
+		t.Errorf("Backup partition 2 is %v", part)
+	}
+	if free := g.FreeSpace(DefaultAlign); !reflect.DeepEqual(free, []Extent{{2048, 4095}}) {
+		t.Errorf("FreeSpace() = %v", free)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gpt
+
+import (
+	"fmt"
+	"io"
+)
+
+// Repair reads the partition table of a disk of size bytes. If one of the
+// GPTs is damaged, it is rebuilt from the other one; if both are fine but
+// differ, the backup is rebuilt from the primary. If the disk has no valid
+// MBR, it gets a protective MBR. Write the result to repair the disk; Write
+// also recomputes the CRCs.
+func Repair(r io.ReaderAt, size int64) (*PartitionTable, error) {
+	p := &PartitionTable{MasterBootRecord: &MBR{}}
+	if n, err := r.ReadAt(p.MasterBootRecord[:], 0); n != BlockSize {
+		return nil, fmt.Errorf("Reading MBR: %v", err)
+	}
+	blocks := uint64(size / BlockSize)
+	if m := p.MasterBootRecord; m[510] != 0x55 || m[511] != 0xaa {
+		p.MasterBootRecord = ProtectiveMBR(blocks)
+	}
+
+	primary, perr := Table(r, HeaderOff)
+	// Without the primary, the backup is expected in the last block.
+	backupLBA := blocks - 1
+	if perr == nil {
+		backupLBA = primary.BackupLBA
+	}
+	backup, berr := Table(r, int64(backupLBA*BlockSize))
+
+	switch {
+	case perr != nil && berr != nil:
+		return nil, fmt.Errorf("Both GPTs are damaged: %v; %v", perr, berr)
+	case perr != nil:
+		h := backup.Header
+		h.CurrentLBA, h.BackupLBA = 1, backup.CurrentLBA
+		// The primary partition entries always follow its header.
+		h.PartStart = 2
+		p.Primary = &GPT{Header: h, Parts: append([]Part(nil), backup.Parts...)}
+		p.Backup = backup
+		p.Backup.BackupLBA = 1
+	case berr != nil:
+		p.Primary = primary
+		p.syncBackup()
+	default:
+		p.Primary, p.Backup = primary, backup
+		if EqualHeader(primary.Header, backup.Header) != nil || EqualParts(primary, backup) != nil {
+			p.syncBackup()
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !race
+
+package gpt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	p, err := Create(diskSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Add(PartTypes["linux"], 0, 0, "root"); err != nil {
+		t.Fatal(err)
+	}
+	good := make(iodisk, diskSize)
+	if err := Write(&good, p); err != nil {
+		t.Fatal(err)
+	}
+	backupHeader := int64(diskSize - BlockSize)
+
+	for _, tt := range []struct {
+		name   string
+		mangle []int64
+		fail   bool
+	}{
+		{name: "intact"},
+		{name: "primary header", mangle: []int64{HeaderOff + 0x10}},
+		{name: "primary partitions", mangle: []int64{2*BlockSize + 0x20}},
+		{name: "backup header", mangle: []int64{backupHeader + 0x10}},
+		{name: "backup partitions", mangle: []int64{backupHeader - 32*BlockSize + 0x20}},
+		{name: "MBR", mangle: []int64{510}},
+		{name: "both", mangle: []int64{HeaderOff, backupHeader}, fail: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d := append(iodisk(nil), good...)
+			for _, m := range tt.mangle {
+				d[m]++
+			}
+			r, err := Repair(bytes.NewReader(d), diskSize)
+			if tt.fail {
+				if err == nil {
+					t.Errorf("Repair: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := Write(&d, r); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(d, good) {
+				t.Errorf("Repaired disk differs from the original one")
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"syscall"
 	"unsafe"
 
+	"github.com/u-root/u-root/pkg/cmdline"
 	"golang.org/x/sys/unix"
 )
 
@@ -125,6 +126,9 @@ type ProbeOpts struct {
 	DryRunCB func(string)
 	RootDir  string
 	KVer     string
+	// IgnoreProcCmdLine, if true, does not apply the module's parameters
+	// on the kernel command line, e.g. ixgbe.allow_unsupported_sfp=1.
+	IgnoreProcCmdLine bool
 }
 
 // Probe loads the given kernel module and its dependencies.
@@ -269,6 +273,15 @@ func loadModule(path, modParams string, opts ProbeOpts) error {
 	}
 	defer f.Close()
 
+	// As in modprobe, parameters given here win over those on the
+	// kernel command line, since they come later.
+	if !opts.IgnoreProcCmdLine {
+		name := strings.TrimSuffix(filepath.Base(path), ".ko")
+		if p := cmdline.ModuleParams(name); p != "" {
+			modParams = strings.TrimSpace(p + " " + modParams)
+		}
+	}
+
 	if err := FileInit(f, modParams, 0); err != nil {
 		if serr, ok := err.(*SyscallError); !ok || (ok && serr.Errno != unix.EEXIST) {
 			return err
@@ -0,0 +1,264 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loop
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	LOOP_SET_DIRECT_IO  = 0x4C08
+	LOOP_SET_BLOCK_SIZE = 0x4C09
+	LOOP_CONFIGURE      = 0x4C0A
+)
+
+// Flags are the flags of a loop device.
+type Flags uint32
+
+// Flags of loop devices, as in linux/loop.h.
+const (
+	// FlagReadOnly makes the device read-only.
+	FlagReadOnly Flags = 1
+	// FlagAutoclear detaches the file when the device is last closed,
+	// e.g. when the file system on it is unmounted.
+	FlagAutoclear Flags = 4
+	// FlagPartScan makes the kernel scan the device for partitions and
+	// create devices like /dev/loop0p1 for them.
+	FlagPartScan Flags = 8
+	// FlagDirectIO bypasses the page cache of the file.
+	FlagDirectIO Flags = 16
+)
+
+var flagNames = []struct {
+	f    Flags
+	name string
+}{
+	{FlagReadOnly, "ro"},
+	{FlagAutoclear, "autoclear"},
+	{FlagPartScan, "partscan"},
+	{FlagDirectIO, "dio"},
+}
+
+func (f Flags) String() string {
+	var s []string
+	for _, n := range flagNames {
+		if f&n.f != 0 {
+			s = append(s, n.name)
+			f &^= n.f
+		}
+	}
+	if f != 0 {
+		s = append(s, fmt.Sprintf("%#x", uint32(f)))
+	}
+	return strings.Join(s, ",")
+}
+
+// Status is the status of a loop device: the file it is bound to, and
+// which part of it.
+type Status struct {
+	// Number is the number of the device, as in /dev/loopN.
+	Number int
+	// Device and Inode are the device and inode numbers of the file.
+	Device uint64
+	Inode  uint64
+	// FileName is the name of the file, truncated to 63 bytes.
+	FileName string
+	// Offset is where in the file the device starts.
+	Offset uint64
+	// SizeLimit is the size of the device. If 0, it extends to the end
+	// of the file.
+	SizeLimit uint64
+	Flags     Flags
+}
+
+// Dev returns the name of the device.
+func (s *Status) Dev() string {
+	return fmt.Sprintf("/dev/loop%d", s.Number)
+}
+
+// loopInfo64 is struct loop_info64.
+type loopInfo64 struct {
+	device         uint64
+	inode          uint64
+	rdevice        uint64
+	offset         uint64
+	sizeLimit      uint64
+	number         uint32
+	encryptType    uint32
+	encryptKeySize uint32
+	flags          uint32
+	fileName       [LO_NAME_SIZE]byte
+	cryptName      [LO_NAME_SIZE]byte
+	encryptKey     [LO_KEY_SIZE]byte
+	init           [2]uint64
+}
+
+// loopConfig is struct loop_config.
+type loopConfig struct {
+	fd        uint32
+	blockSize uint32
+	info      loopInfo64
+	reserved  [8]uint64
+}
+
+func (s *Status) info() *loopInfo64 {
+	i := &loopInfo64{
+		offset:    s.Offset,
+		sizeLimit: s.SizeLimit,
+		flags:     uint32(s.Flags),
+	}
+	copy(i.fileName[:LO_NAME_SIZE-1], s.FileName)
+	return i
+}
+
+func ioctl(fd, req uintptr, arg unsafe.Pointer) error {
+	if _, _, err := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); err != 0 {
+		return err
+	}
+	return nil
+}
+
+// GetStatus returns the status of the loop device pointed by fd. It
+// returns ENXIO if no file is bound to it.
+func GetStatus(fd uintptr) (*Status, error) {
+	var i loopInfo64
+	if err := ioctl(fd, LOOP_GET_STATUS64, unsafe.Pointer(&i)); err != nil {
+		return nil, err
+	}
+	name := i.fileName[:]
+	if n := bytes.IndexByte(name, 0); n >= 0 {
+		name = name[:n]
+	}
+	return &Status{
+		Number:    int(i.number),
+		Device:    i.device,
+		Inode:     i.inode,
+		FileName:  string(name),
+		Offset:    i.offset,
+		SizeLimit: i.sizeLimit,
+		Flags:     Flags(i.flags),
+	}, nil
+}
+
+// SetStatus sets the offset, size limit and flags of the loop device
+// pointed by fd. Only FlagAutoclear and FlagPartScan can be changed once
+// a file is bound.
+func SetStatus(fd uintptr, s *Status) error {
+	return ioctl(fd, LOOP_SET_STATUS64, unsafe.Pointer(s.info()))
+}
+
+// Configure binds the regular file pointed by ffd to the loop device
+// pointed by lfd with the offset, size limit and flags in s, all at once.
+// Kernels older than 5.8 do not have LOOP_CONFIGURE; on them, Configure
+// uses SetFd and SetStatus.
+func Configure(lfd, ffd uintptr, s *Status) error {
+	c := &loopConfig{fd: uint32(ffd), info: *s.info()}
+	err := ioctl(lfd, LOOP_CONFIGURE, unsafe.Pointer(c))
+	if err != syscall.EINVAL && err != syscall.ENOTTY {
+		return err
+	}
+	if err := SetFd(lfd, ffd); err != nil {
+		return err
+	}
+	if err := SetStatus(lfd, s); err != nil {
+		ClearFd(lfd)
+		return err
+	}
+	return nil
+}
+
+// ConfigureFiles binds regular file "filename" to loop device
+// "devicename" as Configure does. The file is opened read-only if s has
+// FlagReadOnly or it can not be written, in which case FlagReadOnly is
+// added to s.
+func ConfigureFiles(devicename, filename string, s *Status) error {
+	mode := os.O_RDWR
+	if s.Flags&FlagReadOnly != 0 {
+		mode = os.O_RDONLY
+	}
+	file, err := os.OpenFile(filename, mode, 0)
+	if err != nil && mode == os.O_RDWR {
+		mode = os.O_RDONLY
+		s.Flags |= FlagReadOnly
+		file, err = os.OpenFile(filename, mode, 0)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	device, err := os.OpenFile(devicename, mode, 0)
+	if err != nil {
+		return err
+	}
+	defer device.Close()
+
+	if s.FileName == "" {
+		if abs, err := filepath.Abs(filename); err == nil {
+			s.FileName = abs
+		}
+	}
+	return Configure(device.Fd(), file.Fd(), s)
+}
+
+// GetStatusFile returns the status of loop device "devicename".
+func GetStatusFile(devicename string) (*Status, error) {
+	device, err := os.Open(devicename)
+	if err != nil {
+		return nil, err
+	}
+	defer device.Close()
+
+	return GetStatus(device.Fd())
+}
+
+// SetStatusFile sets the status of loop device "devicename" as SetStatus
+// does.
+func SetStatusFile(devicename string, s *Status) error {
+	device, err := os.Open(devicename)
+	if err != nil {
+		return err
+	}
+	defer device.Close()
+
+	return SetStatus(device.Fd(), s)
+}
+
+// List returns the status of all loop devices that have a file bound,
+// ordered by number.
+func List() ([]*Status, error) {
+	fi, err := ioutil.ReadDir("/sys/block")
+	if err != nil {
+		return nil, err
+	}
+	var l []*Status
+	for _, f := range fi {
+		if !strings.HasPrefix(f.Name(), "loop") {
+			continue
+		}
+		if _, err := strconv.Atoi(f.Name()[4:]); err != nil {
+			continue
+		}
+		s, err := GetStatusFile(filepath.Join("/dev", f.Name()))
+		if err == syscall.ENXIO || os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, s)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Number < l[j].Number })
+	return l, nil
+}
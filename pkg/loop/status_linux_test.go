@@ -0,0 +1,80 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loop
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestFlagsString(t *testing.T) {
+	for f, want := range map[Flags]string{
+		0:                               "",
+		FlagReadOnly:                    "ro",
+		FlagAutoclear | FlagPartScan:    "autoclear,partscan",
+		FlagDirectIO | FlagReadOnly | 2: "ro,dio,0x2",
+	} {
+		if got := f.String(); got != want {
+			t.Errorf("Flags(%#x).String() = %q, want %q", uint32(f), got, want)
+		}
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Must be root for this test")
+	}
+	f, err := ioutil.TempFile("", "loop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := f.Truncate(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+
+	dev, err := FindDevice()
+	if err != nil {
+		t.Skipf("no loop devices: %v", err)
+	}
+	want := &Status{Offset: 4096, SizeLimit: 8192, Flags: FlagReadOnly}
+	if err := ConfigureFiles(dev, f.Name(), want); err != nil {
+		t.Fatal(err)
+	}
+	defer ClearFdFile(dev)
+
+	got, err := GetStatusFile(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Dev() != dev || got.FileName != f.Name() || got.Offset != want.Offset || got.SizeLimit != want.SizeLimit || got.Flags&FlagReadOnly == 0 {
+		t.Errorf("GetStatusFile(%s) = %+v, want %+v", dev, got, want)
+	}
+
+	l, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, s := range l {
+		found = found || s.Dev() == dev
+	}
+	if !found {
+		t.Errorf("List() does not have %s", dev)
+	}
+
+	// Nothing else has the device open, so it is freed when
+	// SetStatusFile closes it.
+	got.Flags |= FlagAutoclear
+	if err := SetStatusFile(dev, got); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := GetStatusFile(dev); err != syscall.ENXIO {
+		t.Errorf("GetStatusFile(%s) after autoclear = %v, %v, want ENXIO", dev, s, err)
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// mbr implements reading and writing of MBR (DOS) partition tables,
+// including logical partitions in extended partitions.
+//
+// An MBR has four primary partition entries. One of them can be an
+// extended partition, which holds a chain of extended boot records (EBRs).
+// Each EBR describes one logical partition, relative to the EBR, and points
+// to the next EBR, relative to the start of the extended partition.
+package mbr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode"
+)
+
+const (
+	BlockSize        = 512
+	Signature uint16 = 0xaa55
+
+	// NPrimary is the number of partition entries in an MBR.
+	NPrimary = 4
+
+	// maxLogical bounds the EBR chain, which could be a loop.
+	maxLogical = 128
+)
+
+// Partition types.
+const (
+	Empty         = 0x00
+	FAT12         = 0x01
+	FAT16         = 0x06
+	Extended      = 0x05
+	NTFS          = 0x07
+	FAT32         = 0x0b
+	FAT32LBA      = 0x0c
+	FAT16LBA      = 0x0e
+	ExtendedLBA   = 0x0f
+	LinuxSwap     = 0x82
+	Linux         = 0x83
+	LinuxExtended = 0x85
+	LinuxLVM      = 0x8e
+	GPTProtective = 0xee
+	EFISystem     = 0xef
+	LinuxRAID     = 0xfd
+)
+
+// Bootable is the status of a partition marked active.
+const Bootable = 0x80
+
+// PartEntry is a partition entry as it is on disk.
+type PartEntry struct {
+	Status    uint8
+	CHSFirst  [3]byte
+	Type      uint8
+	CHSLast   [3]byte
+	FirstLBA  uint32
+	NumBlocks uint32
+}
+
+// MBR is a master or extended boot record as it is on disk.
+type MBR struct {
+	Bootstrap     [440]byte
+	DiskSignature uint32
+	Reserved      uint16
+	Parts         [NPrimary]PartEntry
+	Signature     uint16
+}
+
+// Partition is a primary or logical partition, with addresses relative to
+// the start of the disk.
+type Partition struct {
+	// Number is 1 to 4 for primary partitions, and 5 and up for
+	// logical partitions, as Linux numbers them.
+	Number   int
+	Bootable bool
+	Type     uint8
+	FirstLBA uint64
+	Blocks   uint64
+	// EBR is the block of the EBR of a logical partition.
+	EBR uint64
+}
+
+// Table is an MBR partition table.
+type Table struct {
+	MBR
+	// Logical are the logical partitions in the extended partition.
+	Logical []Partition
+}
+
+// IsExtended returns whether t is the type of an extended partition.
+func IsExtended(t uint8) bool {
+	return t == Extended || t == ExtendedLBA || t == LinuxExtended
+}
+
+func readMBR(r io.ReaderAt, lba uint64) (*MBR, error) {
+	var m MBR
+	if err := binary.Read(io.NewSectionReader(r, int64(lba*BlockSize), BlockSize), binary.LittleEndian, &m); err != nil {
+		return nil, fmt.Errorf("Reading boot record at block %d: %v", lba, err)
+	}
+	if m.Signature != Signature {
+		return nil, fmt.Errorf("Boot record at block %d has signature %#04x, want %#04x", lba, m.Signature, Signature)
+	}
+	return &m, nil
+}
+
+// Read reads an MBR and the logical partitions of its extended partition,
+// if it has one.
+func Read(r io.ReaderAt) (*Table, error) {
+	m, err := readMBR(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	t := &Table{MBR: *m}
+	ext := t.Extended()
+	if ext == nil {
+		return t, nil
+	}
+
+	start := uint64(ext.FirstLBA)
+	seen := map[uint64]bool{}
+	for ebr := start; ; {
+		if seen[ebr] || len(t.Logical) == maxLogical {
+			return t, fmt.Errorf("Extended partition at block %d: EBR chain loops at block %d", start, ebr)
+		}
+		seen[ebr] = true
+		e, err := readMBR(r, ebr)
+		if err != nil {
+			return t, err
+		}
+		p := e.Parts[0]
+		if p.Type != Empty {
+			t.Logical = append(t.Logical, Partition{
+				Number:   NPrimary + 1 + len(t.Logical),
+				Bootable: p.Status&Bootable != 0,
+				Type:     p.Type,
+				FirstLBA: ebr + uint64(p.FirstLBA),
+				Blocks:   uint64(p.NumBlocks),
+				EBR:      ebr,
+			})
+		}
+		next := e.Parts[1]
+		if !IsExtended(next.Type) || next.FirstLBA == 0 {
+			return t, nil
+		}
+		ebr = start + uint64(next.FirstLBA)
+	}
+}
+
+// Extended returns the extended partition entry, or nil if there is none.
+func (t *Table) Extended() *PartEntry {
+	for i := range t.Parts {
+		if IsExtended(t.Parts[i].Type) {
+			return &t.Parts[i]
+		}
+	}
+	return nil
+}
+
+// IsProtective returns whether the MBR is the protective MBR of a GPT.
+func (t *Table) IsProtective() bool {
+	for _, p := range t.Parts {
+		if p.Type == GPTProtective {
+			return true
+		}
+	}
+	return false
+}
+
+// Partitions returns the primary partitions, other than the extended
+// partition, followed by the logical partitions.
+func (t *Table) Partitions() []Partition {
+	var parts []Partition
+	for i, p := range t.Parts {
+		if p.Type == Empty || IsExtended(p.Type) {
+			continue
+		}
+		parts = append(parts, Partition{
+			Number:   i + 1,
+			Bootable: p.Status&Bootable != 0,
+			Type:     p.Type,
+			FirstLBA: uint64(p.FirstLBA),
+			Blocks:   uint64(p.NumBlocks),
+		})
+	}
+	return append(parts, t.Logical...)
+}
+
+// CHS returns the cylinder/head/sector address of lba as it is stored in a
+// partition entry, for the usual geometry of 255 heads and 63 sectors. Blocks
+// beyond what CHS can address get the largest address.
+func CHS(lba uint64) [3]byte {
+	const heads, sectors = 255, 63
+	c := lba / (heads * sectors)
+	if c > 1023 {
+		return [3]byte{0xfe, 0xff, 0xff}
+	}
+	h := lba / sectors % heads
+	s := lba%sectors + 1
+	return [3]byte{byte(h), byte(s) | byte(c>>8)<<6, byte(c)}
+}
+
+// Entry returns a partition entry for blocks first to first+blocks-1,
+// with first being relative to base.
+func Entry(typ uint8, bootable bool, base, first, blocks uint64) (PartEntry, error) {
+	if first < base || first-base > 0xffffffff || blocks > 0xffffffff {
+		return PartEntry{}, fmt.Errorf("Partition at block %d with %d blocks can not be addressed by an MBR", first, blocks)
+	}
+	e := PartEntry{
+		Type:      typ,
+		CHSFirst:  CHS(first),
+		CHSLast:   CHS(first + blocks - 1),
+		FirstLBA:  uint32(first - base),
+		NumBlocks: uint32(blocks),
+	}
+	if bootable {
+		e.Status = Bootable
+	}
+	return e, nil
+}
+
+func writeMBR(w io.WriterAt, lba uint64, m *MBR) error {
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, m); err != nil {
+		return err
+	}
+	if _, err := w.WriteAt(b.Bytes(), int64(lba*BlockSize)); err != nil {
+		return fmt.Errorf("Writing boot record at block %d: %v", lba, err)
+	}
+	return nil
+}
+
+// Write writes the MBR and, if there is an extended partition, the EBRs of
+// the logical partitions to w. Each logical partition has to be after its
+// EBR, and both have to be in the extended partition. The first EBR is at
+// the start of the extended partition.
+func Write(w io.WriterAt, t *Table) error {
+	m := t.MBR
+	m.Signature = Signature
+	if err := writeMBR(w, 0, &m); err != nil {
+		return err
+	}
+	ext := t.Extended()
+	if ext == nil {
+		if len(t.Logical) > 0 {
+			return fmt.Errorf("%d logical partitions but no extended partition", len(t.Logical))
+		}
+		return nil
+	}
+
+	start, end := uint64(ext.FirstLBA), uint64(ext.FirstLBA)+uint64(ext.NumBlocks)
+	if len(t.Logical) == 0 {
+		// An empty EBR ends the chain.
+		return writeMBR(w, start, &MBR{Signature: Signature})
+	}
+	if t.Logical[0].EBR != start {
+		return fmt.Errorf("The first EBR is at block %d, not at the start of the extended partition at %d", t.Logical[0].EBR, start)
+	}
+	for i, l := range t.Logical {
+		if l.EBR < start || l.FirstLBA <= l.EBR || l.FirstLBA+l.Blocks > end {
+			return fmt.Errorf("Logical partition %d at blocks %d-%d with EBR at %d is not in extended partition %d-%d", l.Number, l.FirstLBA, l.FirstLBA+l.Blocks-1, l.EBR, start, end-1)
+		}
+		e := MBR{Signature: Signature}
+		var err error
+		if e.Parts[0], err = Entry(l.Type, l.Bootable, l.EBR, l.FirstLBA, l.Blocks); err != nil {
+			return err
+		}
+		if i+1 < len(t.Logical) {
+			n := t.Logical[i+1]
+			if e.Parts[1], err = Entry(Extended, false, start, n.EBR, n.FirstLBA+n.Blocks-n.EBR); err != nil {
+				return err
+			}
+		}
+		if err := writeMBR(w, l.EBR, &e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PartitionPath returns the path of partition n of device dev, as Linux
+// names them: sda1, but mmcblk0p1 and nvme0n1p1.
+func PartitionPath(dev string, n int) string {
+	if len(dev) > 0 && unicode.IsDigit(rune(dev[len(dev)-1])) {
+		return dev + "p" + strconv.Itoa(n)
+	}
+	return dev + strconv.Itoa(n)
+}
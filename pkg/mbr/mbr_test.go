@@ -0,0 +1,129 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mbr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+type disk []byte
+
+func (d disk) WriteAt(b []byte, off int64) (int, error) {
+	copy(d[off:], b)
+	return len(b), nil
+}
+
+func testTable(t *testing.T) *Table {
+	var tab Table
+	var err error
+	for i, p := range []struct {
+		typ           uint8
+		first, blocks uint64
+	}{
+		{Linux, 2048, 2048},
+		{Extended, 4096, 8192},
+		{LinuxSwap, 12288, 2048},
+	} {
+		if tab.Parts[i], err = Entry(p.typ, i == 0, 0, p.first, p.blocks); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tab.Logical = []Partition{
+		{Number: 5, Type: Linux, FirstLBA: 4096 + 63, Blocks: 1000, EBR: 4096},
+		{Number: 6, Type: FAT32LBA, FirstLBA: 6144 + 2048, Blocks: 4096, EBR: 6144},
+	}
+	return &tab
+}
+
+func TestWriteRead(t *testing.T) {
+	d := make(disk, 16384*BlockSize)
+	tab := testTable(t)
+	if err := Write(d, tab); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Partition{
+		{Number: 1, Bootable: true, Type: Linux, FirstLBA: 2048, Blocks: 2048},
+		{Number: 3, Type: LinuxSwap, FirstLBA: 12288, Blocks: 2048},
+		{Number: 5, Type: Linux, FirstLBA: 4096 + 63, Blocks: 1000, EBR: 4096},
+		{Number: 6, Type: FAT32LBA, FirstLBA: 6144 + 2048, Blocks: 4096, EBR: 6144},
+	}
+	if p := got.Partitions(); !reflect.DeepEqual(p, want) {
+		t.Errorf("Partitions() = %v, want %v", p, want)
+	}
+	if got.IsProtective() {
+		t.Errorf("IsProtective() = true, want false")
+	}
+	// The second EBR is relative to the extended partition.
+	if n := binary.LittleEndian.Uint32(d[4096*BlockSize+0x1be+16+8:]); n != 2048 {
+		t.Errorf("Second EBR is at %d in the extended partition, want 2048", n)
+	}
+
+	// A logical partition outside the extended partition.
+	tab.Logical[1].Blocks = 8192
+	if err := Write(d, tab); err == nil {
+		t.Errorf("Write with a logical partition outside the extended partition: got nil, want error")
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	d := make(disk, 16384*BlockSize)
+	if _, err := Read(bytes.NewReader(d)); err == nil {
+		t.Errorf("Read without a signature: got nil, want error")
+	}
+
+	if err := Write(d, testTable(t)); err != nil {
+		t.Fatal(err)
+	}
+	// Point the second EBR at itself.
+	next := d[6144*BlockSize+0x1be+16:]
+	next[4] = Extended
+	binary.LittleEndian.PutUint32(next[8:], 2048)
+	tab, err := Read(bytes.NewReader(d))
+	if err == nil {
+		t.Errorf("Read with an EBR loop: got nil, want error")
+	}
+	if len(tab.Logical) != 2 {
+		t.Errorf("Read with an EBR loop returned %d logical partitions, want 2", len(tab.Logical))
+	}
+}
+
+func TestCHS(t *testing.T) {
+	for _, tt := range []struct {
+		lba uint64
+		chs [3]byte
+	}{
+		{0, [3]byte{0, 1, 0}},
+		{2048, [3]byte{32, 33, 0}},
+		{16450559, [3]byte{254, 0xff, 0xff}},
+		{1 << 32, [3]byte{0xfe, 0xff, 0xff}},
+	} {
+		if chs := CHS(tt.lba); chs != tt.chs {
+			t.Errorf("CHS(%d) = %#x, want %#x", tt.lba, chs, tt.chs)
+		}
+	}
+}
+
+func TestPartitionPath(t *testing.T) {
+	for _, tt := range []struct {
+		dev  string
+		n    int
+		path string
+	}{
+		{"/dev/sda", 1, "/dev/sda1"},
+		{"/dev/mmcblk0", 5, "/dev/mmcblk0p5"},
+		{"/dev/nvme0n1", 2, "/dev/nvme0n1p2"},
+	} {
+		if p := PartitionPath(tt.dev, tt.n); p != tt.path {
+			t.Errorf("PartitionPath(%q, %d) = %q, want %q", tt.dev, tt.n, p, tt.path)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mount
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FstabEntry is a line of an fstab(5) file. /proc/mounts uses the same
+// format.
+type FstabEntry struct {
+	// Spec is the device, such as /dev/sda1, UUID=... or LABEL=...
+	Spec string
+	// File is the mount point.
+	File string
+	// Type is the file system type.
+	Type string
+	// Options are the mount options.
+	Options []string
+	// Freq is used by dump(8).
+	Freq int
+	// PassNo is the order in which fsck(8) checks file systems.
+	PassNo int
+}
+
+// HasOption returns whether e has option opt.
+func (e *FstabEntry) HasOption(opt string) bool {
+	for _, o := range e.Options {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeFstab replaces the octal escapes fstab uses for blanks, such as
+// \040 for a space.
+func unescapeFstab(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// ParseFstab parses fstab lines from r. Blank lines and lines starting
+// with # are skipped.
+func ParseFstab(r io.Reader) ([]*FstabEntry, error) {
+	var entries []*FstabEntry
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			return nil, fmt.Errorf("fstab line %d: %q: want at least a device and a mount point", n, line)
+		}
+		e := &FstabEntry{
+			Spec:    unescapeFstab(f[0]),
+			File:    unescapeFstab(f[1]),
+			Type:    "auto",
+			Options: []string{"defaults"},
+		}
+		if len(f) > 2 {
+			e.Type = f[2]
+		}
+		if len(f) > 3 {
+			e.Options = strings.Split(f[3], ",")
+		}
+		var err error
+		if len(f) > 4 {
+			if e.Freq, err = strconv.Atoi(f[4]); err != nil {
+				return nil, fmt.Errorf("fstab line %d: dump frequency: %v", n, err)
+			}
+		}
+		if len(f) > 5 {
+			if e.PassNo, err = strconv.Atoi(f[5]); err != nil {
+				return nil, fmt.Errorf("fstab line %d: pass number: %v", n, err)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, s.Err()
+}
+
+// ReadFstab parses the fstab file name.
+func ReadFstab(name string) ([]*FstabEntry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseFstab(f)
+}
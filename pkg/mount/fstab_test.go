@@ -0,0 +1,63 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mount
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFstab(t *testing.T) {
+	const fstab = `# /etc/fstab
+UUID=5bd1c8f5-7b1c-4a8e-9f5e-0e3f6a8e4b2d /     ext4 rw,relatime 0 1
+
+LABEL=EFI  /boot/efi vfat umask=0077,noauto 0 2
+/srv/My\040Files /mnt/my\040files none bind
+tmpfs /tmp tmpfs
+/dev/sdb1 /data
+`
+	got, err := ParseFstab(strings.NewReader(fstab))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*FstabEntry{
+		{Spec: "UUID=5bd1c8f5-7b1c-4a8e-9f5e-0e3f6a8e4b2d", File: "/", Type: "ext4", Options: []string{"rw", "relatime"}, PassNo: 1},
+		{Spec: "LABEL=EFI", File: "/boot/efi", Type: "vfat", Options: []string{"umask=0077", "noauto"}, PassNo: 2},
+		{Spec: "/srv/My Files", File: "/mnt/my files", Type: "none", Options: []string{"bind"}},
+		{Spec: "tmpfs", File: "/tmp", Type: "tmpfs", Options: []string{"defaults"}},
+		{Spec: "/dev/sdb1", File: "/data", Type: "auto", Options: []string{"defaults"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		for _, e := range got {
+			t.Logf("%+v", e)
+		}
+		t.Errorf("ParseFstab() differs from %v", want)
+	}
+	if !got[1].HasOption("noauto") || got[0].HasOption("noauto") {
+		t.Errorf("HasOption(noauto) is wrong")
+	}
+
+	for _, bad := range []string{"/dev/sda1\n", "/dev/sda1 / ext4 rw x\n", "/dev/sda1 / ext4 rw 0 y\n"} {
+		if _, err := ParseFstab(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseFstab(%q): got nil, want error", bad)
+		}
+	}
+}
+
+func TestUnescapeFstab(t *testing.T) {
+	for in, want := range map[string]string{
+		`plain`:        "plain",
+		`a\040b`:       "a b",
+		`tab\011`:      "tab\t",
+		`back\134`:     `back\`,
+		`short\04`:     `short\04`,
+		`notoctal\089`: `notoctal\089`,
+	} {
+		if got := unescapeFstab(in); got != want {
+			t.Errorf("unescapeFstab(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+)
+
+// DefaultSocket is where init serves its Supervisor. Only root may use it,
+// as the requests stop services and the system.
+const DefaultSocket = "/run/uinit.sock"
+
+// Serve answers requests on l until it is closed. A request is a line of
+// words, and the answer is "ok" or "error: " and the error on a line,
+// followed by the output. The requests are
+//
+//	status              the status of the services
+//	log [NAME]          the log, or the lines of service NAME in it
+//	start NAME          start a service
+//	stop NAME           stop a service
+//	restart NAME        stop and start a service
+//	shutdown            stop all services, as Stop does
+func (s *Supervisor) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(c)
+	}
+}
+
+func (s *Supervisor) serve(c net.Conn) {
+	defer c.Close()
+	req, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		return
+	}
+	out, err := s.request(strings.Fields(req))
+	if err != nil {
+		fmt.Fprintf(c, "error: %v\n", err)
+		return
+	}
+	fmt.Fprintf(c, "ok\n%s", out)
+}
+
+func (s *Supervisor) request(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("empty request")
+	}
+	var b bytes.Buffer
+	switch cmd, args := args[0], args[1:]; {
+	case cmd == "status" && len(args) == 0:
+		for _, st := range s.Status() {
+			fmt.Fprintln(&b, st)
+		}
+	case cmd == "log" && len(args) < 2:
+		l := s.Log.Bytes()
+		if len(args) == 0 {
+			return string(l), nil
+		}
+		// Lines of a service start with its name, and those about it
+		// with the time and its name.
+		p := args[0] + ": "
+		for _, line := range strings.SplitAfter(string(l), "\n") {
+			if i := strings.Index(line, " "); strings.HasPrefix(line, p) || i > -1 && strings.HasPrefix(line[i+1:], p) {
+				b.WriteString(line)
+			}
+		}
+	case cmd == "start" && len(args) == 1:
+		return "", s.StartService(args[0])
+	case cmd == "stop" && len(args) == 1:
+		return "", s.StopService(args[0])
+	case cmd == "restart" && len(args) == 1:
+		return "", s.RestartService(args[0])
+	case cmd == "shutdown" && len(args) == 0:
+		s.Stop()
+	default:
+		return "", fmt.Errorf("bad request %q", strings.Join(append([]string{cmd}, args...), " "))
+	}
+	return b.String(), nil
+}
+
+// Command sends a request to the Supervisor served on socket, and
+// returns the output.
+func Command(socket string, args ...string) (string, error) {
+	c, err := net.Dial("unix", socket)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	if _, err := fmt.Fprintln(c, strings.Join(args, " ")); err != nil {
+		return "", err
+	}
+	r := bufio.NewReader(c)
+	st, err := r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("%s: no answer: %v", socket, err)
+	}
+	if st = strings.TrimSuffix(st, "\n"); st != "ok" {
+		return "", errors.New(strings.TrimPrefix(st, "error: "))
+	}
+	out, err := ioutil.ReadAll(r)
+	return string(out), err
+}
+
+// Shutdown asks init to stop its services, and waits until they have
+// stopped. It does nothing if init does not run services. Commands that
+// reboot or kexec call it first.
+func Shutdown() error {
+	if _, err := os.Stat(DefaultSocket); os.IsNotExist(err) {
+		return nil
+	}
+	_, err := Command(DefaultSocket, "shutdown")
+	return err
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Ring is a log that keeps the last bytes written to it. It is safe for
+// concurrent use.
+type Ring struct {
+	mu   sync.Mutex
+	buf  []byte
+	next int
+	full bool
+	// prev is the byte before the oldest one that is kept.
+	prev byte
+}
+
+// NewRing returns a Ring that keeps size bytes.
+func NewRing(size int) *Ring {
+	return &Ring{buf: make([]byte, size), prev: '\n'}
+}
+
+// Write adds b to the log, dropping the oldest bytes if it is full.
+func (r *Ring) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(b)
+	if len(r.buf) == 0 {
+		return n, nil
+	}
+	// When b does not fit, the byte before what is kept of it is in b.
+	var cut []byte
+	if len(b) > len(r.buf) {
+		cut = b[len(b)-len(r.buf)-1 : len(b)-len(r.buf)]
+		b = b[len(b)-len(r.buf):]
+	}
+	for len(b) > 0 {
+		c := len(r.buf) - r.next
+		if c > len(b) {
+			c = len(b)
+		}
+		if r.full {
+			r.prev = r.buf[r.next+c-1]
+		}
+		copy(r.buf[r.next:], b[:c])
+		b = b[c:]
+		r.next += c
+		if r.next == len(r.buf) {
+			r.next, r.full = 0, true
+		}
+	}
+	if cut != nil {
+		r.prev = cut[0]
+	}
+	return n, nil
+}
+
+// Bytes returns what is in the log, oldest first. If part of a line was
+// dropped, it starts at the next line.
+func (r *Ring) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]byte{}, r.buf[:r.next]...)
+	}
+	b := append(append([]byte{}, r.buf[r.next:]...), r.buf[:r.next]...)
+	if r.prev != '\n' {
+		if i := bytes.IndexByte(b, '\n'); i > -1 {
+			b = b[i+1:]
+		}
+	}
+	return b
+}
+
+// lineWriter writes lines to a Ring with a prefix. Lines are written
+// whole, so that the lines of services do not get mixed up.
+type lineWriter struct {
+	prefix string
+	ring   *Ring
+	mu     sync.Mutex
+	line   []byte
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			w.line = append(w.line, b...)
+			break
+		}
+		w.line = append(w.line, b[:i+1]...)
+		w.ring.Write(append([]byte(w.prefix), w.line...))
+		w.line = w.line[:0]
+		b = b[i+1:]
+	}
+	return n, nil
+}
+
+// flush writes what is left of a line.
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.line) > 0 {
+		w.ring.Write(append(append([]byte(w.prefix), w.line...), '\n'))
+		w.line = w.line[:0]
+	}
+}
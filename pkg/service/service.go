@@ -0,0 +1,188 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package service supervises long-running programs, such as sshd or
+// dhclient, for init.
+//
+// A service is defined by a file in a directory like /etc/uinit.d, named
+// for the service:
+//
+//	# /etc/uinit.d/sshd
+//	exec = /bbin/sshd -port 2222
+//	after = dhclient
+//	restart = always
+//	delay = 2s
+//	env = HOME=/root
+//
+// A Supervisor starts all services at once, except that a service starts
+// only when the services it is after are ready. It restarts services that
+// exit as their restart policy says, keeps what they print in a ring
+// buffer, and stops them in the reverse order on shutdown. Other programs
+// talk to it through a unix socket; see Serve and Command.
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Restart is when a service is restarted after it exits.
+type Restart int
+
+const (
+	// OnFailure restarts a service that exits with an error or is
+	// killed by a signal. It is the default.
+	OnFailure Restart = iota
+	// Always restarts a service however it exits.
+	Always
+	// Never leaves a service that exits alone.
+	Never
+)
+
+var restarts = map[string]Restart{
+	"on-failure": OnFailure,
+	"always":     Always,
+	"no":         Never,
+	"never":      Never,
+}
+
+func (r Restart) String() string {
+	switch r {
+	case Always:
+		return "always"
+	case Never:
+		return "no"
+	}
+	return "on-failure"
+}
+
+// DefaultDelay is how long a service waits before it is restarted, unless
+// its definition says otherwise.
+const DefaultDelay = time.Second
+
+// Service is the definition of a service.
+type Service struct {
+	Name string
+	// Args is the program and its arguments.
+	Args []string
+	// Env is added to the environment of the program.
+	Env []string
+	// Dir is the working directory of the program, if it is not "".
+	Dir string
+	// After are the services that have to be ready before this one
+	// starts.
+	After   []string
+	Restart Restart
+	// Delay is how long to wait before a restart.
+	Delay time.Duration
+	// Oneshot services are ready once they have exited successfully,
+	// and are not restarted then. Other services are ready as soon as
+	// they are started.
+	Oneshot bool
+}
+
+// Parse reads the definition of service name from r. It is lines of
+// key = value, and # starts a comment. The keys are
+//
+//	exec     the program and its arguments, split at spaces; required
+//	after    services to wait for, separated by spaces
+//	restart  always, on-failure or no
+//	delay    how long to wait before a restart, as in 500ms or 2s
+//	type     simple, the default, or oneshot
+//	env      NAME=VALUE to add to the environment; may be repeated
+//	dir      the working directory
+func Parse(name string, r io.Reader) (*Service, error) {
+	s := &Service{Name: name, Delay: DefaultDelay}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		l := sc.Text()
+		if i := strings.Index(l, "#"); i > -1 {
+			l = l[:i]
+		}
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s:%d: want key = value, got %q", name, n, l)
+		}
+		k, v := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch k {
+		case "exec":
+			s.Args = strings.Fields(v)
+		case "after":
+			s.After = append(s.After, strings.Fields(v)...)
+		case "restart":
+			r, ok := restarts[v]
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: restart is always, on-failure or no, not %q", name, n, v)
+			}
+			s.Restart = r
+		case "delay":
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", name, n, err)
+			}
+			s.Delay = d
+		case "type":
+			switch v {
+			case "simple":
+				s.Oneshot = false
+			case "oneshot":
+				s.Oneshot = true
+			default:
+				return nil, fmt.Errorf("%s:%d: type is simple or oneshot, not %q", name, n, v)
+			}
+		case "env":
+			if !strings.Contains(v, "=") {
+				return nil, fmt.Errorf("%s:%d: env is NAME=VALUE, not %q", name, n, v)
+			}
+			s.Env = append(s.Env, v)
+		case "dir":
+			s.Dir = v
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown key %q", name, n, k)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(s.Args) == 0 {
+		return nil, fmt.Errorf("%s: no exec", name)
+	}
+	return s, nil
+}
+
+// ReadDir reads the definitions in dir, a service per file. Files whose
+// names start with . are skipped.
+func ReadDir(dir string) ([]*Service, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var svcs []*Service
+	for _, fi := range fis {
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		s, err := Parse(fi.Name(), f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		svcs = append(svcs, s)
+	}
+	return svcs, nil
+}
@@ -0,0 +1,281 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		def  string
+		want *Service
+		err  string
+	}{
+		{
+			def: "# sshd\nexec = /bbin/sshd -port 2222\nafter = dhclient net\nrestart = always # always\ndelay = 2s\nenv = HOME=/root\nenv=A=b=c\ndir = /tmp\n",
+			want: &Service{Name: "svc", Args: []string{"/bbin/sshd", "-port", "2222"}, Env: []string{"HOME=/root", "A=b=c"},
+				Dir: "/tmp", After: []string{"dhclient", "net"}, Restart: Always, Delay: 2 * time.Second},
+		},
+		{
+			def:  "exec=/bin/true\ntype=oneshot\nrestart=no",
+			want: &Service{Name: "svc", Args: []string{"/bin/true"}, Restart: Never, Delay: DefaultDelay, Oneshot: true},
+		},
+		{def: "after = x", err: "svc: no exec"},
+		{def: "exec", err: `svc:1: want key = value, got "exec"`},
+		{def: "exec = a\nrestart = sometimes", err: `svc:2: restart is always, on-failure or no, not "sometimes"`},
+		{def: "exec = a\ntype = forking", err: `svc:2: type is simple or oneshot, not "forking"`},
+		{def: "exec = a\nenv = A", err: `svc:2: env is NAME=VALUE, not "A"`},
+		{def: "exec = a\nuser = root", err: `svc:2: unknown key "user"`},
+		{def: "exec = a\ndelay = soon", err: `svc:2: time: invalid duration "soon"`},
+	} {
+		s, err := Parse("svc", strings.NewReader(tt.def))
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("Parse(%q): got %v, want %v", tt.def, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(s, tt.want) {
+			t.Errorf("Parse(%q): got %+v, %v, want %+v", tt.def, s, err, tt.want)
+		}
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for n, def := range map[string]string{"b": "exec = b", "a": "exec = a", ".hidden": "junk"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, n), []byte(def), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	svcs, err := ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(svcs) != 2 || svcs[0].Name != "a" || svcs[1].Name != "b" {
+		t.Errorf("ReadDir: got %+v, want a and b", svcs)
+	}
+}
+
+func TestNew(t *testing.T) {
+	svc := func(name string, after ...string) *Service {
+		return &Service{Name: name, Args: []string{name}, After: after}
+	}
+	s, err := New([]*Service{svc("c", "b"), svc("a"), svc("b", "a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(s.order, want) {
+		t.Errorf("order: got %q, want %q", s.order, want)
+	}
+	for _, tt := range []struct {
+		svcs []*Service
+		err  string
+	}{
+		{[]*Service{svc("a"), svc("a")}, "service a is defined twice"},
+		{[]*Service{svc("a", "x")}, "service a is after x, which does not exist"},
+		{[]*Service{svc("a", "b"), svc("b", "c"), svc("c", "a")}, "service a is after itself"},
+	} {
+		if _, err := New(tt.svcs); err == nil || err.Error() != tt.err {
+			t.Errorf("New(%v): got %v, want %v", tt.svcs, err, tt.err)
+		}
+	}
+}
+
+func TestRing(t *testing.T) {
+	r := NewRing(16)
+	r.Write([]byte("one\ntwo\n"))
+	if got := string(r.Bytes()); got != "one\ntwo\n" {
+		t.Errorf("Bytes: got %q, want %q", got, "one\ntwo\n")
+	}
+	for _, tt := range []struct {
+		write, want string
+	}{
+		// Part of one is dropped, so two is the first line.
+		{"three\nfour\n", "two\nthree\nfour\n"},
+		// All of two is dropped.
+		{"five\n", "three\nfour\nfive\n"},
+		{"a very long line that does not fit\nend\n", "end\n"},
+		{"a very long line\nthat fits\n", "that fits\n"},
+	} {
+		r.Write([]byte(tt.write))
+		if got := string(r.Bytes()); got != tt.want {
+			t.Errorf("Bytes after %q: got %q, want %q", tt.write, got, tt.want)
+		}
+	}
+
+	w := &lineWriter{prefix: "x: ", ring: NewRing(64)}
+	w.Write([]byte("a\nb"))
+	w.Write([]byte("c\nd"))
+	w.flush()
+	if got := string(w.ring.Bytes()); got != "x: a\nx: bc\nx: d\n" {
+		t.Errorf("lineWriter: got %q", got)
+	}
+}
+
+// waitFor waits until the state of service name is state.
+func waitFor(t *testing.T, s *Supervisor, name, state string) Status {
+	for i := 0; i < 500; i++ {
+		for _, st := range s.Status() {
+			if st.Name == name && st.State == state {
+				return st
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s did not get to %s: %v\n%s", name, state, s.Status(), s.Log.Bytes())
+	return Status{}
+}
+
+func TestSupervisor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	flag := filepath.Join(dir, "flag")
+	svcs := []*Service{
+		// setup has to finish before daemon starts.
+		{Name: "setup", Args: []string{"/bin/sh", "-c", "sleep 0.1; echo set up; touch " + flag}, Oneshot: true, Restart: OnFailure},
+		{Name: "daemon", Args: []string{"/bin/sh", "-c", "test -e " + flag + " && echo $GREETING && exec sleep 100"}, Env: []string{"GREETING=hello"}, After: []string{"setup"}},
+		{Name: "flaky", Args: []string{"/bin/sh", "-c", "echo failing; exit 3"}, Delay: 10 * time.Millisecond},
+		{Name: "once", Args: []string{"/bin/true"}, Restart: OnFailure},
+		{Name: "late", Args: []string{"/bin/sleep", "100"}, After: []string{"daemon"}},
+		{Name: "missing", Args: []string{filepath.Join(dir, "nope")}, Restart: Never},
+	}
+	s, err := New(svcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.StopTimeout = time.Second
+	go s.Reap()
+	s.Start()
+
+	waitFor(t, s, "daemon", Running)
+	waitFor(t, s, "late", Running)
+	waitFor(t, s, "setup", Done)
+	waitFor(t, s, "once", Exited)
+	waitFor(t, s, "missing", Failed)
+	for i := 0; i < 500; i++ {
+		if st := waitFor(t, s, "flaky", Restarting); st.Restarts >= 2 {
+			if st.Last != "exit status 3" {
+				t.Errorf("flaky: last is %q, want %q", st.Last, "exit status 3")
+			}
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := s.StopService("daemon"); err != nil {
+		t.Fatal(err)
+	}
+	if st := waitFor(t, s, "daemon", Stopped); st.Pid != 0 || st.Last != "" {
+		t.Errorf("daemon: got %+v after stop", st)
+	}
+	if err := s.RestartService("daemon"); err != nil {
+		t.Fatal(err)
+	}
+	daemon := waitFor(t, s, "daemon", Running)
+	if err := s.StartService("nope"); err == nil {
+		t.Errorf("StartService(nope): got nil, want an error")
+	}
+
+	s.Stop()
+	for _, st := range s.Status() {
+		if st.State != Stopped || st.Pid != 0 {
+			t.Errorf("%s: got %+v after Stop, want it stopped", st.Name, st)
+		}
+	}
+	if err := s.StartService("daemon"); err == nil {
+		t.Errorf("StartService after Stop: got nil, want an error")
+	}
+
+	log := string(s.Log.Bytes())
+	for _, want := range []string{"setup: set up\n", "daemon: hello\n", "flaky: failing\n", "flaky: exit status 3\n", "daemon: stopped: killed by terminated\n"} {
+		if !strings.Contains(log, want) {
+			t.Errorf("log does not have %q:\n%s", want, log)
+		}
+	}
+	// late is stopped before daemon, which it is after.
+	if l, d := strings.Index(log, "late: stopped"), strings.LastIndex(log, "daemon: stopped"); l < 0 || l > d {
+		t.Errorf("late was not stopped before daemon:\n%s", log)
+	}
+	if err := syscall.Kill(daemon.Pid, 0); err == nil {
+		t.Errorf("daemon pid %d is still running", daemon.Pid)
+	}
+}
+
+func TestServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s, err := New([]*Service{
+		{Name: "a", Args: []string{"/bin/sh", "-c", "echo from a; exec sleep 100"}},
+		{Name: "b", Args: []string{"/bin/sh", "-c", "echo from b; exec sleep 100"}, After: []string{"a"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	go s.Reap()
+	s.Start()
+	waitFor(t, s, "b", Running)
+	for i := 0; i < 500 && !strings.Contains(string(s.Log.Bytes()), "from b"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	out, err := Command(sock, "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := strings.Split(out, "\n"); len(l) != 3 || !strings.HasPrefix(l[0], "a ") || !strings.Contains(l[1], Running) {
+		t.Errorf("status: got %q", out)
+	}
+	out, err = Command(sock, "log", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "b: from b\n") || strings.Contains(out, "from a") {
+		t.Errorf("log b: got %q", out)
+	}
+	if _, err := Command(sock, "stop", "b"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, s, "b", Stopped)
+	if _, err := Command(sock, "stop", "c"); err == nil || err.Error() != "no service c" {
+		t.Errorf("stop c: got %v, want no service c", err)
+	}
+	if _, err := Command(sock, "frobnicate"); err == nil || err.Error() != `bad request "frobnicate"` {
+		t.Errorf("frobnicate: got %v, want bad request", err)
+	}
+	if _, err := Command(sock, "shutdown"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, s, "a", Stopped)
+}
@@ -0,0 +1,436 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultDir is where init looks for service definitions.
+	DefaultDir = "/etc/uinit.d"
+	// DefaultLogSize is the size of the log of a Supervisor.
+	DefaultLogSize = 64 << 10
+	// DefaultStopTimeout is how long a service has to exit after
+	// SIGTERM before it gets SIGKILL.
+	DefaultStopTimeout = 5 * time.Second
+)
+
+// The states of a service.
+const (
+	Waiting    = "waiting"
+	Running    = "running"
+	Restarting = "restarting"
+	Done       = "done"
+	Exited     = "exited"
+	Failed     = "failed"
+	Stopped    = "stopped"
+)
+
+// Status is the state of a service.
+type Status struct {
+	Name  string
+	State string
+	// Pid is the process of a running service, and else 0.
+	Pid      int
+	Restarts int
+	// Since is when the service got to its state.
+	Since time.Time
+	// Last is how the service last exited, if it has.
+	Last string
+}
+
+func (s Status) String() string {
+	pid := "-"
+	if s.Pid != 0 {
+		pid = fmt.Sprint(s.Pid)
+	}
+	return fmt.Sprintf("%-16s %-10s %6s %4d  %s  %s", s.Name, s.State, pid, s.Restarts, s.Since.Format("15:04:05"), s.Last)
+}
+
+// A unit is a service as the Supervisor runs it.
+type unit struct {
+	svc *Service
+	out *lineWriter
+
+	// These are guarded by the Supervisor's mu.
+	status Status
+	// stop is closed to stop the service, and done is closed when it
+	// is stopped. They are nil when the service is not being run.
+	stop, done chan struct{}
+
+	// ready is closed when the services after this one can start.
+	ready     chan struct{}
+	readyOnce sync.Once
+	// exited gets the status of the process when it is reaped.
+	exited chan syscall.WaitStatus
+}
+
+func (u *unit) markReady() {
+	u.readyOnce.Do(func() { close(u.ready) })
+}
+
+// Supervisor runs services.
+//
+// The processes of the services are children of the process of the
+// Supervisor, and it has to be told when they exit with Reaped, or reap
+// them itself with Reap. It does not wait for them with os/exec, so that
+// it works in init, which has to reap all orphans.
+type Supervisor struct {
+	// Log gets what the services print, each line prefixed with the
+	// name of the service, and what happens to them.
+	Log *Ring
+	// StopTimeout is how long a service has to exit after SIGTERM
+	// before it gets SIGKILL.
+	StopTimeout time.Duration
+
+	mu    sync.Mutex
+	units map[string]*unit
+	// order is the names of the services, each after the ones it is
+	// after.
+	order []string
+	pids  map[int]*unit
+	// stopping is set by Stop, and stopped is closed when it is done.
+	stopping bool
+	stopped  chan struct{}
+}
+
+// New returns a Supervisor for svcs. It is an error if a service is after
+// one that does not exist, or if services are after each other.
+func New(svcs []*Service) (*Supervisor, error) {
+	s := &Supervisor{
+		Log:         NewRing(DefaultLogSize),
+		StopTimeout: DefaultStopTimeout,
+		units:       make(map[string]*unit),
+		pids:        make(map[int]*unit),
+		stopped:     make(chan struct{}),
+	}
+	for _, svc := range svcs {
+		if _, ok := s.units[svc.Name]; ok {
+			return nil, fmt.Errorf("service %s is defined twice", svc.Name)
+		}
+		s.units[svc.Name] = &unit{
+			svc:    svc,
+			out:    &lineWriter{prefix: svc.Name + ": ", ring: s.Log},
+			status: Status{Name: svc.Name, State: Stopped, Since: time.Now()},
+			ready:  make(chan struct{}),
+			exited: make(chan syscall.WaitStatus, 1),
+		}
+	}
+	names := make([]string, 0, len(svcs))
+	for n := range s.units {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	// visiting are the services on the path to the one being
+	// visited, to find cycles.
+	visiting, visited := map[string]bool{}, map[string]bool{}
+	var visit func(n string) error
+	visit = func(n string) error {
+		if visited[n] {
+			return nil
+		}
+		if visiting[n] {
+			return fmt.Errorf("service %s is after itself", n)
+		}
+		visiting[n] = true
+		for _, a := range s.units[n].svc.After {
+			if _, ok := s.units[a]; !ok {
+				return fmt.Errorf("service %s is after %s, which does not exist", n, a)
+			}
+			if err := visit(a); err != nil {
+				return err
+			}
+		}
+		visiting[n], visited[n] = false, true
+		s.order = append(s.order, n)
+		return nil
+	}
+	for _, n := range names {
+		if err := visit(n); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// logf adds a message about service name to the log.
+func (s *Supervisor) logf(name, format string, v ...interface{}) {
+	fmt.Fprintf(s.Log, "%s %s: %s\n", time.Now().Format("15:04:05"), name, fmt.Sprintf(format, v...))
+}
+
+// setState sets the state of u. s.mu must be held.
+func (u *unit) setState(state string) {
+	u.status.State, u.status.Since = state, time.Now()
+}
+
+func (s *Supervisor) set(u *unit, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u.setState(state)
+}
+
+// Start starts all services, each as soon as the ones it is after are
+// ready.
+func (s *Supervisor) Start() {
+	for _, n := range s.order {
+		s.StartService(n)
+	}
+}
+
+// StartService starts service name, unless it is already running.
+func (s *Supervisor) StartService(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopping {
+		return fmt.Errorf("services are shut down")
+	}
+	u, ok := s.units[name]
+	if !ok {
+		return fmt.Errorf("no service %s", name)
+	}
+	if u.done != nil {
+		select {
+		case <-u.done:
+		default:
+			return nil
+		}
+	}
+	u.stop, u.done = make(chan struct{}), make(chan struct{})
+	u.setState(Waiting)
+	go s.run(u, u.stop, u.done)
+	return nil
+}
+
+// StopService stops service name, and waits until it has exited.
+func (s *Supervisor) StopService(name string) error {
+	s.mu.Lock()
+	u, ok := s.units[name]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("no service %s", name)
+	}
+	stop, done := u.stop, u.done
+	u.stop, u.done = nil, nil
+	s.mu.Unlock()
+	if done == nil {
+		return nil
+	}
+	close(stop)
+	<-done
+	s.set(u, Stopped)
+	return nil
+}
+
+// RestartService stops and starts service name.
+func (s *Supervisor) RestartService(name string) error {
+	if err := s.StopService(name); err != nil {
+		return err
+	}
+	return s.StartService(name)
+}
+
+// Stop stops all services, each before the ones it is after, and waits
+// until they have exited. Services cannot be started after Stop.
+func (s *Supervisor) Stop() {
+	s.mu.Lock()
+	if s.stopping {
+		s.mu.Unlock()
+		<-s.stopped
+		return
+	}
+	s.stopping = true
+	s.mu.Unlock()
+	for i := len(s.order) - 1; i >= 0; i-- {
+		s.StopService(s.order[i])
+	}
+	close(s.stopped)
+}
+
+// Status returns the state of the services, in the order they start.
+func (s *Supervisor) Status() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var st []Status
+	for _, n := range s.order {
+		st = append(st, s.units[n].status)
+	}
+	return st
+}
+
+// Reaped tells s that process pid was reaped with status ws. It returns
+// whether pid was a service.
+func (s *Supervisor) Reaped(pid int, ws syscall.WaitStatus) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.pids[pid]
+	if !ok {
+		return false
+	}
+	delete(s.pids, pid)
+	u.exited <- ws
+	return true
+}
+
+// Reap reaps the children of the process until Stop has stopped all
+// services, and tells s about them.
+func (s *Supervisor) Reap() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGCHLD)
+	defer signal.Stop(c)
+	for {
+		for {
+			var ws syscall.WaitStatus
+			p, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+			if p <= 0 || err != nil {
+				break
+			}
+			s.Reaped(p, ws)
+		}
+		select {
+		case <-c:
+		case <-s.stopped:
+			return
+		}
+	}
+}
+
+// start starts the process of u.
+func (s *Supervisor) start(u *unit) (int, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer w.Close()
+	c := exec.Command(u.svc.Args[0], u.svc.Args[1:]...)
+	c.Env = append(os.Environ(), u.svc.Env...)
+	c.Dir = u.svc.Dir
+	c.Stdout, c.Stderr = w, w
+	// A process group of its own lets the service be stopped with its
+	// children.
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	// The process is not known to Reaped until it is in pids, so hold
+	// mu until it is.
+	s.mu.Lock()
+	if err := c.Start(); err != nil {
+		s.mu.Unlock()
+		r.Close()
+		return 0, err
+	}
+	pid := c.Process.Pid
+	s.pids[pid] = u
+	u.status.Pid = pid
+	u.setState(Running)
+	s.mu.Unlock()
+	c.Process.Release()
+
+	go func() {
+		io.Copy(u.out, r)
+		r.Close()
+		u.out.flush()
+	}()
+	return pid, nil
+}
+
+// kill stops process pid of u: SIGTERM, then SIGKILL if it does not exit
+// within the StopTimeout.
+func (s *Supervisor) kill(u *unit, pid int) {
+	syscall.Kill(-pid, syscall.SIGTERM)
+	select {
+	case ws := <-u.exited:
+		s.logf(u.svc.Name, "stopped: %s", exitString(ws))
+		return
+	case <-time.After(s.StopTimeout):
+	}
+	s.logf(u.svc.Name, "did not exit after SIGTERM, killing it")
+	syscall.Kill(-pid, syscall.SIGKILL)
+	select {
+	case <-u.exited:
+	case <-time.After(s.StopTimeout):
+		s.logf(u.svc.Name, "was not reaped")
+	}
+}
+
+func exitString(ws syscall.WaitStatus) string {
+	if ws.Signaled() {
+		return fmt.Sprintf("killed by %v", ws.Signal())
+	}
+	return fmt.Sprintf("exit status %d", ws.ExitStatus())
+}
+
+// run runs u until stop is closed, and closes done.
+func (s *Supervisor) run(u *unit, stop, done chan struct{}) {
+	defer close(done)
+	for _, a := range u.svc.After {
+		select {
+		case <-s.units[a].ready:
+		case <-stop:
+			return
+		}
+	}
+	for {
+		pid, err := s.start(u)
+		var ok bool
+		if err != nil {
+			s.logf(u.svc.Name, "%v", err)
+			s.mu.Lock()
+			u.status.Last = err.Error()
+			s.mu.Unlock()
+		} else {
+			s.logf(u.svc.Name, "started, pid %d", pid)
+			if !u.svc.Oneshot {
+				u.markReady()
+			}
+			var ws syscall.WaitStatus
+			select {
+			case ws = <-u.exited:
+			case <-stop:
+				s.kill(u, pid)
+				s.mu.Lock()
+				delete(s.pids, pid)
+				u.status.Pid = 0
+				s.mu.Unlock()
+				return
+			}
+			ok = ws.Exited() && ws.ExitStatus() == 0
+			s.logf(u.svc.Name, "%s", exitString(ws))
+			s.mu.Lock()
+			u.status.Pid, u.status.Last = 0, exitString(ws)
+			s.mu.Unlock()
+		}
+
+		switch {
+		case u.svc.Oneshot && ok:
+			u.markReady()
+			s.set(u, Done)
+			return
+		case u.svc.Restart == Never, u.svc.Restart == OnFailure && ok:
+			if ok {
+				s.set(u, Exited)
+			} else {
+				s.set(u, Failed)
+			}
+			return
+		}
+		s.set(u, Restarting)
+		select {
+		case <-time.After(u.svc.Delay):
+		case <-stop:
+			return
+		}
+		s.mu.Lock()
+		u.status.Restarts++
+		s.mu.Unlock()
+	}
+}
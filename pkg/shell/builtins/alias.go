@@ -0,0 +1,76 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builtins
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+func init() {
+	Register("alias", Func(alias))
+	Register("unalias", Func(unalias))
+}
+
+// alias defines or prints aliases.
+//
+// Synopsis:
+//     alias [NAME[=VALUE]]...
+//
+// Description:
+//     NAME=VALUE makes NAME, as the first word of a command, stand for the
+//     words of VALUE. NAME alone prints its alias, and no NAMEs all of them.
+func alias(ctx *Context) error {
+	aliases := ctx.Shell.Aliases()
+	args := ctx.Args[1:]
+	if len(args) == 0 {
+		var names []string
+		for n := range aliases {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		args = names
+	}
+	var err error
+	for _, a := range args {
+		if i := strings.IndexByte(a, '='); i > 0 {
+			aliases[a[:i]] = a[i+1:]
+			continue
+		}
+		v, ok := aliases[a]
+		if !ok {
+			err = fmt.Errorf("alias: %s: not found", a)
+			continue
+		}
+		fmt.Fprintf(ctx.Stdout, "alias %s=%s\n", a, quote(v))
+	}
+	return err
+}
+
+// unalias removes aliases.
+//
+// Synopsis:
+//     unalias -a
+//     unalias NAME...
+func unalias(ctx *Context) error {
+	aliases := ctx.Shell.Aliases()
+	args := ctx.Args[1:]
+	if len(args) == 1 && args[0] == "-a" {
+		for n := range aliases {
+			delete(aliases, n)
+		}
+		return nil
+	}
+	var err error
+	for _, a := range args {
+		if _, ok := aliases[a]; !ok {
+			err = fmt.Errorf("unalias: %s: not found", a)
+			continue
+		}
+		delete(aliases, a)
+	}
+	return err
+}
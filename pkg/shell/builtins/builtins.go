@@ -0,0 +1,126 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package builtins is a registry of shell builtins, commands that run in
+// the shell itself, and a set of standard ones: alias, unalias, cd, echo,
+// export, printf, pwd, read, source and ., test and [, umask, unset and
+// wait.
+//
+// Packages add builtins by calling Register in an init function, so a
+// shell gets them by importing the package, e.g. with
+//
+//     import _ "example.com/site/builtins"
+//
+// in a file of its own built with the shell.
+package builtins
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// A Shell is what builtins need of the shell that runs them.
+type Shell interface {
+	// Get returns the value of variable name, and whether it is set.
+	Get(name string) (string, bool)
+	// Set sets variable name.
+	Set(name, value string)
+	// Export puts variable name in the environment of commands.
+	Export(name string)
+	// Unset removes variable name.
+	Unset(name string)
+	// Environ returns the environment of commands as NAME=value.
+	Environ() []string
+	// Aliases returns the aliases of the shell. Builtins change them
+	// in the map.
+	Aliases() map[string]string
+	// Source runs the commands read from r, with args as the positional
+	// parameters if there are any, and returns the exit status of the
+	// last one.
+	Source(r io.Reader, args []string) int
+	// Wait waits for the jobs or process IDs, or all jobs if there are
+	// none, and returns the exit status of the last.
+	Wait(jobs []string) (int, error)
+}
+
+// A Context is what a builtin runs with.
+type Context struct {
+	// Args are the name of the builtin and its arguments.
+	Args   []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	Shell  Shell
+}
+
+// A Builtin is a command that runs in the shell. It returns nil for exit
+// status 0, an ExitStatus to fail quietly, and other errors, which the
+// shell prints, for exit status 1.
+type Builtin interface {
+	Run(ctx *Context) error
+}
+
+// Func makes a function a Builtin.
+type Func func(ctx *Context) error
+
+// Run implements Builtin.
+func (f Func) Run(ctx *Context) error {
+	return f(ctx)
+}
+
+// ExitStatus is the error of builtins that fail without a message.
+type ExitStatus int
+
+func (e ExitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// Status returns the exit status of a builtin that returned err.
+func Status(err error) int {
+	switch err := err.(type) {
+	case nil:
+		return 0
+	case ExitStatus:
+		return int(err)
+	}
+	return 1
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]Builtin)
+)
+
+// Register adds builtin b as name. A name can only be registered once.
+func Register(name string, b Builtin) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := registry[name]; ok {
+		return fmt.Errorf("%v already a builtin", name)
+	}
+	registry[name] = b
+	return nil
+}
+
+// Lookup returns the builtin registered as name.
+func Lookup(name string) (Builtin, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	b, ok := registry[name]
+	return b, ok
+}
+
+// Names returns the names of the builtins, sorted.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	var n []string
+	for name := range registry {
+		n = append(n, name)
+	}
+	sort.Strings(n)
+	return n
+}
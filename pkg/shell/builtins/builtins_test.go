@@ -0,0 +1,269 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builtins
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testShell is a Shell that keeps its state in maps.
+type testShell struct {
+	vars     map[string]string
+	exported map[string]bool
+	aliases  map[string]string
+	sourced  string
+	args     []string
+}
+
+func newTestShell() *testShell {
+	return &testShell{vars: map[string]string{}, exported: map[string]bool{}, aliases: map[string]string{}}
+}
+
+func (s *testShell) Get(name string) (string, bool) {
+	v, ok := s.vars[name]
+	return v, ok
+}
+
+func (s *testShell) Set(name, value string) {
+	s.vars[name] = value
+}
+
+func (s *testShell) Export(name string) {
+	s.exported[name] = true
+}
+
+func (s *testShell) Unset(name string) {
+	delete(s.vars, name)
+	delete(s.exported, name)
+}
+
+func (s *testShell) Environ() []string {
+	var e []string
+	for n := range s.exported {
+		e = append(e, n+"="+s.vars[n])
+	}
+	sort.Strings(e)
+	return e
+}
+
+func (s *testShell) Aliases() map[string]string {
+	return s.aliases
+}
+
+func (s *testShell) Source(r io.Reader, args []string) int {
+	b, _ := ioutil.ReadAll(r)
+	s.sourced, s.args = string(b), args
+	return len(args)
+}
+
+func (s *testShell) Wait(jobs []string) (int, error) {
+	if len(jobs) > 0 && jobs[0] == "%9" {
+		return 0, errors.New("%9: no such job")
+	}
+	return len(jobs), nil
+}
+
+// run runs builtin args[0] in s with stdin, and returns its output and
+// exit status.
+func run(t *testing.T, s *testShell, stdin string, args ...string) (string, int) {
+	b, ok := Lookup(args[0])
+	if !ok {
+		t.Fatalf("%s is not a builtin", args[0])
+	}
+	var out bytes.Buffer
+	err := b.Run(&Context{Args: args, Stdin: strings.NewReader(stdin), Stdout: &out, Stderr: ioutil.Discard, Shell: s})
+	return out.String(), Status(err)
+}
+
+func TestRegister(t *testing.T) {
+	if err := Register("echo", Func(echo)); err == nil {
+		t.Errorf("Register(echo): got nil, want an error")
+	}
+	if err := Register("testbuiltin", Func(func(ctx *Context) error { return ExitStatus(3) })); err != nil {
+		t.Fatal(err)
+	}
+	if _, st := run(t, newTestShell(), "", "testbuiltin"); st != 3 {
+		t.Errorf("testbuiltin: got %d, want 3", st)
+	}
+	n := Names()
+	if !sort.StringsAreSorted(n) {
+		t.Errorf("Names() is not sorted: %q", n)
+	}
+	for _, b := range []string{"alias", "cd", "echo", "export", "printf", "pwd", "read", "source", ".", "test", "[", "umask", "unalias", "unset", "wait", "testbuiltin"} {
+		if _, ok := Lookup(b); !ok {
+			t.Errorf("Lookup(%q): not found", b)
+		}
+	}
+}
+
+func TestOutput(t *testing.T) {
+	for _, tt := range []struct {
+		args   []string
+		stdout string
+		status int
+	}{
+		{[]string{"echo", "a", "b"}, "a b\n", 0},
+		{[]string{"echo", "-n", "a"}, "a", 0},
+		{[]string{"echo", "-e", `a\tb\0101\x41\cc`}, "a\tbAA", 0},
+		{[]string{"echo", "-x", `a\tb`}, "-x a\\tb\n", 0},
+		{[]string{"printf", `%s-%5s|%-3d|%03d\n`, "a", "b", "1", "2"}, "a-    b|1  |002\n", 0},
+		{[]string{"printf", `%s\n`, "a", "b", "c"}, "a\nb\nc\n", 0},
+		{[]string{"printf", `%x %X %o %u %c %.2f %e\n`, "255", "255", "8", "3", "hello", "3.14159", "1000"}, "ff FF 10 3 h 3.14 1.000000e+03\n", 0},
+		{[]string{"printf", `%d %d %*d\n`, "'A", "0x10", "4", "7"}, "65 16    7\n", 0},
+		{[]string{"printf", `%b|\101%%\n`, `x\ty`}, "x\ty|A%\n", 0},
+		{[]string{"printf", `%d\n`, "abc"}, "0\n", 1},
+		{[]string{"printf", `a\cb`}, "a", 0},
+		{[]string{"test", "a", "=", "a"}, "", 0},
+		{[]string{"[", "-d", "/", "-a", "!", "-f", "/", "]"}, "", 0},
+		{[]string{"[", "1", "-gt", "2", "]"}, "", 1},
+		{[]string{"[", "1", "-gt", "2"}, "", 1},
+		{[]string{"test"}, "", 1},
+		{[]string{"wait", "%1", "%2"}, "", 2},
+		{[]string{"wait", "%9"}, "", 1},
+	} {
+		out, st := run(t, newTestShell(), "", tt.args...)
+		if out != tt.stdout || st != tt.status {
+			t.Errorf("%q: got %q, %d, want %q, %d", tt.args, out, st, tt.stdout, tt.status)
+		}
+	}
+}
+
+func TestVars(t *testing.T) {
+	s := newTestShell()
+	s.vars["x"] = "1"
+	if _, st := run(t, s, "", "export", "x", "y=2 3"); st != 0 {
+		t.Fatalf("export: got %d, want 0", st)
+	}
+	if out, _ := run(t, s, "", "export"); out != "export x='1'\nexport y='2 3'\n" {
+		t.Errorf("export: got %q", out)
+	}
+	if _, st := run(t, s, "", "export", "a-b=1"); st != 1 {
+		t.Errorf("export a-b=1: got %d, want 1", st)
+	}
+	run(t, s, "", "unset", "x")
+	if _, ok := s.vars["x"]; ok {
+		t.Errorf("unset x: x is still set")
+	}
+
+	for _, tt := range []struct {
+		stdin  string
+		args   []string
+		vars   map[string]string
+		status int
+	}{
+		{"  a b  c d  \nnext", []string{"read", "x", "y"}, map[string]string{"x": "a", "y": "b  c d"}, 0},
+		{"a\\ b\\\nc d", []string{"read", "x", "y"}, map[string]string{"x": "a bc", "y": "d"}, 1},
+		{"a\\ b c", []string{"read", "-r", "x", "y"}, map[string]string{"x": "a\\", "y": "b c"}, 1},
+		{"line\n", []string{"read"}, map[string]string{"REPLY": "line"}, 0},
+		{"a:b::c\n", []string{"read", "IFS=:", "x", "y", "z"}, map[string]string{"x": "a", "y": "b", "z": ":c"}, 0},
+		{"", []string{"read", "x"}, map[string]string{"x": ""}, 1},
+	} {
+		s := newTestShell()
+		args := tt.args
+		if len(args) > 1 && strings.HasPrefix(args[1], "IFS=") {
+			s.vars["IFS"] = args[1][4:]
+			args = append(args[:1], args[2:]...)
+		}
+		_, st := run(t, s, tt.stdin, args...)
+		delete(s.vars, "IFS")
+		if !reflect.DeepEqual(s.vars, tt.vars) || st != tt.status {
+			t.Errorf("%q with %q: got %q, %d, want %q, %d", tt.args, tt.stdin, s.vars, st, tt.vars, tt.status)
+		}
+	}
+}
+
+func TestAlias(t *testing.T) {
+	s := newTestShell()
+	run(t, s, "", "alias", "ll=ls -l", "la=ls -a")
+	if out, _ := run(t, s, "", "alias"); out != "alias la='ls -a'\nalias ll='ls -l'\n" {
+		t.Errorf("alias: got %q", out)
+	}
+	if out, st := run(t, s, "", "alias", "ll", "nope"); out != "alias ll='ls -l'\n" || st != 1 {
+		t.Errorf("alias ll nope: got %q, %d", out, st)
+	}
+	run(t, s, "", "unalias", "ll")
+	if _, ok := s.aliases["ll"]; ok {
+		t.Errorf("unalias ll: ll is still an alias")
+	}
+	run(t, s, "", "unalias", "-a")
+	if len(s.aliases) != 0 {
+		t.Errorf("unalias -a: got %q, want none", s.aliases)
+	}
+}
+
+func TestDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestDir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	tmpDir, err = filepath.EvalSymlinks(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	s := newTestShell()
+	if _, st := run(t, s, "", "cd", tmpDir); st != 0 {
+		t.Fatalf("cd %s: got %d, want 0", tmpDir, st)
+	}
+	if s.vars["PWD"] != tmpDir || s.vars["OLDPWD"] != wd {
+		t.Errorf("cd %s: PWD, OLDPWD are %q, %q, want %q, %q", tmpDir, s.vars["PWD"], s.vars["OLDPWD"], tmpDir, wd)
+	}
+	if out, _ := run(t, s, "", "pwd"); out != tmpDir+"\n" {
+		t.Errorf("pwd: got %q, want %q", out, tmpDir+"\n")
+	}
+	if out, _ := run(t, s, "", "cd", "-"); out != wd+"\n" {
+		t.Errorf("cd -: got %q, want %q", out, wd+"\n")
+	}
+	if _, st := run(t, s, "", "cd", filepath.Join(tmpDir, "nope")); st != 1 {
+		t.Errorf("cd nope: got %d, want 1", st)
+	}
+
+	old, _ := run(t, s, "", "umask")
+	defer run(t, s, "", "umask", strings.TrimSpace(old))
+	run(t, s, "", "umask", "027")
+	if out, _ := run(t, s, "", "umask"); out != "0027\n" {
+		t.Errorf("umask: got %q, want %q", out, "0027\n")
+	}
+	if out, _ := run(t, s, "", "umask", "-S"); out != "u=rwx,g=rx,o=\n" {
+		t.Errorf("umask -S: got %q, want %q", out, "u=rwx,g=rx,o=\n")
+	}
+	if _, st := run(t, s, "", "umask", "8"); st != 1 {
+		t.Errorf("umask 8: got %d, want 1", st)
+	}
+}
+
+func TestSource(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestSource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "script"), []byte("echo hi\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestShell()
+	s.vars["PATH"] = "/nonexistent:" + tmpDir
+	if _, st := run(t, s, "", ".", "script", "a", "b"); st != 2 || s.sourced != "echo hi\n" || !reflect.DeepEqual(s.args, []string{"a", "b"}) {
+		t.Errorf(". script a b: got %d, %q, %q", st, s.sourced, s.args)
+	}
+	if _, st := run(t, s, "", "source", "nope"); st != 1 {
+		t.Errorf("source nope: got %d, want 1", st)
+	}
+}
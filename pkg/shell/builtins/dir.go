@@ -0,0 +1,122 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builtins
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+func init() {
+	Register("cd", Func(cd))
+	Register("pwd", Func(pwd))
+	Register("umask", Func(umask))
+}
+
+// cd changes the working directory.
+//
+// Synopsis:
+//     cd [DIR]
+//     cd -
+//
+// Description:
+//     Without DIR, cd goes to $HOME, and with -, to $OLDPWD. $PWD and
+//     $OLDPWD are set to the new and old working directories.
+func cd(ctx *Context) error {
+	args := ctx.Args[1:]
+	if len(args) > 1 {
+		return errors.New("usage: cd [DIR]")
+	}
+	dir, _ := ctx.Shell.Get("HOME")
+	if len(args) == 1 {
+		dir = args[0]
+	}
+	back := dir == "-"
+	if back {
+		dir, _ = ctx.Shell.Get("OLDPWD")
+	}
+	if dir == "" {
+		return errors.New("cd: no directory")
+	}
+	old, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	ctx.Shell.Set("OLDPWD", old)
+	ctx.Shell.Set("PWD", wd)
+	if back {
+		fmt.Fprintln(ctx.Stdout, wd)
+	}
+	return nil
+}
+
+// pwd prints the working directory.
+//
+// Synopsis:
+//     pwd [-L|-P]
+func pwd(ctx *Context) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(ctx.Stdout, wd)
+	return nil
+}
+
+// umask prints or sets the file mode creation mask.
+//
+// Synopsis:
+//     umask [-S] [MODE]
+//
+// Description:
+//     MODE is octal. -S prints the mask as the permissions it leaves, e.g.
+//     u=rwx,g=rx,o=rx.
+func umask(ctx *Context) error {
+	args := ctx.Args[1:]
+	sym := len(args) > 0 && args[0] == "-S"
+	if sym {
+		args = args[1:]
+	}
+	switch len(args) {
+	case 0:
+	case 1:
+		m, err := strconv.ParseUint(args[0], 8, 32)
+		if err != nil || m > 0777 {
+			return fmt.Errorf("umask: bad mode %q", args[0])
+		}
+		syscall.Umask(int(m))
+		return nil
+	default:
+		return errors.New("usage: umask [-S] [MODE]")
+	}
+	m := syscall.Umask(0)
+	syscall.Umask(m)
+	if !sym {
+		fmt.Fprintf(ctx.Stdout, "%04o\n", m)
+		return nil
+	}
+	var s string
+	for i, who := range []string{"u", "g", "o"} {
+		p := ^m >> uint(6-3*i)
+		s += who + "="
+		for k, c := range "rwx" {
+			if p&(4>>uint(k)) != 0 {
+				s += string(c)
+			}
+		}
+		if i < 2 {
+			s += ","
+		}
+	}
+	fmt.Fprintln(ctx.Stdout, s)
+	return nil
+}
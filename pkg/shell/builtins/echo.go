@@ -0,0 +1,284 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builtins
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register("echo", Func(echo))
+	Register("printf", Func(printf))
+}
+
+// escape interprets the backslash escapes in s. With zero, octal escapes
+// are \0NNN, as for echo and %b, and else \NNN, as in printf formats. It
+// also returns whether \c ended the output.
+func escape(s string, zero bool) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		e, n, stop := escapeOne(s[i:], zero)
+		b.WriteString(e)
+		if stop {
+			return b.String(), true
+		}
+		i += n
+	}
+	return b.String(), false
+}
+
+// escapeOne interprets the escape s starts with. It returns what it
+// stands for, its length, and whether it is \c.
+func escapeOne(s string, zero bool) (string, int, bool) {
+	if len(s) < 2 {
+		return s, len(s), false
+	}
+	if e := strings.IndexByte(`\abefnrtv`, s[1]); e > -1 {
+		return "\\\a\b\x1b\f\n\r\t\v"[e : e+1], 2, false
+	}
+	switch c := s[1]; {
+	case c == 'c':
+		return "", 2, true
+	case c == 'x':
+		n := 2
+		for n < 4 && n < len(s) && strings.IndexByte("0123456789abcdefABCDEF", s[n]) > -1 {
+			n++
+		}
+		if n == 2 {
+			return s[:2], 2, false
+		}
+		v, _ := strconv.ParseUint(s[2:n], 16, 8)
+		return string([]byte{byte(v)}), n, false
+	case c >= '0' && c <= '7' && (!zero || c == '0'):
+		// With zero, the 0 does not count as a digit.
+		i := 1
+		if zero {
+			i = 2
+		}
+		n := i
+		for n < i+3 && n < len(s) && s[n] >= '0' && s[n] <= '7' {
+			n++
+		}
+		v, _ := strconv.ParseUint("0"+s[i:n], 8, 16)
+		return string([]byte{byte(v)}), n, false
+	}
+	return s[:2], 2, false
+}
+
+// echo prints its arguments.
+//
+// Synopsis:
+//     echo [-neE] [ARG]...
+//
+// Options:
+//     -n: no newline at the end
+//     -e: interpret backslash escapes: \\, \a, \b, \c (end the output),
+//         \e, \f, \n, \r, \t, \v, \0NNN and \xHH
+//     -E: do not interpret them, the default
+func echo(ctx *Context) error {
+	args := ctx.Args[1:]
+	newline, esc := true, false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' && strings.Trim(args[0][1:], "neE") == "" {
+		for _, c := range args[0][1:] {
+			switch c {
+			case 'n':
+				newline = false
+			case 'e':
+				esc = true
+			case 'E':
+				esc = false
+			}
+		}
+		args = args[1:]
+	}
+	s := strings.Join(args, " ")
+	if esc {
+		var stop bool
+		if s, stop = escape(s, true); stop {
+			newline = false
+		}
+	}
+	if newline {
+		s += "\n"
+	}
+	_, err := io.WriteString(ctx.Stdout, s)
+	return err
+}
+
+// printf formats and prints its arguments.
+//
+// Synopsis:
+//     printf FORMAT [ARG]...
+//
+// Description:
+//     FORMAT has the escapes of echo -e, with \NNN for octal, and the
+//     conversions %s, %b (a string with escapes), %c, %d, %i, %o, %u, %x,
+//     %X, %e, %E, %f, %F, %g, %G and %%, with flags, width and precision.
+//     A width or precision of * is taken from the arguments. FORMAT is
+//     used again as long as there are arguments left. Numbers may be
+//     given as 'C, for the code of character C.
+func printf(ctx *Context) error {
+	if len(ctx.Args) < 2 {
+		return errors.New("usage: printf FORMAT [ARG]...")
+	}
+	p := &printer{args: ctx.Args[2:]}
+	for {
+		n := len(p.args)
+		if p.format(ctx.Args[1]) {
+			break
+		}
+		if len(p.args) == 0 || len(p.args) == n {
+			break
+		}
+	}
+	if _, err := io.WriteString(ctx.Stdout, p.out.String()); err != nil {
+		return err
+	}
+	return p.err
+}
+
+// A printer is the state of printf.
+type printer struct {
+	args []string
+	out  strings.Builder
+	err  error
+}
+
+// arg returns the next argument, or "" if there are none left.
+func (p *printer) arg() string {
+	if len(p.args) == 0 {
+		return ""
+	}
+	a := p.args[0]
+	p.args = p.args[1:]
+	return a
+}
+
+// number converts a to a number with parse, or, for 'C or "C, to the code
+// of C.
+func (p *printer) number(a string, parse func(string) error) {
+	if a == "" {
+		return
+	}
+	if a[0] == '\'' || a[0] == '"' {
+		if len(a) > 1 {
+			parse(strconv.Itoa(int([]rune(a[1:])[0])))
+		}
+		return
+	}
+	if err := parse(a); err != nil {
+		p.err = fmt.Errorf("printf: %s: invalid number", a)
+	}
+}
+
+func (p *printer) integer(a string) int64 {
+	var v int64
+	p.number(a, func(s string) (err error) {
+		v, err = strconv.ParseInt(s, 0, 64)
+		return err
+	})
+	return v
+}
+
+// format prints once with format f. It returns whether \c ended the output.
+func (p *printer) format(f string) bool {
+	for i := 0; i < len(f); i++ {
+		switch c := f[i]; {
+		case c == '\\':
+			e, n, stop := escapeOne(f[i:], false)
+			p.out.WriteString(e)
+			if stop {
+				return true
+			}
+			i += n - 1
+		case c != '%':
+			p.out.WriteByte(c)
+		case i+1 < len(f) && f[i+1] == '%':
+			p.out.WriteByte('%')
+			i++
+		default:
+			// A conversion is flags, width, precision and a verb.
+			spec := "%"
+			i++
+			for i < len(f) && strings.IndexByte("-+ #0", f[i]) > -1 {
+				spec += f[i : i+1]
+				i++
+			}
+			for _, part := range []string{"", "."} {
+				if part == "." {
+					if i >= len(f) || f[i] != '.' {
+						break
+					}
+					spec += "."
+					i++
+				}
+				if i < len(f) && f[i] == '*' {
+					spec += strconv.FormatInt(p.integer(p.arg()), 10)
+					i++
+					continue
+				}
+				for i < len(f) && f[i] >= '0' && f[i] <= '9' {
+					spec += f[i : i+1]
+					i++
+				}
+			}
+			if i >= len(f) {
+				p.out.WriteString(spec)
+				return false
+			}
+			if p.convert(spec, f[i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// convert prints the next argument with spec and verb. It returns whether
+// \c ended the output.
+func (p *printer) convert(spec string, verb byte) bool {
+	a := p.arg()
+	switch verb {
+	case 's':
+		p.out.WriteString(fmt.Sprintf(spec+"s", a))
+	case 'b':
+		s, stop := escape(a, true)
+		p.out.WriteString(fmt.Sprintf(spec+"s", s))
+		return stop
+	case 'c':
+		if a != "" {
+			a = string([]rune(a)[:1])
+		}
+		p.out.WriteString(fmt.Sprintf(spec+"s", a))
+	case 'd', 'i':
+		p.out.WriteString(fmt.Sprintf(spec+"d", p.integer(a)))
+	case 'o', 'u', 'x', 'X':
+		v := uint64(p.integer(a))
+		if verb == 'u' {
+			verb = 'd'
+		}
+		p.out.WriteString(fmt.Sprintf(spec+string(verb), v))
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		var v float64
+		p.number(a, func(s string) (err error) {
+			v, err = strconv.ParseFloat(s, 64)
+			return err
+		})
+		p.out.WriteString(fmt.Sprintf(spec+string(verb), v))
+	default:
+		p.err = fmt.Errorf("printf: %%%c: invalid conversion", verb)
+		p.args = append([]string{a}, p.args...)
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builtins
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	Register("source", Func(source))
+	Register(".", Func(source))
+	Register("wait", Func(wait))
+}
+
+// source runs the commands in a file in the shell.
+//
+// Synopsis:
+//     source FILE [ARG]...
+//     . FILE [ARG]...
+//
+// Description:
+//     FILE without a / is looked for in $PATH if it is not in the working
+//     directory. With ARGs, they are the positional parameters while FILE
+//     runs.
+func source(ctx *Context) error {
+	if len(ctx.Args) < 2 {
+		return errors.New("usage: source FILE [ARG]...")
+	}
+	name := ctx.Args[1]
+	f, err := os.Open(name)
+	if err != nil && !strings.Contains(name, "/") {
+		path, _ := ctx.Shell.Get("PATH")
+		for _, d := range filepath.SplitList(path) {
+			if p, err2 := os.Open(filepath.Join(d, name)); err2 == nil {
+				f, err = p, nil
+				break
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if st := ctx.Shell.Source(f, ctx.Args[2:]); st != 0 {
+		return ExitStatus(st)
+	}
+	return nil
+}
+
+// wait waits for jobs.
+//
+// Synopsis:
+//     wait [%JOB|PID]...
+//
+// Description:
+//     Without arguments, wait waits for all jobs that are running. The exit
+//     status is that of the last job.
+func wait(ctx *Context) error {
+	st, err := ctx.Shell.Wait(ctx.Args[1:])
+	if err != nil {
+		return err
+	}
+	if st != 0 {
+		return ExitStatus(st)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builtins
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	Register("test", Func(test))
+	Register("[", Func(test))
+}
+
+// testExpr is an expression being evaluated.
+type testExpr []string
+
+func (e *testExpr) next() string {
+	s := (*e)[0]
+	*e = (*e)[1:]
+	return s
+}
+
+func (e *testExpr) or() (bool, error) {
+	v, err := e.and()
+	for err == nil && len(*e) > 0 && (*e)[0] == "-o" {
+		e.next()
+		var w bool
+		w, err = e.and()
+		v = v || w
+	}
+	return v, err
+}
+
+func (e *testExpr) and() (bool, error) {
+	v, err := e.not()
+	for err == nil && len(*e) > 0 && (*e)[0] == "-a" {
+		e.next()
+		var w bool
+		w, err = e.not()
+		v = v && w
+	}
+	return v, err
+}
+
+func (e *testExpr) not() (bool, error) {
+	if len(*e) > 1 && (*e)[0] == "!" {
+		e.next()
+		v, err := e.not()
+		return !v, err
+	}
+	return e.primary()
+}
+
+var binaryOps = map[string]bool{"=": true, "!=": true, "-eq": true, "-ne": true, "-lt": true, "-le": true, "-gt": true, "-ge": true}
+
+func (e *testExpr) primary() (bool, error) {
+	if len(*e) == 0 {
+		return false, errors.New("test: argument expected")
+	}
+	if len(*e) > 2 && binaryOps[(*e)[1]] {
+		return e.binary()
+	}
+	s := e.next()
+	if s == "(" {
+		v, err := e.or()
+		if err != nil {
+			return false, err
+		}
+		if len(*e) == 0 || e.next() != ")" {
+			return false, errors.New("test: missing )")
+		}
+		return v, nil
+	}
+	if len(s) != 2 || s[0] != '-' || len(*e) == 0 {
+		return s != "", nil
+	}
+	a := e.next()
+	switch s {
+	case "-n":
+		return a != "", nil
+	case "-z":
+		return a == "", nil
+	case "-r":
+		return unix.Access(a, unix.R_OK) == nil, nil
+	case "-w":
+		return unix.Access(a, unix.W_OK) == nil, nil
+	case "-x":
+		return unix.Access(a, unix.X_OK) == nil, nil
+	case "-L", "-h":
+		fi, err := os.Lstat(a)
+		return err == nil && fi.Mode()&os.ModeSymlink != 0, nil
+	}
+	fi, err := os.Stat(a)
+	switch s {
+	case "-e":
+		return err == nil, nil
+	case "-f":
+		return err == nil && fi.Mode().IsRegular(), nil
+	case "-d":
+		return err == nil && fi.IsDir(), nil
+	case "-s":
+		return err == nil && fi.Size() > 0, nil
+	}
+	return false, fmt.Errorf("test: unknown operator %s", s)
+}
+
+func (e *testExpr) binary() (bool, error) {
+	a, op, b := e.next(), e.next(), e.next()
+	switch op {
+	case "=":
+		return a == b, nil
+	case "!=":
+		return a != b, nil
+	}
+	x, err := strconv.ParseInt(a, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("test: %s: integer expected", a)
+	}
+	y, err := strconv.ParseInt(b, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("test: %s: integer expected", b)
+	}
+	switch op {
+	case "-eq":
+		return x == y, nil
+	case "-ne":
+		return x != y, nil
+	case "-lt":
+		return x < y, nil
+	case "-le":
+		return x <= y, nil
+	case "-gt":
+		return x > y, nil
+	}
+	return x >= y, nil
+}
+
+// test evaluates a condition.
+//
+// Synopsis:
+//     test EXPRESSION
+//     [ EXPRESSION ]
+//
+// Description:
+//     The exit status is 0 if EXPRESSION is true, and 1 if it is false.
+//     EXPRESSION is made of
+//         -e, -f, -d, -L, -r, -w, -x, -s FILE: FILE exists, and is a
+//             regular file, a directory, a symbolic link, readable,
+//             writable, executable or not empty
+//         -n, -z STRING: STRING is not empty or empty
+//         STRING: STRING is not empty
+//         STRING = STRING, STRING != STRING
+//         INTEGER -eq, -ne, -lt, -le, -gt, -ge INTEGER
+//         ! EXPRESSION, EXPRESSION -a EXPRESSION, EXPRESSION -o EXPRESSION
+//         ( EXPRESSION )
+func test(ctx *Context) error {
+	args := ctx.Args[1:]
+	if ctx.Args[0] == "[" {
+		if len(args) == 0 || args[len(args)-1] != "]" {
+			return errors.New("[: missing ]")
+		}
+		args = args[:len(args)-1]
+	}
+	if len(args) == 0 {
+		return ExitStatus(1)
+	}
+	e := testExpr(args)
+	v, err := e.or()
+	if err != nil {
+		return err
+	}
+	if len(e) > 0 {
+		return fmt.Errorf("test: unexpected %s", e[0])
+	}
+	if !v {
+		return ExitStatus(1)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builtins
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+func init() {
+	Register("export", Func(export))
+	Register("unset", Func(unset))
+	Register("read", Func(read))
+}
+
+// validName reports whether s can be the name of a variable.
+func validName(s string) bool {
+	for i, c := range s {
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// quote quotes s for the shell to read it back.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// export puts variables in the environment of commands.
+//
+// Synopsis:
+//     export [-p] [NAME[=VALUE]]...
+//
+// Description:
+//     Without NAMEs, the environment is printed as export commands.
+func export(ctx *Context) error {
+	args := ctx.Args[1:]
+	if len(args) > 0 && args[0] == "-p" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		for _, e := range ctx.Shell.Environ() {
+			if kv := strings.SplitN(e, "=", 2); len(kv) == 2 {
+				fmt.Fprintf(ctx.Stdout, "export %s=%s\n", kv[0], quote(kv[1]))
+			}
+		}
+		return nil
+	}
+	for _, a := range args {
+		name := a
+		i := strings.IndexByte(a, '=')
+		if i > -1 {
+			name = a[:i]
+		}
+		if !validName(name) {
+			return fmt.Errorf("export: bad variable name %q", name)
+		}
+		if i > -1 {
+			ctx.Shell.Set(name, a[i+1:])
+		}
+		ctx.Shell.Export(name)
+	}
+	return nil
+}
+
+// unset removes variables.
+//
+// Synopsis:
+//     unset [-v] NAME...
+func unset(ctx *Context) error {
+	args := ctx.Args[1:]
+	if len(args) > 0 && args[0] == "-v" {
+		args = args[1:]
+	}
+	for _, name := range args {
+		ctx.Shell.Unset(name)
+	}
+	return nil
+}
+
+// read reads a line and splits it into variables.
+//
+// Synopsis:
+//     read [-r] [-p PROMPT] [NAME]...
+//
+// Description:
+//     The fields of the line, split at the characters in $IFS, are
+//     assigned to the NAMEs, with the rest of the line in the last one,
+//     which is REPLY if there are no NAMEs. Without -r, \ quotes the next
+//     character, and continues the line at a newline. The exit status is 1
+//     at the end of the input.
+//
+// Options:
+//     -r: do not treat \ specially
+//     -p: print PROMPT to stderr first
+func read(ctx *Context) error {
+	f := flag.NewFlagSet("read", flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+	raw := f.Bool("r", false, "Do not treat \\ specially")
+	prompt := f.String("p", "", "Prompt")
+	if err := f.Parse(ctx.Args[1:]); err != nil {
+		return errors.New("usage: read [-r] [-p PROMPT] [NAME]...")
+	}
+	names := f.Args()
+	if len(names) == 0 {
+		names = []string{"REPLY"}
+	}
+	for _, n := range names {
+		if !validName(n) {
+			return fmt.Errorf("read: bad variable name %q", n)
+		}
+	}
+	fmt.Fprint(ctx.Stderr, *prompt)
+
+	// Read a byte at a time, so that what follows the line is left for
+	// the commands after read. quoted marks the characters after \.
+	var (
+		line   []byte
+		quoted []bool
+		eof    bool
+		b      [1]byte
+	)
+	for {
+		n, err := ctx.Stdin.Read(b[:])
+		if n == 0 {
+			if err != nil {
+				eof = true
+				break
+			}
+			continue
+		}
+		if b[0] == '\n' {
+			break
+		}
+		if b[0] == '\\' && !*raw {
+			if n, _ := io.ReadFull(ctx.Stdin, b[:]); n == 0 {
+				eof = true
+				break
+			}
+			if b[0] != '\n' {
+				line, quoted = append(line, b[0]), append(quoted, true)
+			}
+			continue
+		}
+		line, quoted = append(line, b[0]), append(quoted, false)
+	}
+
+	ifs, ok := ctx.Shell.Get("IFS")
+	if !ok {
+		ifs = " \t\n"
+	}
+	isIFS := func(i int) bool {
+		return !quoted[i] && strings.IndexByte(ifs, line[i]) > -1
+	}
+	isSpace := func(i int) bool {
+		return isIFS(i) && strings.IndexByte(" \t\n", line[i]) > -1
+	}
+	i := 0
+	for i < len(line) && isSpace(i) {
+		i++
+	}
+	for k, name := range names {
+		start := i
+		if k == len(names)-1 {
+			end := len(line)
+			for end > start && isSpace(end-1) {
+				end--
+			}
+			ctx.Shell.Set(name, string(line[start:end]))
+			break
+		}
+		for i < len(line) && !isIFS(i) {
+			i++
+		}
+		ctx.Shell.Set(name, string(line[start:i]))
+		// A field ends at IFS white space, and at most one other IFS
+		// character.
+		for i < len(line) && isSpace(i) {
+			i++
+		}
+		if i < len(line) && isIFS(i) {
+			i++
+			for i < len(line) && isSpace(i) {
+				i++
+			}
+		}
+	}
+	if eof {
+		return ExitStatus(1)
+	}
+	return nil
+}
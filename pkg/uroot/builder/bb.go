@@ -55,6 +55,7 @@ func (BBBuilder) Build(af *initramfs.Files, opts Opts) error {
 	if err := af.AddFile(bbPath, path.Join(opts.BinaryDir, "bb")); err != nil {
 		return err
 	}
+	af.SetOrigin(path.Join(opts.BinaryDir, "bb"), "busybox")
 
 	// Add symlinks for included commands to initramfs.
 	for _, pkg := range opts.Packages {
@@ -66,6 +67,7 @@ func (BBBuilder) Build(af *initramfs.Files, opts Opts) error {
 		if err := af.AddRecord(cpio.Symlink(filepath.Join(opts.BinaryDir, path.Base(pkg)), "bb")); err != nil {
 			return err
 		}
+		af.SetOrigin(path.Join(opts.BinaryDir, path.Base(pkg)), "package "+pkg)
 	}
 	return nil
 }
@@ -113,7 +113,7 @@ func BuildBusybox(env golang.Environ, pkgs []string, binaryPath string) error {
 			return err
 		}
 
-		bbPackages = append(bbPackages, fmt.Sprintf("github.com/u-root/u-root/bb/cmds/%s", path.Base(pkg)))
+		bbPackages = append(bbPackages, path.Join(cmdsImportPath, path.Base(pkg)))
 	}
 
 	bb, err := NewPackageFromEnv(env, "github.com/u-root/u-root/pkg/bb/cmd", importer)
@@ -32,3 +32,24 @@ func TestPackageRewriteFile(t *testing.T) {
 		t.Fatalf("foo failed: %v %v", string(o), err)
 	}
 }
+
+func TestCommandSizes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bin := filepath.Join(dir, "bb")
+	if err := BuildBusybox(golang.Default(), []string{"github.com/u-root/u-root/pkg/uroot/test/foo"}, bin); err != nil {
+		t.Fatal(err)
+	}
+
+	sizes, err := CommandSizes(bin)
+	if err != nil {
+		t.Fatalf("CommandSizes(%q) = %v", bin, err)
+	}
+	if len(sizes) != 1 || sizes["foo"] == 0 {
+		t.Errorf("CommandSizes(%q) = %v, want non-zero size for foo only", bin, sizes)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bb
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"fmt"
+	"strings"
+)
+
+// cmdsImportPath is the import path under which BuildBusybox places the
+// rewritten command packages.
+const cmdsImportPath = "github.com/u-root/u-root/bb/cmds"
+
+// CommandSizes estimates how many bytes each command contributes to the
+// busybox binary at binaryPath, keyed by command name.
+//
+// The estimate is the sum of the sizes of all symbols in the command's
+// rewritten package. Packages shared by several commands are not attributed
+// to any of them.
+//
+// If the binary was stripped of its symbol table, as u-root does by default,
+// only function sizes recovered from the Go pclntab are counted.
+func CommandSizes(binaryPath string) (map[string]uint64, error) {
+	f, err := elf.Open(binaryPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return packageSizes(f, cmdsImportPath+"/")
+}
+
+// packageSizes sums up the sizes of the symbols in f whose package import
+// path starts with prefix, keyed by the first path element after prefix.
+func packageSizes(f *elf.File, prefix string) (map[string]uint64, error) {
+	sizes := make(map[string]uint64)
+	add := func(name string, size uint64) {
+		if !strings.HasPrefix(name, prefix) {
+			return
+		}
+		name = name[len(prefix):]
+		if i := strings.IndexAny(name, "/."); i > 0 {
+			sizes[name[:i]] += size
+		}
+	}
+
+	syms, err := f.Symbols()
+	if err == nil {
+		for _, s := range syms {
+			add(s.Name, s.Size)
+		}
+		return sizes, nil
+	}
+	if err != elf.ErrNoSymbols {
+		return nil, err
+	}
+
+	// Stripped binary. Fall back to the function table the Go runtime
+	// needs for stack traces, which is never stripped.
+	pcln := f.Section(".gopclntab")
+	text := f.Section(".text")
+	if pcln == nil || text == nil {
+		return nil, fmt.Errorf("binary has neither a symbol table nor a Go pclntab")
+	}
+	pclnData, err := pcln.Data()
+	if err != nil {
+		return nil, err
+	}
+	var symtabData []byte
+	if symtab := f.Section(".gosymtab"); symtab != nil {
+		if symtabData, err = symtab.Data(); err != nil {
+			return nil, err
+		}
+	}
+	table, err := gosym.NewTable(symtabData, gosym.NewLineTable(pclnData, text.Addr))
+	if err != nil {
+		return nil, fmt.Errorf("reading Go pclntab: %v", err)
+	}
+	for _, fn := range table.Funcs {
+		add(fn.Name, fn.End-fn.Entry)
+	}
+	return sizes, nil
+}
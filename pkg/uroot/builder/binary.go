@@ -49,6 +49,10 @@ func (BinaryBuilder) Build(af *initramfs.Files, opts Opts) error {
 		}
 	}
 
+	for _, pkg := range opts.Packages {
+		af.SetOrigin(filepath.Join(opts.BinaryDir, filepath.Base(pkg)), "package "+pkg)
+	}
+
 	// Add bin directory to archive.
 	return af.AddFile(opts.TempDir, "")
 }
@@ -51,7 +51,7 @@ func (SourceBuilder) Build(af *initramfs.Files, opts Opts) error {
 		}
 
 		// Add high-level packages' src files to archive.
-		p := goListPkg(opts, pkg, af)
+		p := goListPkg(opts, pkg, "package "+pkg, af)
 		if p == nil {
 			continue
 		}
@@ -67,6 +67,7 @@ func (SourceBuilder) Build(af *initramfs.Files, opts Opts) error {
 				path.Join("/", opts.BinaryDir, "installcommand"))); err != nil {
 				return err
 			}
+			af.SetOrigin(path.Join(opts.BinaryDir, name), "package "+pkg)
 		}
 	}
 	if len(installcommand) == 0 {
@@ -75,7 +76,7 @@ func (SourceBuilder) Build(af *initramfs.Files, opts Opts) error {
 
 	// Add src files of dependencies to archive.
 	for dep := range deps {
-		goListPkg(opts, dep, af)
+		goListPkg(opts, dep, "dependency "+dep, af)
 	}
 
 	// Add Go toolchain.
@@ -88,6 +89,8 @@ func (SourceBuilder) Build(af *initramfs.Files, opts Opts) error {
 	}
 
 	// Add Go toolchain and installcommand to archive.
+	af.SetOrigin("go", "Go toolchain")
+	af.SetOrigin(path.Join(opts.BinaryDir, "installcommand"), "package "+installcommand)
 	return af.AddFile(opts.TempDir, "")
 }
 
@@ -112,7 +115,7 @@ func buildToolchain(opts Opts) error {
 	return nil
 }
 
-func goListPkg(opts Opts, importPath string, out *initramfs.Files) *golang.ListPackage {
+func goListPkg(opts Opts, importPath string, origin string, out *initramfs.Files) *golang.ListPackage {
 	p, err := opts.Env.Deps(importPath)
 	if err != nil {
 		log.Printf("Can't list Go dependencies for %v; ignoring.", importPath)
@@ -124,9 +127,10 @@ func goListPkg(opts Opts, importPath string, out *initramfs.Files) *golang.ListP
 		relPath := filepath.Join("src", p.ImportPath, file)
 		srcFile := filepath.Join(p.Root, relPath)
 		if p.Goroot {
-			out.AddFile(srcFile, filepath.Join("go", relPath))
-		} else {
-			out.AddFile(srcFile, relPath)
+			relPath = filepath.Join("go", relPath)
+		}
+		if err := out.AddFile(srcFile, relPath); err == nil {
+			out.SetOrigin(relPath, origin)
 		}
 	}
 	return p
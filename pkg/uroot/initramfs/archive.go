@@ -39,6 +39,10 @@ type Archiver interface {
 
 	// Reader returns a Reader that allows reading files from a file.
 	Reader(file io.ReaderAt) Reader
+
+	// StreamReader returns a Reader that allows reading files from a
+	// stream that cannot be read at random offsets, such as a pipe.
+	StreamReader(r io.Reader) Reader
 }
 
 // GetArchiver finds a registered initramfs archiver by name.
@@ -85,6 +89,10 @@ type Opts struct {
 	// If this is false, the "init" file in BaseArchive will be renamed
 	// "inito" (for init-original) in the output archive.
 	UseExistingInit bool
+
+	// Manifest, if not nil, receives an entry for every record written to
+	// OutputFile.
+	Manifest *Manifest
 }
 
 // Write uses the given options to determine which files to write to the output
@@ -119,11 +127,22 @@ func Write(opts *Opts) error {
 			}
 			// TODO: ignore only the error where it already exists
 			// in archive.
-			opts.Files.AddRecord(transform(f))
+			f = transform(f)
+			if err := opts.Files.AddRecord(f); err == nil {
+				opts.Files.SetOrigin(f.Name, "base archive")
+			}
 		}
 	}
 
-	if err := opts.Files.WriteTo(opts.OutputFile); err != nil {
+	out := opts.OutputFile
+	if opts.Manifest != nil {
+		out = manifestWriter{
+			Writer: out,
+			m:      opts.Manifest,
+			origin: opts.Files.Origin,
+		}
+	}
+	if err := opts.Files.WriteTo(out); err != nil {
 		return err
 	}
 	return opts.OutputFile.Finish()
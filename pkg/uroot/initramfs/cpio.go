@@ -56,3 +56,25 @@ func (o osWriter) Finish() error {
 func (ca CPIOArchiver) Reader(r io.ReaderAt) Reader {
 	return ca.RecordFormat.Reader(r)
 }
+
+// StreamReader implements Archiver.StreamReader.
+//
+// Record contents are read into memory, as the records are kept until the
+// whole archive is written.
+func (ca CPIOArchiver) StreamReader(r io.Reader) Reader {
+	return inMemReader{cpio.NewStreamReader(ca.RecordFormat, r)}
+}
+
+// inMemReader reads the content of every record it returns into memory.
+type inMemReader struct {
+	cpio.RecordReader
+}
+
+// ReadRecord implements cpio.RecordReader.
+func (r inMemReader) ReadRecord() (cpio.Record, error) {
+	rec, err := r.RecordReader.ReadRecord()
+	if err != nil {
+		return cpio.Record{}, err
+	}
+	return cpio.InMemRecord(rec)
+}
@@ -24,6 +24,13 @@ func (da DirArchiver) Reader(io.ReaderAt) Reader {
 	return nil
 }
 
+// StreamReader implements Archiver.StreamReader.
+//
+// Currently unsupported for directories.
+func (da DirArchiver) StreamReader(io.Reader) Reader {
+	return nil
+}
+
 // OpenWriter implements Archiver.OpenWriter.
 func (da DirArchiver) OpenWriter(path, goos, goarch string) (Writer, error) {
 	if len(path) == 0 {
@@ -25,6 +25,14 @@ type Files struct {
 	// cpio.Record. If or when there is another archival mode, we can add a
 	// similar uroot.Record type.
 	Records map[string]cpio.Record
+
+	// Origins is a map of relative archive path -> a human-readable
+	// description of why the path is in the archive, e.g. the Go package
+	// or ldd dependency that caused it to be added.
+	//
+	// Children of a path inherit its origin unless they have their own.
+	// Origins may be nil.
+	Origins map[string]string
 }
 
 // NewFiles returns a new archive files map.
@@ -123,6 +131,29 @@ func (af *Files) AddRecord(r cpio.Record) error {
 	return nil
 }
 
+// SetOrigin records why `dest` is in the archive.
+//
+// The origin applies to dest and all of its children that do not have an
+// origin of their own.
+func (af *Files) SetOrigin(dest string, origin string) {
+	if af.Origins == nil {
+		af.Origins = make(map[string]string)
+	}
+	af.Origins[path.Clean(dest)] = origin
+}
+
+// Origin returns why `dest` is in the archive, or an empty string if unknown.
+func (af *Files) Origin(dest string) string {
+	for p := path.Clean(dest); ; p = path.Dir(p) {
+		if o, ok := af.Origins[p]; ok {
+			return o
+		}
+		if p == "." || p == "/" {
+			return ""
+		}
+	}
+}
+
 // Contains returns whether path `dest` is already contained in the archive.
 func (af *Files) Contains(dest string) bool {
 	_, fok := af.Files[dest]
@@ -141,6 +172,10 @@ func (af *Files) Rename(name string, newname string) {
 		record.Name = newname
 		af.Records[newname] = record
 	}
+	if origin, ok := af.Origins[name]; ok {
+		delete(af.Origins, name)
+		af.Origins[newname] = origin
+	}
 }
 
 // addParent recursively adds parent directory records for `name`.
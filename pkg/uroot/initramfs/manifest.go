@@ -0,0 +1,286 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package initramfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+// ManifestEntry describes a single file in an initramfs archive.
+type ManifestEntry struct {
+	// Path is the relative archive path.
+	Path string `json:"path"`
+
+	// Mode is the Linux mode_t of the file, including the file type.
+	Mode uint64 `json:"mode"`
+
+	// Size is the size of the file's content in bytes.
+	Size uint64 `json:"size"`
+
+	// SHA256 is the hex-encoded SHA256 of the file's content.
+	//
+	// SHA256 is empty for records without content, such as directories
+	// and device nodes.
+	SHA256 string `json:"sha256,omitempty"`
+
+	// Origin describes why the file is in the archive, e.g. "package
+	// github.com/u-root/u-root/cmds/ls" or "base archive".
+	Origin string `json:"origin,omitempty"`
+}
+
+// String implements fmt.Stringer.
+func (e ManifestEntry) String() string {
+	s := fmt.Sprintf("%#o %d %s", e.Mode, e.Size, e.Path)
+	if len(e.Origin) > 0 {
+		s += fmt.Sprintf(" (%s)", e.Origin)
+	}
+	return s
+}
+
+// Manifest is a record of every file written to an initramfs archive.
+type Manifest struct {
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// NewManifestEntry computes the manifest entry for a record.
+func NewManifestEntry(r cpio.Record, origin string) (ManifestEntry, error) {
+	e := ManifestEntry{
+		Path:   cpio.Normalize(r.Name),
+		Mode:   r.Mode,
+		Origin: origin,
+	}
+	if r.ReaderAt == nil {
+		return e, nil
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, uio.Reader(r))
+	if err != nil {
+		return ManifestEntry{}, fmt.Errorf("reading %q for manifest: %v", r.Name, err)
+	}
+	e.Size = uint64(n)
+	if n > 0 {
+		e.SHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	return e, nil
+}
+
+// Add adds an entry for `r` to the manifest.
+func (m *Manifest) Add(r cpio.Record, origin string) error {
+	e, err := NewManifestEntry(r, origin)
+	if err != nil {
+		return err
+	}
+	m.Entries = append(m.Entries, e)
+	return nil
+}
+
+// Size returns the sum of the content sizes of all entries.
+func (m *Manifest) Size() uint64 {
+	var size uint64
+	for _, e := range m.Entries {
+		size += e.Size
+	}
+	return size
+}
+
+// Encode writes the manifest as JSON to w.
+func (m *Manifest) Encode(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(m)
+}
+
+// ReadManifest decodes a JSON manifest as written by Manifest.Encode.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("decoding manifest: %v", err)
+	}
+	return &m, nil
+}
+
+// ManifestFromArchive computes a manifest for an existing archive.
+//
+// Origins of files in an existing archive are unknown and left empty.
+func ManifestFromArchive(a *cpio.Archive) (*Manifest, error) {
+	m := &Manifest{}
+	seen := make(map[string]struct{})
+	for _, name := range a.Order {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		if err := m.Add(a.Files[name], ""); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// manifestWriter is a Writer that records everything written to it in a
+// Manifest.
+type manifestWriter struct {
+	Writer
+
+	m      *Manifest
+	origin func(string) string
+}
+
+// WriteRecord implements Writer.WriteRecord.
+func (mw manifestWriter) WriteRecord(r cpio.Record) error {
+	if err := mw.m.Add(r, mw.origin(cpio.Normalize(r.Name))); err != nil {
+		return err
+	}
+	return mw.Writer.WriteRecord(r)
+}
+
+// ChangeKind is the kind of difference between two manifests.
+type ChangeKind int
+
+// Kinds of manifest changes.
+const (
+	Added ChangeKind = iota
+	Removed
+	Modified
+)
+
+// String implements fmt.Stringer.
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "+"
+	case Removed:
+		return "-"
+	case Modified:
+		return "~"
+	}
+	return "?"
+}
+
+// Change is a single difference between two manifests.
+type Change struct {
+	Kind ChangeKind
+
+	// Old is the entry in the old manifest. Old is nil for Added.
+	Old *ManifestEntry
+
+	// New is the entry in the new manifest. New is nil for Removed.
+	New *ManifestEntry
+}
+
+// Path is the archive path of the changed file.
+func (c Change) Path() string {
+	if c.New != nil {
+		return c.New.Path
+	}
+	return c.Old.Path
+}
+
+// SizeDelta is the number of bytes this change adds to the archive content.
+func (c Change) SizeDelta() int64 {
+	var d int64
+	if c.New != nil {
+		d += int64(c.New.Size)
+	}
+	if c.Old != nil {
+		d -= int64(c.Old.Size)
+	}
+	return d
+}
+
+// String implements fmt.Stringer.
+func (c Change) String() string {
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("%v %v", c.Kind, c.New)
+	case Removed:
+		return fmt.Sprintf("%v %v", c.Kind, c.Old)
+	default:
+		s := fmt.Sprintf("%v %s", c.Kind, c.Path())
+		if c.Old.Mode != c.New.Mode {
+			s += fmt.Sprintf(" mode %#o -> %#o", c.Old.Mode, c.New.Mode)
+		}
+		if c.Old.Size != c.New.Size {
+			s += fmt.Sprintf(" size %d -> %d (%+d)", c.Old.Size, c.New.Size, c.SizeDelta())
+		} else if c.Old.SHA256 != c.New.SHA256 {
+			s += " content"
+		}
+		if c.Old.Origin != c.New.Origin && len(c.New.Origin) > 0 {
+			s += fmt.Sprintf(" (%s)", c.New.Origin)
+		}
+		return s
+	}
+}
+
+func entryMap(m *Manifest) map[string]*ManifestEntry {
+	em := make(map[string]*ManifestEntry, len(m.Entries))
+	for i := range m.Entries {
+		em[m.Entries[i].Path] = &m.Entries[i]
+	}
+	return em
+}
+
+// diff returns the changes between two entry maps, sorted by path.
+func diff(before, after map[string]*ManifestEntry, modified func(string) bool) []Change {
+	var changes []Change
+	for p, o := range before {
+		n, ok := after[p]
+		if !ok {
+			changes = append(changes, Change{Kind: Removed, Old: o})
+		} else if modified(p) {
+			changes = append(changes, Change{Kind: Modified, Old: o, New: n})
+		}
+	}
+	for p, n := range after {
+		if _, ok := before[p]; !ok {
+			changes = append(changes, Change{Kind: Added, New: n})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path() < changes[j].Path()
+	})
+	return changes
+}
+
+// DiffManifests returns the files added, removed, or modified between `before`
+// and `after`, sorted by path.
+//
+// Files are considered modified if their mode, size, or content hash
+// differ. Origins are informational and are not compared.
+func DiffManifests(before, after *Manifest) []Change {
+	o, n := entryMap(before), entryMap(after)
+	return diff(o, n, func(p string) bool {
+		return o[p].Mode != n[p].Mode || o[p].Size != n[p].Size || o[p].SHA256 != n[p].SHA256
+	})
+}
+
+// DiffArchives returns the files added, removed, or modified between two
+// archives, sorted by path.
+//
+// Records are compared with cpio.Equal after cpio.MakeReproducible, so that
+// differences in inode numbers, owners, and timestamps are not reported.
+func DiffArchives(before, after *cpio.Archive) ([]Change, error) {
+	om, err := ManifestFromArchive(before)
+	if err != nil {
+		return nil, err
+	}
+	nm, err := ManifestFromArchive(after)
+	if err != nil {
+		return nil, err
+	}
+	return diff(entryMap(om), entryMap(nm), func(p string) bool {
+		or, _ := before.Get(p)
+		nr, _ := after.Get(p)
+		return !cpio.Equal(cpio.MakeReproducible(or), cpio.MakeReproducible(nr))
+	}), nil
+}
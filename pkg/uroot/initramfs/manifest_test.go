@@ -0,0 +1,136 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package initramfs
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/cpio"
+)
+
+func TestWriteManifest(t *testing.T) {
+	files := NewFiles()
+	if err := files.AddRecord(cpio.StaticFile("bin/foo", "foo", 0755)); err != nil {
+		t.Fatal(err)
+	}
+	files.SetOrigin("bin/foo", "package foo")
+	if err := files.AddRecord(cpio.Directory("etc/conf.d", 0755)); err != nil {
+		t.Fatal(err)
+	}
+	files.SetOrigin("etc", "config")
+
+	ma := &MockArchiver{
+		Records: make(Records),
+		BaseArchive: []cpio.Record{
+			cpio.StaticFile("etc/motd", "hi", 0644),
+		},
+	}
+	m := &Manifest{}
+	opts := &Opts{
+		Files:       files,
+		OutputFile:  ma,
+		BaseArchive: ma,
+		Manifest:    m,
+	}
+	if err := Write(opts); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+
+	want := []ManifestEntry{
+		{Path: "bin", Mode: 040755},
+		{
+			Path:   "bin/foo",
+			Mode:   0100755,
+			Size:   3,
+			SHA256: "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+			Origin: "package foo",
+		},
+		{Path: "etc", Mode: 040755, Origin: "config"},
+		{Path: "etc/conf.d", Mode: 040755, Origin: "config"},
+		{
+			Path:   "etc/motd",
+			Mode:   0100644,
+			Size:   2,
+			SHA256: "8f434346648f6b96df89dda901c5176b10a6d83961dd3c1ac88b59b2dc327aa4",
+			Origin: "base archive",
+		},
+	}
+	if !reflect.DeepEqual(m.Entries, want) {
+		t.Errorf("manifest = %v, want %v", m.Entries, want)
+	}
+	if got := m.Size(); got != 5 {
+		t.Errorf("Size() = %d, want 5", got)
+	}
+
+	var b bytes.Buffer
+	if err := m.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	m2, err := ReadManifest(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, m2) {
+		t.Errorf("ReadManifest(Encode(%v)) = %v", m, m2)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := cpio.ArchiveFromRecords([]cpio.Record{
+		cpio.Directory("bin", 0755),
+		cpio.StaticFile("bin/same", "same", 0755),
+		cpio.StaticFile("bin/gone", "gone", 0755),
+		cpio.StaticFile("bin/grows", "a", 0755),
+		cpio.StaticFile("bin/chmod", "chmod", 0755),
+	})
+	after := cpio.ArchiveFromRecords([]cpio.Record{
+		cpio.Directory("bin", 0755),
+		cpio.StaticFile("bin/same", "same", 0755),
+		cpio.StaticFile("bin/grows", "abc", 0755),
+		cpio.StaticFile("bin/chmod", "chmod", 0700),
+		cpio.StaticFile("bin/new", "new", 0755),
+	})
+
+	type change struct {
+		kind  ChangeKind
+		path  string
+		delta int64
+	}
+	want := []change{
+		{Modified, "bin/chmod", 0},
+		{Removed, "bin/gone", -4},
+		{Modified, "bin/grows", 2},
+		{Added, "bin/new", 3},
+	}
+	simplify := func(cs []Change) []change {
+		var s []change
+		for _, c := range cs {
+			s = append(s, change{c.Kind, c.Path(), c.SizeDelta()})
+		}
+		return s
+	}
+
+	got, err := DiffArchives(before, after)
+	if err != nil {
+		t.Fatalf("DiffArchives() = %v", err)
+	}
+	if !reflect.DeepEqual(simplify(got), want) {
+		t.Errorf("DiffArchives() = %v, want %v", got, want)
+	}
+
+	bm, err := ManifestFromArchive(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am, err := ManifestFromArchive(after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := DiffManifests(bm, am); !reflect.DeepEqual(simplify(got), want) {
+		t.Errorf("DiffManifests() = %v, want %v", got, want)
+	}
+}
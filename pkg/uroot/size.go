@@ -0,0 +1,173 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uroot
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/uroot/builder"
+	"github.com/u-root/u-root/pkg/uroot/builder/bb"
+	"github.com/u-root/u-root/pkg/uroot/initramfs"
+)
+
+// largestFiles is the number of files listed in a SizeReport's breakdown.
+const largestFiles = 10
+
+// SizeReport is a breakdown of the size of an initramfs.
+type SizeReport struct {
+	// ArchiveSize is the size of the uncompressed newc archive in bytes.
+	ArchiveSize uint64
+
+	// Files are all files in the archive, largest first.
+	Files []initramfs.ManifestEntry
+
+	// Origins is a map of file origin -> total content size of files with
+	// that origin.
+	Origins map[string]uint64
+
+	// Commands is a map of busybox command name -> estimated number of
+	// bytes the command contributes to the busybox binary.
+	//
+	// See bb.CommandSizes for how this is estimated.
+	Commands map[string]uint64
+}
+
+// round4 returns the next multiple of 4 close to n.
+func round4(n uint64) uint64 {
+	return (n + 3) &^ 0x3
+}
+
+// newcSize returns the size of the newc record for a file named name with
+// size bytes of content.
+func newcSize(name string, size uint64) uint64 {
+	// 6 bytes of magic followed by 13 8-digit hex fields.
+	const headerSize = 6 + 13*8
+	return round4(headerSize+uint64(len(name))+1) + round4(size)
+}
+
+// NewSizeReport computes a size report for an initramfs that was written
+// with the manifest m from files and cmds.
+//
+// Busybox binaries are looked up in files to attribute their size to
+// individual commands. Errors doing so are logged and not fatal.
+func NewSizeReport(logger *log.Logger, m *initramfs.Manifest, files *initramfs.Files, cmds []Commands) *SizeReport {
+	r := &SizeReport{
+		ArchiveSize: newcSize("TRAILER!!!", 0),
+		Files:       append([]initramfs.ManifestEntry(nil), m.Entries...),
+		Origins:     make(map[string]uint64),
+		Commands:    make(map[string]uint64),
+	}
+	for _, e := range r.Files {
+		r.ArchiveSize += newcSize(e.Path, e.Size)
+		r.Origins[e.Origin] += e.Size
+	}
+	sort.SliceStable(r.Files, func(i, j int) bool {
+		return r.Files[i].Size > r.Files[j].Size
+	})
+
+	for _, c := range cmds {
+		if _, ok := c.Builder.(builder.BBBuilder); !ok {
+			continue
+		}
+		bin, ok := files.Files[path.Join(c.TargetDir(), "bb")]
+		if !ok {
+			continue
+		}
+		sizes, err := bb.CommandSizes(bin)
+		if err != nil {
+			logger.Printf("Could not attribute busybox size to commands: %v", err)
+			continue
+		}
+		for name, size := range sizes {
+			r.Commands[name] += size
+		}
+	}
+	return r
+}
+
+// sortedBySize returns the keys of m, largest value first.
+func sortedBySize(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// isExtraFile returns whether the origin is an Opts.ExtraFiles entry or one
+// of its ldd dependencies.
+func isExtraFile(origin string) bool {
+	return strings.HasPrefix(origin, "extra file ") || strings.HasPrefix(origin, "ldd dependency of ")
+}
+
+// String implements fmt.Stringer.
+func (r *SizeReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Archive size: %d bytes\n", r.ArchiveSize)
+
+	fmt.Fprintf(&b, "Largest files:\n")
+	for i, e := range r.Files {
+		if i == largestFiles {
+			break
+		}
+		fmt.Fprintf(&b, "  %10d %s\n", e.Size, e.Path)
+	}
+
+	fmt.Fprintf(&b, "By origin:\n")
+	for _, o := range sortedBySize(r.Origins) {
+		name := o
+		if len(name) == 0 {
+			name = "(unknown)"
+		}
+		fmt.Fprintf(&b, "  %10d %s\n", r.Origins[o], name)
+	}
+
+	if len(r.Commands) > 0 {
+		fmt.Fprintf(&b, "Busybox commands (estimated):\n")
+		for _, c := range sortedBySize(r.Commands) {
+			fmt.Fprintf(&b, "  %10d %s\n", r.Commands[c], c)
+		}
+	}
+
+	var extra []initramfs.ManifestEntry
+	for _, e := range r.Files {
+		if isExtraFile(e.Origin) {
+			extra = append(extra, e)
+		}
+	}
+	if len(extra) > 0 {
+		fmt.Fprintf(&b, "Extra files:\n")
+		for _, e := range extra {
+			fmt.Fprintf(&b, "  %10d %s (%s)\n", e.Size, e.Path, e.Origin)
+		}
+	}
+	return b.String()
+}
+
+// SizeError is returned by CreateInitramfs if the archive exceeds
+// Opts.MaxSize.
+type SizeError struct {
+	// MaxSize is the size budget that was exceeded.
+	MaxSize uint64
+
+	// Report is the breakdown of the archive's size.
+	Report *SizeReport
+}
+
+// Error implements error.
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("initramfs is %d bytes, exceeding the maximum size of %d bytes by %d bytes\n%v",
+		e.Report.ArchiveSize, e.MaxSize, e.Report.ArchiveSize-e.MaxSize, e.Report)
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uroot
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/golang"
+)
+
+func TestSizeReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l := log.New(os.Stdout, "", log.LstdFlags)
+
+	var report SizeReport
+	archive := inMemArchive{cpio.InMemArchive()}
+	opts := Opts{
+		Env:         golang.Default(),
+		TempDir:     dir,
+		InitCmd:     "/bin/systemd",
+		OutputFile:  archive,
+		BaseArchive: cpio.ArchiveFromRecords([]cpio.Record{cpio.StaticFile("etc/motd", "hello", 0644)}).Reader(),
+		SizeReport:  &report,
+	}
+	if err := CreateInitramfs(l, opts); err != nil {
+		t.Fatalf("CreateInitramfs() = %v", err)
+	}
+
+	// Write the same records as newc to check the size estimate.
+	var b bytes.Buffer
+	w := cpio.Newc.Writer(&b)
+	if err := cpio.Passthrough(archive.Reader(), w); err != nil {
+		t.Fatal(err)
+	}
+	if report.ArchiveSize != uint64(b.Len()) {
+		t.Errorf("ArchiveSize = %d, want %d", report.ArchiveSize, b.Len())
+	}
+
+	wantOrigins := map[string]uint64{
+		"base archive": 5,
+		"init command": uint64(len("/bin/systemd")),
+		"":             0,
+	}
+	if !reflect.DeepEqual(report.Origins, wantOrigins) {
+		t.Errorf("Origins = %v, want %v", report.Origins, wantOrigins)
+	}
+	if len(report.Files) == 0 || report.Files[0].Path != "init" {
+		t.Errorf("Files = %v, want init first", report.Files)
+	}
+
+	// The same build with too small a budget must fail.
+	opts.OutputFile = inMemArchive{cpio.InMemArchive()}
+	opts.BaseArchive = nil
+	opts.SizeReport = nil
+	opts.MaxSize = 1
+	err = CreateInitramfs(l, opts)
+	if serr, ok := err.(*SizeError); !ok {
+		t.Errorf("CreateInitramfs() = %v, want *SizeError", err)
+	} else if serr.Report.ArchiveSize <= 1 {
+		t.Errorf("SizeError.Report.ArchiveSize = %d, want > 1", serr.Report.ArchiveSize)
+	}
+}
+
+func TestNewcSize(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		size uint64
+		want uint64
+	}{
+		{"", 0, 112},
+		{"a", 0, 112},
+		{"ab", 0, 116},
+		{"TRAILER!!!", 0, 124},
+		{"a", 1, 116},
+		{"a", 4, 116},
+		{"a", 5, 120},
+	} {
+		if got := newcSize(tt.name, tt.size); got != tt.want {
+			t.Errorf("newcSize(%q, %d) = %d, want %d", tt.name, tt.size, got, tt.want)
+		}
+	}
+}
@@ -169,8 +169,53 @@ type Opts struct {
 	//
 	// This must be specified to have a default shell.
 	DefaultShell string
+
+	// Manifest, if not nil, receives an entry with the path, mode, size,
+	// SHA256, and origin of every file written to OutputFile.
+	Manifest *initramfs.Manifest
+
+	// MaxSize is the maximum size in bytes of the uncompressed archive.
+	//
+	// If the archive is larger, CreateInitramfs returns a *SizeError with
+	// a breakdown of the archive's size. The archive will have been written
+	// regardless.
+	//
+	// MaxSize may be zero, in which case the size is not limited.
+	MaxSize uint64
+
+	// SizeReport, if not nil, is filled in with a breakdown of the
+	// archive's size.
+	SizeReport *SizeReport
+
+	// Namespace, if not empty, is the namespace file init creates the
+	// root file system from, instead of its default namespace. It can
+	// also just skip parts of the default, set environment variables and
+	// choose the cgroup hierarchy. See util.ParseNamespace for the
+	// format.
+	Namespace string
+
+	// UinitArgs are the default arguments init starts uinit with. The
+	// kernel command line's uroot.uinitargs replaces them, and its
+	// uroot.uinitflags are added to them.
+	UinitArgs []string
+
+	// UinitEnv are the default NAME=value environment variables of
+	// uinit. Those of the kernel command line's uroot.uinitenv win over
+	// them.
+	UinitEnv []string
 }
 
+// namespaceFile is where the namespace goes in the archive, as
+// util.NamespaceFile says.
+const namespaceFile = "etc/uroot/namespace"
+
+// uinitArgsFile is where the default arguments of uinit go in the archive,
+// one to a line, for init to read.
+const uinitArgsFile = "etc/uroot/uinitargs"
+
+// uinitEnvFile is where the default environment of uinit goes, likewise.
+const uinitEnvFile = "etc/uroot/uinitenv"
+
 // CreateInitramfs creates an initramfs built to opts' specifications.
 func CreateInitramfs(logger *log.Logger, opts Opts) error {
 	if _, err := os.Stat(opts.TempDir); os.IsNotExist(err) {
@@ -216,6 +261,10 @@ func CreateInitramfs(logger *log.Logger, opts Opts) error {
 		OutputFile:      opts.OutputFile,
 		BaseArchive:     opts.BaseArchive,
 		UseExistingInit: opts.UseExistingInit,
+		Manifest:        opts.Manifest,
+	}
+	if archive.Manifest == nil && (opts.MaxSize > 0 || opts.SizeReport != nil) {
+		archive.Manifest = &initramfs.Manifest{}
 	}
 
 	if len(opts.DefaultShell) > 0 {
@@ -224,6 +273,7 @@ func CreateInitramfs(logger *log.Logger, opts Opts) error {
 		} else if err := archive.AddRecord(cpio.Symlink("bin/defaultsh", target)); err != nil {
 			return err
 		}
+		archive.SetOrigin("bin/defaultsh", "default shell")
 	}
 
 	if len(opts.InitCmd) > 0 {
@@ -232,6 +282,40 @@ func CreateInitramfs(logger *log.Logger, opts Opts) error {
 		} else if err := archive.AddRecord(cpio.Symlink("init", target)); err != nil {
 			return err
 		}
+		archive.SetOrigin("init", "init command")
+	}
+
+	if len(opts.Namespace) > 0 {
+		if err := archive.AddRecord(cpio.StaticFile(namespaceFile, opts.Namespace, 0644)); err != nil {
+			return err
+		}
+		archive.SetOrigin(namespaceFile, "namespace")
+	}
+
+	if len(opts.UinitArgs) > 0 {
+		for _, a := range opts.UinitArgs {
+			if a == "" || strings.Contains(a, "\n") {
+				return fmt.Errorf("uinit argument %q is empty or has a newline", a)
+			}
+		}
+		args := strings.Join(opts.UinitArgs, "\n") + "\n"
+		if err := archive.AddRecord(cpio.StaticFile(uinitArgsFile, args, 0644)); err != nil {
+			return err
+		}
+		archive.SetOrigin(uinitArgsFile, "uinit arguments")
+	}
+
+	if len(opts.UinitEnv) > 0 {
+		for _, e := range opts.UinitEnv {
+			if strings.Index(e, "=") < 1 || strings.Contains(e, "\n") {
+				return fmt.Errorf("uinit environment variable %q is not NAME=value or has a newline", e)
+			}
+		}
+		env := strings.Join(opts.UinitEnv, "\n") + "\n"
+		if err := archive.AddRecord(cpio.StaticFile(uinitEnvFile, env, 0644)); err != nil {
+			return err
+		}
+		archive.SetOrigin(uinitEnvFile, "uinit environment")
 	}
 
 	if err := ParseExtraFiles(logger, archive.Files, opts.ExtraFiles, true); err != nil {
@@ -242,6 +326,16 @@ func CreateInitramfs(logger *log.Logger, opts Opts) error {
 	if err := initramfs.Write(&archive); err != nil {
 		return fmt.Errorf("error archiving: %v", err)
 	}
+
+	if opts.MaxSize > 0 || opts.SizeReport != nil {
+		report := NewSizeReport(logger, archive.Manifest, files, opts.Commands)
+		if opts.SizeReport != nil {
+			*opts.SizeReport = *report
+		}
+		if opts.MaxSize > 0 && report.ArchiveSize > opts.MaxSize {
+			return &SizeError{MaxSize: opts.MaxSize, Report: report}
+		}
+	}
 	return nil
 }
 
@@ -366,6 +460,7 @@ func ParseExtraFiles(logger *log.Logger, archive *initramfs.Files, extraFiles []
 		if err := archive.AddFile(src, dst); err != nil {
 			return fmt.Errorf("couldn't add %q to archive: %v", file, err)
 		}
+		archive.SetOrigin(dst, "extra file "+src)
 
 		if lddDeps {
 			// Pull dependencies in the case of binaries. If `path` is not
@@ -378,6 +473,8 @@ func ParseExtraFiles(logger *log.Logger, archive *initramfs.Files, extraFiles []
 			for _, lib := range libs {
 				if err := archive.AddFile(lib, lib[1:]); err != nil {
 					logger.Printf("WARNING: couldn't add ldd dependencies for %q: %v", lib, err)
+				} else if _, ok := archive.Origins[lib[1:]]; !ok {
+					archive.SetOrigin(lib[1:], "ldd dependency of "+src)
 				}
 			}
 		}
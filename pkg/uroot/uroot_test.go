@@ -307,6 +307,42 @@ func TestCreateInitramfs(t *testing.T) {
 				hasRecord{cpio.Symlink("init", "/bin/systemd")},
 			},
 		},
+		{
+			name: "namespace",
+			opts: Opts{
+				Env:       golang.Default(),
+				TempDir:   dir,
+				Namespace: "skip /tcz\ncgroup v2\n",
+			},
+			want: nil,
+			validators: []archiveValidator{
+				hasRecord{cpio.StaticFile("etc/uroot/namespace", "skip /tcz\ncgroup v2\n", 0644)},
+			},
+		},
+		{
+			name: "uinit arguments",
+			opts: Opts{
+				Env:       golang.Default(),
+				TempDir:   dir,
+				UinitArgs: []string{"-mode", "net boot"},
+			},
+			want: nil,
+			validators: []archiveValidator{
+				hasRecord{cpio.StaticFile("etc/uroot/uinitargs", "-mode\nnet boot\n", 0644)},
+			},
+		},
+		{
+			name: "uinit environment",
+			opts: Opts{
+				Env:      golang.Default(),
+				TempDir:  dir,
+				UinitEnv: []string{"MODE=net boot", "V="},
+			},
+			want: nil,
+			validators: []archiveValidator{
+				hasRecord{cpio.StaticFile("etc/uroot/uinitenv", "MODE=net boot\nV=\n", 0644)},
+			},
+		},
 		{
 			name: "multi-mode archive",
 			opts: Opts{
@@ -0,0 +1,352 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// NamespaceFile is where Rootfs looks for a namespace in the initramfs.
+// u-root -namespace puts one there.
+const NamespaceFile = "/etc/uroot/namespace"
+
+// DefaultNamespace is what Rootfs creates if NamespaceFile does not say
+// otherwise. If there is a Go toolchain, the directories it needs are
+// created as well.
+const DefaultNamespace = `
+dir /tmp 0777
+dir /env 0777
+dir /tcz 0777
+dir /lib 0777
+dir /usr/lib 0777
+dir /var/log 0777
+dir /etc 0777
+
+dir /proc 0555
+mount proc /proc proc
+mount tmpfs /tmp tmpfs
+
+dir /dev 0777
+dev /dev/tty c 5 0 0666
+dev /dev/urandom c 1 9 0444
+dev /dev/port c 1 4 0640
+
+# Kernel must be compiled with CONFIG_DEVTMPFS.
+# Note that things kind of work even if this mount fails.
+mount devtmpfs /dev devtmpfs
+
+dir /dev/pts 0777
+mount devpts /dev/pts devpts newinstance,ptmxmode=666,gid=5,mode=620
+dev /dev/ptmx c 5 2 0666
+# Note: shm is required at least for Chrome. If you don't mount
+# it chrome throws a bogus "out of memory" error, not the more
+# useful "I can't open /dev/shm/whatever". SAD!
+dir /dev/shm 0777
+mount tmpfs /dev/shm tmpfs
+
+dir /sys 0555
+mount sysfs /sys sysfs
+`
+
+// goToolchain is there if the initramfs has a Go toolchain.
+var goToolchain = "/go/bin/go"
+
+// goEnv are the variables of Env that are for the Go toolchain.
+var goEnv = []string{"GOROOT", "GOPATH", "GOBIN", "CGO_ENABLED"}
+
+// goNamespace returns what the Go toolchain needs.
+func goNamespace() []Creator {
+	return []Creator{
+		Dir{Name: "/buildbin", Mode: 0777},
+		Dir{Name: "/ubin", Mode: 0777},
+		Dir{Name: fmt.Sprintf("/go/pkg/%s_%s", runtime.GOOS, runtime.GOARCH), Mode: 0777},
+	}
+}
+
+// Namespace is what a namespace file says. It is parsed by
+// ParseNamespace.
+type Namespace struct {
+	// Creators replace the default ones, unless there are none.
+	Creators []Creator
+	// Skip are paths. Nothing is created at or under them.
+	Skip []string
+	// Env is set in the environment, and Unset are removed from it.
+	Env   map[string]string
+	Unset []string
+	// Cgroup is the cgroup hierarchy: v1, v2 or none. The default, "",
+	// is v1.
+	Cgroup string
+}
+
+// mountFlags are the options of mount lines that are flags. Other options
+// are passed to the file system.
+var mountFlags = map[string]uintptr{
+	"ro":       unix.MS_RDONLY,
+	"rw":       0,
+	"nosuid":   unix.MS_NOSUID,
+	"nodev":    unix.MS_NODEV,
+	"noexec":   unix.MS_NOEXEC,
+	"noatime":  unix.MS_NOATIME,
+	"relatime": unix.MS_RELATIME,
+	"bind":     unix.MS_BIND,
+	"rec":      unix.MS_REC,
+}
+
+// ParseNamespace parses a namespace file. It has a line for each thing
+// to create, in order, and # starts a comment:
+//
+//	dir PATH [MODE]
+//	file PATH MODE CONTENTS       CONTENTS is the rest of the line
+//	symlink TARGET PATH
+//	link OLDPATH PATH
+//	dev PATH c|b MAJOR MINOR [MODE]
+//	mount SOURCE TARGET FSTYPE [OPTION,...]
+//
+// If there are any, they replace DefaultNamespace. Other lines change
+// what is created:
+//
+//	skip PATH                     create nothing at or under PATH
+//	env NAME [VALUE]              set NAME in the environment
+//	unsetenv NAME                 remove NAME from the environment
+//	cgroup v1|v2|none             mount the cgroup v1 controllers, the
+//	                              v2 unified hierarchy, or neither
+//
+// Modes are octal, and are 0777 for directories and 0666 for devices if
+// they are left out.
+func ParseNamespace(r io.Reader) (*Namespace, error) {
+	ns := &Namespace{Env: map[string]string{}}
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		l := s.Text()
+		if i := strings.Index(l, "#"); i > -1 {
+			l = l[:i]
+		}
+		f := strings.Fields(l)
+		if len(f) == 0 {
+			continue
+		}
+		if err := ns.parseLine(f); err != nil {
+			return nil, fmt.Errorf("namespace line %d: %q: %v", n, strings.TrimSpace(l), err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return ns, nil
+}
+
+// lineArgs are how many arguments each kind of line takes, at least and at
+// most. -1 is any number.
+var lineArgs = map[string][2]int{
+	"dir":      {1, 2},
+	"file":     {2, -1},
+	"symlink":  {2, 2},
+	"link":     {2, 2},
+	"dev":      {4, 5},
+	"mount":    {3, 4},
+	"skip":     {1, 1},
+	"env":      {1, -1},
+	"unsetenv": {1, 1},
+	"cgroup":   {1, 1},
+}
+
+func parseMode(f []string, i int, def os.FileMode) (os.FileMode, error) {
+	if len(f) <= i {
+		return def, nil
+	}
+	m, err := strconv.ParseUint(f[i], 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad mode %q", f[i])
+	}
+	return os.FileMode(m), nil
+}
+
+func (ns *Namespace) parseLine(f []string) error {
+	n, ok := lineArgs[f[0]]
+	if !ok {
+		return fmt.Errorf("unknown kind %q", f[0])
+	}
+	if len(f)-1 < n[0] || n[1] > -1 && len(f)-1 > n[1] {
+		return fmt.Errorf("wrong number of arguments")
+	}
+	switch f[0] {
+	case "dir":
+		m, err := parseMode(f, 2, 0777)
+		if err != nil {
+			return err
+		}
+		ns.Creators = append(ns.Creators, Dir{Name: f[1], Mode: m})
+	case "file":
+		m, err := parseMode(f, 2, 0)
+		if err != nil {
+			return err
+		}
+		ns.Creators = append(ns.Creators, File{Name: f[1], Mode: m, Contents: strings.Join(f[3:], " ")})
+	case "symlink":
+		ns.Creators = append(ns.Creators, Symlink{Target: f[1], NewPath: f[2]})
+	case "link":
+		ns.Creators = append(ns.Creators, Link{OldPath: f[1], NewPath: f[2]})
+	case "dev":
+		var t uint32
+		switch f[2] {
+		case "c":
+			t = syscall.S_IFCHR
+		case "b":
+			t = syscall.S_IFBLK
+		default:
+			return fmt.Errorf("device type is c or b, not %q", f[2])
+		}
+		major, err := strconv.ParseUint(f[3], 0, 32)
+		if err != nil {
+			return fmt.Errorf("bad major number %q", f[3])
+		}
+		minor, err := strconv.ParseUint(f[4], 0, 32)
+		if err != nil {
+			return fmt.Errorf("bad minor number %q", f[4])
+		}
+		m, err := parseMode(f, 5, 0666)
+		if err != nil {
+			return err
+		}
+		ns.Creators = append(ns.Creators, Dev{Name: f[1], Mode: t | uint32(m), Dev: int(unix.Mkdev(uint32(major), uint32(minor)))})
+	case "mount":
+		m := Mount{Source: f[1], Target: f[2], FSType: f[3]}
+		if len(f) > 4 {
+			var opts []string
+			for _, o := range strings.Split(f[4], ",") {
+				if fl, ok := mountFlags[o]; ok {
+					m.Flags |= fl
+				} else {
+					opts = append(opts, o)
+				}
+			}
+			m.Opts = strings.Join(opts, ",")
+		}
+		ns.Creators = append(ns.Creators, m)
+	case "skip":
+		ns.Skip = append(ns.Skip, f[1])
+	case "env":
+		ns.Env[f[1]] = strings.Join(f[2:], " ")
+	case "unsetenv":
+		ns.Unset = append(ns.Unset, f[1])
+	case "cgroup":
+		switch f[1] {
+		case "v1", "v2", "none":
+			ns.Cgroup = f[1]
+		default:
+			return fmt.Errorf("cgroup is v1, v2 or none, not %q", f[1])
+		}
+	}
+	return nil
+}
+
+// target returns the path c creates.
+func target(c Creator) string {
+	switch c := c.(type) {
+	case Dir:
+		return c.Name
+	case File:
+		return c.Name
+	case Symlink:
+		return c.NewPath
+	case Link:
+		return c.NewPath
+	case Dev:
+		return c.Name
+	case Mount:
+		return c.Target
+	}
+	return ""
+}
+
+// skipped returns whether ns skips p.
+func (ns *Namespace) skipped(p string) bool {
+	for _, s := range ns.Skip {
+		if p == s || strings.HasPrefix(p, strings.TrimSuffix(s, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// creators returns what to create, with the Go toolchain or without.
+func (ns *Namespace) creators(hasGo bool) []Creator {
+	cs := ns.Creators
+	if len(cs) == 0 {
+		cs = namespace
+		if hasGo {
+			cs = append(goNamespace(), cs...)
+		}
+	}
+	var out []Creator
+	for _, c := range cs {
+		if !ns.skipped(target(c)) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// cgroups returns what to create for the cgroup hierarchy.
+func (ns *Namespace) cgroups() []Creator {
+	var cs []Creator
+	switch ns.Cgroup {
+	case "", "v1":
+		cs = cgroupsnamespace
+	case "v2":
+		cs = cgroup2namespace
+	}
+	var out []Creator
+	for _, c := range cs {
+		if !ns.skipped(target(c)) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// environ returns the environment to set, from env and ns, with the Go
+// toolchain or without.
+func (ns *Namespace) environ(env map[string]string, hasGo bool) map[string]string {
+	e := make(map[string]string)
+	for k, v := range env {
+		e[k] = v
+	}
+	if hasGo {
+		e["PATH"] = fmt.Sprintf("%v:%v:%v:%v", GoBin(), PATHHEAD, PATHMID, PATHTAIL)
+	} else {
+		for _, k := range goEnv {
+			delete(e, k)
+		}
+		e["PATH"] = fmt.Sprintf("%v:%v", PATHMID, PATHTAIL)
+	}
+	for k, v := range ns.Env {
+		e[k] = v
+	}
+	for _, k := range ns.Unset {
+		delete(e, k)
+	}
+	return e
+}
+
+// mustParseNamespace parses s, which is part of the program.
+func mustParseNamespace(s string) []Creator {
+	ns, err := ParseNamespace(strings.NewReader(s))
+	if err != nil {
+		panic(err)
+	}
+	return ns.Creators
+}
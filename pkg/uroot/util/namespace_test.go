@@ -0,0 +1,169 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package util
+
+import (
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestDefaultNamespace(t *testing.T) {
+	want := []Creator{
+		Dir{Name: "/tmp", Mode: 0777},
+		Dir{Name: "/env", Mode: 0777},
+		Dir{Name: "/tcz", Mode: 0777},
+		Dir{Name: "/lib", Mode: 0777},
+		Dir{Name: "/usr/lib", Mode: 0777},
+		Dir{Name: "/var/log", Mode: 0777},
+		Dir{Name: "/etc", Mode: 0777},
+		Dir{Name: "/proc", Mode: 0555},
+		Mount{Source: "proc", Target: "/proc", FSType: "proc"},
+		Mount{Source: "tmpfs", Target: "/tmp", FSType: "tmpfs"},
+		Dir{Name: "/dev", Mode: 0777},
+		Dev{Name: "/dev/tty", Mode: syscall.S_IFCHR | 0666, Dev: 0x0500},
+		Dev{Name: "/dev/urandom", Mode: syscall.S_IFCHR | 0444, Dev: 0x0109},
+		Dev{Name: "/dev/port", Mode: syscall.S_IFCHR | 0640, Dev: 0x0104},
+		Mount{Source: "devtmpfs", Target: "/dev", FSType: "devtmpfs"},
+		Dir{Name: "/dev/pts", Mode: 0777},
+		Mount{Source: "devpts", Target: "/dev/pts", FSType: "devpts", Opts: "newinstance,ptmxmode=666,gid=5,mode=620"},
+		Dev{Name: "/dev/ptmx", Mode: syscall.S_IFCHR | 0666, Dev: 0x0502},
+		Dir{Name: "/dev/shm", Mode: 0777},
+		Mount{Source: "tmpfs", Target: "/dev/shm", FSType: "tmpfs"},
+		Dir{Name: "/sys", Mode: 0555},
+		Mount{Source: "sysfs", Target: "/sys", FSType: "sysfs"},
+	}
+	ns := &Namespace{}
+	if got := ns.creators(false); !reflect.DeepEqual(got, want) {
+		t.Errorf("without Go: got %v, want %v", got, want)
+	}
+	got := ns.creators(true)
+	if len(got) != len(want)+3 || !reflect.DeepEqual(got[3:], want) {
+		t.Errorf("with Go: got %v, want the Go directories and %v", got, want)
+	}
+	if !reflect.DeepEqual(ns.cgroups(), cgroupsnamespace) {
+		t.Errorf("cgroups: got %v, want v1", ns.cgroups())
+	}
+}
+
+func TestParseNamespace(t *testing.T) {
+	ns, err := ParseNamespace(strings.NewReader(`
+# A small namespace.
+dir /tmp
+dir /proc 0555
+mount proc /proc proc
+mount tmpfs /tmp tmpfs ro,nosuid,size=10m  # a comment
+dev /dev/sda b 8 0
+dev /dev/null c 1 3 0666
+symlink /proc/self/fd /dev/fd
+link /etc/a /etc/b
+file /etc/hostname 0644 flash box
+skip /tcz
+env GOROOT
+env PS1 $ >
+unsetenv GOPATH
+cgroup v2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Namespace{
+		Creators: []Creator{
+			Dir{Name: "/tmp", Mode: 0777},
+			Dir{Name: "/proc", Mode: 0555},
+			Mount{Source: "proc", Target: "/proc", FSType: "proc"},
+			Mount{Source: "tmpfs", Target: "/tmp", FSType: "tmpfs", Flags: unix.MS_RDONLY | unix.MS_NOSUID, Opts: "size=10m"},
+			Dev{Name: "/dev/sda", Mode: syscall.S_IFBLK | 0666, Dev: 0x0800},
+			Dev{Name: "/dev/null", Mode: syscall.S_IFCHR | 0666, Dev: 0x0103},
+			Symlink{Target: "/proc/self/fd", NewPath: "/dev/fd"},
+			Link{OldPath: "/etc/a", NewPath: "/etc/b"},
+			File{Name: "/etc/hostname", Mode: 0644, Contents: "flash box"},
+		},
+		Skip:   []string{"/tcz"},
+		Env:    map[string]string{"GOROOT": "", "PS1": "$ >"},
+		Unset:  []string{"GOPATH"},
+		Cgroup: "v2",
+	}
+	if !reflect.DeepEqual(ns, want) {
+		t.Errorf("got %+v, want %+v", ns, want)
+	}
+	if !reflect.DeepEqual(ns.cgroups(), cgroup2namespace) {
+		t.Errorf("cgroups: got %v, want v2", ns.cgroups())
+	}
+
+	for _, tt := range []struct {
+		ns  string
+		err string
+	}{
+		{"mkdir /x", `namespace line 1: "mkdir /x": unknown kind "mkdir"`},
+		{"\ndir", `namespace line 2: "dir": wrong number of arguments`},
+		{"symlink a b c", `namespace line 1: "symlink a b c": wrong number of arguments`},
+		{"dir /x 0999", `namespace line 1: "dir /x 0999": bad mode "0999"`},
+		{"dev /dev/x p 1 2", `namespace line 1: "dev /dev/x p 1 2": device type is c or b, not "p"`},
+		{"dev /dev/x c one 2", `namespace line 1: "dev /dev/x c one 2": bad major number "one"`},
+		{"cgroup v3", `namespace line 1: "cgroup v3": cgroup is v1, v2 or none, not "v3"`},
+	} {
+		if _, err := ParseNamespace(strings.NewReader(tt.ns)); err == nil || err.Error() != tt.err {
+			t.Errorf("ParseNamespace(%q): got %v, want %v", tt.ns, err, tt.err)
+		}
+	}
+}
+
+func TestSkip(t *testing.T) {
+	ns, err := ParseNamespace(strings.NewReader("skip /tcz\nskip /go/\nskip /dev/pts\ncgroup none"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range ns.creators(true) {
+		if p := target(c); p == "/tcz" || strings.HasPrefix(p, "/go/") || strings.HasPrefix(p, "/dev/pts") {
+			t.Errorf("%v is not skipped", c)
+		}
+	}
+	var dev bool
+	for _, c := range ns.creators(true) {
+		dev = dev || target(c) == "/dev"
+	}
+	if !dev {
+		t.Errorf("/dev is skipped, but only /dev/pts should be")
+	}
+	if cs := ns.cgroups(); len(cs) != 0 {
+		t.Errorf("cgroups: got %v, want none", cs)
+	}
+
+	ns, err = ParseNamespace(strings.NewReader("skip /sys/fs/cgroup/perf_event"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs := ns.cgroups(); len(cs) != len(cgroupsnamespace)-2 {
+		t.Errorf("cgroups: got %d, want all but perf_event, %d", len(cs), len(cgroupsnamespace)-2)
+	}
+}
+
+func TestEnviron(t *testing.T) {
+	env := map[string]string{"LD_LIBRARY_PATH": "/usr/local/lib", "GOROOT": "/go", "GOPATH": "/", "GOBIN": "/ubin", "CGO_ENABLED": "0"}
+	ns := &Namespace{}
+	e := ns.environ(env, true)
+	if e["GOROOT"] != "/go" || !strings.HasPrefix(e["PATH"], GoBin()) {
+		t.Errorf("with Go: got %v", e)
+	}
+	if len(env) != 5 {
+		t.Errorf("environ changed env: %v", env)
+	}
+	e = ns.environ(env, false)
+	want := map[string]string{"LD_LIBRARY_PATH": "/usr/local/lib", "PATH": PATHMID + ":" + PATHTAIL}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("without Go: got %v, want %v", e, want)
+	}
+	ns = &Namespace{Env: map[string]string{"PATH": "/bin", "A": "b"}, Unset: []string{"LD_LIBRARY_PATH"}}
+	want = map[string]string{"PATH": "/bin", "A": "b"}
+	if e := ns.environ(env, false); !reflect.DeepEqual(e, want) {
+		t.Errorf("with env and unsetenv: got %v, want %v", e, want)
+	}
+}
@@ -119,45 +119,8 @@ func (m Mount) String() string {
 }
 
 var (
-	namespace = []Creator{
-		Dir{Name: "/buildbin", Mode: 0777},
-		Dir{Name: "/ubin", Mode: 0777},
-		Dir{Name: "/tmp", Mode: 0777},
-		Dir{Name: "/env", Mode: 0777},
-		Dir{Name: "/tcz", Mode: 0777},
-		Dir{Name: "/lib", Mode: 0777},
-		Dir{Name: "/usr/lib", Mode: 0777},
-		Dir{Name: "/var/log", Mode: 0777},
-		Dir{Name: "/go/pkg/linux_amd64", Mode: 0777},
-
-		Dir{Name: "/etc", Mode: 0777},
-
-		Dir{Name: "/proc", Mode: 0555},
-		Mount{Source: "proc", Target: "/proc", FSType: "proc"},
-		Mount{Source: "tmpfs", Target: "/tmp", FSType: "tmpfs"},
-
-		Dir{Name: "/dev", Mode: 0777},
-		Dev{Name: "/dev/tty", Mode: syscall.S_IFCHR | 0666, Dev: 0x0500},
-		Dev{Name: "/dev/urandom", Mode: syscall.S_IFCHR | 0444, Dev: 0x0109},
-		Dev{Name: "/dev/port", Mode: syscall.S_IFCHR | 0640, Dev: 0x0104},
-
-		// Kernel must be compiled with CONFIG_DEVTMPFS.
-		// Note that things kind of work even if this mount fails.
-		// TODO: move the Dir commands above below this line?
-		Mount{Source: "devtmpfs", Target: "/dev", FSType: "devtmpfs"},
-
-		Dir{Name: "/dev/pts", Mode: 0777},
-		Mount{Source: "devpts", Target: "/dev/pts", FSType: "devpts", Opts: "newinstance,ptmxmode=666,gid=5,mode=620"},
-		Dev{Name: "/dev/ptmx", Mode: syscall.S_IFCHR | 0666, Dev: 0x0502},
-		// Note: shm is required at least for Chrome. If you don't mount
-		// it chrome throws a bogus "out of memory" error, not the more
-		// useful "I can't open /dev/shm/whatever". SAD!
-		Dir{Name: "/dev/shm", Mode: 0777},
-		Mount{Source: "tmpfs", Target: "/dev/shm", FSType: "tmpfs"},
-
-		Dir{Name: "/sys", Mode: 0555},
-		Mount{Source: "sysfs", Target: "/sys", FSType: "sysfs"},
-	}
+	namespace = mustParseNamespace(DefaultNamespace)
+
 	cgroupsnamespace = []Creator{
 		Mount{Source: "cgroup", Target: "/sys/fs/cgroup", FSType: "tmpfs"},
 		Dir{Name: "/sys/fs/cgroup/memory", Mode: 0555},
@@ -185,6 +148,9 @@ var (
 		Mount{Source: "cgroup", Target: "/sys/fs/cgroup/hugetlb", FSType: "cgroup", Opts: "hugetlb"},
 		Mount{Source: "cgroup", Target: "/sys/fs/cgroup/perf_event", FSType: "cgroup", Opts: "perf_event"},
 	}
+	cgroup2namespace = []Creator{
+		Mount{Source: "cgroup2", Target: "/sys/fs/cgroup", FSType: "cgroup2"},
+	}
 
 	Env = map[string]string{
 		"LD_LIBRARY_PATH": "/usr/local/lib",
@@ -213,12 +179,31 @@ func create(namespace []Creator) {
 }
 
 // build the root file system.
+//
+// What is created is DefaultNamespace, or what NamespaceFile says, and
+// the cgroup hierarchy. The environment is set from Env. Without a Go
+// toolchain, there are no directories and variables for it.
 func Rootfs() {
-	Env["PATH"] = fmt.Sprintf("%v:%v:%v:%v", GoBin(), PATHHEAD, PATHMID, PATHTAIL)
+	ns := &Namespace{}
+	if f, err := os.Open(NamespaceFile); err == nil {
+		ns, err = ParseNamespace(f)
+		f.Close()
+		if err != nil {
+			log.Printf("%s: %v; using the default namespace", NamespaceFile, err)
+			ns = &Namespace{}
+		}
+	}
+	_, err := os.Stat(goToolchain)
+	hasGo := err == nil
+
+	Env = ns.environ(Env, hasGo)
+	for _, k := range ns.Unset {
+		os.Unsetenv(k)
+	}
 	for k, v := range Env {
 		os.Setenv(k, v)
 	}
-	create(namespace)
+	create(ns.creators(hasGo))
 
 	// systemd gets upset when it discovers something has already setup cgroups
 	// We have to do this after the base namespace is created, so we have /proc
@@ -226,7 +211,7 @@ func Rootfs() {
 	systemd, present := initFlags["systemd"]
 	systemdEnabled, boolErr := strconv.ParseBool(systemd)
 	if !present || boolErr != nil || systemdEnabled == false {
-		create(cgroupsnamespace)
+		create(ns.cgroups())
 	}
 
 }
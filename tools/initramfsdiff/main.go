@@ -0,0 +1,128 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// initramfsdiff shows the differences between two initramfs archives or
+// manifests.
+//
+// Synopsis:
+//     initramfsdiff [-s] OLD NEW
+//
+// Description:
+//     OLD and NEW may each be a newc cpio archive or a JSON manifest written
+//     by u-root -manifest. Added files are prefixed with +, removed files
+//     with -, and modified files with ~. The total change in content size is
+//     printed last.
+//
+// Options:
+//     -s: only print the size summary
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/uroot/initramfs"
+)
+
+var summary = flag.Bool("s", false, "Only print the size summary")
+
+// input is either a cpio archive or a manifest.
+type input struct {
+	archive  *cpio.Archive
+	manifest *initramfs.Manifest
+}
+
+func open(path string) (*input, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Manifests are JSON objects; everything else must be an archive.
+	b, err := bufio.NewReader(f).Peek(1)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading %q: %v", path, err)
+	}
+	if b[0] == '{' {
+		defer f.Close()
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		m, err := initramfs.ReadManifest(f)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", path, err)
+		}
+		return &input{manifest: m}, nil
+	}
+
+	// The archive's records read from f lazily, so f stays open.
+	a, err := cpio.ReadArchive(cpio.Newc.Reader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%q: %v", path, err)
+	}
+	return &input{archive: a}, nil
+}
+
+func (i *input) Manifest() (*initramfs.Manifest, error) {
+	if i.manifest != nil {
+		return i.manifest, nil
+	}
+	return initramfs.ManifestFromArchive(i.archive)
+}
+
+func diff(before, after *input) ([]initramfs.Change, uint64, uint64, error) {
+	bm, err := before.Manifest()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	am, err := after.Manifest()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	var changes []initramfs.Change
+	if before.archive != nil && after.archive != nil {
+		changes, err = initramfs.DiffArchives(before.archive, after.archive)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+	} else {
+		changes = initramfs.DiffManifests(bm, am)
+	}
+	return changes, bm.Size(), am.Size(), nil
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 2 {
+		log.Fatalf("Usage: initramfsdiff [-s] OLD NEW")
+	}
+
+	before, err := open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	after, err := open(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	changes, beforeSize, afterSize, err := diff(before, after)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !*summary {
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+	}
+	fmt.Printf("%d files changed; content size %d -> %d bytes (%+d)\n",
+		len(changes), beforeSize, afterSize, int64(afterSize)-int64(beforeSize))
+}
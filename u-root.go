@@ -7,10 +7,13 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 
+	humanize "github.com/dustin/go-humanize"
+	"github.com/u-root/u-root/pkg/cmdline"
 	"github.com/u-root/u-root/pkg/golang"
 	"github.com/u-root/u-root/pkg/uroot"
 	"github.com/u-root/u-root/pkg/uroot/builder"
@@ -32,6 +35,9 @@ func (m *multiFlag) Set(value string) error {
 // Flags for u-root builder.
 var (
 	build, format, tmpDir, base, outputPath *string
+	manifestPath, maxSize, namespace        *string
+	uinitArgs, uinitEnv                     *string
+	sizeReport                              *bool
 	initCmd                                 *string
 	defaultShell                            *string
 	useExistingInit                         *bool
@@ -230,6 +236,14 @@ func init() {
 	base = flag.String("base", "", "Base archive to add files to. By default, this is a couple of directories like /bin, /etc, etc.")
 	useExistingInit = flag.Bool("useinit", false, "Use existing init from base archive (only if --base was specified).")
 	outputPath = flag.String("o", "", "Path to output initramfs file.")
+	maxSize = flag.String("max-size", "", "Maximum size of the uncompressed initramfs, e.g. 8MiB. The build fails with a size breakdown if it is exceeded.")
+	sizeReport = flag.Bool("size-report", false, "Print a breakdown of the initramfs size by file, origin, and busybox command.")
+	manifestPath = flag.String("manifest", "", "Path to write a JSON manifest of the path, mode, size, SHA256, and origin of every file in the archive to.")
+
+	namespace = flag.String("namespace", "", "Namespace file for init to create the root file system from, e.g. to skip /tcz or use cgroup v2.")
+
+	uinitArgs = flag.String("uinitargs", "", "Default arguments for uinit, e.g. \"-mode netboot\". Quotes group words. uroot.uinitargs on the kernel command line replaces them.")
+	uinitEnv = flag.String("uinitenv", "", "Default NAME=value environment variables for uinit, e.g. \"MODE=netboot\". Quotes group words. Those of uroot.uinitenv on the kernel command line win over them.")
 
 	initCmd = flag.String("initcmd", "init", "Symlink target for /init. Can be an absolute path or a u-root command name.")
 	defaultShell = flag.String("defaultsh", "rush", "Default shell. Can be an absolute path or a u-root command name.")
@@ -314,7 +328,13 @@ func Main() error {
 			return err
 		}
 		defer bf.Close()
-		baseFile = archiver.Reader(bf)
+		// Pipes, e.g. from process substitution, cannot be read at
+		// random offsets.
+		if _, err := bf.Seek(0, io.SeekCurrent); err != nil {
+			baseFile = archiver.StreamReader(bf)
+		} else {
+			baseFile = archiver.Reader(bf)
+		}
 	} else {
 		baseFile = uroot.DefaultRamfs.Reader()
 	}
@@ -337,6 +357,63 @@ func Main() error {
 		InitCmd:         *initCmd,
 		DefaultShell:    *defaultShell,
 	}
+	if *manifestPath != "" {
+		opts.Manifest = &initramfs.Manifest{}
+	}
+	if *maxSize != "" {
+		opts.MaxSize, err = humanize.ParseBytes(*maxSize)
+		if err != nil {
+			return fmt.Errorf("invalid -max-size %q: %v", *maxSize, err)
+		}
+	}
+	if *sizeReport {
+		opts.SizeReport = &uroot.SizeReport{}
+	}
+	if *namespace != "" {
+		b, err := ioutil.ReadFile(*namespace)
+		if err != nil {
+			return err
+		}
+		opts.Namespace = string(b)
+	}
+	opts.UinitArgs = cmdline.Argv(*uinitArgs)
+	opts.UinitEnv = cmdline.Argv(*uinitEnv)
 	logger := log.New(os.Stderr, "", log.LstdFlags)
-	return uroot.CreateInitramfs(logger, opts)
+	err = uroot.CreateInitramfs(logger, opts)
+	if _, ok := err.(*uroot.SizeError); ok {
+		// Do not leave an oversized archive behind to be used by
+		// mistake. The size report and manifest are still written,
+		// since they are what is needed to trim the build.
+		if *outputPath != "" {
+			if rerr := os.RemoveAll(*outputPath); rerr != nil {
+				log.Printf("Could not remove oversized initramfs %q: %v", *outputPath, rerr)
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+	if opts.SizeReport != nil {
+		log.Printf("Size report:\n%v", opts.SizeReport)
+	}
+
+	if opts.Manifest != nil {
+		if merr := writeManifest(opts.Manifest, *manifestPath); merr != nil {
+			return merr
+		}
+		log.Printf("Manifest is %s", *manifestPath)
+	}
+	return err
+}
+
+// writeManifest encodes m to the file at path.
+func writeManifest(m *initramfs.Manifest, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := m.Encode(f); err != nil {
+		return fmt.Errorf("error writing manifest %q: %v", path, err)
+	}
+	return nil
 }
@@ -36,7 +36,12 @@ func Command(f Editor, c string, startLine, endLine int) error {
 	case 'q':
 		os.Exit(1)
 	case 'e':
+		// e replaces everything. Range is lines [1, $] of text,
+		// but bytes [0, $) of bin.
 		_, endLine = f.Range()
+		if _, ok := f.(*file); ok {
+			endLine++
+		}
 		startLine = 0
 		fallthrough
 	case 'r':
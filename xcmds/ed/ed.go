@@ -8,11 +8,14 @@
 //   ed - text editor
 //
 // SYNOPSIS
-//   ed [ - ] [ -d ] [ name ]
+//   ed [ - ] [ -d ] [ -t text|bin ] [ -v ] [ name ]
 //
 // DESCRIPTION
 //   Ed is the standard text editor.
 //
+//   With -v, or if it is called vi, it is a full-screen editor like vi
+//   instead. A bin file is shown and edited in hex.
+//
 // OPTIONS
 package main
 
@@ -22,6 +25,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
 )
 
@@ -40,6 +44,7 @@ var (
 		"bin":  NewBinEditor,
 	}
 	fileType = flag.String("t", "text", "type of file")
+	visualed = flag.Bool("v", false, "full-screen editing, like vi")
 )
 
 func readerio(r io.Reader) editorArg {
@@ -79,8 +84,14 @@ func main() {
 		flag.Usage()
 	}
 
+	visual := *visualed || filepath.Base(os.Args[0]) == "vi"
+	var name string
 	if len(flag.Args()) == 1 {
-		args = append(args, readFile(flag.Args()[0]))
+		name = flag.Args()[0]
+		// vi starts new files empty.
+		if _, err := os.Stat(name); !visual || !os.IsNotExist(err) {
+			args = append(args, readFile(name))
+		}
 	}
 
 	ed, err := e(args...)
@@ -88,6 +99,13 @@ func main() {
 		log.Fatalf("%v", err)
 	}
 
+	if visual {
+		if err := runVisual(ed, *fileType == "bin", name); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
 	// Now just eat the lines, and turn them into commands.
 	// The format is a regular language.
 	// [start][,end]command[rest of line]
@@ -222,6 +222,34 @@ func TestFileDelete(t *testing.T) {
 
 }
 
+func TestFileWriteLines(t *testing.T) {
+	// DoCommand passes Write the half-open range [start, end+1) for
+	// "start,endp".
+	var test = []struct {
+		start, end int
+		out        string
+	}{
+		{start: 1, end: 7, out: "a\nb\nc\nd\ne\nf\n"},
+		{start: 2, end: 7, out: "b\nc\nd\ne\nf\n"},
+		{start: 6, end: 7, out: "f\n"},
+		{start: 5, end: 6, out: "e\n"},
+		{start: 1, end: 2, out: "a\n"},
+	}
+
+	debug = t.Logf
+	for _, v := range test {
+		f := &file{dot: 1, data: []byte("a\nb\nc\nd\ne\nf\n"), lines: []int{0, 2, 4, 6, 8, 10}}
+		var b bytes.Buffer
+		if _, err := f.Write(&b, v.start, v.end); err != nil {
+			t.Errorf("Write [%d, %d): want nil, got %v", v.start, v.end, err)
+			continue
+		}
+		if b.String() != v.out {
+			t.Errorf("Write [%d, %d): want %q, got %q", v.start, v.end, v.out, b.String())
+		}
+	}
+}
+
 func TestFileDCommand(t *testing.T) {
 	var test = []struct {
 		c   string
@@ -239,6 +267,26 @@ func TestFileDCommand(t *testing.T) {
 			fi: &file{dot: 1, data: []byte("a\nb\nc\na\nb\nc"), lines: []int{0, 2, 4, 6, 8, 10}},
 			fo: &file{dot: 1, data: []byte("a\nb\nc"), lines: []int{0, 2, 4}},
 		},
+		{
+			c:  "5d",
+			fi: &file{dot: 1, data: []byte("a\nb\nc\nd\ne\nf\n"), lines: []int{0, 2, 4, 6, 8, 10}},
+			fo: &file{dot: 5, data: []byte("a\nb\nc\nd\nf\n"), lines: []int{0, 2, 4, 6, 8}},
+		},
+		{
+			c:  "$d",
+			fi: &file{dot: 1, data: []byte("a\nb\nc\nd\ne\nf\n"), lines: []int{0, 2, 4, 6, 8, 10}},
+			fo: &file{dot: 5, data: []byte("a\nb\nc\nd\ne\n"), lines: []int{0, 2, 4, 6, 8}},
+		},
+		{
+			c:  "2,$d",
+			fi: &file{dot: 1, data: []byte("a\nb\nc\nd\ne\nf\n"), lines: []int{0, 2, 4, 6, 8, 10}},
+			fo: &file{dot: 1, data: []byte("a\n"), lines: []int{0}},
+		},
+		{
+			c:  "1,$d",
+			fi: &file{dot: 1, data: []byte("a\nb\nc\nd\ne\nf\n"), lines: []int{0, 2, 4, 6, 8, 10}},
+			fo: &file{dot: 1},
+		},
 	}
 
 	debug = t.Logf
@@ -116,7 +116,7 @@ func (f *file) SliceX(startLine, endLine int) (int, int) {
 		endLine = startLine
 	}
 	if endLine != startLine {
-		if endLine >= len(f.lines) {
+		if endLine > len(f.lines) {
 			//f.end = len(f.lines)
 			end = len(f.data)
 			return start, end
@@ -0,0 +1,507 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/termios"
+)
+
+// Keys that are not runes.
+const (
+	keyEsc rune = -1 - iota
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyPgUp
+	keyPgDn
+	keyDel
+)
+
+func ctrl(c byte) rune {
+	return rune(c & 0x1f)
+}
+
+// A visual is a vi-like full-screen editor. Its buffer is an Editor: a text
+// one, which it shows as lines, or a bin one, which it shows in hex.
+//
+// Keys in text mode are
+//     h, j, k, l, arrows: move; w, b: move a word
+//     0, ^, $, Home, End: go to the start or end of the line
+//     gg, G, NG: go to the first, the last or line N
+//     ^F, ^B, PgDn, PgUp: move a page
+//     i, a, I, A, o, O: insert; ESC ends it
+//     x, X, D, dd, J: delete characters, the rest of the line, lines,
+//         and join lines
+//     C, r: change the rest of the line, or a character
+//     yy, Y, p, P: yank lines and put them after or before the cursor
+//     /RE, ?RE, n, N: search forward, back, and again
+//     u, ^R: undo and redo
+//     ^L: redraw; ^G: show where you are
+//     ZZ: write and quit
+// and commands, typed after :, are
+//     w [FILE], q, q!, wq, x: write and quit
+//     N, $: go to line N or the last line
+//     [RANGE]s/RE/TEXT/[g]: substitute; RANGE is %, N or N,M
+//     set hex, set nohex: switch between text and hex mode
+// Most keys take a count, e.g. 3dd.
+//
+// In hex mode, h, j, k, l, the arrows and the paging keys move over
+// bytes, x deletes them, r reads two hex digits for the byte at the
+// cursor, and i and a insert bytes typed as pairs of hex digits. /HEX and
+// ?HEX search for bytes, and :s/HEX/HEX/ substitutes bytes of the same
+// length in the whole buffer.
+type visual struct {
+	ed   Editor
+	hex  bool
+	name string
+
+	in  *bufio.Reader
+	out *bufio.Writer
+	// rows and cols are the size of the screen. The last row is the
+	// status line.
+	rows, cols int
+	// screen is what is on the screen, by row.
+	screen []string
+	msg    string
+	mode   string
+
+	// cy and cx are the line and character of the cursor in text mode,
+	// and top and left are the first line and column shown.
+	cy, cx, top, left int
+	// off is the offset of the cursor in hex mode, and row is the first
+	// row shown.
+	off, row int
+
+	count      int
+	yank       []string
+	pat        string
+	back       bool
+	undo, redo []state
+	quit       bool
+}
+
+// state is what undo goes back to.
+type state struct {
+	data        []byte
+	cy, cx, off int
+}
+
+func newVisual(ed Editor, hex bool, name string, in io.Reader, out io.Writer, rows, cols int) *visual {
+	return &visual{
+		ed:   ed,
+		hex:  hex,
+		name: name,
+		in:   bufio.NewReader(in),
+		out:  bufio.NewWriter(out),
+		rows: rows,
+		cols: cols,
+		cy:   1,
+		top:  1,
+	}
+}
+
+// runVisual edits with a visual on the terminal.
+func runVisual(ed Editor, hex bool, name string) error {
+	t, err := termios.New()
+	if err != nil {
+		return err
+	}
+	rows, cols := 24, 80
+	if w, err := t.GetWinSize(); err == nil && w.Row > 1 && w.Col > 0 {
+		rows, cols = int(w.Row), int(w.Col)
+	}
+	old, err := t.Raw()
+	if err != nil {
+		return err
+	}
+	defer t.Set(old)
+	v := newVisual(ed, hex, name, t, t, rows, cols)
+	if name != "" {
+		v.msg = fmt.Sprintf("%q %d bytes", name, len(v.data()))
+	}
+	err = v.run()
+	fmt.Fprintf(t, "\x1b[%d;1H\x1b[K", rows)
+	return err
+}
+
+// run edits until the user quits.
+func (v *visual) run() error {
+	for !v.quit {
+		v.draw()
+		k, err := v.key()
+		if err != nil {
+			return err
+		}
+		v.msg = ""
+		if k >= '0' && k <= '9' && (k != '0' || v.count > 0) {
+			v.count = v.count*10 + int(k-'0')
+			continue
+		}
+		if v.hex {
+			v.hexKey(k)
+		} else {
+			v.textKey(k)
+		}
+		v.count = 0
+	}
+	return nil
+}
+
+// times returns the count of a command, which is 1 if none was typed.
+func (v *visual) times() int {
+	if v.count == 0 {
+		return 1
+	}
+	return v.count
+}
+
+// key reads a key.
+func (v *visual) key() (rune, error) {
+	r, _, err := v.in.ReadRune()
+	if err != nil || r != 27 {
+		return r, err
+	}
+	// A lone ESC is not followed by the rest of a control sequence.
+	if v.in.Buffered() == 0 {
+		return keyEsc, nil
+	}
+	c, _, err := v.in.ReadRune()
+	if err != nil {
+		return keyEsc, nil
+	}
+	if c != '[' && c != 'O' {
+		v.in.UnreadRune()
+		return keyEsc, nil
+	}
+	// A control sequence is parameters and a final byte.
+	var param string
+	for {
+		c, _, err = v.in.ReadRune()
+		if err != nil {
+			return keyEsc, nil
+		}
+		if c >= 0x40 && c <= 0x7e {
+			break
+		}
+		param += string(c)
+	}
+	switch {
+	case c == 'A':
+		return keyUp, nil
+	case c == 'B':
+		return keyDown, nil
+	case c == 'C':
+		return keyRight, nil
+	case c == 'D':
+		return keyLeft, nil
+	case c == 'H', c == '~' && (param == "1" || param == "7"):
+		return keyHome, nil
+	case c == 'F', c == '~' && (param == "4" || param == "8"):
+		return keyEnd, nil
+	case c == '~' && param == "3":
+		return keyDel, nil
+	case c == '~' && param == "5":
+		return keyPgUp, nil
+	case c == '~' && param == "6":
+		return keyPgDn, nil
+	}
+	return v.key()
+}
+
+// data returns all of the buffer.
+func (v *visual) data() []byte {
+	var b bytes.Buffer
+	if v.hex {
+		_, end := v.ed.Range()
+		v.ed.Write(&b, 0, end)
+	} else if n := v.lines(); n > 0 {
+		v.ed.Write(&b, 1, n+1)
+	}
+	return b.Bytes()
+}
+
+// setData replaces all of the buffer with d.
+func (v *visual) setData(d []byte) {
+	if v.hex {
+		_, end := v.ed.Range()
+		v.ed.Replace(d, 0, end)
+		return
+	}
+	v.ed.Replace(d, 1, v.lines()+1)
+}
+
+// change is called before the buffer is changed, so that it can be undone.
+func (v *visual) change() {
+	v.undo = append(v.undo, state{data: v.data(), cy: v.cy, cx: v.cx, off: v.off})
+	v.redo = nil
+	v.ed.Dirty(true)
+}
+
+// undoLast goes back to the state of from before the last change, after
+// saving the current one in to.
+func (v *visual) undoLast(from, to *[]state) {
+	if len(*from) == 0 {
+		v.msg = "Nothing to undo"
+		return
+	}
+	s := (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+	*to = append(*to, state{data: v.data(), cy: v.cy, cx: v.cx, off: v.off})
+	v.setData(s.data)
+	v.cy, v.cx, v.off = s.cy, s.cx, s.off
+	v.ed.Dirty(true)
+}
+
+// draw updates the screen, writing only the rows that changed.
+func (v *visual) draw() {
+	var rows []string
+	var y, x int
+	if v.hex {
+		rows, y, x = v.hexRows()
+	} else {
+		rows, y, x = v.textRows()
+	}
+	status := v.msg
+	if status == "" {
+		status = v.mode
+	}
+	rows = append(rows, v.statusLine(status))
+	v.show(rows, y, x)
+}
+
+func (v *visual) statusLine(s string) string {
+	var pos string
+	if v.hex {
+		pos = fmt.Sprintf("%#x", v.off)
+	} else {
+		pos = fmt.Sprintf("%d,%d", v.cy, v.cx+1)
+	}
+	if len(s)+len(pos)+1 < v.cols {
+		s += strings.Repeat(" ", v.cols-len(s)-len(pos)-1) + pos
+	}
+	return s
+}
+
+// show puts rows on the screen, and the cursor at y, x.
+func (v *visual) show(rows []string, y, x int) {
+	if v.screen == nil {
+		v.out.WriteString("\x1b[H\x1b[2J")
+		v.screen = make([]string, v.rows)
+	}
+	for i, r := range rows {
+		if len(r) > v.cols {
+			r = r[:v.cols]
+		}
+		if i < len(v.screen) && v.screen[i] == r {
+			continue
+		}
+		fmt.Fprintf(v.out, "\x1b[%d;1H%s\x1b[K", i+1, r)
+		v.screen[i] = r
+	}
+	fmt.Fprintf(v.out, "\x1b[%d;%dH", y+1, x+1)
+	v.out.Flush()
+}
+
+// redraw draws all of the screen again.
+func (v *visual) redraw() {
+	v.screen = nil
+}
+
+// prompt reads a line on the status line after p. It returns false if the
+// user typed ESC or ^C.
+func (v *visual) prompt(p string) (string, bool) {
+	var l []rune
+	for {
+		s := p + string(l)
+		v.show(append(v.screen[:v.rows-1:v.rows-1], s), v.rows-1, len(p)+len(l))
+		k, err := v.key()
+		if err != nil {
+			return "", false
+		}
+		switch k {
+		case '\r', '\n':
+			return string(l), true
+		case keyEsc, ctrl('C'):
+			return "", false
+		case 127, ctrl('H'):
+			if len(l) == 0 {
+				return "", false
+			}
+			l = l[:len(l)-1]
+		case ctrl('U'):
+			l = nil
+		default:
+			if k >= ' ' {
+				l = append(l, k)
+			}
+		}
+	}
+}
+
+// command runs a command typed after :.
+func (v *visual) command(c string) {
+	c = strings.TrimSpace(c)
+	switch {
+	case c == "":
+	case c == "q":
+		if v.ed.IsDirty() {
+			v.msg = "No write since last change (add ! to override)"
+			return
+		}
+		v.quit = true
+	case c == "q!":
+		v.quit = true
+	case c == "w" || strings.HasPrefix(c, "w "):
+		v.write(strings.TrimSpace(c[1:]))
+	case c == "wq" || c == "x":
+		if v.write("") {
+			v.quit = true
+		}
+	case c == "set hex":
+		v.setHex(true)
+	case c == "set nohex":
+		v.setHex(false)
+	case c == "$":
+		v.cy, v.off = v.lines(), v.size()
+	default:
+		if n, err := strconv.ParseInt(c, 0, 0); err == nil {
+			// Offsets can be in hex, e.g. 0x1000.
+			v.cy, v.off = int(n), int(n)
+			return
+		}
+		if v.substitute(c) {
+			return
+		}
+		v.msg = fmt.Sprintf("Not an editor command: %s", c)
+	}
+}
+
+// write writes the buffer to name, or to the file being edited.
+func (v *visual) write(name string) bool {
+	if name == "" {
+		name = v.name
+	}
+	if name == "" {
+		v.msg = "No file name"
+		return false
+	}
+	d := v.data()
+	f, err := os.Create(name)
+	if err == nil {
+		_, err = f.Write(d)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		v.msg = err.Error()
+		return false
+	}
+	if v.name == "" {
+		v.name = name
+	}
+	if name == v.name {
+		v.ed.Dirty(false)
+	}
+	v.msg = fmt.Sprintf("%q %d bytes written", name, len(d))
+	return true
+}
+
+// setHex switches to hex mode, with a bin Editor, or back to text mode,
+// with a text one. Changes can not be undone across the switch.
+func (v *visual) setHex(hex bool) {
+	if hex == v.hex {
+		return
+	}
+	d, dirty := v.data(), v.ed.IsDirty()
+	newEditor := NewTextEditor
+	if hex {
+		newEditor = NewBinEditor
+	}
+	ed, err := newEditor(readerio(bytes.NewReader(d)))
+	if err != nil {
+		v.msg = err.Error()
+		return
+	}
+	ed.Dirty(dirty)
+	v.ed, v.hex = ed, hex
+	v.undo, v.redo = nil, nil
+	v.cy, v.cx, v.top, v.left, v.off, v.row = 1, 0, 1, 0, 0, 0
+}
+
+// substitute runs an s command, and returns false if c is not one.
+func (v *visual) substitute(c string) bool {
+	start, end := v.cy, v.cy
+	switch {
+	case strings.HasPrefix(c, "%"):
+		start, end = 1, v.lines()
+		c = c[1:]
+	case len(c) > 0 && c[0] >= '0' && c[0] <= '9':
+		i := strings.IndexFunc(c, func(r rune) bool { return (r < '0' || r > '9') && r != ',' })
+		if i < 0 {
+			return false
+		}
+		r := strings.SplitN(c[:i], ",", 2)
+		var err error
+		if start, err = strconv.Atoi(r[0]); err != nil {
+			return false
+		}
+		end = start
+		if len(r) == 2 {
+			if end, err = strconv.Atoi(r[1]); err != nil {
+				return false
+			}
+		}
+		c = c[i:]
+	}
+	if len(c) < 2 || c[0] != 's' {
+		return false
+	}
+	o := strings.SplitN(c[2:], c[1:2], 3)
+	for len(o) < 3 {
+		o = append(o, "")
+	}
+	if o[0] == "" {
+		o[0] = v.pat
+	}
+	v.change()
+	var err error
+	if v.hex {
+		// Sub compares len(o[0])/2 bytes at each offset up to the end it
+		// is given.
+		n := v.size() - len(o[0])/2 + 1
+		if n > v.size() {
+			n = v.size()
+		}
+		switch {
+		case o[0] == "" || len(o[0])%2 != 0:
+			err = fmt.Errorf("bad hex pattern %q", o[0])
+		case n > 0:
+			err = v.ed.Sub(o[0], o[1], "", 0, n)
+		}
+	} else {
+		if start < 1 || end < start || end > v.lines() {
+			err = fmt.Errorf("bad range %d,%d", start, end)
+		} else {
+			err = v.ed.Sub(o[0], o[1], strings.Replace(o[2], "p", "", -1), start, end+1)
+		}
+	}
+	if err != nil {
+		v.undo = v.undo[:len(v.undo)-1]
+		v.msg = err.Error()
+	}
+	return true
+}
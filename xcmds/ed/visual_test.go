@@ -0,0 +1,165 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// visualEdit edits d with keys, and returns the visual.
+func visualEdit(t *testing.T, newEditor func(...editorArg) (Editor, error), d, keys string) *visual {
+	ed, err := newEditor(readerio(strings.NewReader(d)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := newVisual(ed, false, "", strings.NewReader(keys), ioutil.Discard, 10, 40)
+	if _, ok := ed.(*bin); ok {
+		v.hex = true
+	}
+	if err := v.run(); err != nil && err != io.EOF {
+		t.Fatalf("%q: %v", keys, err)
+	}
+	return v
+}
+
+func TestVisualText(t *testing.T) {
+	for _, tt := range []struct {
+		d, keys, want string
+	}{
+		{"a\n", "ihello \x1b", "hello a\n"},
+		{"", "ione\rtwo\x1b", "one\ntwo\n"},
+		{"one\ntwo\nthree\n", "jdd", "one\nthree\n"},
+		{"1\n2\n3\n4\n", "3Gdd", "1\n2\n4\n"},
+		{"1\n2\n3\n4\n", "j2dd", "1\n4\n"},
+		{"a b c\n", "wx", "a  c\n"},
+		{"abc\n", "$x", "ab\n"},
+		{"abc\n", "2x", "c\n"},
+		{"abc\n", "rX", "Xbc\n"},
+		{"abc def\n", "wD", "abc \n"},
+		{"abc def\n", "wCxyz\x1b", "abc xyz\n"},
+		{"abc\n", "A\x7f\x7fd\x1b", "ad\n"},
+		{"  abc\n", "I-\x1b", "  -abc\n"},
+		{"ab\ncd\n", "ji\x7f\x1b", "abcd\n"},
+		{"one two\n", "wi\r\x1b", "one \ntwo\n"},
+		{"ab\ncd\n", "\x1b[B\x1b[Cx", "ab\nc\n"},
+		{"no newline", "oadded\x1b", "no newline\nadded\n"},
+		{"one\n", "Otop\x1b", "top\none\n"},
+		{"one\ntwo\n", "yyjp", "one\ntwo\none\n"},
+		{"one\ntwo\n", "jYggP", "two\none\ntwo\n"},
+		{"one\n  two\nthree\n", "J", "one two\nthree\n"},
+		{"one\ntwo\nthree\n", "3J", "one two three\n"},
+		{"one\ntwo\nthree\n", "2ddu", "one\ntwo\nthree\n"},
+		{"one\ntwo\n", "ddu\x12", "two\n"},
+		{"one\ntwo\n", "xxuu", "one\ntwo\n"},
+		{"foo bar foo\nfoo\n", ":%s/foo/baz/g\r", "baz bar baz\nbaz\n"},
+		{"foo foo\nfoo\n", ":s/foo/x/\r", "x foo\nfoo\n"},
+		{"a\nfoo\nfoo\nfoo\n", ":2,3s/o+/u/\r", "a\nfu\nfu\nfoo\n"},
+		{"a\nb\nneedle\nc\n", "/needle\rdd", "a\nb\nc\n"},
+		{"x\nneedle\ny\nneedle\n", "G?needle\rdd", "x\ny\nneedle\n"},
+		{"a needle\nneedle\n", "/needle\rnx", "a needle\needle\n"},
+		{"needle\nx\n", "j/needle\rx", "eedle\nx\n"},
+		{"one\ntwo\n", ":2\rx", "one\nwo\n"},
+		{"abc\n", ":set hex\rx:set nohex\rx", "c\n"},
+	} {
+		v := visualEdit(t, NewTextEditor, tt.d, tt.keys+":q!\r")
+		if got := string(v.data()); got != tt.want {
+			t.Errorf("%q with %q: got %q, want %q", tt.d, tt.keys, got, tt.want)
+		}
+		if !v.quit {
+			t.Errorf("%q with %q: did not quit", tt.d, tt.keys)
+		}
+	}
+}
+
+func TestVisualHex(t *testing.T) {
+	for _, tt := range []struct {
+		d, keys, want string
+	}{
+		{"\x00\x01\x02", "x", "\x01\x02"},
+		{"\x00\x01\x02", "l2x", "\x00"},
+		{"\x00\x01\x02", "lrff", "\x00\xff\x02"},
+		{"\x00\x01", "i4142\x1b", "AB\x00\x01"},
+		{"\x00\x01", "a41\x1b", "\x00A\x01"},
+		{"", "i41\x1b", "A"},
+		{"\x00\x01\x02\x01", "/01\rnx", "\x00\x01\x02"},
+		{"\x00\x01\x02\x01", "$?01\rx", "\x00\x02\x01"},
+		{"\x00\x01\x02\x01", ":s/01/ff/\r", "\x00\xff\x02\xff"},
+		{"\x00\x01\x02", "xxu", "\x01\x02"},
+		{strings.Repeat("x", 100), ":0x40\rrff", strings.Repeat("x", 64) + "\xff" + strings.Repeat("x", 35)},
+		// Found by fuzzing: an empty pattern was matched past the end.
+		{"abc\n", "9x:s$\n", ""},
+		{"\x00\x01", ":s/0/f/\r", "\x00\x01"},
+	} {
+		v := visualEdit(t, NewBinEditor, tt.d, tt.keys+":q!\r")
+		if got := string(v.data()); got != tt.want {
+			t.Errorf("%q with %q: got %q, want %q", tt.d, tt.keys, got, tt.want)
+		}
+	}
+}
+
+func TestVisualWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	n := filepath.Join(dir, "f")
+
+	// q does not quit with changes that were not written.
+	v := visualEdit(t, NewTextEditor, "abc\n", "x:q\r")
+	if v.quit {
+		t.Errorf("q quit with changes")
+	}
+	v = visualEdit(t, NewTextEditor, "abc\n", "x:w "+n+"\r:q\r")
+	if !v.quit {
+		t.Errorf("q did not quit after w: %s", v.msg)
+	}
+	if b, err := ioutil.ReadFile(n); err != nil || string(b) != "bc\n" {
+		t.Errorf("%s: got %q, %v, want %q", n, b, err, "bc\n")
+	}
+	ed, err := NewTextEditor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v = newVisual(ed, false, n, strings.NewReader("inew\x1bZZ"), ioutil.Discard, 10, 40)
+	if err := v.run(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(n); err != nil || string(b) != "new\n" {
+		t.Errorf("%s after ZZ: got %q, %v, want %q", n, b, err, "new\n")
+	}
+}
+
+func TestVisualScreen(t *testing.T) {
+	ed, err := NewTextEditor(readerio(strings.NewReader("one\n\ttab\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	v := newVisual(ed, false, "", strings.NewReader("jA"), &out, 4, 40)
+	v.run()
+	// Input ends in insert mode, which leaves it, as ESC does.
+	if !strings.Contains(out.String(), "-- INSERT --") {
+		t.Errorf("got %q, want -- INSERT -- shown", out.String())
+	}
+	want := []string{"one", "        tab", "~"}
+	for i, w := range want {
+		if !strings.HasPrefix(v.screen[i], w) {
+			t.Errorf("row %d: got %q, want %q", i, v.screen[i], w)
+		}
+	}
+	if !strings.HasSuffix(v.screen[3], "2,4") {
+		t.Errorf("status: got %q, want it to end with 2,4", v.screen[3])
+	}
+	if !strings.HasSuffix(out.String(), "\x1b[2;11H") {
+		t.Errorf("cursor: got %q, want it at 2,11", out.String())
+	}
+}
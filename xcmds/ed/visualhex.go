@@ -0,0 +1,251 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+// hexWidth is how many bytes a row shows in hex mode.
+const hexWidth = 16
+
+// size returns the size of the buffer in hex mode.
+func (v *visual) size() int {
+	_, n := v.ed.Range()
+	return n
+}
+
+// hexData returns bytes [start, end) of the buffer.
+func (v *visual) hexData(start, end int) []byte {
+	if n := v.size(); end > n {
+		end = n
+	}
+	if start >= end {
+		return nil
+	}
+	var b bytes.Buffer
+	v.ed.Write(&b, start, end)
+	return b.Bytes()
+}
+
+// hexClamp puts the cursor on a byte, or after the last one in insert
+// mode, and scrolls so that it is shown.
+func (v *visual) hexClamp(insert bool) {
+	n := v.size()
+	if !insert && n > 0 {
+		n--
+	}
+	if v.off > n {
+		v.off = n
+	}
+	if v.off < 0 {
+		v.off = 0
+	}
+	h := v.rows - 1
+	if r := v.off / hexWidth; r < v.row {
+		v.row = r
+	} else if r >= v.row+h {
+		v.row = r - h + 1
+	}
+}
+
+// hexRows returns the rows of the screen in hex mode, and where the
+// cursor is.
+func (v *visual) hexRows() ([]string, int, int) {
+	v.hexClamp(v.mode != "")
+	var rows []string
+	n := v.size()
+	for i := 0; i < v.rows-1; i++ {
+		o := (v.row + i) * hexWidth
+		if o >= n && !(o == 0 && n == 0) {
+			rows = append(rows, "~")
+			continue
+		}
+		d := v.hexData(o, o+hexWidth)
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "%08x  ", o)
+		for j := 0; j < hexWidth; j++ {
+			if j < len(d) {
+				fmt.Fprintf(&b, "%02x ", d[j])
+			} else {
+				b.WriteString("   ")
+			}
+		}
+		b.WriteByte(' ')
+		for _, c := range d {
+			if c < ' ' || c > '~' {
+				c = '.'
+			}
+			b.WriteByte(c)
+		}
+		rows = append(rows, b.String())
+	}
+	return rows, v.off/hexWidth - v.row, 10 + 3*(v.off%hexWidth)
+}
+
+// hexDigit returns the value of hex digit k, or -1.
+func hexDigit(k rune) int {
+	switch {
+	case k >= '0' && k <= '9':
+		return int(k - '0')
+	case k >= 'a' && k <= 'f':
+		return int(k-'a') + 10
+	case k >= 'A' && k <= 'F':
+		return int(k-'A') + 10
+	}
+	return -1
+}
+
+// hexByte reads a byte typed as two hex digits. It returns false if
+// something else is typed.
+func (v *visual) hexByte() (byte, bool) {
+	var b int
+	for i := 0; i < 2; i++ {
+		k, err := v.key()
+		if err != nil {
+			return 0, false
+		}
+		d := hexDigit(k)
+		if d < 0 {
+			return 0, false
+		}
+		b = b<<4 | d
+		v.msg = fmt.Sprintf("%x", b)
+		v.draw()
+	}
+	v.msg = ""
+	return byte(b), true
+}
+
+// hexKey handles a key in hex mode.
+func (v *visual) hexKey(k rune) {
+	switch k {
+	case 'h', keyLeft, ctrl('H'), 127:
+		v.off -= v.times()
+	case 'l', keyRight, ' ':
+		v.off += v.times()
+	case 'j', keyDown, ctrl('N'), '\r', '\n':
+		v.off += hexWidth * v.times()
+	case 'k', keyUp, ctrl('P'):
+		v.off -= hexWidth * v.times()
+	case '0', keyHome:
+		v.off -= v.off % hexWidth
+	case '$', keyEnd:
+		v.off += hexWidth - 1 - v.off%hexWidth
+	case 'G':
+		v.off = v.size()
+		if v.count > 0 {
+			v.off = v.count
+		}
+	case 'g':
+		if k, _ := v.key(); k == 'g' {
+			v.off = 0
+		}
+	case ctrl('F'), keyPgDn:
+		v.off += (v.rows - 2) * hexWidth * v.times()
+	case ctrl('B'), keyPgUp:
+		v.off -= (v.rows - 2) * hexWidth * v.times()
+	case 'x', keyDel:
+		if v.off < v.size() {
+			v.change()
+			end := v.off + v.times()
+			if n := v.size(); end > n {
+				end = n
+			}
+			v.ed.Replace(nil, v.off, end)
+		}
+	case 'r':
+		if v.off >= v.size() {
+			return
+		}
+		if b, ok := v.hexByte(); ok {
+			v.change()
+			v.ed.Replace([]byte{b}, v.off, v.off+1)
+		}
+	case 'i', 'a':
+		v.change()
+		if k == 'a' && v.size() > 0 {
+			v.off++
+		}
+		v.hexInsert()
+	case 'u':
+		v.undoLast(&v.undo, &v.redo)
+	case ctrl('R'):
+		v.undoLast(&v.redo, &v.undo)
+	case '/', '?':
+		p, ok := v.prompt(string(k))
+		if !ok {
+			return
+		}
+		if p != "" {
+			v.pat = p
+		}
+		v.back = k == '?'
+		v.hexSearch(v.back)
+	case 'n':
+		v.hexSearch(v.back)
+	case 'N':
+		v.hexSearch(!v.back)
+	case ':':
+		if c, ok := v.prompt(":"); ok {
+			v.command(c)
+		}
+	case 'Z':
+		if k, _ := v.key(); k == 'Z' {
+			v.command("x")
+		}
+	case ctrl('L'):
+		v.redraw()
+	case ctrl('G'):
+		v.msg = fmt.Sprintf("%q %d bytes", v.name, v.size())
+	}
+}
+
+// hexInsert inserts bytes typed as pairs of hex digits until ESC.
+func (v *visual) hexInsert() {
+	v.mode = "-- INSERT --"
+	defer func() {
+		v.mode = ""
+	}()
+	for {
+		v.draw()
+		b, ok := v.hexByte()
+		if !ok {
+			return
+		}
+		v.ed.Replace([]byte{b}, v.off, v.off)
+		v.off++
+	}
+}
+
+// hexSearch goes to the next bytes that are the last pattern, in hex, or
+// the ones before.
+func (v *visual) hexSearch(back bool) {
+	p, err := hex.DecodeString(v.pat)
+	if err != nil || len(p) == 0 {
+		v.msg = fmt.Sprintf("Not hex bytes: %s", v.pat)
+		return
+	}
+	d := v.hexData(0, v.size())
+	i := -1
+	if back {
+		if i = bytes.LastIndex(d[:v.off], p); i < 0 {
+			i = bytes.LastIndex(d, p)
+		}
+	} else if v.off+1 <= len(d) {
+		if i = bytes.Index(d[v.off+1:], p); i > -1 {
+			i += v.off + 1
+		} else {
+			i = bytes.Index(d, p)
+		}
+	}
+	if i < 0 {
+		v.msg = fmt.Sprintf("Pattern not found: %s", v.pat)
+		return
+	}
+	v.off = i
+}
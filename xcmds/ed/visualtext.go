@@ -0,0 +1,531 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// tabWidth is how many columns a tab goes to a multiple of.
+const tabWidth = 8
+
+// lines returns the number of lines of the buffer.
+func (v *visual) lines() int {
+	_, n := v.ed.Range()
+	return n
+}
+
+// line returns line n, without its newline.
+func (v *visual) line(n int) []rune {
+	if n < 1 || n > v.lines() {
+		return nil
+	}
+	var b bytes.Buffer
+	v.ed.Write(&b, n, n+1)
+	return []rune(strings.TrimSuffix(b.String(), "\n"))
+}
+
+// replaceLines replaces lines [from, to) with ls.
+func (v *visual) replaceLines(from, to int, ls ...[]rune) {
+	n := v.lines()
+	if to > n+1 {
+		to = n + 1
+	}
+	// The last line may have no newline, and lines can not be added
+	// after it then.
+	if from > n && n > 0 {
+		var b bytes.Buffer
+		v.ed.Write(&b, n, n+1)
+		if !bytes.HasSuffix(b.Bytes(), []byte{'\n'}) {
+			b.WriteByte('\n')
+			v.ed.Replace(b.Bytes(), n, n+1)
+		}
+	}
+	var b bytes.Buffer
+	for _, l := range ls {
+		b.WriteString(string(l))
+		b.WriteByte('\n')
+	}
+	v.ed.Replace(b.Bytes(), from, to)
+}
+
+// setLine replaces the line of the cursor with l.
+func (v *visual) setLine(l []rune) {
+	v.replaceLines(v.cy, v.cy+1, l)
+}
+
+// column returns the column character x of l is shown in.
+func column(l []rune, x int) int {
+	c := 0
+	for i := 0; i < x && i < len(l); i++ {
+		if l[i] == '\t' {
+			c += tabWidth - c%tabWidth
+		} else {
+			c++
+		}
+	}
+	return c
+}
+
+// expand returns l as it is shown, with tabs as spaces and control
+// characters as ?.
+func expand(l []rune) string {
+	var b strings.Builder
+	c := 0
+	for _, r := range l {
+		switch {
+		case r == '\t':
+			n := tabWidth - c%tabWidth
+			b.WriteString(strings.Repeat(" ", n))
+			c += n
+			continue
+		case r < ' ' || r == 127:
+			r = '?'
+		}
+		b.WriteRune(r)
+		c++
+	}
+	return b.String()
+}
+
+// clamp puts the cursor on the text, and scrolls so that it is shown. In
+// insert mode, the cursor can be after the end of the line.
+func (v *visual) clamp(insert bool) {
+	n := v.lines()
+	if v.cy > n {
+		v.cy = n
+	}
+	if v.cy < 1 {
+		v.cy = 1
+	}
+	l := len(v.line(v.cy))
+	if !insert && l > 0 {
+		l--
+	}
+	if v.cx > l {
+		v.cx = l
+	}
+	if v.cx < 0 {
+		v.cx = 0
+	}
+	h := v.rows - 1
+	if v.cy < v.top {
+		v.top = v.cy
+	}
+	if v.cy >= v.top+h {
+		v.top = v.cy - h + 1
+	}
+	c := column(v.line(v.cy), v.cx)
+	if c < v.left {
+		v.left = c
+	}
+	if c >= v.left+v.cols {
+		v.left = c - v.cols + 1
+	}
+}
+
+// textRows returns the rows of the screen in text mode, and where the
+// cursor is.
+func (v *visual) textRows() ([]string, int, int) {
+	v.clamp(v.mode != "")
+	var rows []string
+	n := v.lines()
+	for i := 0; i < v.rows-1; i++ {
+		y := v.top + i
+		if y > n && !(y == 1 && n == 0) {
+			rows = append(rows, "~")
+			continue
+		}
+		s := []rune(expand(v.line(y)))
+		if v.left < len(s) {
+			s = s[v.left:]
+		} else {
+			s = nil
+		}
+		if len(s) > v.cols {
+			s = s[:v.cols]
+		}
+		rows = append(rows, string(s))
+	}
+	return rows, v.cy - v.top, column(v.line(v.cy), v.cx) - v.left
+}
+
+// isWord returns whether r is part of a word, for w and b.
+func isWord(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// class returns the kind of r: space, word or other.
+func class(r rune) int {
+	switch {
+	case unicode.IsSpace(r):
+		return 0
+	case isWord(r):
+		return 1
+	}
+	return 2
+}
+
+// word moves to the start of the next word, or of this or the last one if
+// back is true.
+func (v *visual) word(back bool) {
+	l := v.line(v.cy)
+	if back {
+		if v.cx == 0 {
+			if v.cy > 1 {
+				v.cy--
+				v.cx = len(v.line(v.cy))
+			}
+			return
+		}
+		x := v.cx - 1
+		for x > 0 && class(l[x]) == 0 {
+			x--
+		}
+		for x > 0 && class(l[x-1]) == class(l[x]) {
+			x--
+		}
+		v.cx = x
+		return
+	}
+	x := v.cx
+	if x < len(l) {
+		c := class(l[x])
+		for x < len(l) && class(l[x]) == c {
+			x++
+		}
+	}
+	for x < len(l) && class(l[x]) == 0 {
+		x++
+	}
+	if x >= len(l) && v.cy < v.lines() {
+		v.cy, v.cx = v.cy+1, 0
+		return
+	}
+	v.cx = x
+}
+
+// textKey handles a key in text mode.
+func (v *visual) textKey(k rune) {
+	l := v.line(v.cy)
+	switch k {
+	case 'h', keyLeft, ctrl('H'), 127:
+		v.cx -= v.times()
+	case 'l', keyRight, ' ':
+		v.cx += v.times()
+	case 'j', keyDown, ctrl('N'), '+', '\r', '\n':
+		v.cy += v.times()
+	case 'k', keyUp, ctrl('P'), '-':
+		v.cy -= v.times()
+	case '0', keyHome:
+		v.cx = 0
+	case '^':
+		v.cx = 0
+		for v.cx < len(l) && unicode.IsSpace(l[v.cx]) {
+			v.cx++
+		}
+	case '$', keyEnd:
+		v.cx = len(l)
+	case 'w', 'b':
+		for i := 0; i < v.times(); i++ {
+			v.word(k == 'b')
+		}
+	case 'G':
+		v.cy = v.lines()
+		if v.count > 0 {
+			v.cy = v.count
+		}
+	case 'g':
+		if k, _ := v.key(); k == 'g' {
+			v.cy = v.times()
+		}
+	case ctrl('F'), keyPgDn:
+		v.cy += (v.rows - 2) * v.times()
+		v.top = v.cy
+	case ctrl('B'), keyPgUp:
+		v.cy -= (v.rows - 2) * v.times()
+		v.top -= (v.rows - 2) * v.times()
+		if v.top < 1 {
+			v.top = 1
+		}
+	case 'i':
+		v.change()
+		v.insert()
+	case 'a':
+		v.change()
+		if len(l) > 0 {
+			v.cx++
+		}
+		v.insert()
+	case 'I':
+		v.change()
+		v.textKey('^')
+		v.insert()
+	case 'A':
+		v.change()
+		v.cx = len(l)
+		v.insert()
+	case 'o', 'O':
+		v.change()
+		if k == 'o' && v.lines() > 0 {
+			v.cy++
+		}
+		v.replaceLines(v.cy, v.cy, []rune{})
+		v.cx = 0
+		v.insert()
+	case 'x', keyDel, 'X':
+		from, to := v.cx, v.cx+v.times()
+		if k == 'X' {
+			from, to = v.cx-v.times(), v.cx
+		}
+		if from < 0 {
+			from = 0
+		}
+		if to > len(l) {
+			to = len(l)
+		}
+		if from < to {
+			v.change()
+			v.setLine(append(l[:from:from], l[to:]...))
+			v.cx = from
+		}
+	case 'D', 'C':
+		v.change()
+		v.setLine(l[:v.cx])
+		if k == 'C' {
+			v.insert()
+		}
+	case 'd', 'y':
+		if k2, _ := v.key(); k2 != k {
+			return
+		}
+		v.yankLines(k == 'd')
+	case 'Y':
+		v.yankLines(false)
+	case 'p', 'P':
+		if len(v.yank) == 0 {
+			v.msg = "Nothing to put"
+			return
+		}
+		v.change()
+		at := v.cy
+		if k == 'p' && v.lines() > 0 {
+			at++
+		}
+		var ls [][]rune
+		for i := 0; i < v.times(); i++ {
+			for _, y := range v.yank {
+				ls = append(ls, []rune(y))
+			}
+		}
+		v.replaceLines(at, at, ls...)
+		v.cy, v.cx = at, 0
+	case 'J':
+		n := v.times()
+		if n < 2 {
+			n = 2
+		}
+		if v.cy+n-1 > v.lines() {
+			return
+		}
+		v.change()
+		j := l
+		for i := 1; i < n; i++ {
+			next := strings.TrimLeftFunc(string(v.line(v.cy+i)), unicode.IsSpace)
+			v.cx = len(j)
+			if next != "" {
+				j = append(j, []rune(" "+next)...)
+			}
+		}
+		v.replaceLines(v.cy, v.cy+n, j)
+	case 'r':
+		r, err := v.key()
+		if err != nil || r < ' ' || v.cx >= len(l) {
+			return
+		}
+		v.change()
+		l[v.cx] = r
+		v.setLine(l)
+	case 'u':
+		v.undoLast(&v.undo, &v.redo)
+	case ctrl('R'):
+		v.undoLast(&v.redo, &v.undo)
+	case '/', '?':
+		p, ok := v.prompt(string(k))
+		if !ok {
+			return
+		}
+		if p != "" {
+			v.pat = p
+		}
+		v.back = k == '?'
+		v.search(v.back)
+	case 'n':
+		v.search(v.back)
+	case 'N':
+		v.search(!v.back)
+	case ':':
+		if c, ok := v.prompt(":"); ok {
+			v.command(c)
+		}
+	case 'Z':
+		if k, _ := v.key(); k == 'Z' {
+			v.command("x")
+		}
+	case ctrl('L'):
+		v.redraw()
+	case ctrl('G'):
+		name := v.name
+		if name == "" {
+			name = "[No Name]"
+		}
+		m := ""
+		if v.ed.IsDirty() {
+			m = " [Modified]"
+		}
+		v.msg = fmt.Sprintf("%q%s %d lines", name, m, v.lines())
+	}
+}
+
+// yankLines yanks as many lines as the count, and deletes them if del is
+// true.
+func (v *visual) yankLines(del bool) {
+	n := v.lines()
+	if n == 0 {
+		return
+	}
+	end := v.cy + v.times()
+	if end > n+1 {
+		end = n + 1
+	}
+	v.yank = nil
+	for y := v.cy; y < end; y++ {
+		v.yank = append(v.yank, string(v.line(y)))
+	}
+	if del {
+		v.change()
+		v.replaceLines(v.cy, end)
+		v.msg = fmt.Sprintf("%d fewer lines", end-v.cy)
+	}
+}
+
+// search goes to the next match of the last pattern, or the one before.
+func (v *visual) search(back bool) {
+	if v.pat == "" {
+		v.msg = "No previous pattern"
+		return
+	}
+	re, err := regexp.Compile(v.pat)
+	if err != nil {
+		v.msg = err.Error()
+		return
+	}
+	n := v.lines()
+	// The line of the cursor is searched first after the cursor, and
+	// last, after wrapping around, before it.
+	for i := 0; i <= n && n > 0; i++ {
+		after := func(x int) bool {
+			switch {
+			case i == 0 && !back:
+				return x > v.cx
+			case i == 0 && back:
+				return x < v.cx
+			case i == n && !back:
+				return x <= v.cx
+			case i == n && back:
+				return x >= v.cx
+			}
+			return true
+		}
+		y := v.cy + i
+		if back {
+			y = v.cy - i
+		}
+		y = (y+n-1)%n + 1
+		l := string(v.line(y))
+		x := -1
+		for _, m := range re.FindAllStringIndex(l, -1) {
+			if c := len([]rune(l[:m[0]])); after(c) {
+				x = c
+				if !back {
+					break
+				}
+			}
+		}
+		if x > -1 {
+			if !back && v.cy+i > n || back && v.cy-i < 1 {
+				v.msg = "search wrapped"
+			}
+			v.cy, v.cx = y, x
+			return
+		}
+	}
+	v.msg = fmt.Sprintf("Pattern not found: %s", v.pat)
+}
+
+// insert inserts what is typed until ESC.
+func (v *visual) insert() {
+	v.mode = "-- INSERT --"
+	defer func() {
+		v.mode = ""
+		if v.cx > 0 {
+			v.cx--
+		}
+	}()
+	for {
+		v.draw()
+		k, err := v.key()
+		if err != nil {
+			return
+		}
+		l := v.line(v.cy)
+		switch k {
+		case keyEsc:
+			return
+		case '\r', '\n':
+			v.replaceLines(v.cy, v.cy+1, l[:v.cx], l[v.cx:])
+			v.cy, v.cx = v.cy+1, 0
+		case 127, ctrl('H'):
+			switch {
+			case v.cx > 0:
+				v.setLine(append(l[:v.cx-1:v.cx-1], l[v.cx:]...))
+				v.cx--
+			case v.cy > 1:
+				p := v.line(v.cy - 1)
+				v.replaceLines(v.cy-1, v.cy+1, append(p, l...))
+				v.cy, v.cx = v.cy-1, len(p)
+			}
+		case keyDel:
+			if v.cx < len(l) {
+				v.setLine(append(l[:v.cx:v.cx], l[v.cx+1:]...))
+			}
+		case keyLeft:
+			v.cx--
+		case keyRight:
+			v.cx++
+		case keyUp:
+			v.cy--
+		case keyDown:
+			v.cy++
+		case keyHome:
+			v.cx = 0
+		case keyEnd:
+			v.cx = len(l)
+		default:
+			if k == '\t' || k >= ' ' {
+				if v.cx > len(l) {
+					v.cx = len(l)
+				}
+				n := append(append(l[:v.cx:v.cx], k), l[v.cx:]...)
+				v.setLine(n)
+				v.cx++
+			}
+		}
+	}
+}
@@ -4,12 +4,25 @@
 
 // Forth is a forth interpreter.
 // It reads a line at a time and puts it through the interpreter.
+//
+// Synopsis:
+//     forth [-d] [FILE...]
+//
+// Description:
+//     With FILEs, forth runs each of them as a program and exits, so that
+//     it can be used for scripts such as
+//         : show ( addr -- ) dup hex . rl . cr ;
+//         0xfed40000 show
+//
+// Options:
+//     -d: dump the stack after each Eval
 package main
 
 import (
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 
@@ -22,6 +35,21 @@ func main() {
 	var b = make([]byte, 512)
 	flag.Parse()
 	f := forth.New()
+	if flag.NArg() > 0 {
+		for _, n := range flag.Args() {
+			b, err := ioutil.ReadFile(n)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := forth.Run(f, string(b)); err != nil {
+				log.Fatalf("%s: %v", n, err)
+			}
+			if *debug {
+				fmt.Printf("%v\n", f.Stack())
+			}
+		}
+		return
+	}
 	for {
 		n, err := os.Stdin.Read(b)
 		if err != nil {
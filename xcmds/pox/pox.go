@@ -5,9 +5,6 @@
 // pox builds a portable executable as a squashfs image.
 // It is intended to create files compatible with tinycore
 // tcz files.
-// This could have been a simple program but mksquashfs does not
-// preserve path information.
-// Yeah.
 //
 // Synopsis:
 //     pox [-d] -[output|o file]
@@ -20,11 +17,11 @@
 // Options:
 //     debug|d: verbose
 //     output|o file: output file name (default /tmp/pox.tcz)
-//     test|t: run a test by loopback mounting the squashfs and using the first arg as a command to run in a chroot
+//     test|t: run a test by unpacking the squashfs and using the first arg as a command to run in a chroot
 //
 // Example:
 //	pox -d -t /bin/bash /bin/cat /bin/ls /etc/hosts
-//	Will build and squashfs, mount it, and drop you into it running bash.
+//	Will build and squashfs, unpack it, and drop you into it running bash.
 //	You can use ls and cat on /etc/hosts.
 //	Simpler example:
 //	pox -d -t /bin/ls /etc/hosts
@@ -33,7 +30,6 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -42,8 +38,8 @@ import (
 
 	flag "github.com/spf13/pflag"
 	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/fs/squashfs"
 	"github.com/u-root/u-root/pkg/ldd"
-	"github.com/u-root/u-root/pkg/loop"
 )
 
 const usage = "pox [-d] [-f file] command..."
@@ -76,16 +72,17 @@ func pox() error {
 			v("%s", dep.FullName)
 			names = append(names, dep.FullName)
 		}
-		// Now we need to make a template file hierarchy and put
-		// the stuff we want in there.
-		dir, err := ioutil.TempDir("", "pox")
+		out, err := os.Create(*ofile)
 		if err != nil {
 			return err
 		}
-		if !*debug {
-			defer os.RemoveAll(dir)
+		defer out.Close()
+		w, err := squashfs.NewWriter(out, nil)
+		if err != nil {
+			return err
 		}
-		archiver := cpio.InMemArchive()
+		// The same library may be named more than once.
+		archiver := cpio.NewDedupWriter(w)
 		for _, f := range names {
 			v("Process %v", f)
 			rec, err := cpio.GetRecord(f)
@@ -96,27 +93,11 @@ func pox() error {
 				return err
 			}
 		}
-		v("%v", archiver)
-		rr := archiver.Reader()
-		for {
-			r, err := rr.ReadRecord()
-			v("%v %v", r, err)
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return err
-			}
-			if err := cpio.CreateFileInRoot(r, dir); err != nil {
-				return err
-			}
-
+		if err := w.Close(); err != nil {
+			return err
 		}
-		c := exec.Command("mksquashfs", dir, *ofile, "-noappend")
-		o, err := c.CombinedOutput()
-		v("%v", string(o))
-		if err != nil {
-			return fmt.Errorf("%v: %v: %v", c.Args, string(o), err)
+		if err := out.Close(); err != nil {
+			return err
 		}
 	}
 
@@ -130,13 +111,25 @@ func pox() error {
 	if !*debug {
 		defer os.RemoveAll(dir)
 	}
-	m, err := loop.New(*ofile, dir, "squashfs", 0, "")
+	f, err := os.Open(*ofile)
 	if err != nil {
 		return err
 	}
-	if err := m.Mount(); err != nil {
+	defer f.Close()
+	fsys, err := squashfs.New(f)
+	if err != nil {
+		return err
+	}
+	recs, err := fsys.Records()
+	if err != nil {
 		return err
 	}
+	for _, r := range recs {
+		v("%v", r)
+		if err := cpio.CreateFileInRoot(r, dir); err != nil {
+			return err
+		}
+	}
 	c := exec.Command(names[0])
 	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
 	c.SysProcAttr = &syscall.SysProcAttr{
@@ -147,10 +140,6 @@ func pox() error {
 	if err != nil {
 		log.Printf("Running test: %v", err)
 	}
-	if err := m.Unmount(0); err != nil {
-		v("Unmounting and freeing %v: %v", m, err)
-	}
-
 	v("Done, your pox is in %v", *ofile)
 	return err
 }
@@ -7,6 +7,7 @@ package main
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"syscall"
 	"testing"
 
@@ -27,6 +28,11 @@ func TestSimple(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer os.RemoveAll(tmpDir)
+	// The output can not be created under a regular file.
+	notDir := filepath.Join(tmpDir, "file")
+	if err := ioutil.WriteFile(notDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
 
 	var tests = []struct {
 		args   []string
@@ -48,11 +54,11 @@ func TestSimple(t *testing.T) {
 			skip:   uskip,
 		},
 		{
-			args:   []string{"-o", "/tmp/x/a/g/c/d/e/f/g", "/bin/bash"},
+			args:   []string{"-o", filepath.Join(notDir, "pox.tcz"), "/bin/bash"},
 			name:   "Bad output file",
 			status: 1,
-			out:    "/tmp/x/a/g/c/d/e/f/g -noappend]: Could not stat destination file: Not a directory\n: exit status 1\n",
-			skip:   uskip + len("[mksquashfs /tmp/pox373051153 "), // the tempname varies so skip it.
+			out:    "open " + filepath.Join(notDir, "pox.tcz") + ": not a directory\n",
+			skip:   uskip,
 		},
 		{
 			args:  []string{"-t", "/bin/bash"},